@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/girino/renoter/internal/config"
+	"github.com/girino/renoter/internal/storage"
+	"github.com/girino/renoter/pkg/client"
+)
+
+// checkFlags holds the flags check-config inspects.
+type checkFlags struct {
+	path, trusted, guards, serverRelays string
+	policy                              client.PathPolicy
+	opts                                client.Options
+	sizeBuckets                         string
+	nwc, cashuTokens, proxy, journal    string
+	journalKeep                         int
+	dial                                bool
+}
+
+// checkConfig validates the configuration without starting the client, prints a report and
+// returns whether every check passed.
+func checkConfig(ctx context.Context, flags checkFlags) bool {
+	var report config.Report
+	opts := flags.opts
+
+	buckets, err := config.ParseSizeBuckets(flags.sizeBuckets)
+	if err == nil {
+		opts.Limits.Buckets = buckets
+		err = opts.Limits.Validate()
+	}
+	report.Result("size limits", err, "%d byte containers, sizes %v", opts.Limits.StandardizedSize, opts.Limits.Sizes())
+	report.Result("options", opts.Validate(), "lane %s, %d mining workers", opts.Lane, opts.MiningWorkers)
+
+	renterPath, err := client.ValidatePath(splitList(flags.path))
+	report.Result("path", err, "%d Renoters", len(renterPath))
+	policy := flags.policy
+	policy.Trusted = make(map[string]bool)
+	if flags.trusted != "" {
+		trusted, err := client.ValidatePath(splitList(flags.trusted))
+		report.Result("trusted", err, "%d Renoters", len(trusted))
+		for _, node := range trusted {
+			policy.Trusted[node.Key()] = true
+		}
+	}
+	if flags.guards != "" {
+		guards, err := client.ValidatePath(splitList(flags.guards))
+		report.Result("guards", err, "%d Renoters", len(guards))
+		policy.Guards = make(map[string]bool)
+		for _, node := range guards {
+			policy.Guards[node.Key()] = true
+		}
+	}
+	if len(renterPath) > 0 {
+		report.Result("path policy", policy.Check(renterPath), "%d hops per event", policy.PathLength(len(renterPath)))
+
+		// The largest event the path carries, in the lane leaving the least room
+		hops := policy.PathLength(len(renterPath))
+		sizes := func(opts client.Options) (int, int, error) {
+			maxEvent, maxContent := opts.MaxEventSize(hops), opts.MaxContentSize(hops)
+			if maxContent == 0 {
+				return 0, 0, fmt.Errorf("a %d-hop path leaves no room for content in a %d byte container", hops, opts.Limits.StandardizedSize)
+			}
+			return maxEvent, maxContent, nil
+		}
+		jsonOpts := opts
+		jsonOpts.CompactLayers = false
+		maxEvent, maxContent, err := sizes(jsonOpts)
+		report.Result("event size", err, "events up to %d bytes, content up to %d bytes over %d hops", maxEvent, maxContent, hops)
+		if opts.CompactLayers {
+			maxEvent, maxContent, err := sizes(opts)
+			report.Result("event size (compact layers)", err, "events up to %d bytes, content up to %d bytes if every Renoter supports compact layers", maxEvent, maxContent)
+		}
+	}
+
+	// The proxy goes first so that dialing takes the route the client would
+	if flags.proxy != "" {
+		report.Result("proxy", client.SetProxy(flags.proxy), "relay connections go through %s", flags.proxy)
+	}
+	report.CheckRelays(ctx, "server relay", splitList(flags.serverRelays), flags.dial, 10*time.Second)
+	if flags.dial {
+		// What the relays announce they carry, in the same order as the list
+		var relayURLs []string
+		for _, relayURL := range splitList(flags.serverRelays) {
+			if config.ValidateRelayURL(relayURL) == nil {
+				relayURLs = append(relayURLs, relayURL)
+			}
+		}
+		nip11Ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		for _, result := range client.CheckServerRelays(nip11Ctx, relayURLs, opts) {
+			var err error
+			if !result.Compatible() {
+				err = errors.New(result.String())
+			}
+			report.Result("server relay "+result.URL+" NIP-11", err, "%s", result)
+		}
+		cancel()
+	}
+
+	if flags.nwc != "" {
+		_, err := client.NewNWCWallet(flags.nwc)
+		report.Result("nwc", err, "wallet connect URI parses")
+	}
+	if flags.cashuTokens != "" {
+		wallet, err := client.NewTokenFile(flags.cashuTokens)
+		var tokens int
+		var sats uint64
+		if err == nil {
+			tokens, sats, err = wallet.Balance()
+		}
+		report.Result("cashu tokens", err, "%d tokens worth %d sats", tokens, sats)
+	}
+	if flags.journal != "" {
+		entries := 0
+		err := storage.Scan(flags.journal, client.JournalStorage(storage.Options{Keep: flags.journalKeep}), time.Time{}, time.Time{}, func([]byte) { entries++ })
+		report.Result("journal", err, "%d entries readable", entries)
+	}
+
+	report.Print(os.Stdout)
+	return report.OK()
+}
+
+// splitList splits a comma-separated flag into its trimmed, non-empty entries.
+func splitList(s string) []string {
+	var entries []string
+	for _, entry := range strings.Split(s, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
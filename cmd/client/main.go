@@ -1,30 +1,121 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"github.com/girino/renoter/internal/config"
+	"github.com/girino/renoter/internal/keychain"
+	"github.com/girino/renoter/internal/secret"
+	"github.com/girino/renoter/internal/storage"
 	"github.com/girino/renoter/pkg/client"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/fiatjaf/khatru"
 	"github.com/girino/nostr-lib/logging"
+	"github.com/nbd-wtf/go-nostr/nip19"
 )
 
+// shutdownTimeout bounds how long shutting down waits for subscriptions to close and background
+// work to stop before exiting anyway.
+const shutdownTimeout = 5 * time.Second
+
 func main() {
 	// Initialize logging from environment variable
 	logging.SetVerbose(os.Getenv("VERBOSE"))
 
+	// "renoter-client check-config [flags]" validates the flags and exits instead of starting
+	checking := len(os.Args) > 1 && os.Args[1] == "check-config"
+	if checking {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
+
 	var (
 		listenAddr   = flag.String("listen", ":8080", "Address and port to listen on (e.g., :8080)")
-		path         = flag.String("path", "", "Comma-separated list of Renoter npubs (e.g., npub1...,npub2...)")
+		path         = flag.String("path", "", "Comma-separated list of Renoter npubs, nprofiles or hex pubkeys (e.g., npub1...,nprofile1...)")
+		hops         = flag.Int("hops", 0, "Renoters from -path each event is routed through, chosen at random per event (0 = all)")
+		trusted      = flag.String("trusted", "", "Comma-separated npubs from -path you trust; the others are treated as unknown")
+		minTrusted   = flag.Int("min-trusted", 0, "Trusted Renoters every event must pass through")
+		guardNpubs   = flag.String("guards", "", "Comma-separated npubs from -path every event enters through (overrides -guard-count)")
+		guardCount   = flag.Int("guard-count", 0, "Pick this many guards from -path once and keep every event's first hop among them (0 = no guards)")
+		guardFile    = flag.String("guard-file", "renoter-guards.txt", "File the picked guards are kept in across restarts")
+		diffRegions  = flag.Bool("distinct-regions", false, "Never route an event through two Renoters declaring the same region")
+		diffASNs     = flag.Bool("distinct-asns", false, "Never route an event through two Renoters declaring the same network provider (ASN)")
 		serverRelays = flag.String("server-relays", "", "Comma-separated relay URLs where wrapped events will be sent (e.g., wss://relay1.com,wss://relay2.com)")
 		configFile   = flag.String("config", "", "Path to config file (not implemented yet)")
 		verbose      = flag.String("verbose", "", "Verbose logging (true/all, or comma-separated module.method filters)")
+		standardSize = flag.Int("standardized-size", config.StandardizedSize, "Size in bytes every outermost 29000 is padded to (must match the Renoters)")
+		sizeBuckets  = flag.String("size-buckets", "", "Comma-separated smaller sizes in bytes the outermost 29000 is padded to when it fits (must match the Renoters)")
+		maxInnerSize = flag.Int("max-inner-size", 0, "Maximum outermost 29000 size in bytes before padding (0 = standardized size minus padding tag overhead)")
+		allowedKinds = flag.String("allowed-kinds", "", "Comma-separated event kinds routed through the Renoters; others are rejected (empty = all)")
+		neverRoute   = flag.String("never-route-kinds", "0,3", "Comma-separated event kinds refused because their content identifies the author anyway (empty = none)")
+		tagPolicy    = flag.String("tag-policy", "client=reject", "Comma-separated tag=warn|reject policies for tags that can identify you, e.g. client=warn,g=reject; a bare warn or reject covers the client, g and proxy tags (empty = off)")
+		transforms   = flag.String("transforms", "", "Comma-separated rewrites applied in order to events before they are checked and wrapped: strip-tag=<name>, expiration=<duration> and sign (with -sign-key), e.g. strip-tag=client,sign (empty = none)")
+		signKey      = flag.String("sign-key", "", "Your nsec or hex private key, used by the sign transform to sign rewritten events again; prefer -sign-key-file or $RENOTER_SIGN_KEY, which stay out of the process list")
+		signKeyFile  = flag.String("sign-key-file", "", "File holding the -sign-key")
+		signKeychain = flag.Bool("sign-keychain", false, "Keep the -sign-key in the OS keychain (macOS Keychain, Linux secret service, Windows DPAPI); the key given is stored there on first use")
+		maxEventAge  = flag.Duration("max-event-age", 0, "Reject events whose created_at is further in the past than this (0 = any age)")
+		maxFuture    = flag.Duration("max-event-future", client.DefaultOptions().Validation.MaxFuture, "Reject events whose created_at is further in the future than this (0 = any)")
+		miningWork   = flag.Int("mining-workers", client.DefaultOptions().MiningWorkers, "Number of background workers wrapping and mining accepted events")
+		miningQueue  = flag.Int("mining-queue", client.DefaultOptions().MiningQueueSize, "Maximum number of accepted events waiting for a mining worker")
+		spillDir     = flag.String("queue-spill", "", "Directory queued events are kept in, encrypted, until dispatched, so a restart does not lose them; events beyond -mining-queue are held there instead of rejected (empty = memory only)")
+		spillMax     = flag.Int("queue-spill-max", 1000, "Most events held in -queue-spill beyond -mining-queue before new ones are rejected")
+		storageKey   = flag.String("storage-key", "renoter-storage.key", "File holding the key events kept on disk are encrypted with, or its salt with -storage-passphrase; generated on first use")
+		storagePass  = flag.String("storage-passphrase", "", "Passphrase the storage key is derived from; prefer -storage-passphrase-file or $RENOTER_STORAGE_PASSPHRASE, which stay out of the process list (empty = -storage-key holds the key)")
+		storagePFile = flag.String("storage-passphrase-file", "", "File holding the -storage-passphrase, e.g. /dev/stdin")
+		storageChain = flag.Bool("storage-keychain", false, "Keep the storage key in the OS keychain (macOS Keychain, Linux secret service, Windows DPAPI) instead of -storage-key")
+		miningTime   = flag.Duration("mining-timeout", client.DefaultOptions().MiningTimeout, "Maximum time spent wrapping and mining a single event")
+		batchEvery   = flag.Duration("batch-interval", 0, "Publish wrapped events in shuffled batches at fixed wall-clock intervals, e.g. 2m (0 = immediately)")
+		hedgeRelays  = flag.Int("hedge-relays", 0, "Publish each container to at most this many random server relays, one at a time, instead of all of them (0 = all)")
+		hedgeDelay   = flag.Duration("hedge-delay", client.DefaultHedgeDelay, "Time to wait for a relay's OK before adding another one (-hedge-relays)")
+		relayReasons = flag.Bool("log-relay-reasons", false, "Log the messages server relays refuse containers with, not only their category (rate-limited, pow, invalid, ...)")
+		lane         = flag.String("lane", config.LaneFast, "Delivery lane of events whose renoter-lane tag or connection (?lane=) does not pick one: fast, or mixed to ask every Renoter for a random delay")
+		mixingDelay  = flag.Duration("mixing-delay", config.DefaultMixingDelay, "Mean delay requested from each Renoter for events in the mixed lane")
+		reportLat    = flag.Bool("report-latency", false, "Ask every Renoter for an encrypted timing trailer to show where latency accumulates")
+		resendAfter  = flag.Duration("resend-timeout", 0, "Resend an event over a new path if it has not appeared on the server relays this long after dispatch (0 = never)")
+		maxResends   = flag.Int("max-resends", client.DefaultOptions().MaxResends, "Resends per event before giving up")
+		powService   = flag.String("pow-service", "", "URL of a remote PoW mining service (e.g., http://miner:8090/mine); mines locally if empty")
+		containerPoW = flag.Int("container-pow", 0, "PoW difficulty mined on outer 29001 containers for relays that require it (0 = none)")
+		relayCheck   = flag.String("relay-check", client.RelayCheckWarn, "Check the server relays' NIP-11 documents at startup for a max_message_length or max_content_length too small for containers, or no ephemeral events: off, warn or strict (refuse to start)")
+		detectPoW    = flag.Bool("detect-container-pow", true, "Raise -container-pow to the min_pow_difficulty advertised in the server relays' NIP-11")
+		checkDesc    = flag.Bool("check-descriptors", true, "Check the Renoters' service descriptors for compatibility before using the path")
+		healthEvery  = flag.Duration("health-interval", 0, "Refetch the Renoters' service descriptors at this interval and route around offline Renoters (0 = never)")
+		requireDesc  = flag.Bool("require-descriptors", false, "Refuse Renoters that have not published a service descriptor")
+		premine      = flag.Int("premine", 0, "Stamps (PoW on a layer skeleton) mined ahead per Renoter while idle, for Renoters announcing protocol version 3, so fast-lane events need less mining (0 = mine when wrapping; needs -check-descriptors or -handshake)")
+		liteProfile  = flag.Bool("lite", false, "Send small events in 4KB lite containers with lower PoW when every Renoter on the path supports it, saving bandwidth on metered connections (needs -check-descriptors or -handshake)")
+		compactLayer = flag.Bool("compact-layers", false, "Encode the event inside every layer compactly instead of as JSON when every Renoter supports it, fitting larger events (needs -check-descriptors or -handshake)")
+		handshake    = flag.Bool("handshake", false, "Probe every Renoter for its capabilities before sending traffic and check its answer")
+		nwcURI       = flag.String("nwc", "", "Nostr Wallet Connect URI (nostr+walletconnect://...) used to pay paid Renoters")
+		cashuTokens  = flag.String("cashu-tokens", "", "File of cashuA tokens, one per line, spent instead of PoW on Renoters that admit Cashu")
+		maxConns     = flag.Int("max-connections", client.DefaultOptions().Pool.MaxConnections, "Maximum number of server relays connected at once (0 = unlimited)")
+		idleTimeout  = flag.Duration("idle-timeout", client.DefaultOptions().Pool.IdleTimeout, "Close server relay connections unused for this long (0 = never)")
+		connRate     = flag.Int("connection-rate", 0, "Events per minute each local app connection may submit (0 = unlimited)")
+		connPending  = flag.Int("connection-max-pending", 0, "Events of each local app connection that may be queued or mining at once (0 = unlimited)")
+		journalFile  = flag.String("journal", "renoter-journal.jsonl", "Append-only JSONL journal of dispatched events, or sqlite:<file> for an SQLite database (empty = disabled)")
+		journalSize  = flag.Int64("journal-max-size", 10*1024*1024, "Rotate the journal when it would exceed this many bytes (0 = never)")
+		journalKeep  = flag.Int("journal-keep", 3, "Rotated journal files kept")
+		journalSync  = flag.Bool("journal-sync", false, "Flush every journal entry to disk before going on")
+		journalAge   = flag.Duration("journal-retention", 0, "Drop journal entries older than this (0 = keep until rotated out)")
+		lookupEvent  = flag.String("lookup", "", "Print the journal entries of this event ID and exit")
+		proxyURL     = flag.String("proxy", "", "Proxy all outgoing connections go through, e.g. socks5://127.0.0.1:9050 for Tor (empty = direct or HTTPS_PROXY/ALL_PROXY)")
+		checkMode    = flag.String("transport-check", "off", "Check at startup that relay traffic does not leave from the client's own IP: off, warn or strict (refuse to start)")
+		checkerURL   = flag.String("transport-checker", client.DefaultTransportChecker, "Service answering with the caller's IP (plain text or JSON), used by -transport-check")
+		stdinMode    = flag.Bool("stdin", false, "Publish signed events read as JSON lines from stdin (e.g. from nak), print one JSON result per event and exit")
+		selfTest     = flag.Bool("selftest", false, "Send a probe note through the configured path, report per-hop timing and exit (1 if it was not delivered)")
+		selfTestWait = flag.Duration("selftest-timeout", 2*time.Minute, "How long -selftest waits for the probe to be delivered")
+		statusSocket = flag.String("status-socket", "", "Serve state changes as JSON lines on this unix socket path or loopback host:port, for tray apps (empty = disabled)")
+		checkDial    = flag.Bool("dial", false, "With check-config, also connect to every server relay")
+		publishToken = flag.String("publish-token", "", "Bearer token enabling POST /publish for submitting events over HTTP; prefer -publish-token-file or $RENOTER_PUBLISH_TOKEN, which stay out of the process list (empty = disabled)")
+		publishFile  = flag.String("publish-token-file", "", "File holding the -publish-token")
 	)
 	flag.Parse()
 
@@ -33,6 +124,81 @@ func main() {
 		logging.SetVerbose(*verbose)
 	}
 
+	if checking {
+		opts := client.DefaultOptions()
+		opts.Limits.StandardizedSize = *standardSize
+		opts.Limits.MaxInnerEventSize = *maxInnerSize
+		if opts.Limits.MaxInnerEventSize == 0 {
+			opts.Limits.MaxInnerEventSize = *standardSize - config.PaddingTagOverhead
+		}
+		opts.MiningWorkers = *miningWork
+		opts.MiningQueueSize = *miningQueue
+		opts.MiningTimeout = *miningTime
+		opts.Lane = *lane
+		opts.MixingDelay = *mixingDelay
+		opts.ReportLatency = *reportLat
+		opts.CompactLayers = *compactLayer
+		opts.LiteProfile = *liteProfile
+		opts.Premine = *premine
+		opts.CheckDescriptors = *checkDesc
+		opts.Handshake = *handshake
+		opts.MaxResends = *maxResends
+		opts.ResendTimeout = *resendAfter
+		opts.RelayCheck = *relayCheck
+		ok := checkConfig(context.Background(), checkFlags{
+			path:         *path,
+			trusted:      *trusted,
+			guards:       *guardNpubs,
+			serverRelays: *serverRelays,
+			policy:       client.PathPolicy{Hops: *hops, MinTrusted: *minTrusted, DistinctRegions: *diffRegions, DistinctASNs: *diffASNs},
+			opts:         opts,
+			sizeBuckets:  *sizeBuckets,
+			nwc:          *nwcURI,
+			cashuTokens:  *cashuTokens,
+			proxy:        *proxyURL,
+			journal:      *journalFile,
+			journalKeep:  *journalKeep,
+			dial:         *checkDial,
+		})
+		if !ok {
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Answer journal lookups without starting the relay
+	if *lookupEvent != "" {
+		if *journalFile == "" {
+			log.Fatal("Error: -lookup needs a -journal")
+		}
+		entries, err := client.LookupJournal(*journalFile, *lookupEvent, *journalKeep)
+		if err != nil {
+			log.Fatalf("Error: failed to read journal: %v", err)
+		}
+		if len(entries) == 0 {
+			fmt.Printf("Event %s is not in the journal\n", *lookupEvent)
+			os.Exit(1)
+		}
+		for _, entry := range entries {
+			status := "dispatched"
+			if entry.Attempt > 0 {
+				status = fmt.Sprintf("resent (attempt %d)", entry.Attempt)
+			}
+			if !entry.Dispatched() {
+				status = "not dispatched: " + entry.Error
+			}
+			fmt.Printf("%s %s as %s over %d hops (path %s, submitted %s)\n", entry.FinishedAt.Format(time.RFC3339), status, entry.WrappedID, entry.Hops, entry.PathHash[:16], entry.SubmittedAt.Format(time.RFC3339))
+			for _, result := range entry.Results {
+				if result.OK {
+					fmt.Printf("  %s: accepted\n", result.Relay)
+				} else {
+					fmt.Printf("  %s: %s\n", result.Relay, result.Error)
+				}
+			}
+		}
+		return
+	}
+
 	if *path == "" {
 		log.Fatal("Error: -path is required (comma-separated npubs)")
 	}
@@ -45,6 +211,172 @@ func main() {
 		log.Println("Warning: -config flag is not yet implemented, ignoring")
 	}
 
+	// Validate size limits and mining options before doing anything else
+	opts := client.DefaultOptions()
+	opts.Limits.StandardizedSize = *standardSize
+	opts.Limits.MaxInnerEventSize = *maxInnerSize
+	if opts.Limits.MaxInnerEventSize == 0 {
+		opts.Limits.MaxInnerEventSize = *standardSize - config.PaddingTagOverhead
+	}
+	buckets, err := config.ParseSizeBuckets(*sizeBuckets)
+	if err != nil {
+		log.Fatalf("Error: invalid -size-buckets: %v", err)
+	}
+	opts.Limits.Buckets = buckets
+	opts.Validation.MaxAge = *maxEventAge
+	opts.Validation.MaxFuture = *maxFuture
+	if *allowedKinds != "" {
+		opts.Validation.AllowedKinds = make(map[int]bool)
+		for _, field := range strings.Split(*allowedKinds, ",") {
+			kind, err := strconv.Atoi(strings.TrimSpace(field))
+			if err != nil {
+				log.Fatalf("Error: invalid -allowed-kinds entry %q", field)
+			}
+			opts.Validation.AllowedKinds[kind] = true
+		}
+	}
+	opts.Validation.NeverRouted = make(map[int]bool)
+	for _, field := range strings.Split(*neverRoute, ",") {
+		if strings.TrimSpace(field) == "" {
+			continue
+		}
+		kind, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			log.Fatalf("Error: invalid -never-route-kinds entry %q", field)
+		}
+		opts.Validation.NeverRouted[kind] = true
+	}
+	tagPolicies, err := client.ParseTagPolicies(*tagPolicy)
+	if err != nil {
+		log.Fatalf("Error: invalid -tag-policy: %v", err)
+	}
+	opts.Validation.TagPolicies = tagPolicies
+	sk, err := secret.Lookup(*signKey, *signKeyFile, "RENOTER_SIGN_KEY")
+	if err != nil {
+		log.Fatalf("Error: invalid -sign-key: %v", err)
+	}
+	if *signKeychain {
+		stored, err := keychain.Get("sign")
+		switch {
+		case err == nil && sk != "" && sk != stored:
+			log.Fatal("Error: -sign-key differs from the key in the OS keychain")
+		case err == nil:
+			sk = stored
+			log.Println("Using the signing key from the OS keychain")
+		case !errors.Is(err, keychain.ErrNotFound):
+			log.Fatalf("Error: failed to read the OS keychain: %v", err)
+		case sk == "":
+			log.Fatal("Error: the OS keychain holds no signing key yet; give it once with -sign-key-file or RENOTER_SIGN_KEY")
+		default:
+			if _, err := client.ParseTransforms("sign", sk); err != nil {
+				log.Fatalf("Error: invalid -sign-key: %v", err)
+			}
+			if err := keychain.Set("sign", sk); err != nil {
+				log.Fatalf("Error: %v", err)
+			}
+			log.Println("Stored the signing key in the OS keychain; -sign-key is no longer needed")
+		}
+	}
+	opts.Transforms, err = client.ParseTransforms(*transforms, sk)
+	if err != nil {
+		log.Fatalf("Error: invalid -transforms: %v", err)
+	}
+	opts.MiningWorkers = *miningWork
+	opts.MiningQueueSize = *miningQueue
+	opts.MiningTimeout = *miningTime
+	opts.BatchInterval = *batchEvery
+	opts.Hedge = client.HedgePolicy{Relays: *hedgeRelays, Delay: *hedgeDelay}
+	opts.LogRelayReasons = *relayReasons
+	opts.ResendTimeout = *resendAfter
+	opts.Lane = *lane
+	opts.MixingDelay = *mixingDelay
+	opts.ReportLatency = *reportLat
+	opts.MaxResends = *maxResends
+	opts.Stats = client.NewStats()
+	connections, err := client.NewConnections(*connRate, *connPending)
+	if err != nil {
+		log.Fatalf("Error: invalid connection limits: %v", err)
+	}
+	opts.Connections = connections
+	if *journalFile != "" {
+		store, err := storage.Open(*journalFile, client.JournalStorage(storage.Options{
+			MaxBytes:  *journalSize,
+			Keep:      *journalKeep,
+			Sync:      *journalSync,
+			Retention: *journalAge,
+		}))
+		if err != nil {
+			log.Fatalf("Error: invalid -journal: %v", err)
+		}
+		journal := client.NewJournalWithStorage(store)
+		log.Printf("Recording dispatched events in %s", *journalFile)
+		defer journal.Close()
+		opts.Journal = journal
+	}
+	token, err := secret.Lookup(*publishToken, *publishFile, "RENOTER_PUBLISH_TOKEN")
+	if err != nil {
+		log.Fatalf("Error: invalid -publish-token: %v", err)
+	}
+	if token != "" {
+		publishAPI, err := client.NewPublishAPI(token)
+		if err != nil {
+			log.Fatalf("Error: invalid -publish-token: %v", err)
+		}
+		opts.PublishAPI = publishAPI
+	}
+	if *statusSocket != "" {
+		opts.Status = client.NewStatusSocket()
+	}
+	if *spillDir != "" {
+		passphrase, err := secret.Lookup(*storagePass, *storagePFile, "RENOTER_STORAGE_PASSPHRASE")
+		if err != nil {
+			log.Fatalf("Error: invalid -storage-passphrase: %v", err)
+		}
+		key, err := client.LoadStorageKey(client.StorageKey{File: *storageKey, Passphrase: passphrase, Keychain: *storageChain})
+		if err != nil {
+			log.Fatalf("Error: invalid storage key: %v", err)
+		}
+		spill, err := client.OpenQueueSpill(*spillDir, key, *spillMax)
+		if err != nil {
+			log.Fatalf("Error: invalid -queue-spill: %v", err)
+		}
+		log.Printf("Keeping queued events in %s", *spillDir)
+		opts.QueueSpill = spill
+	}
+	opts.ContainerPoWDifficulty = *containerPoW
+	opts.DetectContainerPoW = *detectPoW
+	opts.RelayCheck = *relayCheck
+	opts.CheckDescriptors = *checkDesc
+	opts.HealthInterval = *healthEvery
+	opts.Handshake = *handshake
+	opts.CompactLayers = *compactLayer
+	opts.LiteProfile = *liteProfile
+	opts.Premine = *premine
+	opts.RequireDescriptors = *requireDesc
+	if *nwcURI != "" {
+		wallet, err := client.NewNWCWallet(*nwcURI)
+		if err != nil {
+			log.Fatalf("Error: invalid -nwc: %v", err)
+		}
+		opts.Payer = client.NewLightningPayer(wallet)
+	}
+	if *cashuTokens != "" {
+		wallet, err := client.NewTokenFile(*cashuTokens)
+		if err != nil {
+			log.Fatalf("Error: invalid -cashu-tokens: %v", err)
+		}
+		opts.Cashu = wallet
+	}
+	opts.Pool.MaxConnections = *maxConns
+	opts.Pool.IdleTimeout = *idleTimeout
+	if *powService != "" {
+		opts.Miner = client.NewHTTPMiner(*powService)
+		log.Printf("Delegating PoW mining to %s", *powService)
+	}
+	if err := opts.Validate(); err != nil {
+		log.Fatalf("Error: invalid options: %v", err)
+	}
+
 	// Parse Renoter path
 	npubs := strings.Split(*path, ",")
 	for i := range npubs {
@@ -59,6 +391,45 @@ func main() {
 
 	log.Printf("Validated Renoter path with %d nodes", len(renterPath))
 
+	// Draw each event's path from the configured Renoters under the trust policy
+	opts.PathPolicy = client.PathPolicy{Hops: *hops, MinTrusted: *minTrusted, Trusted: make(map[string]bool), DistinctRegions: *diffRegions, DistinctASNs: *diffASNs}
+	if *trusted != "" {
+		trustedNpubs := strings.Split(*trusted, ",")
+		for i := range trustedNpubs {
+			trustedNpubs[i] = strings.TrimSpace(trustedNpubs[i])
+		}
+		trustedKeys, err := client.ValidatePath(trustedNpubs)
+		if err != nil {
+			log.Fatalf("Error: invalid -trusted: %v", err)
+		}
+		for _, node := range trustedKeys {
+			opts.PathPolicy.Trusted[node.Key()] = true
+		}
+	}
+	if *guardNpubs != "" {
+		guardList := strings.Split(*guardNpubs, ",")
+		for i := range guardList {
+			guardList[i] = strings.TrimSpace(guardList[i])
+		}
+		guardKeys, err := client.ValidatePath(guardList)
+		if err != nil {
+			log.Fatalf("Error: invalid -guards: %v", err)
+		}
+		opts.PathPolicy.Guards = make(map[string]bool)
+		for _, node := range guardKeys {
+			opts.PathPolicy.Guards[node.Key()] = true
+		}
+	} else if *guardCount > 0 {
+		opts.PathPolicy.Guards, err = client.LoadGuards(*guardFile, renterPath, *guardCount, opts.PathPolicy.Trusted)
+		if err != nil {
+			log.Fatalf("Error: failed to load guards: %v", err)
+		}
+		log.Printf("Entering through %d guards kept in %s", len(opts.PathPolicy.Guards), *guardFile)
+	}
+	if err := opts.PathPolicy.Check(renterPath); err != nil {
+		log.Fatalf("Error: cannot build paths: %v", err)
+	}
+
 	// Parse server relay URLs
 	serverRelayList := strings.Split(*serverRelays, ",")
 	for i := range serverRelayList {
@@ -70,14 +441,97 @@ func main() {
 
 	log.Printf("Using %d server relays: %v", len(serverRelayList), serverRelayList)
 
+	// Route relay traffic through the proxy before anything connects, then verify it
+	if *proxyURL != "" {
+		if err := client.SetProxy(*proxyURL); err != nil {
+			log.Fatalf("Error: invalid -proxy: %v", err)
+		}
+	}
+	switch *checkMode {
+	case "off":
+	case "warn", "strict":
+		direct := &http.Client{Transport: &http.Transport{Proxy: nil}}
+		exitIP, err := client.CheckTransport(context.Background(), *checkerURL, http.DefaultClient, direct)
+		switch {
+		case err != nil && *checkMode == "strict":
+			log.Fatalf("Error: transport check failed: %v", err)
+		case err != nil:
+			log.Printf("Warning: transport check failed, relays may see your IP: %v", err)
+		default:
+			log.Printf("Transport check passed: relays see exit IP %s", exitIP)
+		}
+	default:
+		log.Fatalf("Error: invalid -transport-check %q (use off, warn or strict)", *checkMode)
+	}
+
+	// Publish the events piped in without starting the local relay
+	if *stdinMode {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		dispatcher, err := client.StartDispatcher(ctx, renterPath, serverRelayList, opts)
+		if err != nil {
+			log.Fatalf("Error: failed to start dispatcher: %v", err)
+		}
+		failed, err := client.PublishStream(ctx, dispatcher, os.Stdin, os.Stdout)
+		stop()
+		closeDispatcher(dispatcher)
+		if opts.Journal != nil {
+			opts.Journal.Close()
+		}
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		if failed > 0 {
+			log.Printf("%d events were not dispatched", failed)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// Send a probe through the path and report how far it got
+	if *selfTest {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		ctx, cancel := context.WithTimeout(ctx, *selfTestWait)
+		dispatcher, err := client.StartDispatcher(ctx, renterPath, serverRelayList, opts)
+		if err != nil {
+			log.Fatalf("Error: failed to start dispatcher: %v", err)
+		}
+		result, err := dispatcher.SelfTest(ctx)
+		cancel()
+		stop()
+		closeDispatcher(dispatcher)
+		if err != nil {
+			log.Fatalf("Error: self-test failed: %v", err)
+		}
+		fmt.Println(result)
+		if !result.Delivered {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	// Create khatru relay
 	relay := khatru.NewRelay()
 
 	// Setup relay to intercept and wrap events
-	err = client.SetupRelay(relay, renterPath, serverRelayList)
+	dispatcher, err := client.StartDispatcher(context.Background(), renterPath, serverRelayList, opts)
 	if err != nil {
 		log.Fatalf("Error: failed to setup relay: %v", err)
 	}
+	client.AttachDispatcher(relay, dispatcher)
+
+	// Serve state changes to tray apps
+	if opts.Status != nil {
+		listener, err := client.ListenStatus(*statusSocket)
+		if err != nil {
+			log.Fatalf("Error: invalid -status-socket: %v", err)
+		}
+		go func() {
+			if err := opts.Status.Serve(context.Background(), listener); err != nil {
+				log.Printf("Warning: status socket stopped: %v", err)
+			}
+		}()
+		log.Printf("Serving status on %s", *statusSocket)
+	}
 
 	// Setup HTTP handlers on router
 	mux := relay.Router()
@@ -85,7 +539,46 @@ func main() {
 		w.Header().Set("Content-Type", "text/plain")
 		fmt.Fprintf(w, "Nostr Renoter Client\n\n")
 		fmt.Fprintf(w, "Connect your Nostr client to ws://%s\n", *listenAddr)
+
+		stats := opts.Stats.Snapshot()
+		fmt.Fprintf(w, "\nDispatched: %d events (%d failed), average %.1f hops\n", stats.Dispatched, stats.Failed, stats.AverageHops)
+		fmt.Fprintf(w, "Sizes: %d bytes submitted, %d padded, %d encrypted\n", stats.PlaintextBytes, stats.PaddedBytes, stats.EncryptedBytes)
+		fmt.Fprintf(w, "Overhead: padding x%.1f, encryption x%.1f, bandwidth x%.1f (%d bytes sent to relays)\n", stats.PaddingOverhead, stats.EncryptionOverhead, stats.BandwidthOverhead, stats.PublishedBytes)
+		for renoter, latency := range stats.Latency {
+			npub, _ := nip19.EncodePublicKey(renoter)
+			fmt.Fprintf(w, "Latency of %s: transit %.0fms, held %.0fms, processing %.0fms (%d reports)\n", npub, latency.TransitMs, latency.HeldMs, latency.ProcessingMs, latency.Reports)
+		}
+
+		if relays := dispatcher.RelayCompatibility(); relays != nil {
+			fmt.Fprintf(w, "\nServer relays (NIP-11):\n")
+			for _, relay := range relays {
+				status := "ok"
+				if !relay.Compatible() {
+					status = "INCOMPATIBLE"
+				}
+				fmt.Fprintf(w, "  %s %s: %s\n", status, relay.URL, relay)
+			}
+		}
+
+		conns := opts.Connections.Snapshot()
+		fmt.Fprintf(w, "\nConnections: %d open\n", len(conns))
+		for _, conn := range conns {
+			fmt.Fprintf(w, "  #%d %s since %s: %d submitted (%d bytes), %d wrapped, %d rejected, %d failed, %d pending\n",
+				conn.ID, conn.RemoteAddr, conn.ConnectedAt.Format(time.RFC3339), conn.Submitted, conn.Bytes, conn.Wrapped, conn.Rejected, conn.Failed, conn.Pending)
+		}
+		fmt.Fprintf(w, "\nJSON stats: /stats, /connections, /relays\n")
+	})
+	mux.Handle("/stats", opts.Stats)
+	mux.Handle("/connections", opts.Connections)
+	mux.HandleFunc("/relays", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(dispatcher.RelayCompatibility())
 	})
+	if opts.PublishAPI != nil {
+		mux.Handle("/publish", opts.PublishAPI)
+		mux.Handle("/jobs", opts.PublishAPI.Jobs())
+		mux.Handle("/jobs/", opts.PublishAPI.Jobs())
+	}
 
 	// Setup graceful shutdown
 	sigChan := make(chan os.Signal, 1)
@@ -94,6 +587,7 @@ func main() {
 	go func() {
 		<-sigChan
 		log.Println("Shutting down...")
+		closeDispatcher(dispatcher)
 		os.Exit(0)
 	}()
 
@@ -123,3 +617,13 @@ func main() {
 		log.Fatalf("Error: failed to start server: %v", err)
 	}
 }
+
+// closeDispatcher stops dispatcher and closes its relay connections, waiting at most
+// shutdownTimeout.
+func closeDispatcher(dispatcher *client.Dispatcher) {
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := dispatcher.Close(ctx); err != nil {
+		log.Printf("Warning: unclean shutdown: %v", err)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/girino/renoter/internal/config"
+	"github.com/girino/renoter/pkg/client"
+)
+
+func main() {
+	// Initialize logging from environment variable
+	logging.SetVerbose(os.Getenv("VERBOSE"))
+
+	var (
+		listenAddr    = flag.String("listen", ":8090", "Address and port to listen on (e.g., :8090)")
+		maxDifficulty = flag.Int("max-difficulty", config.PoWDifficulty+8, "Highest PoW difficulty the service agrees to mine")
+		verbose       = flag.String("verbose", "", "Verbose logging (true/all, or comma-separated module.method filters)")
+	)
+	flag.Parse()
+
+	// Override with flag if provided
+	if *verbose != "" {
+		logging.SetVerbose(*verbose)
+	}
+
+	if *maxDifficulty < 1 {
+		log.Fatalf("Error: -max-difficulty must be at least 1, got %d", *maxDifficulty)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/mine", client.NewPoWHandler(client.CPUMiner{}, *maxDifficulty))
+
+	log.Printf("Starting PoW mining service on %s (max difficulty %d)", *listenAddr, *maxDifficulty)
+	log.Printf("Point clients at http://<host>%s/mine with -pow-service", *listenAddr)
+
+	if err := http.ListenAndServe(*listenAddr, mux); err != nil {
+		log.Fatalf("Error: failed to start server: %v", err)
+	}
+}
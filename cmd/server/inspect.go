@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/girino/renoter/internal/config"
+	"github.com/girino/renoter/internal/keychain"
+	"github.com/girino/renoter/pkg/server"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// inspectFlags holds the flags inspect uses.
+type inspectFlags struct {
+	privateKey  string
+	useKeychain bool
+	signer      string
+	signerToken string
+	sizeFlag    int
+	bucketFlag  string
+}
+
+// inspect decrypts the 29001 container in the JSON file at path with the server key, or the
+// signer service, and writes a redacted summary to w. It returns why decoding stopped, if it did.
+func inspect(w io.Writer, path string, flags inspectFlags) error {
+	var identity server.Identity
+	if flags.signer != "" {
+		remote, err := server.NewRemoteIdentity(context.Background(), flags.signer, flags.signerToken)
+		if err != nil {
+			return err
+		}
+		identity = remote
+	} else {
+		sk := flags.privateKey
+		if sk == "" && flags.useKeychain {
+			stored, err := keychain.Get("server")
+			if err != nil {
+				return fmt.Errorf("failed to read the OS keychain: %w", err)
+			}
+			sk = stored
+		}
+		if sk == "" {
+			return fmt.Errorf("inspect needs the server key, with -private-key, -keychain or -signer")
+		}
+		key, err := server.NewKeyIdentity(sk)
+		if err != nil {
+			return err
+		}
+		identity = key
+	}
+
+	limits := config.SizeLimits{
+		StandardizedSize:  flags.sizeFlag,
+		MaxInnerEventSize: flags.sizeFlag - config.PaddingTagOverhead,
+	}
+	buckets, err := config.ParseSizeBuckets(flags.bucketFlag)
+	if err != nil {
+		return fmt.Errorf("invalid -size-buckets: %w", err)
+	}
+	limits.Buckets = buckets
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var container nostr.Event
+	if err := json.Unmarshal(data, &container); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+
+	inspection, err := server.InspectWithIdentity(identity, limits, &container)
+	if inspection == nil {
+		return err
+	}
+	fmt.Fprintf(w, "Container:      %s\n", inspection.ContainerID)
+	fmt.Fprintf(w, "Created at:     %s\n", inspection.CreatedAt.Time().UTC().Format("2006-01-02T15:04:05Z"))
+	fmt.Fprintf(w, "Size:           %d bytes\n", inspection.ContainerSize)
+	fmt.Fprintf(w, "PoW:            %d bits\n", inspection.ContainerPoW)
+	fmt.Fprintf(w, "Signature:      %s\n", validity(inspection.ValidSignature))
+	if inspection.AddressedTo != "" {
+		whose := "another Renoter"
+		if inspection.ForUs {
+			whose = "this Renoter"
+		}
+		fmt.Fprintf(w, "Addressed to:   %s (%s)\n", inspection.AddressedTo, whose)
+	}
+	if inspection.Opened {
+		fmt.Fprintf(w, "Layer bucket:   %d bytes\n", inspection.Bucket)
+		fmt.Fprintf(w, "Layer for:      %s\n", inspection.LayerAddressedTo)
+		stamped := ""
+		if inspection.Stamped {
+			stamped = " (stamp)"
+		}
+		fmt.Fprintf(w, "Layer PoW:      %d bits%s\n", inspection.LayerPoW, stamped)
+		fmt.Fprintf(w, "Layer tags:     %s\n", strings.Join(inspection.LayerTags, ", "))
+	}
+	if inspection.Decrypted {
+		fmt.Fprintf(w, "Inner kind:     %d\n", inspection.InnerKind)
+		next := "no, published as the final event"
+		if inspection.NextHop {
+			next = "yes, forwarded as another 29000"
+		}
+		fmt.Fprintf(w, "Next hop:       %s\n", next)
+	}
+	return err
+}
+
+// validity describes a check result.
+func validity(ok bool) string {
+	if ok {
+		return "valid"
+	}
+	return "invalid"
+}
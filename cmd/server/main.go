@@ -2,27 +2,135 @@ package main
 
 import (
 	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"github.com/girino/nostr-lib/logging"
+	"github.com/girino/renoter/internal/config"
+	"github.com/girino/renoter/internal/descriptor"
+	"github.com/girino/renoter/internal/keychain"
+	"github.com/girino/renoter/internal/relaypool"
+	"github.com/girino/renoter/internal/secret"
+	"github.com/girino/renoter/internal/storage"
 	"github.com/girino/renoter/pkg/server"
 	"github.com/nbd-wtf/go-nostr"
 	"github.com/nbd-wtf/go-nostr/nip19"
 	"log"
+	"maps"
+	"net/http"
 	"os"
 	"os/signal"
+	"slices"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 )
 
+// shutdownTimeout bounds how long shutting down waits for subscriptions to close and background
+// work to stop before exiting anyway.
+const shutdownTimeout = 5 * time.Second
+
 func main() {
 	// Initialize logging from environment variable
 	logging.SetVerbose(os.Getenv("VERBOSE"))
 
+	// "renoter-server check-config [flags]" validates the flags and exits instead of starting
+	checking := len(os.Args) > 1 && os.Args[1] == "check-config"
+	if checking {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
+	// "renoter-server inspect [flags] <event.json>" decodes one container offline and exits
+	inspecting := len(os.Args) > 1 && os.Args[1] == "inspect"
+	if inspecting {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
+
 	var (
-		privateKey = flag.String("private-key", "", "Private key in hex format (or leave empty to generate new)")
-		relays     = flag.String("relays", "", "Comma-separated relay URLs for listening and forwarding (e.g., wss://relay1.com,wss://relay2.com)")
-		configFile = flag.String("config", "", "Path to config file (not implemented yet)")
-		verbose    = flag.String("verbose", "", "Verbose logging (true/all, or comma-separated module.method filters)")
+		privateKey  = flag.String("private-key", "", "Private key in hex format (or leave empty to generate new)")
+		signer      = flag.String("signer", "", "Signer service holding the private key, e.g. in front of an HSM: an http(s) URL or unix:<socket path> (instead of -private-key or -keychain)")
+		signerToken = flag.String("signer-token", "", "Bearer token of the signer service; prefer -signer-token-file or $RENOTER_SIGNER_TOKEN, which stay out of the process list")
+		signerFile  = flag.String("signer-token-file", "", "File holding the -signer-token")
+		useKeychain = flag.Bool("keychain", false, "Keep the private key in the OS keychain (macOS Keychain, Linux secret service, Windows DPAPI); -private-key or a new key is stored there on first use")
+		relays      = flag.String("relays", "", "Comma-separated relay URLs for listening and forwarding (e.g., wss://relay1.com,wss://relay2.com)")
+		configFile  = flag.String("config", "", "Path to config file (not implemented yet)")
+		verbose     = flag.String("verbose", "", "Verbose logging (true/all, or comma-separated module.method filters)")
+		sizeFlag    = flag.Int("standardized-size", config.StandardizedSize, "Size in bytes every 29000 is padded to before forwarding (must match clients and other Renoters)")
+		bucketFlag  = flag.String("size-buckets", "", "Comma-separated smaller sizes in bytes a 29000 may be padded to; forwarded containers keep the inbound size (must match clients and other Renoters)")
+		powFlag     = flag.Int("container-pow", 0, "PoW difficulty mined on forwarded 29001 containers for relays that require it (0 = none)")
+		detectPoW   = flag.Bool("detect-container-pow", true, "Raise -container-pow to the min_pow_difficulty advertised in the relays' NIP-11")
+		sinceStart  = flag.Bool("since-startup", true, "Only subscribe to 29001 containers created after startup")
+		subLimit    = flag.Int("subscription-limit", 0, "Maximum number of stored 29001 containers requested from each relay (0 = relay default)")
+		listenOn    = flag.String("listen-relays", "", "Comma-separated subset of -relays to receive 29001 containers from (default: all relays)")
+		forwardOn   = flag.String("forward-relays", "", "Comma-separated subset of -relays re-wrapped 29001 containers are published to (default: all relays)")
+		finalOn     = flag.String("final-relays", "", "Comma-separated subset of -relays final events are published to (default: all relays)")
+		misaddress  = flag.String("misaddressed", server.MisaddressedDrop, "What to do with 29001 containers addressed to other Renoters: drop, listen (republish to -listen-relays) or relays (republish to -misaddressed-relays)")
+		misRelays   = flag.String("misaddressed-relays", "", "Comma-separated relays containers addressed to other Renoters are republished to (-misaddressed relays)")
+		announce    = flag.Bool("announce", true, "Publish a service descriptor so clients can check compatibility")
+		feeMsats    = flag.Int64("fee-msats", 0, "Fee per forwarded event in millisatoshis (0 = free; requires -lightning-address)")
+		lnAddress   = flag.String("lightning-address", "", "Lightning address (LUD-16, with LUD-21 verify support) fees are paid to")
+		freeQuota   = flag.Int("free-quota", 0, "Unpaid events forwarded per hour in paid mode")
+		admission   = flag.String("admission", descriptor.AdmissionPoW, "Comma-separated anti-spam strategies a 29000 is admitted with, any one suffices (pow, cashu)")
+		cashuMints  = flag.String("cashu-mints", "", "Comma-separated mint URLs whose Cashu tokens are accepted (cashu admission)")
+		cashuSats   = flag.Uint64("cashu-amount", 1, "Cashu token value in sats required per 29000 (cashu admission)")
+		cashuFile   = flag.String("cashu-wallet", "cashu-wallet.txt", "File redeemed Cashu tokens are appended to (cashu admission)")
+		keyEvents   = flag.Int("quota-key-events", 0, "29001 containers accepted per submitting pubkey per hour (0 = unlimited)")
+		keyBytes    = flag.Int64("quota-key-bytes", 0, "29001 container bytes accepted per submitting pubkey per hour (0 = unlimited)")
+		allEvents   = flag.Int("quota-total-events", 0, "29001 containers accepted from all pubkeys per hour (0 = unlimited)")
+		allBytes    = flag.Int64("quota-total-bytes", 0, "29001 container bytes accepted from all pubkeys per hour (0 = unlimited)")
+		quotaKeys   = flag.Int("quota-tracked-keys", 10000, "Submitting pubkeys tracked for per-key quotas; the least recently seen is forgotten first")
+		entryPoW    = flag.Int("entry-min-pow", 0, "Minimum PoW difficulty of 29000s this Renoter forwards to another hop (0 = admission only)")
+		entryRate   = flag.Int("entry-events-per-hour", 0, "Containers forwarded to another hop per hour (0 = unlimited)")
+		exitPoW     = flag.Int("exit-min-pow", 0, "Minimum PoW difficulty of 29000s whose final event this Renoter publishes as exit (0 = admission only)")
+		exitRate    = flag.Int("exit-events-per-hour", 0, "Final events published as exit per hour (0 = unlimited)")
+		exitKinds   = flag.String("exit-kinds", "", "Comma-separated kinds of final events published as exit; others are rejected (empty = all)")
+		exitSize    = flag.Int("exit-max-content", 0, "Largest final event content in bytes published as exit (0 = unlimited)")
+		exitMaxP    = flag.Int("exit-max-mentions", 0, "Most pubkeys a final event may mention to be published as exit (0 = unlimited)")
+		region      = flag.String("region", "", "Region announced in the service descriptor, e.g. an ISO 3166 country code (optional)")
+		asn         = flag.Uint("asn", 0, "Autonomous system number of the hosting provider announced in the service descriptor (0 = undeclared)")
+		delivered   = flag.String("delivery-cache", "renoter-deliveries.txt", "File the idempotency keys of published final events are kept in, to drop resent copies across restarts (empty = memory only)")
+		dupTTL      = flag.Duration("duplicate-ttl", 24*time.Hour, "Publish each final event at most once in this period, however many senders route it")
+		minDelay    = flag.Duration("min-mixing-delay", 0, "Shortest time a layer asking for a mixing delay (mixed lane) is held before it is forwarded")
+		maxDelay    = flag.Duration("max-mixing-delay", config.DefaultMaxMixingDelay, "Longest time a layer asking for a mixing delay (mixed lane) is held before it is forwarded (0 = never hold)")
+		trailers    = flag.Bool("timing-trailers", true, "Add an encrypted timing trailer for senders asking for latency reports")
+		hopAcks     = flag.Bool("hop-acks", true, "Acknowledge layers asking for an encrypted hop acknowledgement once forwarded or rejected")
+		notices     = flag.Bool("error-notices", true, "Send an encrypted error notice to senders asking for one when rejecting their layer")
+		delayDist   = flag.String("mixing-distribution", config.MixingExponential, "Distribution mixing delays are drawn from around the requested mean: exponential or uniform")
+		workers     = flag.Int("workers", server.DefaultProcessingPolicy().Workers, "29001 containers handled at once")
+		queueLen    = flag.Int("queue-size", server.DefaultProcessingPolicy().QueueSize, "29001 containers waiting for a worker; reading from -listen-relays pauses while the queue is full")
+		maxHeld     = flag.Int("max-held", server.DefaultProcessingPolicy().MaxHeld, "Layers held for a mixing delay at once; handling waits for room beyond it (0 = unlimited)")
+		maxAge      = flag.Duration("max-event-age", server.DefaultAgePolicy().MaxAge, "Reject 29001 containers and the 29000 layers inside them created longer ago than this")
+		maxFuture   = flag.Duration("max-event-future", server.DefaultAgePolicy().MaxFuture, "Reject 29001 containers and 29000 layers dated further than this in the future")
+		cacheSize   = flag.Int("replay-cache-size", server.DefaultReplayCachePolicy().MaxSize, "Most 29001 container IDs remembered to detect replays")
+		cachePrune  = flag.Float64("replay-cache-prune", server.DefaultReplayCachePolicy().PruneFraction, "Fraction of -replay-cache-size pruned, oldest first, when the replay cache is full (0 < fraction <= 1)")
+		cacheTTL    = flag.Duration("replay-cache-ttl", server.DefaultReplayCachePolicy().TTL, "How long 29001 container IDs are remembered to detect replays; must cover -max-event-age plus -max-event-future")
+		mentions    = flag.Bool("deliver-mentions", false, "Also publish final events to the inbox relays (NIP-65/NIP-17) of the pubkeys they mention")
+		mentionMax  = flag.Int("mention-max-pubkeys", 5, "Mentioned pubkeys whose relay lists are looked up per event (-deliver-mentions)")
+		inboxMax    = flag.Int("mention-max-relays", 10, "Hard cap on the inbox relays each final event is published to (-deliver-mentions)")
+		lookupOn    = flag.String("mention-lookup-relays", "", "Comma-separated relays relay lists are fetched from (default: -relays)")
+		kindStats   = flag.Bool("kind-stats", true, "Count the kinds of published final events per hour, rounded, for the metrics and dashboard (never their content)")
+		liteProf    = flag.Bool("lite-profile", false, "Also forward 4KB lite containers, admitting their layers with lower PoW, for clients on metered connections (announced in the descriptor)")
+		attribute   = flag.Bool("attribution", false, "Publish a NIP-32 label signed by this Renoter for every final event it publishes, attributing the event to it")
+		contact     = flag.String("contact", "", "Operator contact announced in the service descriptor (npub, email or URL)")
+		heartbeat   = flag.Duration("announce-interval", 0, "Republish the service descriptor at this interval so clients notice when this Renoter goes away (0 = publish once)")
+		gossipTick  = flag.Duration("gossip-interval", 0, "Publish a signed liveness heartbeat at this interval and relay those of -gossip-peers (0 = disabled)")
+		gossipPeer  = flag.String("gossip-peers", "", "Comma-separated npubs or hex pubkeys of Renoters whose heartbeats are republished to -relays (-gossip-interval)")
+		maxConns    = flag.Int("max-connections", relaypool.DefaultOptions().MaxConnections, "Maximum number of relays connected at once for forwarding (0 = unlimited)")
+		idleTime    = flag.Duration("idle-timeout", relaypool.DefaultOptions().IdleTimeout, "Close forwarding connections unused for this long (0 = never)")
+		auditFile   = flag.String("audit-log", "", "Local file recording the kind, size, hashed ID and time of every final event published as exit, never its content, or sqlite:<file> for an SQLite database (empty = disabled)")
+		auditSize   = flag.Int64("audit-max-size", 10*1024*1024, "Rotate the audit log when it would grow beyond this many bytes (0 = never)")
+		auditKeep   = flag.Int("audit-keep", 3, "Rotated audit log files kept")
+		auditSync   = flag.Bool("audit-sync", false, "Flush every audit entry to disk before going on")
+		auditAge    = flag.Duration("audit-retention", 0, "Drop audit entries older than this (0 = keep until rotated out)")
+		lookupID    = flag.String("lookup-audit", "", "Print the audit log entries of this event ID and exit")
+		mirrorFile  = flag.String("mirror", "", "Opt-in local JSONL file keeping a full copy (content and author included) of every final event published as exit, e.g. for abuse response (empty = disabled)")
+		mirrorAge   = flag.Duration("mirror-retention", 7*24*time.Hour, "Delete mirrored events created longer ago than this (0 = no age limit)")
+		mirrorMax   = flag.Int("mirror-max-events", 100000, "Keep only the newest this many mirrored events (0 = no count limit)")
+		lookupCopy  = flag.String("lookup-mirror", "", "Print the mirrored copy of this event ID as JSON and exit")
+		traceSize   = flag.Int("trace-buffer", 0, "Keep redacted processing traces (stage timings, outcome, error category) of this many recent containers, served on -metrics-listen at /traces (0 = off)")
+		metricsOn   = flag.String("metrics-listen", "", "Address to serve Prometheus metrics (/metrics) and a plain-text dashboard (/) on, e.g. localhost:9090 (empty = disabled)")
+		checkDial   = flag.Bool("dial", false, "With check-config, also connect to every relay")
+		plugin      = flag.Bool("strfry-plugin", false, "Read events from stdin and write decisions to stdout as a strfry write policy plugin, instead of subscribing to -listen-relays")
 	)
 	flag.Parse()
 
@@ -31,7 +139,71 @@ func main() {
 		logging.SetVerbose(*verbose)
 	}
 
-	if *relays == "" {
+	// The token is read after parsing, never a flag default, so -h does not print it
+	token, err := secret.Lookup(*signerToken, *signerFile, "RENOTER_SIGNER_TOKEN")
+	if err != nil {
+		log.Fatalf("Error: invalid -signer-token: %v", err)
+	}
+	*signerToken = token
+
+	// Answer audit lookups without starting the Renoter
+	if *lookupID != "" {
+		if *auditFile == "" {
+			log.Fatal("Error: -lookup-audit needs an -audit-log")
+		}
+		entries, err := server.LookupAudit(*auditFile, *lookupID, *auditKeep)
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		if len(entries) == 0 {
+			fmt.Printf("Event %s is not in the audit log\n", *lookupID)
+			os.Exit(1)
+		}
+		for _, entry := range entries {
+			fmt.Printf("%s published kind %d (%d bytes) to %d relays\n", entry.PublishedAt.Format(time.RFC3339), entry.Kind, entry.Size, entry.Relays)
+		}
+		return
+	}
+
+	// Answer mirror lookups without starting the Renoter
+	if *lookupCopy != "" {
+		if *mirrorFile == "" {
+			log.Fatal("Error: -lookup-mirror needs a -mirror")
+		}
+		store := &storage.EventFile{Path: *mirrorFile}
+		if err := store.Init(); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		events, err := store.QueryEvents(context.Background(), nostr.Filter{IDs: []string{*lookupCopy}})
+		if err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		found := false
+		for event := range events {
+			fmt.Println(event.String())
+			found = true
+		}
+		store.Close()
+		if !found {
+			fmt.Printf("Event %s is not in the mirror\n", *lookupCopy)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if inspecting {
+		if flag.NArg() != 1 {
+			log.Fatal("Error: usage: renoter-server inspect [-private-key <hex> | -keychain | -signer <url>] [-standardized-size <bytes>] [-size-buckets <sizes>] <event.json>")
+		}
+		err := inspect(os.Stdout, flag.Arg(0), inspectFlags{privateKey: *privateKey, useKeychain: *useKeychain, signer: *signer, signerToken: *signerToken, sizeFlag: *sizeFlag, bucketFlag: *bucketFlag})
+		if err != nil {
+			fmt.Printf("Stopped:        %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *relays == "" && !checking {
 		log.Fatal("Error: -relays is required (comma-separated relay URLs)")
 	}
 
@@ -40,25 +212,118 @@ func main() {
 		log.Println("Warning: -config flag is not yet implemented, ignoring")
 	}
 
-	// Generate or use provided private key
-	sk := *privateKey
-	if sk == "" {
-		sk = nostr.GeneratePrivateKey()
-		log.Println("Generated new private key")
+	// Every setting goes through check: fatal when starting, a line of the report in check-config
+	var report config.Report
+	check := func(name string, err error, format string, args ...any) {
+		if err != nil && !checking {
+			log.Fatalf("Error: invalid %s: %v", name, err)
+		}
+		report.Result(name, err, format, args...)
+	}
+	finishCheck := func() {
+		report.Print(os.Stdout)
+		if !report.OK() {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// Validate size limits before doing anything else
+	sizeLimits := config.SizeLimits{
+		StandardizedSize:  *sizeFlag,
+		MaxInnerEventSize: *sizeFlag - config.PaddingTagOverhead,
+	}
+	buckets, err := config.ParseSizeBuckets(*bucketFlag)
+	if err == nil {
+		sizeLimits.Buckets = buckets
+		err = sizeLimits.Validate()
+	}
+	check("size limits", err, "%d byte containers, sizes %v", sizeLimits.StandardizedSize, sizeLimits.Sizes())
+
+	// Use the signer service, or generate or use provided private key, or the one in the OS keychain
+	var identity server.Identity
+	keyKnown, keychainOK := false, true
+	if *signer != "" {
+		if *privateKey != "" || *useKeychain {
+			err = fmt.Errorf("-signer cannot be combined with -private-key or -keychain")
+		} else {
+			signerCtx, signerCancel := context.WithTimeout(context.Background(), 10*time.Second)
+			identity, err = server.NewRemoteIdentity(signerCtx, *signer, *signerToken)
+			signerCancel()
+		}
+		if err != nil && !checking {
+			log.Fatalf("Error: %v", err)
+		}
+		if err != nil {
+			report.Fail("signer", err)
+			finishCheck()
+		}
+		log.Printf("Using the signer service at %s", *signer)
 	} else {
-		log.Println("Using provided private key")
+		sk := *privateKey
+		keyKnown = sk != ""
+		if *useKeychain {
+			stored, err := keychain.Get("server")
+			switch {
+			case err == nil && sk != "" && sk != stored:
+				err = fmt.Errorf("-private-key differs from the key in the OS keychain")
+				if !checking {
+					log.Fatalf("Error: %v", err)
+				}
+				report.Fail("private key", err)
+				keychainOK = false
+			case err == nil:
+				sk, keyKnown = stored, true
+				log.Println("Using private key from the OS keychain")
+			case !errors.Is(err, keychain.ErrNotFound):
+				if !checking {
+					log.Fatalf("Error: failed to read the OS keychain: %v", err)
+				}
+				report.Fail("private key", err)
+				keychainOK = false
+			case !checking:
+				if sk == "" {
+					sk = nostr.GeneratePrivateKey()
+					log.Println("Generated new private key")
+				}
+				if err := keychain.Set("server", sk); err != nil {
+					log.Fatalf("Error: %v", err)
+				}
+				keyKnown = true
+				log.Println("Stored the private key in the OS keychain; -private-key is no longer needed")
+			}
+		}
+		if sk == "" {
+			if checking && !*useKeychain {
+				report.Fail("private key", fmt.Errorf("no -private-key: a new key, and so a new npub, would be generated at every start"))
+			}
+			sk = nostr.GeneratePrivateKey()
+			log.Println("Generated new private key")
+		} else if !*useKeychain {
+			log.Println("Using provided private key")
+		}
+
+		identity, err = server.NewKeyIdentity(sk)
 	}
 
-	// Get public key
-	pubkey, err := nostr.GetPublicKey(sk)
+	// Encode the public key as npub
+	var npub string
+	if err == nil {
+		npub, err = nip19.EncodePublicKey(identity.PublicKey())
+	}
+	if err != nil && checking {
+		report.Fail("private key", err)
+		finishCheck()
+	}
 	if err != nil {
 		log.Fatalf("Error: failed to get public key: %v", err)
 	}
-
-	// Encode as npub
-	npub, err := nip19.EncodePublicKey(pubkey)
-	if err != nil {
-		log.Fatalf("Error: failed to encode npub: %v", err)
+	if *signer != "" {
+		report.Pass("signer", "npub %s", npub)
+	} else if keyKnown && keychainOK {
+		report.Pass("private key", "npub %s", npub)
+	} else if *useKeychain && keychainOK {
+		report.Pass("private key", "the OS keychain holds none yet, a new key would be generated and stored there")
 	}
 
 	log.Printf("Renoter public key (npub): %s", npub)
@@ -67,7 +332,7 @@ func main() {
 	relayList := strings.Split(*relays, ",")
 	for i := range relayList {
 		relayList[i] = strings.TrimSpace(relayList[i])
-		if relayList[i] == "" {
+		if relayList[i] == "" && !checking {
 			log.Fatalf("Error: empty relay URL at index %d", i)
 		}
 	}
@@ -78,10 +343,214 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// Create Renoter instance with SimplePool
-	renoter, err := server.NewRenoter(ctx, sk, relayList)
-	if err != nil {
-		log.Fatalf("Error: failed to create Renoter: %v", err)
+	// Create Renoter instance with SimplePool; check-config only validates the relays, connecting
+	// to them with -dial, and then applies the settings to a Renoter that never connects
+	var renoter *server.Renoter
+	if checking {
+		report.CheckRelays(ctx, "relay", relayList, *checkDial, 10*time.Second)
+		renoter, err = server.NewRenoterWithIdentity(ctx, identity, relayList, nostr.NewSimplePool(ctx))
+		if err != nil {
+			report.Fail("renoter", err)
+			finishCheck()
+		}
+	} else {
+		renoter, err = server.NewRenoterWithIdentity(ctx, identity, relayList, nil)
+		if err != nil {
+			log.Fatalf("Error: failed to create Renoter: %v", err)
+		}
+	}
+	if sizeLimits.Validate() == nil {
+		if err := renoter.SetSizeLimits(sizeLimits); err != nil {
+			log.Fatalf("Error: failed to apply size limits: %v", err)
+		}
+	}
+	check("-container-pow", renoter.SetContainerPoWDifficulty(*powFlag), "difficulty %d", *powFlag)
+	// relayCount is the number of relays a setting covers: the subset if given, all otherwise
+	relayCount := func(subset []string) int {
+		if len(subset) > 0 {
+			return len(subset)
+		}
+		return len(relayList)
+	}
+	subscription := server.SubscriptionOptions{
+		SinceStartup: *sinceStart,
+		Limit:        *subLimit,
+	}
+	if *listenOn != "" {
+		for _, url := range strings.Split(*listenOn, ",") {
+			subscription.ListenRelays = append(subscription.ListenRelays, strings.TrimSpace(url))
+		}
+	}
+	check("subscription options", renoter.SetSubscriptionOptions(subscription), "listening on %d relays", relayCount(subscription.ListenRelays))
+	publishPolicy := server.PublishPolicy{}
+	if *forwardOn != "" {
+		publishPolicy.ForwardRelays = strings.Split(*forwardOn, ",")
+	}
+	if *finalOn != "" {
+		publishPolicy.FinalRelays = strings.Split(*finalOn, ",")
+	}
+	check("publish policy", renoter.SetPublishPolicy(publishPolicy), "%d forward relays, %d final relays", relayCount(publishPolicy.ForwardRelays), relayCount(publishPolicy.FinalRelays))
+	misaddressed := server.MisaddressedPolicy{Action: *misaddress}
+	if *misRelays != "" {
+		for _, url := range strings.Split(*misRelays, ",") {
+			misaddressed.Relays = append(misaddressed.Relays, strings.TrimSpace(url))
+		}
+	}
+	check("misaddressed containers", renoter.SetMisaddressedPolicy(misaddressed), "%s", *misaddress)
+	check("connection limits", renoter.SetPublishPoolOptions(relaypool.Options{MaxConnections: *maxConns, IdleTimeout: *idleTime}), "at most %d connections", *maxConns)
+	if *feeMsats > 0 {
+		policy := server.PaymentPolicy{FeeMsats: *feeMsats, LightningAddress: *lnAddress, FreeQuota: *freeQuota}
+		check("payment settings", renoter.SetPaymentPolicy(policy, nil), "%d msats per event to %s", *feeMsats, *lnAddress)
+	}
+	if *keyEvents > 0 || *keyBytes > 0 || *allEvents > 0 || *allBytes > 0 {
+		policy := server.QuotaPolicy{EventsPerKey: *keyEvents, BytesPerKey: *keyBytes, TotalEvents: *allEvents, TotalBytes: *allBytes, TrackedKeys: *quotaKeys}
+		check("quota settings", renoter.SetQuotaPolicy(policy), "applied")
+	}
+	if *entryPoW > 0 || *entryRate > 0 || *exitPoW > 0 || *exitRate > 0 || *exitKinds != "" || *exitSize > 0 || *exitMaxP > 0 {
+		policies := server.RolePolicies{
+			Entry: server.RolePolicy{MinPoW: *entryPoW, EventsPerHour: *entryRate},
+			Exit:  server.RolePolicy{MinPoW: *exitPoW, EventsPerHour: *exitRate, MaxContentSize: *exitSize, MaxMentions: *exitMaxP},
+		}
+		if *exitKinds != "" {
+			policies.Exit.AllowedKinds = make(map[int]bool)
+			for _, field := range strings.Split(*exitKinds, ",") {
+				kind, err := strconv.Atoi(strings.TrimSpace(field))
+				if err != nil {
+					check("-exit-kinds", fmt.Errorf("invalid entry %q", field), "")
+					continue
+				}
+				policies.Exit.AllowedKinds[kind] = true
+			}
+		}
+		check("role policies", renoter.SetRolePolicies(policies), "entry and exit rules applied")
+	}
+	var admissions []server.Admission
+	for _, name := range strings.Split(*admission, ",") {
+		switch strings.TrimSpace(name) {
+		case descriptor.AdmissionPoW:
+			admissions = append(admissions, &server.PoWAdmission{Difficulty: config.PoWDifficulty})
+		case descriptor.AdmissionCashu:
+			policy := server.CashuPolicy{Amount: *cashuSats, WalletFile: *cashuFile}
+			if *cashuMints != "" {
+				policy.Mints = strings.Split(*cashuMints, ",")
+			}
+			cashuAdmission, err := server.NewCashuAdmission(policy, nil)
+			check("cashu settings", err, "%d sats from %d mints", *cashuSats, len(policy.Mints))
+			if err == nil {
+				admissions = append(admissions, cashuAdmission)
+			}
+		default:
+			check("-admission", fmt.Errorf("unknown admission strategy %q", name), "")
+		}
+	}
+	check("admission strategies", renoter.SetAdmissions(admissions...), "%s", *admission)
+	renoter.SetLocation(*region, uint32(*asn))
+	if *mentions {
+		policy := server.MentionPolicy{MaxMentions: *mentionMax, MaxRelays: *inboxMax}
+		if *lookupOn != "" {
+			policy.LookupRelays = strings.Split(*lookupOn, ",")
+		}
+		check("mention delivery settings", renoter.SetMentionPolicy(policy), "up to %d inbox relays", *inboxMax)
+		if checking && *lookupOn != "" {
+			report.CheckRelays(ctx, "mention lookup relay", policy.LookupRelays, *checkDial, 10*time.Second)
+		}
+	}
+	check("mixing delay settings", renoter.SetMixingPolicy(server.MixingPolicy{Min: *minDelay, Max: *maxDelay, Distribution: *delayDist}), "%v to %v, %s", *minDelay, *maxDelay, *delayDist)
+	check("processing limits", renoter.SetProcessingPolicy(server.ProcessingPolicy{Workers: *workers, QueueSize: *queueLen, MaxHeld: *maxHeld}), "%d workers, queue of %d, %d held layers", *workers, *queueLen, *maxHeld)
+	// The replay cache must remember containers for as long as they are accepted, so a shorter
+	// TTL is applied after the age limits it has to cover, and a longer one before
+	cachePolicy := server.ReplayCachePolicy{MaxSize: *cacheSize, PruneFraction: *cachePrune, TTL: *cacheTTL}
+	setCache := func() {
+		check("replay cache", renoter.SetReplayCachePolicy(cachePolicy), "%d container IDs for %v, pruning %.0f%% when full", cachePolicy.MaxSize, cachePolicy.TTL, 100*cachePolicy.PruneFraction)
+	}
+	longer := cachePolicy.TTL >= renoter.ReplayCachePolicy().TTL
+	if longer {
+		setCache()
+	}
+	check("age limits", renoter.SetAgePolicy(server.AgePolicy{MaxAge: *maxAge, MaxFuture: *maxFuture}), "up to %v old, %v ahead", *maxAge, *maxFuture)
+	if !longer {
+		setCache()
+	}
+	var traceErr error
+	if *traceSize > 0 && *metricsOn == "" {
+		traceErr = fmt.Errorf("-trace-buffer needs -metrics-listen to serve the traces")
+	} else {
+		traceErr = renoter.SetTraceBuffer(*traceSize)
+	}
+	check("trace buffer", traceErr, "%d containers", *traceSize)
+	renoter.SetTimingTrailers(*trailers)
+	renoter.SetHopAcks(*hopAcks)
+	renoter.SetErrorNotices(*notices)
+	renoter.SetAttribution(*attribute)
+	check("-lite-profile", renoter.SetLiteProfile(*liteProf), "%v", *liteProf)
+	renoter.SetKindStats(*kindStats)
+	check("-duplicate-ttl", renoter.SetDuplicateTTL(*dupTTL), "%v", *dupTTL)
+	gossipPolicy := server.GossipPolicy{Interval: *gossipTick}
+	if *gossipTick > 0 {
+		for _, field := range strings.Split(*gossipPeer, ",") {
+			peer := strings.TrimSpace(field)
+			if peer == "" {
+				continue
+			}
+			if strings.HasPrefix(peer, "npub") {
+				_, value, err := nip19.Decode(peer)
+				if err != nil {
+					check("-gossip-peers", fmt.Errorf("invalid npub %q: %w", peer, err), "")
+					continue
+				}
+				peer = value.(string)
+			}
+			gossipPolicy.Peers = append(gossipPolicy.Peers, peer)
+		}
+		check("gossip settings", gossipPolicy.Validate(), "heartbeat every %v, relaying %d peers", *gossipTick, len(gossipPolicy.Peers))
+	}
+
+	// check-config reads the files the Renoter keeps instead of opening (and creating) them
+	if checking {
+		if *auditFile != "" {
+			entries := 0
+			err := storage.Scan(*auditFile, server.AuditStorage(storage.Options{Keep: *auditKeep}), time.Time{}, time.Time{}, func([]byte) { entries++ })
+			check("-audit-log", err, "%d entries readable", entries)
+		}
+		if *mirrorFile != "" {
+			events := 0
+			err := storage.Scan(*mirrorFile, storage.Options{}, time.Time{}, time.Time{}, func([]byte) { events++ })
+			if err == nil {
+				err = server.MirrorPolicy{Retention: *mirrorAge, MaxEvents: *mirrorMax}.Validate()
+			}
+			check("-mirror", err, "%d events readable", events)
+		}
+		finishCheck()
+	}
+	if *delivered != "" {
+		if err := renoter.SetDeliveryCache(*delivered); err != nil {
+			log.Fatalf("Error: invalid -delivery-cache: %v", err)
+		}
+	}
+	if *auditFile != "" {
+		store, err := storage.Open(*auditFile, server.AuditStorage(storage.Options{
+			MaxBytes:  *auditSize,
+			Keep:      *auditKeep,
+			Sync:      *auditSync,
+			Retention: *auditAge,
+		}))
+		if err != nil {
+			log.Fatalf("Error: invalid -audit-log: %v", err)
+		}
+		renoter.SetAuditStorage(store)
+		log.Printf("Recording published final events in %s", *auditFile)
+		defer renoter.CloseAuditLog()
+	}
+	if *mirrorFile != "" {
+		policy := server.MirrorPolicy{Retention: *mirrorAge, MaxEvents: *mirrorMax}
+		if err := renoter.StartMirror(ctx, &storage.EventFile{Path: *mirrorFile}, policy); err != nil {
+			log.Fatalf("Error: invalid -mirror: %v", err)
+		}
+		log.Printf("Mirroring published final events to %s", *mirrorFile)
+		defer renoter.CloseMirror()
+	}
+	if *detectPoW {
+		log.Printf("Mining forwarded 29001 containers with PoW difficulty %d", renoter.DetectContainerPoW(ctx))
 	}
 
 	// Setup signal handling
@@ -91,10 +560,108 @@ func main() {
 	go func() {
 		<-sigChan
 		log.Println("Shutting down...")
+		stats := renoter.DuplicateStats()
+		log.Printf("Suppressed %d resent layers and %d duplicate final events", stats.ResentLayers, stats.DuplicateFinals)
+		log.Printf("Rejected %d malformed wrappers", renoter.MalformedWrappers())
+		outcomes := renoter.OutcomeStats()
+		log.Printf("Containers: %d forwarded, %d published, %d held, %d dropped, %d rejected", outcomes.Forwarded, outcomes.PublishedFinal, outcomes.Held, outcomes.Dropped, outcomes.Rejected)
+		if *announce {
+			offlineCtx, offlineCancel := context.WithTimeout(context.Background(), 5*time.Second)
+			if err := renoter.AnnounceOffline(offlineCtx, *contact); err != nil {
+				log.Printf("Warning: failed to announce going offline: %v", err)
+			}
+			offlineCancel()
+		}
 		cancel()
+		closeCtx, closeCancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		if err := renoter.Close(closeCtx); err != nil {
+			log.Printf("Warning: unclean shutdown: %v", err)
+		}
+		closeCancel()
+		renoter.CloseAuditLog()
+		renoter.CloseMirror()
 		os.Exit(0)
 	}()
 
+	// Announce what this Renoter accepts; failing to announce is not fatal
+	if *announce {
+		var announceErr error
+		if *heartbeat > 0 {
+			announceErr = renoter.AnnouncePeriodically(ctx, *contact, *heartbeat)
+		} else {
+			announceErr = renoter.PublishDescriptor(ctx, *contact)
+		}
+		if announceErr != nil {
+			log.Printf("Warning: failed to publish service descriptor: %v", announceErr)
+		}
+	}
+	if *gossipTick > 0 {
+		if err := renoter.StartGossip(ctx, gossipPolicy); err != nil {
+			log.Printf("Warning: failed to publish heartbeat: %v", err)
+		}
+	}
+
+	// Metrics and dashboard, for tuning admission on the traffic actually seen
+	if *metricsOn != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			fmt.Fprintf(w, "Nostr Renoter Server %s\n\n", npub)
+
+			pow := renoter.PoWStats()
+			fmt.Fprintf(w, "Admitted by PoW: %d layers, %d bits required\n", pow.Admitted, pow.Required)
+			if pow.Admitted > 0 {
+				fmt.Fprintf(w, "Leading zero bits: mean %.1f, median %d, p90 %d, max %d\n", pow.Mean(), pow.Quantile(0.5), pow.Quantile(0.9), len(pow.Bits)-1)
+				fmt.Fprintf(w, "Above required: %d layers (%.0f%%)\n", pow.Above(), 100*float64(pow.Above())/float64(pow.Admitted))
+				for bits := pow.Required; bits < len(pow.Bits); bits++ {
+					fmt.Fprintf(w, "  %3d bits: %d\n", bits, pow.Bits[bits])
+				}
+			}
+
+			outcomes := renoter.OutcomeStats()
+			fmt.Fprintf(w, "\nContainers: %d forwarded, %d published, %d held, %d dropped, %d rejected\n", outcomes.Forwarded, outcomes.PublishedFinal, outcomes.Held, outcomes.Dropped, outcomes.Rejected)
+
+			if *kindStats {
+				kinds := renoter.KindStats()
+				totals := kinds.Totals()
+				fmt.Fprintf(w, "\nFinal events by kind, last %d complete hours, rounded to %d per hour:\n", server.KindStatsHours, server.KindStatsRounding)
+				if len(totals) == 0 {
+					fmt.Fprintf(w, "  none\n")
+				}
+				for _, kind := range slices.Sorted(maps.Keys(totals)) {
+					fmt.Fprintf(w, "  kind %5d: %d (last hour %d)\n", kind, totals[kind], kinds.LastHour[kind])
+				}
+			}
+
+			dups := renoter.DuplicateStats()
+			fmt.Fprintf(w, "\nSuppressed: %d resent layers, %d duplicate final events\n", dups.ResentLayers, dups.DuplicateFinals)
+			fmt.Fprintf(w, "Rejected: %d malformed wrappers (extra tags, bad content or routing tags)\n", renoter.MalformedWrappers())
+
+			cache := renoter.ReplayCacheStats()
+			fmt.Fprintf(w, "\nReplay cache: %d of %d container IDs, remembered for %v, pruning %.0f%% when full\n", cache.Size, cache.MaxSize, cache.TTL, 100*cache.PruneFraction)
+			fmt.Fprintf(w, "\nPrometheus metrics: /metrics (JSON with Accept: application/json)\n")
+			if *traceSize > 0 {
+				fmt.Fprintf(w, "Processing traces of the last %d containers: /traces\n", *traceSize)
+			}
+		})
+		mux.Handle("/metrics", server.PoWMetrics{Renoter: renoter})
+		mux.Handle("/traces", server.TraceHandler{Renoter: renoter})
+		go func() {
+			if err := http.ListenAndServe(*metricsOn, mux); err != nil {
+				log.Fatalf("Error: failed to serve metrics: %v", err)
+			}
+		}()
+		log.Printf("Serving metrics on http://%s/metrics", *metricsOn)
+	}
+
+	// As a relay plugin, events arrive on stdin; the process ends when the relay closes it
+	if *plugin {
+		if err := renoter.ServePlugin(ctx, os.Stdin, os.Stdout); err != nil {
+			log.Fatalf("Error: %v", err)
+		}
+		return
+	}
+
 	// Subscribe to wrapped events on all relays
 	log.Printf("Subscribing to %d relays for wrapped events", len(relayList))
 	log.Println("Press Ctrl+C to stop")
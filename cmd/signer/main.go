@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/girino/renoter/internal/keychain"
+	"github.com/girino/renoter/internal/secret"
+	"github.com/girino/renoter/pkg/server"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+func main() {
+	// Initialize logging from environment variable
+	logging.SetVerbose(os.Getenv("VERBOSE"))
+
+	var (
+		listenAddr  = flag.String("listen", "unix:renoter-signer.sock", "Address to listen on: unix:<socket path>, or host:port (e.g., localhost:8091; requires -token)")
+		tokenFlag   = flag.String("token", "", "Bearer token clients must send, required on host:port; prefer -token-file or $RENOTER_SIGNER_TOKEN, which stay out of the process list")
+		tokenFile   = flag.String("token-file", "", "File holding the -token")
+		privateKey  = flag.String("private-key", "", "Private key of the Renoter in hex format")
+		useKeychain = flag.Bool("keychain", false, "Read the private key from the OS keychain, where renoter-server -keychain keeps it")
+		verbose     = flag.String("verbose", "", "Verbose logging (true/all, or comma-separated module.method filters)")
+	)
+	flag.Parse()
+
+	// Override with flag if provided
+	if *verbose != "" {
+		logging.SetVerbose(*verbose)
+	}
+
+	// The token is read after parsing, never a flag default, so -h does not print it
+	token, err := secret.Lookup(*tokenFlag, *tokenFile, "RENOTER_SIGNER_TOKEN")
+	if err != nil {
+		log.Fatalf("Error: invalid -token: %v", err)
+	}
+
+	sk := *privateKey
+	if sk == "" && *useKeychain {
+		stored, err := keychain.Get("server")
+		if errors.Is(err, keychain.ErrNotFound) {
+			log.Fatal("Error: the OS keychain holds no server key yet")
+		}
+		if err != nil {
+			log.Fatalf("Error: failed to read the OS keychain: %v", err)
+		}
+		sk = stored
+	}
+	if sk == "" {
+		log.Fatal("Error: -private-key or -keychain is required")
+	}
+	identity, err := server.NewKeyIdentity(sk)
+	if err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+	npub, _ := nip19.EncodePublicKey(identity.PublicKey())
+
+	listener, err := listen(*listenAddr, token)
+	if err != nil {
+		log.Fatalf("Error: failed to listen on %s: %v", *listenAddr, err)
+	}
+
+	log.Printf("Starting signer service for %s on %s", npub, *listenAddr)
+	if token != "" {
+		log.Printf("Point the Renoter at it with -signer=%s and the same -signer-token", signerURL(*listenAddr))
+	} else {
+		log.Printf("Point the Renoter at it with -signer=%s", signerURL(*listenAddr))
+	}
+
+	if err := http.Serve(listener, server.NewIdentityHandler(identity, token)); err != nil {
+		log.Fatalf("Error: failed to serve: %v", err)
+	}
+}
+
+// listen opens the listener of the service on listenAddr. Whoever reaches it can open containers
+// addressed to the Renoter, so a unix socket is created with mode 0600, only its owner may
+// connect, and host:port is refused without a token, as any local user or host could connect.
+func listen(listenAddr, token string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(listenAddr, "unix:")
+	if !ok {
+		if token == "" {
+			return nil, fmt.Errorf("listening on host:port needs -token, or listen on a unix:<socket path>")
+		}
+		return net.Listen("tcp", listenAddr)
+	}
+	os.Remove(path)
+	// The socket is reachable as soon as it exists: create it with the right mode, not Chmod later
+	var listener net.Listener
+	err := withUmask(0o077, func() (err error) {
+		listener, err = net.Listen("unix", path)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// signerURL returns the -signer value for a service listening on listenAddr.
+func signerURL(listenAddr string) string {
+	if strings.HasPrefix(listenAddr, "unix:") {
+		return listenAddr
+	}
+	return "http://" + listenAddr
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSignerURL(t *testing.T) {
+	tests := map[string]string{
+		"unix:/run/renoter/signer.sock": "unix:/run/renoter/signer.sock",
+		"localhost:8091":                "http://localhost:8091",
+	}
+	for listen, want := range tests {
+		if got := signerURL(listen); got != want {
+			t.Errorf("signerURL(%q) = %q, want %q", listen, got, want)
+		}
+	}
+}
+
+func TestListen(t *testing.T) {
+	if _, err := listen("localhost:0", ""); err == nil {
+		t.Error("listen() on host:port without a token should fail")
+	}
+	tcp, err := listen("localhost:0", "s3cret")
+	if err != nil {
+		t.Fatalf("listen() on host:port with a token error = %v", err)
+	}
+	tcp.Close()
+
+	path := filepath.Join(t.TempDir(), "signer.sock")
+	unix, err := listen("unix:"+path, "")
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+	defer unix.Close()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if perm := info.Mode().Perm(); perm&0o077 != 0 {
+		t.Errorf("socket mode = %v, want no access for group and others", perm)
+	}
+}
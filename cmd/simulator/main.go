@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/girino/renoter/pkg/simulator"
+)
+
+func main() {
+	// Initialize logging from environment variable
+	logging.SetVerbose(os.Getenv("VERBOSE"))
+
+	defaults := simulator.DefaultConfig()
+	var (
+		clients         = flag.Int("clients", defaults.Clients, "Number of simulated clients")
+		renoters        = flag.Int("renoters", defaults.Renoters, "Number of simulated Renoter servers")
+		relays          = flag.Int("relays", defaults.Relays, "Number of in-process relays")
+		pathLength      = flag.Int("path-length", defaults.PathLength, "Number of Renoters in each event's path")
+		events          = flag.Int("events", defaults.EventsPerClient, "Number of events each client submits")
+		pattern         = flag.String("pattern", string(defaults.Pattern), "Traffic pattern: constant, burst or poisson")
+		interval        = flag.Duration("interval", defaults.Interval, "Mean gap between a client's submissions")
+		deliveryTimeout = flag.Duration("delivery-timeout", defaults.DeliveryTimeout, "How long to wait for stragglers after the last submission")
+		dropRate        = flag.Float64("drop-rate", 0, "Fraction of container deliveries the relays silently drop")
+		duplicateRate   = flag.Float64("duplicate-rate", 0, "Fraction of container deliveries the relays make twice")
+		jitter          = flag.Duration("jitter", 0, "Largest random delay the relays add to container deliveries, reordering them")
+		disconnectEvery = flag.Duration("disconnect-interval", 0, "Mean time between forced disconnects on each relay (0 = never)")
+		verbose         = flag.String("verbose", "", "Verbose logging (true/all, or comma-separated module.method filters)")
+	)
+	flag.Parse()
+
+	// Override with flag if provided
+	if *verbose != "" {
+		logging.SetVerbose(*verbose)
+	}
+
+	cfg := defaults
+	cfg.Clients = *clients
+	cfg.Renoters = *renoters
+	cfg.Relays = *relays
+	cfg.PathLength = *pathLength
+	cfg.EventsPerClient = *events
+	cfg.Pattern = simulator.Pattern(*pattern)
+	cfg.Interval = *interval
+	cfg.DeliveryTimeout = *deliveryTimeout
+	cfg.Faults = simulator.Faults{
+		DropRate:           *dropRate,
+		DuplicateRate:      *duplicateRate,
+		Jitter:             *jitter,
+		DisconnectInterval: *disconnectEvery,
+	}
+
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Error: %v", err)
+	}
+
+	// Cancel the run on Ctrl+C
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigChan
+		log.Println("Interrupted, stopping simulation...")
+		cancel()
+	}()
+
+	log.Printf("Simulating %d clients and %d renoters over %d relays (path length %d, %d events per client, %s traffic)",
+		cfg.Clients, cfg.Renoters, cfg.Relays, cfg.PathLength, cfg.EventsPerClient, cfg.Pattern)
+
+	started := time.Now()
+	report, err := simulator.Run(ctx, cfg)
+	if err != nil {
+		log.Fatalf("Error: simulation failed: %v", err)
+	}
+
+	fmt.Println(report.String())
+	log.Printf("Simulation completed in %v", time.Since(started).Round(time.Millisecond))
+	if report.Lost > 0 || report.SubmitErrors > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,13 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestMainPackage(t *testing.T) {
+	// This test ensures the main package compiles correctly
+	// The simulation itself is covered by pkg/simulator tests
+
+	_ = os.Getenv("VERBOSE")
+}
@@ -0,0 +1,105 @@
+//go:build js && wasm
+
+// Command wasm is the wrapping core built as a WebAssembly module for browser clients, which
+// construct 29001 containers locally and publish them to the server relays over their own
+// websockets. Build it with
+//
+//	GOOS=js GOARCH=wasm go build -o renoter.wasm ./cmd/wasm
+//
+// and load it with the wasm_exec.js of the same Go release. It sets a global renoter object:
+//
+//	renoter.wrapEvent(event, renoters, options, onProgress) -> Promise<string>
+//	renoter.maxContentSize(hops, options) -> number
+//
+// event is a signed event (object or JSON), renoters the npubs, nprofiles or hex pubkeys of the
+// path in order, options an optional object ({compactLayers, containerPow, lane}) and onProgress
+// an optional function called with {difficulty, attempts, done} while layers are mined. The
+// promise resolves with the container as JSON, or rejects with the reason in the
+// config.Rejection format. Mining blocks the thread it runs on, so load the module in a Web
+// Worker to keep a page responsive.
+package main
+
+import (
+	"context"
+	"fmt"
+	"syscall/js"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/girino/renoter/pkg/client"
+)
+
+func main() {
+	js.Global().Set("renoter", js.ValueOf(map[string]any{
+		"wrapEvent":      js.FuncOf(wrapEvent),
+		"maxContentSize": js.FuncOf(jsMaxContentSize),
+	}))
+	// The functions stay callable for as long as the page is open
+	select {}
+}
+
+// wrapEvent is renoter.wrapEvent.
+func wrapEvent(this js.Value, args []js.Value) any {
+	eventJSON := jsonArg(args, 0)
+	var renoters []string
+	if len(args) > 1 && args[1].Type() == js.TypeObject {
+		for i := 0; i < args[1].Length(); i++ {
+			renoters = append(renoters, args[1].Index(i).String())
+		}
+	}
+	optionsJSON := jsonArg(args, 2)
+	var progress func(client.MiningProgress)
+	if len(args) > 3 && args[3].Type() == js.TypeFunction {
+		onProgress := args[3]
+		progress = func(p client.MiningProgress) {
+			onProgress.Invoke(js.ValueOf(map[string]any{"difficulty": p.Difficulty, "attempts": float64(p.Attempts), "done": p.Done}))
+		}
+	}
+
+	return newPromise(func() (any, error) {
+		return wrap(context.Background(), eventJSON, renoters, optionsJSON, progress)
+	})
+}
+
+// jsMaxContentSize is renoter.maxContentSize.
+func jsMaxContentSize(this js.Value, args []js.Value) any {
+	if len(args) == 0 || args[0].Type() != js.TypeNumber {
+		return js.Global().Get("Error").New("the number of hops is required")
+	}
+	size, err := maxContentSize(args[0].Int(), jsonArg(args, 1))
+	if err != nil {
+		return js.Global().Get("Error").New(err.Error())
+	}
+	return size
+}
+
+// jsonArg returns args[i] as JSON: strings as they are, other values stringified ("" if absent).
+func jsonArg(args []js.Value, i int) string {
+	if len(args) <= i || args[i].IsUndefined() || args[i].IsNull() {
+		return ""
+	}
+	if args[i].Type() == js.TypeString {
+		return args[i].String()
+	}
+	return js.Global().Get("JSON").Call("stringify", args[i]).String()
+}
+
+// newPromise returns a JS promise settled with the result of run, which runs on its own
+// goroutine so the calling JS function returns at once.
+func newPromise(run func() (any, error)) js.Value {
+	var executor js.Func
+	executor = js.FuncOf(func(this js.Value, args []js.Value) any {
+		resolve, reject := args[0], args[1]
+		go func() {
+			defer executor.Release()
+			result, err := run()
+			if err != nil {
+				logging.DebugMethod("wasm.main", "wrapEvent", "Wrapping failed: %v", err)
+				reject.Invoke(js.Global().Get("Error").New(fmt.Sprint(err)))
+				return
+			}
+			resolve.Invoke(result)
+		}()
+		return nil
+	})
+	return js.Global().Get("Promise").New(executor)
+}
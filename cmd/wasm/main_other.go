@@ -0,0 +1,13 @@
+//go:build !(js && wasm)
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func main() {
+	fmt.Fprintln(os.Stderr, "Error: this is a WebAssembly module, build it with GOOS=js GOARCH=wasm go build -o renoter.wasm ./cmd/wasm")
+	os.Exit(1)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/girino/renoter/internal/config"
+	"github.com/girino/renoter/pkg/client"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestWrap(t *testing.T) {
+	event := nostr.Event{Kind: 1, Content: "posted from a browser", CreatedAt: nostr.Now(), Tags: nostr.Tags{}}
+	event.Sign(nostr.GeneratePrivateKey())
+	pk, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+
+	var reports []client.MiningProgress
+	containerJSON, err := wrap(context.Background(), event.String(), []string{pk}, `{"lane":"fast"}`, func(p client.MiningProgress) {
+		reports = append(reports, p)
+	})
+	if err != nil {
+		t.Fatalf("wrap() error = %v", err)
+	}
+	var container nostr.Event
+	if err := json.Unmarshal([]byte(containerJSON), &container); err != nil {
+		t.Fatalf("wrap() returned malformed JSON: %v", err)
+	}
+	if container.Kind != config.StandardizedWrapperKind {
+		t.Errorf("container kind = %d, want %d", container.Kind, config.StandardizedWrapperKind)
+	}
+	if ok, err := container.CheckSignature(); !ok {
+		t.Errorf("container signature invalid: %v", err)
+	}
+	if len(reports) == 0 || !reports[len(reports)-1].Done {
+		t.Errorf("progress reports = %+v, want them to end with a done report", reports)
+	}
+}
+
+func TestWrap_Invalid(t *testing.T) {
+	event := nostr.Event{Kind: 1, Content: "posted from a browser", CreatedAt: nostr.Now(), Tags: nostr.Tags{}}
+	event.Sign(nostr.GeneratePrivateKey())
+	pk, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+
+	tests := []struct {
+		name     string
+		event    string
+		renoters []string
+		options  string
+	}{
+		{"malformed event", "{", []string{pk}, ""},
+		{"unsigned event", `{"kind":1,"content":"x"}`, []string{pk}, ""},
+		{"no renoters", event.String(), nil, ""},
+		{"malformed options", event.String(), []string{pk}, "{"},
+		{"unknown lane", event.String(), []string{pk}, `{"lane":"slow"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := wrap(context.Background(), tt.event, tt.renoters, tt.options, nil); err == nil {
+				t.Error("wrap() succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestMaxContentSize(t *testing.T) {
+	plain, err := maxContentSize(3, "")
+	if err != nil || plain <= 0 {
+		t.Fatalf("maxContentSize(3) = %d, %v, want a positive size", plain, err)
+	}
+	if compact, _ := maxContentSize(3, `{"compactLayers":true}`); compact <= plain {
+		t.Errorf("maxContentSize(3) with compact layers = %d, want more than %d", compact, plain)
+	}
+	if _, err := maxContentSize(0, ""); err == nil || !strings.Contains(err.Error(), "at least one") {
+		t.Errorf("maxContentSize(0) error = %v, want an error", err)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/girino/renoter/internal/config"
+	"github.com/girino/renoter/pkg/client"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// wrapOptions are the options web clients pass to renoter.wrapEvent and renoter.maxContentSize.
+type wrapOptions struct {
+	// Encode the event compactly in every layer; only for paths whose Renoters all announce
+	// config.CompactLayersVersion in their descriptors
+	CompactLayers bool `json:"compactLayers,omitempty"`
+	// PoW difficulty mined on the 29001 container, for server relays requiring it
+	ContainerPoW int `json:"containerPow,omitempty"`
+	// Delivery lane, config.LaneFast or config.LaneMixed ("" = fast)
+	Lane string `json:"lane,omitempty"`
+}
+
+// clientOptions returns the client options of optionsJSON ("" = defaults), mining with miner.
+func clientOptions(optionsJSON string, miner client.PoWMiner) (client.Options, error) {
+	var options wrapOptions
+	if optionsJSON != "" {
+		if err := json.Unmarshal([]byte(optionsJSON), &options); err != nil {
+			return client.Options{}, fmt.Errorf("malformed options: %w", err)
+		}
+	}
+	opts := client.DefaultOptions()
+	opts.Miner = miner
+	opts.CompactLayers = options.CompactLayers
+	opts.ContainerPoWDifficulty = options.ContainerPoW
+	if options.Lane != "" {
+		opts.Lane = options.Lane
+	}
+	if err := opts.Validate(); err != nil {
+		return client.Options{}, fmt.Errorf("invalid options: %w", err)
+	}
+	return opts, nil
+}
+
+// wrap wraps the signed event in eventJSON for the Renoters in renoters, in path order, and
+// returns the 29001 container as JSON, ready to be published to the server relays. progress, if
+// not nil, is called as every layer is mined.
+func wrap(ctx context.Context, eventJSON string, renoters []string, optionsJSON string, progress func(client.MiningProgress)) (string, error) {
+	var event nostr.Event
+	if err := json.Unmarshal([]byte(eventJSON), &event); err != nil {
+		return "", fmt.Errorf("%s: malformed event: %w", config.PrefixInvalid, err)
+	}
+	path, err := client.ValidatePath(renoters)
+	if err != nil {
+		return "", err
+	}
+	opts, err := clientOptions(optionsJSON, client.ProgressMiner{Progress: progress})
+	if err != nil {
+		return "", err
+	}
+	if err := client.ValidateEvent(&event, opts.Validation, time.Now()); err != nil {
+		return "", err
+	}
+	container, err := client.WrapEvent(ctx, &event, path, client.WrapOptions{Options: opts})
+	if err != nil {
+		return "", err
+	}
+	return container.String(), nil
+}
+
+// maxContentSize returns the largest content a hops-Renoter path accepts with optionsJSON.
+func maxContentSize(hops int, optionsJSON string) (int, error) {
+	if hops < 1 {
+		return 0, fmt.Errorf("a path needs at least one Renoter, got %d", hops)
+	}
+	opts, err := clientOptions(optionsJSON, client.CPUMiner{})
+	if err != nil {
+		return 0, err
+	}
+	return opts.MaxContentSize(hops), nil
+}
@@ -0,0 +1,52 @@
+package e2e
+
+import (
+	"context"
+	"sync"
+
+	"github.com/fiatjaf/khatru"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// memoryStore keeps the events published to a test relay so they can be looked up by ID, as
+// the client does to confirm delivery. Ephemeral events are never stored, like on real relays.
+type memoryStore struct {
+	mu     sync.Mutex
+	events map[string]*nostr.Event
+}
+
+// attachMemoryStore makes relay store the events published to it in memory.
+func attachMemoryStore(relay *khatru.Relay) {
+	store := &memoryStore{events: make(map[string]*nostr.Event)}
+	relay.StoreEvent = append(relay.StoreEvent, store.save)
+	relay.ReplaceEvent = append(relay.ReplaceEvent, store.save)
+	relay.QueryEvents = append(relay.QueryEvents, store.query)
+}
+
+// save stores a copy of event.
+func (s *memoryStore) save(ctx context.Context, event *nostr.Event) error {
+	copied := *event
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events[event.ID] = &copied
+	return nil
+}
+
+// query returns the stored events matching filter.
+func (s *memoryStore) query(ctx context.Context, filter nostr.Filter) (chan *nostr.Event, error) {
+	s.mu.Lock()
+	var matches []*nostr.Event
+	for _, event := range s.events {
+		if filter.Matches(event) {
+			matches = append(matches, event)
+		}
+	}
+	s.mu.Unlock()
+
+	events := make(chan *nostr.Event, len(matches))
+	for _, event := range matches {
+		events <- event
+	}
+	close(events)
+	return events, nil
+}
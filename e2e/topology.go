@@ -0,0 +1,260 @@
+// Package e2e runs the whole Renoter topology in-process for end-to-end tests: server relays,
+// Renoters subscribed to them, and a client relay dispatching the events local apps publish to
+// it over a path through the Renoters. It is the in-process counterpart of the docker compose
+// deployment, so the distributed behavior (a hop failing, a relay going down, replays) can be
+// exercised with go test, without Docker.
+//
+// Every component listens on a random localhost port, and the server relays keep the events
+// published to them in memory. Tests can stop and restart Renoters and stop relays while events
+// are in flight.
+package e2e
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/girino/renoter/pkg/client"
+	"github.com/girino/renoter/pkg/server"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// WarmUp is how long Start waits for the Renoter subscriptions to reach the relays.
+const WarmUp = 500 * time.Millisecond
+
+// Config is the shape of a topology.
+type Config struct {
+	// Renoters on the path, in path order
+	Renoters int
+	// Server relays every Renoter and the client use
+	Relays int
+	// Options of the client's dispatcher
+	Client client.Options
+	// Always enter through the first Renoter, as the client's only guard, so tests know which
+	// hop receives the client's containers. The order of the other hops is drawn per event.
+	FixedEntry bool
+}
+
+// DefaultConfig returns the reference topology: one client, 3 Renoters and 2 server relays.
+func DefaultConfig() Config {
+	return Config{Renoters: 3, Relays: 2, Client: client.DefaultOptions()}
+}
+
+// Topology is a running in-process network.
+type Topology struct {
+	// Server relays, and their URLs
+	Relays    []*server.TestRelay
+	RelayURLs []string
+	// Every Renoter, in the order they were started; the client draws the order of the hops
+	Path client.Path
+	// Relay local apps publish to, and the dispatcher behind it
+	ClientRelay *server.TestRelay
+	Dispatcher  *client.Dispatcher
+
+	mu       sync.Mutex
+	renoters []*server.Renoter
+	keys     []string
+	// Indexes of the Renoters and relays stopped
+	stoppedRenoters map[int]bool
+	stoppedRelays   map[int]bool
+}
+
+// Start launches the relays, the Renoters and the client relay of cfg and waits for their
+// subscriptions. Everything stops with Close.
+func Start(ctx context.Context, cfg Config) (*Topology, error) {
+	if cfg.Renoters < 1 || cfg.Relays < 1 {
+		return nil, fmt.Errorf("a topology needs at least one Renoter and one relay, got %d and %d", cfg.Renoters, cfg.Relays)
+	}
+	t := &Topology{stoppedRenoters: make(map[int]bool), stoppedRelays: make(map[int]bool)}
+	for i := 0; i < cfg.Relays; i++ {
+		relay, err := server.StartTestRelay(ctx)
+		if err != nil {
+			t.Close()
+			return nil, fmt.Errorf("failed to start relay %d: %w", i, err)
+		}
+		attachMemoryStore(relay.Relay())
+		t.Relays = append(t.Relays, relay)
+		t.RelayURLs = append(t.RelayURLs, relay.URL())
+	}
+
+	t.renoters = make([]*server.Renoter, cfg.Renoters)
+	t.keys = make([]string, cfg.Renoters)
+	for i := range t.renoters {
+		t.keys[i] = nostr.GeneratePrivateKey()
+		if err := t.startRenoter(ctx, i); err != nil {
+			t.Close()
+			return nil, err
+		}
+		pubkey, _ := hex.DecodeString(t.renoters[i].GetPublicKey())
+		t.Path = append(t.Path, client.NewPath(pubkey)...)
+	}
+
+	clientRelay, err := server.StartTestRelay(ctx)
+	if err != nil {
+		t.Close()
+		return nil, fmt.Errorf("failed to start client relay: %w", err)
+	}
+	t.ClientRelay = clientRelay
+	if cfg.FixedEntry {
+		cfg.Client.PathPolicy.Guards = map[string]bool{t.Path[0].Key(): true}
+	}
+	t.Dispatcher, err = client.StartDispatcher(ctx, t.Path, t.RelayURLs, cfg.Client)
+	if err != nil {
+		t.Close()
+		return nil, fmt.Errorf("failed to start dispatcher: %w", err)
+	}
+	client.AttachDispatcher(clientRelay.Relay(), t.Dispatcher)
+
+	time.Sleep(WarmUp)
+	logging.Info("e2e.topology.Start: Started %d relays, %d Renoters and a client relay at %s", len(t.Relays), len(t.renoters), clientRelay.URL())
+	return t, nil
+}
+
+// startRenoter creates the i-th Renoter with its key and subscribes it to the relays.
+func (t *Topology) startRenoter(ctx context.Context, i int) error {
+	renoter, err := server.NewRenoter(ctx, t.keys[i], t.RelayURLs)
+	if err != nil {
+		return fmt.Errorf("failed to create renoter %d: %w", i, err)
+	}
+	if err := renoter.SubscribeToWrappedEvents(ctx); err != nil {
+		renoter.Close(ctx)
+		return fmt.Errorf("failed to subscribe renoter %d: %w", i, err)
+	}
+	t.renoters[i] = renoter
+	return nil
+}
+
+// Renoter returns the i-th Renoter, or nil while it is stopped.
+func (t *Topology) Renoter(i int) *server.Renoter {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.stoppedRenoters[i] {
+		return nil
+	}
+	return t.renoters[i]
+}
+
+// StopRenoter stops the i-th Renoter, so containers addressed to it are lost.
+func (t *Topology) StopRenoter(ctx context.Context, i int) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.stoppedRenoters[i] {
+		return nil
+	}
+	t.stoppedRenoters[i] = true
+	return t.renoters[i].Close(ctx)
+}
+
+// RestartRenoter starts the i-th Renoter again with the same key. Like a restarted server, it
+// has forgotten its replay cache and only sees containers published from now on.
+func (t *Topology) RestartRenoter(ctx context.Context, i int) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.stoppedRenoters[i] {
+		return fmt.Errorf("renoter %d is running", i)
+	}
+	if err := t.startRenoter(ctx, i); err != nil {
+		return err
+	}
+	delete(t.stoppedRenoters, i)
+	time.Sleep(WarmUp)
+	return nil
+}
+
+// StopRelay shuts down the i-th server relay, as in an outage.
+func (t *Topology) StopRelay(ctx context.Context, i int) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.stoppedRelays[i] {
+		return nil
+	}
+	t.stoppedRelays[i] = true
+	return t.Relays[i].Stop(ctx)
+}
+
+// liveRelayURLs returns the URLs of the server relays still running.
+func (t *Topology) liveRelayURLs() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var urls []string
+	for i, url := range t.RelayURLs {
+		if !t.stoppedRelays[i] {
+			urls = append(urls, url)
+		}
+	}
+	return urls
+}
+
+// Publish publishes event to the client relay like a local app, returning its rejection if any.
+func (t *Topology) Publish(ctx context.Context, event *nostr.Event) error {
+	relay, err := nostr.RelayConnect(ctx, t.ClientRelay.URL())
+	if err != nil {
+		return fmt.Errorf("failed to connect to client relay: %w", err)
+	}
+	defer relay.Close()
+	return relay.Publish(ctx, *event)
+}
+
+// Delivered returns the URLs of the running server relays that hold the event with id.
+func (t *Topology) Delivered(ctx context.Context, id string) []string {
+	var urls []string
+	for _, url := range t.liveRelayURLs() {
+		relay, err := nostr.RelayConnect(ctx, url)
+		if err != nil {
+			continue
+		}
+		events, err := relay.QuerySync(ctx, nostr.Filter{IDs: []string{id}})
+		relay.Close()
+		if err == nil && len(events) > 0 {
+			urls = append(urls, url)
+		}
+	}
+	return urls
+}
+
+// AwaitDelivery polls the running server relays until the event with id is on at least want
+// of them, and returns the relays holding it then, or those holding it when timeout passes.
+func (t *Topology) AwaitDelivery(ctx context.Context, id string, want int, timeout time.Duration) []string {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		urls := t.Delivered(ctx, id)
+		if len(urls) >= want {
+			return urls
+		}
+		select {
+		case <-ctx.Done():
+			return urls
+		case <-ticker.C:
+		}
+	}
+}
+
+// Close stops the dispatcher, the Renoters still running and every relay.
+func (t *Topology) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if t.Dispatcher != nil {
+		t.Dispatcher.Close(ctx)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, renoter := range t.renoters {
+		if renoter != nil && !t.stoppedRenoters[i] {
+			renoter.Close(ctx)
+		}
+	}
+	if t.ClientRelay != nil {
+		t.ClientRelay.Stop(ctx)
+	}
+	for i, relay := range t.Relays {
+		if !t.stoppedRelays[i] {
+			relay.Stop(ctx)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package e2e
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/girino/renoter/internal/config"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// deliveryTimeout bounds how long an event may take through the path, mining included.
+const deliveryTimeout = 30 * time.Second
+
+// startTopology starts cfg for the test and closes it when the test ends.
+func startTopology(t *testing.T, ctx context.Context, cfg Config) *Topology {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("Skipping end-to-end test in short mode (mines PoW)")
+	}
+	topology, err := Start(ctx, cfg)
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	t.Cleanup(topology.Close)
+	return topology
+}
+
+func newNote(content string) *nostr.Event {
+	event := &nostr.Event{Kind: 1, Content: content, CreatedAt: nostr.Now(), Tags: nostr.Tags{}}
+	event.Sign(nostr.GeneratePrivateKey())
+	return event
+}
+
+func TestTopology_DeliversThroughEveryHop(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	topology := startTopology(t, ctx, DefaultConfig())
+
+	note := newNote("end to end")
+	if err := topology.Publish(ctx, note); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if got := topology.AwaitDelivery(ctx, note.ID, len(topology.Relays), deliveryTimeout); len(got) != len(topology.Relays) {
+		t.Fatalf("event delivered to %d relays, want %d", len(got), len(topology.Relays))
+	}
+	// Every hop handled the event once: all forwarded it but the exit, which published it
+	var forwarded, published int64
+	for i := range topology.Path {
+		stats := topology.Renoter(i).OutcomeStats()
+		if stats.Forwarded+stats.PublishedFinal != 1 {
+			t.Errorf("Renoter %d outcomes = %+v, want the event handled once", i, stats)
+		}
+		forwarded += stats.Forwarded
+		published += stats.PublishedFinal
+	}
+	if forwarded != int64(len(topology.Path)-1) || published != 1 {
+		t.Errorf("%d hops forwarded and %d published the event, want %d and 1", forwarded, published, len(topology.Path)-1)
+	}
+}
+
+func TestTopology_HopFailure(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	cfg := DefaultConfig()
+	cfg.Client.ResendTimeout = 3 * time.Second
+	cfg.Client.MaxResends = 5
+	cfg.FixedEntry = true
+	topology := startTopology(t, ctx, cfg)
+
+	// A hop after the entry is down: the event dies there
+	if err := topology.StopRenoter(ctx, 1); err != nil {
+		t.Fatalf("StopRenoter() error = %v", err)
+	}
+	note := newNote("through a broken hop")
+	if err := topology.Publish(ctx, note); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if got := topology.AwaitDelivery(ctx, note.ID, 1, 2*time.Second); len(got) != 0 {
+		t.Fatalf("event delivered to %v through a stopped hop", got)
+	}
+
+	// Once it is back, the client's resend gets through
+	if err := topology.RestartRenoter(ctx, 1); err != nil {
+		t.Fatalf("RestartRenoter() error = %v", err)
+	}
+	if got := topology.AwaitDelivery(ctx, note.ID, 1, deliveryTimeout); len(got) == 0 {
+		t.Fatal("event not delivered after the hop came back")
+	}
+}
+
+func TestTopology_RelayOutage(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	topology := startTopology(t, ctx, DefaultConfig())
+
+	if err := topology.StopRelay(ctx, 1); err != nil {
+		t.Fatalf("StopRelay() error = %v", err)
+	}
+	note := newNote("one relay down")
+	if err := topology.Publish(ctx, note); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if got := topology.AwaitDelivery(ctx, note.ID, 1, deliveryTimeout); len(got) != 1 || got[0] != topology.RelayURLs[0] {
+		t.Fatalf("event delivered to %v, want the surviving relay", got)
+	}
+}
+
+func TestTopology_Replay(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+	cfg := DefaultConfig()
+	cfg.FixedEntry = true
+	topology := startTopology(t, ctx, cfg)
+
+	// Capture the container the client publishes for the entry
+	pool := nostr.NewSimplePool(ctx)
+	containers := pool.SubscribeMany(ctx, topology.RelayURLs, nostr.Filter{
+		Kinds: []int{config.StandardizedWrapperKind},
+		Tags:  nostr.TagMap{"p": []string{topology.Path[0].Key()}},
+	})
+	time.Sleep(WarmUp)
+
+	note := newNote("replayed")
+	if err := topology.Publish(ctx, note); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	var container nostr.Event
+	select {
+	case relayEvent := <-containers:
+		container = *relayEvent.Event
+	case <-time.After(deliveryTimeout):
+		t.Fatal("no container published for the entry")
+	}
+	if got := topology.AwaitDelivery(ctx, note.ID, 1, deliveryTimeout); len(got) == 0 {
+		t.Fatal("event not delivered")
+	}
+
+	// Publishing the captured container again is caught by the entry's replay cache
+	for result := range pool.PublishMany(ctx, topology.RelayURLs, container) {
+		if result.Error != nil {
+			t.Fatalf("PublishMany() error = %v", result.Error)
+		}
+	}
+	time.Sleep(time.Second)
+	for i := range topology.Path {
+		stats := topology.Renoter(i).OutcomeStats()
+		if stats.Forwarded+stats.PublishedFinal != 1 {
+			t.Errorf("Renoter %d handled the event %d times after a replay, want once", i, stats.Forwarded+stats.PublishedFinal)
+		}
+	}
+}
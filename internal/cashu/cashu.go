@@ -0,0 +1,417 @@
+package cashu
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/bits"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/girino/nostr-lib/logging"
+)
+
+// UnitSat is the only token unit Renoters accept.
+const UnitSat = "sat"
+
+// tokenPrefix marks a serialized V3 token (NUT-00); newer CBOR (cashuB) tokens are not supported.
+const tokenPrefix = "cashuA"
+
+// domainSeparator prefixes secrets before they are hashed to a curve point (NUT-00).
+const domainSeparator = "Secp256k1_HashToCurve_Cashu_"
+
+// maxResponseSize bounds mint responses; a swap response grows with the number of outputs.
+const maxResponseSize = 256 * 1024
+
+// Proof is a single ecash note (NUT-00).
+type Proof struct {
+	Amount uint64 `json:"amount"`
+	ID     string `json:"id"`
+	Secret string `json:"secret"`
+	C      string `json:"C"`
+}
+
+// TokenEntry holds the proofs of a token issued by one mint.
+type TokenEntry struct {
+	Mint   string  `json:"mint"`
+	Proofs []Proof `json:"proofs"`
+}
+
+// Token is a V3 (cashuA) token.
+type Token struct {
+	Token []TokenEntry `json:"token"`
+	Unit  string       `json:"unit,omitempty"`
+	Memo  string       `json:"memo,omitempty"`
+}
+
+// Keyset describes one of a mint's keysets (NUT-02).
+type Keyset struct {
+	ID          string `json:"id"`
+	Unit        string `json:"unit"`
+	Active      bool   `json:"active"`
+	InputFeePPK uint64 `json:"input_fee_ppk"`
+}
+
+// BlindedMessage is an output sent to the mint for signing (NUT-00).
+type BlindedMessage struct {
+	Amount uint64 `json:"amount"`
+	ID     string `json:"id"`
+	B      string `json:"B_"`
+}
+
+// BlindSignature is the mint's signature on a BlindedMessage (NUT-00).
+type BlindSignature struct {
+	Amount uint64 `json:"amount"`
+	ID     string `json:"id"`
+	C      string `json:"C_"`
+}
+
+// mintError is the error body returned by mints.
+type mintError struct {
+	Detail string `json:"detail"`
+	Code   int    `json:"code"`
+}
+
+// Decode parses a serialized cashuA token, optionally prefixed with "cashu:".
+func Decode(s string) (*Token, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "cashu:")
+	encoded, ok := strings.CutPrefix(s, tokenPrefix)
+	if !ok {
+		return nil, fmt.Errorf("unsupported token format, expected a %s token", tokenPrefix)
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(encoded, "="))
+	if err != nil {
+		return nil, fmt.Errorf("invalid token encoding: %w", err)
+	}
+	var token Token
+	if err := json.Unmarshal(raw, &token); err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+	if len(token.Token) == 0 {
+		return nil, fmt.Errorf("token has no proofs")
+	}
+	return &token, nil
+}
+
+// Encode serializes the token as a cashuA string.
+func (t *Token) Encode() (string, error) {
+	raw, err := json.Marshal(t)
+	if err != nil {
+		return "", err
+	}
+	return tokenPrefix + base64.RawURLEncoding.EncodeToString(raw), nil
+}
+
+// Amount returns the total value of the token's proofs. Proof amounts come from the sender, so a
+// total that does not fit in a uint64 is an error rather than wrapping around to a small value.
+func (t *Token) Amount() (uint64, error) {
+	var total uint64
+	for _, entry := range t.Token {
+		for _, proof := range entry.Proofs {
+			var carry uint64
+			total, carry = bits.Add64(total, proof.Amount, 0)
+			if carry != 0 {
+				return 0, fmt.Errorf("token amount overflows")
+			}
+		}
+	}
+	return total, nil
+}
+
+// Mint returns the URL of the mint that issued the token. Tokens mixing mints are rejected,
+// since they cannot be redeemed in a single swap.
+func (t *Token) Mint() (string, error) {
+	mint := ""
+	for _, entry := range t.Token {
+		if mint != "" && entry.Mint != mint {
+			return "", fmt.Errorf("token mixes proofs from several mints")
+		}
+		mint = entry.Mint
+	}
+	if mint == "" {
+		return "", fmt.Errorf("token has no mint")
+	}
+	return mint, nil
+}
+
+// Proofs returns all proofs of the token.
+func (t *Token) Proofs() []Proof {
+	var proofs []Proof
+	for _, entry := range t.Token {
+		proofs = append(proofs, entry.Proofs...)
+	}
+	return proofs
+}
+
+// HashToCurve maps a message to a secp256k1 point (NUT-00 hash_to_curve).
+func HashToCurve(message []byte) (*btcec.PublicKey, error) {
+	msgHash := sha256.Sum256(append([]byte(domainSeparator), message...))
+	counter := make([]byte, 4)
+	for i := uint32(0); i < 1<<16; i++ {
+		binary.LittleEndian.PutUint32(counter, i)
+		hash := sha256.Sum256(append(msgHash[:], counter...))
+		if point, err := btcec.ParsePubKey(append([]byte{0x02}, hash[:]...)); err == nil {
+			return point, nil
+		}
+	}
+	return nil, fmt.Errorf("no valid point found")
+}
+
+// Keysets fetches the keysets of a mint (NUT-02).
+func Keysets(ctx context.Context, client *http.Client, mintURL string) ([]Keyset, error) {
+	var response struct {
+		Keysets []Keyset `json:"keysets"`
+	}
+	if err := doJSON(ctx, client, http.MethodGet, mintURL+"/v1/keysets", nil, &response); err != nil {
+		return nil, fmt.Errorf("failed to fetch keysets: %w", err)
+	}
+	return response.Keysets, nil
+}
+
+// Keys fetches the public key for each amount of a mint's keyset (NUT-01).
+func Keys(ctx context.Context, client *http.Client, mintURL, keysetID string) (map[uint64]*btcec.PublicKey, error) {
+	var response struct {
+		Keysets []struct {
+			ID   string            `json:"id"`
+			Keys map[string]string `json:"keys"`
+		} `json:"keysets"`
+	}
+	if err := doJSON(ctx, client, http.MethodGet, mintURL+"/v1/keys/"+keysetID, nil, &response); err != nil {
+		return nil, fmt.Errorf("failed to fetch keys: %w", err)
+	}
+	for _, keyset := range response.Keysets {
+		if keyset.ID != keysetID {
+			continue
+		}
+		keys := make(map[uint64]*btcec.PublicKey, len(keyset.Keys))
+		for amount, pubkey := range keyset.Keys {
+			value, err := strconv.ParseUint(amount, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid key amount %q", amount)
+			}
+			raw, err := hex.DecodeString(pubkey)
+			if err != nil {
+				return nil, fmt.Errorf("invalid key for amount %d", value)
+			}
+			if keys[value], err = btcec.ParsePubKey(raw); err != nil {
+				return nil, fmt.Errorf("invalid key for amount %d: %w", value, err)
+			}
+		}
+		return keys, nil
+	}
+	return nil, fmt.Errorf("mint did not return keyset %s", keysetID)
+}
+
+// Swap exchanges inputs for signatures on outputs (NUT-03). The mint marks the inputs as spent,
+// so a successful swap both validates and redeems them.
+func Swap(ctx context.Context, client *http.Client, mintURL string, inputs []Proof, outputs []BlindedMessage) ([]BlindSignature, error) {
+	request := struct {
+		Inputs  []Proof          `json:"inputs"`
+		Outputs []BlindedMessage `json:"outputs"`
+	}{inputs, outputs}
+	var response struct {
+		Signatures []BlindSignature `json:"signatures"`
+	}
+	if err := doJSON(ctx, client, http.MethodPost, mintURL+"/v1/swap", request, &response); err != nil {
+		return nil, fmt.Errorf("swap failed: %w", err)
+	}
+	if len(response.Signatures) != len(outputs) {
+		return nil, fmt.Errorf("mint returned %d signatures for %d outputs", len(response.Signatures), len(outputs))
+	}
+	return response.Signatures, nil
+}
+
+// Redeem swaps every proof of token for fresh proofs from the same mint, so the sender can no
+// longer spend them, and returns the new token. Its value is the token's minus the mint's input fee.
+func Redeem(ctx context.Context, client *http.Client, token *Token) (*Token, error) {
+	mintURL, err := token.Mint()
+	if err != nil {
+		return nil, err
+	}
+	unit := token.Unit
+	if unit == "" {
+		unit = UnitSat
+	}
+	inputs := token.Proofs()
+
+	keysets, err := Keysets(ctx, client, mintURL)
+	if err != nil {
+		return nil, err
+	}
+	var active *Keyset
+	feePPK := make(map[string]uint64, len(keysets))
+	for i, keyset := range keysets {
+		feePPK[keyset.ID] = keyset.InputFeePPK
+		if keyset.Active && keyset.Unit == unit && active == nil {
+			active = &keysets[i]
+		}
+	}
+	if active == nil {
+		return nil, fmt.Errorf("mint %s has no active %s keyset", mintURL, unit)
+	}
+
+	// Input fees are charged per proof in parts per thousand, rounded up (NUT-02)
+	var totalFeePPK uint64
+	for _, proof := range inputs {
+		fee, ok := feePPK[proof.ID]
+		if !ok {
+			return nil, fmt.Errorf("proof keyset %s is unknown to mint %s", proof.ID, mintURL)
+		}
+		totalFeePPK += fee
+	}
+	fee := (totalFeePPK + 999) / 1000
+	value, err := token.Amount()
+	if err != nil {
+		return nil, err
+	}
+	if value <= fee {
+		return nil, fmt.Errorf("token value %d does not cover the mint fee %d", value, fee)
+	}
+
+	keys, err := Keys(ctx, client, mintURL, active.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	amounts := splitAmount(value - fee)
+	outputs := make([]BlindedMessage, len(amounts))
+	secrets := make([]string, len(amounts))
+	blindingFactors := make([]*btcec.ModNScalar, len(amounts))
+	for i, amount := range amounts {
+		if keys[amount] == nil {
+			return nil, fmt.Errorf("mint keyset %s has no key for amount %d", active.ID, amount)
+		}
+		secrets[i], blindingFactors[i], outputs[i], err = blindOutput(amount, active.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	signatures, err := Swap(ctx, client, mintURL, inputs, outputs)
+	if err != nil {
+		return nil, err
+	}
+
+	proofs := make([]Proof, len(signatures))
+	for i, signature := range signatures {
+		if signature.Amount != amounts[i] {
+			return nil, fmt.Errorf("mint signed amount %d, requested %d", signature.Amount, amounts[i])
+		}
+		c, err := unblind(signature.C, blindingFactors[i], keys[amounts[i]])
+		if err != nil {
+			return nil, err
+		}
+		proofs[i] = Proof{Amount: amounts[i], ID: signature.ID, Secret: secrets[i], C: c}
+	}
+
+	logging.DebugMethod("cashu", "Redeem", "Redeemed %d %s from %s (fee %d)", value, unit, mintURL, fee)
+	return &Token{Token: []TokenEntry{{Mint: mintURL, Proofs: proofs}}, Unit: unit}, nil
+}
+
+// splitAmount decomposes amount into powers of two, the denominations mints sign.
+func splitAmount(amount uint64) []uint64 {
+	var amounts []uint64
+	for bit := uint64(1); amount > 0; bit <<= 1 {
+		if amount&bit != 0 {
+			amounts = append(amounts, bit)
+			amount &^= bit
+		}
+	}
+	return amounts
+}
+
+// blindOutput creates a random secret and its blinded message B_ = hash_to_curve(secret) + rG.
+func blindOutput(amount uint64, keysetID string) (string, *btcec.ModNScalar, BlindedMessage, error) {
+	secretBytes := make([]byte, 32)
+	if _, err := rand.Read(secretBytes); err != nil {
+		return "", nil, BlindedMessage{}, err
+	}
+	secret := hex.EncodeToString(secretBytes)
+	y, err := HashToCurve([]byte(secret))
+	if err != nil {
+		return "", nil, BlindedMessage{}, err
+	}
+	r, err := btcec.NewPrivateKey()
+	if err != nil {
+		return "", nil, BlindedMessage{}, err
+	}
+
+	var yPoint, rG, blinded btcec.JacobianPoint
+	y.AsJacobian(&yPoint)
+	btcec.ScalarBaseMultNonConst(&r.Key, &rG)
+	btcec.AddNonConst(&yPoint, &rG, &blinded)
+	blinded.ToAffine()
+	b := btcec.NewPublicKey(&blinded.X, &blinded.Y).SerializeCompressed()
+	return secret, &r.Key, BlindedMessage{Amount: amount, ID: keysetID, B: hex.EncodeToString(b)}, nil
+}
+
+// unblind turns the mint's signature C_ into the proof signature C = C_ - rK.
+func unblind(blindSignature string, r *btcec.ModNScalar, mintKey *btcec.PublicKey) (string, error) {
+	raw, err := hex.DecodeString(blindSignature)
+	if err != nil {
+		return "", fmt.Errorf("invalid blind signature")
+	}
+	cBlind, err := btcec.ParsePubKey(raw)
+	if err != nil {
+		return "", fmt.Errorf("invalid blind signature: %w", err)
+	}
+
+	var cPoint, keyPoint, rK, c btcec.JacobianPoint
+	cBlind.AsJacobian(&cPoint)
+	mintKey.AsJacobian(&keyPoint)
+	btcec.ScalarMultNonConst(r, &keyPoint, &rK)
+	rK.ToAffine()
+	rK.Y.Negate(1).Normalize()
+	btcec.AddNonConst(&cPoint, &rK, &c)
+	c.ToAffine()
+	return hex.EncodeToString(btcec.NewPublicKey(&c.X, &c.Y).SerializeCompressed()), nil
+}
+
+func doJSON(ctx context.Context, client *http.Client, method, target string, body, v any) error {
+	var reader io.Reader
+	if body != nil {
+		payload, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	logging.DebugMethod("cashu", "doJSON", "%s %s returned status %d", method, target, resp.StatusCode)
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		var mintErr mintError
+		if json.Unmarshal(raw, &mintErr) == nil && mintErr.Detail != "" {
+			return fmt.Errorf("mint error %d: %s", mintErr.Code, mintErr.Detail)
+		}
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("invalid response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+package cashu
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+)
+
+func TestHashToCurve(t *testing.T) {
+	// Test vectors from NUT-00
+	tests := []struct {
+		message string
+		want    string
+	}{
+		{"0000000000000000000000000000000000000000000000000000000000000000", "024cce997d3b518f739663b757deaec95bcd9473c30a14ac2fd04023a739d1a725"},
+		{"0000000000000000000000000000000000000000000000000000000000000001", "022e7158e11c9506f1aa4248bf531298daa7febd6194f003edcd9b93ade6253acf"},
+	}
+	for _, tt := range tests {
+		message, _ := hex.DecodeString(tt.message)
+		point, err := HashToCurve(message)
+		if err != nil {
+			t.Fatalf("HashToCurve(%s) error = %v", tt.message, err)
+		}
+		if got := hex.EncodeToString(point.SerializeCompressed()); got != tt.want {
+			t.Errorf("HashToCurve(%s) = %s, want %s", tt.message, got, tt.want)
+		}
+	}
+}
+
+func TestTokenEncodeDecode(t *testing.T) {
+	token := &Token{
+		Token: []TokenEntry{{Mint: "https://mint.example.com", Proofs: []Proof{{Amount: 2, ID: "009a1f293253e41e", Secret: "s1", C: "02aa"}, {Amount: 8, ID: "009a1f293253e41e", Secret: "s2", C: "02bb"}}}},
+		Unit:  UnitSat,
+	}
+	encoded, err := token.Encode()
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	if !strings.HasPrefix(encoded, "cashuA") {
+		t.Errorf("Encode() = %q, want a cashuA token", encoded)
+	}
+
+	decoded, err := Decode("cashu:" + encoded)
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if amount, err := decoded.Amount(); err != nil || amount != 10 {
+		t.Errorf("Amount() = %d, %v, want 10", amount, err)
+	}
+	overflowing := &Token{Token: []TokenEntry{{Proofs: []Proof{{Amount: math.MaxUint64}, {Amount: 2}}}}}
+	if _, err := overflowing.Amount(); err == nil {
+		t.Error("Amount() should reject proofs summing past the uint64 range")
+	}
+	if mint, err := decoded.Mint(); err != nil || mint != "https://mint.example.com" {
+		t.Errorf("Mint() = %q, %v", mint, err)
+	}
+
+	if _, err := Decode("cashuBo2F0gaJhaUgA"); err == nil {
+		t.Error("Decode() should reject cashuB tokens")
+	}
+	mixed := &Token{Token: []TokenEntry{{Mint: "https://a.example.com"}, {Mint: "https://b.example.com"}}}
+	if _, err := mixed.Mint(); err == nil {
+		t.Error("Mint() should reject tokens from several mints")
+	}
+}
+
+func TestSplitAmount(t *testing.T) {
+	got := splitAmount(13)
+	want := []uint64{1, 4, 8}
+	if len(got) != len(want) {
+		t.Fatalf("splitAmount(13) = %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("splitAmount(13) = %v, want %v", got, want)
+		}
+	}
+}
+
+// fakeMint is a minimal single-keyset mint implementing the NUT-01/02/03 endpoints.
+type fakeMint struct {
+	keysetID    string
+	inputFeePPK uint64
+	keys        map[uint64]*btcec.PrivateKey
+
+	mu    sync.Mutex
+	spent map[string]bool
+}
+
+func newFakeMint(t *testing.T, inputFeePPK uint64) (*fakeMint, *httptest.Server) {
+	t.Helper()
+	mint := &fakeMint{keysetID: "00ffd48b8f5ecf80", inputFeePPK: inputFeePPK, keys: make(map[uint64]*btcec.PrivateKey), spent: make(map[string]bool)}
+	for amount := uint64(1); amount <= 1024; amount <<= 1 {
+		key, err := btcec.NewPrivateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		mint.keys[amount] = key
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/keysets", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keysets": []Keyset{{ID: mint.keysetID, Unit: UnitSat, Active: true, InputFeePPK: mint.inputFeePPK}}})
+	})
+	mux.HandleFunc("/v1/keys/"+mint.keysetID, func(w http.ResponseWriter, r *http.Request) {
+		keys := make(map[string]string)
+		for amount, key := range mint.keys {
+			keys[strconv.FormatUint(amount, 10)] = hex.EncodeToString(key.PubKey().SerializeCompressed())
+		}
+		json.NewEncoder(w).Encode(map[string]any{"keysets": []map[string]any{{"id": mint.keysetID, "unit": UnitSat, "keys": keys}}})
+	})
+	mux.HandleFunc("/v1/swap", mint.handleSwap)
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return mint, server
+}
+
+// sign returns k*point for the key of amount.
+func (m *fakeMint) sign(amount uint64, point *btcec.PublicKey) string {
+	var p, result btcec.JacobianPoint
+	point.AsJacobian(&p)
+	btcec.ScalarMultNonConst(&m.keys[amount].Key, &p, &result)
+	result.ToAffine()
+	return hex.EncodeToString(btcec.NewPublicKey(&result.X, &result.Y).SerializeCompressed())
+}
+
+// issue creates a token the mint will accept, as if it had been minted.
+func (m *fakeMint) issue(t *testing.T, mintURL string, amounts ...uint64) *Token {
+	t.Helper()
+	var proofs []Proof
+	for i, amount := range amounts {
+		secret := "issued-" + strconv.Itoa(i) + "-" + t.Name()
+		y, err := HashToCurve([]byte(secret))
+		if err != nil {
+			t.Fatal(err)
+		}
+		proofs = append(proofs, Proof{Amount: amount, ID: m.keysetID, Secret: secret, C: m.sign(amount, y)})
+	}
+	return &Token{Token: []TokenEntry{{Mint: mintURL, Proofs: proofs}}, Unit: UnitSat}
+}
+
+func (m *fakeMint) handleSwap(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Inputs  []Proof          `json:"inputs"`
+		Outputs []BlindedMessage `json:"outputs"`
+	}
+	fail := func(code int, detail string) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(mintError{Detail: detail, Code: code})
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		fail(10000, "invalid request")
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var in, out uint64
+	for _, proof := range request.Inputs {
+		y, _ := HashToCurve([]byte(proof.Secret))
+		if m.keys[proof.Amount] == nil || proof.C != m.sign(proof.Amount, y) {
+			fail(10003, "invalid proof")
+			return
+		}
+		if m.spent[proof.Secret] {
+			fail(11001, "Token already spent.")
+			return
+		}
+		in += proof.Amount
+	}
+	for _, output := range request.Outputs {
+		out += output.Amount
+	}
+	fee := (uint64(len(request.Inputs))*m.inputFeePPK + 999) / 1000
+	if in != out+fee {
+		fail(11002, "Transaction is not balanced.")
+		return
+	}
+
+	signatures := make([]BlindSignature, len(request.Outputs))
+	for i, output := range request.Outputs {
+		raw, _ := hex.DecodeString(output.B)
+		b, err := btcec.ParsePubKey(raw)
+		if err != nil || m.keys[output.Amount] == nil {
+			fail(10001, "invalid output")
+			return
+		}
+		signatures[i] = BlindSignature{Amount: output.Amount, ID: m.keysetID, C: m.sign(output.Amount, b)}
+	}
+	for _, proof := range request.Inputs {
+		m.spent[proof.Secret] = true
+	}
+	json.NewEncoder(w).Encode(map[string]any{"signatures": signatures})
+}
+
+func TestRedeem(t *testing.T) {
+	mint, server := newFakeMint(t, 100)
+	ctx := context.Background()
+	token := mint.issue(t, server.URL, 8, 4, 2)
+
+	redeemed, err := Redeem(ctx, server.Client(), token)
+	if err != nil {
+		t.Fatalf("Redeem() error = %v", err)
+	}
+	// Three inputs at 100 ppk cost a 1 sat fee
+	if amount, _ := redeemed.Amount(); amount != 13 {
+		t.Errorf("redeemed amount = %d, want 13", amount)
+	}
+
+	if _, err := Redeem(ctx, server.Client(), token); err == nil || !strings.Contains(err.Error(), "already spent") {
+		t.Errorf("Redeem() of a spent token error = %v, want already spent", err)
+	}
+
+	// The redeemed proofs are valid: the mint accepts them in another swap
+	if again, err := Redeem(ctx, server.Client(), redeemed); err != nil {
+		t.Errorf("Redeem() of redeemed proofs error = %v", err)
+	} else if amount, _ := again.Amount(); amount != 12 {
+		t.Errorf("second redeemed amount = %d, want 12", amount)
+	}
+}
@@ -0,0 +1,181 @@
+// Package compact implements the binary encoding of the events inside 29000 layers.
+//
+// JSON-in-JSON spends most of every layer on hex keys and signatures, field names and the
+// base64 ciphertext of the layer below. The compact form stores keys and signatures as raw
+// bytes and base64 content (every 29000's NIP-44 payload) decoded, so each layer costs about a
+// quarter less than the one it wraps. Decoding yields the exact event that was encoded, so
+// its ID and signature still verify.
+package compact
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Magic is the first byte of every compact event. Serialized JSON events start with '{',
+// so a layer's plaintext tells which encoding it uses.
+const Magic = 0x00
+
+// How the content is stored
+const (
+	contentRaw    = 0x00
+	contentBase64 = 0x01
+)
+
+// Is reports whether data holds a compact event rather than JSON.
+func Is(data []byte) bool {
+	return len(data) > 0 && data[0] == Magic
+}
+
+// Encode serializes a signed event in the compact form. The event must have a hex ID,
+// pubkey and signature of the standard lengths.
+func Encode(event *nostr.Event) ([]byte, error) {
+	out := []byte{Magic}
+	for _, field := range []struct {
+		name  string
+		value string
+		size  int
+	}{{"id", event.ID, 32}, {"pubkey", event.PubKey, 32}, {"sig", event.Sig, 64}} {
+		raw, err := hex.DecodeString(field.value)
+		if err != nil || len(raw) != field.size {
+			return nil, fmt.Errorf("event %s is not %d bytes of hex", field.name, field.size)
+		}
+		out = append(out, raw...)
+	}
+	if event.CreatedAt < 0 || event.Kind < 0 {
+		return nil, fmt.Errorf("event has a negative created_at or kind")
+	}
+	out = binary.AppendUvarint(out, uint64(event.CreatedAt))
+	out = binary.AppendUvarint(out, uint64(event.Kind))
+
+	// Base64 content is stored decoded when it encodes back to exactly the same string
+	if decoded, err := base64.StdEncoding.DecodeString(event.Content); err == nil && base64.StdEncoding.EncodeToString(decoded) == event.Content {
+		out = append(out, contentBase64)
+		out = appendBytes(out, decoded)
+	} else {
+		out = append(out, contentRaw)
+		out = appendBytes(out, []byte(event.Content))
+	}
+
+	out = binary.AppendUvarint(out, uint64(len(event.Tags)))
+	for _, tag := range event.Tags {
+		out = binary.AppendUvarint(out, uint64(len(tag)))
+		for _, value := range tag {
+			out = appendBytes(out, []byte(value))
+		}
+	}
+	return out, nil
+}
+
+// LengthPrefix returns how many bytes the length prefix of an n-byte field takes.
+func LengthPrefix(n int) int {
+	return len(binary.AppendUvarint(nil, uint64(n)))
+}
+
+// Decode parses a compact event produced by Encode.
+func Decode(data []byte) (*nostr.Event, error) {
+	d := decoder{data: data}
+	if magic := d.byte(); magic != Magic {
+		return nil, fmt.Errorf("not a compact event (first byte 0x%02x)", magic)
+	}
+	event := &nostr.Event{
+		ID:     hex.EncodeToString(d.next(32)),
+		PubKey: hex.EncodeToString(d.next(32)),
+		Sig:    hex.EncodeToString(d.next(64)),
+	}
+	event.CreatedAt = nostr.Timestamp(d.uvarint())
+	event.Kind = int(d.uvarint())
+
+	switch flag, content := d.byte(), d.bytes(); flag {
+	case contentRaw:
+		event.Content = string(content)
+	case contentBase64:
+		event.Content = base64.StdEncoding.EncodeToString(content)
+	default:
+		if d.err == nil {
+			d.err = fmt.Errorf("unknown content encoding 0x%02x", flag)
+		}
+	}
+
+	count := d.uvarint()
+	event.Tags = make(nostr.Tags, 0, min(count, uint64(len(data))))
+	for i := uint64(0); i < count && d.err == nil; i++ {
+		values := d.uvarint()
+		tag := make(nostr.Tag, 0, min(values, uint64(len(data))))
+		for j := uint64(0); j < values && d.err == nil; j++ {
+			tag = append(tag, string(d.bytes()))
+		}
+		event.Tags = append(event.Tags, tag)
+	}
+
+	if d.err != nil {
+		return nil, fmt.Errorf("malformed compact event: %w", d.err)
+	}
+	if len(d.data) > 0 {
+		return nil, fmt.Errorf("malformed compact event: %d trailing bytes", len(d.data))
+	}
+	return event, nil
+}
+
+// appendBytes appends b prefixed with its length.
+func appendBytes(out, b []byte) []byte {
+	out = binary.AppendUvarint(out, uint64(len(b)))
+	return append(out, b...)
+}
+
+var errTruncated = errors.New("truncated")
+
+// decoder reads fields from data, remembering the first error so callers check once at the end.
+type decoder struct {
+	data []byte
+	err  error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(d.data) {
+		d.err = errTruncated
+		return nil
+	}
+	b := d.data[:n]
+	d.data = d.data[n:]
+	return b
+}
+
+func (d *decoder) byte() byte {
+	if b := d.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.data)
+	if n <= 0 {
+		d.err = errTruncated
+		return 0
+	}
+	d.data = d.data[n:]
+	return v
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.uvarint()
+	if n > uint64(len(d.data)) {
+		if d.err == nil {
+			d.err = errTruncated
+		}
+		return nil
+	}
+	return d.next(int(n))
+}
@@ -0,0 +1,110 @@
+package compact
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip44"
+)
+
+func TestEncode_RoundTrip(t *testing.T) {
+	conversationKey, _ := nip44.GenerateConversationKey(mustPubkey(t), nostr.GeneratePrivateKey())
+	ciphertext, _ := nip44.Encrypt(strings.Repeat("x", 500), conversationKey)
+
+	tests := []struct {
+		name    string
+		content string
+		tags    nostr.Tags
+	}{
+		{"text note", "hello <world> & \"quotes\" éè\n", nostr.Tags{{"t", "nostr"}, {"e", strings.Repeat("ab", 32), "wss://relay.example.com"}}},
+		{"wrapper", ciphertext, nostr.Tags{{"p", mustPubkey(t)}, {"nonce", "12345", "16"}}},
+		{"base64 lookalike", "YWJj", nil},
+		{"unpadded base64", "YWI", nostr.Tags{{}}},
+		{"empty", "", nostr.Tags{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			event := &nostr.Event{Kind: 29000, Content: tt.content, CreatedAt: nostr.Now(), Tags: tt.tags}
+			event.Sign(nostr.GeneratePrivateKey())
+
+			encoded, err := Encode(event)
+			if err != nil {
+				t.Fatalf("Encode() error = %v", err)
+			}
+			if !Is(encoded) {
+				t.Error("Is() = false for a compact event")
+			}
+			decoded, err := Decode(encoded)
+			if err != nil {
+				t.Fatalf("Decode() error = %v", err)
+			}
+			if decoded.ID != event.ID || decoded.PubKey != event.PubKey || decoded.Sig != event.Sig ||
+				decoded.CreatedAt != event.CreatedAt || decoded.Kind != event.Kind || decoded.Content != event.Content {
+				t.Errorf("Decode() = %+v, want %+v", decoded, event)
+			}
+			if valid, err := decoded.CheckSignature(); err != nil || !valid {
+				t.Errorf("decoded event signature invalid: %v", err)
+			}
+
+			eventJSON, _ := json.Marshal(event)
+			if len(encoded) >= len(eventJSON) {
+				t.Errorf("compact size %d is not smaller than JSON size %d", len(encoded), len(eventJSON))
+			}
+		})
+	}
+}
+
+func TestEncode_ShrinksWrappers(t *testing.T) {
+	conversationKey, _ := nip44.GenerateConversationKey(mustPubkey(t), nostr.GeneratePrivateKey())
+	ciphertext, _ := nip44.Encrypt(strings.Repeat("x", 8000), conversationKey)
+	event := &nostr.Event{Kind: 29000, Content: ciphertext, CreatedAt: nostr.Now(), Tags: nostr.Tags{{"p", mustPubkey(t)}}}
+	event.Sign(nostr.GeneratePrivateKey())
+
+	encoded, _ := Encode(event)
+	eventJSON, _ := json.Marshal(event)
+	// Base64 content is stored decoded, so a wrapper shrinks by about a quarter
+	if len(encoded) > len(eventJSON)*4/5 {
+		t.Errorf("compact wrapper is %d bytes, JSON %d; want at least a fifth smaller", len(encoded), len(eventJSON))
+	}
+}
+
+func TestEncode_RejectsUnsigned(t *testing.T) {
+	if _, err := Encode(&nostr.Event{Kind: 1, Content: "unsigned"}); err == nil {
+		t.Error("Encode() of an unsigned event should fail")
+	}
+}
+
+func TestDecode_Malformed(t *testing.T) {
+	event := &nostr.Event{Kind: 1, Content: "hello", CreatedAt: nostr.Now(), Tags: nostr.Tags{{"t", "x"}}}
+	event.Sign(nostr.GeneratePrivateKey())
+	encoded, _ := Encode(event)
+
+	if Is([]byte(`{"kind":1}`)) {
+		t.Error("Is() = true for JSON")
+	}
+	for i := 0; i < len(encoded); i++ {
+		if _, err := Decode(encoded[:i]); err == nil {
+			t.Fatalf("Decode() of %d of %d bytes should fail", i, len(encoded))
+		}
+	}
+	if _, err := Decode(append(encoded, 0)); err == nil {
+		t.Error("Decode() with trailing bytes should fail")
+	}
+	// A tag count far beyond the data must not allocate or loop for it
+	huge := append(encoded[:len(encoded)-6:len(encoded)-6], 0xff, 0xff, 0xff, 0xff, 0x0f)
+	if _, err := Decode(huge); err == nil {
+		t.Error("Decode() with an oversized tag count should fail")
+	}
+}
+
+func mustPubkey(t *testing.T) string {
+	t.Helper()
+	pubkey, err := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	if err != nil {
+		t.Fatalf("GetPublicKey() error = %v", err)
+	}
+	return pubkey
+}
@@ -0,0 +1,115 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Check is the outcome of one check of a check-config report.
+type Check struct {
+	Name   string
+	OK     bool
+	Detail string
+}
+
+// Report collects the checks of a configuration, for the check-config subcommands.
+type Report struct {
+	Checks []Check
+}
+
+// Pass records a check that passed.
+func (r *Report) Pass(name, format string, args ...any) {
+	r.Checks = append(r.Checks, Check{Name: name, OK: true, Detail: fmt.Sprintf(format, args...)})
+}
+
+// Fail records a check that failed.
+func (r *Report) Fail(name string, err error) {
+	r.Checks = append(r.Checks, Check{Name: name, Detail: err.Error()})
+}
+
+// Result records a check that passed if err is nil, with the given detail, and failed otherwise.
+func (r *Report) Result(name string, err error, format string, args ...any) {
+	if err != nil {
+		r.Fail(name, err)
+		return
+	}
+	r.Pass(name, format, args...)
+}
+
+// OK reports whether every check passed.
+func (r *Report) OK() bool {
+	for _, check := range r.Checks {
+		if !check.OK {
+			return false
+		}
+	}
+	return true
+}
+
+// Print writes one line per check followed by a summary.
+func (r *Report) Print(w io.Writer) {
+	failed := 0
+	for _, check := range r.Checks {
+		status := "ok  "
+		if !check.OK {
+			status = "FAIL"
+			failed++
+		}
+		fmt.Fprintf(w, "%s %s: %s\n", status, check.Name, check.Detail)
+	}
+	if failed > 0 {
+		fmt.Fprintf(w, "%d of %d checks failed\n", failed, len(r.Checks))
+	} else {
+		fmt.Fprintf(w, "all %d checks passed\n", len(r.Checks))
+	}
+}
+
+// ValidateRelayURL checks that relayURL is a ws:// or wss:// URL with a host.
+func ValidateRelayURL(relayURL string) error {
+	parsed, err := url.Parse(relayURL)
+	if err != nil {
+		return fmt.Errorf("invalid relay URL %q: %w", relayURL, err)
+	}
+	if parsed.Scheme != "ws" && parsed.Scheme != "wss" {
+		return fmt.Errorf("relay URL %q must start with ws:// or wss://", relayURL)
+	}
+	if parsed.Host == "" {
+		return fmt.Errorf("relay URL %q has no host", relayURL)
+	}
+	return nil
+}
+
+// CheckRelays adds a check per relay URL to the report and, with dial, connects to each relay
+// for at most timeout.
+func (r *Report) CheckRelays(ctx context.Context, name string, relayURLs []string, dial bool, timeout time.Duration) {
+	if len(relayURLs) == 0 {
+		r.Fail(name, fmt.Errorf("no relays configured"))
+		return
+	}
+	for _, relayURL := range relayURLs {
+		check := fmt.Sprintf("%s %s", name, relayURL)
+		if err := ValidateRelayURL(relayURL); err != nil {
+			r.Fail(check, err)
+			continue
+		}
+		if !dial {
+			r.Pass(check, "valid URL")
+			continue
+		}
+		dialCtx, cancel := context.WithTimeout(ctx, timeout)
+		started := time.Now()
+		relay, err := nostr.RelayConnect(dialCtx, relayURL)
+		cancel()
+		if err != nil {
+			r.Fail(check, fmt.Errorf("failed to connect: %w", err))
+			continue
+		}
+		relay.Close()
+		r.Pass(check, "connected in %v", time.Since(started).Round(time.Millisecond))
+	}
+}
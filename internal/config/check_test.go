@@ -0,0 +1,58 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateRelayURL(t *testing.T) {
+	for url, valid := range map[string]bool{
+		"wss://relay.example.com":     true,
+		"ws://localhost:7777/path":    true,
+		"https://relay.example.com":   false,
+		"relay.example.com":           false,
+		"wss://":                      false,
+		"wss://relay.example.com/%zz": false,
+	} {
+		if err := ValidateRelayURL(url); (err == nil) != valid {
+			t.Errorf("ValidateRelayURL(%q) error = %v, want valid = %v", url, err, valid)
+		}
+	}
+}
+
+func TestReport(t *testing.T) {
+	var report Report
+	report.Pass("size limits", "%d bytes", 32768)
+	report.Result("path", nil, "%d Renoters", 3)
+	if !report.OK() {
+		t.Error("OK() = false with only passing checks")
+	}
+	report.CheckRelays(context.Background(), "relay", []string{"wss://relay.example.com", "http://relay.example.com"}, false, time.Second)
+	report.Result("journal", errors.New("unreadable"), "")
+	if report.OK() {
+		t.Error("OK() = true with failing checks")
+	}
+
+	var out strings.Builder
+	report.Print(&out)
+	for _, want := range []string{
+		"ok   size limits: 32768 bytes\n",
+		"ok   relay wss://relay.example.com: valid URL\n",
+		"FAIL relay http://relay.example.com: ",
+		"FAIL journal: unreadable\n",
+		"2 of 5 checks failed\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Print() = %q, missing %q", out.String(), want)
+		}
+	}
+
+	var empty Report
+	empty.CheckRelays(context.Background(), "relay", nil, false, time.Second)
+	if empty.OK() {
+		t.Error("CheckRelays() without relays should fail")
+	}
+}
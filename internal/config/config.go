@@ -1,16 +1,24 @@
 package config
 
-// WrapperEventKind is the ephemeral event kind used for inner wrapper events (routing layer).
-// Ephemeral events (20000-29999) are non-persistent and won't be stored by relays.
-const WrapperEventKind = 29000
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
 
-// StandardizedWrapperKind is the ephemeral event kind used for outer standardized size containers.
-// These events are always padded to exactly StandardizedSize (8KB) to hide message size metadata.
-const StandardizedWrapperKind = 29001
-
-// StandardizedSize is the target size for standardized wrapper events (32KB).
-const StandardizedSize = 32 * 1024 // 32768 bytes
-
-// PoWDifficulty is the proof-of-work difficulty for 29000 wrapper events (number of leading zero bits required).
-// Default is 16, which requires ~65536 attempts on average. This can be adjusted to balance spam prevention vs CPU cost.
-const PoWDifficulty = 16
+// ParseSizeBuckets parses a comma-separated list of size buckets in bytes ("" = none).
+func ParseSizeBuckets(s string) ([]int, error) {
+	var buckets []int
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		bucket, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid size bucket %q: %w", field, err)
+		}
+		buckets = append(buckets, bucket)
+	}
+	return buckets, nil
+}
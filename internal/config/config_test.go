@@ -1,15 +1,45 @@
 package config
 
-import "testing"
+import (
+	"slices"
+	"testing"
+)
 
-func TestWrapperEventKind(t *testing.T) {
-	// Verify that WrapperEventKind is defined and has the expected value
-	if WrapperEventKind != 29000 {
-		t.Errorf("WrapperEventKind = %d, want 29000", WrapperEventKind)
+func TestParseSizeBuckets(t *testing.T) {
+	buckets, err := ParseSizeBuckets("4096, 1024,4096")
+	if err != nil {
+		t.Fatalf("ParseSizeBuckets() error = %v", err)
 	}
+	if !slices.Equal(buckets, []int{4096, 1024, 4096}) {
+		t.Errorf("ParseSizeBuckets() = %v, want [4096 1024 4096]", buckets)
+	}
+	if _, err := ParseSizeBuckets("4096,big"); err == nil {
+		t.Error("ParseSizeBuckets() should reject a non-numeric bucket")
+	}
+	if got, err := ParseSizeBuckets(""); err != nil || got != nil {
+		t.Errorf("ParseSizeBuckets(\"\") = %v, %v, want no buckets", got, err)
+	}
+}
 
-	// Verify it's within the ephemeral event range (20000-29999)
-	if WrapperEventKind < 20000 || WrapperEventKind > 29999 {
-		t.Errorf("WrapperEventKind = %d, should be in ephemeral event range (20000-29999)", WrapperEventKind)
+func TestRejection_RoundTrip(t *testing.T) {
+	msg := NewRejection(RejectSizeExceeded, "32768", "event too large").Error()
+	if msg != "blocked: size-exceeded:32768 event too large" {
+		t.Errorf("Error() = %q", msg)
+	}
+	got, ok := ParseRejection(msg)
+	if !ok || got.Prefix != PrefixBlocked || got.Reason != RejectSizeExceeded || got.Param != "32768" || got.Message != "event too large" {
+		t.Errorf("ParseRejection(%q) = %+v, %v", msg, got, ok)
+	}
+
+	if got, ok := ParseRejection("error: path-down"); !ok || got.Reason != RejectPathDown || got.Param != "" {
+		t.Errorf("ParseRejection() without parameter = %+v, %v", got, ok)
+	}
+	if got, ok := ParseRejection("invalid: bad-signature event signature is invalid"); !ok || got.Prefix != PrefixInvalid || got.Reason != RejectBadSignature {
+		t.Errorf("ParseRejection() of an invalid event = %+v, %v", got, ok)
+	}
+	for _, msg := range []string{"blocked: no reason", "rate-limited: size-exceeded:1 wrong prefix", "no prefix at all"} {
+		if _, ok := ParseRejection(msg); ok {
+			t.Errorf("ParseRejection(%q) should not recognize the message", msg)
+		}
 	}
 }
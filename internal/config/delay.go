@@ -0,0 +1,37 @@
+package config
+
+import "time"
+
+// Delivery lanes a client routes an event in.
+const (
+	// Forward the event as fast as possible, without asking for mixing delays
+	LaneFast = "fast"
+	// Ask every Renoter on the path to hold the event for a random delay, so events leave the
+	// Renoters in a different order than they arrived
+	LaneMixed = "mixed"
+)
+
+// LaneTag is the local tag an app adds to an event to pick its lane, e.g. ["renoter-lane", "mixed"].
+// The client removes it before sizing and wrapping, so it never leaves the machine.
+const LaneTag = "renoter-lane"
+
+// Default delays of the mixed lane.
+const (
+	// Mean delay a client requests from each Renoter
+	DefaultMixingDelay = 30 * time.Second
+	// Longest delay a Renoter holds a layer for
+	DefaultMaxMixingDelay = 5 * time.Minute
+)
+
+// Distributions a Renoter draws mixing delays from, around the mean the layer asks for.
+const (
+	// Exponentially distributed with the requested mean, so delays are memoryless
+	MixingExponential = "exponential"
+	// Uniformly distributed between 0 and twice the requested mean
+	MixingUniform = "uniform"
+)
+
+// ValidLane reports whether lane is a known delivery lane.
+func ValidLane(lane string) bool {
+	return lane == LaneFast || lane == LaneMixed
+}
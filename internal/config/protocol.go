@@ -0,0 +1,104 @@
+package config
+
+import (
+	"time"
+
+	"github.com/girino/renoter/pkg/protocol"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// The wire-level constants, types and helpers are defined in pkg/protocol, where implementations
+// outside this module can import them. These aliases keep the rest of the module building while
+// it moves over.
+//
+// Deprecated: Use the protocol package.
+const (
+	WrapperEventKind        = protocol.WrapperEventKind
+	StandardizedWrapperKind = protocol.StandardizedWrapperKind
+	TrailerEventKind        = protocol.TrailerEventKind
+	HandshakeResponseKind   = protocol.HandshakeResponseKind
+	HopAckKind              = protocol.HopAckKind
+	ErrorNoticeKind         = protocol.ErrorNoticeKind
+	ServiceDescriptorKind   = protocol.ServiceDescriptorKind
+	HeartbeatKind           = protocol.HeartbeatKind
+
+	ProtocolVersion      = protocol.Version
+	MinProtocolVersion   = protocol.MinVersion
+	CompactLayersVersion = protocol.CompactLayersVersion
+	StampedPoWVersion    = protocol.StampedPoWVersion
+	HopAcksVersion       = protocol.HopAcksVersion
+	ErrorNoticesVersion  = protocol.ErrorNoticesVersion
+
+	StandardizedSize    = protocol.StandardizedSize
+	LiteSize            = protocol.LiteSize
+	PaddingTagOverhead  = protocol.PaddingTagOverhead
+	MaxStandardizedSize = protocol.MaxStandardizedSize
+	PoWDifficulty       = protocol.PoWDifficulty
+	LitePoWDifficulty   = protocol.LitePoWDifficulty
+
+	ServiceDescriptorTag = protocol.ServiceDescriptorTag
+	RoutingTag           = protocol.RoutingTag
+	HandshakeTag         = protocol.HandshakeTag
+	ReportTag            = protocol.ReportTag
+	TrailerTag           = protocol.TrailerTag
+	TrailerSlots         = protocol.TrailerSlots
+	AckTag               = protocol.AckTag
+	ReplyTag             = protocol.ReplyTag
+	IdempotencyTag       = protocol.IdempotencyTag
+	DelayTag             = protocol.DelayTag
+	MaxDelayHint         = protocol.MaxDelayHint
+
+	AckForwarded = protocol.AckForwarded
+	AckPublished = protocol.AckPublished
+	AckRejected  = protocol.AckRejected
+)
+
+// SizeLimits is protocol.SizeLimits.
+//
+// Deprecated: Use protocol.SizeLimits.
+type SizeLimits = protocol.SizeLimits
+
+// HopAck is protocol.HopAck.
+//
+// Deprecated: Use protocol.HopAck.
+type HopAck = protocol.HopAck
+
+// ErrorNotice is protocol.ErrorNotice.
+//
+// Deprecated: Use protocol.ErrorNotice.
+type ErrorNotice = protocol.ErrorNotice
+
+// DefaultSizeLimits returns protocol.DefaultSizeLimits().
+//
+// Deprecated: Use protocol.DefaultSizeLimits.
+func DefaultSizeLimits() SizeLimits { return protocol.DefaultSizeLimits() }
+
+// LiteSizeLimits returns protocol.LiteSizeLimits().
+//
+// Deprecated: Use protocol.LiteSizeLimits.
+func LiteSizeLimits() SizeLimits { return protocol.LiteSizeLimits() }
+
+// ContainerContentSize returns protocol.ContainerContentSize(bucket).
+//
+// Deprecated: Use protocol.ContainerContentSize.
+func ContainerContentSize(bucket int) int { return protocol.ContainerContentSize(bucket) }
+
+// RoutingPubkey returns protocol.RoutingPubkey(wrapper).
+//
+// Deprecated: Use protocol.RoutingPubkey.
+func RoutingPubkey(wrapper *nostr.Event) (string, error) { return protocol.RoutingPubkey(wrapper) }
+
+// IdempotencyKey returns protocol.IdempotencyKey(eventID).
+//
+// Deprecated: Use protocol.IdempotencyKey.
+func IdempotencyKey(eventID string) string { return protocol.IdempotencyKey(eventID) }
+
+// FormatDelayHint returns protocol.FormatDelayHint(delay).
+//
+// Deprecated: Use protocol.FormatDelayHint.
+func FormatDelayHint(delay time.Duration) string { return protocol.FormatDelayHint(delay) }
+
+// ParseDelayHint returns protocol.ParseDelayHint(value).
+//
+// Deprecated: Use protocol.ParseDelayHint.
+func ParseDelayHint(value string) (time.Duration, error) { return protocol.ParseDelayHint(value) }
@@ -0,0 +1,135 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// NIP-01 machine-readable prefixes of OK and CLOSED messages used by Renoter rejections.
+const (
+	PrefixInvalid     = "invalid"
+	PrefixBlocked     = "blocked"
+	PrefixRateLimited = "rate-limited"
+	PrefixPoW         = "pow"
+	PrefixError       = "error"
+)
+
+// Rejection reasons a Renoter client reports to local apps, and an ingress relay (see
+// server.IngressPolicy) to clients publishing containers. They follow the NIP-01 prefix, with
+// an optional parameter after a colon, so GUI clients can render friendly errors:
+//
+//	blocked: size-exceeded:32768 event too large (...)
+const (
+	// The event ID does not match its contents
+	RejectBadID = "bad-id"
+	// The event signature is missing or invalid
+	RejectBadSignature = "bad-signature"
+	// The event's created_at is too far in the past or future; the parameter is the allowed drift
+	RejectBadCreatedAt = "bad-created-at"
+	// The event kind is not routed by this client; the parameter is the kind
+	RejectKindNotAllowed = "kind-not-allowed"
+	// The event kind identifies its author by content and is never routed; the parameter is the kind
+	RejectKindUnsafe = "kind-unsafe"
+	// The event carries a tag that can identify its author; the parameter is the tag name
+	RejectIdentifyingTag = "identifying-tag"
+	// The delivery lane requested by the connection is unknown; the parameter is the lane
+	RejectUnknownLane = "unknown-lane"
+	// The event does not fit through the path; the parameter is the largest accepted event in bytes
+	RejectSizeExceeded = "size-exceeded"
+	// The mining queue is full; the parameter is the queue size
+	RejectQueueFull = "queue-full"
+	// The connection submits too fast; the parameter is the events allowed per minute
+	RejectConnectionRate = "connection-rate"
+	// The connection has too many events in flight; the parameter is the limit
+	RejectConnectionPending = "connection-pending"
+	// Wrapping did not finish in time; the parameter is the mining timeout
+	RejectMiningTimeout = "mining-timeout"
+	// No server relay accepted the wrapped event, so the path is unreachable
+	RejectPathDown = "path-down"
+	// No path satisfies the client's path policy (e.g. too few trusted Renoters)
+	RejectPathUnsatisfiable = "path-unsatisfiable"
+	// Wrapping failed for another reason
+	RejectWrapFailed = "wrap-failed"
+	// A transform configured on the client refused the event
+	RejectTransformFailed = "transform-failed"
+	// The container does not follow the wrapper schema
+	RejectMalformedWrapper = "malformed-wrapper"
+	// The container is addressed to a Renoter the relay does not serve
+	RejectUnknownRenoter = "unknown-renoter"
+	// The container content is not the size of any bucket; the parameter is the largest bucket
+	RejectBadSize = "bad-size"
+	// The container ID has too few leading zero bits; the parameter is the required difficulty
+	RejectInsufficientPoW = "insufficient-pow"
+)
+
+// rejectionPrefixes maps every rejection reason to its NIP-01 prefix.
+var rejectionPrefixes = map[string]string{
+	RejectBadID:             PrefixInvalid,
+	RejectBadSignature:      PrefixInvalid,
+	RejectBadCreatedAt:      PrefixInvalid,
+	RejectUnknownLane:       PrefixInvalid,
+	RejectKindNotAllowed:    PrefixBlocked,
+	RejectKindUnsafe:        PrefixBlocked,
+	RejectIdentifyingTag:    PrefixBlocked,
+	RejectSizeExceeded:      PrefixBlocked,
+	RejectQueueFull:         PrefixRateLimited,
+	RejectConnectionRate:    PrefixRateLimited,
+	RejectConnectionPending: PrefixRateLimited,
+	RejectMiningTimeout:     PrefixError,
+	RejectPathDown:          PrefixError,
+	RejectPathUnsatisfiable: PrefixError,
+	RejectWrapFailed:        PrefixError,
+	RejectTransformFailed:   PrefixError,
+	RejectMalformedWrapper:  PrefixInvalid,
+	RejectUnknownRenoter:    PrefixBlocked,
+	RejectBadSize:           PrefixInvalid,
+	RejectInsufficientPoW:   PrefixPoW,
+}
+
+// Rejection is a machine-readable rejection reason with a human-readable message.
+// It is an error whose text is the OK message.
+type Rejection struct {
+	// NIP-01 prefix, derived from Reason
+	Prefix string
+	// One of the Reject* reasons
+	Reason string
+	// Optional reason-specific parameter (e.g. a limit)
+	Param string
+	// Human-readable explanation
+	Message string
+}
+
+// NewRejection creates a rejection for one of the Reject* reasons.
+func NewRejection(reason, param, message string) *Rejection {
+	prefix, ok := rejectionPrefixes[reason]
+	if !ok {
+		prefix = PrefixError
+	}
+	return &Rejection{Prefix: prefix, Reason: reason, Param: param, Message: message}
+}
+
+func (r *Rejection) Error() string {
+	code := r.Reason
+	if r.Param != "" {
+		code += ":" + r.Param
+	}
+	if r.Message == "" {
+		return fmt.Sprintf("%s: %s", r.Prefix, code)
+	}
+	return fmt.Sprintf("%s: %s %s", r.Prefix, code, r.Message)
+}
+
+// ParseRejection extracts a rejection from an OK or NOTICE message, reporting false if the
+// message does not carry one of the known reasons.
+func ParseRejection(msg string) (*Rejection, bool) {
+	prefix, rest, ok := strings.Cut(msg, ": ")
+	if !ok {
+		return nil, false
+	}
+	code, message, _ := strings.Cut(rest, " ")
+	reason, param, _ := strings.Cut(code, ":")
+	if expected, known := rejectionPrefixes[reason]; !known || expected != prefix {
+		return nil, false
+	}
+	return &Rejection{Prefix: prefix, Reason: reason, Param: param, Message: message}, true
+}
@@ -0,0 +1,375 @@
+package descriptor
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/girino/renoter/internal/config"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// FeePolicyFree is the fee policy of a Renoter that forwards events at no charge.
+const FeePolicyFree = "free"
+
+// FeePolicyLightning is the fee policy of a Renoter that charges FeeMsats per forwarded event,
+// paid to LightningAddress, after an optional hourly free quota.
+const FeePolicyLightning = "lightning"
+
+// AdmissionPoW admits 29000 layers carrying PoW of at least PoWDifficulty. Renoters whose
+// descriptor lists no admission strategy use it.
+const AdmissionPoW = "pow"
+
+// AdmissionCashu admits 29000 layers carrying a Cashu token worth at least CashuAmount sats
+// from one of CashuMints, which the Renoter redeems before forwarding.
+const AdmissionCashu = "cashu"
+
+// ProfileLite is the profile of Renoters that also forward containers padded to config.LiteSize
+// and admit the 29000 wrappers inside them with PoW of config.LitePoWDifficulty.
+const ProfileLite = "lite"
+
+// Statuses a Renoter announces in its descriptor.
+const (
+	StatusOnline = "online"
+	// Published by a Renoter shutting down, so clients route around it until it is back
+	StatusOffline = "offline"
+)
+
+// heartbeatGrace is how many heartbeats a descriptor may miss before its Renoter is taken for offline.
+const heartbeatGrace = 3
+
+// Descriptor is a Renoter's service announcement, published as a parameterized replaceable
+// event of kind config.ServiceDescriptorKind. Every field is a tag so relays can filter on them.
+type Descriptor struct {
+	// Public key of the Renoter the descriptor was published by
+	PubKey string
+	// Event kinds the Renoter accepts (29000 and 29001)
+	Kinds []int
+	// Standardized size the Renoter pads to; must match every client and Renoter on a path
+	StandardizedSize int
+	// Smaller size buckets the Renoter re-wraps containers padded to them to; must match too
+	Buckets []int
+	// Anti-spam strategies the Renoter admits 29000 wrappers with; any one of them suffices
+	Admission []string
+	// PoW difficulty the Renoter requires on 29000 wrappers (AdmissionPoW only)
+	PoWDifficulty int
+	// PoW difficulty the Renoter mines on forwarded 29001 containers
+	ContainerPoWDifficulty int
+	// Optional protocol profiles the Renoter supports besides the full one (ProfileLite)
+	Profiles []string
+	// Fee policy, FeePolicyFree for no charge
+	FeePolicy string
+	// Fee per forwarded event in millisatoshis (FeePolicyLightning only)
+	FeeMsats int64
+	// Lightning address (LUD-16) fees are paid to (FeePolicyLightning only)
+	LightningAddress string
+	// Unpaid events forwarded per hour before payment is required (FeePolicyLightning only)
+	FreeQuota int
+	// Mints whose tokens the Renoter accepts (AdmissionCashu only)
+	CashuMints []string
+	// Token value in sats required per 29000 wrapper (AdmissionCashu only)
+	CashuAmount uint64
+	// Free-form operator contact (e.g. an npub, email or URL)
+	Contact string
+	// Self-declared region the Renoter runs in (e.g. an ISO 3166 country code), empty if undeclared
+	Region string
+	// Self-declared autonomous system number of the Renoter's network provider, 0 if undeclared
+	ASN uint32
+	// Bounds of the delays the Renoter holds mixed-lane layers for; a MixingMax of 0 means layers
+	// are always forwarded immediately
+	MixingMin, MixingMax time.Duration
+	// Distribution mixing delays are drawn from (config.MixingExponential or config.MixingUniform)
+	MixingDistribution string
+	// StatusOnline or StatusOffline (empty = online)
+	Status string
+	// Protocol version the Renoter speaks (0 if not announced)
+	Version int
+	// Interval the Renoter republishes its descriptor at, 0 if it does not
+	Heartbeat time.Duration
+	// Whether the Renoter publishes a NIP-32 label attributing the final events it publishes to itself
+	Attribution bool
+	// When the descriptor was published, set by Parse
+	CreatedAt time.Time
+}
+
+// Event returns the unsigned descriptor event.
+func (d Descriptor) Event() nostr.Event {
+	tags := nostr.Tags{{"d", config.ServiceDescriptorTag}}
+	for _, kind := range d.Kinds {
+		tags = append(tags, nostr.Tag{"k", strconv.Itoa(kind)})
+	}
+	for _, admission := range d.Admission {
+		tags = append(tags, nostr.Tag{"admission", admission})
+	}
+	tags = append(tags,
+		nostr.Tag{"size", strconv.Itoa(d.StandardizedSize)},
+	)
+	for _, bucket := range d.Buckets {
+		tags = append(tags, nostr.Tag{"bucket", strconv.Itoa(bucket)})
+	}
+	tags = append(tags,
+		nostr.Tag{"pow", strconv.Itoa(d.PoWDifficulty)},
+		nostr.Tag{"container_pow", strconv.Itoa(d.ContainerPoWDifficulty)},
+		nostr.Tag{"fee", d.FeePolicy},
+	)
+	for _, profile := range d.Profiles {
+		tags = append(tags, nostr.Tag{"profile", profile})
+	}
+	if d.FeePolicy == FeePolicyLightning {
+		tags = append(tags,
+			nostr.Tag{"fee_msats", strconv.FormatInt(d.FeeMsats, 10)},
+			nostr.Tag{"lud16", d.LightningAddress},
+			nostr.Tag{"free_quota", strconv.Itoa(d.FreeQuota)},
+		)
+	}
+	if slices.Contains(d.Admission, AdmissionCashu) {
+		for _, mint := range d.CashuMints {
+			tags = append(tags, nostr.Tag{"cashu_mint", mint})
+		}
+		tags = append(tags, nostr.Tag{"cashu_amount", strconv.FormatUint(d.CashuAmount, 10)})
+	}
+	if d.Contact != "" {
+		tags = append(tags, nostr.Tag{"contact", d.Contact})
+	}
+	if d.Region != "" {
+		tags = append(tags, nostr.Tag{"region", d.Region})
+	}
+	if d.ASN != 0 {
+		tags = append(tags, nostr.Tag{"asn", strconv.FormatUint(uint64(d.ASN), 10)})
+	}
+	if d.Version > 0 {
+		tags = append(tags, nostr.Tag{"version", strconv.Itoa(d.Version)})
+	}
+	if d.Status != "" {
+		tags = append(tags, nostr.Tag{"status", d.Status})
+	}
+	if d.Heartbeat > 0 {
+		tags = append(tags, nostr.Tag{"heartbeat", strconv.FormatInt(int64(d.Heartbeat/time.Second), 10)})
+	}
+	if d.Attribution {
+		tags = append(tags, nostr.Tag{"attribution", "label"})
+	}
+	if d.MixingMax > 0 {
+		tags = append(tags, nostr.Tag{"mixing", strconv.FormatInt(int64(d.MixingMin/time.Second), 10), strconv.FormatInt(int64(d.MixingMax/time.Second), 10), d.MixingDistribution})
+	}
+
+	return nostr.Event{
+		Kind:      config.ServiceDescriptorKind,
+		CreatedAt: nostr.Now(),
+		Tags:      tags,
+		Content:   "Renoter service descriptor",
+	}
+}
+
+// Parse extracts a Descriptor from a signed descriptor event.
+func Parse(event *nostr.Event) (*Descriptor, error) {
+	if event.Kind != config.ServiceDescriptorKind {
+		return nil, fmt.Errorf("expected kind %d, got %d", config.ServiceDescriptorKind, event.Kind)
+	}
+	if valid, err := event.CheckSignature(); err != nil || !valid {
+		return nil, fmt.Errorf("invalid descriptor signature")
+	}
+	if d := event.Tags.GetD(); d != config.ServiceDescriptorTag {
+		return nil, fmt.Errorf("unexpected d tag %q", d)
+	}
+	d := &Descriptor{PubKey: event.PubKey, CreatedAt: event.CreatedAt.Time()}
+	if err := d.parseTags(event.Tags); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// ParseCapabilities extracts the Descriptor of the Renoter pubkey from the tags of a capability
+// response, which carries the same tags as its service descriptor but is not signed by it.
+func ParseCapabilities(pubkey string, tags nostr.Tags, receivedAt time.Time) (*Descriptor, error) {
+	d := &Descriptor{PubKey: pubkey, CreatedAt: receivedAt}
+	if err := d.parseTags(tags); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// parseTags fills d in from descriptor tags and checks that they are consistent.
+func (d *Descriptor) parseTags(tags nostr.Tags) error {
+	var err error
+	for _, tag := range tags {
+		if len(tag) < 2 {
+			continue
+		}
+		switch tag[0] {
+		case "k":
+			var kind int
+			if kind, err = strconv.Atoi(tag[1]); err == nil {
+				d.Kinds = append(d.Kinds, kind)
+			}
+		case "admission":
+			d.Admission = append(d.Admission, tag[1])
+		case "size":
+			d.StandardizedSize, err = strconv.Atoi(tag[1])
+		case "bucket":
+			var bucket int
+			if bucket, err = strconv.Atoi(tag[1]); err == nil {
+				d.Buckets = append(d.Buckets, bucket)
+			}
+		case "pow":
+			d.PoWDifficulty, err = strconv.Atoi(tag[1])
+		case "container_pow":
+			d.ContainerPoWDifficulty, err = strconv.Atoi(tag[1])
+		case "profile":
+			d.Profiles = append(d.Profiles, tag[1])
+		case "fee":
+			d.FeePolicy = tag[1]
+		case "fee_msats":
+			d.FeeMsats, err = strconv.ParseInt(tag[1], 10, 64)
+		case "lud16":
+			d.LightningAddress = tag[1]
+		case "free_quota":
+			d.FreeQuota, err = strconv.Atoi(tag[1])
+		case "cashu_mint":
+			d.CashuMints = append(d.CashuMints, tag[1])
+		case "cashu_amount":
+			d.CashuAmount, err = strconv.ParseUint(tag[1], 10, 64)
+		case "contact":
+			d.Contact = tag[1]
+		case "region":
+			d.Region = tag[1]
+		case "asn":
+			var asn uint64
+			asn, err = strconv.ParseUint(strings.TrimPrefix(strings.ToUpper(tag[1]), "AS"), 10, 32)
+			d.ASN = uint32(asn)
+		case "mixing":
+			err = d.parseMixing(tag)
+		case "status":
+			d.Status = tag[1]
+		case "attribution":
+			d.Attribution = true
+		case "version":
+			d.Version, err = strconv.Atoi(tag[1])
+		case "heartbeat":
+			var seconds int64
+			if seconds, err = strconv.ParseInt(tag[1], 10, 64); err == nil && seconds <= 0 {
+				err = fmt.Errorf("must be positive")
+			}
+			d.Heartbeat = time.Duration(seconds) * time.Second
+		}
+		if err != nil {
+			return fmt.Errorf("invalid %s tag %q: %w", tag[0], tag[1], err)
+		}
+	}
+	if d.StandardizedSize == 0 {
+		return fmt.Errorf("descriptor has no size tag")
+	}
+	if len(d.Admission) == 0 {
+		d.Admission = []string{AdmissionPoW}
+	}
+	if slices.Contains(d.Admission, AdmissionCashu) && (len(d.CashuMints) == 0 || d.CashuAmount == 0) {
+		return fmt.Errorf("cashu admission requires cashu_mint and cashu_amount tags")
+	}
+	if d.FeePolicy == FeePolicyLightning && (d.FeeMsats <= 0 || d.LightningAddress == "") {
+		return fmt.Errorf("lightning fee policy requires fee_msats and lud16 tags")
+	}
+	return nil
+}
+
+// parseMixing reads a ["mixing", "<min seconds>", "<max seconds>", "<distribution>"] tag.
+func (d *Descriptor) parseMixing(tag nostr.Tag) error {
+	if len(tag) < 4 {
+		return fmt.Errorf("expected min, max and distribution")
+	}
+	minSeconds, err := strconv.ParseInt(tag[1], 10, 64)
+	if err != nil {
+		return err
+	}
+	maxSeconds, err := strconv.ParseInt(tag[2], 10, 64)
+	if err != nil {
+		return err
+	}
+	if minSeconds < 0 || maxSeconds < minSeconds {
+		return fmt.Errorf("bounds %d..%d are out of order", minSeconds, maxSeconds)
+	}
+	d.MixingMin, d.MixingMax = time.Duration(minSeconds)*time.Second, time.Duration(maxSeconds)*time.Second
+	d.MixingDistribution = tag[3]
+	return nil
+}
+
+// Offline reports whether the Renoter is offline at now, and why: it announced so, or it missed
+// several heartbeats. Renoters without a heartbeat are only offline when they announce it.
+func (d *Descriptor) Offline(now time.Time) (string, bool) {
+	return offline(d.Status, d.Heartbeat, d.CreatedAt, now)
+}
+
+// offline is Offline for an announcement of status published at createdAt, repeated every interval.
+func offline(status string, interval time.Duration, createdAt, now time.Time) (string, bool) {
+	if status == StatusOffline {
+		return "announced offline", true
+	}
+	if interval > 0 {
+		if silent := now.Sub(createdAt); silent > heartbeatGrace*interval {
+			return fmt.Sprintf("no announcement for %v (heartbeat %v)", silent.Round(time.Second), interval), true
+		}
+	}
+	return "", false
+}
+
+// CheckCompatible reports why a client using limits and mining powDifficulty on every 29000
+// cannot route through the Renoter described by d, or nil if it can. Paid Renoters are only
+// compatible with clients that can pay (canPay), unless they offer a free quota. Renoters that
+// do not admit PoW are only compatible with clients holding Cashu tokens (hasCashu).
+func (d *Descriptor) CheckCompatible(limits config.SizeLimits, powDifficulty int, canPay, hasCashu bool) error {
+	if d.Version > 0 && d.Version < config.MinProtocolVersion {
+		return fmt.Errorf("speaks protocol version %d, client needs at least %d", d.Version, config.MinProtocolVersion)
+	}
+	for _, kind := range []int{config.WrapperEventKind, config.StandardizedWrapperKind} {
+		if !slices.Contains(d.Kinds, kind) {
+			return fmt.Errorf("does not accept kind %d", kind)
+		}
+	}
+	if d.StandardizedSize != limits.StandardizedSize {
+		return fmt.Errorf("uses standardized size %d, client uses %d", d.StandardizedSize, limits.StandardizedSize)
+	}
+	if buckets := (config.SizeLimits{StandardizedSize: d.StandardizedSize, Buckets: d.Buckets}).Sizes(); !slices.Equal(buckets, limits.Sizes()) {
+		return fmt.Errorf("uses size buckets %v, client uses %v", buckets, limits.Sizes())
+	}
+	if err := d.checkAdmission(powDifficulty, hasCashu); err != nil {
+		return err
+	}
+	switch d.FeePolicy {
+	case FeePolicyFree:
+	case FeePolicyLightning:
+		if !canPay && d.FreeQuota == 0 {
+			return fmt.Errorf("charges %d msats per event and no lightning wallet is configured", d.FeeMsats)
+		}
+	default:
+		return fmt.Errorf("fee policy %q is not supported", d.FeePolicy)
+	}
+	return nil
+}
+
+// checkAdmission reports why a client cannot satisfy any of d's admission strategies.
+func (d *Descriptor) checkAdmission(powDifficulty int, hasCashu bool) error {
+	admissions := d.Admission
+	if len(admissions) == 0 {
+		admissions = []string{AdmissionPoW}
+	}
+	var reasons []string
+	for _, admission := range admissions {
+		switch admission {
+		case AdmissionPoW:
+			if d.PoWDifficulty <= powDifficulty {
+				return nil
+			}
+			reasons = append(reasons, fmt.Sprintf("requires PoW difficulty %d, client mines %d", d.PoWDifficulty, powDifficulty))
+		case AdmissionCashu:
+			if hasCashu {
+				return nil
+			}
+			reasons = append(reasons, fmt.Sprintf("requires a %d sat Cashu token and no Cashu tokens are configured", d.CashuAmount))
+		}
+	}
+	if len(reasons) == 0 {
+		return fmt.Errorf("no supported admission strategy in %v", d.Admission)
+	}
+	return fmt.Errorf("%s", strings.Join(reasons, " or "))
+}
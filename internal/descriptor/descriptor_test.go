@@ -0,0 +1,326 @@
+package descriptor
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/girino/renoter/internal/config"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func newDescriptor() Descriptor {
+	return Descriptor{
+		Kinds:                  []int{config.WrapperEventKind, config.StandardizedWrapperKind},
+		StandardizedSize:       config.StandardizedSize,
+		PoWDifficulty:          config.PoWDifficulty,
+		ContainerPoWDifficulty: 8,
+		FeePolicy:              FeePolicyFree,
+		Contact:                "ops@example.com",
+	}
+}
+
+func TestParse_RoundTrip(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	event := newDescriptor().Event()
+	if err := event.Sign(sk); err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+
+	d, err := Parse(&event)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	want := newDescriptor()
+	want.PubKey = event.PubKey
+	if d.PubKey != want.PubKey || d.StandardizedSize != want.StandardizedSize || d.PoWDifficulty != want.PoWDifficulty ||
+		d.ContainerPoWDifficulty != want.ContainerPoWDifficulty || d.FeePolicy != want.FeePolicy || d.Contact != want.Contact ||
+		len(d.Kinds) != 2 {
+		t.Errorf("Parse() = %+v, want %+v", d, want)
+	}
+}
+
+func TestParse_Buckets(t *testing.T) {
+	bucketed := newDescriptor()
+	bucketed.Buckets = []int{4096, 16384}
+	event := bucketed.Event()
+	event.Sign(nostr.GeneratePrivateKey())
+	d, err := Parse(&event)
+	if err != nil || !slices.Equal(d.Buckets, []int{4096, 16384}) {
+		t.Errorf("Parse() = %+v, %v, want the announced buckets", d, err)
+	}
+}
+
+func TestParse_Attribution(t *testing.T) {
+	for _, attribution := range []bool{false, true} {
+		announced := newDescriptor()
+		announced.Attribution = attribution
+		event := announced.Event()
+		event.Sign(nostr.GeneratePrivateKey())
+		if d, err := Parse(&event); err != nil || d.Attribution != attribution {
+			t.Errorf("Parse() = %+v, %v, want attribution %v", d, err, attribution)
+		}
+	}
+}
+
+func TestParse_Location(t *testing.T) {
+	located := newDescriptor()
+	located.Region = "DE"
+	located.ASN = 24940
+	event := located.Event()
+	event.Sign(nostr.GeneratePrivateKey())
+	d, err := Parse(&event)
+	if err != nil || d.Region != "DE" || d.ASN != 24940 {
+		t.Errorf("Parse() = %+v, %v, want the declared location", d, err)
+	}
+
+	// The common "AS" prefix is accepted, garbage is not
+	event = newDescriptor().Event()
+	event.Tags = append(event.Tags, nostr.Tag{"asn", "AS16276"})
+	event.Sign(nostr.GeneratePrivateKey())
+	if d, err := Parse(&event); err != nil || d.ASN != 16276 {
+		t.Errorf("Parse() of an AS-prefixed asn = %+v, %v", d, err)
+	}
+	event = newDescriptor().Event()
+	event.Tags = append(event.Tags, nostr.Tag{"asn", "hetzner"})
+	event.Sign(nostr.GeneratePrivateKey())
+	if _, err := Parse(&event); err == nil {
+		t.Error("Parse() should reject a non-numeric asn")
+	}
+}
+
+func TestParse_Mixing(t *testing.T) {
+	mixing := newDescriptor()
+	mixing.MixingMin, mixing.MixingMax, mixing.MixingDistribution = 5*time.Second, 10*time.Minute, config.MixingUniform
+	event := mixing.Event()
+	event.Sign(nostr.GeneratePrivateKey())
+	d, err := Parse(&event)
+	if err != nil || d.MixingMin != 5*time.Second || d.MixingMax != 10*time.Minute || d.MixingDistribution != config.MixingUniform {
+		t.Errorf("Parse() = %+v, %v, want the mixing bounds", d, err)
+	}
+
+	// Renoters that never hold layers announce nothing
+	if event := newDescriptor().Event(); event.Tags.Find("mixing") != nil {
+		t.Error("Event() announced mixing without a maximum delay")
+	}
+
+	for _, tag := range []nostr.Tag{{"mixing", "10", "5", "uniform"}, {"mixing", "0", "60"}, {"mixing", "0", "soon", "uniform"}} {
+		event := newDescriptor().Event()
+		event.Tags = append(event.Tags, tag)
+		event.Sign(nostr.GeneratePrivateKey())
+		if _, err := Parse(&event); err == nil {
+			t.Errorf("Parse() should reject %v", tag)
+		}
+	}
+}
+
+func TestDescriptor_Offline(t *testing.T) {
+	now := time.Now()
+	d := newDescriptor()
+	d.Status, d.Heartbeat = StatusOnline, 10*time.Minute
+	event := d.Event()
+	event.CreatedAt = nostr.Timestamp(now.Add(-20 * time.Minute).Unix())
+	event.Sign(nostr.GeneratePrivateKey())
+	parsed, err := Parse(&event)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if parsed.Heartbeat != 10*time.Minute || parsed.Status != StatusOnline {
+		t.Errorf("Parse() = heartbeat %v, status %q", parsed.Heartbeat, parsed.Status)
+	}
+	if reason, offline := parsed.Offline(now); offline {
+		t.Errorf("Offline() after two missed heartbeats = %q, want online", reason)
+	}
+	if _, offline := parsed.Offline(now.Add(15 * time.Minute)); !offline {
+		t.Error("Offline() after more than three missed heartbeats = online, want offline")
+	}
+
+	parsed.Status = StatusOffline
+	if reason, offline := parsed.Offline(now); !offline || reason != "announced offline" {
+		t.Errorf("Offline() of an offline announcement = %q, %v", reason, offline)
+	}
+
+	// Without a heartbeat, an old descriptor says nothing about liveness
+	parsed.Status, parsed.Heartbeat = "", 0
+	if _, offline := parsed.Offline(now.Add(24 * time.Hour)); offline {
+		t.Error("Offline() without a heartbeat should only trust the announced status")
+	}
+}
+
+func TestParse_LightningFee(t *testing.T) {
+	paid := newDescriptor()
+	paid.FeePolicy = FeePolicyLightning
+	paid.FeeMsats = 2000
+	paid.LightningAddress = "renoter@example.com"
+	paid.FreeQuota = 100
+
+	event := paid.Event()
+	event.Sign(nostr.GeneratePrivateKey())
+	d, err := Parse(&event)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if d.FeeMsats != 2000 || d.LightningAddress != "renoter@example.com" || d.FreeQuota != 100 {
+		t.Errorf("Parse() = %+v, want lightning fee fields", d)
+	}
+
+	paid.LightningAddress = ""
+	event = paid.Event()
+	event.Sign(nostr.GeneratePrivateKey())
+	if _, err := Parse(&event); err == nil {
+		t.Error("Parse() should reject a lightning fee policy without an address")
+	}
+}
+
+func TestParse_Admission(t *testing.T) {
+	event := newDescriptor().Event()
+	event.Sign(nostr.GeneratePrivateKey())
+	d, err := Parse(&event)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(d.Admission) != 1 || d.Admission[0] != AdmissionPoW {
+		t.Errorf("Parse() admission = %v, want PoW by default", d.Admission)
+	}
+
+	cashu := newDescriptor()
+	cashu.Admission = []string{AdmissionPoW, AdmissionCashu}
+	cashu.CashuMints = []string{"https://mint.example.com"}
+	cashu.CashuAmount = 2
+	event = cashu.Event()
+	event.Sign(nostr.GeneratePrivateKey())
+	if d, err = Parse(&event); err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(d.Admission) != 2 || d.Admission[1] != AdmissionCashu || len(d.CashuMints) != 1 || d.CashuAmount != 2 {
+		t.Errorf("Parse() = %+v, want cashu admission fields", d)
+	}
+
+	cashu.CashuMints = nil
+	event = cashu.Event()
+	event.Sign(nostr.GeneratePrivateKey())
+	if _, err := Parse(&event); err == nil {
+		t.Error("Parse() should reject cashu admission without mints")
+	}
+}
+
+func TestParse_Rejects(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	sign := func(event nostr.Event) *nostr.Event {
+		event.Sign(sk)
+		return &event
+	}
+
+	wrongKind := newDescriptor().Event()
+	wrongKind.Kind = 1
+
+	badSize := newDescriptor().Event()
+	for _, tag := range badSize.Tags {
+		if tag[0] == "size" {
+			tag[1] = "big"
+		}
+	}
+
+	forged := sign(newDescriptor().Event())
+	forged.Tags = append(forged.Tags, nostr.Tag{"contact", "attacker"})
+
+	tests := map[string]*nostr.Event{
+		"wrong kind":    sign(wrongKind),
+		"bad size":      sign(badSize),
+		"bad signature": forged,
+	}
+	for name, event := range tests {
+		if _, err := Parse(event); err == nil {
+			t.Errorf("Parse(%s) should fail", name)
+		}
+	}
+}
+
+func TestCheckCompatible(t *testing.T) {
+	limits := config.DefaultSizeLimits()
+	tests := []struct {
+		name     string
+		modify   func(*Descriptor)
+		canPay   bool
+		hasCashu bool
+		wantErr  string
+	}{
+		{"compatible", func(d *Descriptor) {}, false, false, ""},
+		{"lower PoW", func(d *Descriptor) { d.PoWDifficulty = 8 }, false, false, ""},
+		{"missing kind", func(d *Descriptor) { d.Kinds = []int{config.StandardizedWrapperKind} }, false, false, "kind 29000"},
+		{"other size", func(d *Descriptor) { d.StandardizedSize = 16384 }, false, false, "standardized size"},
+		{"other buckets", func(d *Descriptor) { d.Buckets = []int{4096} }, false, false, "size buckets"},
+		{"higher PoW", func(d *Descriptor) { d.PoWDifficulty = 24 }, false, false, "PoW difficulty"},
+		{"paid without wallet", func(d *Descriptor) { d.FeePolicy = FeePolicyLightning }, false, false, "no lightning wallet"},
+		{"paid with wallet", func(d *Descriptor) { d.FeePolicy = FeePolicyLightning }, true, false, ""},
+		{"paid with free quota", func(d *Descriptor) { d.FeePolicy = FeePolicyLightning; d.FreeQuota = 10 }, false, false, ""},
+		{"cashu only without tokens", func(d *Descriptor) { d.Admission = []string{AdmissionCashu}; d.CashuAmount = 2 }, false, false, "Cashu token"},
+		{"cashu only with tokens", func(d *Descriptor) { d.Admission = []string{AdmissionCashu} }, false, true, ""},
+		{"cashu or PoW without tokens", func(d *Descriptor) { d.Admission = []string{AdmissionCashu, AdmissionPoW} }, false, false, ""},
+		{"cashu or too much PoW", func(d *Descriptor) { d.Admission = []string{AdmissionPoW, AdmissionCashu}; d.PoWDifficulty = 24 }, false, false, "PoW difficulty"},
+		{"unknown admission", func(d *Descriptor) { d.Admission = []string{"captcha"} }, false, true, "no supported admission"},
+		{"unknown fee policy", func(d *Descriptor) { d.FeePolicy = "barter" }, true, false, "fee policy"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newDescriptor()
+			tt.modify(&d)
+			err := d.CheckCompatible(limits, config.PoWDifficulty, tt.canPay, tt.hasCashu)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("CheckCompatible() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("CheckCompatible() error = %v, want mention of %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestHeartbeat_RoundTrip(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	event := Heartbeat{Status: StatusOnline, Interval: 2 * time.Minute}.Event()
+	event.Sign(sk)
+
+	beat, err := ParseHeartbeat(&event)
+	if err != nil {
+		t.Fatalf("ParseHeartbeat() error = %v", err)
+	}
+	pk, _ := nostr.GetPublicKey(sk)
+	if beat.PubKey != pk || beat.Status != StatusOnline || beat.Interval != 2*time.Minute {
+		t.Errorf("ParseHeartbeat() = %+v", beat)
+	}
+	if _, offline := beat.Offline(beat.CreatedAt.Add(5 * time.Minute)); offline {
+		t.Error("Offline() within the grace period")
+	}
+	if _, offline := beat.Offline(beat.CreatedAt.Add(7 * time.Minute)); !offline {
+		t.Error("Offline() should report a Renoter silent for three heartbeats")
+	}
+
+	// A republished heartbeat cannot be altered
+	event.Tags[0][1] = StatusOffline
+	if _, err := ParseHeartbeat(&event); err == nil {
+		t.Error("ParseHeartbeat() accepted an altered heartbeat")
+	}
+	for _, tags := range []nostr.Tags{{{"status", "busy"}, {"interval", "60"}}, {{"status", StatusOnline}}, {{"status", StatusOnline}, {"interval", "0"}}} {
+		event := nostr.Event{Kind: config.HeartbeatKind, CreatedAt: nostr.Now(), Tags: tags}
+		event.Sign(sk)
+		if _, err := ParseHeartbeat(&event); err == nil {
+			t.Errorf("ParseHeartbeat(%v) should fail", tags)
+		}
+	}
+}
+
+func TestParse_Profiles(t *testing.T) {
+	announced := newDescriptor()
+	announced.Profiles = []string{ProfileLite}
+	event := announced.Event()
+	event.Sign(nostr.GeneratePrivateKey())
+	if d, err := Parse(&event); err != nil || !slices.Equal(d.Profiles, []string{ProfileLite}) {
+		t.Errorf("Parse() = %+v, %v, want the lite profile", d, err)
+	}
+}
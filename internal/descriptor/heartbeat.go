@@ -0,0 +1,76 @@
+package descriptor
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/girino/renoter/internal/config"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Heartbeat is a Renoter's signed statement that it is alive, published as a replaceable event of
+// kind config.HeartbeatKind. It only ever speaks for its signer, so peers can republish it to
+// their own relays without being able to change it.
+type Heartbeat struct {
+	// Public key of the Renoter the heartbeat was published by
+	PubKey string
+	// StatusOnline or StatusOffline
+	Status string
+	// Interval the Renoter publishes heartbeats at
+	Interval time.Duration
+	// When the heartbeat was published, set by ParseHeartbeat
+	CreatedAt time.Time
+}
+
+// Event returns the unsigned heartbeat event.
+func (h Heartbeat) Event() nostr.Event {
+	return nostr.Event{
+		Kind:      config.HeartbeatKind,
+		CreatedAt: nostr.Now(),
+		Tags: nostr.Tags{
+			{"status", h.Status},
+			{"interval", strconv.FormatInt(int64(h.Interval/time.Second), 10)},
+		},
+		Content: "Renoter heartbeat",
+	}
+}
+
+// ParseHeartbeat extracts a Heartbeat from a signed heartbeat event.
+func ParseHeartbeat(event *nostr.Event) (*Heartbeat, error) {
+	if event.Kind != config.HeartbeatKind {
+		return nil, fmt.Errorf("expected kind %d, got %d", config.HeartbeatKind, event.Kind)
+	}
+	if valid, err := event.CheckSignature(); err != nil || !valid {
+		return nil, fmt.Errorf("invalid heartbeat signature")
+	}
+	h := &Heartbeat{PubKey: event.PubKey, CreatedAt: event.CreatedAt.Time()}
+	for _, tag := range event.Tags {
+		if len(tag) < 2 {
+			continue
+		}
+		switch tag[0] {
+		case "status":
+			h.Status = tag[1]
+		case "interval":
+			seconds, err := strconv.ParseInt(tag[1], 10, 64)
+			if err != nil || seconds <= 0 {
+				return nil, fmt.Errorf("invalid interval tag %q", tag[1])
+			}
+			h.Interval = time.Duration(seconds) * time.Second
+		}
+	}
+	if h.Status != StatusOnline && h.Status != StatusOffline {
+		return nil, fmt.Errorf("invalid status %q", h.Status)
+	}
+	if h.Interval == 0 {
+		return nil, fmt.Errorf("heartbeat has no interval")
+	}
+	return h, nil
+}
+
+// Offline reports whether the Renoter is offline at now according to the heartbeat, and why: it
+// announced so, or it missed several heartbeats.
+func (h *Heartbeat) Offline(now time.Time) (string, bool) {
+	return offline(h.Status, h.Interval, h.CreatedAt, now)
+}
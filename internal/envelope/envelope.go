@@ -0,0 +1,151 @@
+package envelope
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+// version is the first byte of every envelope, so the format can change later.
+const version = 1
+
+// nonceSize is the size of the secretbox nonce following the version byte.
+const nonceSize = 24
+
+// saltPrefix marks a key file holding the scrypt salt of a passphrase instead of a key.
+const saltPrefix = "scrypt:"
+
+// scrypt parameters for passphrase keys (the recommended interactive settings).
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// Key encrypts and decrypts envelopes.
+type Key [32]byte
+
+// Seal encrypts plaintext into an envelope bound to label: version byte, random nonce and the
+// secretbox of the label and plaintext. Opening it under another label fails, so one record
+// cannot be passed off as another (e.g. label it with the event ID it holds).
+func Seal(key *Key, plaintext []byte, label string) []byte {
+	var nonce [nonceSize]byte
+	rand.Read(nonce[:])
+	out := append([]byte{version}, nonce[:]...)
+	return secretbox.Seal(out, bind(plaintext, label), &nonce, (*[32]byte)(key))
+}
+
+// Open decrypts an envelope sealed under label.
+func Open(key *Key, sealed []byte, label string) ([]byte, error) {
+	if len(sealed) < 1+nonceSize+secretbox.Overhead {
+		return nil, fmt.Errorf("envelope too short")
+	}
+	if sealed[0] != version {
+		return nil, fmt.Errorf("unsupported envelope version %d", sealed[0])
+	}
+	var nonce [nonceSize]byte
+	copy(nonce[:], sealed[1:1+nonceSize])
+	opened, ok := secretbox.Open(nil, sealed[1+nonceSize:], &nonce, (*[32]byte)(key))
+	if !ok {
+		return nil, fmt.Errorf("failed to decrypt envelope (wrong key?)")
+	}
+	if len(opened) < 2 {
+		return nil, fmt.Errorf("malformed envelope")
+	}
+	n := int(binary.BigEndian.Uint16(opened))
+	if len(opened) < 2+n || !bytes.Equal(opened[2:2+n], []byte(label)) {
+		return nil, fmt.Errorf("envelope does not hold %q", label)
+	}
+	return opened[2+n:], nil
+}
+
+// bind prefixes plaintext with the length and bytes of label.
+func bind(plaintext []byte, label string) []byte {
+	out := binary.BigEndian.AppendUint16(nil, uint16(len(label)))
+	out = append(out, label...)
+	return append(out, plaintext...)
+}
+
+// LoadKey returns the key of file. Without a passphrase the file holds the key itself, as hex; with
+// one it holds the salt the key is derived from, so it is useless without the passphrase. The
+// file is created with mode 0600 on first use. A file created for one mode is refused in the
+// other, so a forgotten passphrase is noticed rather than silently generating a new key.
+func LoadKey(file, passphrase string) (*Key, error) {
+	data, err := os.ReadFile(file)
+	if errors.Is(err, fs.ErrNotExist) {
+		return createKey(file, passphrase)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	content := strings.TrimSpace(string(data))
+	salted := strings.HasPrefix(content, saltPrefix)
+	switch {
+	case salted && passphrase == "":
+		return nil, fmt.Errorf("key file %s needs a passphrase", file)
+	case !salted && passphrase != "":
+		return nil, fmt.Errorf("key file %s holds a key, not a passphrase salt", file)
+	case salted:
+		salt, err := hex.DecodeString(strings.TrimPrefix(content, saltPrefix))
+		if err != nil || len(salt) < 16 {
+			return nil, fmt.Errorf("invalid salt in key file %s", file)
+		}
+		return deriveKey(passphrase, salt)
+	}
+	return ParseKey(content)
+}
+
+// createKey writes a new key file for passphrase (a salt) or without one (a random key).
+func createKey(file, passphrase string) (*Key, error) {
+	if passphrase != "" {
+		salt := make([]byte, 16)
+		rand.Read(salt)
+		if err := os.WriteFile(file, []byte(saltPrefix+hex.EncodeToString(salt)+"\n"), 0o600); err != nil {
+			return nil, fmt.Errorf("failed to write key file: %w", err)
+		}
+		return deriveKey(passphrase, salt)
+	}
+	key := NewKey()
+	if err := os.WriteFile(file, []byte(hex.EncodeToString(key[:])+"\n"), 0o600); err != nil {
+		return nil, fmt.Errorf("failed to write key file: %w", err)
+	}
+	return key, nil
+}
+
+// deriveKey stretches passphrase into a key with scrypt.
+func deriveKey(passphrase string, salt []byte) (*Key, error) {
+	derived, err := scrypt.Key([]byte(passphrase), salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive key: %w", err)
+	}
+	var key Key
+	copy(key[:], derived)
+	return &key, nil
+}
+
+// NewKey returns a random key.
+func NewKey() *Key {
+	var key Key
+	rand.Read(key[:])
+	return &key
+}
+
+// ParseKey decodes a key written as 64 hex characters.
+func ParseKey(s string) (*Key, error) {
+	raw, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil || len(raw) != 32 {
+		return nil, fmt.Errorf("key must be 64 hex characters")
+	}
+	var key Key
+	copy(key[:], raw)
+	return &key, nil
+}
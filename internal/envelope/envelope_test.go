@@ -0,0 +1,82 @@
+package envelope
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSealOpen(t *testing.T) {
+	key := NewKey()
+	plaintext := []byte(`{"content":"hello"}`)
+	sealed := Seal(key, plaintext, "event-1")
+	if bytes.Contains(sealed, plaintext) {
+		t.Fatal("Seal() left the plaintext readable")
+	}
+	opened, err := Open(key, sealed, "event-1")
+	if err != nil || !bytes.Equal(opened, plaintext) {
+		t.Fatalf("Open() = %q, %v", opened, err)
+	}
+
+	tampered := append([]byte{}, sealed...)
+	tampered[len(tampered)-1] ^= 1
+	tests := []struct {
+		name   string
+		key    *Key
+		sealed []byte
+		label  string
+	}{
+		{"wrong key", NewKey(), sealed, "event-1"},
+		{"wrong label", key, sealed, "event-2"},
+		{"tampered", key, tampered, "event-1"},
+		{"truncated", key, sealed[:20], "event-1"},
+		{"unknown version", key, append([]byte{2}, sealed[1:]...), "event-1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Open(tt.key, tt.sealed, tt.label); err == nil {
+				t.Error("Open() succeeded")
+			}
+		})
+	}
+}
+
+func TestLoadKey(t *testing.T) {
+	dir := t.TempDir()
+
+	file := filepath.Join(dir, "random.key")
+	key, err := LoadKey(file, "")
+	if err != nil {
+		t.Fatalf("LoadKey() error = %v", err)
+	}
+	if info, _ := os.Stat(file); info.Mode().Perm() != 0o600 {
+		t.Errorf("key file mode = %v, want 0600", info.Mode().Perm())
+	}
+	if again, err := LoadKey(file, ""); err != nil || *again != *key {
+		t.Errorf("LoadKey() again = %x, %v, want the stored key", again, err)
+	}
+	if _, err := LoadKey(file, "secret"); err == nil {
+		t.Error("LoadKey() with a passphrase accepted a key file")
+	}
+
+	salted := filepath.Join(dir, "passphrase.key")
+	derived, err := LoadKey(salted, "correct horse")
+	if err != nil {
+		t.Fatalf("LoadKey() with a passphrase error = %v", err)
+	}
+	data, _ := os.ReadFile(salted)
+	if !strings.HasPrefix(string(data), saltPrefix) {
+		t.Errorf("passphrase key file = %q, want a salt", data)
+	}
+	if again, _ := LoadKey(salted, "correct horse"); *again != *derived {
+		t.Error("LoadKey() derived another key from the same passphrase")
+	}
+	if other, _ := LoadKey(salted, "wrong horse"); *other == *derived {
+		t.Error("LoadKey() derived the same key from another passphrase")
+	}
+	if _, err := LoadKey(salted, ""); err == nil {
+		t.Error("LoadKey() without a passphrase accepted a salt file")
+	}
+}
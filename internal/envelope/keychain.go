@@ -0,0 +1,30 @@
+package envelope
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/girino/renoter/internal/keychain"
+)
+
+// KeychainKey returns the key stored in the OS keychain under account, generating and storing
+// one if there is none.
+func KeychainKey(account string) (*Key, error) {
+	stored, err := keychain.Get(account)
+	if err == nil {
+		key, err := ParseKey(stored)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key in the keychain: %w", err)
+		}
+		return key, nil
+	}
+	if !errors.Is(err, keychain.ErrNotFound) {
+		return nil, err
+	}
+	key := NewKey()
+	if err := keychain.Set(account, hex.EncodeToString(key[:])); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
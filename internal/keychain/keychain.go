@@ -0,0 +1,168 @@
+package keychain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Service is the service name secrets are stored under.
+const Service = "renoter"
+
+// ErrNotFound is returned by Get when the keychain holds no secret for the account.
+var ErrNotFound = errors.New("no secret in the keychain")
+
+// toolError is a keychain tool that ran and exited with a non-zero status.
+type toolError struct {
+	// Name of the tool
+	Name string
+	// Exit status
+	Code int
+	// What the tool printed on stderr
+	Stderr string
+}
+
+func (e *toolError) Error() string {
+	return fmt.Sprintf("%s: exit status %d: %s", e.Name, e.Code, e.Stderr)
+}
+
+// runCommand runs a keychain tool with stdin and returns its output, and a *toolError if it exited
+// with a non-zero status; tests replace it.
+var runCommand = func(stdin string, name string, args ...string) ([]byte, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return out, &toolError{Name: name, Code: exitErr.ExitCode(), Stderr: strings.TrimSpace(stderr.String())}
+	}
+	if err != nil {
+		return out, fmt.Errorf("%s: %w", name, err)
+	}
+	return out, nil
+}
+
+// notFound reports whether a lookup failed with err and out only because the keychain holds no
+// such item: exit status 44 for security, and exit status 1 without any output for secret-tool.
+// A locked keychain, a missing D-Bus session or a cancelled prompt fail otherwise, and must not
+// make the caller store a new secret over the existing one.
+func notFound(out []byte, err error) bool {
+	var toolErr *toolError
+	if !errors.As(err, &toolErr) {
+		return false
+	}
+	switch goos {
+	case "darwin":
+		return toolErr.Code == 44
+	default:
+		return toolErr.Code == 1 && len(bytes.TrimSpace(out)) == 0 && toolErr.Stderr == ""
+	}
+}
+
+// goos is runtime.GOOS; tests replace it.
+var goos = runtime.GOOS
+
+// Get returns the secret stored for account: in the macOS Keychain (security), the Linux secret
+// service (secret-tool, libsecret) or, on Windows, a file in the user's config directory
+// encrypted with DPAPI for the current user (through PowerShell).
+func Get(account string) (string, error) {
+	var out []byte
+	var err error
+	switch goos {
+	case "darwin":
+		out, err = runCommand("", "security", "find-generic-password", "-s", Service, "-a", account, "-w")
+	case "linux", "freebsd", "openbsd":
+		out, err = runCommand("", "secret-tool", "lookup", "service", Service, "account", account)
+	case "windows":
+		file, ferr := dpapiFile(account)
+		if ferr != nil {
+			return "", ferr
+		}
+		blob, rerr := os.ReadFile(file)
+		if errors.Is(rerr, os.ErrNotExist) {
+			return "", ErrNotFound
+		}
+		if rerr != nil {
+			return "", fmt.Errorf("failed to read %s: %w", file, rerr)
+		}
+		out, err = runCommand(string(blob), "powershell", "-NoProfile", "-NonInteractive", "-Command",
+			"$s = ConvertTo-SecureString ([Console]::In.ReadToEnd().Trim()); [Runtime.InteropServices.Marshal]::PtrToStringAuto([Runtime.InteropServices.Marshal]::SecureStringToBSTR($s))")
+	default:
+		return "", fmt.Errorf("no OS keychain support on %s", goos)
+	}
+	if errors.Is(err, exec.ErrNotFound) {
+		return "", fmt.Errorf("keychain tool not installed: %w", err)
+	}
+	if err != nil && goos == "windows" {
+		return "", fmt.Errorf("failed to decrypt keychain secret: %w", err)
+	}
+	if notFound(out, err) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read the keychain: %w", err)
+	}
+	secret := strings.TrimSpace(string(out))
+	if secret == "" {
+		return "", fmt.Errorf("the keychain holds an empty secret for %s", account)
+	}
+	return secret, nil
+}
+
+// Set stores secret for account, replacing any earlier one.
+func Set(account, secret string) error {
+	var err error
+	switch goos {
+	case "darwin":
+		// security only takes the secret as an argument, which any user sees in ps: pass the
+		// command on stdin to its interactive mode instead. -U updates an existing item.
+		if strings.ContainsAny(account+secret, " \t\n\"\\'") {
+			return fmt.Errorf("cannot store a secret or account with spaces or quotes in the macOS Keychain")
+		}
+		_, err = runCommand(fmt.Sprintf("add-generic-password -U -s %s -a %q -w %q\n", Service, account, secret), "security", "-i")
+		if err == nil {
+			// security -i keeps going after a failed command, so read the secret back
+			var stored string
+			if stored, err = Get(account); err == nil && stored != secret {
+				err = fmt.Errorf("the keychain holds another secret after storing it")
+			}
+		}
+	case "linux", "freebsd", "openbsd":
+		_, err = runCommand(secret, "secret-tool", "store", "--label=Renoter "+account, "service", Service, "account", account)
+	case "windows":
+		var file string
+		if file, err = dpapiFile(account); err != nil {
+			return err
+		}
+		var blob []byte
+		blob, err = runCommand(secret, "powershell", "-NoProfile", "-NonInteractive", "-Command",
+			"ConvertTo-SecureString ([Console]::In.ReadToEnd().Trim()) -AsPlainText -Force | ConvertFrom-SecureString")
+		if err == nil {
+			if err = os.MkdirAll(filepath.Dir(file), 0o700); err == nil {
+				err = os.WriteFile(file, bytes.TrimSpace(blob), 0o600)
+			}
+		}
+	default:
+		return fmt.Errorf("no OS keychain support on %s", goos)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to store secret in the keychain: %w", err)
+	}
+	return nil
+}
+
+// dpapiFile returns the file the DPAPI-encrypted secret of account is kept in on Windows.
+func dpapiFile(account string) (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("no user config directory for the keychain: %w", err)
+	}
+	return filepath.Join(dir, Service, "keychain", account+".dpapi"), nil
+}
@@ -0,0 +1,144 @@
+package keychain
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// fakeTools replaces the keychain tools of goos with an in-memory store, returning it.
+func fakeTools(t *testing.T, platform string) map[string]string {
+	t.Helper()
+	origRun, origOS := runCommand, goos
+	t.Cleanup(func() { runCommand, goos = origRun, origOS })
+	goos = platform
+	store := map[string]string{}
+	runCommand = func(stdin string, name string, args ...string) ([]byte, error) {
+		if name == "security" && args[0] == "-i" {
+			// The command comes on stdin, with quoted arguments
+			args = strings.Fields(strings.ReplaceAll(stdin, `"`, ""))
+		}
+		// The account follows -a for security and comes last for secret-tool
+		account := args[len(args)-1]
+		for i, arg := range args[:len(args)-1] {
+			if arg == "-a" {
+				account = args[i+1]
+			}
+		}
+		switch {
+		case name == "powershell" && strings.Contains(strings.Join(args, " "), "ConvertFrom-SecureString"):
+			// Stands in for the DPAPI blob
+			return []byte("blob:" + stdin + "\r\n"), nil
+		case name == "powershell":
+			return []byte(strings.TrimPrefix(stdin, "blob:")), nil
+		case args[0] == "find-generic-password" || args[0] == "lookup":
+			secret, ok := store[account]
+			if !ok && name == "security" {
+				return nil, &toolError{Name: name, Code: 44, Stderr: "The specified item could not be found in the keychain."}
+			}
+			if !ok {
+				return nil, &toolError{Name: name, Code: 1}
+			}
+			return []byte(secret + "\n"), nil
+		case args[0] == "add-generic-password":
+			store[account] = args[slices.Index(args, "-w")+1]
+		case args[0] == "store":
+			store[account] = stdin
+		default:
+			t.Fatalf("unexpected command %s %v", name, args)
+		}
+		return nil, nil
+	}
+	return store
+}
+
+func TestGetSet(t *testing.T) {
+	for _, platform := range []string{"darwin", "linux", "windows"} {
+		t.Run(platform, func(t *testing.T) {
+			fakeTools(t, platform)
+			if platform == "windows" {
+				t.Setenv("APPDATA", t.TempDir())
+				t.Setenv("XDG_CONFIG_HOME", os.Getenv("APPDATA"))
+			}
+			if _, err := Get("server"); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("Get() of a missing secret error = %v, want ErrNotFound", err)
+			}
+			if err := Set("server", "s3cret"); err != nil {
+				t.Fatalf("Set() error = %v", err)
+			}
+			if secret, err := Get("server"); err != nil || secret != "s3cret" {
+				t.Errorf("Get() = %q, %v, want the stored secret", secret, err)
+			}
+		})
+	}
+}
+
+func TestUnsupportedPlatform(t *testing.T) {
+	fakeTools(t, "plan9")
+	if _, err := Get("server"); err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() error = %v, want an unsupported platform error", err)
+	}
+	if err := Set("server", "s3cret"); err == nil {
+		t.Error("Set() succeeded on an unsupported platform")
+	}
+}
+
+func TestMissingTool(t *testing.T) {
+	fakeTools(t, "linux")
+	runCommand = func(stdin string, name string, args ...string) ([]byte, error) {
+		return nil, fmt.Errorf("%s: %w", name, exec.ErrNotFound)
+	}
+	if _, err := Get("server"); err == nil || errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() without secret-tool error = %v, want a missing tool error", err)
+	}
+}
+
+func TestLockedKeychain(t *testing.T) {
+	// Failures other than a missing item must not read as one, or a new key would replace the
+	// stored one
+	for _, tc := range []struct {
+		platform string
+		err      *toolError
+	}{
+		{"darwin", &toolError{Name: "security", Code: 36, Stderr: "User interaction is not allowed."}},
+		{"darwin", &toolError{Name: "security", Code: 128}},
+		{"linux", &toolError{Name: "secret-tool", Code: 1, Stderr: "Cannot autolaunch D-Bus without X11 $DISPLAY"}},
+		{"linux", &toolError{Name: "secret-tool", Code: 1, Stderr: "Cannot create an item in a locked collection"}},
+	} {
+		fakeTools(t, tc.platform)
+		runCommand = func(stdin string, name string, args ...string) ([]byte, error) {
+			return nil, tc.err
+		}
+		if _, err := Get("server"); err == nil || errors.Is(err, ErrNotFound) {
+			t.Errorf("Get() on %s with %v error = %v, want a keychain error", tc.platform, tc.err, err)
+		}
+	}
+}
+
+func TestSetKeepsSecretOffCommandLine(t *testing.T) {
+	for _, platform := range []string{"darwin", "linux", "windows"} {
+		fakeTools(t, platform)
+		if platform == "windows" {
+			t.Setenv("APPDATA", t.TempDir())
+			t.Setenv("XDG_CONFIG_HOME", os.Getenv("APPDATA"))
+		}
+		fake := runCommand
+		runCommand = func(stdin string, name string, args ...string) ([]byte, error) {
+			if slices.Contains(args, "s3cret") {
+				t.Errorf("%s: %s got the secret as an argument: %v", platform, name, args)
+			}
+			return fake(stdin, name, args...)
+		}
+		if err := Set("server", "s3cret"); err != nil {
+			t.Errorf("%s: Set() error = %v", platform, err)
+		}
+	}
+	fakeTools(t, "darwin")
+	if err := Set("server", `s3cret" -a other`); err == nil {
+		t.Error("Set() of a secret with quotes succeeded on darwin")
+	}
+}
@@ -0,0 +1,193 @@
+package lightning
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/girino/nostr-lib/logging"
+)
+
+// maxResponseSize bounds LNURL responses, which are small JSON documents.
+const maxResponseSize = 64 * 1024
+
+// PayParams is the LUD-06 payRequest a lightning address resolves to.
+type PayParams struct {
+	Callback    string `json:"callback"`
+	MinSendable int64  `json:"minSendable"`
+	MaxSendable int64  `json:"maxSendable"`
+	Tag         string `json:"tag"`
+	Status      string `json:"status"`
+	Reason      string `json:"reason"`
+}
+
+// Invoice is a LUD-06 callback response; Verify is the LUD-21 verification URL.
+type Invoice struct {
+	PR     string `json:"pr"`
+	Verify string `json:"verify"`
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+}
+
+// VerifyResult is a LUD-21 verify response.
+type VerifyResult struct {
+	Settled  bool   `json:"settled"`
+	Preimage string `json:"preimage"`
+	PR       string `json:"pr"`
+	Status   string `json:"status"`
+	Reason   string `json:"reason"`
+}
+
+// AddressURL returns the LUD-16 well-known URL for a lightning address (name@domain).
+func AddressURL(address string) (string, error) {
+	name, domain, ok := strings.Cut(address, "@")
+	if !ok || name == "" || domain == "" {
+		return "", fmt.Errorf("invalid lightning address %q", address)
+	}
+	scheme := "https"
+	// Plain HTTP is only sensible for local testing
+	if host, _, _ := strings.Cut(domain, ":"); host == "localhost" || host == "127.0.0.1" {
+		scheme = "http"
+	}
+	return fmt.Sprintf("%s://%s/.well-known/lnurlp/%s", scheme, domain, url.PathEscape(name)), nil
+}
+
+// AddressDomain returns the host part of a lightning address.
+func AddressDomain(address string) string {
+	_, domain, _ := strings.Cut(address, "@")
+	return domain
+}
+
+// ResolveAddress fetches the LUD-06 pay parameters for a lightning address.
+func ResolveAddress(ctx context.Context, client *http.Client, address string) (*PayParams, error) {
+	addressURL, err := AddressURL(address)
+	if err != nil {
+		return nil, err
+	}
+	var params PayParams
+	if err := getJSON(ctx, client, addressURL, &params); err != nil {
+		return nil, fmt.Errorf("failed to resolve lightning address %s: %w", address, err)
+	}
+	if params.Status == "ERROR" {
+		return nil, fmt.Errorf("lightning address %s: %s", address, params.Reason)
+	}
+	if params.Callback == "" {
+		return nil, fmt.Errorf("lightning address %s has no callback", address)
+	}
+	return &params, nil
+}
+
+// RequestInvoice asks the payRequest callback for an invoice of amountMsats.
+func RequestInvoice(ctx context.Context, client *http.Client, params *PayParams, amountMsats int64) (*Invoice, error) {
+	if amountMsats < params.MinSendable || (params.MaxSendable > 0 && amountMsats > params.MaxSendable) {
+		return nil, fmt.Errorf("amount %d msats is outside the accepted range %d-%d", amountMsats, params.MinSendable, params.MaxSendable)
+	}
+	callback, err := url.Parse(params.Callback)
+	if err != nil {
+		return nil, fmt.Errorf("invalid callback URL: %w", err)
+	}
+	query := callback.Query()
+	query.Set("amount", strconv.FormatInt(amountMsats, 10))
+	callback.RawQuery = query.Encode()
+
+	var invoice Invoice
+	if err := getJSON(ctx, client, callback.String(), &invoice); err != nil {
+		return nil, fmt.Errorf("failed to request invoice: %w", err)
+	}
+	if invoice.Status == "ERROR" {
+		return nil, fmt.Errorf("invoice request failed: %s", invoice.Reason)
+	}
+	if invoice.PR == "" {
+		return nil, fmt.Errorf("invoice response has no payment request")
+	}
+	return &invoice, nil
+}
+
+// Verify fetches a LUD-21 verify URL.
+func Verify(ctx context.Context, client *http.Client, verifyURL string) (*VerifyResult, error) {
+	var result VerifyResult
+	if err := getJSON(ctx, client, verifyURL, &result); err != nil {
+		return nil, fmt.Errorf("failed to verify payment: %w", err)
+	}
+	if result.Status == "ERROR" {
+		return nil, fmt.Errorf("payment verification failed: %s", result.Reason)
+	}
+	return &result, nil
+}
+
+// InvoiceAmountMsats returns the amount encoded in a BOLT-11 invoice's human-readable part.
+// Invoices without an amount are rejected, since they cannot prove a fee was paid.
+func InvoiceAmountMsats(bolt11 string) (int64, error) {
+	invoice := strings.ToLower(bolt11)
+	separator := strings.LastIndexByte(invoice, '1')
+	if !strings.HasPrefix(invoice, "ln") || separator < 0 {
+		return 0, fmt.Errorf("not a BOLT-11 invoice")
+	}
+	hrp := invoice[2:separator]
+
+	// Skip the currency prefix (bc, tb, bcrt, ...) to reach the amount digits
+	start := strings.IndexAny(hrp, "0123456789")
+	if start < 0 {
+		return 0, fmt.Errorf("invoice has no amount")
+	}
+	amount := hrp[start:]
+
+	multiplier := byte(0)
+	if last := amount[len(amount)-1]; last < '0' || last > '9' {
+		multiplier = last
+		amount = amount[:len(amount)-1]
+	}
+	value, err := strconv.ParseInt(amount, 10, 64)
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("invalid invoice amount %q", amount)
+	}
+
+	// Amounts are in bitcoin; 1 BTC = 1e11 msats
+	var scale int64
+	switch multiplier {
+	case 0:
+		scale = 100_000_000_000
+	case 'm':
+		scale = 100_000_000
+	case 'u':
+		scale = 100_000
+	case 'n':
+		scale = 100
+	case 'p':
+		if value%10 != 0 {
+			return 0, fmt.Errorf("invalid sub-millisatoshi amount %q", amount)
+		}
+		return value / 10, nil
+	default:
+		return 0, fmt.Errorf("invalid amount multiplier %q", multiplier)
+	}
+	// A crafted amount must not wrap around to a small or negative one
+	if value > math.MaxInt64/scale {
+		return 0, fmt.Errorf("invoice amount %q overflows", amount)
+	}
+	return value * scale, nil
+}
+
+func getJSON(ctx context.Context, client *http.Client, target string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	logging.DebugMethod("lightning", "getJSON", "GET %s returned status %d", target, resp.StatusCode)
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseSize)).Decode(v); err != nil {
+		return fmt.Errorf("invalid response (status %d): %w", resp.StatusCode, err)
+	}
+	return nil
+}
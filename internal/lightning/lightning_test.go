@@ -0,0 +1,95 @@
+package lightning
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestInvoiceAmountMsats(t *testing.T) {
+	tests := []struct {
+		invoice string
+		want    int64
+		wantErr bool
+	}{
+		{"lnbc2500u1pvjluezpp5qqqsyqcyq5rqwzqf", 250_000_000, false},
+		{"lnbc20m1pvjluezpp5qqqsyqcyq5rqwzqf", 2_000_000_000, false},
+		{"lnbc10n1pjq8t3xpp5qqqsyqcyq5rqwzqf", 1_000, false},
+		{"lntb1u1pjq8t3xpp5qqqsyqcyq5rqwzqf", 100_000, false},
+		{"lnbcrt20p1pjq8t3xpp5qqqsyqcyq5rqwzqf", 2, false},
+		{"LNBC1M1PVJLUEZPP5QQQSYQCYQ5RQWZQF", 100_000_000, false},
+		{"lnbc1pvjluezpp5qqqsyqcyq5rqwzqf", 0, true},    // no amount
+		{"lnbc15p1pjq8t3xpp5qqqsyqcyq5rqwzqf", 0, true}, // sub-millisatoshi
+		{"not an invoice", 0, true},
+		{"lnbc922337211pjq8t3xpp5qqqsyqcyq5rqwzqf", 0, true},     // wraps around at 1e11 msats per BTC
+		{"lnbc92233720369m1pjq8t3xpp5qqqsyqcyq5rqwzqf", 0, true}, // wraps around at 1e8 msats per mBTC
+		{"lnbc92233720m1pjq8t3xpp5qqqsyqcyq5rqwzqf", 9_223_372_000_000_000, false},
+	}
+
+	for _, tt := range tests {
+		got, err := InvoiceAmountMsats(tt.invoice)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("InvoiceAmountMsats(%q) error = %v, wantErr %v", tt.invoice, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("InvoiceAmountMsats(%q) = %d, want %d", tt.invoice, got, tt.want)
+		}
+	}
+}
+
+func TestAddressURL(t *testing.T) {
+	if got, _ := AddressURL("alice@example.com"); got != "https://example.com/.well-known/lnurlp/alice" {
+		t.Errorf("AddressURL() = %q", got)
+	}
+	if got, _ := AddressURL("bob@localhost:3000"); got != "http://localhost:3000/.well-known/lnurlp/bob" {
+		t.Errorf("AddressURL() for localhost = %q", got)
+	}
+	if _, err := AddressURL("no-at-sign"); err == nil {
+		t.Error("AddressURL() should reject an address without @")
+	}
+}
+
+func TestPayFlow(t *testing.T) {
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	mux.HandleFunc("/.well-known/lnurlp/alice", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(PayParams{Callback: server.URL + "/callback", MinSendable: 1000, MaxSendable: 1_000_000, Tag: "payRequest"})
+	})
+	mux.HandleFunc("/callback", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("amount") != "2000" {
+			json.NewEncoder(w).Encode(Invoice{Status: "ERROR", Reason: "unexpected amount"})
+			return
+		}
+		json.NewEncoder(w).Encode(Invoice{PR: "lnbc20n1test", Verify: server.URL + "/verify/abc"})
+	})
+	mux.HandleFunc("/verify/abc", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(VerifyResult{Status: "OK", Settled: true, Preimage: "00", PR: "lnbc20n1test"})
+	})
+
+	ctx := context.Background()
+	params, err := ResolveAddress(ctx, server.Client(), "alice@"+strings.Replace(host, "127.0.0.1", "localhost", 1))
+	if err != nil {
+		t.Fatalf("ResolveAddress() error = %v", err)
+	}
+	if _, err := RequestInvoice(ctx, server.Client(), params, 10); err == nil {
+		t.Error("RequestInvoice() should reject amounts below minSendable")
+	}
+	invoice, err := RequestInvoice(ctx, server.Client(), params, 2000)
+	if err != nil {
+		t.Fatalf("RequestInvoice() error = %v", err)
+	}
+	result, err := Verify(ctx, server.Client(), invoice.Verify)
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if !result.Settled || result.PR != invoice.PR {
+		t.Errorf("Verify() = %+v, want settled invoice %s", result, invoice.PR)
+	}
+}
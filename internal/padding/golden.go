@@ -0,0 +1,73 @@
+package padding
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// GoldenVectors holds the padding and container sizing vectors shared by the client and
+// server test suites. Both halves of the protocol must reproduce them exactly.
+type GoldenVectors struct {
+	Padding   []PaddingVector `json:"padding"`
+	Container ContainerVector `json:"container"`
+}
+
+// PaddingVector describes a fixed event and the exact padding expected for a target size.
+type PaddingVector struct {
+	Name        string     `json:"name"`
+	SecretKey   string     `json:"secret_key"`
+	Kind        int        `json:"kind"`
+	Content     string     `json:"content"`
+	CreatedAt   int64      `json:"created_at"`
+	Tags        nostr.Tags `json:"tags"`
+	Target      int        `json:"target"`
+	TagBaseSize int        `json:"tag_base_size"`
+	PaddingLen  int        `json:"padding_len"`
+	WantErr     bool       `json:"want_err"`
+}
+
+// ContainerVector describes the expected sizes of a 29001 container built around a padded 29000.
+type ContainerVector struct {
+	StandardizedSize int `json:"standardized_size"`
+	ContentLen       int `json:"content_len"`
+	JSONSize         int `json:"json_size"`
+}
+
+// LoadGoldenVectors reads golden vectors from a JSON file.
+func LoadGoldenVectors(path string) (*GoldenVectors, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read golden vectors: %w", err)
+	}
+	var vectors GoldenVectors
+	if err := json.Unmarshal(data, &vectors); err != nil {
+		return nil, fmt.Errorf("failed to parse golden vectors: %w", err)
+	}
+	return &vectors, nil
+}
+
+// Event builds the signed event described by the vector.
+func (v PaddingVector) Event() (*nostr.Event, error) {
+	pubkey, err := nostr.GetPublicKey(v.SecretKey)
+	if err != nil {
+		return nil, fmt.Errorf("vector %q: failed to get public key: %w", v.Name, err)
+	}
+	tags := v.Tags
+	if tags == nil {
+		tags = nostr.Tags{}
+	}
+	event := &nostr.Event{
+		Kind:      v.Kind,
+		Content:   v.Content,
+		CreatedAt: nostr.Timestamp(v.CreatedAt),
+		PubKey:    pubkey,
+		Tags:      tags,
+	}
+	if err := event.Sign(v.SecretKey); err != nil {
+		return nil, fmt.Errorf("vector %q: failed to sign event: %w", v.Name, err)
+	}
+	return event, nil
+}
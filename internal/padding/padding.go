@@ -0,0 +1,138 @@
+package padding
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/mailru/easyjson/jwriter"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// TagName is the tag used to carry random padding bytes inside wrapper events.
+const TagName = "padding"
+
+// Marshal serializes an event the way it is measured for padding and encrypted into a layer:
+// go-nostr's generated encoder, with its fixed field order and without HTML escaping.
+// encoding/json must not be used for size-sensitive serialization, since it re-escapes
+// '<', '>', '&', U+2028 and U+2029 in the encoder's output and so changes its length.
+func Marshal(event *nostr.Event) ([]byte, error) {
+	return MarshalTo(nil, event)
+}
+
+// MarshalTo is Marshal reusing the storage of buf when it is large enough, for callers pooling buffers.
+func MarshalTo(buf []byte, event *nostr.Event) ([]byte, error) {
+	w := jwriter.Writer{NoEscapeHTML: true}
+	event.MarshalEasyJSON(&w)
+	if w.Error != nil {
+		return nil, w.Error
+	}
+	return w.BuildBytes(buf[:0])
+}
+
+// PadEventToExactSize adds padding tags to an event to make its serialized size exactly targetSize.
+// Returns a new event with padding tags added, or an error if the base event is too large.
+// Accounts for padding tag overhead before calculating padding needed.
+// Padding tags the event already carries are replaced with fresh random padding, never kept, so
+// padding bytes chosen by a previous hop cannot reach the next one.
+// This is the single implementation shared by the client and the server so both halves
+// of the protocol always produce identically sized containers.
+func PadEventToExactSize(event *nostr.Event, targetSize int) (*nostr.Event, error) {
+	// Create a copy to avoid modifying the original
+	paddedEvent := *event
+	paddedEvent.Tags = StripPadding(event.Tags)
+
+	// Serialize event to get current size (without padding)
+	eventJSON, err := Marshal(&paddedEvent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize event for padding: %w", err)
+	}
+	currentSize := len(eventJSON)
+
+	// Calculate padding tag base size: ["padding",""]
+	tagBaseSize, err := TagBaseSize(&paddedEvent)
+	if err != nil {
+		return nil, err
+	}
+	logging.DebugMethod("padding", "PadEventToExactSize", "Padding tag base size: %d bytes", tagBaseSize)
+
+	// Calculate total size including padding tag base
+	totalSize := currentSize + tagBaseSize
+
+	// Check if base event is too large
+	if totalSize > targetSize {
+		logging.Error("padding.PadEventToExactSize: event base size %d (with tag overhead %d) exceeds target size %d", currentSize, tagBaseSize, targetSize)
+		return nil, fmt.Errorf("event too large: base event size %d bytes exceeds maximum %d bytes", totalSize, targetSize)
+	}
+
+	// Calculate exact padding needed
+	paddingNeeded := targetSize - totalSize
+
+	// Generate padding string of exactly the needed length
+	paddingBytes := make([]byte, (paddingNeeded+1)/2) // Round up
+	if len(paddingBytes) > 0 {
+		if _, err := rand.Read(paddingBytes); err != nil {
+			return nil, fmt.Errorf("failed to generate random padding: %w", err)
+		}
+	}
+	paddingString := hex.EncodeToString(paddingBytes)
+
+	// Truncate to exact length needed
+	if len(paddingString) > paddingNeeded {
+		paddingString = paddingString[:paddingNeeded]
+	}
+
+	// Add padding tag
+	paddedEvent.Tags = append(paddedEvent.Tags, nostr.Tag{TagName, paddingString})
+
+	// Verify final size
+	finalJSON, _ := Marshal(&paddedEvent)
+	if len(finalJSON) != targetSize {
+		logging.Error("padding.PadEventToExactSize: padded event size %d does not match target %d", len(finalJSON), targetSize)
+		return nil, fmt.Errorf("padded event size %d does not match target %d", len(finalJSON), targetSize)
+	}
+
+	logging.DebugMethod("padding", "PadEventToExactSize", "Added padding: %d bytes needed, event size: %d -> target: %d", paddingNeeded, currentSize, targetSize)
+
+	return &paddedEvent, nil
+}
+
+// TagBaseSize returns how many bytes an empty ["padding",""] tag adds to the serialized event.
+// The overhead differs depending on whether the event already has tags (a separating comma is needed).
+func TagBaseSize(event *nostr.Event) (int, error) {
+	return TagSize(event, nostr.Tag{TagName, ""})
+}
+
+// TagSize returns how many bytes appending tag adds to the serialized event, separating comma
+// included, as measured with Marshal.
+func TagSize(event *nostr.Event, tag nostr.Tag) (int, error) {
+	without := *event
+	if without.Tags == nil {
+		without.Tags = nostr.Tags{}
+	}
+	baseJSON, err := Marshal(&without)
+	if err != nil {
+		return 0, fmt.Errorf("failed to serialize event for padding: %w", err)
+	}
+
+	with := without
+	with.Tags = append(with.Tags[:len(with.Tags):len(with.Tags)], tag)
+	taggedJSON, err := Marshal(&with)
+	if err != nil {
+		return 0, fmt.Errorf("failed to serialize event for padding: %w", err)
+	}
+
+	return len(taggedJSON) - len(baseJSON), nil
+}
+
+// StripPadding returns a copy of tags with every padding tag removed.
+func StripPadding(tags nostr.Tags) nostr.Tags {
+	stripped := nostr.Tags{}
+	for _, tag := range tags {
+		if len(tag) == 0 || tag[0] != TagName {
+			stripped = append(stripped, tag)
+		}
+	}
+	return stripped
+}
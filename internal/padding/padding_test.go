@@ -0,0 +1,234 @@
+package padding
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const goldenFile = "testdata/golden.json"
+
+func TestPadEventToExactSize(t *testing.T) {
+	event := &nostr.Event{
+		Kind:      1,
+		Content:   "Small content",
+		CreatedAt: nostr.Now(),
+		PubKey:    nostr.GeneratePrivateKey(),
+	}
+	event.Sign(event.PubKey)
+
+	targetSize := 1000 // 1KB target
+
+	// Test successful padding
+	padded, err := PadEventToExactSize(event, targetSize)
+	if err != nil {
+		t.Fatalf("PadEventToExactSize() error = %v", err)
+	}
+
+	// Verify size
+	jsonBytes, _ := json.Marshal(padded)
+	if len(jsonBytes) != targetSize {
+		t.Errorf("Padded event size = %d, want %d", len(jsonBytes), targetSize)
+	}
+
+	// Verify padding tag exists
+	foundPadding := false
+	for _, tag := range padded.Tags {
+		if len(tag) >= 1 && tag[0] == TagName {
+			foundPadding = true
+			break
+		}
+	}
+	if !foundPadding {
+		t.Error("Padded event should have padding tag")
+	}
+
+	// Original event must not be modified
+	if len(event.Tags) != 0 {
+		t.Errorf("PadEventToExactSize() modified original event tags: %v", event.Tags)
+	}
+}
+
+func TestPadEventToExactSize_TooLarge(t *testing.T) {
+	// Create a large event that exceeds target even with padding tag overhead
+	event := &nostr.Event{
+		Kind:      1,
+		Content:   strings.Repeat("A", 5000),
+		CreatedAt: nostr.Now(),
+		PubKey:    nostr.GeneratePrivateKey(),
+	}
+	event.Sign(event.PubKey)
+
+	// Try to pad to a size smaller than the event
+	_, err := PadEventToExactSize(event, 100)
+	if err == nil {
+		t.Fatal("PadEventToExactSize() should error when event is too large")
+	}
+	if !strings.Contains(err.Error(), "too large") {
+		t.Errorf("Error message should mention 'too large', got: %v", err)
+	}
+}
+
+func TestPadEventToExactSize_ExactSize(t *testing.T) {
+	event := &nostr.Event{
+		Kind:      1,
+		Content:   "Test",
+		CreatedAt: nostr.Now(),
+		PubKey:    nostr.GeneratePrivateKey(),
+	}
+	event.Sign(event.PubKey)
+
+	jsonBytes, _ := json.Marshal(event)
+	tagBaseSize, err := TagBaseSize(event)
+	if err != nil {
+		t.Fatalf("TagBaseSize() error = %v", err)
+	}
+
+	// Odd and even padding lengths, plus zero padding, must all be hit exactly
+	for _, extra := range []int{0, 1, 99, 100} {
+		targetSize := len(jsonBytes) + tagBaseSize + extra
+		padded, err := PadEventToExactSize(event, targetSize)
+		if err != nil {
+			t.Fatalf("PadEventToExactSize(+%d) error = %v", extra, err)
+		}
+		finalJSON, _ := json.Marshal(padded)
+		if len(finalJSON) != targetSize {
+			t.Errorf("Padded event size = %d, want %d", len(finalJSON), targetSize)
+		}
+	}
+}
+
+func TestMarshal_Canonical(t *testing.T) {
+	event := &nostr.Event{
+		Kind:      1,
+		Content:   "<b>fish & chips</b>\u2028",
+		CreatedAt: nostr.Now(),
+		Tags:      nostr.Tags{{"t", "a&b"}, {"client", "<x>"}},
+	}
+	event.Sign(nostr.GeneratePrivateKey())
+
+	first, err := Marshal(event)
+	if err != nil {
+		t.Fatalf("Marshal() error = %v", err)
+	}
+	want, _ := event.MarshalJSON()
+	if string(first) != string(want) {
+		t.Errorf("Marshal() = %s, want go-nostr's serialization %s", first, want)
+	}
+	for i := 0; i < 10; i++ {
+		if again, _ := Marshal(event); string(again) != string(first) {
+			t.Fatalf("Marshal() is not stable between calls: %s != %s", again, first)
+		}
+	}
+	reused, err := MarshalTo(make([]byte, 0, 4096), event)
+	if err != nil || string(reused) != string(first) {
+		t.Errorf("MarshalTo() = %s, %v, want the same serialization as Marshal()", reused, err)
+	}
+
+	// encoding/json re-escapes these characters, which is why it is not used for sizing
+	if escaped, _ := json.Marshal(event); len(escaped) == len(first) {
+		t.Errorf("json.Marshal() length %d unexpectedly matches Marshal(); the regression case no longer exercises escaping", len(escaped))
+	}
+}
+
+func TestPadEventToExactSize_EscapedCharacters(t *testing.T) {
+	event := &nostr.Event{
+		Kind:      1,
+		Content:   "<script>&\u2029</script>",
+		CreatedAt: nostr.Now(),
+		Tags:      nostr.Tags{{"subject", "<&>"}},
+	}
+	event.Sign(nostr.GeneratePrivateKey())
+
+	for _, target := range []int{1000, 1001, 4096} {
+		padded, err := PadEventToExactSize(event, target)
+		if err != nil {
+			t.Fatalf("PadEventToExactSize(%d) error = %v", target, err)
+		}
+		serialized, _ := Marshal(padded)
+		if len(serialized) != target {
+			t.Errorf("padded event serializes to %d bytes, want %d", len(serialized), target)
+		}
+	}
+}
+
+func TestStripPadding(t *testing.T) {
+	tags := nostr.Tags{{"p", "abc"}, {TagName, "ffff"}, {}, {"e", "def"}, {TagName, ""}}
+	stripped := StripPadding(tags)
+	if len(stripped) != 3 {
+		t.Fatalf("StripPadding() returned %d tags, want 3", len(stripped))
+	}
+	for _, tag := range stripped {
+		if len(tag) > 0 && tag[0] == TagName {
+			t.Errorf("StripPadding() left a padding tag: %v", tag)
+		}
+	}
+	// Empty tags are not padding and are kept where they were
+	if len(stripped[1]) != 0 {
+		t.Errorf("StripPadding() dropped or moved the empty tag: %v", stripped)
+	}
+	if len(tags) != 5 {
+		t.Errorf("StripPadding() modified input tags")
+	}
+}
+
+func TestPadEventToExactSize_ReplacesPadding(t *testing.T) {
+	watermark := strings.Repeat("deadbeef", 32)
+	event := &nostr.Event{Kind: 29000, Content: "layer", CreatedAt: nostr.Now(), Tags: nostr.Tags{{"p", "abc"}, {TagName, watermark}}}
+	padded, err := PadEventToExactSize(event, 4096)
+	if err != nil {
+		t.Fatalf("PadEventToExactSize() error = %v", err)
+	}
+	serialized, _ := Marshal(padded)
+	if len(serialized) != 4096 {
+		t.Errorf("padded event serializes to %d bytes, want 4096", len(serialized))
+	}
+	if strings.Contains(string(serialized), watermark) {
+		t.Error("PadEventToExactSize() kept the existing padding")
+	}
+	if got := len(padded.Tags) - len(StripPadding(padded.Tags)); got != 1 {
+		t.Errorf("padded event has %d padding tags, want 1", got)
+	}
+	if len(event.Tags) != 2 || event.Tags[1][1] != watermark {
+		t.Error("PadEventToExactSize() modified the input event")
+	}
+}
+
+func TestPadEventToExactSize_Golden(t *testing.T) {
+	vectors, err := LoadGoldenVectors(goldenFile)
+	if err != nil {
+		t.Fatalf("LoadGoldenVectors() error = %v", err)
+	}
+
+	for _, v := range vectors.Padding {
+		t.Run(v.Name, func(t *testing.T) {
+			event, err := v.Event()
+			if err != nil {
+				t.Fatalf("Event() error = %v", err)
+			}
+
+			tagBaseSize, err := TagBaseSize(event)
+			if err != nil {
+				t.Fatalf("TagBaseSize() error = %v", err)
+			}
+			if tagBaseSize != v.TagBaseSize {
+				t.Errorf("TagBaseSize() = %d, want %d", tagBaseSize, v.TagBaseSize)
+			}
+
+			padded, err := PadEventToExactSize(event, v.Target)
+			if (err != nil) != v.WantErr {
+				t.Fatalf("PadEventToExactSize() error = %v, wantErr %v", err, v.WantErr)
+			}
+			if v.WantErr {
+				return
+			}
+
+			paddingTag := padded.Tags[len(padded.Tags)-1]
+			if paddingTag[0] != TagName || len(paddingTag[1]) != v.PaddingLen {
+				t.Errorf("padding tag length = %d, want %d", len(paddingTag[1]), v.PaddingLen)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+// Package relayinfo reads what relays announce in their NIP-11 documents.
+package relayinfo
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/nbd-wtf/go-nostr/nip11"
+)
+
+// MinPoWDifficulty fetches the NIP-11 document of every relay concurrently and returns the
+// highest limitation.min_pow_difficulty advertised. Relays that cannot be queried are logged
+// and treated as requiring no PoW.
+func MinPoWDifficulty(ctx context.Context, relayURLs []string) int {
+	difficulties := make([]int, len(relayURLs))
+
+	var wg sync.WaitGroup
+	for i, url := range relayURLs {
+		wg.Add(1)
+		go func(i int, url string) {
+			defer wg.Done()
+			info, err := nip11.Fetch(ctx, url)
+			if err != nil {
+				logging.Warn("relayinfo.MinPoWDifficulty: failed to fetch NIP-11 document for %s: %v", url, err)
+				return
+			}
+			if info.Limitation != nil {
+				difficulties[i] = info.Limitation.MinPowDifficulty
+			}
+			logging.DebugMethod("relayinfo", "MinPoWDifficulty", "Relay %s requires PoW difficulty %d", url, difficulties[i])
+		}(i, url)
+	}
+	wg.Wait()
+
+	max := 0
+	for _, difficulty := range difficulties {
+		if difficulty > max {
+			max = difficulty
+		}
+	}
+	return max
+}
+
+// Requirements are the limits a relay must allow to carry the containers of a client or Renoter.
+type Requirements struct {
+	// Length of the EVENT message of the largest container
+	MessageSize int
+	// Length of the content of the largest container
+	ContentSize int
+}
+
+// Compatibility is what the NIP-11 document of a relay says about carrying containers.
+type Compatibility struct {
+	URL string `json:"url"`
+	// Why the document could not be fetched (empty if it was); nothing else is known then
+	Error string `json:"error,omitempty"`
+	// limitation.max_message_length (0 = not advertised)
+	MaxMessageLength int `json:"max_message_length,omitempty"`
+	// limitation.max_content_length (0 = not advertised)
+	MaxContentLength int `json:"max_content_length,omitempty"`
+	// limitation.min_pow_difficulty
+	MinPoWDifficulty int `json:"min_pow_difficulty,omitempty"`
+	// Why the relay would refuse or drop containers (empty = none known)
+	Problems []string `json:"problems,omitempty"`
+}
+
+// Compatible reports whether nothing in the relay's document rules it out. A relay whose document
+// could not be fetched is not ruled out.
+func (c Compatibility) Compatible() bool {
+	return len(c.Problems) == 0
+}
+
+// String summarizes the compatibility in one line.
+func (c Compatibility) String() string {
+	if c.Error != "" {
+		return "NIP-11 document unavailable: " + c.Error
+	}
+	if len(c.Problems) > 0 {
+		return strings.Join(c.Problems, "; ")
+	}
+	limit := func(n int) string {
+		if n == 0 {
+			return "unlimited"
+		}
+		return strconv.Itoa(n) + " bytes"
+	}
+	return fmt.Sprintf("messages %s, content %s, PoW %d", limit(c.MaxMessageLength), limit(c.MaxContentLength), c.MinPoWDifficulty)
+}
+
+// CheckCompatibility fetches the NIP-11 document of every relay concurrently and checks it
+// against req, returning one result per relay in order.
+func CheckCompatibility(ctx context.Context, relayURLs []string, req Requirements) []Compatibility {
+	results := make([]Compatibility, len(relayURLs))
+
+	var wg sync.WaitGroup
+	for i, url := range relayURLs {
+		wg.Add(1)
+		go func(i int, url string) {
+			defer wg.Done()
+			info, err := nip11.Fetch(ctx, url)
+			if err != nil {
+				logging.Warn("relayinfo.CheckCompatibility: failed to fetch NIP-11 document for %s: %v", url, err)
+				results[i] = Compatibility{URL: url, Error: err.Error()}
+				return
+			}
+			results[i] = compatibility(url, info, req)
+			logging.DebugMethod("relayinfo", "CheckCompatibility", "Relay %s: %s", url, results[i])
+		}(i, url)
+	}
+	wg.Wait()
+	return results
+}
+
+// compatibility checks a fetched NIP-11 document against req.
+func compatibility(url string, info nip11.RelayInformationDocument, req Requirements) Compatibility {
+	c := Compatibility{URL: url}
+	if info.Limitation != nil {
+		c.MaxMessageLength = info.Limitation.MaxMessageLength
+		c.MaxContentLength = info.Limitation.MaxContentLength
+		c.MinPoWDifficulty = info.Limitation.MinPowDifficulty
+	}
+	if c.MaxMessageLength > 0 && c.MaxMessageLength < req.MessageSize {
+		c.Problems = append(c.Problems, fmt.Sprintf("max_message_length %d is below the %d bytes of a container message", c.MaxMessageLength, req.MessageSize))
+	}
+	if c.MaxContentLength > 0 && c.MaxContentLength < req.ContentSize {
+		c.Problems = append(c.Problems, fmt.Sprintf("max_content_length %d is below the %d bytes of a container's content", c.MaxContentLength, req.ContentSize))
+	}
+	if !supportsEphemeral(info) {
+		c.Problems = append(c.Problems, "supported_nips lists neither NIP-01 nor NIP-16, so ephemeral events may not be relayed")
+	}
+	return c
+}
+
+// supportsEphemeral reports whether a relay relays ephemeral events, as far as its document says:
+// they are part of NIP-01 and were NIP-16 before. Relays listing no NIPs at all are given the
+// benefit of the doubt.
+func supportsEphemeral(info nip11.RelayInformationDocument) bool {
+	if len(info.SupportedNIPs) == 0 {
+		return true
+	}
+	for _, nip := range info.SupportedNIPs {
+		// Numbers decode as float64, and some relays list them as strings
+		switch fmt.Sprint(nip) {
+		case "1", "01", "16":
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+package relayinfo
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fiatjaf/khatru"
+	"github.com/nbd-wtf/go-nostr/nip11"
+)
+
+// startRelay serves a khatru relay advertising the given min_pow_difficulty (negative for no limitation).
+func startRelay(t *testing.T, minPoW int) string {
+	t.Helper()
+	relay := khatru.NewRelay()
+	if minPoW >= 0 {
+		relay.Info.Limitation = &nip11.RelayLimitationDocument{MinPowDifficulty: minPoW}
+	}
+	server := httptest.NewServer(relay)
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func TestMinPoWDifficulty(t *testing.T) {
+	urls := []string{startRelay(t, -1), startRelay(t, 12), startRelay(t, 20)}
+	if got := MinPoWDifficulty(context.Background(), urls); got != 20 {
+		t.Errorf("MinPoWDifficulty() = %d, want 20", got)
+	}
+}
+
+func TestMinPoWDifficulty_UnreachableRelay(t *testing.T) {
+	urls := []string{startRelay(t, 8), "ws://127.0.0.1:1"}
+	if got := MinPoWDifficulty(context.Background(), urls); got != 8 {
+		t.Errorf("MinPoWDifficulty() = %d, want 8 (unreachable relays ignored)", got)
+	}
+}
+
+func TestMinPoWDifficulty_NoRelays(t *testing.T) {
+	if got := MinPoWDifficulty(context.Background(), nil); got != 0 {
+		t.Errorf("MinPoWDifficulty(nil) = %d, want 0", got)
+	}
+}
+
+func TestCheckCompatibility(t *testing.T) {
+	// serve starts a relay whose document is changed by modify
+	serve := func(modify func(info *nip11.RelayInformationDocument)) string {
+		relay := khatru.NewRelay()
+		modify(relay.Info)
+		server := httptest.NewServer(relay)
+		t.Cleanup(server.Close)
+		return "ws" + strings.TrimPrefix(server.URL, "http")
+	}
+	urls := []string{
+		serve(func(info *nip11.RelayInformationDocument) {}),
+		serve(func(info *nip11.RelayInformationDocument) {
+			info.Limitation = &nip11.RelayLimitationDocument{MaxMessageLength: 65536, MaxContentLength: 40000, MinPowDifficulty: 8}
+		}),
+		serve(func(info *nip11.RelayInformationDocument) { info.SupportedNIPs = []any{11, 42} }),
+		"ws://127.0.0.1:1",
+	}
+	results := CheckCompatibility(context.Background(), urls, Requirements{MessageSize: 44000, ContentSize: 43800})
+	if len(results) != len(urls) {
+		t.Fatalf("CheckCompatibility() returned %d results, want %d", len(results), len(urls))
+	}
+
+	if !results[0].Compatible() || results[0].Error != "" {
+		t.Errorf("default relay = %+v, want compatible", results[0])
+	}
+	if results[1].Compatible() || len(results[1].Problems) != 1 || !strings.Contains(results[1].Problems[0], "max_content_length") || results[1].MinPoWDifficulty != 8 {
+		t.Errorf("limited relay = %+v, want only its content length flagged", results[1])
+	}
+	if results[2].Compatible() || !strings.Contains(results[2].String(), "ephemeral") {
+		t.Errorf("relay without NIP-01 = %+v, want ephemeral events flagged", results[2])
+	}
+	if !results[3].Compatible() || results[3].Error == "" {
+		t.Errorf("unreachable relay = %+v, want an error and no problems", results[3])
+	}
+	for i, result := range results {
+		if result.URL != urls[i] {
+			t.Errorf("result %d is for %s, want %s", i, result.URL, urls[i])
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package relaypool
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Pool is the relay operations the Renoter components need: subscribing, fetching stored events
+// and publishing. *nostr.SimplePool and *Publisher implement it; tests can supply fakes, and
+// embedders pools with custom dialers (Tor), metrics or circuit breakers.
+type Pool interface {
+	SubscribeMany(ctx context.Context, urls []string, filter nostr.Filter, opts ...nostr.SubscriptionOption) chan nostr.RelayEvent
+	FetchMany(ctx context.Context, urls []string, filter nostr.Filter, opts ...nostr.SubscriptionOption) chan nostr.RelayEvent
+	PublishMany(ctx context.Context, urls []string, evt nostr.Event) chan nostr.PublishResult
+}
+
+var (
+	_ Pool = (*nostr.SimplePool)(nil)
+	_ Pool = (*Publisher)(nil)
+)
+
+// closePoll is how often CloseRelays checks whether a relay's subscriptions are closed.
+const closePoll = 10 * time.Millisecond
+
+// CloseRelays closes pool and every relay connection it holds; SimplePool.Close alone leaves the
+// connections open. Subscriptions whose contexts were cancelled send CLOSE asynchronously, so
+// each relay is disconnected once it has none left, or when ctx is done, in which case the
+// relays are closed anyway and an error is returned.
+func CloseRelays(ctx context.Context, pool *nostr.SimplePool) error {
+	var err error
+	pool.Relays.Range(func(url string, relay *nostr.Relay) bool {
+		if relay == nil {
+			return true
+		}
+		for relay.IsConnected() && relay.Subscriptions.Size() > 0 && ctx.Err() == nil {
+			time.Sleep(closePoll)
+		}
+		if n := relay.Subscriptions.Size(); n > 0 && err == nil {
+			err = fmt.Errorf("%s still had %d subscriptions open: %w", url, n, ctx.Err())
+		}
+		relay.Close()
+		return true
+	})
+	pool.Relays.Clear()
+	pool.Close("closed")
+	return err
+}
+
+// SubscribeMany subscribes to every url like SimplePool.SubscribeMany. Each subscription counts
+// against MaxConnections until it ends.
+func (p *Publisher) SubscribeMany(ctx context.Context, urls []string, filter nostr.Filter, opts ...nostr.SubscriptionOption) chan nostr.RelayEvent {
+	return p.relayEvents(ctx, urls, func(url string) chan nostr.RelayEvent {
+		return p.pool.SubscribeMany(ctx, []string{url}, filter, opts...)
+	})
+}
+
+// FetchMany fetches stored events from every url like SimplePool.FetchMany, within MaxConnections.
+func (p *Publisher) FetchMany(ctx context.Context, urls []string, filter nostr.Filter, opts ...nostr.SubscriptionOption) chan nostr.RelayEvent {
+	return p.relayEvents(ctx, urls, func(url string) chan nostr.RelayEvent {
+		return p.pool.FetchMany(ctx, []string{url}, filter, opts...)
+	})
+}
+
+// relayEvents runs query on each url once a connection slot is free and merges the events until
+// ctx is done. Unlike SimplePool, an event held by several relays is delivered once per relay.
+func (p *Publisher) relayEvents(ctx context.Context, urls []string, query func(url string) chan nostr.RelayEvent) chan nostr.RelayEvent {
+	ch := make(chan nostr.RelayEvent)
+
+	var wg sync.WaitGroup
+	for _, url := range urls {
+		wg.Add(1)
+		go func(url string) {
+			defer wg.Done()
+			nm := p.acquire(url)
+			defer func() {
+				_, connected := p.pool.Relays.Load(nm)
+				p.release(nm, connected)
+			}()
+			for event := range query(url) {
+				select {
+				case ch <- event:
+				case <-ctx.Done():
+					// The reader is gone; the pool stops the query with ctx
+					return
+				}
+			}
+		}(url)
+	}
+
+	go func() {
+		wg.Wait()
+		close(ch)
+	}()
+	return ch
+}
@@ -0,0 +1,261 @@
+package relaypool
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// reapInterval is how often idle connections are looked for; idle timeouts are only as precise as this.
+const reapInterval = 15 * time.Second
+
+// Options bounds the relay connections a Publisher keeps open.
+type Options struct {
+	// Maximum number of relays connected at once (0 = unlimited)
+	MaxConnections int
+	// Connections unused for this long are closed (0 = never)
+	IdleTimeout time.Duration
+}
+
+// DefaultOptions returns the limits used when none are configured.
+func DefaultOptions() Options {
+	return Options{
+		MaxConnections: 16,
+		IdleTimeout:    5 * time.Minute,
+	}
+}
+
+// Validate checks that the options are usable.
+func (o Options) Validate() error {
+	if o.MaxConnections < 0 {
+		return fmt.Errorf("max connections must not be negative, got %d", o.MaxConnections)
+	}
+	if o.IdleTimeout < 0 {
+		return fmt.Errorf("idle timeout must not be negative, got %v", o.IdleTimeout)
+	}
+	return nil
+}
+
+// connection tracks one relay opened by the Publisher.
+type connection struct {
+	lastUsed time.Time
+	inUse    int
+}
+
+// Publisher publishes events through its own SimplePool, separate from any subscription pool so
+// that publishing never competes with a long-lived subscription for the same socket. It opens at
+// most MaxConnections relays at once, closing the least recently used idle connection to make room,
+// and closes connections that stay idle longer than IdleTimeout.
+type Publisher struct {
+	pool *nostr.SimplePool
+	opts Options
+	// Stops the reaper, see Close
+	stop context.CancelFunc
+
+	mu          sync.Mutex
+	cond        *sync.Cond
+	connections map[string]*connection
+}
+
+// NewPublisher creates a Publisher whose connections live until ctx is done.
+func NewPublisher(ctx context.Context, opts Options) (*Publisher, error) {
+	if err := opts.Validate(); err != nil {
+		logging.Error("relaypool.NewPublisher: invalid options: %v", err)
+		return nil, fmt.Errorf("invalid pool options: %w", err)
+	}
+
+	ctx, stop := context.WithCancel(ctx)
+	p := &Publisher{
+		pool:        nostr.NewSimplePool(ctx),
+		opts:        opts,
+		stop:        stop,
+		connections: make(map[string]*connection),
+	}
+	p.cond = sync.NewCond(&p.mu)
+	go p.reapLoop(ctx)
+
+	logging.DebugMethod("relaypool", "NewPublisher", "Created publisher (max connections %d, idle timeout %v)", opts.MaxConnections, opts.IdleTimeout)
+	return p, nil
+}
+
+// SetOptions validates and applies new limits. Connections above a lowered cap are closed as
+// they become idle.
+func (p *Publisher) SetOptions(opts Options) error {
+	if err := opts.Validate(); err != nil {
+		logging.Error("relaypool.SetOptions: invalid options: %v", err)
+		return fmt.Errorf("invalid pool options: %w", err)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.opts = opts
+	p.cond.Broadcast()
+	logging.DebugMethod("relaypool", "SetOptions", "Publisher limits set to max connections %d, idle timeout %v", opts.MaxConnections, opts.IdleTimeout)
+	return nil
+}
+
+// Connect opens a connection to url, failing if the relay is unreachable. It is used to check
+// relays at startup; the connection is subject to the same limits as any other.
+func (p *Publisher) Connect(url string) error {
+	nm := p.acquire(url)
+	_, err := p.pool.EnsureRelay(url)
+	p.release(nm, err == nil)
+	return err
+}
+
+// PublishMany publishes evt to every url, like SimplePool.PublishMany, but never has more than
+// MaxConnections relays connected at once. Results are emitted as they are received.
+func (p *Publisher) PublishMany(ctx context.Context, urls []string, evt nostr.Event) chan nostr.PublishResult {
+	ch := make(chan nostr.PublishResult, len(urls))
+
+	var wg sync.WaitGroup
+	for _, url := range urls {
+		wg.Add(1)
+		go func(url string) {
+			defer wg.Done()
+			nm := p.acquire(url)
+			connected := false
+			for result := range p.pool.PublishMany(ctx, []string{url}, evt) {
+				connected = result.Relay != nil
+				ch <- result
+			}
+			p.release(nm, connected)
+		}(url)
+	}
+
+	go func() {
+		wg.Wait()
+		close(ch)
+	}()
+	return ch
+}
+
+// Close stops the Publisher and closes its relay connections, see CloseRelays. Publishing
+// after Close fails.
+func (p *Publisher) Close(ctx context.Context) error {
+	p.stop()
+	err := CloseRelays(ctx, p.pool)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	clear(p.connections)
+	p.cond.Broadcast()
+	return err
+}
+
+// OpenConnections returns the number of relays currently tracked as connected.
+func (p *Publisher) OpenConnections() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.connections)
+}
+
+// acquire waits until url may be used without exceeding MaxConnections, evicting the least
+// recently used idle connection if needed, and marks it in use. Returns the normalized URL.
+func (p *Publisher) acquire(url string) string {
+	nm := nostr.NormalizeURL(url)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for {
+		if conn, ok := p.connections[nm]; ok {
+			conn.inUse++
+			return nm
+		}
+		if p.opts.MaxConnections == 0 || len(p.connections) < p.opts.MaxConnections || p.evictIdleLocked() {
+			p.connections[nm] = &connection{inUse: 1}
+			return nm
+		}
+		// Every open connection is busy; wait for one to be released
+		p.cond.Wait()
+	}
+}
+
+// release marks one use of nm as finished. Relays that could not be connected stop counting
+// against the cap once no one is using them.
+func (p *Publisher) release(nm string, connected bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if conn, ok := p.connections[nm]; ok {
+		conn.inUse--
+		conn.lastUsed = time.Now()
+		if !connected && conn.inUse == 0 {
+			delete(p.connections, nm)
+		}
+	}
+	// Shrink towards a cap lowered by SetOptions
+	for p.opts.MaxConnections > 0 && len(p.connections) > p.opts.MaxConnections {
+		if !p.evictIdleLocked() {
+			break
+		}
+	}
+	p.cond.Broadcast()
+}
+
+// evictIdleLocked closes the least recently used idle connection. Returns false if all are in use.
+func (p *Publisher) evictIdleLocked() bool {
+	var oldest string
+	for nm, conn := range p.connections {
+		if conn.inUse == 0 && (oldest == "" || conn.lastUsed.Before(p.connections[oldest].lastUsed)) {
+			oldest = nm
+		}
+	}
+	if oldest == "" {
+		return false
+	}
+	logging.DebugMethod("relaypool", "evictIdle", "Connection cap %d reached, closing %s", p.opts.MaxConnections, oldest)
+	p.closeLocked(oldest)
+	return true
+}
+
+// reapIdle closes connections that have been idle for longer than IdleTimeout as of now.
+func (p *Publisher) reapIdle(now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.opts.IdleTimeout == 0 {
+		return
+	}
+
+	var idle []string
+	for nm, conn := range p.connections {
+		if conn.inUse == 0 && now.Sub(conn.lastUsed) > p.opts.IdleTimeout {
+			idle = append(idle, nm)
+		}
+	}
+	sort.Strings(idle)
+	for _, nm := range idle {
+		logging.DebugMethod("relaypool", "reapIdle", "Closing %s after %v idle", nm, now.Sub(p.connections[nm].lastUsed))
+		p.closeLocked(nm)
+	}
+	if len(idle) > 0 {
+		p.cond.Broadcast()
+	}
+}
+
+func (p *Publisher) reapLoop(ctx context.Context) {
+	ticker := time.NewTicker(reapInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			p.reapIdle(now)
+		}
+	}
+}
+
+// closeLocked disconnects nm and forgets it; the next use reconnects.
+func (p *Publisher) closeLocked(nm string) {
+	delete(p.connections, nm)
+	if relay, ok := p.pool.Relays.LoadAndDelete(nm); ok && relay != nil {
+		if err := relay.Close(); err != nil {
+			logging.DebugMethod("relaypool", "close", "Error closing %s: %v", nm, err)
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package relaypool
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fiatjaf/khatru"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// startRelay serves a khatru relay that accepts and discards every event.
+func startRelay(t *testing.T) string {
+	t.Helper()
+	relay := khatru.NewRelay()
+	relay.StoreEvent = append(relay.StoreEvent, func(ctx context.Context, event *nostr.Event) error { return nil })
+	server := httptest.NewServer(relay)
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http")
+}
+
+func newTestEvent(t *testing.T) nostr.Event {
+	t.Helper()
+	event := nostr.Event{Kind: 1, CreatedAt: nostr.Now(), Tags: nostr.Tags{}, Content: "relaypool"}
+	if err := event.Sign(nostr.GeneratePrivateKey()); err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+	return event
+}
+
+func TestOptions_Validate(t *testing.T) {
+	if err := DefaultOptions().Validate(); err != nil {
+		t.Errorf("DefaultOptions().Validate() error = %v", err)
+	}
+	if err := (Options{MaxConnections: -1}).Validate(); err == nil {
+		t.Error("Validate() should reject negative max connections")
+	}
+	if err := (Options{IdleTimeout: -time.Second}).Validate(); err == nil {
+		t.Error("Validate() should reject a negative idle timeout")
+	}
+}
+
+func TestPublisher_CapsConnections(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	urls := []string{startRelay(t), startRelay(t), startRelay(t), startRelay(t), startRelay(t)}
+	publisher, err := NewPublisher(ctx, Options{MaxConnections: 2})
+	if err != nil {
+		t.Fatalf("NewPublisher() error = %v", err)
+	}
+
+	successes := 0
+	for result := range publisher.PublishMany(ctx, urls, newTestEvent(t)) {
+		if result.Error != nil {
+			t.Errorf("publish to %s failed: %v", result.RelayURL, result.Error)
+			continue
+		}
+		successes++
+		if open := publisher.OpenConnections(); open > 2 {
+			t.Errorf("OpenConnections() = %d, want at most 2", open)
+		}
+	}
+	if successes != len(urls) {
+		t.Errorf("published to %d relays, want %d", successes, len(urls))
+	}
+	if open := publisher.OpenConnections(); open != 2 {
+		t.Errorf("OpenConnections() = %d after publishing, want 2", open)
+	}
+	connected := 0
+	publisher.pool.Relays.Range(func(_ string, relay *nostr.Relay) bool {
+		if relay != nil && relay.IsConnected() {
+			connected++
+		}
+		return true
+	})
+	if connected > 2 {
+		t.Errorf("pool holds %d connected relays, want at most 2", connected)
+	}
+}
+
+func TestPublisher_ReapsIdleConnections(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	url := startRelay(t)
+	publisher, err := NewPublisher(ctx, Options{IdleTimeout: time.Hour})
+	if err != nil {
+		t.Fatalf("NewPublisher() error = %v", err)
+	}
+	if err := publisher.Connect(url); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+
+	publisher.reapIdle(time.Now())
+	if open := publisher.OpenConnections(); open != 1 {
+		t.Fatalf("OpenConnections() = %d, want 1 before the idle timeout", open)
+	}
+
+	publisher.reapIdle(time.Now().Add(2 * time.Hour))
+	if open := publisher.OpenConnections(); open != 0 {
+		t.Errorf("OpenConnections() = %d, want 0 after the idle timeout", open)
+	}
+
+	// A reaped relay is reconnected on the next publish
+	for result := range publisher.PublishMany(ctx, []string{url}, newTestEvent(t)) {
+		if result.Error != nil {
+			t.Errorf("publish after reaping failed: %v", result.Error)
+		}
+	}
+}
+
+func TestPublisher_UnreachableRelayDoesNotCount(t *testing.T) {
+	publisher, err := NewPublisher(context.Background(), Options{MaxConnections: 1})
+	if err != nil {
+		t.Fatalf("NewPublisher() error = %v", err)
+	}
+	if err := publisher.Connect("ws://127.0.0.1:1"); err == nil {
+		t.Fatal("Connect() to an unreachable relay should fail")
+	}
+	if open := publisher.OpenConnections(); open != 0 {
+		t.Errorf("OpenConnections() = %d, want 0 after a failed connect", open)
+	}
+	if err := publisher.Connect(startRelay(t)); err != nil {
+		t.Errorf("Connect() error = %v", err)
+	}
+}
+
+func TestPublisher_FetchManyWithinCap(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stored := newTestEvent(t)
+	var urls []string
+	for i := 0; i < 3; i++ {
+		relay := khatru.NewRelay()
+		relay.QueryEvents = append(relay.QueryEvents, func(ctx context.Context, filter nostr.Filter) (chan *nostr.Event, error) {
+			ch := make(chan *nostr.Event, 1)
+			ch <- &stored
+			close(ch)
+			return ch, nil
+		})
+		server := httptest.NewServer(relay)
+		t.Cleanup(server.Close)
+		urls = append(urls, "ws"+strings.TrimPrefix(server.URL, "http"))
+	}
+
+	publisher, err := NewPublisher(ctx, Options{MaxConnections: 1})
+	if err != nil {
+		t.Fatalf("NewPublisher() error = %v", err)
+	}
+	fetched := 0
+	for relayEvent := range publisher.FetchMany(ctx, urls, nostr.Filter{IDs: []string{stored.ID}}) {
+		if relayEvent.Event.ID != stored.ID {
+			t.Errorf("fetched event %s, want %s", relayEvent.Event.ID, stored.ID)
+		}
+		fetched++
+		if open := publisher.OpenConnections(); open > 1 {
+			t.Errorf("OpenConnections() = %d while fetching, want at most 1", open)
+		}
+	}
+	if fetched != len(urls) {
+		t.Errorf("fetched %d events, want one per relay (%d)", fetched, len(urls))
+	}
+}
+
+// waitSubscribed waits until relay has n subscriptions open.
+func waitSubscribed(t *testing.T, relay *nostr.Relay, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for relay.Subscriptions.Size() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("relay has %d subscriptions, want %d", relay.Subscriptions.Size(), n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCloseRelays(t *testing.T) {
+	ctx := context.Background()
+	url := startRelay(t)
+	pool := nostr.NewSimplePool(ctx)
+	subCtx, cancel := context.WithCancel(ctx)
+	events := pool.SubscribeMany(subCtx, []string{url}, nostr.Filter{Kinds: []int{1}})
+	relay, err := pool.EnsureRelay(url)
+	if err != nil {
+		t.Fatalf("EnsureRelay() error = %v", err)
+	}
+	waitSubscribed(t, relay, 1)
+
+	// A cancelled subscription is closed before the relay is disconnected
+	cancel()
+	closeCtx, closeCancel := context.WithTimeout(ctx, 5*time.Second)
+	defer closeCancel()
+	if err := CloseRelays(closeCtx, pool); err != nil {
+		t.Errorf("CloseRelays() error = %v", err)
+	}
+	if relay.IsConnected() || pool.Relays.Size() != 0 {
+		t.Error("CloseRelays() left the relay connected")
+	}
+	for range events {
+	}
+
+	// A subscription still open is cut off once ctx is done
+	pool = nostr.NewSimplePool(ctx)
+	pool.SubscribeMany(ctx, []string{url}, nostr.Filter{Kinds: []int{1}})
+	if relay, err = pool.EnsureRelay(url); err != nil {
+		t.Fatalf("EnsureRelay() error = %v", err)
+	}
+	waitSubscribed(t, relay, 1)
+	expired, expire := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer expire()
+	if err := CloseRelays(expired, pool); err == nil {
+		t.Error("CloseRelays() with a subscription open should report it")
+	}
+	if relay.IsConnected() {
+		t.Error("CloseRelays() left the relay connected after ctx was done")
+	}
+}
+
+func TestPublisher_Close(t *testing.T) {
+	ctx := context.Background()
+	publisher, err := NewPublisher(ctx, DefaultOptions())
+	if err != nil {
+		t.Fatalf("NewPublisher() error = %v", err)
+	}
+	if err := publisher.Connect(startRelay(t)); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+
+	closeCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := publisher.Close(closeCtx); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+	if open := publisher.OpenConnections(); open != 0 {
+		t.Errorf("OpenConnections() = %d after Close(), want 0", open)
+	}
+	if n := publisher.pool.Relays.Size(); n != 0 {
+		t.Errorf("pool holds %d relays after Close(), want 0", n)
+	}
+}
@@ -0,0 +1,231 @@
+// Package rotatelog implements the size-rotated JSONL files behind file storage (see
+// internal/storage).
+package rotatelog
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+
+	"github.com/girino/nostr-lib/logging"
+)
+
+// Log is an append-only file of JSON lines. When the file would grow beyond maxBytes it is
+// rotated to path.1, path.2, ... keeping the newest keep rotated files.
+type Log struct {
+	path     string
+	maxBytes int64
+	keep     int
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+	sync bool
+}
+
+// Open opens (or creates) the log at path, readable by its owner only. maxBytes 0 disables rotation.
+func Open(path string, maxBytes int64, keep int) (*Log, error) {
+	if path == "" {
+		return nil, fmt.Errorf("log path is required")
+	}
+	if maxBytes < 0 || keep < 0 {
+		return nil, fmt.Errorf("log size and rotation count must not be negative")
+	}
+	l := &Log{path: path, maxBytes: maxBytes, keep: keep}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *Log) open() error {
+	file, err := os.OpenFile(l.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", l.path, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat %s: %w", l.path, err)
+	}
+	l.file, l.size = file, info.Size()
+	return nil
+}
+
+// SetSync makes Append flush every line to disk before returning, so entries survive a
+// power loss at the cost of a disk sync per entry.
+func (l *Log) SetSync(sync bool) {
+	l.mu.Lock()
+	l.sync = sync
+	l.mu.Unlock()
+}
+
+// Append writes v as one JSON line, rotating the log first if it would exceed its size.
+func (l *Log) Append(v any) error {
+	line, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("failed to serialize log entry: %w", err)
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return fmt.Errorf("%s is closed", l.path)
+	}
+	if l.maxBytes > 0 && l.size > 0 && l.size+int64(len(line)) > l.maxBytes {
+		if err := l.rotateLocked(); err != nil {
+			return fmt.Errorf("failed to rotate %s: %w", l.path, err)
+		}
+	}
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", l.path, err)
+	}
+	if l.sync {
+		if err := l.file.Sync(); err != nil {
+			return fmt.Errorf("failed to sync %s: %w", l.path, err)
+		}
+	}
+	return nil
+}
+
+// Rewrite keeps only the lines of the log and its rotated files for which keepLine returns
+// true. Each file is replaced atomically; rotated files left empty are removed.
+func (l *Log) Rewrite(keepLine func(line []byte) bool) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return fmt.Errorf("%s is closed", l.path)
+	}
+	for n := l.keep; n >= 1; n-- {
+		if err := rewriteFile(rotated(l.path, n), keepLine, true); err != nil {
+			return err
+		}
+	}
+	if err := l.file.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %w", l.path, err)
+	}
+	l.file = nil
+	if err := rewriteFile(l.path, keepLine, false); err != nil {
+		return err
+	}
+	return l.open()
+}
+
+// rewriteFile replaces path with the lines keepLine accepts, removing it if none are left and
+// removeEmpty is set. Missing files are skipped.
+func rewriteFile(path string, keepLine func(line []byte) bool, removeEmpty bool) error {
+	file, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer file.Close()
+
+	var kept []byte
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if json.Valid(scanner.Bytes()) && keepLine(scanner.Bytes()) {
+			kept = append(kept, scanner.Bytes()...)
+			kept = append(kept, '\n')
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if len(kept) == 0 && removeEmpty {
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", path, err)
+		}
+		return nil
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, kept, 0o600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+	return nil
+}
+
+// rotateLocked shifts path.N to path.N+1, drops the files beyond keep and starts a new log.
+func (l *Log) rotateLocked() error {
+	if err := l.file.Close(); err != nil {
+		return fmt.Errorf("failed to close log: %w", err)
+	}
+	l.file = nil
+	if l.keep == 0 {
+		if err := os.Remove(l.path); err != nil {
+			return fmt.Errorf("failed to remove log: %w", err)
+		}
+		return l.open()
+	}
+	if err := os.Remove(rotated(l.path, l.keep)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("failed to remove old log: %w", err)
+	}
+	for i := l.keep - 1; i >= 0; i-- {
+		if err := os.Rename(rotated(l.path, i), rotated(l.path, i+1)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("failed to rotate log: %w", err)
+		}
+	}
+	logging.DebugMethod("rotatelog", "rotate", "Rotated %s", l.path)
+	return l.open()
+}
+
+func rotated(path string, n int) string {
+	if n == 0 {
+		return path
+	}
+	return fmt.Sprintf("%s.%d", path, n)
+}
+
+// Close closes the log file.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return nil
+	}
+	err := l.file.Close()
+	l.file = nil
+	return err
+}
+
+// Scan calls fn with every line of the log at path and its keep rotated files, oldest first.
+// Lines that are not valid JSON, such as a partial last line left by a crash, are skipped.
+func Scan(path string, keep int, fn func(line []byte)) error {
+	for n := keep; n >= 0; n-- {
+		file, err := os.Open(rotated(path, n))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", rotated(path, n), err)
+		}
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			if !json.Valid(scanner.Bytes()) {
+				logging.Warn("rotatelog.Scan: skipping malformed line in %s", file.Name())
+				continue
+			}
+			fn(scanner.Bytes())
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", file.Name(), err)
+		}
+	}
+	return nil
+}
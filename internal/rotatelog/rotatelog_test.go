@@ -0,0 +1,62 @@
+package rotatelog
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLog_AppendRotateScan(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log.jsonl")
+	l, err := Open(path, 20, 1)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := l.Append(map[string]int{"n": i}); err != nil {
+			t.Fatalf("Append() error = %v", err)
+		}
+	}
+	l.Close()
+	if err := l.Append(1); err == nil {
+		t.Error("Append() after Close() should fail")
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0o600 {
+		t.Errorf("log mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	// A partial line left by a crash is skipped
+	file, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	file.WriteString(`{"n":`)
+	file.Close()
+
+	var lines []string
+	if err := Scan(path, 1, func(line []byte) { lines = append(lines, string(line)) }); err != nil {
+		t.Fatalf("Scan() error = %v", err)
+	}
+	want := []string{`{"n":2}`, `{"n":3}`, `{"n":4}`}
+	if len(lines) != len(want) {
+		t.Fatalf("Scan() = %v, want %v", lines, want)
+	}
+	for i := range want {
+		if lines[i] != want[i] {
+			t.Errorf("Scan() line %d = %s, want %s", i, lines[i], want[i])
+		}
+	}
+}
+
+func TestLog_KeepNothing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log.jsonl")
+	l, err := Open(path, 10, 0)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer l.Close()
+	for i := 0; i < 3; i++ {
+		l.Append("0123456")
+	}
+	files, _ := filepath.Glob(path + "*")
+	if len(files) != 1 {
+		t.Errorf("log files = %v, want only the current log", files)
+	}
+}
@@ -0,0 +1,215 @@
+// Package schema defines the only tags and content 29001 containers and 29000 layers may carry.
+// Every value a wrapper carries is either fixed by the protocol, random or encrypted, so a
+// sender or Renoter cannot add a field a later hop or relay could use to recognize the message.
+package schema
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strconv"
+
+	"github.com/girino/renoter/internal/config"
+	"github.com/girino/renoter/internal/padding"
+	"github.com/girino/renoter/internal/stamp"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Bounds of a NIP-44 version 2 payload in base64, as go-nostr's nip44 package enforces them.
+const (
+	minPayloadSize = 132
+	maxPayloadSize = 87472
+)
+
+// sealedTags are the 29000 tags whose single value is sealed to the Renoter the layer is
+// addressed to (see internal/sealtag): payment proofs and Cashu tokens (server.PaymentTag and
+// server.CashuTag), the idempotency key, the mixing delay, the report key, hop acknowledgement
+// requests, the reply key of error notices and capability probes.
+var sealedTags = map[string]bool{
+	"payment":             true,
+	"cashu":               true,
+	config.IdempotencyTag: true,
+	config.DelayTag:       true,
+	config.ReportTag:      true,
+	config.AckTag:         true,
+	config.ReplyTag:       true,
+	config.HandshakeTag:   true,
+}
+
+// CheckContainer checks that a 29001 container carries nothing but exactly one routing tag, an
+// optional PoW nonce, an optional expiration and up to config.TrailerSlots timing trailers, and
+// that its content is a NIP-44 payload.
+func CheckContainer(container *nostr.Event) error {
+	if container.Kind != config.StandardizedWrapperKind {
+		return fmt.Errorf("kind %d, want %d", container.Kind, config.StandardizedWrapperKind)
+	}
+	if _, err := config.RoutingPubkey(container); err != nil {
+		return err
+	}
+	trailers := 0
+	seen := map[string]bool{}
+	for _, tag := range container.Tags {
+		if len(tag) == 0 {
+			return fmt.Errorf("empty tag")
+		}
+		name := tag[0]
+		var err error
+		switch name {
+		case config.RoutingTag:
+			err = checkRouting(tag)
+		case "nonce":
+			err = checkNonce(tag, false)
+		case "expiration":
+			err = checkExpiration(tag)
+		case config.TrailerTag:
+			trailers++
+			if trailers > config.TrailerSlots {
+				return fmt.Errorf("more than %d %q tags", config.TrailerSlots, name)
+			}
+			err = checkTrailer(tag)
+		default:
+			return fmt.Errorf("unexpected %q tag", name)
+		}
+		if err != nil {
+			return fmt.Errorf("%q tag: %w", name, err)
+		}
+		if name != config.TrailerTag && seen[name] {
+			return fmt.Errorf("repeated %q tag", name)
+		}
+		seen[name] = true
+	}
+	if err := CheckPayload(container.Content); err != nil {
+		return fmt.Errorf("content: %w", err)
+	}
+	return nil
+}
+
+// CheckLayer checks that a 29000 layer carries nothing but exactly one routing tag, an optional
+// PoW nonce (stamped or not), an optional expiration, padding and sealed tags, each at most once,
+// and that its content is a NIP-44 payload. Capability probes, which carry no event, have no
+// content.
+func CheckLayer(layer *nostr.Event) error {
+	if layer.Kind != config.WrapperEventKind {
+		return fmt.Errorf("kind %d, want %d", layer.Kind, config.WrapperEventKind)
+	}
+	if _, err := config.RoutingPubkey(layer); err != nil {
+		return err
+	}
+	seen := map[string]bool{}
+	for _, tag := range layer.Tags {
+		if len(tag) == 0 {
+			return fmt.Errorf("empty tag")
+		}
+		name := tag[0]
+		var err error
+		switch {
+		case name == config.RoutingTag:
+			err = checkRouting(tag)
+		case name == "nonce":
+			err = checkNonce(tag, true)
+		case name == "expiration":
+			err = checkExpiration(tag)
+		case name == padding.TagName:
+			err = checkPadding(tag)
+		case sealedTags[name]:
+			err = checkSealed(tag)
+		default:
+			return fmt.Errorf("unexpected %q tag", name)
+		}
+		if err != nil {
+			return fmt.Errorf("%q tag: %w", name, err)
+		}
+		if seen[name] {
+			return fmt.Errorf("repeated %q tag", name)
+		}
+		seen[name] = true
+	}
+	if layer.Content == "" && seen[config.HandshakeTag] {
+		return nil
+	}
+	if err := CheckPayload(layer.Content); err != nil {
+		return fmt.Errorf("content: %w", err)
+	}
+	return nil
+}
+
+// CheckPayload checks that s has the format of a NIP-44 version 2 payload: base64 of the right
+// length starting with the version byte. Only decryption tells whether it is authentic.
+func CheckPayload(s string) error {
+	if len(s) < minPayloadSize || len(s) > maxPayloadSize {
+		return fmt.Errorf("%d bytes is not the length of a NIP-44 payload", len(s))
+	}
+	decoded, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return fmt.Errorf("not base64: %w", err)
+	}
+	if decoded[0] != 2 {
+		return fmt.Errorf("unknown NIP-44 version %d", decoded[0])
+	}
+	return nil
+}
+
+// checkRouting checks that a routing tag carries nothing after its pubkey, which
+// config.RoutingPubkey already checked.
+func checkRouting(tag nostr.Tag) error {
+	if len(tag) != 2 {
+		return fmt.Errorf("want a single pubkey")
+	}
+	return nil
+}
+
+// checkNonce checks a NIP-13 nonce tag: a decimal nonce and target difficulty, followed by the
+// stamp marker on stamped layers.
+func checkNonce(tag nostr.Tag, stamped bool) error {
+	switch {
+	case len(tag) == 4 && stamped && tag[3] == stamp.Marker:
+	case len(tag) != 3:
+		return fmt.Errorf("want a nonce and a difficulty")
+	}
+	if !decimal(tag[1]) || !decimal(tag[2]) {
+		return fmt.Errorf("nonce and difficulty must be decimal numbers")
+	}
+	return nil
+}
+
+// checkExpiration checks a NIP-40 expiration tag.
+func checkExpiration(tag nostr.Tag) error {
+	if len(tag) != 2 || !decimal(tag[1]) {
+		return fmt.Errorf("want a single timestamp")
+	}
+	return nil
+}
+
+// checkTrailer checks a timing trailer: a throwaway pubkey and a NIP-44 payload.
+func checkTrailer(tag nostr.Tag) error {
+	if len(tag) != 3 || !nostr.IsValidPublicKey(tag[1]) {
+		return fmt.Errorf("want a pubkey and a payload")
+	}
+	return CheckPayload(tag[2])
+}
+
+// checkPadding checks a padding tag, whose value is random lowercase hex of any length.
+func checkPadding(tag nostr.Tag) error {
+	if len(tag) != 2 {
+		return fmt.Errorf("want a single value")
+	}
+	for _, c := range tag[1] {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return fmt.Errorf("value is not lowercase hex")
+		}
+	}
+	return nil
+}
+
+// checkSealed checks a sealed tag, whose single value is a NIP-44 payload.
+func checkSealed(tag nostr.Tag) error {
+	if len(tag) != 2 {
+		return fmt.Errorf("want a single sealed value")
+	}
+	return CheckPayload(tag[1])
+}
+
+// decimal reports whether s is a non-negative integer that fits in 64 bits.
+func decimal(s string) bool {
+	_, err := strconv.ParseUint(s, 10, 64)
+	return err == nil
+}
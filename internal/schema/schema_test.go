@@ -0,0 +1,108 @@
+package schema
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/girino/renoter/internal/config"
+	"github.com/girino/renoter/internal/stamp"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip44"
+)
+
+// payload returns a NIP-44 payload of plaintext for a throwaway key.
+func payload(t *testing.T, plaintext string) string {
+	t.Helper()
+	pk, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	key, err := nip44.GenerateConversationKey(pk, nostr.GeneratePrivateKey())
+	if err != nil {
+		t.Fatalf("GenerateConversationKey() error = %v", err)
+	}
+	ciphertext, err := nip44.Encrypt(plaintext, key)
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	return ciphertext
+}
+
+func TestCheckContainer(t *testing.T) {
+	recipient, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	trailerKey, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	content := payload(t, "layer")
+	trailer := nostr.Tag{config.TrailerTag, trailerKey, payload(t, "trailer")}
+	valid := nostr.Tags{{"p", recipient}, trailer, trailer, {"nonce", "12345", "8"}, {"expiration", "1700000000"}}
+
+	if err := CheckContainer(&nostr.Event{Kind: config.StandardizedWrapperKind, Tags: valid, Content: content}); err != nil {
+		t.Errorf("CheckContainer() error = %v for a valid container", err)
+	}
+	tooManyTrailers := nostr.Tags{{"p", recipient}}
+	for range config.TrailerSlots + 1 {
+		tooManyTrailers = append(tooManyTrailers, trailer)
+	}
+	tests := []struct {
+		name    string
+		tags    nostr.Tags
+		content string
+	}{
+		{"no routing tag", nostr.Tags{}, content},
+		{"extra tag", nostr.Tags{{"p", recipient}, {"t", "watermark"}}, content},
+		{"extra routing value", nostr.Tags{{"p", recipient, "wss://relay.example.com"}}, content},
+		{"padding on the container", nostr.Tags{{"p", recipient}, {"padding", "00"}}, content},
+		{"stamped nonce", nostr.Tags{{"p", recipient}, {"nonce", "1", "8", stamp.Marker}}, content},
+		{"non-numeric nonce", nostr.Tags{{"p", recipient}, {"nonce", "watermark", "8"}}, content},
+		{"repeated nonce", nostr.Tags{{"p", recipient}, {"nonce", "1", "8"}, {"nonce", "2", "8"}}, content},
+		{"too many trailers", tooManyTrailers, content},
+		{"plaintext trailer", nostr.Tags{{"p", recipient}, {config.TrailerTag, trailerKey, "watermark"}}, content},
+		{"plaintext content", nostr.Tags{{"p", recipient}}, strings.Repeat("a", 200)},
+		{"unknown NIP-44 version", nostr.Tags{{"p", recipient}}, "Aw" + content[2:]},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := CheckContainer(&nostr.Event{Kind: config.StandardizedWrapperKind, Tags: tt.tags, Content: tt.content}); err == nil {
+				t.Error("CheckContainer() accepted the container")
+			}
+		})
+	}
+}
+
+func TestCheckLayer(t *testing.T) {
+	recipient, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	content := payload(t, "event")
+	sealed := payload(t, `["30"]`)
+	valid := nostr.Tags{{"p", recipient}, {config.DelayTag, sealed}, {config.ReportTag, sealed}, {"nonce", "1", "16", stamp.Marker}, {"padding", "0123abcdef"}}
+
+	if err := CheckLayer(&nostr.Event{Kind: config.WrapperEventKind, Tags: valid, Content: content}); err != nil {
+		t.Errorf("CheckLayer() error = %v for a valid layer", err)
+	}
+	probe := nostr.Tags{{"p", recipient}, {config.HandshakeTag, sealed}}
+	if err := CheckLayer(&nostr.Event{Kind: config.WrapperEventKind, Tags: probe}); err != nil {
+		t.Errorf("CheckLayer() error = %v for a capability probe", err)
+	}
+	tests := []struct {
+		name    string
+		tags    nostr.Tags
+		content string
+	}{
+		{"wrong kind", nil, content},
+		{"two routing tags", nostr.Tags{{"p", recipient}, {"p", recipient}}, content},
+		{"extra tag", nostr.Tags{{"p", recipient}, {"client", "watermark"}}, content},
+		{"plaintext sealed tag", nostr.Tags{{"p", recipient}, {config.DelayTag, "30"}}, content},
+		{"sealed tag with extra value", nostr.Tags{{"p", recipient}, {config.DelayTag, sealed, "x"}}, content},
+		{"repeated sealed tag", nostr.Tags{{"p", recipient}, {config.ReportTag, sealed}, {config.ReportTag, sealed}}, content},
+		{"non-hex padding", nostr.Tags{{"p", recipient}, {"padding", "WATERMARK"}}, content},
+		{"empty content", nostr.Tags{{"p", recipient}}, ""},
+		{"plaintext content", nostr.Tags{{"p", recipient}}, "hello"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kind := config.WrapperEventKind
+			if tt.tags == nil {
+				kind = config.StandardizedWrapperKind
+				tt.tags = nostr.Tags{{"p", recipient}}
+			}
+			if err := CheckLayer(&nostr.Event{Kind: kind, Tags: tt.tags, Content: tt.content}); err == nil {
+				t.Error("CheckLayer() accepted the layer")
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package sealtag
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/nbd-wtf/go-nostr/nip44"
+)
+
+// Seal encrypts tag values with a layer's NIP-44 conversation key, so that a proof carried in a
+// 29000 tag is readable only by the Renoter the layer is addressed to and not by the previous hop,
+// which sees the layer's tags in plaintext.
+func Seal(values []string, conversationKey [32]byte) (string, error) {
+	plaintext, err := Plaintext(values)
+	if err != nil {
+		return "", err
+	}
+	return nip44.Encrypt(string(plaintext), conversationKey)
+}
+
+// Plaintext returns what Seal encrypts for values, so sealed tags can be measured exactly.
+func Plaintext(values []string) ([]byte, error) {
+	plaintext, err := json.Marshal(values)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize sealed tag: %w", err)
+	}
+	return plaintext, nil
+}
+
+// Open decrypts values sealed with Seal.
+func Open(sealed string, conversationKey [32]byte) ([]string, error) {
+	plaintext, err := nip44.Decrypt(sealed, conversationKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt sealed tag: %w", err)
+	}
+	var values []string
+	if err := json.Unmarshal([]byte(plaintext), &values); err != nil {
+		return nil, fmt.Errorf("invalid sealed tag: %w", err)
+	}
+	return values, nil
+}
@@ -0,0 +1,35 @@
+package sealtag
+
+import (
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip44"
+)
+
+func TestSealOpen(t *testing.T) {
+	senderSk := nostr.GeneratePrivateKey()
+	senderPk, _ := nostr.GetPublicKey(senderSk)
+	recipientSk := nostr.GeneratePrivateKey()
+	recipientPk, _ := nostr.GetPublicKey(recipientSk)
+
+	senderKey, _ := nip44.GenerateConversationKey(recipientPk, senderSk)
+	recipientKey, _ := nip44.GenerateConversationKey(senderPk, recipientSk)
+
+	sealed, err := Seal([]string{"https://example.com/verify", "00ff"}, senderKey)
+	if err != nil {
+		t.Fatalf("Seal() error = %v", err)
+	}
+	values, err := Open(sealed, recipientKey)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if len(values) != 2 || values[0] != "https://example.com/verify" || values[1] != "00ff" {
+		t.Errorf("Open() = %v", values)
+	}
+
+	otherKey, _ := nip44.GenerateConversationKey(recipientPk, nostr.GeneratePrivateKey())
+	if _, err := Open(sealed, otherKey); err == nil {
+		t.Error("Open() with another key should fail")
+	}
+}
@@ -0,0 +1,35 @@
+// Package secret reads the tokens, passphrases and keys the commands take. They are never flag
+// defaults, which -h prints, and are best kept out of the command line, which any local user can
+// read: a flag names a file holding the secret, or an environment variable holds it.
+package secret
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Lookup returns the secret given as value on the command line, read from file, or held by the
+// environment variable env, in that order of precedence; empty if none is set. Setting both value
+// and file is refused, as one of them would be silently ignored. Trailing newlines of the file
+// are dropped.
+func Lookup(value, file, env string) (string, error) {
+	if value != "" && file != "" {
+		return "", fmt.Errorf("set the secret or the file holding it, not both")
+	}
+	if value != "" {
+		return value, nil
+	}
+	if file != "" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("failed to read secret file: %w", err)
+		}
+		secret := strings.TrimRight(string(data), "\r\n")
+		if secret == "" {
+			return "", fmt.Errorf("secret file %s is empty", file)
+		}
+		return secret, nil
+	}
+	return os.Getenv(env), nil
+}
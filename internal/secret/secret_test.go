@@ -0,0 +1,44 @@
+package secret
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLookup(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "token")
+	os.WriteFile(file, []byte("from-file\n"), 0o600)
+	empty := filepath.Join(dir, "empty")
+	os.WriteFile(empty, []byte("\n"), 0o600)
+	t.Setenv("RENOTER_TEST_SECRET", "from-env")
+
+	tests := []struct {
+		name    string
+		value   string
+		file    string
+		env     string
+		want    string
+		wantErr bool
+	}{
+		{"flag", "from-flag", "", "RENOTER_TEST_SECRET", "from-flag", false},
+		{"file", "", file, "RENOTER_TEST_SECRET", "from-file", false},
+		{"environment", "", "", "RENOTER_TEST_SECRET", "from-env", false},
+		{"none", "", "", "RENOTER_TEST_UNSET", "", false},
+		{"flag and file", "from-flag", file, "RENOTER_TEST_SECRET", "", true},
+		{"missing file", "", filepath.Join(dir, "missing"), "RENOTER_TEST_SECRET", "", true},
+		{"empty file", "", empty, "RENOTER_TEST_SECRET", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Lookup(tt.value, tt.file, tt.env)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Lookup() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Lookup() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package stamp
+
+import (
+	"time"
+
+	"github.com/girino/renoter/internal/config"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip13"
+)
+
+// Marker is the fourth value of the "nonce" tag of a stamped 29000 layer, whose PoW is mined on
+// its skeleton instead of its ID.
+const Marker = "stamp"
+
+// MaxAge is how old a stamped layer may be. Stamps are mined ahead of time but each is spent
+// once, so Renoters only need to remember the ones spent within MaxAge.
+const MaxAge = time.Hour
+
+// Skeleton returns the skeleton of a 29000 layer from pubkey to recipient created at createdAt:
+// the layer without content or tags other than "p". A stamp is a NIP-13 nonce mined on it, so it
+// can be mined before the content of the layer is known.
+func Skeleton(pubkey string, createdAt nostr.Timestamp, recipient string) nostr.Event {
+	return nostr.Event{
+		Kind:      config.WrapperEventKind,
+		PubKey:    pubkey,
+		CreatedAt: createdAt,
+		Tags:      nostr.Tags{{"p", recipient}},
+		Content:   "",
+	}
+}
+
+// Tag returns the "nonce" tag of a layer carrying the nonce mined on its skeleton.
+func Tag(nonce nostr.Tag) nostr.Tag {
+	return append(nonce[:len(nonce):len(nonce)], Marker)
+}
+
+// Stamped reports whether the PoW of a layer is a stamp.
+func Stamped(layer *nostr.Event) bool {
+	nonce := layer.Tags.Find("nonce")
+	return len(nonce) >= 4 && nonce[3] == Marker
+}
+
+// ID returns the ID of the skeleton of a stamped layer, which its PoW is on.
+func ID(layer *nostr.Event) string {
+	nonce := layer.Tags.Find("nonce")
+	recipient := ""
+	if p := layer.Tags.Find("p"); p != nil {
+		recipient = p[1]
+	}
+	skeleton := Skeleton(layer.PubKey, layer.CreatedAt, recipient)
+	skeleton.Tags = append(skeleton.Tags, nostr.Tag(nonce[:3]))
+	return skeleton.GetID()
+}
+
+// Work returns the ID the PoW of a layer is on: that of its skeleton if it is stamped, its own
+// otherwise.
+func Work(layer *nostr.Event) string {
+	if Stamped(layer) {
+		return ID(layer)
+	}
+	return layer.ID
+}
+
+// CommittedDifficulty is nip13.CommittedDifficulty for stamped layers too.
+func CommittedDifficulty(layer *nostr.Event) int {
+	if !Stamped(layer) {
+		return nip13.CommittedDifficulty(layer)
+	}
+	return nip13.CommittedDifficulty(&nostr.Event{ID: ID(layer), Tags: nostr.Tags{layer.Tags.Find("nonce")}})
+}
@@ -0,0 +1,53 @@
+package stamp
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip13"
+)
+
+func TestCommittedDifficulty(t *testing.T) {
+	pubkey, recipient := strings.Repeat("b", 64), strings.Repeat("a", 64)
+	createdAt := nostr.Now()
+	nonce, err := nip13.DoWork(context.Background(), Skeleton(pubkey, createdAt, recipient), 8)
+	if err != nil {
+		t.Fatalf("DoWork() error = %v", err)
+	}
+
+	// The content and the other tags are only known later, and do not affect the stamp
+	layer := &nostr.Event{
+		Kind:      29000,
+		PubKey:    pubkey,
+		CreatedAt: createdAt,
+		Tags:      nostr.Tags{{"p", recipient}, {"delay", "sealed"}, Tag(nonce)},
+		Content:   "ciphertext",
+	}
+	layer.ID = layer.GetID()
+	if !Stamped(layer) {
+		t.Fatal("Stamped() = false")
+	}
+	if got := CommittedDifficulty(layer); got != 8 {
+		t.Errorf("CommittedDifficulty() = %d, want 8", got)
+	}
+	if Work(layer) != ID(layer) || nip13.Difficulty(Work(layer)) < 8 {
+		t.Errorf("Work() = %s, want the mined skeleton ID", Work(layer))
+	}
+
+	// The stamp is bound to the key, date and recipient of the layer
+	moved := *layer
+	moved.CreatedAt++
+	if got := CommittedDifficulty(&moved); got != 0 {
+		t.Errorf("CommittedDifficulty() of a redated layer = %d, want 0", got)
+	}
+
+	// Without the marker the nonce counts on the layer ID, as in NIP-13
+	unmarked := *layer
+	unmarked.Tags = nostr.Tags{{"p", recipient}, nonce}
+	unmarked.ID = unmarked.GetID()
+	if Stamped(&unmarked) || CommittedDifficulty(&unmarked) != nip13.CommittedDifficulty(&unmarked) {
+		t.Error("an unmarked nonce was taken for a stamp")
+	}
+}
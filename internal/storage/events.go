@@ -0,0 +1,194 @@
+package storage
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/fiatjaf/eventstore"
+	"github.com/girino/nostr-lib/logging"
+	"github.com/girino/renoter/internal/rotatelog"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+var _ eventstore.Store = (*EventFile)(nil)
+
+// EventFile is an eventstore.Store keeping whole nostr events as JSON lines in a file, readable
+// by its owner only, and in memory for queries. Deleted events stay in the file until deletions
+// outnumber the events kept, or the store is closed, when the file is rewritten without them.
+// Set Path, then call Init.
+type EventFile struct {
+	Path string
+
+	mu     sync.Mutex
+	log    *rotatelog.Log
+	events map[string]*nostr.Event
+	// Events deleted since the file was last rewritten
+	deleted int
+}
+
+// Init loads the events already in the file and opens it for appending.
+func (f *EventFile) Init() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.events = make(map[string]*nostr.Event)
+	lines := 0
+	err := rotatelog.Scan(f.Path, 0, func(line []byte) {
+		lines++
+		var event nostr.Event
+		if err := json.Unmarshal(line, &event); err != nil || event.ID == "" {
+			logging.Warn("storage.events.Init: skipping malformed event in %s", f.Path)
+			return
+		}
+		f.events[event.ID] = &event
+	})
+	if err != nil {
+		return err
+	}
+	f.deleted = lines - len(f.events)
+	if f.log, err = rotatelog.Open(f.Path, 0, 0); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Close rewrites the file without the deleted events, if any, and closes it.
+func (f *EventFile) Close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.log == nil {
+		return
+	}
+	if f.deleted > 0 {
+		if err := f.compactLocked(); err != nil {
+			logging.Error("storage.events.Close: %v", err)
+		}
+	}
+	if err := f.log.Close(); err != nil {
+		logging.Error("storage.events.Close: %v", err)
+	}
+	f.log = nil
+}
+
+// QueryEvents returns the events matching filter, newest first, up to its limit if it has one.
+func (f *EventFile) QueryEvents(ctx context.Context, filter nostr.Filter) (chan *nostr.Event, error) {
+	f.mu.Lock()
+	var matches []*nostr.Event
+	for _, event := range f.events {
+		if filter.Matches(event) {
+			matches = append(matches, event)
+		}
+	}
+	f.mu.Unlock()
+
+	slices.SortFunc(matches, func(a, b *nostr.Event) int {
+		return cmp.Or(cmp.Compare(b.CreatedAt, a.CreatedAt), cmp.Compare(a.ID, b.ID))
+	})
+	if filter.LimitZero {
+		matches = nil
+	} else if filter.Limit > 0 && len(matches) > filter.Limit {
+		matches = matches[:filter.Limit]
+	}
+	ch := make(chan *nostr.Event, len(matches))
+	for _, event := range matches {
+		ch <- event
+	}
+	close(ch)
+	return ch, nil
+}
+
+// DeleteEvent deletes the event with the ID of event, if stored.
+func (f *EventFile) DeleteEvent(ctx context.Context, event *nostr.Event) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.deleteLocked(event.ID)
+}
+
+// SaveEvent stores event, or returns eventstore.ErrDupEvent if it is stored already.
+func (f *EventFile) SaveEvent(ctx context.Context, event *nostr.Event) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.events[event.ID]; ok {
+		return eventstore.ErrDupEvent
+	}
+	return f.saveLocked(event)
+}
+
+// ReplaceEvent stores a replaceable or addressable event in place of its older versions, unless
+// a newer version is stored already.
+func (f *EventFile) ReplaceEvent(ctx context.Context, event *nostr.Event) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var older []string
+	for id, stored := range f.events {
+		if stored.Kind != event.Kind || stored.PubKey != event.PubKey {
+			continue
+		}
+		if nostr.IsAddressableKind(event.Kind) && stored.Tags.GetD() != event.Tags.GetD() {
+			continue
+		}
+		if stored.CreatedAt > event.CreatedAt || id == event.ID {
+			return nil
+		}
+		older = append(older, id)
+	}
+	for _, id := range older {
+		if err := f.deleteLocked(id); err != nil {
+			return err
+		}
+	}
+	return f.saveLocked(event)
+}
+
+func (f *EventFile) saveLocked(event *nostr.Event) error {
+	if f.log == nil {
+		return fmt.Errorf("%s is not open", f.Path)
+	}
+	if err := f.log.Append(event); err != nil {
+		return err
+	}
+	stored := *event
+	f.events[event.ID] = &stored
+	return nil
+}
+
+func (f *EventFile) deleteLocked(id string) error {
+	if _, ok := f.events[id]; !ok {
+		return nil
+	}
+	delete(f.events, id)
+	f.deleted++
+	if f.deleted > len(f.events) {
+		return f.compactLocked()
+	}
+	return nil
+}
+
+// compactLocked rewrites the file with one line per stored event.
+func (f *EventFile) compactLocked() error {
+	if f.log == nil {
+		return fmt.Errorf("%s is not open", f.Path)
+	}
+	written := make(map[string]bool, len(f.events))
+	err := f.log.Rewrite(func(line []byte) bool {
+		var event struct {
+			ID string `json:"id"`
+		}
+		if json.Unmarshal(line, &event) != nil {
+			return false
+		}
+		if _, ok := f.events[event.ID]; !ok || written[event.ID] {
+			return false
+		}
+		written[event.ID] = true
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("failed to compact %s: %w", f.Path, err)
+	}
+	f.deleted = 0
+	return nil
+}
@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fiatjaf/eventstore"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func queryContents(t *testing.T, store eventstore.Store, filter nostr.Filter) []string {
+	t.Helper()
+	ch, err := store.QueryEvents(context.Background(), filter)
+	if err != nil {
+		t.Fatalf("QueryEvents() error = %v", err)
+	}
+	var ids []string
+	for event := range ch {
+		ids = append(ids, event.Content)
+	}
+	return ids
+}
+
+func TestEventFile(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "mirror.jsonl")
+	store := &EventFile{Path: path}
+	if err := store.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+
+	sk := nostr.GeneratePrivateKey()
+	var events []*nostr.Event
+	for i, content := range []string{"a", "b", "c", "d"} {
+		event := &nostr.Event{Kind: 1, Content: content, CreatedAt: nostr.Timestamp(1000 + i), Tags: nostr.Tags{}}
+		event.Sign(sk)
+		if err := store.SaveEvent(ctx, event); err != nil {
+			t.Fatalf("SaveEvent() error = %v", err)
+		}
+		events = append(events, event)
+	}
+	if err := store.SaveEvent(ctx, events[0]); !errors.Is(err, eventstore.ErrDupEvent) {
+		t.Errorf("SaveEvent() of a stored event error = %v, want ErrDupEvent", err)
+	}
+
+	if got := strings.Join(queryContents(t, store, nostr.Filter{}), ""); got != "dcba" {
+		t.Errorf("QueryEvents(all) = %q, want newest first", got)
+	}
+	until := nostr.Timestamp(1002)
+	if got := strings.Join(queryContents(t, store, nostr.Filter{Until: &until, Limit: 2}), ""); got != "cb" {
+		t.Errorf("QueryEvents(until, limit 2) = %q", got)
+	}
+	if got := queryContents(t, store, nostr.Filter{IDs: []string{events[1].ID}}); len(got) != 1 || got[0] != "b" {
+		t.Errorf("QueryEvents(id) = %v", got)
+	}
+
+	// Deleting more than half the events rewrites the file without them
+	for _, event := range events[:3] {
+		if err := store.DeleteEvent(ctx, event); err != nil {
+			t.Fatalf("DeleteEvent() error = %v", err)
+		}
+	}
+	data, _ := os.ReadFile(path)
+	if lines := strings.Count(string(data), "\n"); lines != 1 {
+		t.Errorf("file has %d lines after deletions, want 1:\n%s", lines, data)
+	}
+
+	// Replacing keeps only the newest version
+	meta := &nostr.Event{Kind: 0, Content: "old", CreatedAt: 2000, Tags: nostr.Tags{}}
+	meta.Sign(sk)
+	newer := &nostr.Event{Kind: 0, Content: "new", CreatedAt: 2001, Tags: nostr.Tags{}}
+	newer.Sign(sk)
+	for _, event := range []*nostr.Event{meta, newer, meta} {
+		if err := store.ReplaceEvent(ctx, event); err != nil {
+			t.Fatalf("ReplaceEvent() error = %v", err)
+		}
+	}
+	if got := queryContents(t, store, nostr.Filter{Kinds: []int{0}}); len(got) != 1 || got[0] != "new" {
+		t.Errorf("QueryEvents(kind 0) = %v, want only the newest version", got)
+	}
+	store.Close()
+
+	// The events survive reopening, and deleted ones stay deleted
+	reopened := &EventFile{Path: path}
+	if err := reopened.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	defer reopened.Close()
+	if got := strings.Join(queryContents(t, reopened, nostr.Filter{}), ","); got != "new,d" {
+		t.Errorf("QueryEvents() after reopening = %q", got)
+	}
+}
@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/girino/renoter/internal/rotatelog"
+)
+
+// File keeps records as JSON lines in a size-rotated file. Lines hold the records unchanged,
+// so their time is read back from Options.TimeField.
+type File struct {
+	log  *rotatelog.Log
+	path string
+	opts Options
+}
+
+// OpenFile opens (or creates) the record file at path, readable by its owner only.
+func OpenFile(path string, opts Options) (*File, error) {
+	log, err := rotatelog.Open(path, opts.MaxBytes, opts.Keep)
+	if err != nil {
+		return nil, err
+	}
+	log.SetSync(opts.Sync)
+	return &File{log: log, path: path, opts: opts}, nil
+}
+
+// Append writes the record as one line; its time is the record's own TimeField.
+func (f *File) Append(at time.Time, record any) error {
+	return f.log.Append(record)
+}
+
+// Query scans the file and its rotated files.
+func (f *File) Query(from, to time.Time, fn func(record []byte)) error {
+	return scanFile(f.path, f.opts, from, to, fn)
+}
+
+// Prune rewrites the file and its rotated files without the records taken before the given time.
+func (f *File) Prune(before time.Time) error {
+	if f.opts.TimeField == "" {
+		return fmt.Errorf("cannot prune %s: records have no time field", f.path)
+	}
+	return f.log.Rewrite(func(line []byte) bool {
+		at, ok := recordTime(line, f.opts.TimeField)
+		return !ok || !at.Before(before)
+	})
+}
+
+// Close closes the file.
+func (f *File) Close() error {
+	return f.log.Close()
+}
+
+func scanFile(path string, opts Options, from, to time.Time, fn func(record []byte)) error {
+	return rotatelog.Scan(path, opts.Keep, func(line []byte) {
+		if !from.IsZero() || !to.IsZero() {
+			at, ok := recordTime(line, opts.TimeField)
+			if !ok || (!from.IsZero() && at.Before(from)) || (!to.IsZero() && at.After(to)) {
+				return
+			}
+		}
+		fn(line)
+	})
+}
+
+// recordTime reads the time in field of a JSON record.
+func recordTime(line []byte, field string) (time.Time, bool) {
+	var fields map[string]json.RawMessage
+	if field == "" || json.Unmarshal(line, &fields) != nil {
+		return time.Time{}, false
+	}
+	var at time.Time
+	if raw, ok := fields[field]; !ok || json.Unmarshal(raw, &at) != nil {
+		return time.Time{}, false
+	}
+	return at, true
+}
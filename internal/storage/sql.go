@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"time"
+)
+
+// DefaultTable is the table records are kept in when Options.Table is empty.
+const DefaultTable = "records"
+
+var tableName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// SQL keeps records in a table of an SQL database, one row per record with its time in Unix
+// nanoseconds. The statements use ? placeholders, as SQLite and MySQL do.
+type SQL struct {
+	db    *sql.DB
+	table string
+	// Close the database with the storage (opened by Open rather than passed in)
+	owned bool
+}
+
+// OpenSQL creates the table (and its time index) if it does not exist yet. The caller keeps
+// ownership of db.
+func OpenSQL(db *sql.DB, table string) (*SQL, error) {
+	if table == "" {
+		table = DefaultTable
+	}
+	if !tableName.MatchString(table) {
+		return nil, fmt.Errorf("invalid table name %q", table)
+	}
+	for _, stmt := range []string{
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (at INTEGER NOT NULL, record TEXT NOT NULL)", table),
+		fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s_at ON %s (at)", table, table),
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			return nil, fmt.Errorf("failed to create table %s: %w", table, err)
+		}
+	}
+	return &SQL{db: db, table: table}, nil
+}
+
+// Append inserts the record.
+func (s *SQL) Append(at time.Time, record any) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to serialize record: %w", err)
+	}
+	if _, err := s.db.Exec(fmt.Sprintf("INSERT INTO %s (at, record) VALUES (?, ?)", s.table), at.UnixNano(), string(data)); err != nil {
+		return fmt.Errorf("failed to insert record: %w", err)
+	}
+	return nil
+}
+
+// Query selects the records in time order.
+func (s *SQL) Query(from, to time.Time, fn func(record []byte)) error {
+	lo, hi := int64(math.MinInt64), int64(math.MaxInt64)
+	if !from.IsZero() {
+		lo = from.UnixNano()
+	}
+	if !to.IsZero() {
+		hi = to.UnixNano()
+	}
+	rows, err := s.db.Query(fmt.Sprintf("SELECT record FROM %s WHERE at >= ? AND at <= ? ORDER BY at", s.table), lo, hi)
+	if err != nil {
+		return fmt.Errorf("failed to query records: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var record string
+		if err := rows.Scan(&record); err != nil {
+			return fmt.Errorf("failed to read record: %w", err)
+		}
+		fn([]byte(record))
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read records: %w", err)
+	}
+	return nil
+}
+
+// Prune deletes the records taken before the given time.
+func (s *SQL) Prune(before time.Time) error {
+	if _, err := s.db.Exec(fmt.Sprintf("DELETE FROM %s WHERE at < ?", s.table), before.UnixNano()); err != nil {
+		return fmt.Errorf("failed to delete records: %w", err)
+	}
+	return nil
+}
+
+// Close closes the database if the storage opened it.
+func (s *SQL) Close() error {
+	if !s.owned {
+		return nil
+	}
+	return s.db.Close()
+}
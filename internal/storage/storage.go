@@ -0,0 +1,147 @@
+// Package storage implements the append-only record stores behind the client's journal and the
+// exit's audit log: size-rotated JSONL files, or a table in an SQL database such as SQLite. It
+// also implements EventFile, the JSONL event store an exit can mirror its final events to.
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/girino/nostr-lib/logging"
+)
+
+// Storage keeps JSON records in the order they were appended.
+type Storage interface {
+	// Append stores one record taken at the given time
+	Append(at time.Time, record any) error
+	// Query calls fn with the JSON of every record taken between from and to (inclusive; a zero
+	// time leaves that end open), oldest first
+	Query(from, to time.Time, fn func(record []byte)) error
+	// Prune drops the records taken before the given time
+	Prune(before time.Time) error
+	// Close releases the underlying file or database
+	Close() error
+}
+
+// Options configures a Storage opened with Open.
+type Options struct {
+	// Rotate files when they would grow beyond this many bytes (0 = never)
+	MaxBytes int64
+	// Rotated files kept
+	Keep int
+	// Flush every record to disk before Append returns (files; databases always commit)
+	Sync bool
+	// JSON field holding each record's time, which files read back for Query and Prune
+	TimeField string
+	// Table the records are kept in (databases)
+	Table string
+	// Drop records older than this, checked on Open and then at most hourly on Append (0 = keep all)
+	Retention time.Duration
+}
+
+// SQLitePrefix marks a storage spec as an SQLite database, e.g. "sqlite:/var/lib/renoter/audit.db".
+// Any other spec is a file path.
+const SQLitePrefix = "sqlite:"
+
+// SQLiteDriver is the database/sql driver SQLite specs are opened with. This module links no
+// driver itself: programs that accept SQLite specs import one that registers this name, such
+// as modernc.org/sqlite, or set it to the name their driver registers.
+var SQLiteDriver = "sqlite"
+
+// Open opens the storage described by spec: an SQLite database if it starts with SQLitePrefix,
+// a rotated JSONL file otherwise.
+func Open(spec string, opts Options) (Storage, error) {
+	var (
+		store Storage
+		err   error
+	)
+	if dsn, ok := strings.CutPrefix(spec, SQLitePrefix); ok {
+		store, err = openSQLite(dsn, opts.Table)
+	} else {
+		store, err = OpenFile(spec, opts)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if opts.Retention < 0 {
+		store.Close()
+		return nil, fmt.Errorf("retention must not be negative")
+	}
+	if opts.Retention == 0 {
+		return store, nil
+	}
+	retained := &retained{Storage: store, retention: opts.Retention}
+	if err := retained.prune(time.Now()); err != nil {
+		store.Close()
+		return nil, err
+	}
+	return retained, nil
+}
+
+// Scan calls fn with the records of the storage described by spec taken between from and to,
+// like Query, without creating a record file that does not exist.
+func Scan(spec string, opts Options, from, to time.Time, fn func(record []byte)) error {
+	if dsn, ok := strings.CutPrefix(spec, SQLitePrefix); ok {
+		store, err := openSQLite(dsn, opts.Table)
+		if err != nil {
+			return err
+		}
+		defer store.Close()
+		return store.Query(from, to, fn)
+	}
+	return scanFile(spec, opts, from, to, fn)
+}
+
+func openSQLite(dsn, table string) (Storage, error) {
+	db, err := sql.Open(SQLiteDriver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SQLite database (is a %q driver linked in?): %w", SQLiteDriver, err)
+	}
+	store, err := OpenSQL(db, table)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	store.owned = true
+	return store, nil
+}
+
+// pruneInterval is how often a Storage with a retention drops old records.
+const pruneInterval = time.Hour
+
+// retained prunes the records older than its retention as records are appended.
+type retained struct {
+	Storage
+	retention time.Duration
+
+	mu         sync.Mutex
+	lastPruned time.Time
+}
+
+func (r *retained) Append(at time.Time, record any) error {
+	if err := r.Storage.Append(at, record); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	due := at.Sub(r.lastPruned) >= pruneInterval
+	r.mu.Unlock()
+	if due {
+		if err := r.prune(at); err != nil {
+			logging.Warn("storage.retained.Append: %v", err)
+		}
+	}
+	return nil
+}
+
+func (r *retained) prune(now time.Time) error {
+	r.mu.Lock()
+	r.lastPruned = now
+	r.mu.Unlock()
+	if err := r.Prune(now.Add(-r.retention)); err != nil {
+		return fmt.Errorf("failed to drop records older than %v: %w", r.retention, err)
+	}
+	return nil
+}
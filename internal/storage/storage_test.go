@@ -0,0 +1,231 @@
+package storage
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type testRecord struct {
+	N  int       `json:"n"`
+	At time.Time `json:"at"`
+}
+
+// exercise appends records an hour apart and checks Query and Prune on them.
+func exercise(t *testing.T, store Storage) {
+	t.Helper()
+	base := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		at := base.Add(time.Duration(i) * time.Hour)
+		if err := store.Append(at, testRecord{N: i, At: at}); err != nil {
+			t.Fatalf("Append() error = %v", err)
+		}
+	}
+
+	query := func(from, to time.Time) []int {
+		var got []int
+		err := store.Query(from, to, func(record []byte) {
+			var r testRecord
+			if err := json.Unmarshal(record, &r); err != nil {
+				t.Errorf("Query() record %s: %v", record, err)
+			}
+			got = append(got, r.N)
+		})
+		if err != nil {
+			t.Fatalf("Query() error = %v", err)
+		}
+		return got
+	}
+	if got := query(time.Time{}, time.Time{}); fmt.Sprint(got) != "[0 1 2 3 4]" {
+		t.Errorf("Query(all) = %v", got)
+	}
+	if got := query(base.Add(time.Hour), base.Add(3*time.Hour)); fmt.Sprint(got) != "[1 2 3]" {
+		t.Errorf("Query(1h-3h) = %v", got)
+	}
+	if got := query(base.Add(4*time.Hour), time.Time{}); fmt.Sprint(got) != "[4]" {
+		t.Errorf("Query(4h-) = %v", got)
+	}
+
+	if err := store.Prune(base.Add(2 * time.Hour)); err != nil {
+		t.Fatalf("Prune() error = %v", err)
+	}
+	if got := query(time.Time{}, time.Time{}); fmt.Sprint(got) != "[2 3 4]" {
+		t.Errorf("Query() after Prune() = %v", got)
+	}
+	// Appending still works after pruning
+	if err := store.Append(base.Add(5*time.Hour), testRecord{N: 5, At: base.Add(5 * time.Hour)}); err != nil {
+		t.Fatalf("Append() after Prune() error = %v", err)
+	}
+	if got := query(time.Time{}, time.Time{}); fmt.Sprint(got) != "[2 3 4 5]" {
+		t.Errorf("Query() after Append() = %v", got)
+	}
+}
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "records.jsonl")
+	// Small enough that the records spread over the rotated files
+	store, err := Open(path, Options{MaxBytes: 120, Keep: 5, Sync: true, TimeField: "at"})
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer store.Close()
+	exercise(t, store)
+
+	files, _ := filepath.Glob(path + "*")
+	if len(files) < 2 {
+		t.Errorf("record files = %v, want rotated files", files)
+	}
+	var got int
+	Scan(path, Options{Keep: 5, TimeField: "at"}, time.Time{}, time.Time{}, func([]byte) { got++ })
+	if got != 4 {
+		t.Errorf("Scan() = %d records, want 4", got)
+	}
+}
+
+func TestScan_MissingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "missing.jsonl")
+	if err := Scan(path, Options{}, time.Time{}, time.Time{}, func([]byte) { t.Error("Scan() found a record") }); err != nil {
+		t.Errorf("Scan() error = %v", err)
+	}
+	if _, err := os.Stat(path); err == nil {
+		t.Error("Scan() created the file")
+	}
+}
+
+func TestOpen_Retention(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "records.jsonl")
+	old := time.Now().Add(-48 * time.Hour)
+	store, _ := Open(path, Options{TimeField: "at"})
+	store.Append(old, testRecord{N: 0, At: old})
+	store.Close()
+
+	store, err := Open(path, Options{TimeField: "at", Retention: 24 * time.Hour})
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer store.Close()
+	now := time.Now()
+	store.Append(now, testRecord{N: 1, At: now})
+	var got []string
+	store.Query(time.Time{}, time.Time{}, func(record []byte) { got = append(got, string(record)) })
+	if len(got) != 1 || !strings.Contains(got[0], `"n":1`) {
+		t.Errorf("Query() = %v, want only the recent record", got)
+	}
+
+	if _, err := Open(path, Options{Retention: -time.Hour}); err == nil {
+		t.Error("Open() should reject a negative retention")
+	}
+	if _, err := Open(filepath.Join(t.TempDir(), "x"), Options{Retention: time.Hour}); err == nil {
+		t.Error("Open() should require a time field to prune files")
+	}
+}
+
+func TestSQL(t *testing.T) {
+	SQLiteDriver = "fakesql"
+	defer func() { SQLiteDriver = "sqlite" }()
+
+	store, err := Open(SQLitePrefix+"test", Options{Table: "audit"})
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer store.Close()
+	exercise(t, store)
+
+	if _, err := OpenSQL(nil, "bad; DROP TABLE x"); err == nil {
+		t.Error("OpenSQL() should reject an invalid table name")
+	}
+	SQLiteDriver = "missing"
+	if _, err := Open(SQLitePrefix+"test", Options{}); err == nil {
+		t.Error("Open() should fail without a registered driver")
+	}
+}
+
+// fakeSQL is an in-memory database/sql driver that understands exactly the statements of SQL.
+type fakeSQL struct {
+	mu   sync.Mutex
+	rows []fakeRow
+}
+
+type fakeRow struct {
+	at     int64
+	record string
+}
+
+func init() {
+	sql.Register("fakesql", &fakeSQL{})
+}
+
+func (d *fakeSQL) Open(string) (driver.Conn, error) { return fakeConn{d}, nil }
+
+type fakeConn struct{ d *fakeSQL }
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) { return fakeStmt{c.d, query}, nil }
+func (c fakeConn) Close() error                              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error)                 { return nil, fmt.Errorf("not supported") }
+
+type fakeStmt struct {
+	d     *fakeSQL
+	query string
+}
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return strings.Count(s.query, "?") }
+
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	switch {
+	case strings.HasPrefix(s.query, "CREATE"):
+	case strings.HasPrefix(s.query, "INSERT"):
+		s.d.rows = append(s.d.rows, fakeRow{args[0].(int64), args[1].(string)})
+	case strings.HasPrefix(s.query, "DELETE"):
+		kept := s.d.rows[:0]
+		for _, row := range s.d.rows {
+			if row.at >= args[0].(int64) {
+				kept = append(kept, row)
+			}
+		}
+		s.d.rows = kept
+	default:
+		return nil, fmt.Errorf("unexpected statement %q", s.query)
+	}
+	return driver.RowsAffected(0), nil
+}
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	s.d.mu.Lock()
+	defer s.d.mu.Unlock()
+	if !strings.HasPrefix(s.query, "SELECT") {
+		return nil, fmt.Errorf("unexpected query %q", s.query)
+	}
+	var rows []string
+	matching := append([]fakeRow(nil), s.d.rows...)
+	sort.SliceStable(matching, func(i, j int) bool { return matching[i].at < matching[j].at })
+	for _, row := range matching {
+		if row.at >= args[0].(int64) && row.at <= args[1].(int64) {
+			rows = append(rows, row.record)
+		}
+	}
+	return &fakeRows{rows: rows}, nil
+}
+
+type fakeRows struct{ rows []string }
+
+func (r *fakeRows) Columns() []string { return []string{"record"} }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	dest[0], r.rows = r.rows[0], r.rows[1:]
+	return nil
+}
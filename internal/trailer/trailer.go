@@ -0,0 +1,147 @@
+// Package trailer seals and opens the per-hop timing trailers Renoters add to the 29001
+// containers of events whose sender asked for latency reports.
+package trailer
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/girino/renoter/internal/config"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip44"
+)
+
+// plaintextSize is the length every trailer plaintext is padded to before encryption, so real
+// trailers and decoys have ciphertexts of the same length.
+const plaintextSize = 160
+
+// Timing is what one Renoter reports about an event it handled.
+type Timing struct {
+	// Hex pubkey of the Renoter
+	Renoter string
+	// When the Renoter started processing the container
+	ReceivedAt time.Time
+	// When it forwarded the next container or published the final event
+	ForwardedAt time.Time
+	// Mixing delay it held the event for, included in ForwardedAt - ReceivedAt
+	Held time.Duration
+}
+
+// wireTiming is the JSON form of Timing, in milliseconds.
+type wireTiming struct {
+	Renoter     string `json:"r"`
+	ReceivedAt  int64  `json:"in"`
+	ForwardedAt int64  `json:"out"`
+	Held        int64  `json:"held"`
+}
+
+// Seal encrypts timing to reportPubkey with a throwaway key and returns it as a TrailerTag.
+func Seal(timing Timing, reportPubkey string) (nostr.Tag, error) {
+	plaintext, err := json.Marshal(wireTiming{
+		Renoter:     timing.Renoter,
+		ReceivedAt:  timing.ReceivedAt.UnixMilli(),
+		ForwardedAt: timing.ForwardedAt.UnixMilli(),
+		Held:        timing.Held.Milliseconds(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize trailer: %w", err)
+	}
+	if len(plaintext) > plaintextSize {
+		return nil, fmt.Errorf("trailer of %d bytes exceeds %d bytes", len(plaintext), plaintextSize)
+	}
+	return encrypt(string(plaintext)+strings.Repeat(" ", plaintextSize-len(plaintext)), reportPubkey)
+}
+
+// Decoy returns a TrailerTag indistinguishable from a sealed one that nobody can open.
+func Decoy() (nostr.Tag, error) {
+	junk := make([]byte, plaintextSize/2)
+	rand.Read(junk)
+	target, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	return encrypt(fmt.Sprintf("%x", junk), target)
+}
+
+// encrypt encrypts a padded plaintext to pubkey with a throwaway key.
+func encrypt(plaintext, pubkey string) (nostr.Tag, error) {
+	sk := nostr.GeneratePrivateKey()
+	ephemeral, err := nostr.GetPublicKey(sk)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get public key: %w", err)
+	}
+	conversationKey, err := nip44.GenerateConversationKey(pubkey, sk)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate conversation key: %w", err)
+	}
+	ciphertext, err := nip44.Encrypt(plaintext, conversationKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt trailer: %w", err)
+	}
+	return nostr.Tag{config.TrailerTag, ephemeral, ciphertext}, nil
+}
+
+// Open decrypts a TrailerTag sealed to the public key of reportSk. Decoys and trailers sealed
+// to other keys fail.
+func Open(tag nostr.Tag, reportSk string) (Timing, error) {
+	if len(tag) < 3 || tag[0] != config.TrailerTag {
+		return Timing{}, fmt.Errorf("not a trailer tag")
+	}
+	conversationKey, err := nip44.GenerateConversationKey(tag[1], reportSk)
+	if err != nil {
+		return Timing{}, fmt.Errorf("invalid trailer key: %w", err)
+	}
+	plaintext, err := nip44.Decrypt(tag[2], conversationKey)
+	if err != nil {
+		return Timing{}, fmt.Errorf("failed to decrypt trailer: %w", err)
+	}
+	var wire wireTiming
+	if err := json.Unmarshal([]byte(strings.TrimRight(plaintext, " ")), &wire); err != nil {
+		return Timing{}, fmt.Errorf("invalid trailer: %w", err)
+	}
+	return Timing{
+		Renoter:     wire.Renoter,
+		ReceivedAt:  time.UnixMilli(wire.ReceivedAt),
+		ForwardedAt: time.UnixMilli(wire.ForwardedAt),
+		Held:        time.Duration(wire.Held) * time.Millisecond,
+	}, nil
+}
+
+// Decoys returns the TrailerSlots decoy trailers a sender puts on the first container.
+func Decoys() (nostr.Tags, error) {
+	tags := make(nostr.Tags, 0, config.TrailerSlots)
+	for len(tags) < config.TrailerSlots {
+		decoy, err := Decoy()
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, decoy)
+	}
+	return tags, nil
+}
+
+// Carry returns the trailers of an incoming container's tags to put on the next container, with
+// own (if not nil) appended in place of the oldest. Missing slots are filled with decoys, so
+// the result always has TrailerSlots trailers.
+func Carry(incoming nostr.Tags, own nostr.Tag) (nostr.Tags, error) {
+	var trailers nostr.Tags
+	for _, tag := range incoming {
+		if len(tag) >= 3 && tag[0] == config.TrailerTag {
+			trailers = append(trailers, tag)
+		}
+	}
+	if len(trailers) > config.TrailerSlots {
+		trailers = trailers[len(trailers)-config.TrailerSlots:]
+	}
+	for len(trailers) < config.TrailerSlots {
+		decoy, err := Decoy()
+		if err != nil {
+			return nil, err
+		}
+		trailers = append(nostr.Tags{decoy}, trailers...)
+	}
+	if own != nil {
+		trailers = append(trailers[1:], own)
+	}
+	return trailers, nil
+}
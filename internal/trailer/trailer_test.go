@@ -0,0 +1,84 @@
+package trailer
+
+import (
+	"testing"
+	"time"
+
+	"github.com/girino/renoter/internal/config"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestSealOpen(t *testing.T) {
+	reportSk := nostr.GeneratePrivateKey()
+	reportPk, _ := nostr.GetPublicKey(reportSk)
+	renoter, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	timing := Timing{
+		Renoter:     renoter,
+		ReceivedAt:  time.UnixMilli(1700000000123),
+		ForwardedAt: time.UnixMilli(1700000042456),
+		Held:        42 * time.Second,
+	}
+
+	tag, err := Seal(timing, reportPk)
+	if err != nil {
+		t.Fatalf("Seal() error = %v", err)
+	}
+	opened, err := Open(tag, reportSk)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if opened != timing {
+		t.Errorf("Open() = %+v, want %+v", opened, timing)
+	}
+	if _, err := Open(tag, nostr.GeneratePrivateKey()); err == nil {
+		t.Error("Open() with another key should fail")
+	}
+
+	decoy, err := Decoy()
+	if err != nil {
+		t.Fatalf("Decoy() error = %v", err)
+	}
+	if len(decoy[1]) != len(tag[1]) || len(decoy[2]) != len(tag[2]) {
+		t.Errorf("decoy is %d/%d bytes, sealed trailer %d/%d", len(decoy[1]), len(decoy[2]), len(tag[1]), len(tag[2]))
+	}
+	if _, err := Open(decoy, reportSk); err == nil {
+		t.Error("Open() of a decoy should fail")
+	}
+}
+
+func TestCarry(t *testing.T) {
+	reportSk := nostr.GeneratePrivateKey()
+	reportPk, _ := nostr.GetPublicKey(reportSk)
+
+	trailers, err := Decoys()
+	if err != nil {
+		t.Fatalf("Decoys() error = %v", err)
+	}
+	// More hops than slots: only the last TrailerSlots are kept, in hop order
+	hops := config.TrailerSlots + 2
+	for hop := 0; hop < hops; hop++ {
+		own, _ := Seal(Timing{Renoter: "hop", Held: time.Duration(hop) * time.Millisecond}, reportPk)
+		container := append(nostr.Tags{{"p", "next"}}, trailers...)
+		if trailers, err = Carry(container, own); err != nil {
+			t.Fatalf("Carry() error = %v", err)
+		}
+		if len(trailers) != config.TrailerSlots {
+			t.Fatalf("Carry() = %d trailers, want %d", len(trailers), config.TrailerSlots)
+		}
+	}
+	for i, tag := range trailers {
+		timing, err := Open(tag, reportSk)
+		if err != nil {
+			t.Fatalf("Open() of slot %d error = %v", i, err)
+		}
+		if want := time.Duration(hops-config.TrailerSlots+i) * time.Millisecond; timing.Held != want {
+			t.Errorf("slot %d held %v, want %v", i, timing.Held, want)
+		}
+	}
+
+	// A container without trailers gets decoys, and carrying without an own trailer keeps them
+	carried, err := Carry(nostr.Tags{{"p", "next"}}, nil)
+	if err != nil || len(carried) != config.TrailerSlots {
+		t.Errorf("Carry() of a container without trailers = %d trailers, %v", len(carried), err)
+	}
+}
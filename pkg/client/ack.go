@@ -0,0 +1,133 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/girino/renoter/internal/config"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip44"
+)
+
+// HopAckKey is what reading the hop acknowledgement of one layer takes: the layer's ephemeral
+// pubkey, which the acknowledgement is p-tagged with, and its conversation key with the Renoter.
+type HopAckKey struct {
+	// Hex pubkey of the Renoter the layer is addressed to
+	Renoter string
+	// Hex pubkey of the layer
+	Layer           string
+	conversationKey [32]byte
+}
+
+// HopAck is the acknowledgement of one hop of a wrapped event.
+type HopAck struct {
+	// Position of the Renoter on the path, 0 for the entry
+	Hop int
+	// Hex pubkey of the Renoter
+	Renoter string
+	// config.AckForwarded, config.AckPublished or config.AckRejected
+	Outcome string
+	// Why the Renoter rejected the event, e.g. "quota" (empty otherwise)
+	Reason string
+}
+
+// WrapEventWithAcks is like WrapEvent but asks every Renoter on the path for an
+// encrypted hop acknowledgement, and returns the keys to read them with, in path order. Each layer
+// carries a sealed ack tag, so the largest accepted event is slightly smaller. Renoters announcing
+// a protocol version before config.HopAcksVersion would reject the layers, so they are refused.
+func WrapEventWithAcks(ctx context.Context, originalEvent *nostr.Event, renterPath Path, opts WrapOptions) (*nostr.Event, []HopAckKey, error) {
+	for _, node := range renterPath {
+		if node.Descriptor != nil && node.Descriptor.Version < config.HopAcksVersion {
+			logging.Error("client.ack.WrapEventWithAcks: Renoter %s does not speak protocol version %d", node.Key(), config.HopAcksVersion)
+			return nil, nil, fmt.Errorf("renoter %s does not speak protocol version %d needed for hop acknowledgements", node.Key(), config.HopAcksVersion)
+		}
+	}
+	opts.ReportLatency = false
+	opts.hopAcks = true
+	wrapped, layers, err := wrapEvent(ctx, originalEvent, renterPath, opts, "")
+	if err != nil {
+		return nil, nil, err
+	}
+	keys := make([]HopAckKey, len(layers))
+	for i, layer := range layers {
+		keys[i] = HopAckKey{Renoter: renterPath[i].Key(), Layer: layer.pubkey, conversationKey: layer.conversationKey}
+	}
+	return wrapped, keys, nil
+}
+
+// AwaitHopAcks subscribes on relayURLs to the acknowledgements of the layers keyed by keys and
+// delivers them as they arrive, closing the channel once every hop answered or ctx is done. Call
+// it before publishing the container. A hop forwarding the event passes it on to the next, so a
+// hop that stays silent long after the previous one answered lost it, or dropped it as a
+// duplicate; the sender may then resend the event over a path avoiding that hop.
+func AwaitHopAcks(ctx context.Context, pool Pool, relayURLs []string, keys []HopAckKey) <-chan HopAck {
+	ctx, cancel := context.WithCancel(ctx)
+	layers := make([]string, len(keys))
+	for i, key := range keys {
+		layers[i] = key.Layer
+	}
+	since := nostr.Now()
+	events := pool.SubscribeMany(ctx, relayURLs, nostr.Filter{
+		Kinds: []int{config.HopAckKind},
+		Tags:  nostr.TagMap{"p": layers},
+		Since: &since,
+	})
+
+	// Buffered for every hop, so delivering never blocks
+	acks := make(chan HopAck, len(keys))
+	go func() {
+		defer cancel()
+		defer close(acks)
+		answered := make(map[int]bool)
+		for len(answered) < len(keys) {
+			select {
+			case <-ctx.Done():
+				return
+			case relayEvent, ok := <-events:
+				if !ok {
+					return
+				}
+				ack, err := openHopAck(relayEvent.Event, keys)
+				if err != nil {
+					logging.DebugMethod("client.ack", "AwaitHopAcks", "Ignoring hop acknowledgement %s: %v", relayEvent.Event.ID, err)
+					continue
+				}
+				if !answered[ack.Hop] {
+					answered[ack.Hop] = true
+					acks <- ack
+				}
+			}
+		}
+	}()
+	return acks
+}
+
+// openHopAck decrypts a HopAckKind event with the key of the layer it is p-tagged with.
+func openHopAck(event *nostr.Event, keys []HopAckKey) (HopAck, error) {
+	tag := event.Tags.Find("p")
+	if tag == nil {
+		return HopAck{}, fmt.Errorf("no p tag")
+	}
+	for hop, key := range keys {
+		if key.Layer != tag[1] {
+			continue
+		}
+		plaintext, err := nip44.Decrypt(event.Content, key.conversationKey)
+		if err != nil {
+			return HopAck{}, fmt.Errorf("failed to decrypt: %w", err)
+		}
+		var ack config.HopAck
+		if err := json.Unmarshal([]byte(plaintext), &ack); err != nil {
+			return HopAck{}, fmt.Errorf("invalid acknowledgement: %w", err)
+		}
+		switch ack.Outcome {
+		case config.AckForwarded, config.AckPublished, config.AckRejected:
+		default:
+			return HopAck{}, fmt.Errorf("unknown outcome %q", ack.Outcome)
+		}
+		return HopAck{Hop: hop, Renoter: key.Renoter, Outcome: ack.Outcome, Reason: ack.Reason}, nil
+	}
+	return HopAck{}, fmt.Errorf("not for a layer of this event")
+}
@@ -0,0 +1,67 @@
+package client
+
+import (
+	"context"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/girino/renoter/internal/config"
+	"github.com/girino/renoter/internal/descriptor"
+	"github.com/girino/renoter/pkg/server"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestAwaitHopAcks(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	relay, _, _ := newDispatcherTestSetup(t, ctx)
+	var path Path
+	for i := 0; i < 2; i++ {
+		renoter, err := server.NewRenoter(ctx, nostr.GeneratePrivateKey(), []string{relay.URL()})
+		if err != nil {
+			t.Fatalf("NewRenoter() error = %v", err)
+		}
+		if err := renoter.SubscribeToWrappedEvents(ctx); err != nil {
+			t.Fatalf("SubscribeToWrappedEvents() error = %v", err)
+		}
+		pubkey, _ := hex.DecodeString(renoter.GetPublicKey())
+		path = append(path, NewPath(pubkey)...)
+	}
+
+	wrapped, keys, err := WrapEventWithAcks(ctx, newDispatcherTestEvent(), path, DefaultWrapOptions())
+	if err != nil {
+		t.Fatalf("WrapEventWithAcks() error = %v", err)
+	}
+	pool := nostr.NewSimplePool(ctx)
+	acks := AwaitHopAcks(ctx, pool, []string{relay.URL()}, keys)
+	time.Sleep(subscriptionWarmUp)
+	for result := range pool.PublishMany(ctx, []string{relay.URL()}, *wrapped) {
+		if result.Error != nil {
+			t.Fatalf("PublishMany() error = %v", result.Error)
+		}
+	}
+
+	want := []string{config.AckForwarded, config.AckPublished}
+	got := make([]string, len(path))
+	for ack := range acks {
+		if ack.Renoter != path[ack.Hop].Key() {
+			t.Errorf("hop %d acknowledged by %s, want %s", ack.Hop, ack.Renoter, path[ack.Hop].Key())
+		}
+		got[ack.Hop] = ack.Outcome
+	}
+	for hop := range want {
+		if got[hop] != want[hop] {
+			t.Errorf("hop %d acknowledged %q, want %q", hop, got[hop], want[hop])
+		}
+	}
+}
+
+func TestWrapEventWithAcks_RefusesOldRenoters(t *testing.T) {
+	path := testRenoters(2)
+	path[1].Descriptor = &descriptor.Descriptor{Version: config.HopAcksVersion - 1}
+	if _, _, err := WrapEventWithAcks(context.Background(), newDispatcherTestEvent(), path, DefaultWrapOptions()); err == nil {
+		t.Error("WrapEventWithAcks() accepted a Renoter without hop acknowledgements")
+	}
+}
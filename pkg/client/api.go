@@ -0,0 +1,256 @@
+package client
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/girino/renoter/internal/config"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// publishMaxBody bounds the request body of the publish endpoint.
+const publishMaxBody = 1 << 20
+
+// PublishAPI is an HTTP endpoint submitting events without the Nostr websocket protocol, for
+// scripts and server-side apps: a POST with the signed event as its JSON body and the token as
+// a bearer token waits until the event is dispatched and returns the outcome as JSON. With
+// ?async=true it returns the queued Job at once instead, to be followed on the Jobs handler.
+// Pass it via Options.PublishAPI and serve it, e.g. on "/publish".
+type PublishAPI struct {
+	token string
+
+	mu         sync.RWMutex
+	dispatcher *Dispatcher
+}
+
+// PublishResult is the outcome of publishing one event, as returned by the publish endpoint and
+// printed by PublishStream.
+type PublishResult struct {
+	// ID of the submitted event
+	EventID string `json:"id"`
+	// ID of the published 29001 container (empty if wrapping failed)
+	WrappedID string `json:"wrapped_id,omitempty"`
+	// Renoters on the path the event was wrapped for
+	Hops int `json:"hops,omitempty"`
+	// Server relays that accepted the container
+	Accepted int `json:"accepted"`
+	// Result on each server relay
+	Results []JournalResult `json:"results,omitempty"`
+	// Rejection or failure, in the config.Rejection format
+	Error string `json:"error,omitempty"`
+}
+
+// NewPublishAPI creates a publish endpoint accepting requests that carry token.
+func NewPublishAPI(token string) (*PublishAPI, error) {
+	if token == "" {
+		return nil, fmt.Errorf("the publish API needs a token")
+	}
+	return &PublishAPI{token: token}, nil
+}
+
+// attach makes the endpoint submit to dispatcher. A nil *PublishAPI ignores it.
+func (a *PublishAPI) attach(dispatcher *Dispatcher) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	a.dispatcher = dispatcher
+	a.mu.Unlock()
+}
+
+// authorized reports whether a request carries the token.
+func (a *PublishAPI) authorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) == 1
+}
+
+// ServeHTTP handles a publish request.
+func (a *PublishAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writePublishResult(w, http.StatusMethodNotAllowed, PublishResult{Error: "only POST is supported"})
+		return
+	}
+	if !a.authorized(r) {
+		logging.Warn("client.api.ServeHTTP: rejecting unauthorized publish request from %s", r.RemoteAddr)
+		writePublishResult(w, http.StatusUnauthorized, PublishResult{Error: "missing or invalid bearer token"})
+		return
+	}
+	a.mu.RLock()
+	dispatcher := a.dispatcher
+	a.mu.RUnlock()
+	if dispatcher == nil {
+		writePublishResult(w, http.StatusServiceUnavailable, PublishResult{Error: "the client is not ready yet"})
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, publishMaxBody+1))
+	if err != nil || len(body) > publishMaxBody {
+		writePublishResult(w, http.StatusBadRequest, PublishResult{Error: "request body unreadable or too large"})
+		return
+	}
+	var event nostr.Event
+	if err := json.Unmarshal(body, &event); err != nil {
+		writePublishResult(w, http.StatusBadRequest, PublishResult{Error: fmt.Sprintf("%s: malformed event: %v", config.PrefixInvalid, err)})
+		return
+	}
+
+	if async, _ := strconv.ParseBool(r.URL.Query().Get("async")); async {
+		id, err := dispatcher.SubmitEvent(&event)
+		if err != nil {
+			writePublishResult(w, publishStatus(err), NewPublishResult(event.ID, JournalEntry{}, err))
+			return
+		}
+		job, _ := dispatcher.Jobs().Get(id)
+		writeJSON(w, http.StatusAccepted, job)
+		return
+	}
+	entry, err := dispatcher.Publish(r.Context(), &event)
+	writePublishResult(w, publishStatus(err), NewPublishResult(event.ID, entry, err))
+}
+
+// Jobs returns the handler following the events submitted with ?async=true, to be served on
+// "/jobs" and "/jobs/" with the same token. GET /jobs lists the jobs kept and GET /jobs/<id>
+// returns one. Asked for text/event-stream, both stream instead: every job update as a
+// server-sent event, ending for a single job once it is delivered or failed.
+func (a *PublishAPI) Jobs() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			writeJSON(w, http.StatusMethodNotAllowed, PublishResult{Error: "only GET is supported"})
+			return
+		}
+		if !a.authorized(r) {
+			logging.Warn("client.api.Jobs: rejecting unauthorized jobs request from %s", r.RemoteAddr)
+			writeJSON(w, http.StatusUnauthorized, PublishResult{Error: "missing or invalid bearer token"})
+			return
+		}
+		a.mu.RLock()
+		dispatcher := a.dispatcher
+		a.mu.RUnlock()
+		if dispatcher == nil {
+			writeJSON(w, http.StatusServiceUnavailable, PublishResult{Error: "the client is not ready yet"})
+			return
+		}
+
+		jobs := dispatcher.Jobs()
+		id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/jobs"), "/")
+		stream := strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+		switch {
+		case stream:
+			streamJobs(w, r, jobs, id)
+		case id == "":
+			writeJSON(w, http.StatusOK, jobs.List())
+		default:
+			job, ok := jobs.Get(id)
+			if !ok {
+				writeJSON(w, http.StatusNotFound, PublishResult{Error: fmt.Sprintf("unknown job %q", id)})
+				return
+			}
+			writeJSON(w, http.StatusOK, job)
+		}
+	})
+}
+
+// streamJobs sends job updates as server-sent events until the request ends: those of the job
+// with the given ID until it finishes, or those of every job if id is empty. A single job's
+// current state is sent first.
+func streamJobs(w http.ResponseWriter, r *http.Request, jobs *Jobs, id string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeJSON(w, http.StatusInternalServerError, PublishResult{Error: "streaming is not supported"})
+		return
+	}
+	// Subscribe before reading the current state so no update falls in between
+	updates, unsubscribe := jobs.Subscribe()
+	defer unsubscribe()
+	var current Job
+	if id != "" {
+		if current, ok = jobs.Get(id); !ok {
+			writeJSON(w, http.StatusNotFound, PublishResult{Error: fmt.Sprintf("unknown job %q", id)})
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	send := func(job Job) {
+		data, _ := json.Marshal(job)
+		fmt.Fprintf(w, "event: %s\ndata: %s\n\n", job.State, data)
+		flusher.Flush()
+	}
+	if id != "" {
+		send(current)
+		if current.State.Finished() {
+			return
+		}
+	}
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case job := <-updates:
+			if id != "" && job.ID != id {
+				continue
+			}
+			send(job)
+			if id != "" && job.State.Finished() {
+				return
+			}
+		}
+	}
+}
+
+// NewPublishResult summarizes the outcome of publishing the event with ID eventID with
+// Dispatcher.Publish.
+func NewPublishResult(eventID string, entry JournalEntry, err error) PublishResult {
+	result := PublishResult{EventID: eventID, WrappedID: entry.WrappedID, Hops: entry.Hops, Results: entry.Results}
+	for _, relayResult := range entry.Results {
+		if relayResult.OK {
+			result.Accepted++
+		}
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// publishStatus maps the outcome of a publish request to an HTTP status.
+func publishStatus(err error) int {
+	var rejection *config.Rejection
+	switch {
+	case err == nil:
+		return http.StatusOK
+	case !errors.As(err, &rejection):
+		// The request was cancelled before the event was dispatched
+		return http.StatusGatewayTimeout
+	case rejection.Prefix == config.PrefixInvalid:
+		return http.StatusBadRequest
+	case rejection.Prefix == config.PrefixBlocked:
+		return http.StatusForbidden
+	case rejection.Prefix == config.PrefixRateLimited:
+		return http.StatusTooManyRequests
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+func writePublishResult(w http.ResponseWriter, status int, result PublishResult) {
+	writeJSON(w, status, result)
+}
+
+func writeJSON(w http.ResponseWriter, status int, value any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
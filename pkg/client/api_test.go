@@ -0,0 +1,141 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/girino/renoter/internal/config"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestPublishAPI(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	relay, path, pool := newDispatcherTestSetup(t, ctx)
+	nostr.NewSimplePool(ctx).SubscribeMany(ctx, []string{relay.URL()}, nostr.Filter{Kinds: []int{config.StandardizedWrapperKind}})
+	time.Sleep(200 * time.Millisecond)
+
+	api, err := NewPublishAPI("secret")
+	if err != nil {
+		t.Fatalf("NewPublishAPI() error = %v", err)
+	}
+	if _, err := NewPublishAPI(""); err == nil {
+		t.Error("NewPublishAPI() without a token should error")
+	}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	post := func(token, body string) (int, PublishResult) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPost, server.URL, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("POST error = %v", err)
+		}
+		defer resp.Body.Close()
+		var result PublishResult
+		json.NewDecoder(resp.Body).Decode(&result)
+		return resp.StatusCode, result
+	}
+
+	event := newDispatcherTestEvent()
+	eventJSON, _ := json.Marshal(event)
+	if status, _ := post("secret", string(eventJSON)); status != http.StatusServiceUnavailable {
+		t.Errorf("status before the dispatcher is attached = %d, want %d", status, http.StatusServiceUnavailable)
+	}
+
+	dispatcher, err := NewDispatcher(ctx, path, pool, []string{relay.URL()}, DefaultOptions())
+	if err != nil {
+		t.Fatalf("NewDispatcher() error = %v", err)
+	}
+	api.attach(dispatcher)
+
+	if status, _ := post("wrong", string(eventJSON)); status != http.StatusUnauthorized {
+		t.Errorf("status with a wrong token = %d, want %d", status, http.StatusUnauthorized)
+	}
+	if status, result := post("secret", "{not json"); status != http.StatusBadRequest || !strings.HasPrefix(result.Error, "invalid:") {
+		t.Errorf("malformed body = %d %+v, want 400 with an invalid: error", status, result)
+	}
+	forged := *event
+	forged.Content = "tampered"
+	forgedJSON, _ := json.Marshal(forged)
+	if status, result := post("secret", string(forgedJSON)); status != http.StatusBadRequest || !strings.Contains(result.Error, config.RejectBadID) {
+		t.Errorf("tampered event = %d %+v, want 400 with %s", status, result, config.RejectBadID)
+	}
+
+	status, result := post("secret", string(eventJSON))
+	if status != http.StatusOK || result.EventID != event.ID || result.WrappedID == "" || result.Hops != 1 || result.Accepted != 1 || len(result.Results) != 1 {
+		t.Errorf("publish = %d %+v, want the dispatch summary", status, result)
+	}
+}
+
+func TestPublishAPI_Jobs(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pk, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	path, _ := ValidatePath([]string{mustNpub(t, pk)})
+	dispatcher, err := NewDispatcher(ctx, path, &fakePool{published: make(chan nostr.Event, 1)}, []string{"wss://relay.example.com"}, DefaultOptions())
+	if err != nil {
+		t.Fatalf("NewDispatcher() error = %v", err)
+	}
+	api, _ := NewPublishAPI("secret")
+	api.attach(dispatcher)
+	mux := http.NewServeMux()
+	mux.Handle("/publish", api)
+	mux.Handle("/jobs/", api.Jobs())
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	request := func(method, url, accept string, body string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(method, server.URL+url, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s error = %v", method, url, err)
+		}
+		return resp
+	}
+
+	event := newDispatcherTestEvent()
+	eventJSON, _ := json.Marshal(event)
+	resp := request(http.MethodPost, "/publish?async=true", "", string(eventJSON))
+	var job Job
+	json.NewDecoder(resp.Body).Decode(&job)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted || job.ID == "" || job.EventID != event.ID {
+		t.Fatalf("async publish = %d %+v, want 202 with a job", resp.StatusCode, job)
+	}
+
+	// The stream ends once the job is delivered
+	resp = request(http.MethodGet, "/jobs/"+job.ID, "text/event-stream", "")
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.Header.Get("Content-Type") != "text/event-stream" || !strings.Contains(string(body), "event: delivered\ndata: {") {
+		t.Errorf("stream = %q, want a delivered event", body)
+	}
+
+	resp = request(http.MethodGet, "/jobs/"+job.ID, "", "")
+	json.NewDecoder(resp.Body).Decode(&job)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || job.State != JobDelivered || job.WrappedID == "" {
+		t.Errorf("GET job = %d %+v, want the delivered job", resp.StatusCode, job)
+	}
+	if resp := request(http.MethodGet, "/jobs/unknown", "", ""); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("GET unknown job = %d, want 404", resp.StatusCode)
+	}
+}
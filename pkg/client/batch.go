@@ -0,0 +1,71 @@
+package client
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/girino/nostr-lib/logging"
+)
+
+// batch holds wrapped events until the next time slice. Publishing only at fixed wall-clock
+// intervals, in shuffled order, keeps submission times from correlating with app activity.
+type batch struct {
+	interval time.Duration
+
+	mu      sync.Mutex
+	pending []*wrappedJob
+}
+
+// add holds a wrapped event for the next flush.
+func (b *batch) add(w *wrappedJob) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.pending = append(b.pending, w)
+	logging.DebugMethod("client.batch", "add", "Holding wrapped event %s for the next flush (%d held)", w.wrapped.ID, len(b.pending))
+}
+
+// take removes and returns the held events in random order.
+func (b *batch) take() []*wrappedJob {
+	b.mu.Lock()
+	held := b.pending
+	b.pending = nil
+	b.mu.Unlock()
+	rand.Shuffle(len(held), func(i, j int) { held[i], held[j] = held[j], held[i] })
+	return held
+}
+
+// nextFlush returns the next multiple of interval after now, so every client using the same
+// interval flushes at the same wall-clock instants.
+func (b *batch) nextFlush(now time.Time) time.Time {
+	return now.Truncate(b.interval).Add(b.interval)
+}
+
+// runBatches publishes the held events at every time slice until ctx is done.
+func (d *Dispatcher) runBatches(ctx context.Context) {
+	defer d.wg.Done()
+	for {
+		timer := time.NewTimer(time.Until(d.batch.nextFlush(time.Now())))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+			d.flush(ctx)
+		}
+	}
+}
+
+// flush publishes every held event, in shuffled order, and reports the outcomes.
+func (d *Dispatcher) flush(ctx context.Context) {
+	held := d.batch.take()
+	if len(held) == 0 {
+		return
+	}
+	logging.Info("client.batch.flush: Publishing %d held events", len(held))
+	for _, w := range held {
+		msg, ok := d.publish(ctx, w)
+		d.finish(ctx, w.job, msg, ok)
+	}
+}
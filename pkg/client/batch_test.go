@@ -0,0 +1,83 @@
+package client
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/girino/renoter/internal/config"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestBatch_NextFlush(t *testing.T) {
+	b := &batch{interval: 2 * time.Minute}
+	now := time.Date(2024, 1, 1, 12, 3, 10, 0, time.UTC)
+	if got, want := b.nextFlush(now), time.Date(2024, 1, 1, 12, 4, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("nextFlush(%v) = %v, want %v", now, got, want)
+	}
+	// A flush instant schedules the following slice, not itself
+	if got, want := b.nextFlush(time.Date(2024, 1, 1, 12, 4, 0, 0, time.UTC)), time.Date(2024, 1, 1, 12, 6, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("nextFlush() at a slice boundary = %v, want %v", got, want)
+	}
+}
+
+func TestDispatcher_BatchHoldsUntilFlush(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	relay, path, pool := newDispatcherTestSetup(t, ctx)
+
+	// The relay only accepts ephemeral containers while someone listens
+	nostr.NewSimplePool(ctx).SubscribeMany(ctx, []string{relay.URL()}, nostr.Filter{Kinds: []int{config.StandardizedWrapperKind}})
+	time.Sleep(200 * time.Millisecond)
+
+	opts := DefaultOptions()
+	opts.BatchInterval = time.Hour
+	dispatcher, err := NewDispatcher(ctx, path, pool, []string{relay.URL()}, opts)
+	if err != nil {
+		t.Fatalf("NewDispatcher() error = %v", err)
+	}
+
+	notices := make(chan string, 2)
+	events := []string{}
+	for i := 0; i < 2; i++ {
+		event := newDispatcherTestEvent()
+		events = append(events, event.ID)
+		if err := dispatcher.Submit(event, func(msg string) { notices <- msg }); err != nil {
+			t.Fatalf("Submit() error = %v", err)
+		}
+	}
+
+	// Both events are wrapped, but held until the time slice ends
+	deadline := time.Now().Add(30 * time.Second)
+	for {
+		dispatcher.batch.mu.Lock()
+		held := len(dispatcher.batch.pending)
+		dispatcher.batch.mu.Unlock()
+		if held == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d events held, want 2", held)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	select {
+	case msg := <-notices:
+		t.Fatalf("notice %q before the flush", msg)
+	default:
+	}
+
+	dispatcher.flush(ctx)
+	for range events {
+		select {
+		case msg := <-notices:
+			if !strings.Contains(msg, "renoter: dispatched event") {
+				t.Errorf("notice = %q, want a dispatch confirmation", msg)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for dispatch notices")
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/girino/renoter/internal/cashu"
+	"github.com/girino/renoter/internal/descriptor"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// cashuTag is the 29000 tag carrying a Cashu token for the Renoter the layer is addressed to.
+const cashuTag = "cashu"
+
+// maxCashuTokenLength is the longest serialized Cashu token handed to a Renoter; the size budget
+// reserves room for it before a token is taken.
+const maxCashuTokenLength = 2048
+
+// CashuWallet supplies Cashu tokens for Renoters that admit 29000 layers with a token instead of PoW.
+type CashuWallet interface {
+	// Token returns a serialized token the Renoter accepts, of at most maxCashuTokenLength bytes;
+	// the wallet must not hand it out again
+	Token(ctx context.Context, renoter *descriptor.Descriptor) (string, error)
+}
+
+// TokenFile is a CashuWallet backed by a file of cashuA tokens, one per line. It hands out the
+// smallest token that covers a Renoter's price and removes it from the file, so split tokens
+// into the denominations your Renoters ask for to avoid overpaying.
+type TokenFile struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewTokenFile opens a token file; it must exist.
+func NewTokenFile(path string) (*TokenFile, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, fmt.Errorf("cashu token file: %w", err)
+	}
+	return &TokenFile{path: path}, nil
+}
+
+// Token implements CashuWallet.
+func (f *TokenFile) Token(ctx context.Context, renoter *descriptor.Descriptor) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read token file: %w", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+
+	best, bestAmount := -1, uint64(0)
+	for i, line := range lines {
+		// Longer tokens do not fit the room events are sized with
+		if len(strings.TrimSpace(line)) > maxCashuTokenLength {
+			continue
+		}
+		token, err := cashu.Decode(line)
+		if err != nil {
+			continue
+		}
+		mint, err := token.Mint()
+		if err != nil || !slices.Contains(renoter.CashuMints, strings.TrimRight(mint, "/")) {
+			continue
+		}
+		amount, err := token.Amount()
+		if err != nil {
+			continue
+		}
+		if amount >= renoter.CashuAmount && (best < 0 || amount < bestAmount) {
+			best, bestAmount = i, amount
+		}
+	}
+	if best < 0 {
+		return "", fmt.Errorf("no token of at least %d sats from an accepted mint", renoter.CashuAmount)
+	}
+
+	// Remove the token before handing it out, so it is never sent twice
+	token := strings.TrimSpace(lines[best])
+	remaining := slices.Delete(lines, best, best+1)
+	content := strings.Join(remaining, "\n")
+	if content != "" {
+		content += "\n"
+	}
+	if err := os.WriteFile(f.path, []byte(content), 0o600); err != nil {
+		return "", fmt.Errorf("failed to update token file: %w", err)
+	}
+	logging.DebugMethod("client.cashu", "Token", "Using a %d sat token for a %d sat Renoter, %d tokens left", bestAmount, renoter.CashuAmount, len(remaining))
+	return token, nil
+}
+
+// Balance returns the number of tokens in the file and their total value. It fails on lines
+// that are not valid tokens, which Token would silently skip.
+func (f *TokenFile) Balance() (tokens int, sats uint64, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	data, err := os.ReadFile(f.path)
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to read token file: %w", err)
+	}
+	for i, line := range strings.Split(string(data), "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		token, err := cashu.Decode(strings.TrimSpace(line))
+		if err != nil {
+			return 0, 0, fmt.Errorf("line %d is not a valid token: %w", i+1, err)
+		}
+		amount, err := token.Amount()
+		if err != nil {
+			return 0, 0, fmt.Errorf("line %d is not a valid token: %w", i+1, err)
+		}
+		tokens++
+		sats += amount
+	}
+	return tokens, sats, nil
+}
+
+// cashuRenoters returns the descriptors of Renoters that admit layers with Cashu tokens.
+func cashuRenoters(descriptors map[string]*descriptor.Descriptor) map[string]*descriptor.Descriptor {
+	accepting := make(map[string]*descriptor.Descriptor)
+	for pubkey, d := range descriptors {
+		if slices.Contains(d.Admission, descriptor.AdmissionCashu) {
+			accepting[pubkey] = d
+		}
+	}
+	return accepting
+}
+
+// collectCashuTokens takes a token from opts.Cashu for every Cashu-admitting Renoter in
+// recipients and returns the cashu tags by pubkey. Renoters that also admit PoW fall back to it
+// when the wallet has no suitable token.
+func collectCashuTokens(ctx context.Context, recipients []string, opts Options) (map[string]nostr.Tag, error) {
+	if opts.Cashu == nil || len(opts.CashuRenoters) == 0 {
+		return nil, nil
+	}
+	tokens := make(map[string]nostr.Tag)
+	for _, pubkey := range recipients {
+		renoter, ok := opts.CashuRenoters[pubkey]
+		if !ok {
+			continue
+		}
+		token, err := opts.Cashu.Token(ctx, renoter)
+		if err != nil {
+			if slices.Contains(renoter.Admission, descriptor.AdmissionPoW) {
+				logging.Warn("client.cashu.collectCashuTokens: no token for renoter %s, mining PoW instead: %v", pubkey[:16], err)
+				continue
+			}
+			return nil, fmt.Errorf("no cashu token for renoter %s: %w", pubkey[:16], err)
+		}
+		tokens[pubkey] = nostr.Tag{cashuTag, token}
+	}
+	return tokens, nil
+}
@@ -0,0 +1,142 @@
+package client
+
+import (
+	"context"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/girino/renoter/internal/cashu"
+	"github.com/girino/renoter/internal/descriptor"
+	"github.com/girino/renoter/internal/sealtag"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip44"
+)
+
+func encodeTestToken(t *testing.T, mint string, amount uint64) string {
+	t.Helper()
+	token := &cashu.Token{Token: []cashu.TokenEntry{{Mint: mint, Proofs: []cashu.Proof{{Amount: amount, ID: "00", Secret: "s", C: "02"}}}}, Unit: cashu.UnitSat}
+	encoded, err := token.Encode()
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	return encoded
+}
+
+func TestTokenFile_Token(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.txt")
+	lines := []string{
+		encodeTestToken(t, "https://mint.example.com", 8),
+		encodeTestToken(t, "https://other.example.com", 2),
+		encodeTestToken(t, "https://mint.example.com", 2),
+		encodeTestToken(t, "https://mint.example.com", 1),
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	wallet, err := NewTokenFile(path)
+	if err != nil {
+		t.Fatalf("NewTokenFile() error = %v", err)
+	}
+	renoter := &descriptor.Descriptor{CashuMints: []string{"https://mint.example.com"}, CashuAmount: 2}
+
+	// The smallest sufficient token from an accepted mint is used first, then the next one
+	for _, want := range []string{lines[2], lines[0]} {
+		got, err := wallet.Token(context.Background(), renoter)
+		if err != nil {
+			t.Fatalf("Token() error = %v", err)
+		}
+		if got != want {
+			t.Errorf("Token() = %s, want %s", got, want)
+		}
+	}
+	if _, err := wallet.Token(context.Background(), renoter); err == nil {
+		t.Error("Token() should fail once no suitable token is left")
+	}
+
+	data, _ := os.ReadFile(path)
+	if remaining := strings.Split(strings.TrimSpace(string(data)), "\n"); len(remaining) != 2 {
+		t.Errorf("token file has %d tokens left, want 2", len(remaining))
+	}
+	if tokens, sats, err := wallet.Balance(); err != nil || tokens != 2 || sats != 3 {
+		t.Errorf("Balance() = %d tokens, %d sats, %v; want 2 tokens, 3 sats", tokens, sats, err)
+	}
+	os.WriteFile(path, append(data, "not a token\n"...), 0o600)
+	if _, _, err := wallet.Balance(); err == nil {
+		t.Error("Balance() should fail on an invalid line")
+	}
+
+	if _, err := NewTokenFile(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("NewTokenFile() should fail for a missing file")
+	}
+}
+
+// countingMiner returns a dummy nonce and counts how many layers it mined.
+type countingMiner struct {
+	mined int
+}
+
+func (m *countingMiner) Mine(ctx context.Context, event nostr.Event, difficulty int) (nostr.Tag, error) {
+	m.mined++
+	return nostr.Tag{"nonce", "0", "1"}, nil
+}
+
+func TestWrapLayers_CashuTokenReplacesPoW(t *testing.T) {
+	cashuSk := nostr.GeneratePrivateKey()
+	cashuPk := mustPublicKey(t, cashuSk)
+	powPk := mustPublicKey(t, nostr.GeneratePrivateKey())
+	cashuBytes, _ := hex.DecodeString(cashuPk)
+	powBytes, _ := hex.DecodeString(powPk)
+
+	path := filepath.Join(t.TempDir(), "tokens.txt")
+	token := encodeTestToken(t, "https://mint.example.com", 2)
+	os.WriteFile(path, []byte(token+"\n"), 0o600)
+	wallet, _ := NewTokenFile(path)
+
+	opts := DefaultOptions()
+	opts.Cashu = wallet
+	opts.CashuRenoters = map[string]*descriptor.Descriptor{
+		cashuPk: {PubKey: cashuPk, Admission: []string{descriptor.AdmissionCashu}, CashuMints: []string{"https://mint.example.com"}, CashuAmount: 2},
+	}
+	tokens, err := collectCashuTokens(context.Background(), []string{cashuPk, powPk}, opts)
+	if err != nil {
+		t.Fatalf("collectCashuTokens() error = %v", err)
+	}
+	if len(tokens) != 1 || tokens[cashuPk] == nil {
+		t.Fatalf("collectCashuTokens() = %v, want a token for the Cashu Renoter only", tokens)
+	}
+
+	event := &nostr.Event{Kind: 1, Content: "cashu", CreatedAt: nostr.Now(), Tags: nostr.Tags{}}
+	event.Sign(nostr.GeneratePrivateKey())
+
+	miner := &countingMiner{}
+	outermost, _, err := wrapLayers(context.Background(), event, NewPath(cashuBytes, powBytes), 1, miner, sealedLayerTags(tokens), false, nil, 0)
+	if err != nil {
+		t.Fatalf("wrapLayers() error = %v", err)
+	}
+	if miner.mined != 1 {
+		t.Errorf("mined %d layers, want only the PoW Renoter's", miner.mined)
+	}
+	if outermost.Tags.Find("nonce") != nil {
+		t.Error("Cashu Renoter's layer should not be mined")
+	}
+	tag := outermost.Tags.Find(cashuTag)
+	if tag == nil {
+		t.Fatalf("outermost layer tags = %v, want a cashu tag", outermost.Tags)
+	}
+	conversationKey, _ := nip44.GenerateConversationKey(outermost.PubKey, cashuSk)
+	if values, err := sealtag.Open(tag[1], conversationKey); err != nil || values[0] != token {
+		t.Errorf("sealtag.Open() = %v, %v, want the token", values, err)
+	}
+
+	// A Cashu-only Renoter cannot be used once the wallet is empty
+	if _, err := collectCashuTokens(context.Background(), []string{cashuPk}, opts); err == nil {
+		t.Error("collectCashuTokens() should fail without tokens for a Cashu-only Renoter")
+	}
+	opts.CashuRenoters[cashuPk].Admission = []string{descriptor.AdmissionCashu, descriptor.AdmissionPoW}
+	if tokens, err := collectCashuTokens(context.Background(), []string{cashuPk}, opts); err != nil || len(tokens) != 0 {
+		t.Errorf("collectCashuTokens() = %v, %v, want a PoW fallback", tokens, err)
+	}
+}
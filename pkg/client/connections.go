@@ -0,0 +1,205 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fiatjaf/khatru"
+	"github.com/girino/renoter/internal/config"
+)
+
+// Connections tracks the local websocket connections submitting events, with per-connection
+// statistics and rate limits so one misbehaving app cannot exhaust the mining capacity.
+// It is safe for concurrent use; pass it via Options.Connections. A nil *Connections, or a nil
+// websocket (events not submitted over a connection), disables tracking.
+type Connections struct {
+	// Events per minute a connection may submit (0 = unlimited)
+	eventsPerMinute int
+	// Events of a connection that may be queued or mining at once (0 = unlimited)
+	maxPending int
+
+	mu     sync.Mutex
+	nextID int64
+	conns  map[*khatru.WebSocket]*connectionState
+}
+
+// connectionState is the accounting of one open connection.
+type connectionState struct {
+	ConnectionSnapshot
+	// Token bucket for eventsPerMinute
+	tokens     float64
+	lastRefill time.Time
+}
+
+// ConnectionSnapshot is a point-in-time copy of one connection's statistics.
+type ConnectionSnapshot struct {
+	// Sequential connection number
+	ID int64 `json:"id"`
+	// Address of the local app
+	RemoteAddr string `json:"remote_addr"`
+	// When the connection was opened
+	ConnectedAt time.Time `json:"connected_at"`
+	// Events received from the connection
+	Submitted int64 `json:"submitted"`
+	// Events wrapped and published to at least one relay
+	Wrapped int64 `json:"wrapped"`
+	// Events rejected up front (size, rate limit, full queue)
+	Rejected int64 `json:"rejected"`
+	// Events accepted but not dispatched (wrapping or publishing failed)
+	Failed int64 `json:"failed"`
+	// Serialized size of the events received
+	Bytes int64 `json:"bytes"`
+	// Events queued or mining right now
+	Pending int `json:"pending"`
+}
+
+// NewConnections creates a tracker limiting each connection to eventsPerMinute submissions and
+// maxPending events in flight; 0 disables a limit.
+func NewConnections(eventsPerMinute, maxPending int) (*Connections, error) {
+	if eventsPerMinute < 0 || maxPending < 0 {
+		return nil, fmt.Errorf("connection limits must not be negative")
+	}
+	return &Connections{eventsPerMinute: eventsPerMinute, maxPending: maxPending, conns: make(map[*khatru.WebSocket]*connectionState)}, nil
+}
+
+// open starts tracking a connection.
+func (c *Connections) open(ws *khatru.WebSocket, remoteAddr string, now time.Time) {
+	if c == nil || ws == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.nextID++
+	c.conns[ws] = &connectionState{
+		ConnectionSnapshot: ConnectionSnapshot{ID: c.nextID, RemoteAddr: remoteAddr, ConnectedAt: now},
+		tokens:             float64(c.eventsPerMinute),
+		lastRefill:         now,
+	}
+}
+
+// close stops tracking a connection. Its pending events still finish, but are no longer counted.
+func (c *Connections) close(ws *khatru.WebSocket) {
+	if c == nil || ws == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.conns, ws)
+}
+
+// stateLocked returns the state of ws, tracking it on first use if OnConnect was missed.
+func (c *Connections) stateLocked(ws *khatru.WebSocket, now time.Time) *connectionState {
+	state, ok := c.conns[ws]
+	if !ok {
+		c.nextID++
+		state = &connectionState{ConnectionSnapshot: ConnectionSnapshot{ID: c.nextID, ConnectedAt: now}, tokens: float64(c.eventsPerMinute), lastRefill: now}
+		c.conns[ws] = state
+	}
+	return state
+}
+
+// submitted accounts an event of size bytes received from ws.
+func (c *Connections) submitted(ws *khatru.WebSocket, size int, now time.Time) {
+	if c == nil || ws == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state := c.stateLocked(ws, now)
+	state.Submitted++
+	state.Bytes += int64(size)
+}
+
+// rejected accounts an event from ws rejected before it was queued.
+func (c *Connections) rejected(ws *khatru.WebSocket) {
+	if c == nil || ws == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if state, ok := c.conns[ws]; ok {
+		state.Rejected++
+	}
+}
+
+// admit reserves a pending slot for an event from ws, or returns why the connection is over
+// its limits as a *config.Rejection (the event then counts as rejected).
+func (c *Connections) admit(ws *khatru.WebSocket, now time.Time) error {
+	if c == nil || ws == nil {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	state := c.stateLocked(ws, now)
+
+	if c.maxPending > 0 && state.Pending >= c.maxPending {
+		state.Rejected++
+		return config.NewRejection(config.RejectConnectionPending, strconv.Itoa(c.maxPending), fmt.Sprintf("connection has %d events pending", state.Pending))
+	}
+	if c.eventsPerMinute > 0 {
+		rate := float64(c.eventsPerMinute)
+		state.tokens = min(rate, state.tokens+now.Sub(state.lastRefill).Minutes()*rate)
+		state.lastRefill = now
+		if state.tokens < 1 {
+			state.Rejected++
+			return config.NewRejection(config.RejectConnectionRate, strconv.Itoa(c.eventsPerMinute), fmt.Sprintf("connection exceeded %d events per minute", c.eventsPerMinute))
+		}
+		state.tokens--
+	}
+	state.Pending++
+	return nil
+}
+
+// unqueued releases the slot of an admitted event that could not be queued.
+func (c *Connections) unqueued(ws *khatru.WebSocket) {
+	c.finish(ws, func(state *connectionState) { state.Rejected++ })
+}
+
+// dispatched releases the slot of an admitted event once it was dispatched (or failed).
+func (c *Connections) dispatched(ws *khatru.WebSocket, ok bool) {
+	c.finish(ws, func(state *connectionState) {
+		if ok {
+			state.Wrapped++
+		} else {
+			state.Failed++
+		}
+	})
+}
+
+func (c *Connections) finish(ws *khatru.WebSocket, account func(*connectionState)) {
+	if c == nil || ws == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if state, ok := c.conns[ws]; ok {
+		state.Pending--
+		account(state)
+	}
+}
+
+// Snapshot returns the statistics of every open connection, oldest first.
+func (c *Connections) Snapshot() []ConnectionSnapshot {
+	if c == nil {
+		return nil
+	}
+	c.mu.Lock()
+	snapshots := make([]ConnectionSnapshot, 0, len(c.conns))
+	for _, state := range c.conns {
+		snapshots = append(snapshots, state.ConnectionSnapshot)
+	}
+	c.mu.Unlock()
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].ID < snapshots[j].ID })
+	return snapshots
+}
+
+// ServeHTTP serves the open connections as JSON, for use as a status endpoint.
+func (c *Connections) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(c.Snapshot())
+}
@@ -0,0 +1,115 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fiatjaf/khatru"
+	"github.com/girino/renoter/internal/config"
+)
+
+func TestNewConnections(t *testing.T) {
+	if _, err := NewConnections(-1, 0); err == nil {
+		t.Error("NewConnections() should reject a negative rate")
+	}
+	if _, err := NewConnections(0, -1); err == nil {
+		t.Error("NewConnections() should reject a negative pending limit")
+	}
+}
+
+func TestConnections_Accounting(t *testing.T) {
+	c, _ := NewConnections(0, 0)
+	now := time.Now()
+	app, other := &khatru.WebSocket{}, &khatru.WebSocket{}
+	c.open(app, "127.0.0.1", now)
+	c.open(other, "127.0.0.2", now)
+
+	// One event dispatched, one failed, one rejected up front, one not queued
+	for i := 0; i < 3; i++ {
+		c.submitted(app, 100, now)
+		if err := c.admit(app, now); err != nil {
+			t.Fatalf("admit() error = %v", err)
+		}
+	}
+	c.dispatched(app, true)
+	c.dispatched(app, false)
+	c.unqueued(app)
+	c.submitted(app, 50, now)
+	c.rejected(app)
+
+	snapshots := c.Snapshot()
+	if len(snapshots) != 2 || snapshots[0].RemoteAddr != "127.0.0.1" {
+		t.Fatalf("Snapshot() = %+v, want both connections, oldest first", snapshots)
+	}
+	got := snapshots[0]
+	if got.Submitted != 4 || got.Bytes != 350 || got.Wrapped != 1 || got.Failed != 1 || got.Rejected != 2 || got.Pending != 0 {
+		t.Errorf("Snapshot()[0] = %+v", got)
+	}
+	if snapshots[1].Submitted != 0 {
+		t.Errorf("Snapshot()[1].Submitted = %d, want 0", snapshots[1].Submitted)
+	}
+
+	c.close(app)
+	if snapshots := c.Snapshot(); len(snapshots) != 1 {
+		t.Errorf("Snapshot() after close = %+v, want one connection", snapshots)
+	}
+	// Events finishing after the connection closed are ignored
+	c.dispatched(app, true)
+
+	recorder := httptest.NewRecorder()
+	c.ServeHTTP(recorder, httptest.NewRequest("GET", "/connections", nil))
+	var served []ConnectionSnapshot
+	if err := json.Unmarshal(recorder.Body.Bytes(), &served); err != nil || len(served) != 1 {
+		t.Errorf("ServeHTTP() = %s, %v", recorder.Body.String(), err)
+	}
+}
+
+func TestConnections_Limits(t *testing.T) {
+	c, _ := NewConnections(2, 0)
+	now := time.Now()
+	app := &khatru.WebSocket{}
+	c.open(app, "127.0.0.1", now)
+
+	for i := 0; i < 2; i++ {
+		if err := c.admit(app, now); err != nil {
+			t.Fatalf("admit() error = %v", err)
+		}
+	}
+	err := c.admit(app, now)
+	if rejection, ok := err.(*config.Rejection); !ok || rejection.Reason != config.RejectConnectionRate || rejection.Param != "2" {
+		t.Fatalf("admit() over the rate error = %v, want a %s rejection", err, config.RejectConnectionRate)
+	}
+	// The bucket refills at the configured rate
+	if err := c.admit(app, now.Add(30*time.Second)); err != nil {
+		t.Errorf("admit() after refill error = %v", err)
+	}
+
+	c, _ = NewConnections(0, 1)
+	c.open(app, "127.0.0.1", now)
+	if err := c.admit(app, now); err != nil {
+		t.Fatalf("admit() error = %v", err)
+	}
+	if err := c.admit(app, now); !strings.HasPrefix(fmt.Sprint(err), "rate-limited: connection-pending:1 ") {
+		t.Fatalf("admit() over the pending limit error = %v", err)
+	}
+	c.dispatched(app, true)
+	if err := c.admit(app, now); err != nil {
+		t.Errorf("admit() after a dispatch error = %v", err)
+	}
+	if got := c.Snapshot()[0].Rejected; got != 1 {
+		t.Errorf("Rejected = %d, want 1", got)
+	}
+
+	// Nil trackers and events from outside a connection are never limited
+	var disabled *Connections
+	if err := disabled.admit(app, now); err != nil || disabled.Snapshot() != nil {
+		t.Error("a nil Connections should be a no-op")
+	}
+	if err := c.admit(nil, now); err != nil {
+		t.Errorf("admit(nil) error = %v", err)
+	}
+}
@@ -0,0 +1,112 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/girino/renoter/internal/config"
+	"github.com/girino/renoter/internal/descriptor"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+// descriptorFetchTimeout bounds the lookup of service descriptors at startup.
+const descriptorFetchTimeout = 10 * time.Second
+
+// FetchDescriptors looks up the newest valid service descriptor of each pubkey on relayURLs.
+// Pubkeys without a (valid) descriptor are absent from the result.
+func FetchDescriptors(ctx context.Context, relayURLs []string, pubkeys []string) map[string]*descriptor.Descriptor {
+	return fetchDescriptors(ctx, nil, relayURLs, pubkeys)
+}
+
+// fetchDescriptors is FetchDescriptors through pool, or a pool of its own if nil.
+func fetchDescriptors(ctx context.Context, pool Pool, relayURLs []string, pubkeys []string) map[string]*descriptor.Descriptor {
+	ctx, cancel := context.WithTimeout(ctx, descriptorFetchTimeout)
+	defer cancel()
+
+	filter := nostr.Filter{
+		Kinds:   []int{config.ServiceDescriptorKind},
+		Authors: pubkeys,
+		Tags:    nostr.TagMap{"d": []string{config.ServiceDescriptorTag}},
+	}
+
+	if pool == nil {
+		pool = nostr.NewSimplePool(ctx)
+	}
+	newest := make(map[string]nostr.Timestamp)
+	descriptors := make(map[string]*descriptor.Descriptor)
+	for relayEvent := range pool.FetchMany(ctx, relayURLs, filter) {
+		event := relayEvent.Event
+		if event.CreatedAt <= newest[event.PubKey] {
+			continue
+		}
+		d, err := descriptor.Parse(event)
+		if err != nil {
+			logging.Warn("client.descriptor.FetchDescriptors: ignoring invalid descriptor %s: %v", event.ID, err)
+			continue
+		}
+		newest[event.PubKey] = event.CreatedAt
+		descriptors[event.PubKey] = d
+	}
+
+	logging.DebugMethod("client.descriptor", "FetchDescriptors", "Found descriptors for %d/%d Renoters", len(descriptors), len(pubkeys))
+	return descriptors
+}
+
+// CheckPathDescriptors fetches the service descriptors of every Renoter in renterPath from relayURLs
+// and the nodes' own relays and returns them, or an error if any Renoter is incompatible with opts.
+// Renoters without a descriptor are only an error when opts.RequireDescriptors is set.
+func CheckPathDescriptors(ctx context.Context, renterPath Path, relayURLs []string, opts Options) (map[string]*descriptor.Descriptor, error) {
+	pubkeys := renterPath.Keys()
+	lookupRelays := slices.Clone(relayURLs)
+	for _, relay := range renterPath.RelayHints() {
+		if !slices.Contains(lookupRelays, relay) {
+			lookupRelays = append(lookupRelays, relay)
+		}
+	}
+	descriptors := fetchDescriptors(ctx, opts.ServerPool, lookupRelays, pubkeys)
+	if err := checkPathDescriptors(pubkeys, descriptors, opts); err != nil {
+		return nil, err
+	}
+	return descriptors, nil
+}
+
+// paidRenoters returns the descriptors of Renoters that charge a fee.
+func paidRenoters(descriptors map[string]*descriptor.Descriptor) map[string]*descriptor.Descriptor {
+	paid := make(map[string]*descriptor.Descriptor)
+	for pubkey, d := range descriptors {
+		if d.FeePolicy == descriptor.FeePolicyLightning {
+			paid[pubkey] = d
+		}
+	}
+	return paid
+}
+
+func checkPathDescriptors(pubkeys []string, descriptors map[string]*descriptor.Descriptor, opts Options) error {
+	for _, pubkey := range pubkeys {
+		npub, _ := nip19.EncodePublicKey(pubkey)
+		d, ok := descriptors[pubkey]
+		if !ok {
+			if opts.RequireDescriptors {
+				logging.Error("client.descriptor.CheckPathDescriptors: Renoter %s has no service descriptor", npub)
+				return fmt.Errorf("renoter %s has no service descriptor", npub)
+			}
+			logging.Warn("client.descriptor.CheckPathDescriptors: Renoter %s has no service descriptor, compatibility not checked", npub)
+			continue
+		}
+		if err := d.CheckCompatible(opts.Limits, config.PoWDifficulty, opts.Payer != nil, opts.Cashu != nil); err != nil {
+			logging.Error("client.descriptor.CheckPathDescriptors: Renoter %s is incompatible: %v", npub, err)
+			return fmt.Errorf("renoter %s is incompatible: %w", npub, err)
+		}
+		if opts.Lane == config.LaneMixed && d.MixingMax == 0 {
+			logging.Warn("client.descriptor.CheckPathDescriptors: Renoter %s does not hold mixed-lane events, it forwards them immediately", npub)
+		} else if opts.Lane == config.LaneMixed && d.MixingMax < opts.MixingDelay {
+			logging.Warn("client.descriptor.CheckPathDescriptors: Renoter %s holds events for at most %v, less than the %v mixing delay requested", npub, d.MixingMax, opts.MixingDelay)
+		}
+		logging.DebugMethod("client.descriptor", "CheckPathDescriptors", "Renoter %s is compatible (size %d, PoW %d, contact %q)", npub, d.StandardizedSize, d.PoWDifficulty, d.Contact)
+	}
+	return nil
+}
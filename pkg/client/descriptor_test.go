@@ -0,0 +1,43 @@
+package client
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/girino/renoter/internal/config"
+	"github.com/girino/renoter/internal/descriptor"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestCheckPathDescriptors(t *testing.T) {
+	compatible := mustPublicKey(t, nostr.GeneratePrivateKey())
+	incompatible := mustPublicKey(t, nostr.GeneratePrivateKey())
+	unannounced := mustPublicKey(t, nostr.GeneratePrivateKey())
+
+	newDescriptor := func(pubkey string, size int) *descriptor.Descriptor {
+		return &descriptor.Descriptor{
+			PubKey:           pubkey,
+			Kinds:            []int{config.WrapperEventKind, config.StandardizedWrapperKind},
+			StandardizedSize: size,
+			PoWDifficulty:    config.PoWDifficulty,
+			FeePolicy:        descriptor.FeePolicyFree,
+		}
+	}
+	descriptors := map[string]*descriptor.Descriptor{
+		compatible:   newDescriptor(compatible, config.StandardizedSize),
+		incompatible: newDescriptor(incompatible, 16384),
+	}
+
+	opts := DefaultOptions()
+	if err := checkPathDescriptors([]string{compatible, unannounced}, descriptors, opts); err != nil {
+		t.Errorf("checkPathDescriptors() error = %v, want unannounced Renoters tolerated", err)
+	}
+	if err := checkPathDescriptors([]string{compatible, incompatible}, descriptors, opts); err == nil || !strings.Contains(err.Error(), "standardized size") {
+		t.Errorf("checkPathDescriptors() error = %v, want standardized size mismatch", err)
+	}
+
+	opts.RequireDescriptors = true
+	if err := checkPathDescriptors([]string{compatible, unannounced}, descriptors, opts); err == nil || !strings.Contains(err.Error(), "no service descriptor") {
+		t.Errorf("checkPathDescriptors() error = %v, want missing descriptor", err)
+	}
+}
@@ -0,0 +1,554 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/girino/renoter/internal/config"
+	"github.com/girino/renoter/internal/padding"
+	"github.com/girino/renoter/internal/relaypool"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Notifier receives a status message once a submitted event has been dispatched or has failed.
+// The relay uses it to send a NOTICE to the submitting client. Failures are formatted as a
+// config.Rejection (e.g. "error: mining-timeout:1m0s ...").
+type Notifier func(msg string)
+
+// dispatchJob is one accepted event waiting to be wrapped and published.
+type dispatchJob struct {
+	event  *nostr.Event
+	notify Notifier
+	// Delivery lane chosen for the event ("" = Options.Lane)
+	lane string
+	// When the event was accepted, for the journal
+	submittedAt time.Time
+	// Called with whether the event was dispatched, before notify (may be nil)
+	done func(dispatched bool)
+	// Called with the journal entry of the outcome, before done (may be nil)
+	report func(entry JournalEntry)
+	// ID of the job tracking the event, if submitted with SubmitEvent
+	jobID string
+
+	// Resends of the event so far (0 for the first dispatch)
+	attempt int
+	// Hash of the path the event was wrapped for, and of a path to avoid when resending
+	pathHash, avoid string
+	// Every relay refused the last container for a reason resending cannot fix
+	refused bool
+}
+
+// Dispatcher wraps and publishes accepted events on a pool of background workers, so that
+// PoW mining never blocks the websocket of the submitting client.
+type Dispatcher struct {
+	renterPath      Path
+	serverPool      Pool
+	serverRelayURLs []string
+	opts            Options
+
+	jobs chan dispatchJob
+	// NIP-11 compatibility of the server relays, checked by StartDispatcher (nil if not checked)
+	relays []RelayCompatibility
+	// Wrapped events held for the next time slice (nil publishes immediately)
+	batch *batch
+	// Reports whether an event reached the server relays, for resends
+	delivered func(ctx context.Context, eventID string) bool
+	// Liveness of the configured Renoters (nil = every Renoter is taken for online)
+	health *PathHealth
+	// Subscribes to timing trailers when opts.ReportLatency is set
+	observer Pool
+	// Progress of the events submitted with SubmitEvent
+	tracker *Jobs
+	// Server relay connections opened by StartDispatcher, closed by Close (nil = none)
+	publisher *relaypool.Publisher
+	// Workers wrapping an event, see idle
+	busy atomic.Int32
+	// Cancels the context of the workers, see Close
+	stop context.CancelFunc
+	wg   sync.WaitGroup
+}
+
+// NewDispatcher creates a Dispatcher and starts opts.MiningWorkers workers that run until ctx is done.
+func NewDispatcher(ctx context.Context, renterPath Path, serverPool Pool, serverRelayURLs []string, opts Options) (*Dispatcher, error) {
+	if err := opts.Validate(); err != nil {
+		logging.Error("client.dispatcher.NewDispatcher: invalid options: %v", err)
+		return nil, fmt.Errorf("invalid options: %w", err)
+	}
+
+	ctx, stop := context.WithCancel(ctx)
+	d := &Dispatcher{
+		renterPath:      renterPath,
+		serverPool:      serverPool,
+		serverRelayURLs: serverRelayURLs,
+		opts:            opts,
+		jobs:            make(chan dispatchJob, opts.MiningQueueSize),
+		tracker:         newJobs(),
+		stop:            stop,
+	}
+	d.delivered = func(ctx context.Context, eventID string) bool {
+		return delivered(ctx, d.opts.ServerPool, d.serverRelayURLs, eventID)
+	}
+
+	for i := 0; i < opts.MiningWorkers; i++ {
+		d.wg.Add(1)
+		go d.worker(ctx)
+	}
+	if opts.ReportLatency {
+		d.observer = opts.ServerPool
+		if d.observer == nil {
+			d.observer = nostr.NewSimplePool(ctx)
+		}
+	}
+	if opts.HealthInterval > 0 {
+		d.health = NewPathHealth(renterPath)
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			d.health.Monitor(ctx, opts.ServerPool, serverRelayURLs, renterPath, opts.HealthInterval)
+		}()
+		logging.Info("client.dispatcher.NewDispatcher: Checking Renoter liveness every %v", opts.HealthInterval)
+	}
+	if opts.QueueSpill != nil {
+		if err := d.restoreSpilled(); err != nil {
+			logging.Warn("client.dispatcher.NewDispatcher: failed to restore spilled events: %v", err)
+		}
+		d.wg.Add(1)
+		go d.feedSpilled(ctx)
+	}
+	if opts.Premine > 0 {
+		if recipients := stampRecipients(renterPath, opts); len(recipients) > 0 {
+			d.opts.stamps = newStampPool(opts.Miner, config.PoWDifficulty, opts.Premine, recipients)
+			d.wg.Add(1)
+			go d.premine(ctx)
+			logging.Info("client.dispatcher.NewDispatcher: Pre-mining %d stamps for each of %d Renoters while idle", opts.Premine, len(recipients))
+		} else {
+			logging.Warn("client.dispatcher.NewDispatcher: no Renoter announces protocol version %d, mining every layer when wrapping", config.StampedPoWVersion)
+		}
+	}
+	if opts.BatchInterval > 0 {
+		d.batch = &batch{interval: opts.BatchInterval}
+		d.wg.Add(1)
+		go d.runBatches(ctx)
+		logging.Info("client.dispatcher.NewDispatcher: Publishing wrapped events in shuffled batches every %v", opts.BatchInterval)
+	}
+
+	logging.Info("client.dispatcher.NewDispatcher: Started %d mining workers (queue size %d, timeout %v)", opts.MiningWorkers, opts.MiningQueueSize, opts.MiningTimeout)
+	return d, nil
+}
+
+// Submit transforms and validates an event and queues it for wrapping and publishing without blocking.
+// A config.LaneTag on the event picks its lane and is removed first, so the transforms must sign
+// it again. Returns a *config.Rejection if the event is invalid or the queue is full; notify may be nil.
+func (d *Dispatcher) Submit(event *nostr.Event, notify Notifier) error {
+	event, lane, err := eventLane(event, "")
+	if err != nil {
+		return err
+	}
+	event, err = transformEvent(event, d.opts.Transforms)
+	if err != nil {
+		return err
+	}
+	if err := ValidateEvent(event, d.opts.Validation, time.Now()); err != nil {
+		return err
+	}
+	return d.submit(event, notify, lane, nil)
+}
+
+// Publish transforms and validates an event and its size, queues it like Submit and waits until it has been dispatched or has
+// failed. It returns the journal entry of the outcome (wrapped event ID, hops and the result on
+// each server relay), with a *config.Rejection if the event was rejected or failed.
+func (d *Dispatcher) Publish(ctx context.Context, event *nostr.Event) (JournalEntry, error) {
+	event, lane, err := eventLane(event, "")
+	if err != nil {
+		return JournalEntry{}, err
+	}
+	event, err = transformEvent(event, d.opts.Transforms)
+	if err != nil {
+		return JournalEntry{}, err
+	}
+	if err := ValidateEvent(event, d.opts.Validation, time.Now()); err != nil {
+		return JournalEntry{}, err
+	}
+	if err := d.checkSize(event, lane); err != nil {
+		return JournalEntry{}, err
+	}
+	reported := make(chan JournalEntry, 1)
+	job := dispatchJob{event: event, lane: lane, submittedAt: time.Now(), report: func(entry JournalEntry) {
+		select {
+		case reported <- entry:
+		default:
+		}
+	}}
+	if err := d.enqueue(job); err != nil {
+		return JournalEntry{}, err
+	}
+
+	select {
+	case entry := <-reported:
+		if entry.Error == "" {
+			return entry, nil
+		}
+		if rejection, ok := config.ParseRejection(entry.Error); ok {
+			return entry, rejection
+		}
+		return entry, fmt.Errorf("%s", entry.Error)
+	case <-ctx.Done():
+		return JournalEntry{}, ctx.Err()
+	}
+}
+
+// SubmitEvent transforms and validates an event and its size and queues it like Submit, returning at once the
+// ID of a job that follows it through mining and publishing (see Jobs). Returns a
+// *config.Rejection if the event is invalid or the queue is full.
+func (d *Dispatcher) SubmitEvent(event *nostr.Event) (string, error) {
+	event, lane, err := eventLane(event, "")
+	if err != nil {
+		return "", err
+	}
+	event, err = transformEvent(event, d.opts.Transforms)
+	if err != nil {
+		return "", err
+	}
+	if err := ValidateEvent(event, d.opts.Validation, time.Now()); err != nil {
+		return "", err
+	}
+	if err := d.checkSize(event, lane); err != nil {
+		return "", err
+	}
+	id := d.tracker.create(event)
+	if err := d.enqueue(dispatchJob{event: event, lane: lane, submittedAt: time.Now(), jobID: id}); err != nil {
+		d.tracker.remove(id)
+		return "", err
+	}
+	return id, nil
+}
+
+// Jobs returns the progress of the events submitted with SubmitEvent.
+func (d *Dispatcher) Jobs() *Jobs {
+	return d.tracker
+}
+
+// RelayCompatibility returns the NIP-11 compatibility of the server relays checked at startup,
+// in the order of the server relay list (nil unless Options.RelayCheck asked for the check).
+func (d *Dispatcher) RelayCompatibility() []RelayCompatibility {
+	return d.relays
+}
+
+// checkSize refuses oversized events for the lane ("" = Options.Lane) before any mining, as the
+// relay does.
+func (d *Dispatcher) checkSize(event *nostr.Event, lane string) error {
+	return d.opts.sizeBudget(d.opts.PathPolicy.PathLength(len(d.renterPath)), lane).CheckEvent(event)
+}
+
+// submit is Submit in the given lane ("" = Options.Lane) with a callback reporting the outcome,
+// used for per-connection lanes and accounting.
+func (d *Dispatcher) submit(event *nostr.Event, notify Notifier, lane string, done func(dispatched bool)) error {
+	return d.enqueue(dispatchJob{event: event, notify: notify, lane: lane, done: done, submittedAt: time.Now()})
+}
+
+// enqueue queues a job without blocking. If the queue is full, the job is held by the queue
+// spill, if any has room, and rejected otherwise. Jobs are held in order, so while some are, new
+// ones wait behind them.
+func (d *Dispatcher) enqueue(job dispatchJob) error {
+	event := job.event
+	spill := d.opts.QueueSpill
+	if spill != nil {
+		if err := spill.save(event); err != nil {
+			logging.Warn("client.dispatcher.Submit: event %s is queued in memory only: %v", event.ID, err)
+		}
+	}
+	if !spill.holding() {
+		select {
+		case d.jobs <- job:
+			d.opts.Status.setQueue(len(d.jobs), cap(d.jobs))
+			logging.DebugMethod("client.dispatcher", "Submit", "Queued event %s (%d/%d queued)", event.ID, len(d.jobs), cap(d.jobs))
+			return nil
+		default:
+		}
+	}
+	if spill != nil {
+		if spill.hold(job) {
+			logging.DebugMethod("client.dispatcher", "Submit", "Mining queue full, holding event %s until it drains", event.ID)
+			return nil
+		}
+		spill.remove(event.ID)
+	}
+	logging.Warn("client.dispatcher.Submit: mining queue full, rejecting event %s", event.ID)
+	return config.NewRejection(config.RejectQueueFull, strconv.Itoa(cap(d.jobs)), fmt.Sprintf("mining queue is full (%d events pending)", cap(d.jobs)))
+}
+
+// Wait blocks until all workers have exited after the dispatcher context is done.
+func (d *Dispatcher) Wait() {
+	d.wg.Wait()
+}
+
+// Close stops the dispatcher: it cancels the context of its workers, subscriptions and resends,
+// waits for them to exit until ctx is done, and then closes the relay connections it opened, so
+// the server relays see its subscriptions closed rather than dropped. Events still queued are
+// not dispatched.
+func (d *Dispatcher) Close(ctx context.Context) error {
+	logging.Info("client.dispatcher.Close: Closing dispatcher")
+	d.stop()
+
+	var err error
+	finished := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-ctx.Done():
+		err = fmt.Errorf("workers still running: %w", ctx.Err())
+		logging.Warn("client.dispatcher.Close: not every worker stopped: %v", err)
+	}
+
+	// The trailer observer is only the dispatcher's own when no server pool was configured
+	if observer, ok := d.observer.(*nostr.SimplePool); ok && d.opts.ServerPool == nil {
+		if closeErr := relaypool.CloseRelays(ctx, observer); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	if d.publisher != nil {
+		if closeErr := d.publisher.Close(ctx); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+func (d *Dispatcher) worker(ctx context.Context) {
+	defer d.wg.Done()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case job := <-d.jobs:
+			d.opts.Status.setQueue(len(d.jobs), cap(d.jobs))
+			d.tracker.setState(job.jobID, JobMining)
+			d.busy.Add(1)
+			wrapped, msg, ok := d.wrap(ctx, job)
+			d.busy.Add(-1)
+			if !ok {
+				d.finish(ctx, job, msg, false)
+			} else if d.batch != nil {
+				// Hold the container until the next time slice so publishing reveals nothing about submission time
+				d.batch.add(wrapped)
+			} else {
+				msg, ok := d.publish(ctx, wrapped)
+				d.finish(ctx, wrapped.job, msg, ok)
+			}
+		}
+	}
+}
+
+// finish reports the outcome of a job to its submitter and starts watching for its delivery
+// when resends are enabled.
+func (d *Dispatcher) finish(ctx context.Context, job dispatchJob, msg string, dispatched bool) {
+	if job.attempt == 0 {
+		d.opts.QueueSpill.remove(job.event.ID)
+	}
+	if d.resendable(job, dispatched) {
+		d.wg.Add(1)
+		go d.awaitDelivery(ctx, job)
+	}
+	if job.done != nil {
+		job.done(dispatched)
+	}
+	if job.notify != nil {
+		job.notify(msg)
+	}
+}
+
+func (d *Dispatcher) recordFailure() {
+	if d.opts.Stats != nil {
+		d.opts.Stats.recordFailure()
+	}
+}
+
+// record appends the outcome of a job to the journal, if one is configured, updates the status
+// socket and the job tracker and reports it to the submitter if asked to.
+func (d *Dispatcher) record(job dispatchJob, entry JournalEntry) {
+	entry.FinishedAt = time.Now()
+	relays := len(d.serverRelayURLs)
+	// A hedged publish is complete once the relays it tried answered, however many are configured
+	if d.opts.Hedge.Relays > 0 && len(entry.Results) > 0 {
+		relays = len(entry.Results)
+	}
+	d.opts.Status.recordOutcome(entry, relays)
+	d.tracker.finish(job.jobID, entry)
+	if job.report != nil {
+		job.report(entry)
+	}
+	if err := d.opts.Journal.Record(entry); err != nil {
+		logging.Warn("client.dispatcher.record: failed to journal event %s: %v", entry.EventID, err)
+	}
+}
+
+// wrappedJob is an event wrapped for a path and waiting to be published.
+type wrappedJob struct {
+	job     dispatchJob
+	wrapped *nostr.Event
+	path    Path
+	entry   JournalEntry
+	// Key timing trailers are sealed to (empty if none were asked for)
+	reportSk string
+}
+
+// wrap wraps the event of a job under the mining timeout. On failure it returns the status message.
+func (d *Dispatcher) wrap(ctx context.Context, job dispatchJob) (*wrappedJob, string, bool) {
+	event := job.event
+	// Draw a new random path for each event to randomize routing
+	// This improves privacy by ensuring events don't always follow the same path
+	candidates, avoided := d.healthyRenoters()
+	shuffledPath, err := SelectPath(candidates, d.opts.PathPolicy)
+	// A resend tries a few draws for a path other than the one that lost the event
+	for tries := 0; err == nil && job.avoid != "" && PathHash(shuffledPath) == job.avoid && tries < 3; tries++ {
+		shuffledPath, err = SelectPath(candidates, d.opts.PathPolicy)
+	}
+	if err != nil {
+		d.recordFailure()
+		msg := config.NewRejection(config.RejectPathUnsatisfiable, "", fmt.Sprintf("failed to dispatch event %s: %v", event.ID, err)).Error()
+		d.record(job, JournalEntry{EventID: event.ID, Attempt: job.attempt, SubmittedAt: job.submittedAt, Error: msg})
+		return nil, msg, false
+	}
+	job.pathHash = PathHash(shuffledPath)
+	entry := JournalEntry{EventID: event.ID, Attempt: job.attempt, Hops: len(shuffledPath), Avoided: avoided, SubmittedAt: job.submittedAt}
+	if d.opts.Journal != nil {
+		entry.PathHash = job.pathHash
+	}
+
+	opts := d.opts
+	if job.lane != "" {
+		opts.Lane = job.lane
+	}
+	var reportSk, reportPubkey string
+	if opts.ReportLatency {
+		reportSk = nostr.GeneratePrivateKey()
+		reportPubkey, _ = nostr.GetPublicKey(reportSk)
+	}
+	miningCtx, cancel := context.WithTimeout(ctx, d.opts.MiningTimeout)
+	wrappedEvent, _, err := wrapEvent(miningCtx, event, shuffledPath, WrapOptions{Options: opts}, reportPubkey)
+	timedOut := miningCtx.Err() == context.DeadlineExceeded
+	cancel()
+	if err != nil {
+		logging.Error("client.dispatcher.wrap: failed to wrap event %s: %v", event.ID, err)
+		d.recordFailure()
+		rejection := config.NewRejection(config.RejectWrapFailed, "", "")
+		if timedOut {
+			rejection = config.NewRejection(config.RejectMiningTimeout, d.opts.MiningTimeout.String(), "")
+		}
+		rejection.Message = fmt.Sprintf("failed to dispatch event %s: %v", event.ID, err)
+		entry.Error = rejection.Error()
+		d.record(job, entry)
+		return nil, rejection.Error(), false
+	}
+
+	logging.DebugMethod("client.dispatcher", "wrap", "Event %s wrapped as 29001 event %s", event.ID, wrappedEvent.ID)
+	entry.WrappedID = wrappedEvent.ID
+	d.tracker.setState(job.jobID, JobPublishing)
+	return &wrappedJob{job: job, wrapped: wrappedEvent, path: shuffledPath, entry: entry, reportSk: reportSk}, "", true
+}
+
+// healthyRenoters returns the configured Renoters not known to be offline, and the pubkeys of
+// those left out. When the healthy ones cannot satisfy the path policy, all of them are returned
+// and the event takes its chances rather than failing outright.
+func (d *Dispatcher) healthyRenoters() (Path, []string) {
+	if d.health == nil {
+		return d.renterPath, nil
+	}
+	healthy, avoided := d.health.healthy(d.renterPath, time.Now())
+	if len(avoided) == 0 {
+		return d.renterPath, nil
+	}
+	if err := d.opts.PathPolicy.Check(healthy); err != nil {
+		logging.Warn("client.dispatcher.healthyRenoters: %d Renoters are offline but the rest cannot form a path, using them anyway: %v", len(avoided), err)
+		return d.renterPath, nil
+	}
+	logging.DebugMethod("client.dispatcher", "healthyRenoters", "Routing around %d offline Renoters", len(avoided))
+	return healthy, avoided
+}
+
+// publish sends a wrapped event to the server relays (all of them, or a hedged subset), returning the status message and whether
+// at least one relay accepted it.
+func (d *Dispatcher) publish(ctx context.Context, w *wrappedJob) (string, bool) {
+	event, wrappedEvent, entry := w.job.event, w.wrapped, w.entry
+
+	// Listen for the timing trailers before the event can reach the exit
+	var trailers *trailerWatch
+	if w.reportSk != "" {
+		trailers = d.watchTrailers(ctx, w.reportSk)
+	}
+
+	// Publish wrapped event to the server relays through the capped publisher
+	successCount := 0
+	permanent := true
+	var categories []string
+	for result := range d.opts.Hedge.publish(ctx, d.serverPool, d.serverRelayURLs, *wrappedEvent) {
+		journalResult := JournalResult{Relay: result.RelayURL, OK: result.Error == nil}
+		if result.Error != nil {
+			reason := ParseRelayReason(result.Error)
+			journalResult.Error = result.Error.Error()
+			journalResult.Reason = reason.Category
+			if d.opts.Stats != nil {
+				d.opts.Stats.recordRefusal(reason.Category)
+			}
+			// A relay that already holds the container has it published all the same
+			journalResult.OK = reason.Category == RelayDuplicate
+			if !journalResult.OK {
+				logRefusal(wrappedEvent.ID, result.RelayURL, reason, d.opts.LogRelayReasons)
+				permanent = permanent && reason.Permanent()
+				if !slices.Contains(categories, reason.Category) {
+					categories = append(categories, reason.Category)
+				}
+			}
+		}
+		entry.Results = append(entry.Results, journalResult)
+		if journalResult.OK {
+			successCount++
+			logging.DebugMethod("client.dispatcher", "publish", "Successfully published wrapped event %s to relay %s", wrappedEvent.ID, result.RelayURL)
+		}
+	}
+
+	if successCount == 0 {
+		refusals := ""
+		if len(categories) > 0 {
+			refusals = " (" + strings.Join(categories, ", ") + ")"
+		}
+		logging.Error("client.dispatcher.publish: Failed to publish wrapped event %s to any relay%s", wrappedEvent.ID, refusals)
+		d.recordFailure()
+		trailers.stop()
+		// Resending over another path would only be refused again
+		w.job.refused = len(categories) > 0 && permanent
+		msg := config.NewRejection(config.RejectPathDown, "", fmt.Sprintf("failed to dispatch event %s: no relay accepted the wrapped event%s", event.ID, refusals)).Error()
+		entry.Error = msg
+		d.record(w.job, entry)
+		return msg, false
+	}
+
+	if d.opts.Stats != nil {
+		originalJSON, _ := padding.Marshal(event)
+		wrappedJSON, _ := padding.Marshal(wrappedEvent)
+		d.opts.Stats.recordDispatch(len(w.path), len(originalJSON), containerBucket(wrappedEvent, d.opts.Limits), len(wrappedJSON), successCount)
+	}
+
+	if trailers != nil {
+		lane := w.job.lane
+		if lane == "" {
+			lane = d.opts.Lane
+		}
+		d.wg.Add(1)
+		go d.awaitTrailers(ctx, trailers, event.ID, w.path, lane)
+	}
+
+	d.record(w.job, entry)
+	logging.Info("client.dispatcher.publish: Dispatched event %s to %d/%d relays", event.ID, successCount, len(entry.Results))
+	return fmt.Sprintf("renoter: dispatched event %s to %d/%d relays", event.ID, successCount, len(entry.Results)), true
+}
@@ -0,0 +1,184 @@
+package client
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fiatjaf/khatru"
+	"github.com/girino/renoter/internal/config"
+	"github.com/girino/renoter/internal/relaypool"
+	"github.com/girino/renoter/pkg/server"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// newDispatcherTestSetup starts a local relay, set up by setup before it serves, and returns a
+// one-hop path plus a publisher for it.
+func newDispatcherTestSetup(t *testing.T, ctx context.Context, setup ...func(*khatru.Relay)) (*server.TestRelay, Path, *relaypool.Publisher) {
+	t.Helper()
+	relay, err := server.StartTestRelay(ctx, setup...)
+	if err != nil {
+		t.Fatalf("StartTestRelay() error = %v", err)
+	}
+	t.Cleanup(func() { relay.Stop(context.Background()) })
+
+	pk, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	pkBytes, _ := hex.DecodeString(pk)
+	publisher, err := relaypool.NewPublisher(ctx, relaypool.DefaultOptions())
+	if err != nil {
+		t.Fatalf("NewPublisher() error = %v", err)
+	}
+	return relay, NewPath(pkBytes), publisher
+}
+
+func newDispatcherTestEvent() *nostr.Event {
+	event := &nostr.Event{Kind: 1, Content: "dispatch me", CreatedAt: nostr.Now(), Tags: nostr.Tags{}}
+	event.Sign(nostr.GeneratePrivateKey())
+	return event
+}
+
+func TestDispatcher_DispatchesAndNotifies(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	relay, path, pool := newDispatcherTestSetup(t, ctx)
+
+	// Watch the relay for the wrapped container before submitting
+	containers := nostr.NewSimplePool(ctx).SubscribeMany(ctx, []string{relay.URL()}, nostr.Filter{Kinds: []int{config.StandardizedWrapperKind}})
+	time.Sleep(200 * time.Millisecond)
+
+	opts := DefaultOptions()
+	opts.Stats = NewStats()
+	journalPath := filepath.Join(t.TempDir(), "journal.jsonl")
+	opts.Journal, _ = NewJournal(journalPath, 0, 0)
+	dispatcher, err := NewDispatcher(ctx, path, pool, []string{relay.URL()}, opts)
+	if err != nil {
+		t.Fatalf("NewDispatcher() error = %v", err)
+	}
+
+	notices := make(chan string, 1)
+	event := newDispatcherTestEvent()
+	if err := dispatcher.Submit(event, func(msg string) { notices <- msg }); err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+
+	select {
+	case msg := <-notices:
+		if !strings.Contains(msg, "dispatched event "+event.ID) {
+			t.Errorf("notice = %q, want dispatch confirmation for %s", msg, event.ID)
+		}
+	case <-time.After(30 * time.Second):
+		t.Fatal("timed out waiting for dispatch notice")
+	}
+
+	select {
+	case <-containers:
+	case <-time.After(5 * time.Second):
+		t.Error("wrapped container was not published to the relay")
+	}
+
+	snap := opts.Stats.Snapshot()
+	if snap.Dispatched != 1 || snap.PaddedBytes != int64(config.StandardizedSize) || snap.PublishedBytes != snap.EncryptedBytes {
+		t.Errorf("stats after one dispatch = %+v", snap)
+	}
+
+	entries, err := LookupJournal(journalPath, event.ID, 0)
+	if err != nil || len(entries) != 1 || !entries[0].Dispatched() || entries[0].PathHash != PathHash(path) || entries[0].WrappedID == "" {
+		t.Errorf("LookupJournal() = %+v, %v, want the dispatch", entries, err)
+	}
+}
+
+func TestDispatcher_MiningTimeout(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	relay, path, pool := newDispatcherTestSetup(t, ctx)
+
+	opts := DefaultOptions()
+	opts.MiningTimeout = time.Nanosecond
+	dispatcher, err := NewDispatcher(ctx, path, pool, []string{relay.URL()}, opts)
+	if err != nil {
+		t.Fatalf("NewDispatcher() error = %v", err)
+	}
+
+	notices := make(chan string, 1)
+	event := newDispatcherTestEvent()
+	if err := dispatcher.Submit(event, func(msg string) { notices <- msg }); err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+
+	select {
+	case msg := <-notices:
+		if !strings.Contains(msg, "failed to dispatch event "+event.ID) {
+			t.Errorf("notice = %q, want failure for %s", msg, event.ID)
+		}
+		if rejection, ok := config.ParseRejection(msg); !ok || rejection.Reason != config.RejectMiningTimeout {
+			t.Errorf("notice = %q, want a %s rejection", msg, config.RejectMiningTimeout)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for failure notice")
+	}
+}
+
+func TestDispatcher_QueueFull(t *testing.T) {
+	// No workers are running, so the single queue slot stays occupied
+	dispatcher := &Dispatcher{jobs: make(chan dispatchJob, 1)}
+
+	if err := dispatcher.Submit(newDispatcherTestEvent(), nil); err != nil {
+		t.Fatalf("first Submit() error = %v", err)
+	}
+	err := dispatcher.Submit(newDispatcherTestEvent(), nil)
+	var rejection *config.Rejection
+	if !errors.As(err, &rejection) || rejection.Reason != config.RejectQueueFull || rejection.Prefix != config.PrefixRateLimited {
+		t.Errorf("Submit() on a full queue error = %v, want a %s rejection", err, config.RejectQueueFull)
+	}
+}
+
+func TestDispatcher_StopsWithContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	dispatcher, err := NewDispatcher(ctx, nil, nil, nil, DefaultOptions())
+	if err != nil {
+		t.Fatalf("NewDispatcher() error = %v", err)
+	}
+
+	cancel()
+	done := make(chan struct{})
+	go func() {
+		dispatcher.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("workers did not stop after context cancellation")
+	}
+}
+
+func TestDispatcher_Close(t *testing.T) {
+	ctx := context.Background()
+	relay, path, pool := newDispatcherTestSetup(t, ctx)
+	if err := pool.Connect(relay.URL()); err != nil {
+		t.Fatalf("Connect() error = %v", err)
+	}
+	dispatcher, err := NewDispatcher(ctx, path, pool, []string{relay.URL()}, DefaultOptions())
+	if err != nil {
+		t.Fatalf("NewDispatcher() error = %v", err)
+	}
+	// As if opened by StartDispatcher
+	dispatcher.publisher = pool
+
+	closeCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := dispatcher.Close(closeCtx); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if open := pool.OpenConnections(); open != 0 {
+		t.Errorf("OpenConnections() = %d after Close(), want 0", open)
+	}
+	// The workers have exited, though the context given to NewDispatcher is not done
+	dispatcher.Wait()
+}
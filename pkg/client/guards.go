@@ -0,0 +1,74 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/girino/nostr-lib/logging"
+)
+
+// LoadGuards returns n guards for PathPolicy.Guards, keeping the ones stored in file so the
+// entry hop stays the same across restarts. Stored guards no longer among the configured
+// Renoters are dropped and replacements are picked at random, trusted Renoters first, then
+// stored back. The file holds one hex pubkey per line.
+func LoadGuards(file string, renterPath Path, n int, trusted map[string]bool) (map[string]bool, error) {
+	if n <= 0 {
+		return nil, fmt.Errorf("guard count must be positive, got %d", n)
+	}
+	configured := make(map[string]bool, len(renterPath))
+	for _, node := range renterPath {
+		configured[node.Key()] = true
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read guard file: %w", err)
+	}
+	guards := make(map[string]bool)
+	var order []string
+	for _, line := range strings.Split(string(data), "\n") {
+		key := strings.TrimSpace(line)
+		if key == "" || guards[key] || len(guards) == n {
+			continue
+		}
+		if !configured[key] {
+			logging.Warn("client.guards.LoadGuards: guard %s is no longer configured, replacing it", key[:min(16, len(key))])
+			continue
+		}
+		guards[key] = true
+		order = append(order, key)
+	}
+	if len(guards) == n {
+		return guards, nil
+	}
+
+	// Top up with random Renoters, preferring trusted ones
+	var preferred, others []string
+	for _, node := range ShufflePath(renterPath) {
+		key := node.Key()
+		if guards[key] {
+			continue
+		}
+		if trusted[key] {
+			preferred = append(preferred, key)
+		} else {
+			others = append(others, key)
+		}
+	}
+	for _, key := range append(preferred, others...) {
+		if len(guards) == n {
+			break
+		}
+		guards[key] = true
+		order = append(order, key)
+		logging.Info("client.guards.LoadGuards: Picked Renoter %s as a guard", key[:16])
+	}
+
+	if err := os.WriteFile(file, []byte(strings.Join(order, "\n")+"\n"), 0o600); err != nil {
+		return nil, fmt.Errorf("failed to write guard file: %w", err)
+	}
+	return guards, nil
+}
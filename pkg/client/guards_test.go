@@ -0,0 +1,94 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadGuards(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "guards.txt")
+	path := testRenoters(5)
+	trusted := map[string]bool{path[3].Key(): true}
+
+	guards, err := LoadGuards(file, path, 2, trusted)
+	if err != nil {
+		t.Fatalf("LoadGuards() error = %v", err)
+	}
+	if len(guards) != 2 || !guards[path[3].Key()] {
+		t.Errorf("LoadGuards() = %v, want 2 guards including the trusted Renoter", guards)
+	}
+
+	// The same guards are kept on the next start
+	again, err := LoadGuards(file, path, 2, trusted)
+	if err != nil {
+		t.Fatalf("LoadGuards() error = %v", err)
+	}
+	for key := range guards {
+		if !again[key] {
+			t.Errorf("LoadGuards() replaced guard %s on restart", key[:8])
+		}
+	}
+
+	// A guard removed from the configured Renoters is replaced
+	var kept, dropped string
+	for key := range guards {
+		if key == path[3].Key() {
+			kept = key
+		} else {
+			dropped = key
+		}
+	}
+	var remaining Path
+	for _, node := range path {
+		if node.Key() != dropped {
+			remaining = append(remaining, node)
+		}
+	}
+	replaced, err := LoadGuards(file, remaining, 2, trusted)
+	if err != nil {
+		t.Fatalf("LoadGuards() error = %v", err)
+	}
+	if len(replaced) != 2 || !replaced[kept] || replaced[dropped] {
+		t.Errorf("LoadGuards() after removing a guard = %v", replaced)
+	}
+	data, _ := os.ReadFile(file)
+	if strings.Contains(string(data), dropped) {
+		t.Error("the removed guard is still stored")
+	}
+
+	if _, err := LoadGuards(file, path, 0, nil); err == nil {
+		t.Error("LoadGuards() should reject a zero guard count")
+	}
+}
+
+func TestSelectPath_Guards(t *testing.T) {
+	path := testRenoters(5)
+	guards := map[string]bool{path[1].Key(): true, path[2].Key(): true}
+	policy := PathPolicy{Hops: 3, Guards: guards}
+
+	firstHops := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		selected, err := SelectPath(path, policy)
+		if err != nil {
+			t.Fatalf("SelectPath() error = %v", err)
+		}
+		first := selected[0].Key()
+		if !guards[first] {
+			t.Fatalf("SelectPath() entered through %s..., not a guard", first[:8])
+		}
+		firstHops[first] = true
+	}
+	if len(firstHops) != 2 {
+		t.Errorf("SelectPath() used %d different guards, want both", len(firstHops))
+	}
+
+	// Every Renoter on the path still puts a guard first
+	if selected, _ := SelectPath(path, PathPolicy{Guards: guards}); !guards[selected[0].Key()] {
+		t.Error("SelectPath() with all hops should still enter through a guard")
+	}
+	if err := (PathPolicy{Guards: map[string]bool{"ff": true}}).Check(path); err == nil {
+		t.Error("Check() should fail when no guard is configured as a Renoter")
+	}
+}
@@ -0,0 +1,193 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/girino/renoter/internal/config"
+	"github.com/girino/renoter/internal/descriptor"
+	"github.com/girino/renoter/internal/sealtag"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/nbd-wtf/go-nostr/nip44"
+)
+
+// handshakeTimeout bounds how long a capability probe waits for its response.
+const handshakeTimeout = 30 * time.Second
+
+// DefaultCapabilityTTL is how long a capability response is reused before the Renoter is probed again.
+const DefaultCapabilityTTL = time.Hour
+
+// Capabilities caches the capability responses of Renoters by hex pubkey. It is safe for
+// concurrent use.
+type Capabilities struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]*descriptor.Descriptor
+}
+
+// NewCapabilities creates an empty cache keeping responses for ttl.
+func NewCapabilities(ttl time.Duration) *Capabilities {
+	return &Capabilities{ttl: ttl, entries: make(map[string]*descriptor.Descriptor)}
+}
+
+// Get returns the cached capabilities of the Renoter with hex pubkey key, or nil if it has not
+// answered a probe within the TTL.
+func (c *Capabilities) Get(key string) *descriptor.Descriptor {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	d := c.entries[key]
+	if d == nil || time.Since(d.CreatedAt) > c.ttl {
+		return nil
+	}
+	return d
+}
+
+// Negotiate returns the cached capabilities of entry, probing it with Handshake if there are none.
+func (c *Capabilities) Negotiate(ctx context.Context, entry PathNode, relayURLs []string, opts Options) (*descriptor.Descriptor, error) {
+	if d := c.Get(entry.Key()); d != nil {
+		return d, nil
+	}
+	d, err := Handshake(ctx, entry, relayURLs, opts)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.entries[entry.Key()] = d
+	c.mu.Unlock()
+	return d, nil
+}
+
+// Handshake sends entry a capability probe in a standardized 29001 container, published to
+// relayURLs and the node's relay hints like traffic, and waits for its encrypted capability
+// response: protocol version, standardized size, PoW, fees and mixing bounds, as in its service
+// descriptor. Only entry can read the probe and only the client the response.
+func Handshake(ctx context.Context, entry PathNode, relayURLs []string, opts Options) (*descriptor.Descriptor, error) {
+	ctx, cancel := context.WithTimeout(ctx, handshakeTimeout)
+	defer cancel()
+
+	sk := nostr.GeneratePrivateKey()
+	pk, _ := nostr.GetPublicKey(sk)
+	conversationKey, err := nip44.GenerateConversationKey(entry.Key(), sk)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate conversation key: %w", err)
+	}
+	sealed, err := sealtag.Seal([]string{strconv.Itoa(config.ProtocolVersion)}, conversationKey)
+	if err != nil {
+		return nil, err
+	}
+	probe := &nostr.Event{
+		Kind:      config.WrapperEventKind,
+		CreatedAt: nostr.Now(),
+		Tags:      nostr.Tags{{"p", entry.Key()}, {config.HandshakeTag, sealed}},
+	}
+	if err := probe.Sign(sk); err != nil {
+		return nil, fmt.Errorf("failed to sign capability probe: %w", err)
+	}
+	// Probes are small, so they look like traffic in the smallest bucket
+	container, err := buildStandardizedContainer(ctx, probe, entry.Key(), opts.Limits.Sizes()[0], opts.ContainerPoWDifficulty, opts.Miner, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	relays := slices.Clone(relayURLs)
+	for _, relay := range entry.Relays {
+		if !slices.Contains(relays, relay) {
+			relays = append(relays, relay)
+		}
+	}
+	var pool Pool = opts.ServerPool
+	if pool == nil {
+		pool = nostr.NewSimplePool(ctx)
+	}
+	since := nostr.Now()
+	responses := pool.SubscribeMany(ctx, relays, nostr.Filter{
+		Kinds: []int{config.HandshakeResponseKind},
+		Tags:  nostr.TagMap{"p": []string{pk}},
+		Since: &since,
+	})
+	select {
+	case <-time.After(subscriptionWarmUp):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	accepted := 0
+	for result := range pool.PublishMany(ctx, relays, *container) {
+		if result.Error == nil {
+			accepted++
+		}
+	}
+	if accepted == 0 {
+		return nil, fmt.Errorf("no relay accepted the capability probe")
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("no capability response: %w", ctx.Err())
+		case relayEvent, ok := <-responses:
+			if !ok {
+				return nil, fmt.Errorf("no capability response")
+			}
+			plaintext, err := nip44.Decrypt(relayEvent.Event.Content, conversationKey)
+			if err != nil {
+				logging.DebugMethod("client.handshake", "Handshake", "Ignoring unreadable capability response %s: %v", relayEvent.Event.ID, err)
+				continue
+			}
+			var tags nostr.Tags
+			if err := json.Unmarshal([]byte(plaintext), &tags); err != nil {
+				return nil, fmt.Errorf("invalid capability response: %w", err)
+			}
+			d, err := descriptor.ParseCapabilities(entry.Key(), tags, time.Now())
+			if err != nil {
+				return nil, fmt.Errorf("invalid capability response: %w", err)
+			}
+			return d, nil
+		}
+	}
+}
+
+// negotiatePath probes every Renoter on path concurrently, since any of them can be the entry
+// hop, and returns the path with the answers as descriptors. Renoters that do not answer keep
+// the descriptor they had, with a warning; an answer incompatible with opts is an error.
+func negotiatePath(ctx context.Context, path Path, relayURLs []string, opts Options) (Path, error) {
+	answers := make([]*descriptor.Descriptor, len(path))
+	var wg sync.WaitGroup
+	for i, node := range path {
+		wg.Add(1)
+		go func(i int, node PathNode) {
+			defer wg.Done()
+			npub, _ := nip19.EncodePublicKey(node.Key())
+			d, err := opts.Capabilities.Negotiate(ctx, node, relayURLs, opts)
+			if err != nil {
+				logging.Warn("client.handshake.negotiatePath: Renoter %s did not answer the capability probe: %v", npub, err)
+				return
+			}
+			answers[i] = d
+		}(i, node)
+	}
+	wg.Wait()
+
+	negotiated := make(map[string]*descriptor.Descriptor)
+	var answered []string
+	for i, node := range path {
+		if answers[i] != nil {
+			negotiated[node.Key()] = answers[i]
+			answered = append(answered, node.Key())
+		} else if node.Descriptor != nil {
+			negotiated[node.Key()] = node.Descriptor
+		}
+	}
+	if err := checkPathDescriptors(answered, negotiated, opts); err != nil {
+		return nil, err
+	}
+	logging.Info("client.handshake.negotiatePath: %d/%d Renoters answered the capability probe", len(answered), len(path))
+	return path.withDescriptors(negotiated), nil
+}
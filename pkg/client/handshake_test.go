@@ -0,0 +1,67 @@
+package client
+
+import (
+	"context"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/girino/renoter/internal/config"
+	"github.com/girino/renoter/internal/descriptor"
+	"github.com/girino/renoter/pkg/server"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestHandshake(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	relay, _, _ := newDispatcherTestSetup(t, ctx)
+	renoter, err := server.NewRenoter(ctx, nostr.GeneratePrivateKey(), []string{relay.URL()})
+	if err != nil {
+		t.Fatalf("NewRenoter() error = %v", err)
+	}
+	if err := renoter.SubscribeToWrappedEvents(ctx); err != nil {
+		t.Fatalf("SubscribeToWrappedEvents() error = %v", err)
+	}
+	time.Sleep(200 * time.Millisecond)
+	pubkey, _ := hex.DecodeString(renoter.GetPublicKey())
+	path := NewPath(pubkey)
+
+	opts := DefaultOptions()
+	capabilities := NewCapabilities(time.Hour)
+	d, err := capabilities.Negotiate(ctx, path[0], []string{relay.URL()}, opts)
+	if err != nil {
+		t.Fatalf("Negotiate() error = %v", err)
+	}
+	if d.Version != config.ProtocolVersion || d.StandardizedSize != config.StandardizedSize || d.PubKey != renoter.GetPublicKey() {
+		t.Errorf("capabilities = version %d, size %d, pubkey %s", d.Version, d.StandardizedSize, d.PubKey)
+	}
+	if d.MixingMax != config.DefaultMaxMixingDelay {
+		t.Errorf("capabilities announce mixing up to %v, want %v", d.MixingMax, config.DefaultMaxMixingDelay)
+	}
+	if cached := capabilities.Get(path[0].Key()); cached != d {
+		t.Error("Negotiate() did not cache the response")
+	}
+
+	// The answer is checked like a descriptor
+	opts.Limits = config.SizeLimits{StandardizedSize: 16 * 1024, MaxInnerEventSize: 16*1024 - config.PaddingTagOverhead}
+	opts.Capabilities = capabilities
+	if _, err := negotiatePath(ctx, path, []string{relay.URL()}, opts); err == nil || !strings.Contains(err.Error(), "standardized size") {
+		t.Errorf("negotiatePath() error = %v, want the size mismatch", err)
+	}
+}
+
+func TestCapabilities_Expire(t *testing.T) {
+	path := testRenoters(2)
+	capabilities := NewCapabilities(time.Minute)
+	capabilities.entries[path[0].Key()] = &descriptor.Descriptor{CreatedAt: time.Now().Add(-2 * time.Minute)}
+	capabilities.entries[path[1].Key()] = &descriptor.Descriptor{CreatedAt: time.Now()}
+	if capabilities.Get(path[0].Key()) != nil {
+		t.Error("Get() returned a response older than the TTL")
+	}
+	if capabilities.Get(path[1].Key()) == nil {
+		t.Error("Get() did not return a fresh response")
+	}
+}
@@ -0,0 +1,171 @@
+package client
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/girino/renoter/internal/config"
+	"github.com/girino/renoter/internal/descriptor"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+// PathHealth tracks the newest service descriptor and liveness heartbeat of each configured
+// Renoter, so paths can be drawn around Renoters that announced going offline or stopped sending
+// heartbeats. Whichever of the two is newer decides. Renoters without either are taken for online.
+type PathHealth struct {
+	mu          sync.RWMutex
+	descriptors map[string]*descriptor.Descriptor
+	heartbeats  map[string]*descriptor.Heartbeat
+	// Whether each Renoter was offline when last checked, to log only the changes
+	offline map[string]bool
+}
+
+// NewPathHealth creates a PathHealth seeded with the descriptors already attached to path.
+func NewPathHealth(path Path) *PathHealth {
+	h := &PathHealth{descriptors: make(map[string]*descriptor.Descriptor), heartbeats: make(map[string]*descriptor.Heartbeat), offline: make(map[string]bool)}
+	seed := make(map[string]*descriptor.Descriptor)
+	for _, node := range path {
+		if node.Descriptor != nil {
+			seed[node.Key()] = node.Descriptor
+		}
+	}
+	h.Update(seed, time.Now())
+	return h
+}
+
+// Update records the descriptors newer than the ones already known and logs the Renoters
+// going offline or coming back at now. A Renoter missing from descriptors keeps its last one.
+func (h *PathHealth) Update(descriptors map[string]*descriptor.Descriptor, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for key, d := range descriptors {
+		if known := h.descriptors[key]; known == nil || d.CreatedAt.After(known.CreatedAt) {
+			h.descriptors[key] = d
+		}
+	}
+	h.logChangesLocked(now)
+}
+
+// UpdateHeartbeats records the heartbeats newer than the ones already known, like Update.
+func (h *PathHealth) UpdateHeartbeats(heartbeats map[string]*descriptor.Heartbeat, now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for key, beat := range heartbeats {
+		if known := h.heartbeats[key]; known == nil || beat.CreatedAt.After(known.CreatedAt) {
+			h.heartbeats[key] = beat
+		}
+	}
+	h.logChangesLocked(now)
+}
+
+// logChangesLocked logs the Renoters going offline or coming back at now. Must be called with mu held.
+func (h *PathHealth) logChangesLocked(now time.Time) {
+	keys := make(map[string]bool)
+	for key := range h.descriptors {
+		keys[key] = true
+	}
+	for key := range h.heartbeats {
+		keys[key] = true
+	}
+	for key := range keys {
+		reason, offline := h.offlineLocked(key, now)
+		if offline == h.offline[key] {
+			continue
+		}
+		h.offline[key] = offline
+		npub, _ := nip19.EncodePublicKey(key)
+		if offline {
+			logging.Warn("client.health.Update: Renoter %s is offline (%s), routing around it", npub, reason)
+		} else {
+			logging.Info("client.health.Update: Renoter %s is back online", npub)
+		}
+	}
+}
+
+// Offline reports whether the Renoter with hex pubkey key is offline at now, and why.
+func (h *PathHealth) Offline(key string, now time.Time) (string, bool) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.offlineLocked(key, now)
+}
+
+// offlineLocked is Offline, judged by the newer of the Renoter's descriptor and heartbeat. Must
+// be called with mu held.
+func (h *PathHealth) offlineLocked(key string, now time.Time) (string, bool) {
+	d, beat := h.descriptors[key], h.heartbeats[key]
+	if beat != nil && (d == nil || beat.CreatedAt.After(d.CreatedAt)) {
+		return beat.Offline(now)
+	}
+	if d != nil {
+		return d.Offline(now)
+	}
+	return "", false
+}
+
+// healthy splits path into the Renoters online at now and the pubkeys of the offline ones.
+func (h *PathHealth) healthy(path Path, now time.Time) (Path, []string) {
+	var online Path
+	var offline []string
+	for _, node := range path {
+		if _, down := h.Offline(node.Key(), now); down {
+			offline = append(offline, node.Key())
+			continue
+		}
+		online = append(online, node)
+	}
+	return online, offline
+}
+
+// Monitor refetches the descriptors and heartbeats of the Renoters on path from relayURLs and the
+// nodes' own relays every interval until ctx is done. pool may be nil to use a throwaway SimplePool.
+// Heartbeats gossiped by peer Renoters show up on relays the Renoter itself does not publish to.
+func (h *PathHealth) Monitor(ctx context.Context, pool Pool, relayURLs []string, path Path, interval time.Duration) {
+	lookupRelays := slices.Clone(relayURLs)
+	for _, relay := range path.RelayHints() {
+		if !slices.Contains(lookupRelays, relay) {
+			lookupRelays = append(lookupRelays, relay)
+		}
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			h.Update(fetchDescriptors(ctx, pool, lookupRelays, path.Keys()), time.Now())
+			h.UpdateHeartbeats(fetchHeartbeats(ctx, pool, lookupRelays, path.Keys()), time.Now())
+		}
+	}
+}
+
+// fetchHeartbeats fetches the newest liveness heartbeat of each Renoter in pubkeys from relayURLs.
+// Invalid heartbeats, including ones altered by whoever republished them, are ignored.
+func fetchHeartbeats(ctx context.Context, pool Pool, relayURLs []string, pubkeys []string) map[string]*descriptor.Heartbeat {
+	ctx, cancel := context.WithTimeout(ctx, descriptorFetchTimeout)
+	defer cancel()
+
+	if pool == nil {
+		pool = nostr.NewSimplePool(ctx)
+	}
+	heartbeats := make(map[string]*descriptor.Heartbeat)
+	filter := nostr.Filter{Kinds: []int{config.HeartbeatKind}, Authors: pubkeys}
+	for relayEvent := range pool.FetchMany(ctx, relayURLs, filter) {
+		event := relayEvent.Event
+		if known := heartbeats[event.PubKey]; known != nil && !event.CreatedAt.Time().After(known.CreatedAt) {
+			continue
+		}
+		beat, err := descriptor.ParseHeartbeat(event)
+		if err != nil {
+			logging.Warn("client.health.fetchHeartbeats: ignoring invalid heartbeat %s: %v", event.ID, err)
+			continue
+		}
+		heartbeats[event.PubKey] = beat
+	}
+	logging.DebugMethod("client.health", "fetchHeartbeats", "Found heartbeats for %d/%d Renoters", len(heartbeats), len(pubkeys))
+	return heartbeats
+}
@@ -0,0 +1,125 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/girino/renoter/internal/descriptor"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestPathHealth_RoutesAroundOfflineRenoters(t *testing.T) {
+	now := time.Now()
+	path := testRenoters(3)
+	health := NewPathHealth(path.withDescriptors(map[string]*descriptor.Descriptor{
+		path[0].Key(): {Status: descriptor.StatusOnline, Heartbeat: time.Minute, CreatedAt: now},
+		path[1].Key(): {Status: descriptor.StatusOffline, CreatedAt: now},
+	}))
+
+	d := &Dispatcher{renterPath: path, health: health}
+	healthy, avoided := d.healthyRenoters()
+	if len(healthy) != 2 || len(avoided) != 1 || avoided[0] != path[1].Key() {
+		t.Fatalf("healthyRenoters() = %v, avoided %v, want all but the offline Renoter", healthy.Keys(), avoided)
+	}
+	for i := 0; i < 20; i++ {
+		selected, err := SelectPath(healthy, d.opts.PathPolicy)
+		if err != nil {
+			t.Fatalf("SelectPath() error = %v", err)
+		}
+		for _, node := range selected {
+			if node.Key() == path[1].Key() {
+				t.Fatal("SelectPath() drew the offline Renoter")
+			}
+		}
+	}
+
+	// The healthy Renoters cannot give three hops, so every Renoter is used rather than failing
+	d.opts.PathPolicy.Hops = 3
+	if healthy, avoided := d.healthyRenoters(); len(healthy) != 3 || avoided != nil {
+		t.Errorf("healthyRenoters() = %d Renoters, avoided %v, want the full path as a fallback", len(healthy), avoided)
+	}
+
+	// A newer online announcement brings the Renoter back, an older one is ignored
+	health.Update(map[string]*descriptor.Descriptor{path[1].Key(): {Status: descriptor.StatusOnline, CreatedAt: now.Add(-time.Hour)}}, now)
+	if _, offline := health.Offline(path[1].Key(), now); !offline {
+		t.Error("Update() replaced a descriptor with an older one")
+	}
+	health.Update(map[string]*descriptor.Descriptor{path[1].Key(): {Status: descriptor.StatusOnline, CreatedAt: now.Add(time.Second)}}, now)
+	if reason, offline := health.Offline(path[1].Key(), now); offline {
+		t.Errorf("Offline() after an online announcement = %q", reason)
+	}
+
+	// Missing heartbeats take a Renoter offline without any new announcement
+	if _, offline := health.Offline(path[0].Key(), now.Add(4*time.Minute)); !offline {
+		t.Error("Offline() should report a Renoter silent for four heartbeats")
+	}
+	if _, offline := health.Offline(path[2].Key(), now.Add(24*time.Hour)); offline {
+		t.Error("Offline() should take Renoters without a descriptor for online")
+	}
+}
+
+func TestPathHealth_Heartbeats(t *testing.T) {
+	now := time.Now()
+	path := testRenoters(1)
+	key := path[0].Key()
+	// The descriptor is old enough for the Renoter to look silent
+	health := NewPathHealth(path.withDescriptors(map[string]*descriptor.Descriptor{
+		key: {Status: descriptor.StatusOnline, Heartbeat: time.Minute, CreatedAt: now.Add(-time.Hour)},
+	}))
+	if _, offline := health.Offline(key, now); !offline {
+		t.Fatal("Offline() should report a Renoter with a stale descriptor")
+	}
+
+	health.UpdateHeartbeats(map[string]*descriptor.Heartbeat{key: {Status: descriptor.StatusOnline, Interval: time.Minute, CreatedAt: now}}, now)
+	if reason, offline := health.Offline(key, now); offline {
+		t.Errorf("Offline() after a fresh heartbeat = %q", reason)
+	}
+	health.UpdateHeartbeats(map[string]*descriptor.Heartbeat{key: {Status: descriptor.StatusOffline, Interval: time.Minute, CreatedAt: now.Add(-time.Minute)}}, now)
+	if _, offline := health.Offline(key, now); offline {
+		t.Error("UpdateHeartbeats() replaced a heartbeat with an older one")
+	}
+
+	// A newer descriptor takes over from the heartbeat
+	health.Update(map[string]*descriptor.Descriptor{key: {Status: descriptor.StatusOffline, CreatedAt: now.Add(time.Second)}}, now)
+	if _, offline := health.Offline(key, now); !offline {
+		t.Error("Offline() should follow a descriptor newer than the heartbeat")
+	}
+}
+
+// storedPool is a fakePool holding events to fetch.
+type storedPool struct {
+	fakePool
+	events []*nostr.Event
+}
+
+func (p *storedPool) FetchMany(ctx context.Context, urls []string, filter nostr.Filter, opts ...nostr.SubscriptionOption) chan nostr.RelayEvent {
+	ch := make(chan nostr.RelayEvent, len(p.events))
+	for _, event := range p.events {
+		if filter.Matches(event) {
+			ch <- nostr.RelayEvent{Event: event}
+		}
+	}
+	close(ch)
+	return ch
+}
+
+func TestFetchHeartbeats(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	pk, _ := nostr.GetPublicKey(sk)
+	beat := func(status string, createdAt nostr.Timestamp) *nostr.Event {
+		event := descriptor.Heartbeat{Status: status, Interval: time.Minute}.Event()
+		event.CreatedAt = createdAt
+		event.Sign(sk)
+		return &event
+	}
+	newest := beat(descriptor.StatusOnline, nostr.Now())
+	tampered := beat(descriptor.StatusOnline, nostr.Now()+10)
+	tampered.Tags[0][1] = descriptor.StatusOffline
+
+	pool := &storedPool{events: []*nostr.Event{beat(descriptor.StatusOffline, nostr.Now()-60), newest, tampered}}
+	heartbeats := fetchHeartbeats(context.Background(), pool, []string{"wss://relay.example.com"}, []string{pk})
+	if got := heartbeats[pk]; got == nil || got.Status != descriptor.StatusOnline || !got.CreatedAt.Equal(newest.CreatedAt.Time()) {
+		t.Errorf("fetchHeartbeats() = %+v, want the newest valid heartbeat", got)
+	}
+}
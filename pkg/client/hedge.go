@@ -0,0 +1,113 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// DefaultHedgeDelay is how long a hedged publish waits for an OK before adding another relay.
+const DefaultHedgeDelay = 2 * time.Second
+
+// HedgePolicy publishes each container to a few random server relays instead of all of them, so
+// fewer relays see every container the client sends. The container goes to one relay; another
+// is added when it refuses or has not answered within Delay, until one accepts or Relays have
+// been tried, or one refuses the container as invalid, which every relay would. The zero value
+// publishes to all server relays at once.
+type HedgePolicy struct {
+	// Most server relays a container is sent to (0 = all of them, at once)
+	Relays int
+	// Time to wait for an OK before adding the next relay (0 = DefaultHedgeDelay)
+	Delay time.Duration
+}
+
+// Validate checks that the policy is usable.
+func (p HedgePolicy) Validate() error {
+	if p.Relays < 0 {
+		return fmt.Errorf("hedged relays must not be negative, got %d", p.Relays)
+	}
+	if p.Delay < 0 {
+		return fmt.Errorf("hedge delay must not be negative, got %v", p.Delay)
+	}
+	return nil
+}
+
+// publish sends event to relays as the policy says, returning one result per relay tried. The
+// channel is closed once every relay tried has answered.
+func (p HedgePolicy) publish(ctx context.Context, pool Pool, relays []string, event nostr.Event) chan nostr.PublishResult {
+	if p.Relays == 0 {
+		return pool.PublishMany(ctx, relays, event)
+	}
+	delay := p.Delay
+	if delay == 0 {
+		delay = DefaultHedgeDelay
+	}
+	order := make([]string, len(relays))
+	copy(order, relays)
+	rand.Shuffle(len(order), func(i, j int) { order[i], order[j] = order[j], order[i] })
+	if len(order) > p.Relays {
+		order = order[:p.Relays]
+	}
+
+	results := make(chan nostr.PublishResult, len(order))
+	answers := make(chan nostr.PublishResult, len(order))
+	go func() {
+		defer close(results)
+		next, pending, accepted := 0, 0, false
+		// send publishes to the next relay of the subset, if any is left and none accepted yet
+		send := func() {
+			if accepted || next == len(order) {
+				return
+			}
+			url := order[next]
+			next++
+			pending++
+			go func() {
+				answered := false
+				for result := range pool.PublishMany(ctx, []string{url}, event) {
+					answers <- result
+					answered = true
+				}
+				if !answered {
+					answers <- nostr.PublishResult{RelayURL: url, Error: fmt.Errorf("no answer from relay")}
+				}
+			}()
+		}
+
+		send()
+		timer := time.NewTimer(delay)
+		defer timer.Stop()
+		for pending > 0 {
+			select {
+			case result := <-answers:
+				pending--
+				results <- result
+				category := ""
+				if result.Error != nil {
+					category = ParseRelayReason(result.Error).Category
+				}
+				switch category {
+				case "", RelayDuplicate:
+					accepted = true
+				case RelayInvalid:
+					// The container itself is at fault, so no other relay would take it
+					next = len(order)
+				default:
+					send()
+					timer.Reset(delay)
+				}
+			case <-timer.C:
+				if !accepted && next < len(order) {
+					logging.DebugMethod("client.hedge", "publish", "No OK for %s after %v, adding relay %d/%d", event.ID, delay, next+1, len(order))
+				}
+				send()
+				timer.Reset(delay)
+			}
+		}
+	}()
+	return results
+}
@@ -0,0 +1,93 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// hedgePool refuses the first refuseFirst publishes with reason ("blocked: no" if empty) and
+// accepts the rest, answering each after delay.
+type hedgePool struct {
+	fakePool
+	refuseFirst int
+	reason      string
+	delay       time.Duration
+
+	mu    sync.Mutex
+	tried []string
+}
+
+func (p *hedgePool) PublishMany(ctx context.Context, urls []string, evt nostr.Event) chan nostr.PublishResult {
+	p.mu.Lock()
+	refuse := len(p.tried) < p.refuseFirst
+	p.tried = append(p.tried, urls...)
+	p.mu.Unlock()
+	ch := make(chan nostr.PublishResult, len(urls))
+	go func() {
+		defer close(ch)
+		time.Sleep(p.delay)
+		for _, url := range urls {
+			result := nostr.PublishResult{RelayURL: url}
+			if refuse {
+				result.Error = errors.New("msg: blocked: no")
+				if p.reason != "" {
+					result.Error = errors.New("msg: " + p.reason)
+				}
+			}
+			ch <- result
+		}
+	}()
+	return ch
+}
+
+func TestHedgePolicy_Publish(t *testing.T) {
+	relays := []string{"wss://a.example.com", "wss://b.example.com", "wss://c.example.com", "wss://d.example.com"}
+	tests := []struct {
+		name         string
+		policy       HedgePolicy
+		pool         *hedgePool
+		wantTried    int
+		wantAccepted int
+	}{
+		{"all relays", HedgePolicy{}, &hedgePool{}, 4, 4},
+		{"first accepts", HedgePolicy{Relays: 3, Delay: time.Minute}, &hedgePool{}, 1, 1},
+		{"refusal adds a relay", HedgePolicy{Relays: 3, Delay: time.Minute}, &hedgePool{refuseFirst: 1}, 2, 1},
+		{"at most Relays tried", HedgePolicy{Relays: 3, Delay: time.Minute}, &hedgePool{refuseFirst: 4}, 3, 0},
+		{"invalid stops hedging", HedgePolicy{Relays: 3, Delay: time.Minute}, &hedgePool{refuseFirst: 4, reason: "invalid: bad id"}, 1, 0},
+		{"silence adds a relay", HedgePolicy{Relays: 2, Delay: 50 * time.Millisecond}, &hedgePool{delay: 200 * time.Millisecond}, 2, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			accepted, results := 0, 0
+			for result := range tt.policy.publish(context.Background(), tt.pool, relays, nostr.Event{ID: "abc"}) {
+				results++
+				if result.Error == nil {
+					accepted++
+				}
+			}
+			if len(tt.pool.tried) != tt.wantTried || results != tt.wantTried {
+				t.Errorf("tried %v with %d results, want %d relays", tt.pool.tried, results, tt.wantTried)
+			}
+			if accepted != tt.wantAccepted {
+				t.Errorf("accepted by %d relays, want %d", accepted, tt.wantAccepted)
+			}
+		})
+	}
+}
+
+func TestHedgePolicy_Validate(t *testing.T) {
+	if err := (HedgePolicy{Relays: 2, Delay: time.Second}).Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+	if err := (HedgePolicy{Relays: -1}).Validate(); err == nil {
+		t.Error("Validate() accepted negative relays")
+	}
+	if err := (HedgePolicy{Delay: -time.Second}).Validate(); err == nil {
+		t.Error("Validate() accepted a negative delay")
+	}
+}
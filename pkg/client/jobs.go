@@ -0,0 +1,176 @@
+package client
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// JobState is where an event submitted with SubmitEvent is in the dispatch process.
+type JobState string
+
+const (
+	// Waiting for a mining worker
+	JobQueued JobState = "queued"
+	// Being wrapped, mostly mining the PoW of every layer
+	JobMining JobState = "mining"
+	// Wrapped and being published to the server relays (or held for the next batch)
+	JobPublishing JobState = "publishing"
+	// At least one server relay accepted the container
+	JobDelivered JobState = "delivered"
+	// Wrapping or publishing failed; Error says why
+	JobFailed JobState = "failed"
+)
+
+// Finished reports whether the state is final.
+func (s JobState) Finished() bool {
+	return s == JobDelivered || s == JobFailed
+}
+
+// maxFinishedJobs bounds the finished jobs kept for lookups; the oldest are forgotten first.
+const maxFinishedJobs = 1000
+
+// jobUpdatesBuffer is the number of updates a subscriber may fall behind before missing some.
+const jobUpdatesBuffer = 64
+
+// Job is the progress of one event submitted with SubmitEvent.
+type Job struct {
+	ID      string   `json:"id"`
+	EventID string   `json:"event_id"`
+	State   JobState `json:"state"`
+	// ID of the 29001 container, once wrapped
+	WrappedID string `json:"wrapped_id,omitempty"`
+	// Renoters on the path the event was wrapped for
+	Hops int `json:"hops,omitempty"`
+	// Result on each server relay tried
+	Results []JournalResult `json:"results,omitempty"`
+	// Rejection or failure, in the config.Rejection format
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Jobs tracks the events submitted with SubmitEvent so UIs can follow long PoW operations. It
+// keeps every unfinished job and the last maxFinishedJobs finished ones. It is safe for
+// concurrent use.
+type Jobs struct {
+	mu   sync.Mutex
+	jobs map[string]*Job
+	// IDs of finished jobs, oldest first
+	finished []string
+	// Channels receiving every update
+	watchers map[chan Job]struct{}
+}
+
+// newJobs creates an empty job registry.
+func newJobs() *Jobs {
+	return &Jobs{jobs: make(map[string]*Job), watchers: make(map[chan Job]struct{})}
+}
+
+// Get returns a copy of the job with the given ID.
+func (j *Jobs) Get(id string) (Job, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	job, ok := j.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// List returns copies of all jobs kept, in no particular order.
+func (j *Jobs) List() []Job {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	jobs := make([]Job, 0, len(j.jobs))
+	for _, job := range j.jobs {
+		jobs = append(jobs, *job)
+	}
+	return jobs
+}
+
+// Subscribe returns a channel receiving a copy of every job as it changes, and a function
+// ending the subscription. Updates are dropped for subscribers more than jobUpdatesBuffer behind.
+func (j *Jobs) Subscribe() (<-chan Job, func()) {
+	updates := make(chan Job, jobUpdatesBuffer)
+	j.mu.Lock()
+	j.watchers[updates] = struct{}{}
+	j.mu.Unlock()
+	return updates, func() {
+		j.mu.Lock()
+		delete(j.watchers, updates)
+		j.mu.Unlock()
+	}
+}
+
+// create registers a queued job for event and returns its ID.
+func (j *Jobs) create(event *nostr.Event) string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	now := time.Now()
+	job := &Job{ID: hex.EncodeToString(id), EventID: event.ID, State: JobQueued, CreatedAt: now, UpdatedAt: now}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.jobs[job.ID] = job
+	j.notifyLocked(job)
+	return job.ID
+}
+
+// remove forgets a job that never made it into the queue.
+func (j *Jobs) remove(id string) {
+	j.mu.Lock()
+	delete(j.jobs, id)
+	j.mu.Unlock()
+}
+
+// update applies change to the job with the given ID, if it is tracked, and notifies watchers.
+// A nil *Jobs or an empty ID ignores it.
+func (j *Jobs) update(id string, change func(job *Job)) {
+	if j == nil || id == "" {
+		return
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	job, ok := j.jobs[id]
+	if !ok || job.State.Finished() {
+		return
+	}
+	change(job)
+	job.UpdatedAt = time.Now()
+	if job.State.Finished() {
+		j.finished = append(j.finished, id)
+		if len(j.finished) > maxFinishedJobs {
+			delete(j.jobs, j.finished[0])
+			j.finished = j.finished[1:]
+		}
+	}
+	j.notifyLocked(job)
+}
+
+// setState moves a job to state.
+func (j *Jobs) setState(id string, state JobState) {
+	j.update(id, func(job *Job) { job.State = state })
+}
+
+// finish records the journal entry of a job's outcome.
+func (j *Jobs) finish(id string, entry JournalEntry) {
+	j.update(id, func(job *Job) {
+		job.WrappedID, job.Hops, job.Results, job.Error = entry.WrappedID, entry.Hops, entry.Results, entry.Error
+		job.State = JobDelivered
+		if entry.Error != "" {
+			job.State = JobFailed
+		}
+	})
+}
+
+func (j *Jobs) notifyLocked(job *Job) {
+	for watcher := range j.watchers {
+		select {
+		case watcher <- *job:
+		default:
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestJobs(t *testing.T) {
+	jobs := newJobs()
+	updates, unsubscribe := jobs.Subscribe()
+	defer unsubscribe()
+
+	event := newDispatcherTestEvent()
+	id := jobs.create(event)
+	jobs.setState(id, JobMining)
+	jobs.finish(id, JournalEntry{WrappedID: "wrapped", Hops: 2, Results: []JournalResult{{Relay: "wss://relay.example.com", OK: true}}})
+	// Finished jobs no longer change
+	jobs.setState(id, JobMining)
+
+	for _, want := range []JobState{JobQueued, JobMining, JobDelivered} {
+		if got := <-updates; got.ID != id || got.State != want {
+			t.Errorf("update = %s %s, want %s %s", got.ID, got.State, id, want)
+		}
+	}
+	job, ok := jobs.Get(id)
+	if !ok || job.State != JobDelivered || job.EventID != event.ID || job.WrappedID != "wrapped" || job.Hops != 2 {
+		t.Errorf("Get() = %+v, %v", job, ok)
+	}
+
+	failed := jobs.create(event)
+	jobs.finish(failed, JournalEntry{Error: "error: path-down: no relay accepted the wrapped event"})
+	if job, _ := jobs.Get(failed); job.State != JobFailed || job.Error == "" {
+		t.Errorf("failed job = %+v", job)
+	}
+
+	// Only the most recent finished jobs are kept
+	for i := 0; i < maxFinishedJobs; i++ {
+		jobs.finish(jobs.create(event), JournalEntry{})
+	}
+	if _, ok := jobs.Get(id); ok {
+		t.Error("Get() still finds the oldest finished job")
+	}
+	if len(jobs.List()) != maxFinishedJobs {
+		t.Errorf("List() returned %d jobs, want %d", len(jobs.List()), maxFinishedJobs)
+	}
+}
+
+func TestDispatcher_SubmitEvent(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pk, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	path, _ := ValidatePath([]string{mustNpub(t, pk)})
+	dispatcher, err := NewDispatcher(ctx, path, &fakePool{published: make(chan nostr.Event, 1)}, []string{"wss://relay.example.com"}, DefaultOptions())
+	if err != nil {
+		t.Fatalf("NewDispatcher() error = %v", err)
+	}
+	updates, unsubscribe := dispatcher.Jobs().Subscribe()
+	defer unsubscribe()
+
+	forged := newDispatcherTestEvent()
+	forged.Content = "tampered"
+	if _, err := dispatcher.SubmitEvent(forged); err == nil {
+		t.Error("SubmitEvent() accepted a tampered event")
+	}
+	id, err := dispatcher.SubmitEvent(newDispatcherTestEvent())
+	if err != nil {
+		t.Fatalf("SubmitEvent() error = %v", err)
+	}
+
+	var states []JobState
+	for len(states) == 0 || !states[len(states)-1].Finished() {
+		select {
+		case job := <-updates:
+			if job.ID != id {
+				t.Fatalf("update for unknown job %s", job.ID)
+			}
+			states = append(states, job.State)
+		case <-time.After(30 * time.Second):
+			t.Fatalf("job stuck after %v", states)
+		}
+	}
+	want := []JobState{JobQueued, JobMining, JobPublishing, JobDelivered}
+	if len(states) != len(want) {
+		t.Fatalf("states = %v, want %v", states, want)
+	}
+	for i := range want {
+		if states[i] != want[i] {
+			t.Errorf("states = %v, want %v", states, want)
+		}
+	}
+	if job, _ := dispatcher.Jobs().Get(id); job.WrappedID == "" || len(job.Results) != 1 {
+		t.Errorf("delivered job = %+v, want the container and relay result", job)
+	}
+}
@@ -0,0 +1,152 @@
+package client
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/girino/renoter/internal/storage"
+)
+
+// JournalEntry records what happened to one submitted event.
+type JournalEntry struct {
+	// ID of the event submitted by the local app, as rewritten by Options.Transforms
+	EventID string `json:"event_id"`
+	// ID of the 29001 container published for it (empty if wrapping failed)
+	WrappedID string `json:"wrapped_id,omitempty"`
+	// Hash of the Renoter path the event was wrapped for, in hop order (see PathHash)
+	PathHash string `json:"path_hash"`
+	// Number of Renoters on the path
+	Hops int `json:"hops"`
+	// Hex pubkeys of the offline Renoters the path was drawn without (see PathHealth)
+	Avoided []string `json:"avoided,omitempty"`
+	// Resends before this one (0 for the first dispatch); each resend gets its own entry
+	Attempt int `json:"attempt,omitempty"`
+	// When the event was accepted from the local app
+	SubmittedAt time.Time `json:"submitted_at"`
+	// When wrapping and publishing finished
+	FinishedAt time.Time `json:"finished_at"`
+	// Outcome per server relay
+	Results []JournalResult `json:"results,omitempty"`
+	// Why the event was not dispatched (empty on success)
+	Error string `json:"error,omitempty"`
+}
+
+// JournalResult is the publish outcome on one server relay.
+type JournalResult struct {
+	Relay string `json:"relay"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+	// Category of the relay's refusal, one of the Relay* categories (empty if accepted;
+	// RelayDuplicate counts as accepted)
+	Reason string `json:"reason,omitempty"`
+}
+
+// Dispatched reports whether at least one relay accepted the wrapped event.
+func (e JournalEntry) Dispatched() bool {
+	for _, result := range e.Results {
+		if result.OK {
+			return true
+		}
+	}
+	return false
+}
+
+// PathHash identifies a Renoter path without revealing its pubkeys: the hex SHA-256 of the
+// concatenated 32-byte pubkeys in hop order.
+func PathHash(renterPath Path) string {
+	h := sha256.New()
+	for _, node := range renterPath {
+		h.Write(node.PubKey)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// JournalStorage returns the storage options the journal is kept with: its time field and table.
+func JournalStorage(opts storage.Options) storage.Options {
+	opts.TimeField, opts.Table = "finished_at", "journal"
+	return opts
+}
+
+// Journal is an append-only log of dispatched events, so users can later verify whether and
+// when a note was sent. It is kept in a storage.Storage: by default a JSONL file rotated to
+// path.1, path.2, ... when it would grow beyond maxBytes. A nil *Journal records nothing.
+type Journal struct {
+	store storage.Storage
+}
+
+// NewJournal opens (or creates) the journal file at path, keeping keep rotated files.
+// maxBytes 0 disables rotation.
+func NewJournal(path string, maxBytes int64, keep int) (*Journal, error) {
+	store, err := storage.OpenFile(path, JournalStorage(storage.Options{MaxBytes: maxBytes, Keep: keep}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open journal: %w", err)
+	}
+	logging.Info("client.journal.NewJournal: Recording dispatched events in %s (rotating at %d bytes, keeping %d files)", path, maxBytes, keep)
+	return &Journal{store: store}, nil
+}
+
+// NewJournalWithStorage records the journal in store, opened with the options of JournalStorage.
+func NewJournalWithStorage(store storage.Storage) *Journal {
+	return &Journal{store: store}
+}
+
+// Record appends an entry, rotating the journal first if it would exceed its size.
+func (j *Journal) Record(entry JournalEntry) error {
+	if j == nil {
+		return nil
+	}
+	if err := j.store.Append(entry.FinishedAt, entry); err != nil {
+		logging.Error("client.journal.Record: %v", err)
+		return err
+	}
+	return nil
+}
+
+// Entries returns the entries finished between from and to (zero = open end), oldest first.
+func (j *Journal) Entries(from, to time.Time) ([]JournalEntry, error) {
+	var entries []JournalEntry
+	err := j.store.Query(from, to, func(record []byte) {
+		var entry JournalEntry
+		if err := json.Unmarshal(record, &entry); err != nil {
+			logging.Warn("client.journal.Entries: skipping malformed entry: %v", err)
+			return
+		}
+		entries = append(entries, entry)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read journal: %w", err)
+	}
+	return entries, nil
+}
+
+// Close closes the journal storage.
+func (j *Journal) Close() error {
+	if j == nil {
+		return nil
+	}
+	return j.store.Close()
+}
+
+// LookupJournal returns the entries for eventID in the journal at path and its keep rotated
+// files, oldest first. path may also be a storage spec such as "sqlite:journal.db".
+func LookupJournal(path, eventID string, keep int) ([]JournalEntry, error) {
+	var entries []JournalEntry
+	err := storage.Scan(path, JournalStorage(storage.Options{Keep: keep}), time.Time{}, time.Time{}, func(record []byte) {
+		var entry JournalEntry
+		if err := json.Unmarshal(record, &entry); err != nil {
+			logging.Warn("client.journal.LookupJournal: skipping malformed entry in %s: %v", path, err)
+			return
+		}
+		if entry.EventID == eventID {
+			entries = append(entries, entry)
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read journal: %w", err)
+	}
+	return entries, nil
+}
@@ -0,0 +1,116 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/girino/renoter/internal/storage"
+)
+
+func TestJournal_RecordAndLookup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal.jsonl")
+	journal, err := NewJournal(path, 0, 0)
+	if err != nil {
+		t.Fatalf("NewJournal() error = %v", err)
+	}
+	submitted := time.Now().Truncate(time.Second)
+	entries := []JournalEntry{
+		{EventID: "a", WrappedID: "w1", Hops: 2, SubmittedAt: submitted, Results: []JournalResult{{Relay: "wss://r1", OK: true}, {Relay: "wss://r2", Error: "timeout"}}},
+		{EventID: "b", Error: "error: mining-timeout:1m0s"},
+		{EventID: "a", WrappedID: "w2", Results: []JournalResult{{Relay: "wss://r1", Error: "blocked"}}},
+	}
+	for _, entry := range entries {
+		if err := journal.Record(entry); err != nil {
+			t.Fatalf("Record() error = %v", err)
+		}
+	}
+	journal.Close()
+
+	got, err := LookupJournal(path, "a", 0)
+	if err != nil {
+		t.Fatalf("LookupJournal() error = %v", err)
+	}
+	if len(got) != 2 || got[0].WrappedID != "w1" || !got[0].SubmittedAt.Equal(submitted) || !got[0].Dispatched() || got[1].Dispatched() {
+		t.Errorf("LookupJournal() = %+v", got)
+	}
+	if got, _ := LookupJournal(path, "missing", 0); len(got) != 0 {
+		t.Errorf("LookupJournal() of an unknown event = %+v", got)
+	}
+
+	// Reopening appends to the existing journal
+	journal, _ = NewJournal(path, 0, 0)
+	journal.Record(JournalEntry{EventID: "b"})
+	journal.Close()
+	if got, _ := LookupJournal(path, "b", 0); len(got) != 2 {
+		t.Errorf("LookupJournal() after reopening = %d entries, want 2", len(got))
+	}
+
+	var disabled *Journal
+	if err := disabled.Record(JournalEntry{EventID: "a"}); err != nil {
+		t.Errorf("Record() on a nil journal error = %v", err)
+	}
+}
+
+func TestJournal_Rotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "journal.jsonl")
+	journal, err := NewJournal(path, 200, 2)
+	if err != nil {
+		t.Fatalf("NewJournal() error = %v", err)
+	}
+	defer journal.Close()
+
+	eventID := strings.Repeat("e", 64)
+	for i := 0; i < 6; i++ {
+		if err := journal.Record(JournalEntry{EventID: eventID}); err != nil {
+			t.Fatalf("Record() error = %v", err)
+		}
+	}
+
+	files, _ := filepath.Glob(path + "*")
+	if len(files) != 3 {
+		t.Errorf("journal files = %v, want the journal and 2 rotated files", files)
+	}
+	for _, file := range files {
+		if info, _ := os.Stat(file); info.Size() > 200 {
+			t.Errorf("%s is %d bytes, want at most 200", file, info.Size())
+		}
+	}
+	// Entries beyond the kept files are gone, the rest can still be looked up
+	if got, _ := LookupJournal(path, eventID, 2); len(got) != 3 {
+		t.Errorf("LookupJournal() = %d entries, want 3", len(got))
+	}
+}
+
+func TestNewJournal_Invalid(t *testing.T) {
+	if _, err := NewJournal("", 0, 0); err == nil {
+		t.Error("NewJournal() should require a path")
+	}
+	if _, err := NewJournal(filepath.Join(t.TempDir(), "j"), -1, 0); err == nil {
+		t.Error("NewJournal() should reject a negative size")
+	}
+}
+
+func TestJournal_Entries(t *testing.T) {
+	store, err := storage.Open(filepath.Join(t.TempDir(), "journal.jsonl"), JournalStorage(storage.Options{}))
+	if err != nil {
+		t.Fatalf("storage.Open() error = %v", err)
+	}
+	journal := NewJournalWithStorage(store)
+	defer journal.Close()
+
+	base := time.Now().Truncate(time.Second)
+	for i, id := range []string{"a", "b", "c"} {
+		journal.Record(JournalEntry{EventID: id, FinishedAt: base.Add(time.Duration(i) * time.Minute)})
+	}
+	got, err := journal.Entries(base.Add(time.Minute), time.Time{})
+	if err != nil {
+		t.Fatalf("Entries() error = %v", err)
+	}
+	if len(got) != 2 || got[0].EventID != "b" || got[1].EventID != "c" {
+		t.Errorf("Entries() = %+v, want b and c", got)
+	}
+}
@@ -0,0 +1,155 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/girino/renoter/internal/config"
+	"github.com/girino/renoter/internal/trailer"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+// trailerWait is how long the trailers of a fast-lane event are waited for after it is published;
+// mixed-lane events also wait out the default longest delay of every hop.
+const trailerWait = 5 * time.Minute
+
+// HopLatency is where one Renoter's share of an event's latency went, from its timing trailer.
+// Durations spanning two machines (Transit) include their clock offset.
+type HopLatency struct {
+	// Hex pubkey of the Renoter
+	Renoter string
+	// From the previous hop forwarding the event (or the client publishing it) to this Renoter
+	// receiving it; 0 if the previous hop is not reported
+	Transit time.Duration
+	// Mixing delay the Renoter held the event for
+	Held time.Duration
+	// Time the Renoter spent on the event besides holding it
+	Processing time.Duration
+}
+
+// LatencyReport is the per-hop latency of one dispatched event, decrypted from the timing
+// trailers the exit Renoter published. Paths longer than config.TrailerSlots only report their
+// last hops.
+type LatencyReport struct {
+	EventID string
+	Hops    []HopLatency
+	// From publishing the container to the exit publishing the event
+	Total time.Duration
+}
+
+// String renders the report on one line.
+func (r LatencyReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "event %s delivered after %v:", r.EventID, r.Total.Round(time.Millisecond))
+	for _, hop := range r.Hops {
+		npub, _ := nip19.EncodePublicKey(hop.Renoter)
+		fmt.Fprintf(&b, " [%s... transit %v, held %v, processing %v]", npub[:16], hop.Transit.Round(time.Millisecond), hop.Held.Round(time.Millisecond), hop.Processing.Round(time.Millisecond))
+	}
+	return b.String()
+}
+
+// latencyReport builds the report of an event published at publishedAt over path from the tags of
+// its trailer event. Decoys and trailers from other Renoters are skipped.
+func latencyReport(eventID string, path Path, publishedAt time.Time, tags nostr.Tags, reportSk string) (LatencyReport, error) {
+	onPath := make(map[string]bool, len(path))
+	for _, key := range path.Keys() {
+		onPath[key] = true
+	}
+	var timings []trailer.Timing
+	for _, tag := range tags {
+		timing, err := trailer.Open(tag, reportSk)
+		if err != nil || !onPath[timing.Renoter] {
+			continue
+		}
+		timings = append(timings, timing)
+	}
+	if len(timings) == 0 {
+		return LatencyReport{}, fmt.Errorf("no readable trailer")
+	}
+
+	report := LatencyReport{EventID: eventID, Total: timings[len(timings)-1].ForwardedAt.Sub(publishedAt)}
+	for i, timing := range timings {
+		hop := HopLatency{
+			Renoter:    timing.Renoter,
+			Held:       timing.Held,
+			Processing: max(timing.ForwardedAt.Sub(timing.ReceivedAt)-timing.Held, 0),
+		}
+		switch {
+		case i > 0:
+			hop.Transit = timing.ReceivedAt.Sub(timings[i-1].ForwardedAt)
+		case len(timings) == len(path):
+			hop.Transit = timing.ReceivedAt.Sub(publishedAt)
+		}
+		report.Hops = append(report.Hops, hop)
+	}
+	return report, nil
+}
+
+// trailerWatch is the subscription to the trailer event of one dispatched event.
+type trailerWatch struct {
+	reportSk    string
+	events      chan nostr.RelayEvent
+	publishedAt time.Time
+	cancel      context.CancelFunc
+}
+
+// watchTrailers subscribes to the trailer event addressed to the public key of reportSk on the
+// server relays. Call it right before publishing the container.
+func (d *Dispatcher) watchTrailers(ctx context.Context, reportSk string) *trailerWatch {
+	reportPubkey, _ := nostr.GetPublicKey(reportSk)
+	ctx, cancel := context.WithCancel(ctx)
+	since := nostr.Now()
+	events := d.observer.SubscribeMany(ctx, d.serverRelayURLs, nostr.Filter{
+		Kinds: []int{config.TrailerEventKind},
+		Tags:  nostr.TagMap{"p": []string{reportPubkey}},
+		Since: &since,
+	})
+	return &trailerWatch{reportSk: reportSk, events: events, publishedAt: time.Now(), cancel: cancel}
+}
+
+// stop ends the subscription; w may be nil.
+func (w *trailerWatch) stop() {
+	if w != nil {
+		w.cancel()
+	}
+}
+
+// awaitTrailers waits for the trailer event of an event dispatched over path on w, then logs the
+// report and records it in Stats.
+func (d *Dispatcher) awaitTrailers(ctx context.Context, w *trailerWatch, eventID string, path Path, lane string) {
+	defer d.wg.Done()
+	defer w.stop()
+	wait := trailerWait
+	if lane == config.LaneMixed {
+		wait += time.Duration(len(path)) * config.DefaultMaxMixingDelay
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+			logging.DebugMethod("client.latency", "awaitTrailers", "No timing trailers for event %s after %v", eventID, wait)
+			return
+		case relayEvent, ok := <-w.events:
+			if !ok {
+				return
+			}
+			report, err := latencyReport(eventID, path, w.publishedAt, relayEvent.Event.Tags, w.reportSk)
+			if err != nil {
+				logging.DebugMethod("client.latency", "awaitTrailers", "Ignoring trailer event %s: %v", relayEvent.Event.ID, err)
+				continue
+			}
+			logging.Info("client.latency.awaitTrailers: Latency of %s", report)
+			if d.opts.Stats != nil {
+				d.opts.Stats.recordLatency(report)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package client
+
+import (
+	"context"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/girino/renoter/internal/config"
+	"github.com/girino/renoter/internal/trailer"
+	"github.com/girino/renoter/pkg/server"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestDispatcher_ReportsLatency(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	relay, _, pool := newDispatcherTestSetup(t, ctx)
+	var path Path
+	for i := 0; i < 2; i++ {
+		renoter, err := server.NewRenoter(ctx, nostr.GeneratePrivateKey(), []string{relay.URL()})
+		if err != nil {
+			t.Fatalf("NewRenoter() error = %v", err)
+		}
+		if err := renoter.SubscribeToWrappedEvents(ctx); err != nil {
+			t.Fatalf("SubscribeToWrappedEvents() error = %v", err)
+		}
+		pubkey, _ := hex.DecodeString(renoter.GetPublicKey())
+		path = append(path, NewPath(pubkey)...)
+	}
+
+	// Intermediate hops only ever see a constant number of trailers
+	containers := nostr.NewSimplePool(ctx).SubscribeMany(ctx, []string{relay.URL()}, nostr.Filter{Kinds: []int{config.StandardizedWrapperKind}})
+	time.Sleep(200 * time.Millisecond)
+
+	opts := DefaultOptions()
+	opts.ReportLatency = true
+	opts.Stats = NewStats()
+	dispatcher, err := NewDispatcher(ctx, path, pool, []string{relay.URL()}, opts)
+	if err != nil {
+		t.Fatalf("NewDispatcher() error = %v", err)
+	}
+	event := newDispatcherTestEvent()
+	if _, err := dispatcher.Publish(ctx, event); err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+
+	for seen := 0; seen < 2; seen++ {
+		select {
+		case relayEvent := <-containers:
+			trailers := 0
+			for _, tag := range relayEvent.Event.Tags {
+				if tag[0] == config.TrailerTag {
+					trailers++
+				}
+			}
+			if trailers != config.TrailerSlots {
+				t.Errorf("container %d carries %d trailers, want %d", seen, trailers, config.TrailerSlots)
+			}
+		case <-ctx.Done():
+			t.Fatal("containers not observed")
+		}
+	}
+
+	for {
+		if latency := opts.Stats.Snapshot().Latency; len(latency) == 2 {
+			for _, node := range path {
+				if latency[node.Key()].Reports != 1 {
+					t.Errorf("Renoter %s reported %d times, want 1", node.Key()[:8], latency[node.Key()].Reports)
+				}
+			}
+			return
+		}
+		select {
+		case <-time.After(50 * time.Millisecond):
+		case <-ctx.Done():
+			t.Fatalf("no latency report, stats %+v", opts.Stats.Snapshot().Latency)
+		}
+	}
+}
+
+func TestLatencyReport_SkipsDecoysAndStrangers(t *testing.T) {
+	reportSk := nostr.GeneratePrivateKey()
+	reportPk, _ := nostr.GetPublicKey(reportSk)
+	path := testRenoters(2)
+	published := time.UnixMilli(1700000000000)
+
+	tags, _ := trailer.Decoys()
+	for i, node := range append(path, testRenoters(3)[2]) {
+		tag, err := trailer.Seal(trailer.Timing{
+			Renoter:     node.Key(),
+			ReceivedAt:  published.Add(time.Duration(i*100+10) * time.Millisecond),
+			ForwardedAt: published.Add(time.Duration(i*100+60) * time.Millisecond),
+			Held:        20 * time.Millisecond,
+		}, reportPk)
+		if err != nil {
+			t.Fatalf("Seal() error = %v", err)
+		}
+		tags = append(tags, tag)
+	}
+
+	report, err := latencyReport("event", path, published, tags, reportSk)
+	if err != nil {
+		t.Fatalf("latencyReport() error = %v", err)
+	}
+	if len(report.Hops) != 2 {
+		t.Fatalf("latencyReport() = %d hops, want the 2 on the path", len(report.Hops))
+	}
+	first, second := report.Hops[0], report.Hops[1]
+	if first.Transit != 10*time.Millisecond || first.Held != 20*time.Millisecond || first.Processing != 30*time.Millisecond {
+		t.Errorf("first hop = %+v", first)
+	}
+	if second.Transit != 50*time.Millisecond || report.Total != 160*time.Millisecond {
+		t.Errorf("second hop transit %v, total %v; want 50ms and 160ms", second.Transit, report.Total)
+	}
+	if _, err := latencyReport("event", path, published, tags, nostr.GeneratePrivateKey()); err == nil {
+		t.Error("latencyReport() with the wrong key should fail")
+	}
+}
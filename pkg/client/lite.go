@@ -0,0 +1,46 @@
+package client
+
+import (
+	"slices"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/girino/renoter/internal/config"
+	"github.com/girino/renoter/internal/descriptor"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// supportsLiteProfile reports whether the Renoter of node announced the lite profile.
+func supportsLiteProfile(node PathNode) bool {
+	return node.Descriptor != nil && slices.Contains(node.Descriptor.Profiles, descriptor.ProfileLite)
+}
+
+// countLiteProfile returns how many Renoters of the path announced the lite profile.
+func countLiteProfile(renterPath Path) int {
+	count := 0
+	for _, node := range renterPath {
+		if supportsLiteProfile(node) {
+			count++
+		}
+	}
+	return count
+}
+
+// wrapProfile returns the size budget and the layer PoW difficulty to wrap originalEvent with
+// over renterPath: the lite profile's if opts.LiteProfile is set, every Renoter on the path
+// supports it and the event fits it, the full profile's otherwise. Both leave room for the payment
+// proofs and Cashu tokens of the Renoters on the path.
+func wrapProfile(originalEvent *nostr.Event, renterPath Path, opts Options) (SizeBudget, int) {
+	payments := opts.paymentLayers(renterPath.Keys())
+	full := opts.sizeBudget(len(renterPath), "").withPayments(payments)
+	if !opts.LiteProfile || countLiteProfile(renterPath) < len(renterPath) {
+		return full, config.PoWDifficulty
+	}
+	lite := opts
+	lite.Limits = config.LiteSizeLimits()
+	budget := lite.sizeBudget(len(renterPath), "").withPayments(payments)
+	if size, err := budget.eventSize(originalEvent); err != nil || size > budget.MaxEventSize {
+		return full, config.PoWDifficulty
+	}
+	logging.DebugMethod("client.lite", "wrapProfile", "Wrapping event %s in the lite profile", originalEvent.ID)
+	return budget, config.LitePoWDifficulty
+}
@@ -0,0 +1,56 @@
+package client
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/girino/renoter/internal/config"
+	"github.com/girino/renoter/internal/descriptor"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestWrapProfile(t *testing.T) {
+	newPath := func(lite ...bool) Path {
+		path := make(Path, len(lite))
+		for i, supported := range lite {
+			path[i].PubKey = make([]byte, 32)
+			path[i].PubKey[0] = byte(i)
+			path[i].Descriptor = &descriptor.Descriptor{}
+			if supported {
+				path[i].Descriptor.Profiles = []string{descriptor.ProfileLite}
+			}
+		}
+		return path
+	}
+	newEvent := func(contentSize int) *nostr.Event {
+		event := &nostr.Event{Kind: 1, Content: strings.Repeat("a", contentSize), CreatedAt: nostr.Now(), Tags: nostr.Tags{}}
+		event.Sign(nostr.GeneratePrivateKey())
+		return event
+	}
+	liteOpts := DefaultOptions()
+	liteOpts.LiteProfile = true
+
+	tests := []struct {
+		name     string
+		opts     Options
+		path     Path
+		event    *nostr.Event
+		wantLite bool
+	}{
+		{"small event on a lite path", liteOpts, newPath(true, true), newEvent(100), true},
+		{"lite off", DefaultOptions(), newPath(true, true), newEvent(100), false},
+		{"one Renoter without lite", liteOpts, newPath(true, false), newEvent(100), false},
+		{"event too large for lite", liteOpts, newPath(true, true), newEvent(config.LiteSize), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			budget, difficulty := wrapProfile(tt.event, tt.path, tt.opts)
+			if lite := budget.PaddedSize == config.LiteSize; lite != tt.wantLite {
+				t.Errorf("wrapProfile() padded size = %d, want lite %v", budget.PaddedSize, tt.wantLite)
+			}
+			if want := map[bool]int{true: config.LitePoWDifficulty, false: config.PoWDifficulty}[tt.wantLite]; difficulty != want {
+				t.Errorf("wrapProfile() difficulty = %d, want %d", difficulty, want)
+			}
+		})
+	}
+}
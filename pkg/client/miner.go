@@ -0,0 +1,205 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/girino/renoter/internal/config"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip13"
+)
+
+// PoWMiner mines a NIP-13 nonce tag for an event so that its ID reaches the given difficulty.
+// The returned tag must be appended to the event's tags before it is signed.
+type PoWMiner interface {
+	Mine(ctx context.Context, event nostr.Event, difficulty int) (nostr.Tag, error)
+}
+
+// CPUMiner mines locally on all CPU cores. It is the default miner.
+type CPUMiner struct{}
+
+// Mine implements PoWMiner using nip13.DoWork.
+func (CPUMiner) Mine(ctx context.Context, event nostr.Event, difficulty int) (nostr.Tag, error) {
+	return nip13.DoWork(ctx, event, difficulty)
+}
+
+// DefaultProgressInterval is how many nonces a ProgressMiner tries between reports when its
+// Interval is 0.
+const DefaultProgressInterval = 20000
+
+// MiningProgress is what a ProgressMiner reports while it mines one event.
+type MiningProgress struct {
+	// Difficulty being mined
+	Difficulty int `json:"difficulty"`
+	// Nonces tried so far
+	Attempts uint64 `json:"attempts"`
+	// Whether a nonce reaching the difficulty was found
+	Done bool `json:"done"`
+}
+
+// ProgressMiner mines on the calling goroutine and reports its progress every Interval nonces
+// and once done, e.g. to show progress in a UI, or where there is a single thread anyway, as in
+// WebAssembly. It is slower than CPUMiner wherever there are several cores.
+type ProgressMiner struct {
+	// Called with the progress, on the mining goroutine (nil = no reports)
+	Progress func(MiningProgress)
+	// Nonces tried between reports (0 = DefaultProgressInterval)
+	Interval uint64
+}
+
+// Mine implements PoWMiner.
+func (m ProgressMiner) Mine(ctx context.Context, event nostr.Event, difficulty int) (nostr.Tag, error) {
+	if event.PubKey == "" {
+		return nil, nip13.ErrMissingPubKey
+	}
+	interval := m.Interval
+	if interval == 0 {
+		interval = DefaultProgressInterval
+	}
+	report := func(progress MiningProgress) {
+		if m.Progress != nil {
+			m.Progress(progress)
+		}
+	}
+
+	tag := nostr.Tag{"nonce", "", strconv.Itoa(difficulty)}
+	event.Tags = append(event.Tags[:len(event.Tags):len(event.Tags)], tag)
+	for nonce := uint64(0); ; nonce++ {
+		if nonce > 0 && nonce%interval == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, fmt.Errorf("mining cancelled after %d attempts: %w", nonce, err)
+			}
+			report(MiningProgress{Difficulty: difficulty, Attempts: nonce})
+		}
+		tag[1] = strconv.FormatUint(nonce, 10)
+		if nip13.Difficulty(event.GetID()) >= difficulty {
+			report(MiningProgress{Difficulty: difficulty, Attempts: nonce + 1, Done: true})
+			logging.DebugMethod("client.miner", "Mine", "Found difficulty %d after %d attempts", difficulty, nonce+1)
+			return tag, nil
+		}
+	}
+}
+
+// powRequest is the body HTTPMiner posts to a mining service.
+type powRequest struct {
+	Event      nostr.Event `json:"event"`
+	Difficulty int         `json:"difficulty"`
+}
+
+// powResponse is the body a mining service answers with.
+type powResponse struct {
+	Nonce nostr.Tag `json:"nonce,omitempty"`
+	Error string    `json:"error,omitempty"`
+}
+
+// maxPoWRequestSize bounds request and response bodies: an unsigned wrapper is never
+// larger than the largest standardized size plus its JSON envelope.
+const maxPoWRequestSize = 2 * config.MaxStandardizedSize
+
+// HTTPMiner delegates mining to a remote service (see NewPoWHandler), so the heavy work can run
+// on a separate machine or a service shared by several clients. Only unsigned wrapper events are
+// sent; their content is already encrypted for the next Renoter. Returned nonces are verified locally.
+type HTTPMiner struct {
+	// URL of the mining endpoint
+	URL string
+	// HTTP client used for requests; mining time is bounded by the request context
+	Client *http.Client
+}
+
+// NewHTTPMiner creates an HTTPMiner for the given mining endpoint URL.
+func NewHTTPMiner(url string) *HTTPMiner {
+	return &HTTPMiner{URL: url, Client: &http.Client{}}
+}
+
+// Mine implements PoWMiner by posting the event to the remote service.
+func (m *HTTPMiner) Mine(ctx context.Context, event nostr.Event, difficulty int) (nostr.Tag, error) {
+	body, err := json.Marshal(powRequest{Event: event, Difficulty: difficulty})
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize mining request: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create mining request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	logging.DebugMethod("client.miner", "Mine", "Delegating PoW (difficulty %d) to %s", difficulty, m.URL)
+	start := time.Now()
+	resp, err := m.Client.Do(req)
+	if err != nil {
+		logging.Error("client.miner.Mine: mining request to %s failed: %v", m.URL, err)
+		return nil, fmt.Errorf("mining request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result powResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxPoWRequestSize)).Decode(&result); err != nil {
+		logging.Error("client.miner.Mine: invalid response from %s (status %d): %v", m.URL, resp.StatusCode, err)
+		return nil, fmt.Errorf("invalid mining response (status %d): %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK || result.Error != "" {
+		logging.Error("client.miner.Mine: mining service %s returned status %d: %s", m.URL, resp.StatusCode, result.Error)
+		return nil, fmt.Errorf("mining service error (status %d): %s", resp.StatusCode, result.Error)
+	}
+
+	// Never trust the remote: the nonce must actually produce the requested difficulty
+	if len(result.Nonce) < 2 || result.Nonce[0] != "nonce" {
+		return nil, fmt.Errorf("mining service returned an invalid nonce tag: %v", result.Nonce)
+	}
+	event.Tags = append(event.Tags[:len(event.Tags):len(event.Tags)], result.Nonce)
+	if got := nip13.Difficulty(event.GetID()); got < difficulty {
+		logging.Error("client.miner.Mine: mining service %s returned nonce with difficulty %d, want %d", m.URL, got, difficulty)
+		return nil, fmt.Errorf("mining service returned nonce with difficulty %d, want %d", got, difficulty)
+	}
+
+	logging.DebugMethod("client.miner", "Mine", "Remote PoW completed in %v", time.Since(start))
+	return result.Nonce, nil
+}
+
+// NewPoWHandler returns an http.Handler serving mining requests from HTTPMiner clients using miner.
+// Requests above maxDifficulty are refused so a shared service cannot be tied up indefinitely.
+func NewPoWHandler(miner PoWMiner, maxDifficulty int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		reply := func(status int, resp powResponse) {
+			w.WriteHeader(status)
+			json.NewEncoder(w).Encode(resp)
+		}
+
+		if r.Method != http.MethodPost {
+			reply(http.StatusMethodNotAllowed, powResponse{Error: "only POST is supported"})
+			return
+		}
+
+		var req powRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPoWRequestSize)).Decode(&req); err != nil {
+			reply(http.StatusBadRequest, powResponse{Error: "invalid request: " + err.Error()})
+			return
+		}
+		if req.Difficulty < 1 || req.Difficulty > maxDifficulty {
+			reply(http.StatusBadRequest, powResponse{Error: fmt.Sprintf("difficulty must be between 1 and %d", maxDifficulty)})
+			return
+		}
+		if req.Event.PubKey == "" {
+			reply(http.StatusBadRequest, powResponse{Error: "event pubkey is required"})
+			return
+		}
+
+		logging.DebugMethod("client.miner", "PoWHandler", "Mining difficulty %d for %s", req.Difficulty, r.RemoteAddr)
+		nonce, err := miner.Mine(r.Context(), req.Event, req.Difficulty)
+		if err != nil {
+			logging.Warn("client.miner.PoWHandler: mining for %s failed: %v", r.RemoteAddr, err)
+			reply(http.StatusServiceUnavailable, powResponse{Error: err.Error()})
+			return
+		}
+		reply(http.StatusOK, powResponse{Nonce: nonce})
+	})
+}
@@ -0,0 +1,99 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip13"
+)
+
+func newMinerTestEvent(t *testing.T) nostr.Event {
+	t.Helper()
+	pk, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	return nostr.Event{Kind: 29000, Content: "ciphertext", CreatedAt: nostr.Now(), PubKey: pk, Tags: nostr.Tags{{"p", pk}}}
+}
+
+// difficultyWith returns the PoW difficulty the event reaches once nonce is appended.
+func difficultyWith(event nostr.Event, nonce nostr.Tag) int {
+	event.Tags = append(event.Tags[:len(event.Tags):len(event.Tags)], nonce)
+	return nip13.Difficulty(event.GetID())
+}
+
+func TestCPUMiner_Mine(t *testing.T) {
+	event := newMinerTestEvent(t)
+	nonce, err := CPUMiner{}.Mine(context.Background(), event, 8)
+	if err != nil {
+		t.Fatalf("Mine() error = %v", err)
+	}
+	if got := difficultyWith(event, nonce); got < 8 {
+		t.Errorf("mined difficulty = %d, want at least 8", got)
+	}
+}
+
+func TestProgressMiner_Mine(t *testing.T) {
+	event := newMinerTestEvent(t)
+	var reports []MiningProgress
+	miner := ProgressMiner{Interval: 1, Progress: func(p MiningProgress) { reports = append(reports, p) }}
+	nonce, err := miner.Mine(context.Background(), event, 10)
+	if err != nil {
+		t.Fatalf("Mine() error = %v", err)
+	}
+	if got := difficultyWith(event, nonce); got < 10 {
+		t.Errorf("mined difficulty = %d, want at least 10", got)
+	}
+	// One report per attempt after the first, and a final one
+	last := reports[len(reports)-1]
+	if !last.Done || last.Difficulty != 10 || last.Attempts != uint64(len(reports)) {
+		t.Errorf("last of %d progress reports = %+v, want a done report counting every attempt", len(reports), last)
+	}
+}
+
+func TestProgressMiner_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := (ProgressMiner{Interval: 1}).Mine(ctx, newMinerTestEvent(t), 64); err == nil {
+		t.Error("Mine() succeeded with a cancelled context")
+	}
+}
+
+func TestHTTPMiner_Mine(t *testing.T) {
+	service := httptest.NewServer(NewPoWHandler(CPUMiner{}, 20))
+	defer service.Close()
+
+	event := newMinerTestEvent(t)
+	nonce, err := NewHTTPMiner(service.URL).Mine(context.Background(), event, 8)
+	if err != nil {
+		t.Fatalf("Mine() error = %v", err)
+	}
+	if got := difficultyWith(event, nonce); got < 8 {
+		t.Errorf("mined difficulty = %d, want at least 8", got)
+	}
+}
+
+func TestHTTPMiner_RejectsInsufficientNonce(t *testing.T) {
+	// A misbehaving service answers with a nonce that does not meet the difficulty
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(powResponse{Nonce: nostr.Tag{"nonce", "1", "30"}})
+	}))
+	defer service.Close()
+
+	_, err := NewHTTPMiner(service.URL).Mine(context.Background(), newMinerTestEvent(t), 30)
+	if err == nil || !strings.Contains(err.Error(), "difficulty") {
+		t.Errorf("Mine() error = %v, want difficulty mismatch", err)
+	}
+}
+
+func TestPoWHandler_RejectsExcessiveDifficulty(t *testing.T) {
+	service := httptest.NewServer(NewPoWHandler(CPUMiner{}, 16))
+	defer service.Close()
+
+	_, err := NewHTTPMiner(service.URL).Mine(context.Background(), newMinerTestEvent(t), 17)
+	if err == nil || !strings.Contains(err.Error(), "status 400") {
+		t.Errorf("Mine() error = %v, want status 400", err)
+	}
+}
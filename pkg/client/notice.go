@@ -0,0 +1,119 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/girino/renoter/internal/config"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip44"
+)
+
+// ErrorNotice is a Renoter's notice that it rejected a wrapped event, telling a refused event
+// apart from one a relay lost.
+type ErrorNotice struct {
+	// Position of the Renoter on the path, 0 for the entry
+	Hop int
+	// Hex pubkey of the Renoter
+	Renoter string
+	// Pipeline stage that rejected the event, e.g. "admission" (may be empty)
+	Stage string
+	// Why, e.g. "quota" or "malformed"
+	Reason string
+}
+
+// WrapEventWithNotices is like WrapEvent but asks every Renoter on the path to send an
+// encrypted error notice should it reject the event, and returns the fresh reply key to read them
+// with. Each layer carries a sealed reply tag, so the largest accepted event is slightly smaller.
+// Renoters announcing a protocol version before config.ErrorNoticesVersion would reject the
+// layers, so they are refused.
+func WrapEventWithNotices(ctx context.Context, originalEvent *nostr.Event, renterPath Path, opts WrapOptions) (*nostr.Event, string, error) {
+	for _, node := range renterPath {
+		if node.Descriptor != nil && node.Descriptor.Version < config.ErrorNoticesVersion {
+			logging.Error("client.notice.WrapEventWithNotices: Renoter %s does not speak protocol version %d", node.Key(), config.ErrorNoticesVersion)
+			return nil, "", fmt.Errorf("renoter %s does not speak protocol version %d needed for error notices", node.Key(), config.ErrorNoticesVersion)
+		}
+	}
+	replySk := nostr.GeneratePrivateKey()
+	replyPubkey, err := nostr.GetPublicKey(replySk)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to derive reply pubkey: %w", err)
+	}
+	opts.ReportLatency = false
+	opts.replyTo = replyPubkey
+	wrapped, _, err := wrapEvent(ctx, originalEvent, renterPath, opts, "")
+	if err != nil {
+		return nil, "", err
+	}
+	return wrapped, replySk, nil
+}
+
+// AwaitErrorNotices subscribes on relayURLs to the error notices sent to the reply key replySk
+// returned by WrapEventWithNotices, and delivers those from Renoters on renterPath as they arrive
+// until ctx is done. Call it before publishing the container. A rejected event goes no further,
+// so at most one notice is expected; silence means the event was not refused, though a relay may
+// still have lost it.
+func AwaitErrorNotices(ctx context.Context, pool Pool, relayURLs []string, replySk string, renterPath Path) (<-chan ErrorNotice, error) {
+	replyPubkey, err := nostr.GetPublicKey(replySk)
+	if err != nil {
+		return nil, fmt.Errorf("invalid reply key: %w", err)
+	}
+	since := nostr.Now()
+	events := pool.SubscribeMany(ctx, relayURLs, nostr.Filter{
+		Kinds: []int{config.ErrorNoticeKind},
+		Tags:  nostr.TagMap{"p": []string{replyPubkey}},
+		Since: &since,
+	})
+
+	notices := make(chan ErrorNotice, len(renterPath))
+	go func() {
+		defer close(notices)
+		reported := make(map[int]bool)
+		for relayEvent := range events {
+			notice, err := openErrorNotice(relayEvent.Event, replySk, renterPath)
+			if err != nil {
+				logging.DebugMethod("client.notice", "AwaitErrorNotices", "Ignoring error notice %s: %v", relayEvent.Event.ID, err)
+				continue
+			}
+			if reported[notice.Hop] {
+				continue
+			}
+			reported[notice.Hop] = true
+			logging.Warn("client.notice.AwaitErrorNotices: Renoter %s (hop %d) rejected the event at %q: %s", notice.Renoter, notice.Hop, notice.Stage, notice.Reason)
+			select {
+			case notices <- notice:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return notices, nil
+}
+
+// openErrorNotice decrypts an ErrorNoticeKind event with replySk and checks it names a Renoter
+// on renterPath.
+func openErrorNotice(event *nostr.Event, replySk string, renterPath Path) (ErrorNotice, error) {
+	if ok, err := event.CheckSignature(); !ok {
+		return ErrorNotice{}, fmt.Errorf("invalid signature: %v", err)
+	}
+	conversationKey, err := nip44.GenerateConversationKey(event.PubKey, replySk)
+	if err != nil {
+		return ErrorNotice{}, fmt.Errorf("failed to generate conversation key: %w", err)
+	}
+	plaintext, err := nip44.Decrypt(event.Content, conversationKey)
+	if err != nil {
+		return ErrorNotice{}, fmt.Errorf("failed to decrypt: %w", err)
+	}
+	var notice config.ErrorNotice
+	if err := json.Unmarshal([]byte(plaintext), &notice); err != nil {
+		return ErrorNotice{}, fmt.Errorf("invalid error notice: %w", err)
+	}
+	for hop, node := range renterPath {
+		if node.Key() == notice.Renoter {
+			return ErrorNotice{Hop: hop, Renoter: notice.Renoter, Stage: notice.Stage, Reason: notice.Reason}, nil
+		}
+	}
+	return ErrorNotice{}, fmt.Errorf("renoter %s is not on the path", notice.Renoter)
+}
@@ -0,0 +1,75 @@
+package client
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/girino/renoter/internal/config"
+	"github.com/girino/renoter/internal/descriptor"
+	"github.com/girino/renoter/pkg/server"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestAwaitErrorNotices(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	relay, _, _ := newDispatcherTestSetup(t, ctx)
+	var path Path
+	for i := 0; i < 2; i++ {
+		renoter, err := server.NewRenoter(ctx, nostr.GeneratePrivateKey(), []string{relay.URL()})
+		if err != nil {
+			t.Fatalf("NewRenoter() error = %v", err)
+		}
+		if i == 1 {
+			// The exit refuses every event
+			renoter.Pipeline().InsertBefore(server.StageForward, server.Stage{
+				Name: "refuse",
+				Run: func(ctx context.Context, msg *server.Message) error {
+					return fmt.Errorf("refused")
+				},
+			})
+		}
+		if err := renoter.SubscribeToWrappedEvents(ctx); err != nil {
+			t.Fatalf("SubscribeToWrappedEvents() error = %v", err)
+		}
+		pubkey, _ := hex.DecodeString(renoter.GetPublicKey())
+		path = append(path, NewPath(pubkey)...)
+	}
+
+	wrapped, replySk, err := WrapEventWithNotices(ctx, newDispatcherTestEvent(), path, DefaultWrapOptions())
+	if err != nil {
+		t.Fatalf("WrapEventWithNotices() error = %v", err)
+	}
+	pool := nostr.NewSimplePool(ctx)
+	notices, err := AwaitErrorNotices(ctx, pool, []string{relay.URL()}, replySk, path)
+	if err != nil {
+		t.Fatalf("AwaitErrorNotices() error = %v", err)
+	}
+	time.Sleep(subscriptionWarmUp)
+	for result := range pool.PublishMany(ctx, []string{relay.URL()}, *wrapped) {
+		if result.Error != nil {
+			t.Fatalf("PublishMany() error = %v", result.Error)
+		}
+	}
+
+	select {
+	case notice := <-notices:
+		if notice.Hop != 1 || notice.Renoter != path[1].Key() || notice.Stage != "refuse" {
+			t.Errorf("error notice = %+v, want one from the exit's refuse stage", notice)
+		}
+	case <-ctx.Done():
+		t.Fatal("no error notice before the deadline")
+	}
+}
+
+func TestWrapEventWithNotices_RefusesOldRenoters(t *testing.T) {
+	path := testRenoters(2)
+	path[0].Descriptor = &descriptor.Descriptor{Version: config.ErrorNoticesVersion - 1}
+	if _, _, err := WrapEventWithNotices(context.Background(), newDispatcherTestEvent(), path, DefaultWrapOptions()); err == nil {
+		t.Error("WrapEventWithNotices() accepted a Renoter without error notices")
+	}
+}
@@ -4,99 +4,175 @@ import (
 	"encoding/hex"
 	"fmt"
 	"math/rand"
+	"slices"
 	"time"
 
 	"github.com/girino/nostr-lib/logging"
+	"github.com/girino/renoter/internal/descriptor"
+	"github.com/nbd-wtf/go-nostr"
 	"github.com/nbd-wtf/go-nostr/nip19"
 )
 
-// ValidatePath validates a slice of npub strings using NIP-19 decoding.
-// Returns an error if any npub is invalid, otherwise returns the decoded public keys.
-func ValidatePath(npubs []string) ([][]byte, error) {
-	logging.DebugMethod("client.path", "ValidatePath", "Validating Renoter path with %d npubs", len(npubs))
+// PathNode is one Renoter a path routes through.
+type PathNode struct {
+	// 32-byte public key
+	PubKey []byte
+	// Relays the Renoter is known to use, e.g. the hints of its nprofile (empty if unknown).
+	// Its service descriptor is looked up there besides the server relays.
+	Relays []string
+	// Service descriptor announcing its capabilities (nil until looked up, or if it has none)
+	Descriptor *descriptor.Descriptor
+}
 
-	if len(npubs) == 0 {
-		logging.Error("client.path.ValidatePath: path cannot be empty")
-		return nil, fmt.Errorf("path cannot be empty")
+// Key returns the node's pubkey as hex, as used in tags and by the policy maps.
+func (n PathNode) Key() string {
+	return hex.EncodeToString(n.PubKey)
+}
+
+// Path is an ordered list of Renoters: the configured Renoters an event's path is drawn from,
+// or the hops of one event from the entry to the exit.
+type Path []PathNode
+
+// NewPath builds a path from raw 32-byte pubkeys, without relay hints or descriptors.
+func NewPath(pubkeys ...[]byte) Path {
+	path := make(Path, len(pubkeys))
+	for i, pubkey := range pubkeys {
+		path[i] = PathNode{PubKey: pubkey}
 	}
+	return path
+}
 
-	publicKeys := make([][]byte, len(npubs))
-	for i, npub := range npubs {
-		logging.DebugMethod("client.path", "ValidatePath", "Validating npub %d/%d: %s", i+1, len(npubs), npub)
-		if npub == "" {
-			logging.Error("client.path.ValidatePath: npub at index %d is empty", i)
-			return nil, fmt.Errorf("npub at index %d is empty", i)
+// Keys returns the hex pubkeys of the path in order.
+func (p Path) Keys() []string {
+	keys := make([]string, len(p))
+	for i, node := range p {
+		keys[i] = node.Key()
+	}
+	return keys
+}
+
+// RelayHints returns the relays of every node, without duplicates.
+func (p Path) RelayHints() []string {
+	var hints []string
+	for _, node := range p {
+		for _, relay := range node.Relays {
+			if !slices.Contains(hints, relay) {
+				hints = append(hints, relay)
+			}
 		}
+	}
+	return hints
+}
 
-		logging.DebugMethod("client.path", "ValidatePath", "Decoding npub %d with NIP-19", i)
-		prefix, data, err := nip19.Decode(npub)
-		if err != nil {
-			logging.Error("client.path.ValidatePath: failed to decode npub at index %d: %v", i, err)
-			return nil, fmt.Errorf("failed to decode npub at index %d: %w", i, err)
+// withDescriptors returns a copy of the path with each node's descriptor from descriptors
+// (keyed by hex pubkey).
+func (p Path) withDescriptors(descriptors map[string]*descriptor.Descriptor) Path {
+	path := slices.Clone(p)
+	for i := range path {
+		path[i].Descriptor = descriptors[path[i].Key()]
+	}
+	return path
+}
+
+// ParsePathNode decodes an npub, an nprofile (keeping its relay hints) or a 64-character hex
+// pubkey, so paths can be built from data copied out of other tools.
+func ParsePathNode(entry string) (PathNode, error) {
+	if entry == "" {
+		return PathNode{}, fmt.Errorf("entry is empty")
+	}
+
+	if len(entry) == 64 {
+		if !nostr.IsValidPublicKey(entry) {
+			return PathNode{}, fmt.Errorf("invalid hex pubkey")
 		}
+		pubkey, _ := hex.DecodeString(entry)
+		return PathNode{PubKey: pubkey}, nil
+	}
 
+	prefix, data, err := nip19.Decode(entry)
+	if err != nil {
+		return PathNode{}, fmt.Errorf("failed to decode: %w", err)
+	}
+	var pubkeyHex string
+	var relays []string
+	switch pointer := data.(type) {
+	case string:
 		if prefix != "npub" {
-			logging.Error("client.path.ValidatePath: npub at index %d has invalid prefix: %s", i, prefix)
-			return nil, fmt.Errorf("npub at index %d is not a valid npub (prefix: %s)", i, prefix)
+			return PathNode{}, fmt.Errorf("not an npub, nprofile or hex pubkey (prefix: %s)", prefix)
 		}
+		pubkeyHex = pointer
+	case nostr.ProfilePointer:
+		pubkeyHex, relays = pointer.PublicKey, pointer.Relays
+	case *nostr.ProfilePointer:
+		pubkeyHex, relays = pointer.PublicKey, pointer.Relays
+	default:
+		return PathNode{}, fmt.Errorf("not an npub, nprofile or hex pubkey (prefix: %s)", prefix)
+	}
 
-		// nip19.Decode returns npub as a hex-encoded string, not []byte
-		pubkeyHex, ok := data.(string)
-		if !ok {
-			logging.Error("client.path.ValidatePath: npub at index %d decoded to unexpected type (expected string, got %T)", i, data)
-			return nil, fmt.Errorf("npub at index %d decoded to unexpected type", i)
+	pubkey, err := hex.DecodeString(pubkeyHex)
+	if err != nil || len(pubkey) != 32 {
+		return PathNode{}, fmt.Errorf("invalid pubkey in %s", prefix)
+	}
+	// Only well-formed relay hints are kept
+	hints := make([]string, 0, len(relays))
+	for _, relay := range relays {
+		if nostr.IsValidRelayURL(relay) {
+			hints = append(hints, nostr.NormalizeURL(relay))
 		}
+	}
+	return PathNode{PubKey: pubkey, Relays: hints}, nil
+}
 
-		// Decode hex string to bytes
-		displayLen := 32
-		if len(pubkeyHex) < displayLen {
-			displayLen = len(pubkeyHex)
-		}
-		logging.DebugMethod("client.path", "ValidatePath", "Decoding hex pubkey: %s (first %d chars)", pubkeyHex[:displayLen], displayLen)
-		pubkey, err := hex.DecodeString(pubkeyHex)
-		if err != nil {
-			logging.Error("client.path.ValidatePath: failed to decode hex pubkey at index %d: %v", i, err)
-			return nil, fmt.Errorf("npub at index %d has invalid hex encoding: %w", i, err)
-		}
+// ValidatePath decodes every entry of a path, an npub, nprofile or hex pubkey (see ParsePathNode).
+// Returns an error if any entry is invalid or a Renoter appears twice.
+func ValidatePath(entries []string) (Path, error) {
+	logging.DebugMethod("client.path", "ValidatePath", "Validating Renoter path with %d entries", len(entries))
 
-		if len(pubkey) != 32 {
-			logging.Error("client.path.ValidatePath: npub at index %d has invalid length: %d bytes (expected 32)", i, len(pubkey))
-			return nil, fmt.Errorf("npub at index %d has invalid length: %d bytes (expected 32)", i, len(pubkey))
-		}
+	if len(entries) == 0 {
+		logging.Error("client.path.ValidatePath: path cannot be empty")
+		return nil, fmt.Errorf("path cannot be empty")
+	}
 
-		publicKeys[i] = pubkey
-		logging.DebugMethod("client.path", "ValidatePath", "Successfully validated npub %d", i)
+	path := make(Path, len(entries))
+	for i, entry := range entries {
+		logging.DebugMethod("client.path", "ValidatePath", "Validating entry %d/%d: %s", i+1, len(entries), entry)
+		node, err := ParsePathNode(entry)
+		if err != nil {
+			logging.Error("client.path.ValidatePath: invalid entry at index %d: %v", i, err)
+			return nil, fmt.Errorf("entry at index %d: %w", i, err)
+		}
+		path[i] = node
 	}
 
 	// Check for duplicate Renoters in the path
 	// This prevents routing loops and ensures proper anonymization
 	seen := make(map[string]int) // Map pubkey hex to first occurrence index
 
-	for i, pubkey := range publicKeys {
-		pubkeyHex := hex.EncodeToString(pubkey)
+	for i, node := range path {
+		pubkeyHex := node.Key()
 		if firstIndex, exists := seen[pubkeyHex]; exists {
 			// Found duplicate - return error
 			logging.Error("client.path.ValidatePath: Duplicate Renoter pubkey detected at index %d (duplicates index %d): %s (first 16 chars)", i, firstIndex, pubkeyHex[:16])
-			return nil, fmt.Errorf("duplicate Renoters in path: npub at index %d duplicates npub at index %d (pubkey: %s...)", i, firstIndex, pubkeyHex[:16])
+			return nil, fmt.Errorf("duplicate Renoters in path: entry at index %d duplicates entry at index %d (pubkey: %s...)", i, firstIndex, pubkeyHex[:16])
 		}
 		seen[pubkeyHex] = i
 	}
 
-	logging.Info("client.path.ValidatePath: Successfully validated all %d npubs in Renoter path (no duplicates)", len(npubs))
-	return publicKeys, nil
+	logging.Info("client.path.ValidatePath: Successfully validated all %d entries in Renoter path (no duplicates)", len(entries))
+	return path, nil
 }
 
 // ShufflePath randomly shuffles the Renoter path to randomize routing order.
 // This improves privacy by ensuring events don't always follow the same path.
 // Returns a new slice with shuffled order (original slice is not modified).
-func ShufflePath(path [][]byte) [][]byte {
+func ShufflePath(path Path) Path {
 	if len(path) <= 1 {
 		// No need to shuffle if path has 0 or 1 Renoters
 		return path
 	}
 
 	// Create a copy to avoid modifying the original
-	shuffled := make([][]byte, len(path))
+	shuffled := make(Path, len(path))
 	copy(shuffled, path)
 
 	// Use current time as seed for randomness
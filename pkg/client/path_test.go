@@ -1,8 +1,11 @@
 package client
 
 import (
+	"encoding/hex"
+	"strings"
 	"testing"
 
+	"github.com/girino/renoter/internal/descriptor"
 	"github.com/nbd-wtf/go-nostr"
 	"github.com/nbd-wtf/go-nostr/nip19"
 )
@@ -83,8 +86,8 @@ func TestValidatePath_ValidatesCorrectPubkeys(t *testing.T) {
 	}
 
 	// Verify the public keys match
-	if len(result[0]) != 32 || len(result[1]) != 32 {
-		t.Errorf("ValidatePath() public keys should be 32 bytes, got %d and %d", len(result[0]), len(result[1]))
+	if len(result[0].PubKey) != 32 || len(result[1].PubKey) != 32 {
+		t.Errorf("ValidatePath() public keys should be 32 bytes, got %d and %d", len(result[0].PubKey), len(result[1].PubKey))
 	}
 }
 
@@ -127,14 +130,14 @@ func TestShufflePath(t *testing.T) {
 	// So we'll just verify the function works without errors
 
 	// Test with empty path
-	emptyPath := [][]byte{}
+	emptyPath := Path{}
 	shuffledEmpty := ShufflePath(emptyPath)
 	if len(shuffledEmpty) != 0 {
 		t.Errorf("ShufflePath() with empty path should return empty, got length %d", len(shuffledEmpty))
 	}
 
 	// Test with single element
-	singlePath := [][]byte{path[0]}
+	singlePath := Path{path[0]}
 	shuffledSingle := ShufflePath(singlePath)
 	if len(shuffledSingle) != 1 {
 		t.Errorf("ShufflePath() with single element should return single element, got length %d", len(shuffledSingle))
@@ -149,7 +152,7 @@ func TestShufflePath(t *testing.T) {
 			t.Errorf("ShufflePath() iteration %d: length = %v, want %v", i, len(shuffled), len(path))
 		}
 		// Compare first element to see if order changed
-		if i > 0 && !compareByteSlices(shuffled[0], path[0]) {
+		if i > 0 && !compareByteSlices(shuffled[0].PubKey, path[0].PubKey) {
 			allSame = false
 		}
 	}
@@ -208,3 +211,76 @@ func TestValidatePath_RejectsDuplicates(t *testing.T) {
 		t.Errorf("ValidatePath() should return nil result on error, got %d Renoters", len(result2))
 	}
 }
+
+func TestParsePathNode(t *testing.T) {
+	pk, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	npub, _ := nip19.EncodePublicKey(pk)
+	nprofile, _ := nip19.EncodeProfile(pk, []string{"wss://relay.example.com/", "not a relay"})
+	nsec, _ := nip19.EncodePrivateKey(nostr.GeneratePrivateKey())
+
+	for _, entry := range []string{npub, nprofile, pk} {
+		parsed, err := ParsePathNode(entry)
+		if err != nil {
+			t.Errorf("ParsePathNode(%s) error = %v", entry, err)
+			continue
+		}
+		if hex.EncodeToString(parsed.PubKey) != pk {
+			t.Errorf("ParsePathNode(%s) pubkey = %x, want %s", entry, parsed.PubKey, pk)
+		}
+	}
+
+	parsed, _ := ParsePathNode(nprofile)
+	if len(parsed.Relays) != 1 || parsed.Relays[0] != "wss://relay.example.com" {
+		t.Errorf("nprofile relay hints = %v, want only the valid relay, normalized", parsed.Relays)
+	}
+
+	for _, entry := range []string{"", nsec, strings.Repeat("zz", 32), strings.ToUpper(pk)} {
+		if _, err := ParsePathNode(entry); err == nil {
+			t.Errorf("ParsePathNode(%q) should fail", entry)
+		}
+	}
+}
+
+func TestValidatePath_MixedFormats(t *testing.T) {
+	pk1, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	pk2, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	npub1, _ := nip19.EncodePublicKey(pk1)
+	nprofile2, _ := nip19.EncodeProfile(pk2, []string{"wss://relay.example.com", "wss://other.example.com"})
+	nprofile1, _ := nip19.EncodeProfile(pk1, []string{"wss://relay.example.com"})
+
+	path, err := ValidatePath([]string{npub1, nprofile2})
+	if err != nil {
+		t.Fatalf("ValidatePath() error = %v", err)
+	}
+	if path[0].Key() != pk1 || path[1].Key() != pk2 {
+		t.Errorf("ValidatePath() keys = %v, want [%s %s]", path.Keys(), pk1, pk2)
+	}
+	if hints := append(path, PathNode{Relays: []string{"wss://relay.example.com"}}).RelayHints(); len(hints) != 2 {
+		t.Errorf("RelayHints() = %v, want the two distinct relays", hints)
+	}
+
+	if _, err := ValidatePath([]string{npub1, nprofile2, pk1}); err == nil {
+		t.Error("ValidatePath() should reject a Renoter given twice in different formats")
+	}
+	if _, err := ValidatePath([]string{nprofile1, npub1}); err == nil {
+		t.Error("ValidatePath() should reject an nprofile duplicating an npub")
+	}
+}
+
+func TestPath_WithDescriptors(t *testing.T) {
+	pk, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	pkBytes, _ := hex.DecodeString(pk)
+	path := NewPath(pkBytes)
+	if keys := path.Keys(); len(keys) != 1 || keys[0] != pk {
+		t.Fatalf("Keys() = %v, want [%s]", keys, pk)
+	}
+
+	d := &descriptor.Descriptor{PubKey: pk}
+	described := path.withDescriptors(map[string]*descriptor.Descriptor{pk: d})
+	if described[0].Descriptor != d {
+		t.Error("withDescriptors() did not attach the descriptor")
+	}
+	if path[0].Descriptor != nil {
+		t.Error("withDescriptors() modified the original path")
+	}
+}
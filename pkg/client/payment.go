@@ -0,0 +1,219 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/girino/renoter/internal/descriptor"
+	"github.com/girino/renoter/internal/lightning"
+	"github.com/girino/renoter/pkg/protocol"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip04"
+)
+
+// paymentTag is the 29000 tag carrying the payment proof for the Renoter the layer is addressed to.
+const paymentTag = "payment"
+
+// maxVerifyURLLength is the longest LUD-21 verify URL a payment proof is made with; the size
+// budget reserves room for it before paying.
+const maxVerifyURLLength = 512
+
+// Payer pays a paid Renoter's per-event fee and returns the payment tag to add to the 29000
+// layer addressed to it. The tag's values are sealed to that Renoter when the layer is built;
+// sealed, they must take no more room than a verify URL of maxVerifyURLLength bytes and a hex
+// preimage, or the event may be refused after paying.
+type Payer interface {
+	Pay(ctx context.Context, renoter *descriptor.Descriptor) (nostr.Tag, error)
+}
+
+// Wallet pays BOLT-11 invoices and returns the payment preimage (hex).
+type Wallet interface {
+	PayInvoice(ctx context.Context, bolt11 string) (preimage string, err error)
+}
+
+// LightningPayer pays fees by requesting an invoice from the Renoter's lightning address and paying
+// it with Wallet. The Renoter verifies the payment through the invoice's LUD-21 verify URL.
+type LightningPayer struct {
+	// Wallet paying the invoices
+	Wallet Wallet
+	// HTTP client for LNURL requests
+	Client *http.Client
+}
+
+// NewLightningPayer creates a LightningPayer paying with wallet.
+func NewLightningPayer(wallet Wallet) *LightningPayer {
+	return &LightningPayer{Wallet: wallet, Client: &http.Client{}}
+}
+
+// Pay implements Payer.
+func (p *LightningPayer) Pay(ctx context.Context, renoter *descriptor.Descriptor) (nostr.Tag, error) {
+	params, err := lightning.ResolveAddress(ctx, p.Client, renoter.LightningAddress)
+	if err != nil {
+		return nil, err
+	}
+	invoice, err := lightning.RequestInvoice(ctx, p.Client, params, renoter.FeeMsats)
+	if err != nil {
+		return nil, err
+	}
+	if invoice.Verify == "" {
+		return nil, fmt.Errorf("lightning address %s does not support payment verification (LUD-21)", renoter.LightningAddress)
+	}
+	// The event was sized with room for a proof this long; a longer one could not be sent
+	if protocol.SealedTagOverhead(paymentTag, invoice.Verify, strings.Repeat("0", 64)).JSON > paymentOverhead.JSON {
+		return nil, fmt.Errorf("lightning address %s returned a verify URL longer than %d bytes", renoter.LightningAddress, maxVerifyURLLength)
+	}
+
+	// Never pay more than the advertised fee
+	amount, err := lightning.InvoiceAmountMsats(invoice.PR)
+	if err != nil {
+		return nil, fmt.Errorf("invalid invoice: %w", err)
+	}
+	if amount != renoter.FeeMsats {
+		return nil, fmt.Errorf("invoice is for %d msats, advertised fee is %d msats", amount, renoter.FeeMsats)
+	}
+
+	logging.DebugMethod("client.payment", "Pay", "Paying %d msats to %s", amount, renoter.LightningAddress)
+	preimage, err := p.Wallet.PayInvoice(ctx, invoice.PR)
+	if err != nil {
+		logging.Error("client.payment.Pay: failed to pay %s: %v", renoter.LightningAddress, err)
+		return nil, fmt.Errorf("failed to pay invoice: %w", err)
+	}
+	return nostr.Tag{paymentTag, invoice.Verify, preimage}, nil
+}
+
+// collectPayments pays every paid Renoter in recipients and returns the payment tags by pubkey.
+func collectPayments(ctx context.Context, recipients []string, opts Options) (map[string]nostr.Tag, error) {
+	if opts.Payer == nil || len(opts.PaidRenoters) == 0 {
+		return nil, nil
+	}
+	payments := make(map[string]nostr.Tag)
+	for _, pubkey := range recipients {
+		renoter, ok := opts.PaidRenoters[pubkey]
+		if !ok {
+			continue
+		}
+		tag, err := opts.Payer.Pay(ctx, renoter)
+		if err != nil {
+			return nil, fmt.Errorf("failed to pay renoter %s: %w", pubkey[:16], err)
+		}
+		payments[pubkey] = tag
+	}
+	return payments, nil
+}
+
+// nwcTimeout bounds a single Nostr Wallet Connect request.
+const nwcTimeout = 60 * time.Second
+
+// NWCWallet pays invoices through a NIP-47 Nostr Wallet Connect service.
+type NWCWallet struct {
+	walletPubkey string
+	relayURL     string
+	secret       string
+}
+
+// NewNWCWallet parses a nostr+walletconnect:// connection URI.
+func NewNWCWallet(uri string) (*NWCWallet, error) {
+	parsed, err := url.Parse(uri)
+	if err != nil || parsed.Scheme != "nostr+walletconnect" {
+		return nil, fmt.Errorf("invalid wallet connect URI")
+	}
+	w := &NWCWallet{
+		walletPubkey: parsed.Host,
+		relayURL:     parsed.Query().Get("relay"),
+		secret:       parsed.Query().Get("secret"),
+	}
+	if !nostr.IsValidPublicKey(w.walletPubkey) || w.relayURL == "" || w.secret == "" {
+		return nil, fmt.Errorf("wallet connect URI needs a wallet pubkey, relay and secret")
+	}
+	return w, nil
+}
+
+// nwcResponse is the decrypted content of a NIP-47 response.
+type nwcResponse struct {
+	ResultType string `json:"result_type"`
+	Error      *struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+	Result struct {
+		Preimage string `json:"preimage"`
+	} `json:"result"`
+}
+
+// PayInvoice implements Wallet with a NIP-47 pay_invoice request.
+func (w *NWCWallet) PayInvoice(ctx context.Context, bolt11 string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, nwcTimeout)
+	defer cancel()
+
+	sharedSecret, err := nip04.ComputeSharedSecret(w.walletPubkey, w.secret)
+	if err != nil {
+		return "", fmt.Errorf("failed to derive wallet secret: %w", err)
+	}
+	payload, _ := json.Marshal(map[string]any{"method": "pay_invoice", "params": map[string]string{"invoice": bolt11}})
+	content, err := nip04.Encrypt(string(payload), sharedSecret)
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt wallet request: %w", err)
+	}
+	request := nostr.Event{
+		Kind:      23194,
+		CreatedAt: nostr.Now(),
+		Tags:      nostr.Tags{{"p", w.walletPubkey}},
+		Content:   content,
+	}
+	if err := request.Sign(w.secret); err != nil {
+		return "", fmt.Errorf("failed to sign wallet request: %w", err)
+	}
+
+	relay, err := nostr.RelayConnect(ctx, w.relayURL)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to wallet relay: %w", err)
+	}
+	defer relay.Close()
+
+	// Subscribe before publishing so the response cannot be missed
+	sub, err := relay.Subscribe(ctx, nostr.Filters{{
+		Kinds:   []int{23195},
+		Authors: []string{w.walletPubkey},
+		Tags:    nostr.TagMap{"e": []string{request.ID}},
+	}})
+	if err != nil {
+		return "", fmt.Errorf("failed to subscribe to wallet responses: %w", err)
+	}
+	defer sub.Unsub()
+
+	if err := relay.Publish(ctx, request); err != nil {
+		return "", fmt.Errorf("failed to send wallet request: %w", err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("wallet did not respond: %w", ctx.Err())
+		case event, ok := <-sub.Events:
+			if !ok {
+				return "", fmt.Errorf("wallet subscription closed")
+			}
+			plaintext, err := nip04.Decrypt(event.Content, sharedSecret)
+			if err != nil {
+				continue
+			}
+			var response nwcResponse
+			if err := json.Unmarshal([]byte(plaintext), &response); err != nil {
+				return "", fmt.Errorf("invalid wallet response: %w", err)
+			}
+			if response.Error != nil {
+				return "", fmt.Errorf("wallet error %s: %s", response.Error.Code, response.Error.Message)
+			}
+			if response.Result.Preimage == "" {
+				return "", fmt.Errorf("wallet response has no preimage")
+			}
+			return response.Result.Preimage, nil
+		}
+	}
+}
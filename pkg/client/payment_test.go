@@ -0,0 +1,185 @@
+package client
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/girino/renoter/internal/config"
+	"github.com/girino/renoter/internal/descriptor"
+	"github.com/girino/renoter/internal/lightning"
+	"github.com/girino/renoter/internal/sealtag"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip44"
+)
+
+// fakeWallet records paid invoices and returns a fixed preimage.
+type fakeWallet struct {
+	paid []string
+}
+
+func (w *fakeWallet) PayInvoice(ctx context.Context, bolt11 string) (string, error) {
+	w.paid = append(w.paid, bolt11)
+	return strings.Repeat("ab", 32), nil
+}
+
+// startLNURLServer serves a lightning address "renoter@<host>" issuing invoicePR with a verify URL.
+func startLNURLServer(t *testing.T, invoicePR string) string {
+	t.Helper()
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	mux.HandleFunc("/.well-known/lnurlp/renoter", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(lightning.PayParams{Callback: server.URL + "/callback", MinSendable: 1, MaxSendable: 1_000_000_000})
+	})
+	mux.HandleFunc("/callback", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(lightning.Invoice{PR: invoicePR, Verify: server.URL + "/verify/1"})
+	})
+	return "renoter@" + strings.TrimPrefix(server.URL, "http://")
+}
+
+func TestLightningPayer_Pay(t *testing.T) {
+	wallet := &fakeWallet{}
+	payer := NewLightningPayer(wallet)
+	renoter := &descriptor.Descriptor{FeePolicy: descriptor.FeePolicyLightning, FeeMsats: 2000, LightningAddress: startLNURLServer(t, "lnbc20n1test")}
+
+	tag, err := payer.Pay(context.Background(), renoter)
+	if err != nil {
+		t.Fatalf("Pay() error = %v", err)
+	}
+	if len(tag) != 3 || tag[0] != "payment" || !strings.HasSuffix(tag[1], "/verify/1") || tag[2] != strings.Repeat("ab", 32) {
+		t.Errorf("Pay() tag = %v", tag)
+	}
+	if len(wallet.paid) != 1 {
+		t.Errorf("wallet paid %d invoices, want 1", len(wallet.paid))
+	}
+
+	// An invoice above the advertised fee is never paid
+	overpriced := &descriptor.Descriptor{FeePolicy: descriptor.FeePolicyLightning, FeeMsats: 2000, LightningAddress: startLNURLServer(t, "lnbc1u1test")}
+	if _, err := payer.Pay(context.Background(), overpriced); err == nil {
+		t.Error("Pay() should refuse an invoice above the advertised fee")
+	}
+	if len(wallet.paid) != 1 {
+		t.Errorf("wallet paid %d invoices, want still 1", len(wallet.paid))
+	}
+}
+
+// fakePayer returns a tag naming the Renoter it paid.
+type fakePayer struct{}
+
+func (fakePayer) Pay(ctx context.Context, renoter *descriptor.Descriptor) (nostr.Tag, error) {
+	if renoter.FeeMsats == 0 {
+		return nil, fmt.Errorf("no fee")
+	}
+	return nostr.Tag{"payment", "verify-" + renoter.PubKey, "preimage"}, nil
+}
+
+func TestWrapLayers_PaymentTagInPaidLayer(t *testing.T) {
+	paidSk := nostr.GeneratePrivateKey()
+	paidPk := mustPublicKey(t, paidSk)
+	freePk := mustPublicKey(t, nostr.GeneratePrivateKey())
+	paidBytes, _ := hex.DecodeString(paidPk)
+	freeBytes, _ := hex.DecodeString(freePk)
+
+	opts := DefaultOptions()
+	opts.Payer = fakePayer{}
+	opts.PaidRenoters = map[string]*descriptor.Descriptor{paidPk: {PubKey: paidPk, FeeMsats: 1000}}
+
+	payments, err := collectPayments(context.Background(), []string{paidPk, freePk}, opts)
+	if err != nil {
+		t.Fatalf("collectPayments() error = %v", err)
+	}
+	if len(payments) != 1 || payments[paidPk] == nil {
+		t.Fatalf("collectPayments() = %v, want a payment for the paid Renoter only", payments)
+	}
+
+	event := &nostr.Event{Kind: 1, Content: "paid", CreatedAt: nostr.Now(), Tags: nostr.Tags{}}
+	event.Sign(nostr.GeneratePrivateKey())
+
+	// The paid Renoter is first, so its layer is the outermost 29000
+	outermost, _, err := wrapLayers(context.Background(), event, NewPath(paidBytes, freeBytes), 0, nil, sealedLayerTags(payments), false, nil, 0)
+	if err != nil {
+		t.Fatalf("wrapLayers() error = %v", err)
+	}
+	tag := outermost.Tags.Find("payment")
+	if tag == nil {
+		t.Fatalf("outermost layer tags = %v, want the paid Renoter's payment tag", outermost.Tags)
+	}
+	if strings.Contains(tag[1], "verify-") {
+		t.Errorf("payment proof is visible in plaintext: %v", tag)
+	}
+	// Only the paid Renoter can open the proof
+	conversationKey, _ := nip44.GenerateConversationKey(outermost.PubKey, paidSk)
+	proof, err := sealtag.Open(tag[1], conversationKey)
+	if err != nil || proof[0] != "verify-"+paidPk {
+		t.Errorf("sealtag.Open() = %v, %v, want the paid Renoter's proof", proof, err)
+	}
+
+	outermost, _, err = wrapLayers(context.Background(), event, NewPath(freeBytes, paidBytes), 0, nil, sealedLayerTags(payments), false, nil, 0)
+	if err != nil {
+		t.Fatalf("wrapLayers() error = %v", err)
+	}
+	if tag := outermost.Tags.Find("payment"); tag != nil {
+		t.Errorf("free Renoter's layer carries a payment tag: %v", tag)
+	}
+}
+
+// longProofPayer counts payments and returns proofs with the longest verify URL sized for.
+type longProofPayer struct {
+	paid int
+}
+
+func (p *longProofPayer) Pay(ctx context.Context, renoter *descriptor.Descriptor) (nostr.Tag, error) {
+	p.paid++
+	return nostr.Tag{paymentTag, "https://" + strings.Repeat("v", maxVerifyURLLength-len("https://")), strings.Repeat("ab", 32)}, nil
+}
+
+func TestWrapEvent_SizedBeforePaying(t *testing.T) {
+	paidPk := mustPublicKey(t, nostr.GeneratePrivateKey())
+	freePk := mustPublicKey(t, nostr.GeneratePrivateKey())
+	path, _ := ValidatePath([]string{paidPk, freePk})
+
+	payer := &longProofPayer{}
+	opts := DefaultWrapOptions()
+	opts.Payer = payer
+	opts.PaidRenoters = map[string]*descriptor.Descriptor{paidPk: {PubKey: paidPk, FeeMsats: 1000}}
+
+	// NIP-44 padding usually leaves room for a proof; size the limits so that it does not
+	maxInner := WrappedSize(10000, len(path)) + 100
+	opts.Limits = config.SizeLimits{StandardizedSize: maxInner + config.PaddingTagOverhead, MaxInnerEventSize: maxInner}
+	unpaid := NewSizeBudget(len(path), opts.Limits)
+	paid := unpaid.withPayments(opts.paymentLayers(path.Keys()))
+	if paid.MaxEventSize >= unpaid.MaxEventSize {
+		t.Fatalf("budget with a paid Renoter allows %d bytes, want less than %d", paid.MaxEventSize, unpaid.MaxEventSize)
+	}
+	eventOfSize := func(size int) *nostr.Event {
+		event := &nostr.Event{Kind: 1, CreatedAt: nostr.Now(), Tags: nostr.Tags{}}
+		event.Sign(nostr.GeneratePrivateKey())
+		base, _ := json.Marshal(event)
+		event.Content = strings.Repeat("A", size-len(base))
+		event.Sign(nostr.GeneratePrivateKey())
+		return event
+	}
+
+	// An event leaving no room for the proof is refused before anything is paid
+	if _, err := WrapEvent(context.Background(), eventOfSize(paid.MaxEventSize+1), path, opts); err == nil {
+		t.Fatal("WrapEvent() should refuse an event leaving no room for the payment proof")
+	}
+	if payer.paid != 0 {
+		t.Errorf("paid %d times for a refused event, want 0", payer.paid)
+	}
+
+	// The largest event that passes still fits with the longest proof
+	if _, err := WrapEvent(context.Background(), eventOfSize(paid.MaxEventSize), path, opts); err != nil {
+		t.Fatalf("WrapEvent() error = %v after paying", err)
+	}
+	if payer.paid != 1 {
+		t.Errorf("paid %d times, want 1", payer.paid)
+	}
+}
@@ -0,0 +1,174 @@
+package client
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/girino/renoter/internal/config"
+	"github.com/girino/renoter/internal/stamp"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// stampLifetime is how long a pre-mined stamp is used for. The layer must reach its Renoter
+// within stamp.MaxAge of the stamp's date, so half of it is left for publishing and hops.
+const stampLifetime = stamp.MaxAge / 2
+
+// premineRetry is how long the pre-miner waits after failing to mine a stamp.
+const premineRetry = 30 * time.Second
+
+// premineIdleCheck is how often the pre-miner checks whether the dispatcher went idle, and
+// whether it is still idle while mining.
+const premineIdleCheck = 100 * time.Millisecond
+
+// stampPool keeps layer keys with stamps mined ahead for the Renoters that admit them, so the
+// layers for those Renoters need no mining when an event is wrapped.
+type stampPool struct {
+	miner      PoWMiner
+	difficulty int
+	// Stamps kept per Renoter
+	size int
+	// Renoters stamps are mined for
+	recipients []string
+
+	mu sync.Mutex
+	// Ready stamps by Renoter, oldest first
+	stamps map[string][]layerKeys
+}
+
+// newStampPool creates a pool keeping size stamps for each of recipients.
+func newStampPool(miner PoWMiner, difficulty, size int, recipients []string) *stampPool {
+	return &stampPool{miner: miner, difficulty: difficulty, size: size, recipients: recipients, stamps: make(map[string][]layerKeys)}
+}
+
+// take removes and returns the newest stamp for recipient, dropping the stale ones. A nil
+// *stampPool has none.
+func (p *stampPool) take(recipient string) (layerKeys, bool) {
+	if p == nil {
+		return layerKeys{}, false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.dropStaleLocked(recipient, time.Now())
+	ready := p.stamps[recipient]
+	if len(ready) == 0 {
+		return layerKeys{}, false
+	}
+	keys := ready[len(ready)-1]
+	p.stamps[recipient] = ready[:len(ready)-1]
+	return keys, true
+}
+
+// dropStaleLocked removes the stamps for recipient older than stampLifetime.
+func (p *stampPool) dropStaleLocked(recipient string, now time.Time) {
+	ready := p.stamps[recipient]
+	fresh := 0
+	for fresh < len(ready) && now.Sub(ready[fresh].createdAt.Time()) > stampLifetime {
+		fresh++
+	}
+	p.stamps[recipient] = ready[fresh:]
+}
+
+// short returns the Renoter with the fewest fresh stamps, if it has fewer than size.
+func (p *stampPool) short() (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	best, fewest := "", p.size
+	for _, recipient := range p.recipients {
+		p.dropStaleLocked(recipient, now)
+		if n := len(p.stamps[recipient]); n < fewest {
+			best, fewest = recipient, n
+		}
+	}
+	return best, best != ""
+}
+
+// mine mines a stamp for recipient and adds it to the pool.
+func (p *stampPool) mine(ctx context.Context, recipient string) error {
+	keys, err := newLayerKeys(recipient)
+	if err != nil {
+		return err
+	}
+	keys.createdAt = nostr.Now()
+	nonce, err := p.miner.Mine(ctx, stamp.Skeleton(keys.pubkey, keys.createdAt, recipient), p.difficulty)
+	if err != nil {
+		return err
+	}
+	keys.nonce = stamp.Tag(nonce)
+
+	p.mu.Lock()
+	p.stamps[recipient] = append(p.stamps[recipient], keys)
+	p.mu.Unlock()
+	return nil
+}
+
+// stampRecipients returns the Renoters of a path that admit stamped layers: those announcing
+// config.StampedPoWVersion, except the ones paid with Cashu tokens, whose layers are not mined.
+func stampRecipients(renterPath Path, opts Options) []string {
+	var recipients []string
+	for _, node := range renterPath {
+		if node.Descriptor == nil || node.Descriptor.Version < config.StampedPoWVersion {
+			continue
+		}
+		if opts.Cashu != nil && opts.CashuRenoters[node.Key()] != nil {
+			continue
+		}
+		recipients = append(recipients, node.Key())
+	}
+	return recipients
+}
+
+// idle reports whether no event is waiting for or being wrapped.
+func (d *Dispatcher) idle() bool {
+	return len(d.jobs) == 0 && d.busy.Load() == 0
+}
+
+// premine mines stamps while the dispatcher is idle until ctx is done. Mining stops as soon as an
+// event arrives, so it never slows down a real one.
+func (d *Dispatcher) premine(ctx context.Context) {
+	defer d.wg.Done()
+	ticker := time.NewTicker(premineIdleCheck)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		recipient, ok := d.opts.stamps.short()
+		if !ok || !d.idle() {
+			continue
+		}
+
+		mineCtx, cancel := context.WithCancel(ctx)
+		go func() {
+			for {
+				select {
+				case <-mineCtx.Done():
+					return
+				case <-ticker.C:
+					if !d.idle() {
+						cancel()
+						return
+					}
+				}
+			}
+		}()
+		err := d.opts.stamps.mine(mineCtx, recipient)
+		interrupted := mineCtx.Err() != nil
+		cancel()
+		switch {
+		case err == nil:
+			logging.DebugMethod("client.premine", "premine", "Mined a stamp for %s", recipient)
+		case !interrupted:
+			logging.Warn("client.premine.premine: failed to mine a stamp for %s: %v", recipient, err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(premineRetry):
+			}
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package client
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/girino/renoter/internal/config"
+	"github.com/girino/renoter/internal/descriptor"
+	"github.com/girino/renoter/internal/stamp"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// failingMiner refuses to mine, so a layer can only be wrapped with a pre-mined stamp.
+type failingMiner struct{}
+
+func (failingMiner) Mine(ctx context.Context, event nostr.Event, difficulty int) (nostr.Tag, error) {
+	return nil, fmt.Errorf("not mining")
+}
+
+// stampMiner returns a dummy nonce and counts the stamps it mined, from any goroutine.
+type stampMiner struct {
+	mined atomic.Int32
+}
+
+func (m *stampMiner) Mine(ctx context.Context, event nostr.Event, difficulty int) (nostr.Tag, error) {
+	m.mined.Add(1)
+	return nostr.Tag{"nonce", "0", "1"}, nil
+}
+
+func TestStampPool(t *testing.T) {
+	recipient := mustPublicKey(t, nostr.GeneratePrivateKey())
+	pool := newStampPool(CPUMiner{}, 4, 2, []string{recipient})
+
+	if short, ok := pool.short(); !ok || short != recipient {
+		t.Fatalf("short() = %q, %v, want the empty Renoter", short, ok)
+	}
+	for range 2 {
+		if err := pool.mine(context.Background(), recipient); err != nil {
+			t.Fatalf("mine() error = %v", err)
+		}
+	}
+	if _, ok := pool.short(); ok {
+		t.Error("short() reported a full pool")
+	}
+
+	keys, ok := pool.take(recipient)
+	if !ok || keys.nonce == nil || keys.nonce[3] != stamp.Marker {
+		t.Fatalf("take() = %+v, %v, want a stamp", keys, ok)
+	}
+	layer := stamp.Skeleton(keys.pubkey, keys.createdAt, recipient)
+	layer.Tags = append(layer.Tags, keys.nonce)
+	if got := stamp.CommittedDifficulty(&layer); got != 4 {
+		t.Errorf("stamp difficulty = %d, want 4", got)
+	}
+
+	// Stale stamps are dropped rather than handed out
+	pool.stamps[recipient][0].createdAt -= nostr.Timestamp(stampLifetime/time.Second) + 1
+	if _, ok := pool.take(recipient); ok {
+		t.Error("take() handed out a stale stamp")
+	}
+	var none *stampPool
+	if _, ok := none.take(recipient); ok {
+		t.Error("take() on a nil pool found a stamp")
+	}
+}
+
+func TestWrapLayers_Stamp(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	recipient := mustPublicKey(t, sk)
+	recipientBytes, _ := hex.DecodeString(recipient)
+	pool := newStampPool(CPUMiner{}, 4, 1, []string{recipient})
+	if err := pool.mine(context.Background(), recipient); err != nil {
+		t.Fatalf("mine() error = %v", err)
+	}
+	stamped := pool.stamps[recipient][0]
+
+	event := &nostr.Event{Kind: 1, Content: "stamped", CreatedAt: nostr.Now(), Tags: nostr.Tags{}}
+	event.Sign(nostr.GeneratePrivateKey())
+	layer, _, err := wrapLayers(context.Background(), event, NewPath(recipientBytes), 4, failingMiner{}, nil, false, pool, 0)
+	if err != nil {
+		t.Fatalf("wrapLayers() error = %v", err)
+	}
+	if layer.PubKey != stamped.pubkey || layer.CreatedAt != stamped.createdAt || !stamp.Stamped(layer) {
+		t.Errorf("layer = %+v, want the key and date of the stamp", layer)
+	}
+	if got := stamp.CommittedDifficulty(layer); got != 4 {
+		t.Errorf("layer difficulty = %d, want 4", got)
+	}
+	if ok, _ := layer.CheckSignature(); !ok {
+		t.Error("stamped layer is not signed")
+	}
+	originalJSON, _ := json.Marshal(event)
+	layerJSON, _ := json.Marshal(layer)
+	if bound := wrappedSize(len(originalJSON), 1, layerFormat{stamps: true}); len(layerJSON) > bound {
+		t.Errorf("stamped layer size %d exceeds model bound %d", len(layerJSON), bound)
+	}
+
+	// With the pool empty, the layer is mined again
+	if _, _, err := wrapLayers(context.Background(), event, NewPath(recipientBytes), 4, failingMiner{}, nil, false, pool, 0); err == nil {
+		t.Error("wrapLayers() without stamps left did not mine")
+	}
+}
+
+func TestDispatcher_Premine(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	relay, path, pool := newDispatcherTestSetup(t, ctx)
+	legacy := mustPublicKey(t, nostr.GeneratePrivateKey())
+	legacyBytes, _ := hex.DecodeString(legacy)
+	path[0].Descriptor = &descriptor.Descriptor{PubKey: path[0].Key(), Version: config.StampedPoWVersion}
+	path = append(path, PathNode{PubKey: legacyBytes, Descriptor: &descriptor.Descriptor{PubKey: legacy, Version: config.CompactLayersVersion}})
+
+	miner := &stampMiner{}
+	opts := DefaultOptions()
+	opts.Miner = miner
+	opts.Premine = 3
+	dispatcher, err := NewDispatcher(ctx, path, pool, []string{relay.URL()}, opts)
+	if err != nil {
+		t.Fatalf("NewDispatcher() error = %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for miner.mined.Load() < 3 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	time.Sleep(3 * premineIdleCheck)
+	if got := miner.mined.Load(); got != 3 {
+		t.Errorf("mined %d stamps, want 3 for the one Renoter speaking version %d", got, config.StampedPoWVersion)
+	}
+	if _, ok := dispatcher.opts.stamps.take(legacy); ok {
+		t.Error("stamps were mined for a Renoter that cannot read them")
+	}
+}
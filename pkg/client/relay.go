@@ -3,36 +3,416 @@ package client
 import (
 	"context"
 	"fmt"
+	"slices"
+	"time"
 
 	"github.com/fiatjaf/khatru"
 	"github.com/girino/nostr-lib/logging"
+	"github.com/girino/renoter/internal/config"
+	"github.com/girino/renoter/internal/descriptor"
+	"github.com/girino/renoter/internal/relayinfo"
+	"github.com/girino/renoter/internal/relaypool"
 	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip11"
 )
 
+// Options holds tunable client behaviour for SetupRelayWithOptions.
+type Options struct {
+	// Size limits used when wrapping events; must match the Renoters in the path
+	Limits config.SizeLimits
+	// Checks submitted events must pass before they are wrapped
+	Validation EventValidation
+	// Rewrite submitted events, in order, before they are validated (nil = route them as is)
+	Transforms []Transform
+
+	// Number of background workers wrapping and mining accepted events
+	MiningWorkers int
+	// Number of accepted events that may wait for a worker before new ones are rejected
+	MiningQueueSize int
+	// Maximum time spent wrapping (mostly PoW mining) a single event
+	MiningTimeout time.Duration
+	// Keeps queued events on disk across restarts and holds those that don't fit in the mining
+	// queue (nil = memory only, rejecting events when the queue is full)
+	QueueSpill *QueueSpill
+	// Hold wrapped events and publish them in shuffled order at fixed wall-clock intervals,
+	// so publishing times don't reveal when events were submitted (0 = publish immediately)
+	BatchInterval time.Duration
+	// Delivery lane of events that don't choose one (config.LaneFast or config.LaneMixed). Local
+	// apps pick the lane of an event with a config.LaneTag, or a default lane per connection with
+	// ?lane= in the relay URL.
+	Lane string
+	// Mean delay requested from each Renoter for events in the mixed lane
+	MixingDelay time.Duration
+	// Ask every Renoter on the path for an encrypted timing trailer and collect them from the exit,
+	// so Stats shows where latency accumulates. Containers then carry trailer tags and every layer
+	// a sealed report tag, so the largest accepted event is slightly smaller.
+	ReportLatency bool
+	// Encode the event inside every layer compactly instead of as JSON, so larger events fit in a
+	// container. Needs every Renoter on the path to speak config.CompactLayersVersion, which
+	// StartDispatcher checks in their descriptors or handshake answers.
+	CompactLayers bool
+	// Send events small enough in the lite profile, on paths where every Renoter announces it in
+	// its descriptor or handshake answer: containers of config.LiteSize instead of the full
+	// standardized size, and layers mined to config.LitePoWDifficulty. Saves bandwidth and CPU on
+	// metered connections, but relays see which events are small.
+	LiteProfile bool
+	// Stamps mined ahead per Renoter while the dispatcher is idle, so layers for Renoters
+	// speaking config.StampedPoWVersion need no mining when an event is wrapped (0 = mine every
+	// layer when wrapping). Needs the Renoters' descriptors or handshake answers. Stamps are
+	// only used in the fast lane, and each layer is slightly larger.
+	Premine int
+	// Pre-mined stamps, created by NewDispatcher when Premine is set
+	stamps *stampPool
+	// Ask every Renoter for a hop acknowledgement, set by WrapEventWithAcks
+	hopAcks bool
+	// Hex reply pubkey every Renoter is asked to send error notices to, set by
+	// WrapEventWithNotices ("" = none)
+	replyTo string
+	// Resend an event over a new path if it has not appeared on the server relays this long
+	// after being dispatched (0 = never resend)
+	ResendTimeout time.Duration
+	// Resends per event before giving up
+	MaxResends int
+	// Miner used for the 29000 wrapper PoW (local CPU by default, or a remote HTTPMiner)
+	Miner PoWMiner
+
+	// PoW difficulty mined on the outer 29001 container for relays that require it (0 = none).
+	// This is separate from the fixed 29000 PoW that Renoters demand.
+	ContainerPoWDifficulty int
+	// Raise ContainerPoWDifficulty to the highest min_pow_difficulty advertised in the server relays' NIP-11
+	DetectContainerPoW bool
+	// Check the server relays' NIP-11 documents at startup for limits too small for containers
+	// or no ephemeral events: RelayCheckOff ("" too), RelayCheckWarn or RelayCheckStrict
+	RelayCheck string
+
+	// How the path of each event is drawn from the configured Renoters (hops, trusted hops)
+	PathPolicy PathPolicy
+
+	// Check the Renoters' service descriptors on the server relays before using the path
+	CheckDescriptors bool
+	// Refetch the descriptors at this interval and route queued events around Renoters that
+	// announce going offline or miss their heartbeats (0 = never)
+	HealthInterval time.Duration
+	// Treat Renoters without a service descriptor as incompatible (only with CheckDescriptors)
+	RequireDescriptors bool
+	// Probe every Renoter on the path for its capabilities before sending traffic, and check
+	// the answers like descriptors
+	Handshake bool
+	// Capability responses, reused until they expire (created by StartDispatcher if nil)
+	Capabilities *Capabilities
+
+	// Pays the per-event fee of paid Renoters (nil = only free Renoters are usable)
+	Payer Payer
+	// Paid Renoters on the path by pubkey, filled in from their descriptors by SetupRelayWithOptions
+	PaidRenoters map[string]*descriptor.Descriptor
+
+	// Supplies Cashu tokens for Renoters that admit them instead of PoW (nil = always mine PoW)
+	Cashu CashuWallet
+	// Cashu-admitting Renoters on the path by pubkey, filled in from their descriptors by SetupRelayWithOptions
+	CashuRenoters map[string]*descriptor.Descriptor
+
+	// Connection cap and idle timeout for the server relay connections
+	Pool relaypool.Options
+	// Optional pool reaching the server relays instead of the built-in capped one, e.g. with a
+	// custom dialer (Tor), metrics or circuit breakers. It publishes the containers and runs the
+	// descriptor and delivery lookups; the Pool limits don't apply to it.
+	ServerPool Pool
+	// Publish each container to a few random server relays with hedged retries instead of all
+	// of them (zero value = all server relays)
+	Hedge HedgePolicy
+
+	// Optional collector for size and bandwidth accounting (nil disables it)
+	Stats *Stats
+	// Log the messages server relays refuse containers with, not only their category
+	LogRelayReasons bool
+	// Optional per-connection statistics and rate limits for local apps (nil disables them)
+	Connections *Connections
+	// Optional journal of dispatched events for later verification (nil disables it)
+	Journal *Journal
+	// Optional HTTP endpoint submitting events without a websocket (nil disables it)
+	PublishAPI *PublishAPI
+	// Optional status feed for desktop tray apps (nil disables it)
+	Status *StatusSocket
+}
+
+// Pool is the relay operations the client uses: fetching descriptors and delivered events, and
+// publishing. *nostr.SimplePool implements it.
+type Pool = relaypool.Pool
+
+// DefaultOptions returns the options used by SetupRelay.
+func DefaultOptions() Options {
+	return Options{
+		Limits:          config.DefaultSizeLimits(),
+		Validation:      DefaultEventValidation(),
+		MiningWorkers:   2,
+		MiningQueueSize: 64,
+		MiningTimeout:   60 * time.Second,
+		MaxResends:      3,
+		Lane:            config.LaneFast,
+		MixingDelay:     config.DefaultMixingDelay,
+		Miner:           CPUMiner{},
+		Pool:            relaypool.DefaultOptions(),
+	}
+}
+
+// Validate checks that the options are usable.
+func (o Options) Validate() error {
+	if err := o.Limits.Validate(); err != nil {
+		return fmt.Errorf("invalid size limits: %w", err)
+	}
+	if err := o.Validation.Validate(); err != nil {
+		return fmt.Errorf("invalid event validation: %w", err)
+	}
+	if o.MiningWorkers < 1 {
+		return fmt.Errorf("mining workers must be at least 1, got %d", o.MiningWorkers)
+	}
+	if o.MiningQueueSize < 1 {
+		return fmt.Errorf("mining queue size must be at least 1, got %d", o.MiningQueueSize)
+	}
+	if o.MiningTimeout <= 0 {
+		return fmt.Errorf("mining timeout must be positive, got %v", o.MiningTimeout)
+	}
+	if o.BatchInterval < 0 {
+		return fmt.Errorf("batch interval must not be negative, got %v", o.BatchInterval)
+	}
+	if o.Lane != "" && !config.ValidLane(o.Lane) {
+		return fmt.Errorf("unknown lane %q (use %s or %s)", o.Lane, config.LaneFast, config.LaneMixed)
+	}
+	if o.MixingDelay < 0 || o.MixingDelay > config.MaxDelayHint {
+		return fmt.Errorf("mixing delay must be between 0 and %v, got %v", config.MaxDelayHint, o.MixingDelay)
+	}
+	if o.HealthInterval < 0 {
+		return fmt.Errorf("health interval must not be negative, got %v", o.HealthInterval)
+	}
+	if o.ResendTimeout < 0 {
+		return fmt.Errorf("resend timeout must not be negative, got %v", o.ResendTimeout)
+	}
+	if o.Premine < 0 {
+		return fmt.Errorf("pre-mined stamps must not be negative, got %d", o.Premine)
+	}
+	if o.MaxResends < 0 {
+		return fmt.Errorf("max resends must not be negative, got %d", o.MaxResends)
+	}
+	if err := o.PathPolicy.Validate(); err != nil {
+		return fmt.Errorf("invalid path policy: %w", err)
+	}
+	if o.Miner == nil {
+		return fmt.Errorf("a PoW miner is required")
+	}
+	if o.ContainerPoWDifficulty < 0 || o.ContainerPoWDifficulty > 256 {
+		return fmt.Errorf("container PoW difficulty must be between 0 and 256, got %d", o.ContainerPoWDifficulty)
+	}
+	if err := o.Pool.Validate(); err != nil {
+		return fmt.Errorf("invalid pool options: %w", err)
+	}
+	if err := o.Hedge.Validate(); err != nil {
+		return fmt.Errorf("invalid hedge policy: %w", err)
+	}
+	switch o.RelayCheck {
+	case "", RelayCheckOff, RelayCheckWarn, RelayCheckStrict:
+	default:
+		return fmt.Errorf("unknown relay check %q (use %s, %s or %s)", o.RelayCheck, RelayCheckOff, RelayCheckWarn, RelayCheckStrict)
+	}
+	return nil
+}
+
+// StartDispatcher prepares everything SetupRelayWithOptions needs besides the relay: it connects
+// to the server relays, checks the path against the Renoters' descriptors and the path policy,
+// and starts a Dispatcher running until ctx is done. Use it to submit events without a local
+// relay.
+func StartDispatcher(ctx context.Context, renterPath Path, serverRelayURLs []string, opts Options) (*Dispatcher, error) {
+	if err := opts.Validate(); err != nil {
+		logging.Error("client.relay.StartDispatcher: invalid options: %v", err)
+		return nil, fmt.Errorf("invalid options: %w", err)
+	}
+
+	// Location diversity relies on the locations the Renoters declare in their descriptors
+	if (opts.PathPolicy.DistinctRegions || opts.PathPolicy.DistinctASNs) && !opts.CheckDescriptors {
+		return nil, fmt.Errorf("distinct regions or providers require checking the Renoters' service descriptors")
+	}
+	// Compact layers are negotiated from the protocol versions the Renoters announce
+	if opts.CompactLayers && !opts.CheckDescriptors && !opts.Handshake {
+		return nil, fmt.Errorf("compact layers require checking the Renoters' service descriptors or a capability handshake")
+	}
+	if opts.LiteProfile && !opts.CheckDescriptors && !opts.Handshake {
+		return nil, fmt.Errorf("the lite profile requires checking the Renoters' service descriptors or a capability handshake")
+	}
+	if opts.Premine > 0 && !opts.CheckDescriptors && !opts.Handshake {
+		return nil, fmt.Errorf("pre-mining requires checking the Renoters' service descriptors or a capability handshake")
+	}
+
+	serverPool, err := connectServerPool(ctx, serverRelayURLs, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Refuse to route through Renoters that announce an incompatible service
+	if opts.CheckDescriptors {
+		descriptors, err := CheckPathDescriptors(ctx, renterPath, serverRelayURLs, opts)
+		if err != nil {
+			return nil, fmt.Errorf("renoter path check failed: %w", err)
+		}
+		renterPath = renterPath.withDescriptors(descriptors)
+	}
+
+	// Warn about server relays whose limits would refuse or drop containers, or refuse to start
+	relays, err := checkServerRelays(ctx, serverRelayURLs, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// Match the PoW required by the server relays themselves, if asked to
+	if opts.DetectContainerPoW {
+		detected := 0
+		if relays != nil {
+			// The documents were just fetched
+			for _, relay := range relays {
+				detected = max(detected, relay.MinPoWDifficulty)
+			}
+		} else {
+			detected = relayinfo.MinPoWDifficulty(ctx, serverRelayURLs)
+		}
+		if detected > opts.ContainerPoWDifficulty {
+			logging.Info("client.relay.StartDispatcher: Server relays require PoW difficulty %d, mining 29001 containers accordingly", detected)
+			opts.ContainerPoWDifficulty = detected
+		}
+	}
+
+	// Ask the Renoters themselves, in containers relays cannot tell from traffic
+	if opts.Handshake {
+		if opts.Capabilities == nil {
+			opts.Capabilities = NewCapabilities(DefaultCapabilityTTL)
+		}
+		if renterPath, err = negotiatePath(ctx, renterPath, serverRelayURLs, opts); err != nil {
+			return nil, fmt.Errorf("capability handshake failed: %w", err)
+		}
+	}
+	if opts.CheckDescriptors || opts.Handshake {
+		descriptors := make(map[string]*descriptor.Descriptor)
+		for _, node := range renterPath {
+			if node.Descriptor != nil {
+				descriptors[node.Key()] = node.Descriptor
+			}
+		}
+		opts.PaidRenoters = paidRenoters(descriptors)
+		opts.CashuRenoters = cashuRenoters(descriptors)
+		opts.PathPolicy.Descriptors = descriptors
+		if opts.CompactLayers {
+			opts.CompactLayers = compactLayersSupported(renterPath)
+		}
+		if opts.LiteProfile {
+			logging.Info("client.relay.StartDispatcher: %d of %d Renoters support the lite profile", countLiteProfile(renterPath), len(renterPath))
+		}
+	}
+
+	// Refuse to start if no path can satisfy the policy, rather than failing every event
+	if err := opts.PathPolicy.Check(renterPath); err != nil {
+		logging.Error("client.relay.StartDispatcher: unsatisfiable path policy: %v", err)
+		return nil, fmt.Errorf("unsatisfiable path policy: %w", err)
+	}
+	// Or if the layers of the longest path leave no room for any content
+	pathLength := opts.PathPolicy.PathLength(len(renterPath))
+	if opts.MaxContentSize(pathLength) == 0 {
+		logging.Error("client.relay.StartDispatcher: %d layers do not fit in a %d byte container", pathLength, opts.Limits.StandardizedSize)
+		return nil, fmt.Errorf("a %d-hop path leaves no room for content in a %d byte container", pathLength, opts.Limits.StandardizedSize)
+	}
+
+	// Wrapping and PoW mining happen on background workers so client websockets never time out
+	dispatcher, err := NewDispatcher(ctx, renterPath, serverPool, serverRelayURLs, opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.ServerPool == nil {
+		dispatcher.publisher, _ = serverPool.(*relaypool.Publisher)
+	}
+	dispatcher.relays = relays
+	return dispatcher, nil
+}
+
+// compactLayersSupported reports whether every Renoter on the path announced a protocol version
+// reading compact layers, warning about the first one that did not.
+func compactLayersSupported(renterPath Path) bool {
+	for _, node := range renterPath {
+		if node.Descriptor == nil || node.Descriptor.Version < config.CompactLayersVersion {
+			logging.Warn("client.relay.StartDispatcher: Renoter %s does not announce protocol version %d, wrapping layers as JSON", node.Key(), config.CompactLayersVersion)
+			return false
+		}
+	}
+	return true
+}
+
+// connectServerPool returns opts.ServerPool, or else a capped publisher (so a long server relay
+// list doesn't open a socket per relay) after checking that every server relay is reachable.
+func connectServerPool(ctx context.Context, serverRelayURLs []string, opts Options) (Pool, error) {
+	if opts.ServerPool != nil {
+		logging.Info("client.relay.StartDispatcher: Reaching %d server relays through the configured pool", len(serverRelayURLs))
+		return opts.ServerPool, nil
+	}
+
+	serverPool, err := relaypool.NewPublisher(ctx, opts.Pool)
+	if err != nil {
+		logging.Error("client.relay.StartDispatcher: failed to create publisher: %v", err)
+		return nil, fmt.Errorf("failed to create publisher: %w", err)
+	}
+
+	// Connections beyond the cap are closed again
+	for _, url := range serverRelayURLs {
+		if err := serverPool.Connect(url); err != nil {
+			logging.Error("client.relay.StartDispatcher: failed to connect to relay %s: %v", url, err)
+			return nil, fmt.Errorf("failed to ensure relay %s: %w", url, err)
+		}
+	}
+	logging.Info("client.relay.StartDispatcher: Successfully connected to %d server relays (max %d open connections)", len(serverRelayURLs), opts.Pool.MaxConnections)
+	return serverPool, nil
+}
+
 // SetupRelay configures a khatru relay to intercept incoming events,
 // wrap them using the provided Renoter path, and forward to the server relays.
-func SetupRelay(relay *khatru.Relay, renterPath [][]byte, serverRelayURLs []string) error {
+func SetupRelay(relay *khatru.Relay, renterPath Path, serverRelayURLs []string) error {
+	return SetupRelayWithOptions(relay, renterPath, serverRelayURLs, DefaultOptions())
+}
+
+// SetupRelayWithOptions is like SetupRelay but uses the given options.
+func SetupRelayWithOptions(relay *khatru.Relay, renterPath Path, serverRelayURLs []string, opts Options) error {
 	logging.Info("client.relay.SetupRelay: Setting up khatru relay with %d Renoters, server relays: %v", len(renterPath), serverRelayURLs)
 
-	// Create SimplePool for managing multiple relay connections
-	ctx := context.Background()
-	serverPool := nostr.NewSimplePool(ctx)
-	logging.DebugMethod("client.relay", "SetupRelay", "Created SimplePool for %d server relays", len(serverRelayURLs))
+	dispatcher, err := StartDispatcher(context.Background(), renterPath, serverRelayURLs, opts)
+	if err != nil {
+		return err
+	}
+	AttachDispatcher(relay, dispatcher)
+	return nil
+}
 
-	// Ensure all relays are available in the pool (they'll be connected on-demand)
-	for _, url := range serverRelayURLs {
-		_, err := serverPool.EnsureRelay(url)
-		if err != nil {
-			logging.Error("client.relay.SetupRelay: failed to ensure relay %s in pool: %v", url, err)
-			return fmt.Errorf("failed to ensure relay %s: %w", url, err)
-		}
+// AttachDispatcher configures a khatru relay to check incoming events and queue them on a
+// dispatcher started with StartDispatcher. Unlike SetupRelayWithOptions, the caller keeps the
+// dispatcher, to Close it when shutting down.
+func AttachDispatcher(relay *khatru.Relay, dispatcher *Dispatcher) {
+	renterPath := dispatcher.renterPath
+	// The dispatcher's options carry what was learned from the descriptors
+	opts := dispatcher.opts
+	opts.PublishAPI.attach(dispatcher)
+
+	// Tell editors how long a post can be before they try to publish it
+	if relay.Info.Limitation == nil {
+		relay.Info.Limitation = &nip11.RelayLimitationDocument{}
+	}
+	relay.Info.Limitation.MaxContentLength = opts.MaxContentSize(opts.PathPolicy.PathLength(len(renterPath)))
+
+	// Track local app connections so their submissions can be accounted and limited
+	if opts.Connections != nil {
+		relay.OnConnect = append(relay.OnConnect, func(ctx context.Context) {
+			opts.Connections.open(khatru.GetConnection(ctx), khatru.GetIP(ctx), time.Now())
+		})
+		relay.OnDisconnect = append(relay.OnDisconnect, func(ctx context.Context) {
+			opts.Connections.close(khatru.GetConnection(ctx))
+		})
 	}
-	logging.Info("client.relay.SetupRelay: Successfully initialized SimplePool with %d server relays", len(serverRelayURLs))
 
-	// RejectEvent handler: Check size and process events
+	// RejectEvent handler: Check size and queue events for dispatch
 	// This runs before the event is accepted, allowing us to reject oversized events
 	relay.RejectEvent = append(relay.RejectEvent, func(ctx context.Context, event *nostr.Event) (reject bool, msg string) {
-		return rejectEventHandler(ctx, event, renterPath, serverPool, serverRelayURLs)
+		return rejectEventHandler(ctx, event, opts.PathPolicy.PathLength(len(renterPath)), dispatcher, opts)
 	})
 
 	// OnEphemeralEvent handler: Prevent "no one was listening" rejection for ephemeral events
@@ -47,47 +427,111 @@ func SetupRelay(relay *khatru.Relay, renterPath [][]byte, serverRelayURLs []stri
 	// But won't be stored locally (unless StoreEvent is set elsewhere)
 
 	logging.Info("client.relay.SetupRelay: Successfully configured khatru relay with event processing via RejectEvent (size checking and forwarding, no local storage)")
-	return nil
 }
 
-// rejectEventHandler checks event size and processes acceptable events by wrapping and forwarding them.
-func rejectEventHandler(ctx context.Context, event *nostr.Event, renterPath [][]byte, serverPool *nostr.SimplePool, serverRelayURLs []string) (reject bool, msg string) {
-	// Shuffle the Renoter path for each event to randomize routing
-	// This improves privacy by ensuring events don't always follow the same path
-	shuffledPath := ShufflePath(renterPath)
+// rejectEventHandler checks event size and queues acceptable events on the dispatcher.
+// The submitting client gets the OK immediately and a NOTICE once the event is actually dispatched.
+// Rejections use the machine-readable config.Rejection format.
+func rejectEventHandler(ctx context.Context, event *nostr.Event, pathLength int, dispatcher *Dispatcher, opts Options) (reject bool, msg string) {
 	logging.DebugMethod("client.relay", "RejectEvent", "Checking event %s for size limits", event.ID)
 
-	// Try to wrap the event - this will check if the outermost 29000 exceeds 8KB
-	wrappedEvent, err := WrapEvent(ctx, event, shuffledPath)
+	ws := khatru.GetConnection(ctx)
+	opts.Connections.submitted(ws, len(event.String()), time.Now())
+
+	// Apps choose the lane of each event with a local lane tag, stripped here; ?lane= in the relay
+	// URL sets the lane of events without one
+	lane, err := connectionLane(ws, opts.Lane)
+	if err != nil {
+		opts.Connections.rejected(ws)
+		return true, err.Error()
+	}
+	event, lane, err = eventLane(event, lane)
 	if err != nil {
-		// WrapEvent returns properly formatted error messages ready for the caller
-		logging.Error("client.relay.RejectEvent: failed to wrap event %s: %v", event.ID, err)
+		opts.Connections.rejected(ws)
 		return true, err.Error()
 	}
 
-	// Event is acceptable size - publish the wrapped event (29001 will be larger than 8KB due to encryption, which is expected)
-	logging.DebugMethod("client.relay", "RejectEvent", "Event %s outermost 29000 size OK, publishing wrapped 29001 event", event.ID)
+	// The local app's OK names its own event, whatever the transforms make of it
+	event, err = transformEvent(event, opts.Transforms)
+	if err != nil {
+		opts.Connections.rejected(ws)
+		return true, err.Error()
+	}
 
-	// Publish wrapped event to all server relays using SimplePool
-	publishResults := serverPool.PublishMany(ctx, serverRelayURLs, *wrappedEvent)
+	// Malformed events are refused before anything is spent on them
+	if err := ValidateEvent(event, opts.Validation, time.Now()); err != nil {
+		opts.Connections.rejected(ws)
+		return true, err.Error()
+	}
 
-	// Collect results
-	successCount := 0
-	for result := range publishResults {
-		if result.Error != nil {
-			logging.Error("client.relay.RejectEvent: failed to publish wrapped event %s to relay %s: %v", wrappedEvent.ID, result.RelayURL, result.Error)
-		} else {
-			successCount++
-			logging.DebugMethod("client.relay", "RejectEvent", "Successfully published wrapped event %s to relay %s", wrappedEvent.ID, result.RelayURL)
+	// The size model rejects oversized events instantly, before any mining is queued
+	if err := opts.sizeBudget(pathLength, lane).CheckEvent(event); err != nil {
+		opts.Connections.rejected(ws)
+		// CheckEvent returns a config.Rejection, ready to be used as the OK message
+		return true, err.Error()
+	}
+
+	// Keep one app from filling the mining queue for everyone else
+	if err := opts.Connections.admit(ws, time.Now()); err != nil {
+		logging.Warn("client.relay.RejectEvent: rejecting event %s: %v", event.ID, err)
+		return true, err.Error()
+	}
+
+	// Notify the submitting connection once the event has been dispatched (or has failed)
+	var notify Notifier
+	var done func(dispatched bool)
+	if ws != nil {
+		done = func(dispatched bool) { opts.Connections.dispatched(ws, dispatched) }
+		notify = func(msg string) {
+			if err := ws.WriteJSON(nostr.NoticeEnvelope(msg)); err != nil {
+				logging.DebugMethod("client.relay", "RejectEvent", "Could not notify client about event %s: %v", event.ID, err)
+			}
 		}
 	}
 
-	if successCount == 0 {
-		logging.Error("client.relay.RejectEvent: Failed to publish wrapped event %s to any relay", wrappedEvent.ID)
-		// Don't reject the original event - wrapping/publishing failure shouldn't block the event
-		// The sender will see the event was accepted even if forwarding failed
+	if err := dispatcher.submit(event, notify, lane, done); err != nil {
+		opts.Connections.unqueued(ws)
+		return true, err.Error()
 	}
 
 	// Don't reject - return false so event continues (though it won't be stored since StoreEvent is not set)
 	return false, ""
 }
+
+// eventLane returns event without its config.LaneTag and the lane the tag asked for, or event
+// itself and fallback if it has none; an unknown lane gets a *config.Rejection. Removing the tag
+// invalidates the author's signature, so the transforms must sign the event again.
+func eventLane(event *nostr.Event, fallback string) (*nostr.Event, string, error) {
+	tag := event.Tags.Find(config.LaneTag)
+	if tag == nil {
+		return event, fallback, nil
+	}
+	lane := tag[1]
+	if !config.ValidLane(lane) {
+		logging.Warn("client.relay.eventLane: event %s asked for unknown lane %q", event.ID, lane)
+		return nil, "", config.NewRejection(config.RejectUnknownLane, lane, fmt.Sprintf("unknown lane, use %s or %s", config.LaneFast, config.LaneMixed))
+	}
+	stripped := *event
+	stripped.Tags = slices.Clone(event.Tags)
+	StripTags(config.LaneTag)(&stripped)
+	logging.DebugMethod("client.relay", "eventLane", "Event %s asked for the %s lane", event.ID, lane)
+	return &stripped, lane, nil
+}
+
+// connectionLane returns the delivery lane a connection asked for with ?lane= in its URL, or
+// fallback if it did not; an unknown lane gets a *config.Rejection.
+func connectionLane(ws *khatru.WebSocket, fallback string) (string, error) {
+	if ws == nil || ws.Request == nil {
+		return fallback, nil
+	}
+	lane := ws.Request.URL.Query().Get("lane")
+	switch {
+	case lane == "":
+		return fallback, nil
+	case config.ValidLane(lane):
+		return lane, nil
+	default:
+		logging.Warn("client.relay.connectionLane: connection from %s asked for unknown lane %q", ws.Request.RemoteAddr, lane)
+		return "", config.NewRejection(config.RejectUnknownLane, lane, fmt.Sprintf("unknown lane, use %s or %s", config.LaneFast, config.LaneMixed))
+	}
+}
@@ -2,12 +2,19 @@ package client
 
 import (
 	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/fiatjaf/khatru"
 	"github.com/girino/renoter/internal/config"
+	"github.com/girino/renoter/internal/descriptor"
 	"github.com/nbd-wtf/go-nostr"
 	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/nbd-wtf/go-nostr/nip44"
 )
 
 func TestSetupRelay(t *testing.T) {
@@ -44,7 +51,7 @@ func TestRejectEventHandler_EmptyPath(t *testing.T) {
 	// Test the error path when path is empty
 	// We'll manually create a handler to test without relay setup
 	ctx := context.Background()
-	emptyPath := [][]byte{}
+	emptyPath := Path{}
 
 	// Create a test event
 	event := &nostr.Event{
@@ -56,7 +63,7 @@ func TestRejectEventHandler_EmptyPath(t *testing.T) {
 	event.Sign(event.PubKey)
 
 	// Manually test WrapEvent with empty path to verify error handling
-	_, err := WrapEvent(ctx, event, emptyPath)
+	_, err := WrapEvent(ctx, event, emptyPath, DefaultWrapOptions())
 	if err == nil {
 		t.Error("WrapEvent should error on empty path")
 	}
@@ -87,7 +94,7 @@ func TestRejectEventHandler_ValidEvent(t *testing.T) {
 
 	ctx := context.Background()
 	// Test wrapping - should succeed
-	wrapped, err := WrapEvent(ctx, event, path)
+	wrapped, err := WrapEvent(ctx, event, path, DefaultWrapOptions())
 	if err != nil {
 		t.Errorf("WrapEvent() error = %v (expected to succeed for small event)", err)
 		return
@@ -128,7 +135,7 @@ func TestRejectEventHandler_OversizedEvent(t *testing.T) {
 
 	ctx := context.Background()
 	// Test wrapping - should fail with size error
-	_, err = WrapEvent(ctx, event, path)
+	_, err = WrapEvent(ctx, event, path, DefaultWrapOptions())
 
 	if err == nil {
 		t.Error("WrapEvent should error on oversized event")
@@ -136,6 +143,13 @@ func TestRejectEventHandler_OversizedEvent(t *testing.T) {
 	if err != nil && !contains(err.Error(), "too large") {
 		t.Errorf("Error message should mention 'too large', got: %v", err)
 	}
+
+	// The relay rejects it up front with a machine-readable reason
+	reject, msg := rejectEventHandler(ctx, event, len(path), &Dispatcher{}, DefaultOptions())
+	rejection, ok := config.ParseRejection(msg)
+	if !reject || !ok || rejection.Reason != config.RejectSizeExceeded || rejection.Param != strconv.Itoa(MaxOriginalEventSize(len(path), config.DefaultSizeLimits())) {
+		t.Errorf("rejectEventHandler() = %v, %q, want a %s rejection with the size limit", reject, msg, config.RejectSizeExceeded)
+	}
 }
 
 func contains(s, substr string) bool {
@@ -146,3 +160,209 @@ func contains(s, substr string) bool {
 	}
 	return false
 }
+
+func TestOptions_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(*Options)
+		wantErr bool
+	}{
+		{"defaults", func(o *Options) {}, false},
+		{"invalid limits", func(o *Options) { o.Limits.MaxInnerEventSize = o.Limits.StandardizedSize }, true},
+		{"no workers", func(o *Options) { o.MiningWorkers = 0 }, true},
+		{"no queue", func(o *Options) { o.MiningQueueSize = 0 }, true},
+		{"zero timeout", func(o *Options) { o.MiningTimeout = 0 }, true},
+		{"negative batch interval", func(o *Options) { o.BatchInterval = -time.Minute }, true},
+		{"no miner", func(o *Options) { o.Miner = nil }, true},
+		{"negative connection cap", func(o *Options) { o.Pool.MaxConnections = -1 }, true},
+		{"custom", func(o *Options) { o.MiningWorkers = 8; o.MiningTimeout = time.Second }, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DefaultOptions()
+			tt.modify(&opts)
+			if err := opts.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// fakePool accepts every published event without any relay and finds nothing.
+type fakePool struct {
+	published chan nostr.Event
+}
+
+func (p *fakePool) SubscribeMany(ctx context.Context, urls []string, filter nostr.Filter, opts ...nostr.SubscriptionOption) chan nostr.RelayEvent {
+	return p.FetchMany(ctx, urls, filter, opts...)
+}
+
+func (p *fakePool) FetchMany(ctx context.Context, urls []string, filter nostr.Filter, opts ...nostr.SubscriptionOption) chan nostr.RelayEvent {
+	ch := make(chan nostr.RelayEvent)
+	close(ch)
+	return ch
+}
+
+func (p *fakePool) PublishMany(ctx context.Context, urls []string, evt nostr.Event) chan nostr.PublishResult {
+	p.published <- evt
+	ch := make(chan nostr.PublishResult, len(urls))
+	for _, url := range urls {
+		ch <- nostr.PublishResult{RelayURL: url}
+	}
+	close(ch)
+	return ch
+}
+
+func TestStartDispatcher_ServerPool(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pk, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	path, _ := ValidatePath([]string{mustNpub(t, pk)})
+	pool := &fakePool{published: make(chan nostr.Event, 1)}
+	opts := DefaultOptions()
+	opts.ServerPool = pool
+
+	// The relay is never dialled: everything goes through the injected pool
+	dispatcher, err := StartDispatcher(ctx, path, []string{"wss://unreachable.invalid"}, opts)
+	if err != nil {
+		t.Fatalf("StartDispatcher() error = %v", err)
+	}
+	event := newDispatcherTestEvent()
+	entry, err := dispatcher.Publish(ctx, event)
+	if err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	published := <-pool.published
+	if published.Kind != config.StandardizedWrapperKind || published.ID != entry.WrappedID {
+		t.Errorf("published kind %d event %s, want the 29001 container %s", published.Kind, published.ID, entry.WrappedID)
+	}
+}
+
+func mustNpub(t *testing.T, pk string) string {
+	t.Helper()
+	npub, err := nip19.EncodePublicKey(pk)
+	if err != nil {
+		t.Fatalf("EncodePublicKey() error = %v", err)
+	}
+	return npub
+}
+
+func TestConnectionLane(t *testing.T) {
+	connect := func(target string) *khatru.WebSocket {
+		return &khatru.WebSocket{Request: httptest.NewRequest("GET", target, nil)}
+	}
+	if lane, err := connectionLane(nil, config.LaneFast); err != nil || lane != config.LaneFast {
+		t.Errorf("connectionLane(nil) = %q, %v, want the default lane", lane, err)
+	}
+	if lane, err := connectionLane(connect("/"), config.LaneMixed); err != nil || lane != config.LaneMixed {
+		t.Errorf("connectionLane(/) = %q, %v, want the default lane", lane, err)
+	}
+	if lane, err := connectionLane(connect("/?lane=mixed"), config.LaneFast); err != nil || lane != config.LaneMixed {
+		t.Errorf("connectionLane(?lane=mixed) = %q, %v", lane, err)
+	}
+	_, err := connectionLane(connect("/?lane=slow"), config.LaneFast)
+	if rejection, ok := err.(*config.Rejection); !ok || rejection.Reason != config.RejectUnknownLane {
+		t.Errorf("connectionLane(?lane=slow) error = %v, want unknown-lane", err)
+	}
+}
+
+func TestEventLane(t *testing.T) {
+	event := &nostr.Event{Kind: 1, Content: "pick my lane", CreatedAt: nostr.Now(), Tags: nostr.Tags{{"t", "renoter"}, {config.LaneTag, config.LaneMixed}}}
+	event.Sign(nostr.GeneratePrivateKey())
+
+	stripped, lane, err := eventLane(event, config.LaneFast)
+	if err != nil || lane != config.LaneMixed {
+		t.Fatalf("eventLane() = %q, %v, want the tagged lane", lane, err)
+	}
+	if stripped.Tags.Find(config.LaneTag) != nil || stripped.Tags.Find("t") == nil {
+		t.Errorf("tags = %v, want the lane tag stripped and the others kept", stripped.Tags)
+	}
+	if event.Tags.Find(config.LaneTag) == nil {
+		t.Error("eventLane() changed the submitted event")
+	}
+
+	untagged := &nostr.Event{Kind: 1, Tags: nostr.Tags{}}
+	if got, lane, err := eventLane(untagged, config.LaneMixed); err != nil || got != untagged || lane != config.LaneMixed {
+		t.Errorf("eventLane(untagged) = %q, %v, want the event itself in the default lane", lane, err)
+	}
+
+	unknown := &nostr.Event{Kind: 1, Tags: nostr.Tags{{config.LaneTag, "slow"}}}
+	_, _, err = eventLane(unknown, config.LaneFast)
+	if rejection, ok := err.(*config.Rejection); !ok || rejection.Reason != config.RejectUnknownLane {
+		t.Errorf("eventLane(slow) error = %v, want unknown-lane", err)
+	}
+}
+
+func TestRejectEventHandler_LaneTag(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	renoterSk := nostr.GeneratePrivateKey()
+	path, _ := ValidatePath([]string{mustPublicKey(t, renoterSk)})
+	sk := nostr.GeneratePrivateKey()
+	opts := DefaultOptions()
+	opts.Transforms = []Transform{SignWith(sk)}
+	pool := &fakePool{published: make(chan nostr.Event, 1)}
+	dispatcher, err := NewDispatcher(ctx, path, pool, []string{"wss://relay.example.com"}, opts)
+	if err != nil {
+		t.Fatalf("NewDispatcher() error = %v", err)
+	}
+
+	// The default lane is fast; the tag asks for the mixed lane for this event only
+	event := &nostr.Event{Kind: 1, Content: "hold me", CreatedAt: nostr.Now(), Tags: nostr.Tags{{config.LaneTag, config.LaneMixed}}}
+	event.Sign(sk)
+	if reject, msg := rejectEventHandler(ctx, event, len(path), dispatcher, opts); reject {
+		t.Fatalf("rejectEventHandler() rejected the event: %s", msg)
+	}
+
+	container := <-pool.published
+	conversationKey, _ := nip44.GenerateConversationKey(container.PubKey, renoterSk)
+	plaintext, err := nip44.Decrypt(container.Content, conversationKey)
+	if err != nil {
+		t.Fatalf("Decrypt() error = %v", err)
+	}
+	var layer nostr.Event
+	json.Unmarshal([]byte(plaintext), &layer)
+	if layer.Tags.Find(config.DelayTag) == nil {
+		t.Error("layer has no delay tag, want the mixed lane the event asked for")
+	}
+	layerKey, _ := nip44.GenerateConversationKey(layer.PubKey, renoterSk)
+	inner, err := nip44.Decrypt(layer.Content, layerKey)
+	if err != nil {
+		t.Fatalf("Decrypt() of the layer error = %v", err)
+	}
+	var original nostr.Event
+	if err := json.Unmarshal([]byte(inner), &original); err != nil {
+		t.Fatalf("Unmarshal() of the original error = %v", err)
+	}
+	if original.Content != event.Content || original.Tags.Find(config.LaneTag) != nil {
+		t.Errorf("wrapped event = %v, want the submitted event without its lane tag", original)
+	}
+	if strings.Contains(plaintext, config.LaneTag) || strings.Contains(inner, config.LaneTag) {
+		t.Error("the lane tag leaked into the wrapped event")
+	}
+	if valid, err := original.CheckSignature(); err != nil || !valid {
+		t.Errorf("wrapped event signature invalid: %v", err)
+	}
+}
+
+func TestCompactLayersSupported(t *testing.T) {
+	path := testRenoters(2)
+	path[0].Descriptor = &descriptor.Descriptor{Version: config.CompactLayersVersion}
+	path[1].Descriptor = &descriptor.Descriptor{Version: 1}
+	if compactLayersSupported(path) {
+		t.Error("compactLayersSupported() = true with a version 1 Renoter on the path")
+	}
+
+	path[1].Descriptor.Version = config.ProtocolVersion
+	if !compactLayersSupported(path) {
+		t.Error("compactLayersSupported() = false although every Renoter announces it")
+	}
+
+	path[1].Descriptor = nil
+	if compactLayersSupported(path) {
+		t.Error("compactLayersSupported() = true for a Renoter without descriptor")
+	}
+}
@@ -0,0 +1,82 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/girino/renoter/internal/config"
+	"github.com/girino/renoter/internal/relayinfo"
+	"github.com/girino/renoter/internal/trailer"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Modes of Options.RelayCheck.
+const (
+	// Don't check the server relays' NIP-11 documents
+	RelayCheckOff = "off"
+	// Log the server relays that would refuse or drop containers
+	RelayCheckWarn = "warn"
+	// Refuse to start if any server relay would refuse or drop containers
+	RelayCheckStrict = "strict"
+)
+
+// RelayCompatibility is what the NIP-11 document of a server relay says about carrying the
+// client's containers.
+type RelayCompatibility = relayinfo.Compatibility
+
+// CheckServerRelays fetches the NIP-11 documents of the server relays and checks them against the
+// containers published with opts: a max_message_length or max_content_length too small for the
+// largest container, or no support for ephemeral events.
+func CheckServerRelays(ctx context.Context, relayURLs []string, opts Options) []RelayCompatibility {
+	return relayinfo.CheckCompatibility(ctx, relayURLs, opts.relayRequirements())
+}
+
+// relayRequirements returns the limits a server relay must allow for the largest container
+// published with these options: padded to StandardizedSize, mined and carrying timing trailers
+// if asked for.
+func (o Options) relayRequirements() relayinfo.Requirements {
+	contentSize := config.ContainerContentSize(o.Limits.StandardizedSize)
+	container := nostr.Event{
+		ID:        strings.Repeat("0", 64),
+		PubKey:    strings.Repeat("0", 64),
+		CreatedAt: nostr.Timestamp(9999999999),
+		Kind:      config.StandardizedWrapperKind,
+		Content:   strings.Repeat("A", contentSize),
+		Tags: nostr.Tags{
+			{"p", strings.Repeat("0", 64)},
+			{"nonce", strconv.FormatUint(math.MaxUint64, 10), "256"},
+		},
+		Sig: strings.Repeat("0", 128),
+	}
+	if o.ReportLatency {
+		decoys, _ := trailer.Decoys()
+		container.Tags = append(container.Tags, decoys...)
+	}
+	// The EVENT message as go-nostr sends it, with the encoder padding.Marshal measures with
+	message, _ := nostr.EventEnvelope{Event: container}.MarshalJSON()
+	return relayinfo.Requirements{MessageSize: len(message), ContentSize: contentSize}
+}
+
+// checkServerRelays runs the startup check opts.RelayCheck asks for, returning the results (nil
+// if off). In strict mode a relay known to refuse or drop containers is an error.
+func checkServerRelays(ctx context.Context, relayURLs []string, opts Options) ([]RelayCompatibility, error) {
+	if opts.RelayCheck == "" || opts.RelayCheck == RelayCheckOff {
+		return nil, nil
+	}
+	results := CheckServerRelays(ctx, relayURLs, opts)
+	for _, result := range results {
+		if result.Compatible() {
+			continue
+		}
+		if opts.RelayCheck == RelayCheckStrict {
+			logging.Error("client.relaycheck.checkServerRelays: server relay %s is incompatible: %s", result.URL, result)
+			return results, fmt.Errorf("server relay %s is incompatible: %s", result.URL, result)
+		}
+		logging.Warn("client.relaycheck.checkServerRelays: server relay %s may refuse or drop containers: %s", result.URL, result)
+	}
+	return results, nil
+}
@@ -0,0 +1,58 @@
+package client
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/fiatjaf/khatru"
+	"github.com/girino/renoter/internal/config"
+	"github.com/nbd-wtf/go-nostr/nip11"
+)
+
+func TestOptions_RelayRequirements(t *testing.T) {
+	opts := DefaultOptions()
+	req := opts.relayRequirements()
+	if req.ContentSize != config.ContainerContentSize(config.StandardizedSize) {
+		t.Errorf("ContentSize = %d, want %d", req.ContentSize, config.ContainerContentSize(config.StandardizedSize))
+	}
+	if req.MessageSize <= req.ContentSize {
+		t.Errorf("MessageSize = %d, want more than the %d byte content", req.MessageSize, req.ContentSize)
+	}
+
+	// Timing trailers make every container larger
+	opts.ReportLatency = true
+	if withTrailers := opts.relayRequirements(); withTrailers.MessageSize <= req.MessageSize {
+		t.Errorf("MessageSize with trailers = %d, want more than %d", withTrailers.MessageSize, req.MessageSize)
+	}
+}
+
+func TestCheckServerRelays_Modes(t *testing.T) {
+	relay := khatru.NewRelay()
+	relay.Info.Limitation = &nip11.RelayLimitationDocument{MaxMessageLength: 16384}
+	server := httptest.NewServer(relay)
+	defer server.Close()
+	urls := []string{"ws" + strings.TrimPrefix(server.URL, "http")}
+
+	opts := DefaultOptions()
+	if results, err := checkServerRelays(context.Background(), urls, opts); results != nil || err != nil {
+		t.Errorf("checkServerRelays() off = %v, %v, want nothing checked", results, err)
+	}
+
+	opts.RelayCheck = RelayCheckWarn
+	results, err := checkServerRelays(context.Background(), urls, opts)
+	if err != nil || len(results) != 1 || results[0].Compatible() {
+		t.Errorf("checkServerRelays() warn = %v, %v, want the relay flagged without an error", results, err)
+	}
+
+	opts.RelayCheck = RelayCheckStrict
+	if _, err := checkServerRelays(context.Background(), urls, opts); err == nil || !strings.Contains(err.Error(), "max_message_length") {
+		t.Errorf("checkServerRelays() strict error = %v, want the message length refused", err)
+	}
+
+	opts.RelayCheck = "loud"
+	if err := opts.Validate(); err == nil {
+		t.Error("Validate() accepted an unknown relay check")
+	}
+}
@@ -0,0 +1,88 @@
+package client
+
+import (
+	"strings"
+
+	"github.com/girino/nostr-lib/logging"
+)
+
+// Categories of the reasons server relays refuse a container with: the machine-readable prefixes
+// NIP-01 defines for OK and CLOSED messages, and two of our own.
+const (
+	RelayDuplicate    = "duplicate"
+	RelayPoW          = "pow"
+	RelayBlocked      = "blocked"
+	RelayRateLimited  = "rate-limited"
+	RelayInvalid      = "invalid"
+	RelayRestricted   = "restricted"
+	RelayAuthRequired = "auth-required"
+	RelayMute         = "mute"
+	RelayError        = "error"
+	// The relay could not be reached, or did not answer in time
+	RelayUnreachable = "unreachable"
+	// The relay answered with a reason carrying none of the prefixes
+	RelayOther = "other"
+)
+
+// relayPrefixes are the NIP-01 prefixes a reason is categorized by.
+var relayPrefixes = []string{RelayDuplicate, RelayPoW, RelayBlocked, RelayRateLimited, RelayInvalid, RelayRestricted, RelayAuthRequired, RelayMute, RelayError}
+
+// RelayReason is why a relay refused an event, categorized by its NIP-01 prefix.
+type RelayReason struct {
+	// One of the Relay* categories
+	Category string
+	// The relay's message after the prefix, or the error if it did not answer
+	Message string
+}
+
+// ParseRelayMessage categorizes the reason of an OK or CLOSED message, e.g.
+// "rate-limited: slow down".
+func ParseRelayMessage(msg string) RelayReason {
+	prefix, rest, found := strings.Cut(msg, ":")
+	if found {
+		for _, known := range relayPrefixes {
+			if prefix == known {
+				return RelayReason{Category: known, Message: strings.TrimSpace(rest)}
+			}
+		}
+	}
+	return RelayReason{Category: RelayOther, Message: msg}
+}
+
+// ParseRelayReason categorizes the error of publishing an event to a relay. Errors that carry no
+// answer of the relay, such as a failed connection or a missing OK, are RelayUnreachable.
+func ParseRelayReason(err error) RelayReason {
+	// go-nostr reports a refusing OK as "msg: <reason>"
+	if msg, ok := strings.CutPrefix(err.Error(), "msg: "); ok {
+		return ParseRelayMessage(msg)
+	}
+	return RelayReason{Category: RelayUnreachable, Message: err.Error()}
+}
+
+// Permanent reports whether the relay would refuse any container of this client the same way,
+// so resending the event over another path cannot help. Other refusals, e.g. rate limits, may
+// pass later.
+func (r RelayReason) Permanent() bool {
+	switch r.Category {
+	case RelayInvalid, RelayPoW, RelayBlocked, RelayRestricted:
+		return true
+	}
+	return false
+}
+
+func (r RelayReason) String() string {
+	if r.Message == "" {
+		return r.Category
+	}
+	return r.Category + ": " + r.Message
+}
+
+// logRefusal logs a relay refusing a container: its category, and the relay's own message only
+// if full is set, as relay messages are free text of any length.
+func logRefusal(containerID, relayURL string, reason RelayReason, full bool) {
+	if full {
+		logging.Error("client.relayreason.logRefusal: relay %s refused wrapped event %s (%s)", relayURL, containerID, reason)
+		return
+	}
+	logging.Error("client.relayreason.logRefusal: relay %s refused wrapped event %s (%s)", relayURL, containerID, reason.Category)
+}
@@ -0,0 +1,74 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestParseRelayReason(t *testing.T) {
+	tests := []struct {
+		err           error
+		wantCategory  string
+		wantMessage   string
+		wantPermanent bool
+	}{
+		{errors.New("msg: rate-limited: slow down"), RelayRateLimited, "slow down", false},
+		{errors.New("msg: pow: difficulty 20 required"), RelayPoW, "difficulty 20 required", true},
+		{errors.New("msg: invalid: bad signature"), RelayInvalid, "bad signature", true},
+		{errors.New("msg: blocked: size-exceeded:32768 event too large"), RelayBlocked, "size-exceeded:32768 event too large", true},
+		{errors.New("msg: duplicate: already have it"), RelayDuplicate, "already have it", false},
+		{errors.New("msg: too many events"), RelayOther, "too many events", false},
+		{errors.New("given up waiting for an OK"), RelayUnreachable, "given up waiting for an OK", false},
+	}
+	for _, tt := range tests {
+		reason := ParseRelayReason(tt.err)
+		if reason.Category != tt.wantCategory || reason.Message != tt.wantMessage {
+			t.Errorf("ParseRelayReason(%q) = %+v, want %s %q", tt.err, reason, tt.wantCategory, tt.wantMessage)
+		}
+		if reason.Permanent() != tt.wantPermanent {
+			t.Errorf("ParseRelayReason(%q).Permanent() = %v, want %v", tt.err, reason.Permanent(), tt.wantPermanent)
+		}
+	}
+}
+
+func TestDispatcher_CategorizesRefusals(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pk, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	path, _ := ValidatePath([]string{mustNpub(t, pk)})
+	relays := []string{"wss://a.example.com", "wss://b.example.com"}
+	opts := DefaultOptions()
+	opts.Stats = NewStats()
+	opts.ResendTimeout = time.Minute
+	dispatcher, err := NewDispatcher(ctx, path, &hedgePool{refuseFirst: 2, reason: "invalid: bad id"}, relays, opts)
+	if err != nil {
+		t.Fatalf("NewDispatcher() error = %v", err)
+	}
+
+	entry, err := dispatcher.Publish(ctx, newDispatcherTestEvent())
+	if err == nil || entry.Dispatched() {
+		t.Fatalf("Publish() = %+v, %v, want the event refused", entry, err)
+	}
+	for _, result := range entry.Results {
+		if result.Reason != RelayInvalid {
+			t.Errorf("journal result %+v, want reason %q", result, RelayInvalid)
+		}
+	}
+	if refusals := opts.Stats.Snapshot().RelayRefusals; refusals[RelayInvalid] != 2 {
+		t.Errorf("RelayRefusals = %v, want 2 %s", refusals, RelayInvalid)
+	}
+
+	// Resends keep trying after a rate limit, but not after an invalid container
+	event := newDispatcherTestEvent()
+	if !dispatcher.resendable(dispatchJob{event: event, attempt: 1}, false) {
+		t.Error("resendable() = false for a resend refused for a passing reason")
+	}
+	if dispatcher.resendable(dispatchJob{event: event, attempt: 1, refused: true}, false) {
+		t.Error("resendable() = true for a resend refused for a permanent reason")
+	}
+}
@@ -0,0 +1,72 @@
+package client
+
+import (
+	"context"
+	"time"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// deliveryCheckTimeout bounds each lookup of a dispatched event on the server relays.
+const deliveryCheckTimeout = 10 * time.Second
+
+// Delivered reports whether any of relayURLs holds the event with the given ID. The exit Renoter
+// publishes the original event unchanged, so finding it means it crossed the whole path.
+func Delivered(ctx context.Context, relayURLs []string, eventID string) bool {
+	return delivered(ctx, nil, relayURLs, eventID)
+}
+
+// delivered is Delivered through pool, or a pool of its own if nil.
+func delivered(ctx context.Context, pool Pool, relayURLs []string, eventID string) bool {
+	ctx, cancel := context.WithTimeout(ctx, deliveryCheckTimeout)
+	defer cancel()
+
+	if pool == nil {
+		pool = nostr.NewSimplePool(ctx)
+	}
+	for range pool.FetchMany(ctx, relayURLs, nostr.Filter{IDs: []string{eventID}, Limit: 1}) {
+		return true
+	}
+	return false
+}
+
+// resendable reports whether the delivery of a finished job should be watched: resends are
+// enabled, the event is stored by relays (ephemeral events can never be found) and either it was
+// dispatched or it is already a resend, which keeps trying until the attempts run out or every
+// relay refuses it for a reason resending cannot fix.
+func (d *Dispatcher) resendable(job dispatchJob, dispatched bool) bool {
+	if d.opts.ResendTimeout <= 0 || nostr.IsEphemeralKind(job.event.Kind) || job.refused {
+		return false
+	}
+	return dispatched || job.attempt > 0
+}
+
+// awaitDelivery waits opts.ResendTimeout for the event of a finished job to appear on the server
+// relays and otherwise queues it again, to be wrapped for a new path, up to opts.MaxResends times.
+// Every copy carries the same signed event, so relays keep only one even if several arrive.
+func (d *Dispatcher) awaitDelivery(ctx context.Context, job dispatchJob) {
+	defer d.wg.Done()
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(d.opts.ResendTimeout):
+	}
+
+	if d.delivered(ctx, job.event.ID) {
+		logging.DebugMethod("client.resend", "awaitDelivery", "Event %s delivered after %d resends", job.event.ID, job.attempt)
+		return
+	}
+	if job.attempt >= d.opts.MaxResends {
+		logging.Warn("client.resend.awaitDelivery: event %s not delivered after %d resends, giving up", job.event.ID, job.attempt)
+		return
+	}
+
+	// The submitter was told about the first dispatch already; resends are silent
+	resend := dispatchJob{event: job.event, submittedAt: time.Now(), attempt: job.attempt + 1, avoid: job.pathHash}
+	select {
+	case <-ctx.Done():
+	case d.jobs <- resend:
+		logging.Info("client.resend.awaitDelivery: Event %s not delivered within %v, resending over a new path (attempt %d/%d)", job.event.ID, d.opts.ResendTimeout, resend.attempt, d.opts.MaxResends)
+	}
+}
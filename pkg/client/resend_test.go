@@ -0,0 +1,108 @@
+package client
+
+import (
+	"context"
+	"encoding/hex"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fiatjaf/khatru"
+	"github.com/girino/renoter/internal/config"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestDispatcher_ResendsUndeliveredEvents(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	relay, _, pool := newDispatcherTestSetup(t, ctx)
+	nostr.NewSimplePool(ctx).SubscribeMany(ctx, []string{relay.URL()}, nostr.Filter{Kinds: []int{config.StandardizedWrapperKind}})
+	time.Sleep(200 * time.Millisecond)
+
+	// The relay stores nothing and no Renoter listens, so the event is never delivered
+	opts := DefaultOptions()
+	opts.ResendTimeout = 200 * time.Millisecond
+	opts.MaxResends = 2
+	journalPath := filepath.Join(t.TempDir(), "journal.jsonl")
+	opts.Journal, _ = NewJournal(journalPath, 0, 0)
+	var path Path
+	for i := 0; i < 3; i++ {
+		pk, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+		pkBytes, _ := hex.DecodeString(pk)
+		path = append(path, PathNode{PubKey: pkBytes})
+	}
+	dispatcher, err := NewDispatcher(ctx, path, pool, []string{relay.URL()}, opts)
+	if err != nil {
+		t.Fatalf("NewDispatcher() error = %v", err)
+	}
+
+	var notices atomic.Int32
+	event := newDispatcherTestEvent()
+	if err := dispatcher.Submit(event, func(string) { notices.Add(1) }); err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+
+	var entries []JournalEntry
+	for deadline := time.Now().Add(30 * time.Second); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+		if entries, _ = LookupJournal(journalPath, event.ID, 0); len(entries) == 3 {
+			break
+		}
+	}
+	if len(entries) != 3 {
+		t.Fatalf("journal has %d entries, want the dispatch and 2 resends", len(entries))
+	}
+	for i, entry := range entries {
+		if entry.Attempt != i || !entry.Dispatched() {
+			t.Errorf("entry %d = attempt %d, dispatched %v", i, entry.Attempt, entry.Dispatched())
+		}
+	}
+
+	// Attempts are capped and the submitter hears only about the first dispatch
+	time.Sleep(3 * opts.ResendTimeout)
+	if entries, _ = LookupJournal(journalPath, event.ID, 0); len(entries) != 3 {
+		t.Errorf("journal has %d entries after the last resend, want 3", len(entries))
+	}
+	if n := notices.Load(); n != 1 {
+		t.Errorf("submitter notified %d times, want 1", n)
+	}
+}
+
+func TestDispatcher_NoResendOnceDelivered(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Answer every ID lookup as if the exit had published the event; the hook is set before the
+	// relay serves, as its connections read it
+	event := newDispatcherTestEvent()
+	relay, path, pool := newDispatcherTestSetup(t, ctx, func(relay *khatru.Relay) {
+		relay.QueryEvents = append(relay.QueryEvents, func(ctx context.Context, filter nostr.Filter) (chan *nostr.Event, error) {
+			ch := make(chan *nostr.Event, 1)
+			if len(filter.IDs) == 1 && filter.IDs[0] == event.ID {
+				ch <- event
+			}
+			close(ch)
+			return ch, nil
+		})
+	})
+	nostr.NewSimplePool(ctx).SubscribeMany(ctx, []string{relay.URL()}, nostr.Filter{Kinds: []int{config.StandardizedWrapperKind}})
+	time.Sleep(200 * time.Millisecond)
+
+	opts := DefaultOptions()
+	opts.ResendTimeout = 200 * time.Millisecond
+	journalPath := filepath.Join(t.TempDir(), "journal.jsonl")
+	opts.Journal, _ = NewJournal(journalPath, 0, 0)
+	dispatcher, err := NewDispatcher(ctx, path, pool, []string{relay.URL()}, opts)
+	if err != nil {
+		t.Fatalf("NewDispatcher() error = %v", err)
+	}
+	if err := dispatcher.Submit(event, nil); err != nil {
+		t.Fatalf("Submit() error = %v", err)
+	}
+
+	time.Sleep(2 * time.Second)
+	if entries, _ := LookupJournal(journalPath, event.ID, 0); len(entries) != 1 {
+		t.Errorf("journal has %d entries, want only the first dispatch", len(entries))
+	}
+}
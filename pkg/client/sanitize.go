@@ -0,0 +1,95 @@
+package client
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/girino/renoter/internal/config"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// TagPolicy is what the client does with an event carrying a tag that can identify its author.
+// Tags cannot be stripped by the client, since that would invalidate the author's signature,
+// so the strictest policy refuses the event and names the tag to remove before signing again.
+type TagPolicy string
+
+const (
+	// Log the tag and route the event anyway
+	TagWarn TagPolicy = "warn"
+	// Refuse the event with a blocked: identifying-tag:<name> rejection
+	TagReject TagPolicy = "reject"
+)
+
+// IdentifyingTags are tags known to deanonymize an event's author, with why:
+// the app that published it, a location, and the origin of a bridged event.
+var IdentifyingTags = map[string]string{
+	"client": "names the app you publish from",
+	"g":      "reveals a location",
+	"proxy":  "links the event to its origin outside nostr",
+}
+
+// ParseTagPolicies parses a comma-separated list of tag=policy pairs, e.g. "client=warn,g=reject".
+// A bare "warn" or "reject" applies the policy to every tag in IdentifyingTags.
+func ParseTagPolicies(spec string) (map[string]TagPolicy, error) {
+	policies := make(map[string]TagPolicy)
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(field, "=")
+		if !ok {
+			name, value = "", field
+		}
+		policy := TagPolicy(strings.TrimSpace(value))
+		if policy != TagWarn && policy != TagReject {
+			return nil, fmt.Errorf("invalid tag policy %q in %q (use warn or reject)", value, field)
+		}
+		if !ok {
+			for tag := range IdentifyingTags {
+				policies[tag] = policy
+			}
+			continue
+		}
+		if name = strings.TrimSpace(name); name == "" {
+			return nil, fmt.Errorf("missing tag name in %q", field)
+		}
+		policies[name] = policy
+	}
+	return policies, nil
+}
+
+// checkTags applies the tag policies to event, logging tags to warn about and returning a
+// *config.Rejection for the first tag to reject, in name order.
+func checkTags(event *nostr.Event, policies map[string]TagPolicy) error {
+	if len(policies) == 0 {
+		return nil
+	}
+	present := make(map[string]bool)
+	for _, tag := range event.Tags {
+		if len(tag) > 0 && policies[tag[0]] != "" {
+			present[tag[0]] = true
+		}
+	}
+	names := make([]string, 0, len(present))
+	for name := range present {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		why := IdentifyingTags[name]
+		if why == "" {
+			why = "may identify you"
+		}
+		if policies[name] == TagWarn {
+			logging.Warn("client.sanitize.checkTags: event %s carries a %q tag, which %s", event.ID, name, why)
+			continue
+		}
+		logging.Warn("client.sanitize.checkTags: rejecting event %s carrying a %q tag", event.ID, name)
+		return config.NewRejection(config.RejectIdentifyingTag, name, fmt.Sprintf("the %q tag %s; remove it and sign again", name, why))
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package client
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/girino/renoter/internal/config"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestParseTagPolicies(t *testing.T) {
+	policies, err := ParseTagPolicies("warn, g=reject,t=warn")
+	if err != nil {
+		t.Fatalf("ParseTagPolicies() error = %v", err)
+	}
+	want := map[string]TagPolicy{"client": TagWarn, "proxy": TagWarn, "g": TagReject, "t": TagWarn}
+	if len(policies) != len(want) {
+		t.Errorf("ParseTagPolicies() = %v, want %v", policies, want)
+	}
+	for name, policy := range want {
+		if policies[name] != policy {
+			t.Errorf("policy for %q = %q, want %q", name, policies[name], policy)
+		}
+	}
+
+	for _, spec := range []string{"strip", "g=strip", "=warn"} {
+		if _, err := ParseTagPolicies(spec); err == nil {
+			t.Errorf("ParseTagPolicies(%q) should fail", spec)
+		}
+	}
+}
+
+func TestValidateEvent_TagPolicies(t *testing.T) {
+	now := time.Now()
+	event := &nostr.Event{Kind: 1, Content: "sanitize me", CreatedAt: nostr.Timestamp(now.Unix()), Tags: nostr.Tags{{"client", "some-app"}, {"g", "u4pruyd"}}}
+	event.Sign(nostr.GeneratePrivateKey())
+
+	v := DefaultEventValidation()
+	v.TagPolicies = map[string]TagPolicy{"client": TagWarn, "proxy": TagReject}
+	if err := ValidateEvent(event, v, now); err != nil {
+		t.Errorf("ValidateEvent() with a warned tag error = %v", err)
+	}
+
+	v.TagPolicies["g"] = TagReject
+	err := ValidateEvent(event, v, now)
+	rejection, ok := err.(*config.Rejection)
+	if !ok || rejection.Reason != config.RejectIdentifyingTag || rejection.Param != "g" {
+		t.Fatalf("ValidateEvent() error = %v, want identifying-tag:g", err)
+	}
+	if !strings.HasPrefix(err.Error(), "blocked: identifying-tag:g ") {
+		t.Errorf("rejection = %q", err.Error())
+	}
+
+	v.TagPolicies["g"] = "strip"
+	if err := v.Validate(); err == nil {
+		t.Error("Validate() should refuse an unknown tag policy")
+	}
+}
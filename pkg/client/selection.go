@@ -0,0 +1,216 @@
+package client
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/girino/renoter/internal/descriptor"
+)
+
+// PathPolicy constrains how the path of each event is drawn from the configured Renoters.
+// The zero value sends every event through all of them, in random order.
+type PathPolicy struct {
+	// Renoters per path (0 = all configured Renoters)
+	Hops int
+	// Hex pubkeys of the Renoters the user trusts; all others are treated as unknown
+	Trusted map[string]bool
+	// Trusted Renoters every path must include
+	MinTrusted int
+	// Hex pubkeys of the guards: when set, the first hop (which sees the client's connections to
+	// the relays) is always one of them, like Tor guards, while the other hops vary
+	Guards map[string]bool
+
+	// Never put two hops declaring the same region on one path
+	DistinctRegions bool
+	// Never put two hops declaring the same network provider (ASN) on one path
+	DistinctASNs bool
+	// Service descriptors by pubkey with the self-declared locations, filled in by
+	// SetupRelayWithOptions. Renoters without a declared location are not constrained.
+	Descriptors map[string]*descriptor.Descriptor
+}
+
+// Validate checks the policy on its own; Check verifies it against the configured Renoters.
+func (p PathPolicy) Validate() error {
+	if p.Hops < 0 {
+		return fmt.Errorf("hops must not be negative, got %d", p.Hops)
+	}
+	if p.MinTrusted < 0 {
+		return fmt.Errorf("minimum trusted hops must not be negative, got %d", p.MinTrusted)
+	}
+	return nil
+}
+
+// PathLength returns the number of hops per path among pool configured Renoters.
+func (p PathPolicy) PathLength(pool int) int {
+	if p.Hops == 0 || p.Hops > pool {
+		return pool
+	}
+	return p.Hops
+}
+
+// split divides the configured Renoters into trusted and unknown ones.
+func (p PathPolicy) split(renterPath Path) (trusted, unknown Path) {
+	for _, node := range renterPath {
+		if p.Trusted[node.Key()] {
+			trusted = append(trusted, node)
+		} else {
+			unknown = append(unknown, node)
+		}
+	}
+	return trusted, unknown
+}
+
+// descriptor returns the service descriptor of a node: its own, or else the one in Descriptors.
+func (p PathPolicy) descriptor(node PathNode) *descriptor.Descriptor {
+	if node.Descriptor != nil {
+		return node.Descriptor
+	}
+	return p.Descriptors[node.Key()]
+}
+
+// conflict reports why two Renoters may not share a path, or "" if they may.
+func (p PathPolicy) conflict(a, b PathNode) string {
+	da, db := p.descriptor(a), p.descriptor(b)
+	if da == nil || db == nil {
+		return ""
+	}
+	if p.DistinctRegions && da.Region != "" && strings.EqualFold(da.Region, db.Region) {
+		return "region " + da.Region
+	}
+	if p.DistinctASNs && da.ASN != 0 && da.ASN == db.ASN {
+		return fmt.Sprintf("AS%d", da.ASN)
+	}
+	return ""
+}
+
+// Check reports whether paths satisfying the policy can be drawn from renterPath, explaining why not.
+func (p PathPolicy) Check(renterPath Path) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+	if p.Hops > len(renterPath) {
+		return fmt.Errorf("paths of %d hops need at least %d Renoters, but only %d are configured", p.Hops, p.Hops, len(renterPath))
+	}
+	hops := p.PathLength(len(renterPath))
+	if p.MinTrusted > hops {
+		return fmt.Errorf("at least %d trusted hops cannot fit in paths of %d hops", p.MinTrusted, hops)
+	}
+	if trusted, _ := p.split(renterPath); len(trusted) < p.MinTrusted {
+		return fmt.Errorf("at least %d trusted hops are required, but only %d of the %d configured Renoters are trusted", p.MinTrusted, len(trusted), len(renterPath))
+	}
+	if len(p.Guards) > 0 && p.count(renterPath, p.Guards) == 0 {
+		return fmt.Errorf("none of the %d guards is among the %d configured Renoters", len(p.Guards), len(renterPath))
+	}
+	if p.build(renterPath) == nil {
+		// Name one clash to point the user at the offending Renoters
+		for i := range renterPath {
+			for j := i + 1; j < len(renterPath); j++ {
+				if reason := p.conflict(renterPath[i], renterPath[j]); reason != "" {
+					return fmt.Errorf("no path of %d hops meets the policy with every hop in a distinct location (e.g. %s... and %s... both declare %s)",
+						hops, renterPath[i].Key()[:16], renterPath[j].Key()[:16], reason)
+				}
+			}
+		}
+		return fmt.Errorf("no path of %d hops has both %d trusted hops and a guard", hops, p.MinTrusted)
+	}
+	return nil
+}
+
+// count returns how many of renterPath are in set.
+func (p PathPolicy) count(renterPath Path, set map[string]bool) int {
+	n := 0
+	for _, node := range renterPath {
+		if set[node.Key()] {
+			n++
+		}
+	}
+	return n
+}
+
+// build searches candidates, in order, for a set of hops satisfying the policy. It returns nil if
+// none exists. The hops are in candidate order; SelectPath puts a guard first.
+func (p PathPolicy) build(candidates Path) Path {
+	hops := p.PathLength(len(candidates))
+	needGuard := 0
+	if len(p.Guards) > 0 {
+		needGuard = 1
+	}
+
+	var path Path
+	var search func(start, needTrusted, needGuard, trustedLeft, guardsLeft int) bool
+	search = func(start, needTrusted, needGuard, trustedLeft, guardsLeft int) bool {
+		if len(path) == hops {
+			return needTrusted == 0 && needGuard == 0
+		}
+		// Prune when the remaining candidates can no longer meet the thresholds
+		slots := hops - len(path)
+		if needTrusted > trustedLeft || needTrusted > slots || needGuard > guardsLeft || needGuard > slots {
+			return false
+		}
+		for i := start; i < len(candidates); i++ {
+			candidate := candidates[i]
+			key := candidate.Key()
+			isTrusted, isGuard := p.Trusted[key], p.Guards[key]
+			if isTrusted {
+				trustedLeft--
+			}
+			if isGuard {
+				guardsLeft--
+			}
+			clash := false
+			for _, hop := range path {
+				if p.conflict(hop, candidate) != "" {
+					clash = true
+					break
+				}
+			}
+			if clash {
+				continue
+			}
+			path = append(path, candidate)
+			nextTrusted, nextGuard := needTrusted, needGuard
+			if isTrusted && nextTrusted > 0 {
+				nextTrusted--
+			}
+			if isGuard {
+				nextGuard = 0
+			}
+			if search(i+1, nextTrusted, nextGuard, trustedLeft, guardsLeft) {
+				return true
+			}
+			path = path[:len(path)-1]
+		}
+		return false
+	}
+	if !search(0, p.MinTrusted, needGuard, p.count(candidates, p.Trusted), p.count(candidates, p.Guards)) {
+		return nil
+	}
+	return path
+}
+
+// SelectPath draws the path of one event from the configured Renoters: Hops random Renoters,
+// at least MinTrusted of them trusted and none sharing a declared location when asked to, in
+// random order except that a guard, if any are set, comes first. The original slice is not modified.
+func SelectPath(renterPath Path, policy PathPolicy) (Path, error) {
+	if err := policy.Check(renterPath); err != nil {
+		logging.Error("client.selection.SelectPath: cannot build a path: %v", err)
+		return nil, err
+	}
+
+	// A search over shuffled candidates yields a random valid path
+	path := policy.build(ShufflePath(renterPath))
+	rand.Shuffle(len(path), func(i, j int) { path[i], path[j] = path[j], path[i] })
+	if len(policy.Guards) > 0 {
+		for i, node := range path {
+			if policy.Guards[node.Key()] {
+				path[0], path[i] = path[i], path[0]
+				break
+			}
+		}
+	}
+
+	logging.DebugMethod("client.selection", "SelectPath", "Selected %d of %d Renoters (at least %d trusted)", len(path), len(renterPath), policy.MinTrusted)
+	return path, nil
+}
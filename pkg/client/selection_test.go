@@ -0,0 +1,161 @@
+package client
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/girino/renoter/internal/descriptor"
+)
+
+// testRenoters returns n distinct fake Renoter pubkeys.
+func testRenoters(n int) Path {
+	path := make(Path, n)
+	for i := range path {
+		path[i].PubKey = make([]byte, 32)
+		path[i].PubKey[0] = byte(i + 1)
+	}
+	return path
+}
+
+func TestPathPolicy_Check(t *testing.T) {
+	path := testRenoters(4)
+	trusted := map[string]bool{path[0].Key(): true, path[1].Key(): true}
+
+	tests := []struct {
+		name    string
+		policy  PathPolicy
+		wantErr string
+	}{
+		{"default", PathPolicy{}, ""},
+		{"subset", PathPolicy{Hops: 2, Trusted: trusted, MinTrusted: 2}, ""},
+		{"too many hops", PathPolicy{Hops: 5}, "only 4 are configured"},
+		{"threshold above hops", PathPolicy{Hops: 2, Trusted: trusted, MinTrusted: 3}, "cannot fit in paths of 2 hops"},
+		{"too few trusted", PathPolicy{Trusted: trusted, MinTrusted: 3}, "only 2 of the 4 configured Renoters are trusted"},
+		{"negative", PathPolicy{Hops: -1}, "must not be negative"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.policy.Check(path)
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("Check() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Check() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSelectPath_TrustedThreshold(t *testing.T) {
+	path := testRenoters(6)
+	trusted := map[string]bool{path[4].Key(): true, path[5].Key(): true}
+	policy := PathPolicy{Hops: 3, Trusted: trusted, MinTrusted: 2}
+
+	for i := 0; i < 50; i++ {
+		selected, err := SelectPath(path, policy)
+		if err != nil {
+			t.Fatalf("SelectPath() error = %v", err)
+		}
+		if len(selected) != 3 {
+			t.Fatalf("SelectPath() = %d hops, want 3", len(selected))
+		}
+		seen, trustedHops := map[string]bool{}, 0
+		for _, node := range selected {
+			key := node.Key()
+			if seen[key] {
+				t.Fatalf("SelectPath() repeated Renoter %s", key[:8])
+			}
+			seen[key] = true
+			if trusted[key] {
+				trustedHops++
+			}
+		}
+		if trustedHops < 2 {
+			t.Fatalf("SelectPath() = %d trusted hops, want at least 2", trustedHops)
+		}
+	}
+
+	// The configured path is left untouched
+	if path[4].PubKey[0] != 5 || path[5].PubKey[0] != 6 {
+		t.Error("SelectPath() modified the configured Renoters")
+	}
+
+	if _, err := SelectPath(path, PathPolicy{Trusted: trusted, MinTrusted: 3}); err == nil {
+		t.Error("SelectPath() should refuse an unsatisfiable policy")
+	}
+	if selected, _ := SelectPath(path, PathPolicy{}); len(selected) != len(path) {
+		t.Errorf("SelectPath() with the default policy = %d hops, want all %d", len(selected), len(path))
+	}
+}
+
+func TestSelectPath_DistinctLocations(t *testing.T) {
+	path := testRenoters(4)
+	located := func(region string, asn uint32) *descriptor.Descriptor {
+		return &descriptor.Descriptor{Region: region, ASN: asn}
+	}
+	descriptors := map[string]*descriptor.Descriptor{
+		path[0].Key(): located("DE", 24940),
+		path[1].Key(): located("de", 16276),
+		path[2].Key(): located("FR", 16276),
+		// path[3] declares nothing and is not constrained
+	}
+	trusted := map[string]bool{path[0].Key(): true, path[1].Key(): true}
+
+	policy := PathPolicy{Hops: 3, DistinctRegions: true, Descriptors: descriptors}
+	for i := 0; i < 50; i++ {
+		selected, err := SelectPath(path, policy)
+		if err != nil {
+			t.Fatalf("SelectPath() error = %v", err)
+		}
+		for a := range selected {
+			for b := a + 1; b < len(selected); b++ {
+				if reason := policy.conflict(selected[a], selected[b]); reason != "" {
+					t.Fatalf("SelectPath() put two hops in %s", reason)
+				}
+			}
+		}
+	}
+
+	// Both trusted Renoters are in Germany, so two trusted hops can never be distinct
+	policy.Trusted, policy.MinTrusted = trusted, 2
+	if err := policy.Check(path); err == nil || !strings.Contains(err.Error(), "region DE") {
+		t.Errorf("Check() error = %v, want the region clash explained", err)
+	}
+
+	// Provider diversity alone allows 0 and 1 together, but not 1 and 2 (same ASN)
+	policy = PathPolicy{Hops: 3, DistinctASNs: true, Trusted: trusted, MinTrusted: 2, Descriptors: descriptors}
+	for i := 0; i < 20; i++ {
+		selected, err := SelectPath(path, policy)
+		if err != nil {
+			t.Fatalf("SelectPath() error = %v", err)
+		}
+		for _, node := range selected {
+			if node.PubKey[0] == path[2].PubKey[0] {
+				t.Fatal("SelectPath() paired Renoters sharing AS16276")
+			}
+		}
+	}
+
+	// Using every Renoter cannot avoid the clash
+	if err := (PathPolicy{DistinctASNs: true, Descriptors: descriptors}).Check(path); err == nil {
+		t.Error("Check() should fail when all Renoters must be used and two share a provider")
+	}
+}
+
+func TestSelectPath_NodeDescriptors(t *testing.T) {
+	// Descriptors carried by the nodes constrain the path like PathPolicy.Descriptors
+	path := testRenoters(3).withDescriptors(map[string]*descriptor.Descriptor{
+		testRenoters(3)[0].Key(): {ASN: 16276},
+		testRenoters(3)[1].Key(): {ASN: 16276},
+	})
+	policy := PathPolicy{Hops: 2, DistinctASNs: true}
+	for i := 0; i < 20; i++ {
+		selected, err := SelectPath(path, policy)
+		if err != nil {
+			t.Fatalf("SelectPath() error = %v", err)
+		}
+		if selected[0].Descriptor != nil && selected[1].Descriptor != nil {
+			t.Fatal("SelectPath() paired Renoters whose descriptors share AS16276")
+		}
+	}
+}
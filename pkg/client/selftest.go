@@ -0,0 +1,162 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/girino/renoter/internal/config"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// subscriptionWarmUp lets subscriptions reach the relays before the event they wait for is sent.
+const subscriptionWarmUp = 500 * time.Millisecond
+
+// SelfTestResult is the outcome of a self-test: where the probe got to and how long each hop took.
+type SelfTestResult struct {
+	// ID of the probe note and of the 29001 container it was sent in
+	ProbeID   string
+	WrappedID string
+	// Time spent wrapping and mining the probe
+	WrapTime time.Duration
+	// Server relays that accepted the container, out of all server relays
+	Accepted, Relays int
+	// The hops of the path drawn for the probe, entry first
+	Hops []SelfTestHop
+	// Whether the probe appeared on the server relays, and how long after it was published
+	Delivered bool
+	Total     time.Duration
+}
+
+// SelfTestHop is the timing of one Renoter on the self-test path.
+type SelfTestHop struct {
+	// Hex pubkey of the Renoter
+	Renoter string
+	// Whether its output (the next hop's container, or the probe for the exit) was seen
+	Observed bool
+	// Time from its input appearing on the relays to its output appearing
+	Elapsed time.Duration
+}
+
+// String renders the result as a multi-line report.
+func (r *SelfTestResult) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Probe %s wrapped in %v as container %s, accepted by %d/%d relays\n", r.ProbeID, r.WrapTime.Round(time.Millisecond), r.WrappedID, r.Accepted, r.Relays)
+	for i, hop := range r.Hops {
+		if hop.Observed {
+			fmt.Fprintf(&b, "  hop %d %s...: forwarded after %v\n", i+1, hop.Renoter[:16], hop.Elapsed.Round(time.Millisecond))
+		} else {
+			fmt.Fprintf(&b, "  hop %d %s...: not observed\n", i+1, hop.Renoter[:16])
+		}
+	}
+	if r.Delivered {
+		fmt.Fprintf(&b, "Delivered after %v", r.Total.Round(time.Millisecond))
+	} else {
+		b.WriteString("Not delivered")
+	}
+	return b.String()
+}
+
+// SelfTest sends a probe note signed by and addressed to a throwaway key through a path drawn
+// like any other event's, over the real Renoters and server relays, and waits until the probe
+// appears on the server relays or ctx is done. Every hop's forwarded container is watched for
+// on the relays to time the hops; a container from unrelated traffic that reaches the same
+// Renoter first can skew a hop's timing, so the figures are approximate on busy relays.
+// It returns an error only if the probe could not be sent; a lost probe is reported in the result.
+func (d *Dispatcher) SelfTest(ctx context.Context) (*SelfTestResult, error) {
+	sk := nostr.GeneratePrivateKey()
+	pk, _ := nostr.GetPublicKey(sk)
+	probe := &nostr.Event{
+		Kind:      1,
+		Content:   fmt.Sprintf("renoter self-test %s", nostr.GeneratePrivateKey()[:16]),
+		CreatedAt: nostr.Now(),
+		Tags:      nostr.Tags{{"p", pk}},
+	}
+	if err := probe.Sign(sk); err != nil {
+		return nil, fmt.Errorf("failed to sign probe: %w", err)
+	}
+
+	path, err := SelectPath(d.renterPath, d.opts.PathPolicy)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select a path: %w", err)
+	}
+	result := &SelfTestResult{ProbeID: probe.ID, Relays: len(d.serverRelayURLs)}
+	for _, key := range path.Keys() {
+		result.Hops = append(result.Hops, SelfTestHop{Renoter: key})
+	}
+
+	// Watch for the containers forwarded along the path and for the probe itself
+	var observer Pool = d.opts.ServerPool
+	if observer == nil {
+		observer = nostr.NewSimplePool(ctx)
+	}
+	since := nostr.Now()
+	containers := observer.SubscribeMany(ctx, d.serverRelayURLs, nostr.Filter{
+		Kinds: []int{config.StandardizedWrapperKind},
+		Tags:  nostr.TagMap{"p": path.Keys()},
+		Since: &since,
+	})
+	probes := observer.SubscribeMany(ctx, d.serverRelayURLs, nostr.Filter{IDs: []string{probe.ID}})
+	select {
+	case <-time.After(subscriptionWarmUp):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	start := time.Now()
+	wrapped, err := WrapEvent(ctx, probe, path, WrapOptions{Options: d.opts})
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap probe: %w", err)
+	}
+	result.WrappedID, result.WrapTime = wrapped.ID, time.Since(start)
+
+	for publishResult := range d.opts.Hedge.publish(ctx, d.serverPool, d.serverRelayURLs, *wrapped) {
+		if publishResult.Error != nil {
+			logging.Warn("client.selftest.SelfTest: relay %s rejected the probe container: %v", publishResult.RelayURL, publishResult.Error)
+			continue
+		}
+		result.Accepted++
+	}
+	if result.Accepted == 0 {
+		return result, fmt.Errorf("no server relay accepted the probe container")
+	}
+	published := time.Now()
+	logging.Info("client.selftest.SelfTest: Sent probe %s through %d hops as container %s", probe.ID, len(path), wrapped.ID)
+
+	// Hop i's output is the first new container addressed to hop i+1, or the probe for the exit
+	hop, last := 0, published
+	for {
+		select {
+		case <-ctx.Done():
+			return result, nil
+		case relayEvent, ok := <-containers:
+			if !ok {
+				containers = nil
+				continue
+			}
+			container := relayEvent.Event
+			if container.ID == wrapped.ID || hop+1 >= len(path) || container.Tags.FindWithValue("p", path[hop+1].Key()) == nil {
+				continue
+			}
+			now := time.Now()
+			result.Hops[hop].Observed, result.Hops[hop].Elapsed = true, now.Sub(last)
+			hop, last = hop+1, now
+		case relayEvent, ok := <-probes:
+			if !ok {
+				probes = nil
+				continue
+			}
+			if relayEvent.Event.ID != probe.ID {
+				continue
+			}
+			now := time.Now()
+			exit := len(path) - 1
+			result.Hops[exit].Observed, result.Hops[exit].Elapsed = true, now.Sub(last)
+			result.Delivered, result.Total = true, now.Sub(published)
+			logging.Info("client.selftest.SelfTest: Probe %s delivered after %v", probe.ID, result.Total)
+			return result, nil
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package client
+
+import (
+	"context"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/girino/renoter/pkg/server"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestDispatcher_SelfTest(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	relay, _, pool := newDispatcherTestSetup(t, ctx)
+	var path Path
+	for i := 0; i < 2; i++ {
+		renoter, err := server.NewRenoter(ctx, nostr.GeneratePrivateKey(), []string{relay.URL()})
+		if err != nil {
+			t.Fatalf("NewRenoter() error = %v", err)
+		}
+		if err := renoter.SubscribeToWrappedEvents(ctx); err != nil {
+			t.Fatalf("SubscribeToWrappedEvents() error = %v", err)
+		}
+		pubkey, _ := hex.DecodeString(renoter.GetPublicKey())
+		path = append(path, NewPath(pubkey)...)
+	}
+	time.Sleep(200 * time.Millisecond)
+
+	dispatcher, err := NewDispatcher(ctx, path, pool, []string{relay.URL()}, DefaultOptions())
+	if err != nil {
+		t.Fatalf("NewDispatcher() error = %v", err)
+	}
+	result, err := dispatcher.SelfTest(ctx)
+	if err != nil {
+		t.Fatalf("SelfTest() error = %v", err)
+	}
+	if !result.Delivered {
+		t.Fatalf("SelfTest() did not deliver the probe:\n%s", result)
+	}
+	if result.Accepted != 1 || len(result.Hops) != 2 {
+		t.Errorf("SelfTest() = %d/%d relays, %d hops; want 1/1 and 2", result.Accepted, result.Relays, len(result.Hops))
+	}
+	for i, hop := range result.Hops {
+		if !hop.Observed {
+			t.Errorf("hop %d was not observed", i+1)
+		}
+	}
+	if report := result.String(); !strings.Contains(report, "Delivered after") {
+		t.Errorf("String() = %q, want the delivery time", report)
+	}
+}
@@ -0,0 +1,320 @@
+package client
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/girino/renoter/internal/compact"
+	"github.com/girino/renoter/internal/config"
+	"github.com/girino/renoter/internal/padding"
+	"github.com/girino/renoter/internal/stamp"
+	"github.com/girino/renoter/pkg/protocol"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// delayOverhead is the size the sealed delay tag adds to every 29000 of an event in the mixed lane.
+var delayOverhead = protocol.SealedTagOverhead(config.DelayTag, config.FormatDelayHint(config.MaxDelayHint))
+
+// reportOverhead is the size the sealed report tag adds to every 29000 of an event asking for
+// timing trailers.
+var reportOverhead = protocol.SealedTagOverhead(config.ReportTag, strings.Repeat("0", 64))
+
+// ackOverhead is the size the sealed ack tag adds to every 29000 of an event asking for hop
+// acknowledgements.
+var ackOverhead = protocol.SealedTagOverhead(config.AckTag, strconv.Itoa(config.ProtocolVersion))
+
+// replyOverhead is the size the sealed reply tag adds to every 29000 of an event asking for error
+// notices.
+var replyOverhead = protocol.SealedTagOverhead(config.ReplyTag, strings.Repeat("0", 64))
+
+// stampOverhead is the size the stamp marker adds to the nonce tag of a stamped 29000.
+var stampOverhead = protocol.TagOverhead{
+	// Plus the comma separating it from the target
+	JSON:    1 + len(`"`+stamp.Marker+`"`),
+	Compact: compact.LengthPrefix(len(stamp.Marker)) + len(stamp.Marker),
+}
+
+// paymentOverhead is the room reserved for the sealed payment proof in the 29000 addressed to a
+// paid Renoter, for a verify URL of up to maxVerifyURLLength bytes.
+var paymentOverhead = protocol.SealedTagOverhead(paymentTag, strings.Repeat("0", maxVerifyURLLength), strings.Repeat("0", 64))
+
+// cashuOverhead is the room reserved for the sealed Cashu token in the 29000 addressed to a
+// Cashu-admitting Renoter, for a token of up to maxCashuTokenLength bytes.
+var cashuOverhead = protocol.SealedTagOverhead(cashuTag, strings.Repeat("0", maxCashuTokenLength))
+
+// layerFormat is what the size of the layers of a wrapped event depends on besides its content.
+type layerFormat struct {
+	// Delivery lane; layers in config.LaneMixed carry a delay tag
+	lane string
+	// Layers carry a report tag asking for timing trailers
+	report bool
+	// Layers carry an ack tag asking for hop acknowledgements
+	acks bool
+	// Layers carry a reply tag asking for error notices
+	notices bool
+	// Layers hold compact events instead of JSON, and the original event is measured compact
+	compact bool
+	// Layers may carry a stamp, whose nonce tag has one more value
+	stamps bool
+	// Room reserved for payment proofs and Cashu tokens, by layer from the innermost
+	payments [][]protocol.TagOverhead
+}
+
+// layerFormat returns the layer format of events wrapped with these options.
+func (o Options) layerFormat() layerFormat {
+	return layerFormat{lane: o.Lane, report: o.ReportLatency, acks: o.hopAcks, notices: o.replyTo != "", compact: o.CompactLayers, stamps: o.Premine > 0}
+}
+
+// layers returns the format as the protocol size model takes it, with the overheads of the
+// optional tags it adds to every layer.
+func (f layerFormat) layers() protocol.LayerFormat {
+	var tags []protocol.TagOverhead
+	if f.lane == config.LaneMixed {
+		tags = append(tags, delayOverhead)
+	}
+	if f.report {
+		tags = append(tags, reportOverhead)
+	}
+	if f.acks {
+		tags = append(tags, ackOverhead)
+	}
+	if f.notices {
+		tags = append(tags, replyOverhead)
+	}
+	if f.stamps {
+		tags = append(tags, stampOverhead)
+	}
+	return protocol.LayerFormat{Compact: f.compact, Tags: tags, LayerTags: f.payments}
+}
+
+// paymentLayers returns the room to reserve for the payment proofs and Cashu tokens of the layers
+// addressed to paid and Cashu-admitting Renoters among recipients, by layer from the innermost,
+// or nil if there are none.
+func (o Options) paymentLayers(recipients []string) [][]protocol.TagOverhead {
+	var layers [][]protocol.TagOverhead
+	for i, pubkey := range recipients {
+		var tags []protocol.TagOverhead
+		if o.Payer != nil && o.PaidRenoters[pubkey] != nil {
+			tags = append(tags, paymentOverhead)
+		}
+		if o.Cashu != nil && o.CashuRenoters[pubkey] != nil {
+			tags = append(tags, cashuOverhead)
+		}
+		if tags == nil {
+			continue
+		}
+		if layers == nil {
+			layers = make([][]protocol.TagOverhead, len(recipients))
+		}
+		layers[len(recipients)-1-i] = tags
+	}
+	return layers
+}
+
+// WrappedSize returns an upper bound on the serialized size of the outermost 29000 wrapper
+// produced for an original event of originalSize bytes (its JSON) over a path of pathLength hops.
+// Base64 ciphertext never needs JSON escaping, so the only slack is the PoW nonce length.
+func WrappedSize(originalSize, pathLength int) int {
+	return wrappedSize(originalSize, pathLength, layerFormat{})
+}
+
+// wrappedSize is WrappedSize for layers in format (see protocol.WrappedSize).
+func wrappedSize(originalSize, pathLength int, format layerFormat) int {
+	return protocol.WrappedSize(originalSize, pathLength, format.layers())
+}
+
+// MaxOriginalEventSize returns the largest original event JSON size that is guaranteed to fit
+// within limits.MaxInnerEventSize after wrapping for pathLength hops, or 0 if nothing fits.
+func MaxOriginalEventSize(pathLength int, limits config.SizeLimits) int {
+	return maxOriginalEventSize(pathLength, limits, layerFormat{})
+}
+
+// maxOriginalEventSize is MaxOriginalEventSize for layers in format, measured compact with
+// compact layers.
+func maxOriginalEventSize(pathLength int, limits config.SizeLimits, format layerFormat) int {
+	return protocol.MaxPayload(pathLength, limits, format.layers())
+}
+
+// SizeBudget is the size budget of events wrapped for a path, derived once from the path length,
+// the size limits and the layer format, so that every size the client checks follows from the
+// same numbers: an original event of up to MaxEventSize bytes wraps into an outermost 29000 of at
+// most MaxWrappedSize bytes, which is padded to at most PaddedSize bytes and sent in a 29001
+// container whose content is ContainerContentSize bytes long. Events are checked against it
+// before anything is paid or mined. Room for sealed payment proofs and Cashu tokens, whose size
+// is only known once paid, is reserved at the most they may take (see withPayments), so an event
+// that passes is never refused after paying; the wrapped size is still checked again.
+type SizeBudget struct {
+	// Hops of the path
+	PathLength int
+	// Largest original event accepted, serialized as the innermost layer holds it (0 = none fits)
+	MaxEventSize int
+	// Largest outermost 29000 before padding, the limits' MaxInnerEventSize
+	MaxWrappedSize int
+	// Size the largest outermost 29000 is padded to, the plaintext of its 29001 container
+	PaddedSize int
+	// Length of the content of that 29001 container
+	ContainerContentSize int
+
+	limits config.SizeLimits
+	format layerFormat
+}
+
+// NewSizeBudget returns the size budget of events wrapped for pathLength hops under limits, with
+// JSON layers and no optional tags.
+func NewSizeBudget(pathLength int, limits config.SizeLimits) SizeBudget {
+	return newSizeBudget(pathLength, limits, layerFormat{})
+}
+
+// newSizeBudget is NewSizeBudget for layers in format.
+func newSizeBudget(pathLength int, limits config.SizeLimits, format layerFormat) SizeBudget {
+	padded := limits.Bucket(limits.MaxInnerEventSize + config.PaddingTagOverhead)
+	return SizeBudget{
+		PathLength:           pathLength,
+		MaxEventSize:         maxOriginalEventSize(pathLength, limits, format),
+		MaxWrappedSize:       limits.MaxInnerEventSize,
+		PaddedSize:           padded,
+		ContainerContentSize: config.ContainerContentSize(padded),
+		limits:               limits,
+		format:               format,
+	}
+}
+
+// withPayments returns the budget with room reserved for payment proofs and Cashu tokens in the
+// layers given by Options.paymentLayers.
+func (b SizeBudget) withPayments(layers [][]protocol.TagOverhead) SizeBudget {
+	if layers == nil {
+		return b
+	}
+	b.format.payments = layers
+	b.MaxEventSize = maxOriginalEventSize(b.PathLength, b.limits, b.format)
+	return b
+}
+
+// sizeBudget returns the budget of events wrapped with these options for pathLength hops, in lane
+// ("" = o.Lane).
+func (o Options) sizeBudget(pathLength int, lane string) SizeBudget {
+	format := o.layerFormat()
+	if lane != "" {
+		format.lane = lane
+	}
+	return newSizeBudget(pathLength, o.Limits, format)
+}
+
+// SizeBudget returns the budget of events wrapped with these options for pathLength hops in any
+// lane, for its NIP-11 document: the mixed lane leaves less room.
+func (o Options) SizeBudget(pathLength int) SizeBudget {
+	return newSizeBudget(pathLength, o.Limits, o.worstLayerFormat())
+}
+
+// CheckEvent reports whether an original event fits the budget. Oversized events get a
+// *config.Rejection with config.RejectSizeExceeded.
+func (b SizeBudget) CheckEvent(originalEvent *nostr.Event) error {
+	size, err := b.eventSize(originalEvent)
+	if err != nil {
+		logging.Error("client.sizing.CheckEvent: failed to serialize original event for size check: %v", err)
+		return fmt.Errorf("failed to serialize event: %w", err)
+	}
+	if size > b.MaxEventSize {
+		logging.Error("client.sizing.CheckEvent: event size %d bytes exceeds maximum %d bytes for a %d-hop path", size, b.MaxEventSize, b.PathLength)
+		return config.NewRejection(config.RejectSizeExceeded, strconv.Itoa(b.MaxEventSize),
+			fmt.Sprintf("event too large: event size %d bytes exceeds maximum %d bytes for a %d-hop path", size, b.MaxEventSize, b.PathLength))
+	}
+	return nil
+}
+
+// eventSize returns the size of an original event as the innermost layer holds it.
+func (b SizeBudget) eventSize(originalEvent *nostr.Event) (int, error) {
+	encode := padding.Marshal
+	if b.format.compact {
+		encode = compact.Encode
+	}
+	encoded, err := encode(originalEvent)
+	return len(encoded), err
+}
+
+// bucket returns the size an outermost 29000 of wrappedSize bytes is padded to, or a
+// *config.Rejection if it exceeds the budget.
+func (b SizeBudget) bucket(wrappedSize int) (int, error) {
+	if wrappedSize > b.MaxWrappedSize {
+		logging.Error("client.sizing.bucket: outermost 29000 event size %d bytes exceeds maximum %d bytes", wrappedSize, b.MaxWrappedSize)
+		return 0, config.NewRejection(config.RejectSizeExceeded, strconv.Itoa(b.MaxWrappedSize),
+			fmt.Sprintf("event too large: outermost 29000 event size %d bytes exceeds maximum %d bytes", wrappedSize, b.MaxWrappedSize))
+	}
+	return b.limits.Bucket(wrappedSize + config.PaddingTagOverhead), nil
+}
+
+// containerBucket returns the size the 29000 inside a 29001 container built with limits was
+// padded to, from the length of its ciphertext, or 0 if no bucket matches.
+func containerBucket(container *nostr.Event, limits config.SizeLimits) int {
+	for _, bucket := range limits.Sizes() {
+		if protocol.ContainerContentSize(bucket) == len(container.Content) {
+			return bucket
+		}
+	}
+	return 0
+}
+
+// eventOverhead is the serialized size of a signed event with no tags, empty content and the
+// longest possible fields, so eventOverhead + len(content) bounds a tagless event whose content
+// needs no escaping.
+var eventOverhead, compactEventOverhead = computeEventOverhead()
+
+func computeEventOverhead() (int, int) {
+	template := nostr.Event{
+		ID:        strings.Repeat("0", 64),
+		PubKey:    strings.Repeat("0", 64),
+		CreatedAt: nostr.Timestamp(9999999999),
+		Kind:      math.MaxUint16,
+		Tags:      nostr.Tags{},
+		Sig:       strings.Repeat("0", 128),
+	}
+	templateJSON, _ := padding.Marshal(&template)
+	encoded, _ := compact.Encode(&template)
+	return len(templateJSON), len(encoded)
+}
+
+// MaxContentSize returns the longest content, in serialized bytes, a tagless event can carry
+// over a path of pathLength hops, or 0 if nothing fits. Tags, and characters JSON escapes,
+// leave less room; CheckEventSize has the final word.
+func MaxContentSize(pathLength int, limits config.SizeLimits) int {
+	return NewSizeBudget(pathLength, limits).MaxContentSize()
+}
+
+// MaxContentSize returns the longest content, in serialized bytes, a tagless event within the
+// budget can carry, or 0 if nothing fits. Tags, and characters JSON escapes, leave less room;
+// CheckEvent has the final word.
+func (b SizeBudget) MaxContentSize() int {
+	if !b.format.compact {
+		return max(b.MaxEventSize-eventOverhead, 0)
+	}
+	// Compact content is stored behind a length prefix that grows with it
+	n := b.MaxEventSize - compactEventOverhead
+	for n > 0 && compactEventOverhead-compact.LengthPrefix(0)+compact.LengthPrefix(n)+n > b.MaxEventSize {
+		n--
+	}
+	return max(n, 0)
+}
+
+// MaxContentSize returns the longest content the client accepts over a path of pathLength hops
+// in any lane, for its NIP-11 document: the mixed lane leaves less room.
+func (o Options) MaxContentSize(pathLength int) int {
+	return o.SizeBudget(pathLength).MaxContentSize()
+}
+
+// MaxEventSize returns the largest serialized event the client accepts over a path of
+// pathLength hops in any lane.
+func (o Options) MaxEventSize(pathLength int) int {
+	return o.SizeBudget(pathLength).MaxEventSize
+}
+
+// worstLayerFormat is the layer format of the lane leaving the least room.
+func (o Options) worstLayerFormat() layerFormat {
+	format := o.layerFormat()
+	if o.MixingDelay > 0 {
+		format.lane = config.LaneMixed
+	}
+	return format
+}
@@ -0,0 +1,313 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/girino/renoter/internal/compact"
+	"github.com/girino/renoter/internal/config"
+	"github.com/girino/renoter/internal/padding"
+	"github.com/girino/renoter/internal/sealtag"
+	"github.com/girino/renoter/internal/stamp"
+	"github.com/girino/renoter/pkg/protocol"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/nbd-wtf/go-nostr/nip44"
+)
+
+func TestMaxOriginalEventSize(t *testing.T) {
+	limits := config.DefaultSizeLimits()
+	previous := limits.MaxInnerEventSize
+	for hops := 1; hops <= 5; hops++ {
+		max := MaxOriginalEventSize(hops, limits)
+		if max <= 0 || max >= previous {
+			t.Fatalf("MaxOriginalEventSize(%d) = %d, want positive and below %d", hops, max, previous)
+		}
+		if WrappedSize(max, hops) > limits.MaxInnerEventSize {
+			t.Errorf("WrappedSize(%d, %d) = %d exceeds limit %d", max, hops, WrappedSize(max, hops), limits.MaxInnerEventSize)
+		}
+		if WrappedSize(max+1, hops) <= limits.MaxInnerEventSize {
+			t.Errorf("MaxOriginalEventSize(%d) = %d is not the largest fitting size", hops, max)
+		}
+		// Other implementations read the same limit from the protocol package
+		if got := protocol.MaxInnerPayload(hops); got != max {
+			t.Errorf("protocol.MaxInnerPayload(%d) = %d, want %d", hops, got, max)
+		}
+		previous = max
+	}
+
+	tiny := config.SizeLimits{StandardizedSize: 100, MaxInnerEventSize: 85}
+	if max := MaxOriginalEventSize(1, tiny); max != 0 {
+		t.Errorf("MaxOriginalEventSize() with tiny limits = %d, want 0", max)
+	}
+}
+
+func TestWrappedSize_MatchesProtocol(t *testing.T) {
+	limits := config.DefaultSizeLimits()
+	stamped := protocol.TagOverhead{JSON: 1 + len(`"`+stamp.Marker+`"`), Compact: compact.LengthPrefix(len(stamp.Marker)) + len(stamp.Marker)}
+	tests := []struct {
+		name   string
+		format layerFormat
+		want   protocol.LayerFormat
+	}{
+		{"json", layerFormat{}, protocol.LayerFormat{}},
+		{"compact", layerFormat{compact: true}, protocol.LayerFormat{Compact: true}},
+		{"mixed lane", layerFormat{lane: config.LaneMixed}, protocol.LayerFormat{Tags: []protocol.TagOverhead{
+			protocol.SealedTagOverhead(config.DelayTag, config.FormatDelayHint(config.MaxDelayHint)),
+		}}},
+		{"report and acks", layerFormat{report: true, acks: true}, protocol.LayerFormat{Tags: []protocol.TagOverhead{
+			protocol.SealedTagOverhead(config.ReportTag, strings.Repeat("0", 64)),
+			protocol.SealedTagOverhead(config.AckTag, strconv.Itoa(config.ProtocolVersion)),
+		}}},
+		{"compact with everything", layerFormat{lane: config.LaneMixed, report: true, acks: true, notices: true, compact: true, stamps: true}, protocol.LayerFormat{Compact: true, Tags: []protocol.TagOverhead{
+			protocol.SealedTagOverhead(config.DelayTag, config.FormatDelayHint(config.MaxDelayHint)),
+			protocol.SealedTagOverhead(config.ReportTag, strings.Repeat("0", 64)),
+			protocol.SealedTagOverhead(config.AckTag, strconv.Itoa(config.ProtocolVersion)),
+			protocol.SealedTagOverhead(config.ReplyTag, strings.Repeat("0", 64)),
+			stamped,
+		}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for hops := 1; hops <= 5; hops++ {
+				for _, size := range []int{0, 100, 10000} {
+					if got, want := wrappedSize(size, hops, tt.format), protocol.WrappedSize(size, hops, tt.want); got != want {
+						t.Errorf("wrappedSize(%d, %d) = %d, protocol.WrappedSize() = %d", size, hops, got, want)
+					}
+				}
+				if got, want := maxOriginalEventSize(hops, limits, tt.format), protocol.MaxPayload(hops, limits, tt.want); got != want {
+					t.Errorf("maxOriginalEventSize(%d) = %d, protocol.MaxPayload() = %d", hops, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestWrappedSize_BoundsRealWrap(t *testing.T) {
+	sk1 := nostr.GeneratePrivateKey()
+	npub1, _ := nip19.EncodePublicKey(mustPublicKey(t, sk1))
+	sk2 := nostr.GeneratePrivateKey()
+	npub2, _ := nip19.EncodePublicKey(mustPublicKey(t, sk2))
+	path, _ := ValidatePath([]string{npub1, npub2})
+
+	event := &nostr.Event{
+		Kind:      1,
+		Content:   strings.Repeat("size model ", 50),
+		CreatedAt: nostr.Now(),
+		Tags:      nostr.Tags{{"t", "renoter"}},
+	}
+	event.Sign(nostr.GeneratePrivateKey())
+	originalJSON, _ := json.Marshal(event)
+
+	wrapped, err := WrapEvent(context.Background(), event, path, DefaultWrapOptions())
+	if err != nil {
+		t.Fatalf("WrapEvent() error = %v", err)
+	}
+
+	// Recover the outermost 29000 as the first Renoter sees it
+	conversationKey, _ := nip44.GenerateConversationKey(wrapped.PubKey, sk1)
+	plaintext, err := nip44.Decrypt(wrapped.Content, conversationKey)
+	if err != nil {
+		t.Fatalf("Decrypt() error = %v", err)
+	}
+	var outermost nostr.Event
+	if err := json.Unmarshal([]byte(plaintext), &outermost); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	outermost.Tags = padding.StripPadding(outermost.Tags)
+	outermostJSON, _ := json.Marshal(outermost)
+
+	bound := WrappedSize(len(originalJSON), len(path))
+	if len(outermostJSON) > bound {
+		t.Errorf("actual outermost size %d exceeds model bound %d", len(outermostJSON), bound)
+	}
+	// The only slack in the model is the PoW nonce (at most 20 digits per layer)
+	if slack := bound - len(outermostJSON); slack > 20*len(path) {
+		t.Errorf("model bound %d is %d bytes above actual size %d", bound, slack, len(outermostJSON))
+	}
+}
+
+func TestWrappedSize_BoundsCompactWrap(t *testing.T) {
+	sks := []string{nostr.GeneratePrivateKey(), nostr.GeneratePrivateKey(), nostr.GeneratePrivateKey()}
+	path, _ := ValidatePath([]string{mustPublicKey(t, sks[0]), mustPublicKey(t, sks[1]), mustPublicKey(t, sks[2])})
+
+	event := &nostr.Event{Kind: 1, Content: strings.Repeat("compact ", 500), CreatedAt: nostr.Now(), Tags: nostr.Tags{{"t", "renoter"}}}
+	event.Sign(nostr.GeneratePrivateKey())
+	encoded, _ := compact.Encode(event)
+
+	opts := DefaultOptions()
+	opts.CompactLayers = true
+	wrapped, err := WrapEvent(context.Background(), event, path, WrapOptions{Options: opts})
+	if err != nil {
+		t.Fatalf("WrapEvent() error = %v", err)
+	}
+
+	conversationKey, _ := nip44.GenerateConversationKey(wrapped.PubKey, sks[0])
+	plaintext, err := nip44.Decrypt(wrapped.Content, conversationKey)
+	if err != nil {
+		t.Fatalf("Decrypt() error = %v", err)
+	}
+	var outermost nostr.Event
+	if err := json.Unmarshal([]byte(plaintext), &outermost); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	outermost.Tags = padding.StripPadding(outermost.Tags)
+	outermostJSON, _ := json.Marshal(outermost)
+
+	bound := wrappedSize(len(encoded), len(path), layerFormat{compact: true})
+	if len(outermostJSON) > bound {
+		t.Errorf("actual outermost size %d exceeds compact model bound %d", len(outermostJSON), bound)
+	}
+	if slack := bound - len(outermostJSON); slack > 20*len(path) {
+		t.Errorf("compact model bound %d is %d bytes above actual size %d", bound, slack, len(outermostJSON))
+	}
+
+	// Over three hops compact layers fit noticeably larger events
+	limits := config.DefaultSizeLimits()
+	if jsonMax, compactMax := MaxOriginalEventSize(3, limits), maxOriginalEventSize(3, limits, layerFormat{compact: true}); compactMax < jsonMax*5/4 {
+		t.Errorf("largest event with compact layers = %d, with JSON = %d; want at least a quarter more", compactMax, jsonMax)
+	}
+}
+
+func TestWrappedSize_BoundsMixedLaneWrap(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	path, _ := ValidatePath([]string{mustPublicKey(t, sk)})
+	event := &nostr.Event{Kind: 1, Content: "held for mixing", CreatedAt: nostr.Now(), Tags: nostr.Tags{}}
+	event.Sign(nostr.GeneratePrivateKey())
+	originalJSON, _ := json.Marshal(event)
+
+	opts := DefaultOptions()
+	opts.Lane, opts.MixingDelay = config.LaneMixed, 90*time.Second
+	wrapped, err := WrapEvent(context.Background(), event, path, WrapOptions{Options: opts})
+	if err != nil {
+		t.Fatalf("WrapEvent() error = %v", err)
+	}
+	conversationKey, _ := nip44.GenerateConversationKey(wrapped.PubKey, sk)
+	plaintext, _ := nip44.Decrypt(wrapped.Content, conversationKey)
+	var layer nostr.Event
+	json.Unmarshal([]byte(plaintext), &layer)
+	layer.Tags = padding.StripPadding(layer.Tags)
+
+	// The delay is sealed to the Renoter the layer is addressed to
+	tag := layer.Tags.Find(config.DelayTag)
+	if tag == nil {
+		t.Fatal("mixed lane layer has no delay tag")
+	}
+	layerKey, _ := nip44.GenerateConversationKey(layer.PubKey, sk)
+	if values, err := sealtag.Open(tag[1], layerKey); err != nil || len(values) != 1 || values[0] != "90" {
+		t.Errorf("sealed delay = %v, %v, want [90]", values, err)
+	}
+
+	layerJSON, _ := json.Marshal(layer)
+	if bound := wrappedSize(len(originalJSON), 1, layerFormat{lane: config.LaneMixed}); len(layerJSON) > bound || bound-len(layerJSON) > 20 {
+		t.Errorf("mixed lane model bound %d, actual size %d", bound, len(layerJSON))
+	}
+	if WrappedSize(len(originalJSON), 1) >= len(layerJSON) {
+		t.Error("the fast lane model should not fit a mixed lane layer")
+	}
+}
+
+func TestWrapEvent_RejectsBeforeWrapping(t *testing.T) {
+	sk1 := nostr.GeneratePrivateKey()
+	npub1, _ := nip19.EncodePublicKey(mustPublicKey(t, sk1))
+	path, _ := ValidatePath([]string{npub1})
+
+	limits := config.DefaultSizeLimits()
+	max := MaxOriginalEventSize(len(path), limits)
+
+	event := &nostr.Event{Kind: 1, CreatedAt: nostr.Now(), Tags: nostr.Tags{}}
+	event.Sign(nostr.GeneratePrivateKey())
+	baseJSON, _ := json.Marshal(event)
+	event.Content = strings.Repeat("A", max+1-len(baseJSON))
+	event.Sign(nostr.GeneratePrivateKey())
+
+	_, err := WrapEvent(context.Background(), event, path, DefaultWrapOptions())
+	if err == nil {
+		t.Fatal("WrapEvent() should reject an event one byte over the limit")
+	}
+	if !strings.Contains(err.Error(), "maximum "+strconv.Itoa(max)+" bytes") {
+		t.Errorf("error should report the %d byte limit, got: %v", max, err)
+	}
+}
+
+func mustPublicKey(t *testing.T, sk string) string {
+	t.Helper()
+	pk, err := nostr.GetPublicKey(sk)
+	if err != nil {
+		t.Fatalf("GetPublicKey() error = %v", err)
+	}
+	return pk
+}
+
+func TestMaxContentSize_EnforcedByCheckEventSize(t *testing.T) {
+	limits := config.DefaultSizeLimits()
+	for _, format := range []layerFormat{{}, {compact: true}, {lane: config.LaneMixed, report: true}} {
+		for _, hops := range []int{1, 3} {
+			budget := newSizeBudget(hops, limits, format)
+			maxContent := budget.MaxContentSize()
+			if maxContent <= 0 {
+				t.Fatalf("MaxContentSize() for %d hops, %+v = %d", hops, format, maxContent)
+			}
+			// The longest kind and timestamp leave no slack, so one more byte must be refused
+			// ("-" is not base64, which compact layers would store decoded)
+			for _, n := range []int{maxContent, maxContent + 1} {
+				event := &nostr.Event{Kind: math.MaxUint16, Content: strings.Repeat("-", n), CreatedAt: nostr.Timestamp(9999999999), Tags: nostr.Tags{}}
+				event.Sign(nostr.GeneratePrivateKey())
+				err := budget.CheckEvent(event)
+				if n == maxContent && err != nil {
+					t.Errorf("%d hops, %+v: content of %d bytes refused: %v", hops, format, n, err)
+				}
+				if n > maxContent && err == nil {
+					t.Errorf("%d hops, %+v: content of %d bytes accepted, limit %d", hops, format, n, maxContent)
+				}
+			}
+		}
+	}
+
+	if got := MaxContentSize(50, limits); got != 0 {
+		t.Errorf("MaxContentSize() for a path too long for any content = %d, want 0", got)
+	}
+}
+
+func TestSizeBudget_MatchesWrappedContainer(t *testing.T) {
+	sk1 := nostr.GeneratePrivateKey()
+	npub1, _ := nip19.EncodePublicKey(mustPublicKey(t, sk1))
+	path, _ := ValidatePath([]string{npub1})
+
+	limits := config.DefaultSizeLimits()
+	budget := NewSizeBudget(len(path), limits)
+	if budget.MaxWrappedSize != limits.MaxInnerEventSize || budget.PaddedSize != limits.StandardizedSize {
+		t.Fatalf("budget = %+v, want the limits' inner and standardized sizes", budget)
+	}
+	if budget.MaxEventSize != MaxOriginalEventSize(len(path), limits) {
+		t.Errorf("MaxEventSize = %d, want %d", budget.MaxEventSize, MaxOriginalEventSize(len(path), limits))
+	}
+
+	// The largest event the budget accepts is wrapped into a container of exactly the budgeted size
+	event := &nostr.Event{Kind: 1, CreatedAt: nostr.Now(), Tags: nostr.Tags{}}
+	event.Sign(nostr.GeneratePrivateKey())
+	baseJSON, _ := json.Marshal(event)
+	event.Content = strings.Repeat("A", budget.MaxEventSize-len(baseJSON))
+	event.Sign(nostr.GeneratePrivateKey())
+	if err := budget.CheckEvent(event); err != nil {
+		t.Fatalf("CheckEvent() of the largest event error = %v", err)
+	}
+	opts := DefaultWrapOptions()
+	opts.Limits = limits
+	wrapped, err := WrapEvent(context.Background(), event, path, opts)
+	if err != nil {
+		t.Fatalf("WrapEvent() error = %v", err)
+	}
+	if len(wrapped.Content) != budget.ContainerContentSize {
+		t.Errorf("container content is %d bytes, budget says %d", len(wrapped.Content), budget.ContainerContentSize)
+	}
+
+	if _, err := budget.bucket(budget.MaxWrappedSize + 1); err == nil {
+		t.Error("bucket() should refuse an outermost 29000 over the budget")
+	}
+}
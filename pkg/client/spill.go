@@ -0,0 +1,211 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/girino/renoter/internal/envelope"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// spillSuffix names the files of spilled events, one per event.
+const spillSuffix = ".event"
+
+// QueueSpill keeps the events accepted for dispatch on disk until they are dispatched or fail, so
+// a restart does not lose them, and holds the events that don't fit in the mining queue instead
+// of rejecting them. Every file is an envelope sealed with the storage key. Pass it via
+// Options.QueueSpill.
+type QueueSpill struct {
+	dir string
+	key *envelope.Key
+	// Most events held beyond the mining queue
+	max int
+
+	mu sync.Mutex
+	// Events waiting for room in the mining queue, oldest first
+	held []dispatchJob
+	// Signals that held is no longer empty
+	ready chan struct{}
+}
+
+// OpenQueueSpill opens (or creates) the spill directory dir, readable by its owner only, whose
+// events are sealed with key (see LoadStorageKey). Up to max events are held beyond the mining
+// queue.
+func OpenQueueSpill(dir string, key *envelope.Key, max int) (*QueueSpill, error) {
+	if max < 1 {
+		return nil, fmt.Errorf("spilled events must be at least 1, got %d", max)
+	}
+	if key == nil {
+		return nil, fmt.Errorf("the queue spill needs a storage key")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create spill directory: %w", err)
+	}
+	return &QueueSpill{dir: dir, key: key, max: max, ready: make(chan struct{}, 1)}, nil
+}
+
+// save writes event to disk, replacing any earlier copy.
+func (s *QueueSpill) save(event *nostr.Event) error {
+	plaintext, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to encode event: %w", err)
+	}
+	// Labelled with the event ID, so a file cannot be passed off as another event's
+	sealed := envelope.Seal(s.key, plaintext, event.ID)
+
+	name := s.file(event.ID)
+	tmp := name + ".tmp"
+	if err := os.WriteFile(tmp, sealed, 0o600); err != nil {
+		return fmt.Errorf("failed to spill event: %w", err)
+	}
+	if err := os.Rename(tmp, name); err != nil {
+		return fmt.Errorf("failed to spill event: %w", err)
+	}
+	return nil
+}
+
+// remove deletes the copy of an event. A nil *QueueSpill ignores it.
+func (s *QueueSpill) remove(eventID string) {
+	if s == nil {
+		return
+	}
+	if err := os.Remove(s.file(eventID)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		logging.Warn("client.spill.remove: failed to remove spilled event %s: %v", eventID, err)
+	}
+}
+
+// load returns the events on disk. Files that cannot be read or decrypted are left in place and
+// skipped with a warning.
+func (s *QueueSpill) load() ([]*nostr.Event, error) {
+	names, err := filepath.Glob(filepath.Join(s.dir, "*"+spillSuffix))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list spilled events: %w", err)
+	}
+	var events []*nostr.Event
+	for _, name := range names {
+		id := strings.TrimSuffix(filepath.Base(name), spillSuffix)
+		event, err := s.read(name, id)
+		if err != nil {
+			logging.Warn("client.spill.load: skipping spilled event %s: %v", id, err)
+			continue
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// read decrypts the spilled event id in file name.
+func (s *QueueSpill) read(name, id string) (*nostr.Event, error) {
+	sealed, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := envelope.Open(s.key, sealed, id)
+	if err != nil {
+		return nil, err
+	}
+	var event nostr.Event
+	if err := json.Unmarshal(plaintext, &event); err != nil {
+		return nil, fmt.Errorf("malformed event: %w", err)
+	}
+	if event.ID != id {
+		return nil, fmt.Errorf("file holds event %s", event.ID)
+	}
+	return &event, nil
+}
+
+// file returns the path of the spilled copy of an event.
+func (s *QueueSpill) file(eventID string) string {
+	return filepath.Join(s.dir, eventID+spillSuffix)
+}
+
+// hold keeps a job until the mining queue has room, reporting false if max jobs are held already.
+func (s *QueueSpill) hold(job dispatchJob) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.held) >= s.max {
+		return false
+	}
+	s.held = append(s.held, job)
+	select {
+	case s.ready <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// holding reports whether jobs are waiting for room in the mining queue. A nil *QueueSpill never
+// holds any.
+func (s *QueueSpill) holding() bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.held) > 0
+}
+
+// next removes and returns the oldest held job.
+func (s *QueueSpill) next() (dispatchJob, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.held) == 0 {
+		return dispatchJob{}, false
+	}
+	job := s.held[0]
+	s.held = s.held[1:]
+	return job, true
+}
+
+// restoreSpilled holds the events left on disk by an earlier run, to be dispatched again. Their
+// submitters are gone, so only the journal and status see the outcome.
+func (d *Dispatcher) restoreSpilled() error {
+	events, err := d.opts.QueueSpill.load()
+	if err != nil {
+		return err
+	}
+	for _, event := range events {
+		job := dispatchJob{event: event, submittedAt: time.Now()}
+		if !d.opts.QueueSpill.hold(job) {
+			logging.Warn("client.spill.restoreSpilled: more than %d spilled events, leaving %d on disk for the next start", d.opts.QueueSpill.max, len(events)-d.opts.QueueSpill.max)
+			break
+		}
+	}
+	if len(events) > 0 {
+		logging.Info("client.spill.restoreSpilled: Restored %d events queued before the last shutdown", min(len(events), d.opts.QueueSpill.max))
+	}
+	return nil
+}
+
+// feedSpilled moves held jobs into the mining queue as it drains, until ctx is done.
+func (d *Dispatcher) feedSpilled(ctx context.Context) {
+	defer d.wg.Done()
+	spill := d.opts.QueueSpill
+	for {
+		job, ok := spill.next()
+		if !ok {
+			select {
+			case <-ctx.Done():
+				return
+			case <-spill.ready:
+				continue
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case d.jobs <- job:
+			d.opts.Status.setQueue(len(d.jobs), cap(d.jobs))
+			logging.DebugMethod("client.spill", "feedSpilled", "Queued held event %s", job.event.ID)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/girino/renoter/internal/envelope"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestQueueSpill(t *testing.T) {
+	dir := t.TempDir()
+	key, err := LoadStorageKey(StorageKey{File: filepath.Join(dir, "storage.key")})
+	if err != nil {
+		t.Fatalf("LoadStorageKey() error = %v", err)
+	}
+	spill, err := OpenQueueSpill(filepath.Join(dir, "queue"), key, 10)
+	if err != nil {
+		t.Fatalf("OpenQueueSpill() error = %v", err)
+	}
+
+	event := newDispatcherTestEvent()
+	if err := spill.save(event); err != nil {
+		t.Fatalf("save() error = %v", err)
+	}
+	data, _ := os.ReadFile(spill.file(event.ID))
+	if len(data) == 0 || bytes.Contains(data, []byte(event.Content)) {
+		t.Errorf("spilled file holds the event in the clear")
+	}
+
+	// Reopening with the same key finds the event, another key does not
+	reopened, _ := OpenQueueSpill(filepath.Join(dir, "queue"), key, 10)
+	if events, err := reopened.load(); err != nil || len(events) != 1 || events[0].ID != event.ID || events[0].Content != event.Content {
+		t.Errorf("load() = %v, %v, want the saved event", events, err)
+	}
+	other, _ := OpenQueueSpill(filepath.Join(dir, "queue"), envelope.NewKey(), 10)
+	if events, _ := other.load(); len(events) != 0 {
+		t.Errorf("load() with another key = %v, want nothing", events)
+	}
+
+	spill.remove(event.ID)
+	if events, _ := reopened.load(); len(events) != 0 {
+		t.Errorf("load() after remove() = %v, want nothing", events)
+	}
+	if _, err := OpenQueueSpill(dir, key, 0); err == nil {
+		t.Error("OpenQueueSpill() accepted a zero limit")
+	}
+	if _, err := OpenQueueSpill(dir, nil, 10); err == nil {
+		t.Error("OpenQueueSpill() accepted no key")
+	}
+}
+
+func TestDispatcher_QueueSpill(t *testing.T) {
+	dir := t.TempDir()
+	key := envelope.NewKey()
+	spill, err := OpenQueueSpill(filepath.Join(dir, "queue"), key, 1)
+	if err != nil {
+		t.Fatalf("OpenQueueSpill() error = %v", err)
+	}
+	opts := DefaultOptions()
+	opts.QueueSpill = spill
+
+	// A dispatcher without workers keeps its queue of one full
+	stopped := &Dispatcher{opts: opts, jobs: make(chan dispatchJob, 1)}
+	queued, held, rejected := newDispatcherTestEvent(), newDispatcherTestEvent(), newDispatcherTestEvent()
+	if err := stopped.enqueue(dispatchJob{event: queued}); err != nil {
+		t.Fatalf("enqueue() error = %v", err)
+	}
+	if err := stopped.enqueue(dispatchJob{event: held}); err != nil {
+		t.Fatalf("enqueue() with a full queue error = %v, want the event held", err)
+	}
+	if err := stopped.enqueue(dispatchJob{event: rejected}); err == nil {
+		t.Fatal("enqueue() accepted an event beyond the spill limit")
+	}
+	if events, _ := spill.load(); len(events) != 2 {
+		t.Fatalf("spilled %d events, want the queued and the held one", len(events))
+	}
+
+	// After a restart both are dispatched and removed from disk
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	restarted, _ := OpenQueueSpill(filepath.Join(dir, "queue"), key, 10)
+	opts.QueueSpill = restarted
+	pk, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	path, _ := ValidatePath([]string{mustNpub(t, pk)})
+	pool := &fakePool{published: make(chan nostr.Event, 2)}
+	if _, err := NewDispatcher(ctx, path, pool, []string{"wss://relay.example.com"}, opts); err != nil {
+		t.Fatalf("NewDispatcher() error = %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case <-pool.published:
+		case <-time.After(30 * time.Second):
+			t.Fatalf("only %d restored events were published", i)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		events, _ := restarted.load()
+		if len(events) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d events still spilled after dispatch", len(events))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
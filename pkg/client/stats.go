@@ -0,0 +1,176 @@
+package client
+
+import (
+	"encoding/json"
+	"maps"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stats accounts for the size cost of wrapping: how large submitted events are compared to the
+// padded and encrypted containers actually sent, and the total bandwidth to server relays.
+// It is safe for concurrent use; pass it via Options.Stats to have the dispatcher fill it in.
+type Stats struct {
+	dispatched     atomic.Int64
+	failed         atomic.Int64
+	hops           atomic.Int64
+	plaintextBytes atomic.Int64
+	paddedBytes    atomic.Int64
+	encryptedBytes atomic.Int64
+	publishedBytes atomic.Int64
+
+	// Summed timing trailers by Renoter pubkey (see Options.ReportLatency)
+	latencyMu sync.Mutex
+	latency   map[string]*latencySums
+
+	// Server relay refusals by category (see RelayReason)
+	refusalsMu sync.Mutex
+	refusals   map[string]int64
+}
+
+// latencySums accumulates the timing trailers of one Renoter.
+type latencySums struct {
+	reports                   int64
+	transit, held, processing time.Duration
+}
+
+// RenoterLatency is the mean latency one Renoter added to the events it reported on.
+type RenoterLatency struct {
+	Reports int64 `json:"reports"`
+	// Mean time from the previous hop (or the client) to this Renoter, including clock offsets
+	TransitMs float64 `json:"transit_ms"`
+	// Mean mixing delay
+	HeldMs float64 `json:"held_ms"`
+	// Mean time spent on an event besides holding it
+	ProcessingMs float64 `json:"processing_ms"`
+}
+
+// StatsSnapshot is a point-in-time copy of Stats with derived ratios.
+type StatsSnapshot struct {
+	// Events successfully wrapped and published to at least one relay
+	Dispatched int64 `json:"dispatched"`
+	// Events that could not be wrapped or published
+	Failed int64 `json:"failed"`
+	// Mean path length of dispatched events
+	AverageHops float64 `json:"average_hops"`
+
+	// Serialized size of the original events
+	PlaintextBytes int64 `json:"plaintext_bytes"`
+	// Size of the padded outermost 29000 events (the standardized size bucket)
+	PaddedBytes int64 `json:"padded_bytes"`
+	// Serialized size of the 29001 containers
+	EncryptedBytes int64 `json:"encrypted_bytes"`
+	// Container bytes sent to server relays (container size times relays that accepted it)
+	PublishedBytes int64 `json:"published_bytes"`
+
+	// PaddedBytes / PlaintextBytes: cost of the hop count and size bucket
+	PaddingOverhead float64 `json:"padding_overhead"`
+	// EncryptedBytes / PlaintextBytes: total expansion of each event
+	EncryptionOverhead float64 `json:"encryption_overhead"`
+	// PublishedBytes / PlaintextBytes: bandwidth spent per byte submitted
+	BandwidthOverhead float64 `json:"bandwidth_overhead"`
+
+	// Containers refused by a server relay, by category of the reason (see RelayReason)
+	RelayRefusals map[string]int64 `json:"relay_refusals,omitempty"`
+
+	// Mean latency per Renoter hex pubkey, from timing trailers (empty unless reports were asked for)
+	Latency map[string]RenoterLatency `json:"latency,omitempty"`
+}
+
+// NewStats creates an empty Stats collector.
+func NewStats() *Stats {
+	return &Stats{}
+}
+
+// recordDispatch accounts for one event published to relays server relays.
+func (s *Stats) recordDispatch(hops, plaintext, padded, encrypted, relays int) {
+	s.dispatched.Add(1)
+	s.hops.Add(int64(hops))
+	s.plaintextBytes.Add(int64(plaintext))
+	s.paddedBytes.Add(int64(padded))
+	s.encryptedBytes.Add(int64(encrypted))
+	s.publishedBytes.Add(int64(encrypted) * int64(relays))
+}
+
+// recordFailure accounts for one event that could not be dispatched.
+func (s *Stats) recordFailure() {
+	s.failed.Add(1)
+}
+
+// recordRefusal accounts for one container a server relay refused, by category.
+func (s *Stats) recordRefusal(category string) {
+	s.refusalsMu.Lock()
+	defer s.refusalsMu.Unlock()
+	if s.refusals == nil {
+		s.refusals = make(map[string]int64)
+	}
+	s.refusals[category]++
+}
+
+// recordLatency accounts for the timing trailers of one event.
+func (s *Stats) recordLatency(report LatencyReport) {
+	s.latencyMu.Lock()
+	defer s.latencyMu.Unlock()
+	if s.latency == nil {
+		s.latency = make(map[string]*latencySums)
+	}
+	for _, hop := range report.Hops {
+		sums := s.latency[hop.Renoter]
+		if sums == nil {
+			sums = &latencySums{}
+			s.latency[hop.Renoter] = sums
+		}
+		sums.reports++
+		sums.transit += hop.Transit
+		sums.held += hop.Held
+		sums.processing += hop.Processing
+	}
+}
+
+// Snapshot returns the current counters and derived ratios.
+func (s *Stats) Snapshot() StatsSnapshot {
+	snap := StatsSnapshot{
+		Dispatched:     s.dispatched.Load(),
+		Failed:         s.failed.Load(),
+		PlaintextBytes: s.plaintextBytes.Load(),
+		PaddedBytes:    s.paddedBytes.Load(),
+		EncryptedBytes: s.encryptedBytes.Load(),
+		PublishedBytes: s.publishedBytes.Load(),
+	}
+	if snap.Dispatched > 0 {
+		snap.AverageHops = float64(s.hops.Load()) / float64(snap.Dispatched)
+	}
+	if snap.PlaintextBytes > 0 {
+		plaintext := float64(snap.PlaintextBytes)
+		snap.PaddingOverhead = float64(snap.PaddedBytes) / plaintext
+		snap.EncryptionOverhead = float64(snap.EncryptedBytes) / plaintext
+		snap.BandwidthOverhead = float64(snap.PublishedBytes) / plaintext
+	}
+
+	s.refusalsMu.Lock()
+	if len(s.refusals) > 0 {
+		snap.RelayRefusals = maps.Clone(s.refusals)
+	}
+	s.refusalsMu.Unlock()
+
+	s.latencyMu.Lock()
+	defer s.latencyMu.Unlock()
+	for renoter, sums := range s.latency {
+		if snap.Latency == nil {
+			snap.Latency = make(map[string]RenoterLatency, len(s.latency))
+		}
+		mean := func(total time.Duration) float64 {
+			return float64(total.Milliseconds()) / float64(sums.reports)
+		}
+		snap.Latency[renoter] = RenoterLatency{Reports: sums.reports, TransitMs: mean(sums.transit), HeldMs: mean(sums.held), ProcessingMs: mean(sums.processing)}
+	}
+	return snap
+}
+
+// ServeHTTP serves the current snapshot as JSON, for use as a status endpoint.
+func (s *Stats) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Snapshot())
+}
@@ -0,0 +1,51 @@
+package client
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestStats_Snapshot(t *testing.T) {
+	stats := NewStats()
+	if snap := stats.Snapshot(); snap.Dispatched != 0 || snap.PaddingOverhead != 0 {
+		t.Errorf("empty Snapshot() = %+v, want zero values", snap)
+	}
+
+	stats.recordDispatch(2, 1000, 32768, 44198, 2)
+	stats.recordDispatch(4, 3000, 32768, 44198, 1)
+	stats.recordFailure()
+
+	snap := stats.Snapshot()
+	if snap.Dispatched != 2 || snap.Failed != 1 {
+		t.Errorf("Dispatched/Failed = %d/%d, want 2/1", snap.Dispatched, snap.Failed)
+	}
+	if snap.AverageHops != 3 {
+		t.Errorf("AverageHops = %v, want 3", snap.AverageHops)
+	}
+	if snap.PublishedBytes != 44198*3 {
+		t.Errorf("PublishedBytes = %d, want %d", snap.PublishedBytes, 44198*3)
+	}
+	if want := float64(2*32768) / 4000; snap.PaddingOverhead != want {
+		t.Errorf("PaddingOverhead = %v, want %v", snap.PaddingOverhead, want)
+	}
+	if want := float64(44198*3) / 4000; snap.BandwidthOverhead != want {
+		t.Errorf("BandwidthOverhead = %v, want %v", snap.BandwidthOverhead, want)
+	}
+}
+
+func TestStats_ServeHTTP(t *testing.T) {
+	stats := NewStats()
+	stats.recordDispatch(1, 500, 32768, 44198, 1)
+
+	rec := httptest.NewRecorder()
+	stats.ServeHTTP(rec, httptest.NewRequest("GET", "/stats", nil))
+
+	var snap StatsSnapshot
+	if err := json.Unmarshal(rec.Body.Bytes(), &snap); err != nil {
+		t.Fatalf("invalid JSON response: %v", err)
+	}
+	if snap.Dispatched != 1 || snap.EncryptedBytes != 44198 {
+		t.Errorf("served snapshot = %+v", snap)
+	}
+}
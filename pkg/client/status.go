@@ -0,0 +1,265 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/girino/nostr-lib/logging"
+)
+
+// Path health as reported on the status socket.
+const (
+	// Nothing has been dispatched yet
+	HealthUnknown = "unknown"
+	// The last event reached every server relay
+	HealthOK = "ok"
+	// The last event reached only some server relays, or failed after earlier successes
+	HealthDegraded = "degraded"
+	// statusDownAfter events in a row failed
+	HealthDown = "down"
+)
+
+// statusDownAfter is how many consecutive failures mark the path as down.
+const statusDownAfter = 3
+
+// statusMaxLine bounds a single command line read from a status socket connection.
+const statusMaxLine = 4096
+
+// StatusSocket serves the client state as newline-delimited JSON on a local socket, so desktop
+// tray apps can show it without scraping the HTTP page: every connection receives the current
+// Status on connect and again whenever it changes, and may send {"command":"status"} to ask for
+// it. Updates are coalesced, so a slow reader only ever sees the latest state.
+// It is safe for concurrent use; pass it via Options.Status. A nil *StatusSocket ignores updates.
+type StatusSocket struct {
+	mu     sync.Mutex
+	status Status
+	// Per connection signal that the status changed
+	watchers map[chan struct{}]struct{}
+}
+
+// Status is the client state sent on the status socket.
+type Status struct {
+	// Path health: unknown, ok, degraded or down
+	PathHealth string `json:"path_health"`
+	// Events in a row that were not dispatched
+	ConsecutiveFailures int `json:"consecutive_failures"`
+	// Server relays that accepted the last container, out of all server relays
+	RelaysAccepted int `json:"relays_accepted"`
+	RelaysTotal    int `json:"relays_total"`
+
+	// Accepted events waiting for a mining worker, and the queue capacity
+	QueueDepth    int `json:"queue_depth"`
+	QueueCapacity int `json:"queue_capacity"`
+
+	// Events dispatched and failed since startup
+	Dispatched int64 `json:"dispatched"`
+	Failed     int64 `json:"failed"`
+
+	// The last event dispatched, its container and when it was published
+	LastEventID   string    `json:"last_event_id,omitempty"`
+	LastWrappedID string    `json:"last_wrapped_id,omitempty"`
+	LastPublishAt time.Time `json:"last_publish_at,omitzero"`
+	// Why the last failed event was not dispatched
+	LastError string `json:"last_error,omitempty"`
+}
+
+// statusCommand is one line a status socket connection may send.
+type statusCommand struct {
+	Command string `json:"command"`
+}
+
+// statusMessage is one line written to a status socket connection.
+type statusMessage struct {
+	Type string `json:"type"`
+	*Status
+	Error string `json:"error,omitempty"`
+}
+
+// NewStatusSocket creates a status feed with nothing dispatched yet.
+func NewStatusSocket() *StatusSocket {
+	return &StatusSocket{
+		status:   Status{PathHealth: HealthUnknown},
+		watchers: make(map[chan struct{}]struct{}),
+	}
+}
+
+// Snapshot returns the current status.
+func (s *StatusSocket) Snapshot() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.status
+}
+
+// update changes the status under the lock and notifies every connection if it changed.
+func (s *StatusSocket) update(change func(status *Status)) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	before := s.status
+	change(&s.status)
+	if s.status == before {
+		return
+	}
+	for watcher := range s.watchers {
+		select {
+		case watcher <- struct{}{}:
+		default:
+			// An update is already pending; it will carry this change too
+		}
+	}
+}
+
+// setQueue records the depth of the mining queue.
+func (s *StatusSocket) setQueue(depth, capacity int) {
+	s.update(func(status *Status) {
+		status.QueueDepth, status.QueueCapacity = depth, capacity
+	})
+}
+
+// recordOutcome records the journal entry of a dispatched or failed event.
+func (s *StatusSocket) recordOutcome(entry JournalEntry, relays int) {
+	s.update(func(status *Status) {
+		accepted := 0
+		for _, result := range entry.Results {
+			if result.OK {
+				accepted++
+			}
+		}
+		status.RelaysAccepted, status.RelaysTotal = accepted, relays
+
+		if entry.Error != "" {
+			status.Failed++
+			status.ConsecutiveFailures++
+			status.LastError = entry.Error
+			if status.ConsecutiveFailures >= statusDownAfter {
+				status.PathHealth = HealthDown
+			} else if status.PathHealth != HealthDown {
+				status.PathHealth = HealthDegraded
+			}
+			return
+		}
+		status.Dispatched++
+		status.ConsecutiveFailures = 0
+		status.LastEventID, status.LastWrappedID, status.LastPublishAt = entry.EventID, entry.WrappedID, entry.FinishedAt
+		status.PathHealth = HealthOK
+		if accepted < relays {
+			status.PathHealth = HealthDegraded
+		}
+	})
+}
+
+// ListenStatus opens the listener for a status socket: a unix socket if address is a path
+// (contains a slash), otherwise a TCP address that must be on the loopback interface, since
+// the socket is not authenticated. A stale unix socket file is removed first.
+func ListenStatus(address string) (net.Listener, error) {
+	if strings.Contains(address, "/") {
+		if err := os.Remove(address); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to remove stale status socket: %w", err)
+		}
+		return net.Listen("unix", address)
+	}
+
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid status socket address %q: %w", address, err)
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return nil, fmt.Errorf("status socket must listen on a loopback address, not %q", host)
+	}
+	return net.Listen("tcp", address)
+}
+
+// Serve accepts status connections on listener until ctx is done, then closes it and every
+// connection.
+func (s *StatusSocket) Serve(ctx context.Context, listener net.Listener) error {
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	logging.Info("client.status.Serve: Serving status on %s", listener.Addr())
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			logging.Error("client.status.Serve: failed to accept connection: %v", err)
+			return fmt.Errorf("failed to accept status connection: %w", err)
+		}
+		go s.serveConn(ctx, conn)
+	}
+}
+
+// serveConn writes the status to one connection whenever it changes and answers its commands.
+func (s *StatusSocket) serveConn(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	watcher := make(chan struct{}, 1)
+	// Send the current status right away
+	watcher <- struct{}{}
+	s.mu.Lock()
+	s.watchers[watcher] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.watchers, watcher)
+		s.mu.Unlock()
+	}()
+
+	var writeMu sync.Mutex
+	encoder := json.NewEncoder(conn)
+	write := func(msg statusMessage) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		return encoder.Encode(msg)
+	}
+
+	// Commands are read on their own goroutine; closing the connection ends it
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		scanner := bufio.NewScanner(conn)
+		scanner.Buffer(make([]byte, 0, statusMaxLine), statusMaxLine)
+		for scanner.Scan() {
+			var command statusCommand
+			if err := json.Unmarshal(scanner.Bytes(), &command); err != nil {
+				write(statusMessage{Type: "error", Error: fmt.Sprintf("malformed command: %v", err)})
+				continue
+			}
+			switch command.Command {
+			case "status":
+				select {
+				case watcher <- struct{}{}:
+				default:
+				}
+			default:
+				write(statusMessage{Type: "error", Error: fmt.Sprintf("unknown command %q", command.Command)})
+			}
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-closed:
+			return
+		case <-watcher:
+			status := s.Snapshot()
+			if err := write(statusMessage{Type: "status", Status: &status}); err != nil {
+				logging.DebugMethod("client.status", "serveConn", "Closing status connection %s: %v", conn.RemoteAddr(), err)
+				return
+			}
+		}
+	}
+}
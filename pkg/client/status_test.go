@@ -0,0 +1,109 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStatusSocket_PathHealth(t *testing.T) {
+	s := NewStatusSocket()
+	if got := s.Snapshot().PathHealth; got != HealthUnknown {
+		t.Fatalf("initial health = %q, want %q", got, HealthUnknown)
+	}
+
+	allOK := JournalEntry{EventID: "a", WrappedID: "w", Results: []JournalResult{{Relay: "r1", OK: true}, {Relay: "r2", OK: true}}}
+	partial := JournalEntry{EventID: "b", WrappedID: "w", Results: []JournalResult{{Relay: "r1", OK: true}, {Relay: "r2", OK: false}}}
+	failed := JournalEntry{EventID: "c", Error: "error: path-down"}
+
+	steps := []struct {
+		entry JournalEntry
+		want  string
+	}{
+		{allOK, HealthOK},
+		{partial, HealthDegraded},
+		{failed, HealthDegraded},
+		{failed, HealthDegraded},
+		{failed, HealthDown},
+		{allOK, HealthOK},
+	}
+	for i, step := range steps {
+		s.recordOutcome(step.entry, 2)
+		if got := s.Snapshot().PathHealth; got != step.want {
+			t.Errorf("step %d: health = %q, want %q", i, got, step.want)
+		}
+	}
+
+	status := s.Snapshot()
+	if status.Dispatched != 3 || status.Failed != 3 || status.ConsecutiveFailures != 0 {
+		t.Errorf("counters = %d dispatched, %d failed, %d in a row; want 3, 3, 0", status.Dispatched, status.Failed, status.ConsecutiveFailures)
+	}
+	if status.LastEventID != "a" || status.LastError != "error: path-down" {
+		t.Errorf("last event = %q, last error = %q", status.LastEventID, status.LastError)
+	}
+}
+
+func TestStatusSocket_Serve(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := NewStatusSocket()
+	listener, err := ListenStatus(filepath.Join(t.TempDir(), "status.sock"))
+	if err != nil {
+		t.Fatalf("ListenStatus() error = %v", err)
+	}
+	go s.Serve(ctx, listener)
+
+	conn, err := net.Dial("unix", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close()
+	lines := bufio.NewScanner(conn)
+	read := func() map[string]any {
+		t.Helper()
+		conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if !lines.Scan() {
+			t.Fatalf("no status line: %v", lines.Err())
+		}
+		var msg map[string]any
+		if err := json.Unmarshal(lines.Bytes(), &msg); err != nil {
+			t.Fatalf("status line %q is not JSON: %v", lines.Text(), err)
+		}
+		return msg
+	}
+
+	if msg := read(); msg["type"] != "status" || msg["path_health"] != HealthUnknown {
+		t.Errorf("first message = %v, want the initial status", msg)
+	}
+
+	s.setQueue(3, 64)
+	if msg := read(); msg["queue_depth"] != float64(3) || msg["queue_capacity"] != float64(64) {
+		t.Errorf("update = %v, want queue depth 3/64", msg)
+	}
+
+	conn.Write([]byte(`{"command":"status"}` + "\n"))
+	if msg := read(); msg["type"] != "status" || msg["queue_depth"] != float64(3) {
+		t.Errorf("status reply = %v", msg)
+	}
+
+	conn.Write([]byte(`{"command":"reboot"}` + "\n"))
+	if msg := read(); msg["type"] != "error" {
+		t.Errorf("unknown command reply = %v, want an error", msg)
+	}
+}
+
+func TestListenStatus_RefusesNonLoopback(t *testing.T) {
+	if _, err := ListenStatus("0.0.0.0:0"); err == nil {
+		t.Error("ListenStatus(0.0.0.0:0) succeeded, want an error for a non-loopback address")
+	}
+	listener, err := ListenStatus("127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenStatus(127.0.0.1:0) error = %v", err)
+	}
+	listener.Close()
+}
@@ -0,0 +1,32 @@
+package client
+
+import (
+	"fmt"
+
+	"github.com/girino/renoter/internal/envelope"
+)
+
+// StorageKey selects the key client persistence encrypts original events with.
+type StorageKey struct {
+	// File holding the key, or the salt it is derived from with Passphrase; created on first use
+	File string
+	// Passphrase the key is derived from (empty = File holds the key itself)
+	Passphrase string
+	// Keep the key in the OS keychain instead of File
+	Keychain bool
+}
+
+// LoadStorageKey returns the key every client feature that keeps original events on disk (the
+// queue spill) seals them with, so posts are never stored in the clear.
+func LoadStorageKey(source StorageKey) (*envelope.Key, error) {
+	if source.Keychain {
+		if source.Passphrase != "" {
+			return nil, fmt.Errorf("a storage key is kept in the keychain or derived from a passphrase, not both")
+		}
+		return envelope.KeychainKey("storage")
+	}
+	if source.File == "" {
+		return nil, fmt.Errorf("a storage key file is required")
+	}
+	return envelope.LoadKey(source.File, source.Passphrase)
+}
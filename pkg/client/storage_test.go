@@ -0,0 +1,27 @@
+package client
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadStorageKey(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "storage.key")
+	key, err := LoadStorageKey(StorageKey{File: file, Passphrase: "correct horse"})
+	if err != nil {
+		t.Fatalf("LoadStorageKey() error = %v", err)
+	}
+	if again, _ := LoadStorageKey(StorageKey{File: file, Passphrase: "correct horse"}); *again != *key {
+		t.Error("LoadStorageKey() returned another key for the same passphrase")
+	}
+	if _, err := LoadStorageKey(StorageKey{File: file}); err == nil {
+		t.Error("LoadStorageKey() without the passphrase succeeded")
+	}
+	if _, err := LoadStorageKey(StorageKey{Keychain: true, Passphrase: "x"}); err == nil {
+		t.Error("LoadStorageKey() accepted a keychain and a passphrase")
+	}
+	if _, err := LoadStorageKey(StorageKey{}); err == nil {
+		t.Error("LoadStorageKey() accepted no key source")
+	}
+}
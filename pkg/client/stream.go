@@ -0,0 +1,77 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/girino/renoter/internal/config"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// streamMaxLine bounds a single event line read by PublishStream.
+const streamMaxLine = 1 << 20
+
+// PublishStream reads signed events as JSON lines from in (as printed by `nak event`), publishes
+// each through the dispatcher and writes one PublishResult per event as a JSON line to out, in
+// the order the events finish. Up to the dispatcher's mining workers are processed at once.
+// It returns the number of events that were not dispatched once in is exhausted and every
+// event has finished.
+func PublishStream(ctx context.Context, d *Dispatcher, in io.Reader, out io.Writer) (int, error) {
+	var (
+		mu      sync.Mutex
+		failed  int
+		wg      sync.WaitGroup
+		encoder = json.NewEncoder(out)
+		slots   = make(chan struct{}, d.opts.MiningWorkers)
+	)
+	report := func(result PublishResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		if result.Error != "" {
+			failed++
+		}
+		if err := encoder.Encode(result); err != nil {
+			logging.Warn("client.stream.PublishStream: failed to write result for event %s: %v", result.EventID, err)
+		}
+	}
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 0, 64*1024), streamMaxLine)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		event := &nostr.Event{}
+		if err := json.Unmarshal(line, event); err != nil {
+			report(PublishResult{Error: fmt.Sprintf("%s: malformed event: %v", config.PrefixInvalid, err)})
+			continue
+		}
+
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return failed, ctx.Err()
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			entry, err := d.Publish(ctx, event)
+			report(NewPublishResult(event.ID, entry, err))
+		}()
+	}
+	wg.Wait()
+	if err := scanner.Err(); err != nil {
+		logging.Error("client.stream.PublishStream: failed to read events: %v", err)
+		return failed, fmt.Errorf("failed to read events: %w", err)
+	}
+	return failed, nil
+}
@@ -0,0 +1,61 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/girino/renoter/internal/config"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestPublishStream(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	relay, path, pool := newDispatcherTestSetup(t, ctx)
+	nostr.NewSimplePool(ctx).SubscribeMany(ctx, []string{relay.URL()}, nostr.Filter{Kinds: []int{config.StandardizedWrapperKind}})
+	time.Sleep(200 * time.Millisecond)
+
+	dispatcher, err := NewDispatcher(ctx, path, pool, []string{relay.URL()}, DefaultOptions())
+	if err != nil {
+		t.Fatalf("NewDispatcher() error = %v", err)
+	}
+
+	first, second := newDispatcherTestEvent(), newDispatcherTestEvent()
+	firstJSON, _ := json.Marshal(first)
+	secondJSON, _ := json.Marshal(second)
+	in := strings.NewReader(string(firstJSON) + "\n\nnot an event\n" + string(secondJSON) + "\n")
+
+	var out bytes.Buffer
+	failed, err := PublishStream(ctx, dispatcher, in, &out)
+	if err != nil {
+		t.Fatalf("PublishStream() error = %v", err)
+	}
+	if failed != 1 {
+		t.Errorf("PublishStream() failed = %d, want 1 (the malformed line)", failed)
+	}
+
+	results := make(map[string]PublishResult)
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var result PublishResult
+		if err := json.Unmarshal([]byte(line), &result); err != nil {
+			t.Fatalf("output line %q is not JSON: %v", line, err)
+		}
+		results[result.EventID] = result
+	}
+	if len(results) != 3 {
+		t.Fatalf("got %d results, want 3: %s", len(results), out.String())
+	}
+	for _, event := range []*nostr.Event{first, second} {
+		if result := results[event.ID]; result.WrappedID == "" || result.Accepted != 1 || result.Error != "" {
+			t.Errorf("result for %s = %+v, want it dispatched", event.ID, result)
+		}
+	}
+	if result := results[""]; !strings.HasPrefix(result.Error, "invalid:") {
+		t.Errorf("result for the malformed line = %+v, want an invalid: error", result)
+	}
+}
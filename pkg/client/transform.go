@@ -0,0 +1,143 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/girino/renoter/internal/config"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+// Transform rewrites a submitted event before it is validated and wrapped, e.g. to strip tags,
+// add an expiration or have the author re-sign it through NIP-46. It gets a copy of the event it
+// may change or replace, and returns the event to route. Any change invalidates the author's
+// signature, so a chain that changes the event must end with a transform signing it again, or
+// validation refuses it. An error refuses the event; a *config.Rejection is passed to the local
+// app as is.
+type Transform func(event *nostr.Event) (*nostr.Event, error)
+
+// StripTags returns a Transform removing every tag with one of the names.
+func StripTags(names ...string) Transform {
+	return func(event *nostr.Event) (*nostr.Event, error) {
+		event.Tags = slices.DeleteFunc(event.Tags, func(tag nostr.Tag) bool {
+			return len(tag) > 0 && slices.Contains(names, tag[0])
+		})
+		return event, nil
+	}
+}
+
+// AddExpiration returns a Transform adding a NIP-40 expiration tag ttl after the event's
+// created_at, unless it already has one.
+func AddExpiration(ttl time.Duration) Transform {
+	return func(event *nostr.Event) (*nostr.Event, error) {
+		if event.Tags.Find("expiration") == nil {
+			expiration := event.CreatedAt + nostr.Timestamp(ttl/time.Second)
+			event.Tags = append(event.Tags, nostr.Tag{"expiration", strconv.FormatInt(int64(expiration), 10)})
+		}
+		return event, nil
+	}
+}
+
+// SignWith returns a Transform signing the event with the hex private key sk, setting its
+// pubkey and ID. Only use it with the author's own key.
+func SignWith(sk string) Transform {
+	return func(event *nostr.Event) (*nostr.Event, error) {
+		if err := event.Sign(sk); err != nil {
+			return nil, fmt.Errorf("failed to sign: %w", err)
+		}
+		return event, nil
+	}
+}
+
+// ParseTransforms parses a comma-separated chain of built-in transforms, applied in order:
+// strip-tag=<name>, expiration=<duration> and sign, which signs with signingKey (hex or nsec).
+// For example "strip-tag=client,expiration=24h,sign".
+func ParseTransforms(spec, signingKey string) ([]Transform, error) {
+	var transforms []Transform
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(field, "=")
+		value = strings.TrimSpace(value)
+		switch strings.TrimSpace(name) {
+		case "strip-tag":
+			if value == "" {
+				return nil, fmt.Errorf("missing tag name in %q", field)
+			}
+			transforms = append(transforms, StripTags(value))
+		case "expiration":
+			ttl, err := time.ParseDuration(value)
+			if err != nil || ttl <= 0 {
+				return nil, fmt.Errorf("invalid expiration %q in %q (use a positive duration, e.g. 24h)", value, field)
+			}
+			transforms = append(transforms, AddExpiration(ttl))
+		case "sign":
+			sk, err := parseSigningKey(signingKey)
+			if err != nil {
+				return nil, err
+			}
+			transforms = append(transforms, SignWith(sk))
+		default:
+			return nil, fmt.Errorf("unknown transform %q (use strip-tag, expiration or sign)", name)
+		}
+	}
+	return transforms, nil
+}
+
+// parseSigningKey returns the hex private key given as hex or nsec.
+func parseSigningKey(key string) (string, error) {
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return "", fmt.Errorf("the sign transform needs a signing key")
+	}
+	if strings.HasPrefix(key, "nsec1") {
+		prefix, value, err := nip19.Decode(key)
+		if err != nil || prefix != "nsec" {
+			return "", fmt.Errorf("invalid nsec: %v", err)
+		}
+		key = value.(string)
+	}
+	if _, err := nostr.GetPublicKey(key); err != nil {
+		return "", fmt.Errorf("invalid signing key: %w", err)
+	}
+	return key, nil
+}
+
+// transformEvent runs the transforms on a copy of event, leaving event itself untouched, and
+// returns the event to route, or a *config.Rejection.
+func transformEvent(event *nostr.Event, transforms []Transform) (*nostr.Event, error) {
+	if len(transforms) == 0 {
+		return event, nil
+	}
+	transformed := *event
+	transformed.Tags = slices.Clone(event.Tags)
+	current := &transformed
+	for i, transform := range transforms {
+		next, err := transform(current)
+		if err != nil {
+			logging.Warn("client.transform.transformEvent: transform %d refused event %s: %v", i+1, event.ID, err)
+			var rejection *config.Rejection
+			if errors.As(err, &rejection) {
+				return nil, rejection
+			}
+			return nil, config.NewRejection(config.RejectTransformFailed, "", err.Error())
+		}
+		if next == nil {
+			logging.Warn("client.transform.transformEvent: transform %d returned no event for %s", i+1, event.ID)
+			return nil, config.NewRejection(config.RejectTransformFailed, "", "a transform returned no event")
+		}
+		current = next
+	}
+	if current.ID != event.ID {
+		logging.DebugMethod("client.transform", "transformEvent", "Event %s was rewritten to %s", event.ID, current.ID)
+	}
+	return current, nil
+}
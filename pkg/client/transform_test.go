@@ -0,0 +1,107 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/girino/renoter/internal/config"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+func TestParseTransforms(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	nsec, _ := nip19.EncodePrivateKey(sk)
+	transforms, err := ParseTransforms(" strip-tag=client, expiration=1h,sign", nsec)
+	if err != nil || len(transforms) != 3 {
+		t.Fatalf("ParseTransforms() = %d transforms, %v, want 3", len(transforms), err)
+	}
+	if transforms, err := ParseTransforms("", ""); err != nil || len(transforms) != 0 {
+		t.Errorf("ParseTransforms(\"\") = %d transforms, %v, want none", len(transforms), err)
+	}
+
+	for _, spec := range []string{"strip-tag", "expiration=soon", "expiration=-1h", "sign", "redact=content"} {
+		if _, err := ParseTransforms(spec, ""); err == nil {
+			t.Errorf("ParseTransforms(%q) should fail", spec)
+		}
+	}
+	if _, err := ParseTransforms("sign", "nsec1invalid"); err == nil {
+		t.Error("ParseTransforms() should refuse an invalid signing key")
+	}
+}
+
+func TestTransformEvent(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	event := &nostr.Event{Kind: 1, Content: "rewrite me", CreatedAt: nostr.Now(), Tags: nostr.Tags{{"client", "some-app"}, {"t", "renoter"}}}
+	event.Sign(sk)
+	original := *event
+
+	transformed, err := transformEvent(event, []Transform{StripTags("client"), AddExpiration(time.Hour), SignWith(sk)})
+	if err != nil {
+		t.Fatalf("transformEvent() error = %v", err)
+	}
+	if event.ID != original.ID || len(event.Tags) != 2 {
+		t.Error("transformEvent() changed the submitted event")
+	}
+	if transformed.Tags.Find("client") != nil || transformed.Tags.Find("t") == nil {
+		t.Errorf("tags = %v, want the client tag stripped and the others kept", transformed.Tags)
+	}
+	if expiration := transformed.Tags.Find("expiration"); expiration == nil || expiration[1] != strconv.FormatInt(int64(original.CreatedAt)+3600, 10) {
+		t.Errorf("expiration = %v, want created_at plus an hour", expiration)
+	}
+	v := DefaultEventValidation()
+	v.TagPolicies = map[string]TagPolicy{"client": TagReject}
+	if err := ValidateEvent(transformed, v, time.Now()); err != nil {
+		t.Errorf("ValidateEvent() of the signed result error = %v", err)
+	}
+
+	// Without signing again the rewritten event no longer matches its ID
+	unsigned, _ := transformEvent(event, []Transform{StripTags("client")})
+	var rejection *config.Rejection
+	if err := ValidateEvent(unsigned, v, time.Now()); !errors.As(err, &rejection) || rejection.Reason != config.RejectBadID {
+		t.Errorf("ValidateEvent() of an unsigned rewrite error = %v, want bad-id", err)
+	}
+
+	failing := func(*nostr.Event) (*nostr.Event, error) { return nil, errors.New("bunker unreachable") }
+	if _, err := transformEvent(event, []Transform{failing}); !errors.As(err, &rejection) || rejection.Reason != config.RejectTransformFailed {
+		t.Errorf("transformEvent() with a failing transform error = %v, want transform-failed", err)
+	}
+	refusing := func(*nostr.Event) (*nostr.Event, error) {
+		return nil, config.NewRejection(config.RejectKindNotAllowed, "1", "no notes")
+	}
+	if _, err := transformEvent(event, []Transform{refusing}); !errors.As(err, &rejection) || rejection.Reason != config.RejectKindNotAllowed {
+		t.Errorf("transformEvent() with a refusing transform error = %v, want its rejection", err)
+	}
+	dropping := func(*nostr.Event) (*nostr.Event, error) { return nil, nil }
+	if _, err := transformEvent(event, []Transform{dropping}); !errors.As(err, &rejection) || rejection.Reason != config.RejectTransformFailed {
+		t.Errorf("transformEvent() with no event returned error = %v, want transform-failed", err)
+	}
+}
+
+func TestDispatcher_PublishTransformed(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pk, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	path, _ := ValidatePath([]string{mustNpub(t, pk)})
+	sk := nostr.GeneratePrivateKey()
+	opts := DefaultOptions()
+	opts.Transforms = []Transform{AddExpiration(time.Hour), SignWith(sk)}
+	dispatcher, err := NewDispatcher(ctx, path, &fakePool{published: make(chan nostr.Event, 1)}, []string{"wss://relay.example.com"}, opts)
+	if err != nil {
+		t.Fatalf("NewDispatcher() error = %v", err)
+	}
+
+	event := &nostr.Event{Kind: 1, Content: "dispatch me", CreatedAt: nostr.Now(), Tags: nostr.Tags{}}
+	event.Sign(sk)
+	entry, err := dispatcher.Publish(ctx, event)
+	if err != nil {
+		t.Fatalf("Publish() error = %v", err)
+	}
+	if entry.EventID == event.ID || entry.WrappedID == "" {
+		t.Errorf("entry = %+v, want the rewritten event dispatched", entry)
+	}
+}
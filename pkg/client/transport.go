@@ -0,0 +1,115 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/girino/nostr-lib/logging"
+)
+
+// DefaultTransportChecker is the service asked for the client's exit IP by the transport check.
+const DefaultTransportChecker = "https://api.ipify.org?format=json"
+
+// SetProxy routes the process's outgoing HTTP and websocket connections (server relays, LNURL,
+// remote miners) through proxyURL, e.g. socks5://127.0.0.1:9050 for Tor. Loopback addresses
+// are always reached directly.
+func SetProxy(proxyURL string) error {
+	proxy, err := url.Parse(proxyURL)
+	if err != nil {
+		return fmt.Errorf("invalid proxy URL: %w", err)
+	}
+	switch proxy.Scheme {
+	case "socks5", "socks5h", "http", "https":
+	default:
+		return fmt.Errorf("unsupported proxy scheme %q (use socks5, socks5h, http or https)", proxy.Scheme)
+	}
+	transport, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		return fmt.Errorf("the default HTTP transport cannot be configured")
+	}
+	transport.Proxy = func(req *http.Request) (*url.URL, error) {
+		if isLoopback(req.URL.Hostname()) {
+			return nil, nil
+		}
+		return proxy, nil
+	}
+	logging.Info("client.transport.SetProxy: Routing outgoing connections through %s://%s", proxy.Scheme, proxy.Host)
+	return nil
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// ObservedIP asks checkerURL which IP the request came from, using httpClient. The checker may
+// answer with the bare IP or with JSON holding it in an "ip" or "IP" field.
+func ObservedIP(ctx context.Context, httpClient *http.Client, checkerURL string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, checkerURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create checker request: %w", err)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("checker request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil {
+		return "", fmt.Errorf("failed to read checker response: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("checker returned status %d", resp.StatusCode)
+	}
+
+	text := strings.TrimSpace(string(body))
+	var fields map[string]any
+	if json.Unmarshal(body, &fields) == nil {
+		for _, key := range []string{"ip", "IP"} {
+			if value, ok := fields[key].(string); ok {
+				text = value
+				break
+			}
+		}
+	}
+	if net.ParseIP(text) == nil {
+		return "", fmt.Errorf("checker returned no IP address")
+	}
+	return text, nil
+}
+
+// CheckTransport verifies that connections made with proxied (the client used for relays) leave
+// through a different IP than direct ones, returning the exit IP. An error means the proxy is
+// not in effect and relays would see the client's own address.
+func CheckTransport(ctx context.Context, checkerURL string, proxied, direct *http.Client) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	exitIP, err := ObservedIP(ctx, proxied, checkerURL)
+	if err != nil {
+		logging.Error("client.transport.CheckTransport: failed to observe the exit IP: %v", err)
+		return "", fmt.Errorf("failed to observe the exit IP: %w", err)
+	}
+	directIP, err := ObservedIP(ctx, direct, checkerURL)
+	if err != nil {
+		// Without a direct route (e.g. a firewall only allowing the proxy) nothing can leak
+		logging.Info("client.transport.CheckTransport: Exit IP is %s, no direct connection possible (%v)", exitIP, err)
+		return exitIP, nil
+	}
+	if exitIP == directIP {
+		logging.Error("client.transport.CheckTransport: exit IP %s is the client's own address", exitIP)
+		return exitIP, fmt.Errorf("connections are not going through the proxy: exit IP %s is the client's own address", exitIP)
+	}
+	logging.Info("client.transport.CheckTransport: Exit IP is %s, different from the direct address", exitIP)
+	return exitIP, nil
+}
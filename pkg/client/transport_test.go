@@ -0,0 +1,65 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// viaProxy marks requests as if they had left through a proxy.
+type viaProxy struct{}
+
+func (viaProxy) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("X-Exit", "203.0.113.7")
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func newIPChecker(t *testing.T, format string) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := r.Header.Get("X-Exit")
+		if ip == "" {
+			ip = "198.51.100.1"
+		}
+		fmt.Fprintf(w, format, ip)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestObservedIP_Formats(t *testing.T) {
+	for _, format := range []string{"%s\n", `{"ip":"%s"}`, `{"IsTor":true,"IP":"%s"}`} {
+		checker := newIPChecker(t, format)
+		if ip, err := ObservedIP(context.Background(), http.DefaultClient, checker.URL); err != nil || ip != "198.51.100.1" {
+			t.Errorf("ObservedIP(%q) = %q, %v", format, ip, err)
+		}
+	}
+	if _, err := ObservedIP(context.Background(), http.DefaultClient, newIPChecker(t, "not an ip %s").URL); err == nil {
+		t.Error("ObservedIP() should reject a response without an IP")
+	}
+}
+
+func TestCheckTransport(t *testing.T) {
+	checker := newIPChecker(t, "%s")
+	direct := &http.Client{}
+
+	exitIP, err := CheckTransport(context.Background(), checker.URL, &http.Client{Transport: viaProxy{}}, direct)
+	if err != nil || exitIP != "203.0.113.7" {
+		t.Errorf("CheckTransport() through a proxy = %q, %v", exitIP, err)
+	}
+	if _, err := CheckTransport(context.Background(), checker.URL, direct, direct); err == nil {
+		t.Error("CheckTransport() should fail when the exit IP is the direct one")
+	}
+}
+
+func TestSetProxy_Invalid(t *testing.T) {
+	if err := SetProxy("ftp://proxy:21"); err == nil {
+		t.Error("SetProxy() should reject unsupported schemes")
+	}
+	if !isLoopback("127.0.0.1") || !isLoopback("localhost") || isLoopback("relay.example.com") {
+		t.Error("isLoopback() misclassifies hosts")
+	}
+}
@@ -0,0 +1,100 @@
+package client
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/girino/renoter/internal/config"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// EventValidation configures the checks a submitted event must pass before it is wrapped, so a
+// malformed event is rejected in the OK message instead of being mined for and then silently
+// dropped by the exit or the destination relays.
+type EventValidation struct {
+	// How far created_at may be behind the client's clock (0 = any age)
+	MaxAge time.Duration
+	// How far created_at may be ahead of the client's clock (0 = any)
+	MaxFuture time.Duration
+	// Kinds routed through the Renoters (nil = all). The Renoter wrapper kinds are never routed.
+	AllowedKinds map[int]bool
+	// Kinds never routed because their content identifies the author anyway, so publishing them
+	// anonymously is almost always a mistake (nil or empty = none)
+	NeverRouted map[int]bool
+	// What to do with events carrying tags that can identify the author, by tag name (nil = nothing)
+	TagPolicies map[string]TagPolicy
+}
+
+// DefaultNeverRoutedKinds are the kinds refused by default: profile metadata (0) and contact lists (3).
+var DefaultNeverRoutedKinds = []int{0, 3}
+
+// unsafeKindNames names the kinds explained in kind-unsafe rejections.
+var unsafeKindNames = map[int]string{
+	0:     "profile metadata",
+	3:     "contact lists",
+	10002: "relay lists",
+}
+
+// DefaultEventValidation returns the checks applied by default: the ID and signature, a
+// created_at at most 15 minutes ahead, which is what many relays tolerate, and none of the
+// DefaultNeverRoutedKinds.
+func DefaultEventValidation() EventValidation {
+	neverRouted := make(map[int]bool, len(DefaultNeverRoutedKinds))
+	for _, kind := range DefaultNeverRoutedKinds {
+		neverRouted[kind] = true
+	}
+	return EventValidation{MaxFuture: 15 * time.Minute, NeverRouted: neverRouted}
+}
+
+// Validate checks that the settings are usable.
+func (v EventValidation) Validate() error {
+	if v.MaxAge < 0 || v.MaxFuture < 0 {
+		return fmt.Errorf("created_at limits must not be negative")
+	}
+	for name, policy := range v.TagPolicies {
+		if policy != TagWarn && policy != TagReject {
+			return fmt.Errorf("invalid policy %q for tag %q", policy, name)
+		}
+	}
+	return nil
+}
+
+// ValidateEvent checks an event against v at now, returning a *config.Rejection ready to be
+// used as the OK message if it fails.
+func ValidateEvent(event *nostr.Event, v EventValidation, now time.Time) error {
+	// A 29000 or 29001 would be taken for another routing layer by the exit
+	if event.Kind == config.WrapperEventKind || event.Kind == config.StandardizedWrapperKind ||
+		(v.AllowedKinds != nil && !v.AllowedKinds[event.Kind]) {
+		logging.Warn("client.validate.ValidateEvent: rejecting event %s of kind %d", event.ID, event.Kind)
+		return config.NewRejection(config.RejectKindNotAllowed, strconv.Itoa(event.Kind), fmt.Sprintf("events of kind %d are not routed", event.Kind))
+	}
+	if v.NeverRouted[event.Kind] {
+		what := fmt.Sprintf("events of kind %d", event.Kind)
+		if name, ok := unsafeKindNames[event.Kind]; ok {
+			what = fmt.Sprintf("%s (kind %d)", name, event.Kind)
+		}
+		logging.Warn("client.validate.ValidateEvent: refusing to route event %s of unsafe kind %d", event.ID, event.Kind)
+		return config.NewRejection(config.RejectKindUnsafe, strconv.Itoa(event.Kind), fmt.Sprintf("%s are never routed: their content identifies you anyway, publish them directly", what))
+	}
+	if !event.CheckID() {
+		logging.Warn("client.validate.ValidateEvent: rejecting event %s: ID does not match", event.ID)
+		return config.NewRejection(config.RejectBadID, "", "event ID does not match its contents")
+	}
+	if valid, err := event.CheckSignature(); err != nil || !valid {
+		logging.Warn("client.validate.ValidateEvent: rejecting event %s: invalid signature", event.ID)
+		return config.NewRejection(config.RejectBadSignature, "", "event signature is invalid")
+	}
+
+	createdAt := event.CreatedAt.Time()
+	if v.MaxFuture > 0 && createdAt.After(now.Add(v.MaxFuture)) {
+		logging.Warn("client.validate.ValidateEvent: rejecting event %s created in the future (%v)", event.ID, createdAt)
+		return config.NewRejection(config.RejectBadCreatedAt, v.MaxFuture.String(), fmt.Sprintf("created_at is more than %v in the future", v.MaxFuture))
+	}
+	if v.MaxAge > 0 && createdAt.Before(now.Add(-v.MaxAge)) {
+		logging.Warn("client.validate.ValidateEvent: rejecting event %s created too long ago (%v)", event.ID, createdAt)
+		return config.NewRejection(config.RejectBadCreatedAt, v.MaxAge.String(), fmt.Sprintf("created_at is more than %v in the past", v.MaxAge))
+	}
+	return checkTags(event, v.TagPolicies)
+}
@@ -0,0 +1,58 @@
+package client
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/girino/renoter/internal/config"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestValidateEvent(t *testing.T) {
+	now := time.Now()
+	signed := func(kind int, createdAt time.Time) *nostr.Event {
+		event := &nostr.Event{Kind: kind, Content: "validate me", CreatedAt: nostr.Timestamp(createdAt.Unix()), Tags: nostr.Tags{}}
+		event.Sign(nostr.GeneratePrivateKey())
+		return event
+	}
+	tampered := signed(1, now)
+	tampered.Content = "changed after signing"
+	badSig := signed(1, now)
+	badSig.Sig = signed(1, now).Sig
+
+	strict := EventValidation{MaxAge: time.Hour, MaxFuture: time.Minute, AllowedKinds: map[int]bool{1: true}}
+	tests := []struct {
+		name       string
+		event      *nostr.Event
+		validation EventValidation
+		wantReason string
+	}{
+		{"valid", signed(1, now), DefaultEventValidation(), ""},
+		{"old event by default", signed(1, now.Add(-48*time.Hour)), DefaultEventValidation(), ""},
+		{"wrapper kind", signed(config.WrapperEventKind, now), DefaultEventValidation(), config.RejectKindNotAllowed},
+		{"kind not allowed", signed(7, now), strict, config.RejectKindNotAllowed},
+		{"metadata", signed(0, now), DefaultEventValidation(), config.RejectKindUnsafe},
+		{"contact list", signed(3, now), DefaultEventValidation(), config.RejectKindUnsafe},
+		{"contact list overridden", signed(3, now), EventValidation{}, ""},
+		{"tampered", tampered, DefaultEventValidation(), config.RejectBadID},
+		{"foreign signature", badSig, DefaultEventValidation(), config.RejectBadSignature},
+		{"future", signed(1, now.Add(time.Hour)), DefaultEventValidation(), config.RejectBadCreatedAt},
+		{"too old", signed(1, now.Add(-2*time.Hour)), strict, config.RejectBadCreatedAt},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateEvent(tt.event, tt.validation, now)
+			if tt.wantReason == "" {
+				if err != nil {
+					t.Errorf("ValidateEvent() error = %v", err)
+				}
+				return
+			}
+			var rejection *config.Rejection
+			if !errors.As(err, &rejection) || rejection.Reason != tt.wantReason {
+				t.Errorf("ValidateEvent() error = %v, want reason %s", err, tt.wantReason)
+			}
+		})
+	}
+}
@@ -2,100 +2,354 @@ package client
 
 import (
 	"context"
-	"crypto/rand"
-	"encoding/hex"
-	"encoding/json"
 	"fmt"
+	"math/rand"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/girino/nostr-lib/logging"
+	"github.com/girino/renoter/internal/compact"
 	"github.com/girino/renoter/internal/config"
+	"github.com/girino/renoter/internal/padding"
+	"github.com/girino/renoter/internal/sealtag"
+	"github.com/girino/renoter/internal/trailer"
 	"github.com/nbd-wtf/go-nostr"
-	"github.com/nbd-wtf/go-nostr/nip13"
 	"github.com/nbd-wtf/go-nostr/nip44"
 )
 
-const MaxWrappedEventSize = 32 * 1024 // 32KB maximum size for wrapped events after encryption
+// MaxTimestampFuzz bounds WrapOptions.TimestampFuzz, well within the age Renoters admit layers at.
+const MaxTimestampFuzz = 15 * time.Minute
+
+// WrapOptions configures WrapEvent. New protocol options become fields here, so callers keep
+// compiling as the protocol grows; the zero value of every field added after Options keeps the
+// behavior of the path. Relay hints travel with the path: PathNode.Relays.
+type WrapOptions struct {
+	Options
+	// PoW difficulty of the 29000 layers (0 = the profile's, config.PoWDifficulty or
+	// config.LitePoWDifficulty)
+	PoWDifficulty int
+	// Size the outermost 29000 is padded to, one of Limits.Sizes() (0 = the smallest it fits in).
+	// Forcing a bucket also disables the lite profile.
+	Bucket int
+	// Largest random amount every layer and the container are backdated by, each independently,
+	// so their timestamps do not line up (0 = none, at most MaxTimestampFuzz)
+	TimestampFuzz time.Duration
+}
+
+// DefaultWrapOptions returns the WrapOptions of DefaultOptions.
+func DefaultWrapOptions() WrapOptions {
+	return WrapOptions{Options: DefaultOptions()}
+}
 
-// padEventToExactSize adds padding tags to an event to make its serialized size exactly targetSize.
-// Returns a new event with padding tags added, or an error if the base event is too large.
-// Accounts for padding tag overhead before calculating padding needed.
-func padEventToExactSize(event *nostr.Event, targetSize int) (*nostr.Event, error) {
-	// Create a copy to avoid modifying the original
-	paddedEvent := *event
-	if paddedEvent.Tags == nil {
-		paddedEvent.Tags = nostr.Tags{}
+// Validate checks the options WrapOptions adds to Options. The embedded Options are checked by
+// WrapEvent itself, as far as wrapping uses them.
+func (o WrapOptions) Validate() error {
+	if o.PoWDifficulty < 0 || o.PoWDifficulty > 256 {
+		return fmt.Errorf("PoW difficulty must be between 0 and 256, got %d", o.PoWDifficulty)
+	}
+	if o.Bucket != 0 && !slices.Contains(o.Limits.Sizes(), o.Bucket) {
+		return fmt.Errorf("size bucket %d is not one of %v", o.Bucket, o.Limits.Sizes())
 	}
+	if o.TimestampFuzz < 0 || o.TimestampFuzz > MaxTimestampFuzz {
+		return fmt.Errorf("timestamp fuzz must be between 0 and %s, got %s", MaxTimestampFuzz, o.TimestampFuzz)
+	}
+	return nil
+}
 
-	// Serialize event to get current size (without padding)
-	eventJSON, err := json.Marshal(&paddedEvent)
-	if err != nil {
-		return nil, fmt.Errorf("failed to serialize event for padding: %w", err)
+// WrapEvent creates nested wrapper events for the given Renoter path and wraps the outermost one
+// in a standardized 29001 container. Events are wrapped in reverse order (last Renoter first,
+// first Renoter last); each wrapper event encrypts the inner event for the next Renoter in the
+// path. opts.Limits must match those of the Renoters in the path.
+// It never asks for timing trailers; opts.ReportLatency applies to the Dispatcher only, which
+// keeps the key to read them.
+func WrapEvent(ctx context.Context, originalEvent *nostr.Event, renterPath Path, opts WrapOptions) (*nostr.Event, error) {
+	opts.ReportLatency = false
+	wrapped, _, err := wrapEvent(ctx, originalEvent, renterPath, opts, "")
+	return wrapped, err
+}
+
+// wrapEvent is WrapEvent asking every Renoter for a timing trailer sealed to
+// reportPubkey when opts.ReportLatency is set, for a hop acknowledgement when opts.hopAcks is, and
+// for error notices to opts.replyTo when set.
+// It also returns the keys of the layers, in path order.
+func wrapEvent(ctx context.Context, originalEvent *nostr.Event, renterPath Path, opts WrapOptions, reportPubkey string) (*nostr.Event, []layerKeys, error) {
+	limits := opts.Limits
+	logging.DebugMethod("client.wrapper", "WrapEvent", "Starting event wrapping, path length: %d, original event ID: %s, kind: %d", len(renterPath), originalEvent.ID, originalEvent.Kind)
+
+	if len(renterPath) == 0 {
+		logging.Error("client.wrapper.WrapEvent: renoter path cannot be empty")
+		return nil, nil, fmt.Errorf("renoter path cannot be empty")
 	}
-	currentSize := len(eventJSON)
 
-	// Calculate padding tag base size: ["padding",""]
-	testEventWithEmptyPadding := *event
-	if testEventWithEmptyPadding.Tags == nil {
-		testEventWithEmptyPadding.Tags = nostr.Tags{}
+	if err := limits.Validate(); err != nil {
+		logging.Error("client.wrapper.WrapEvent: invalid size limits: %v", err)
+		return nil, nil, fmt.Errorf("invalid size limits: %w", err)
+	}
+	if opts.Miner == nil {
+		logging.Error("client.wrapper.WrapEvent: no PoW miner configured")
+		return nil, nil, fmt.Errorf("no PoW miner configured")
+	}
+	if err := opts.Validate(); err != nil {
+		logging.Error("client.wrapper.WrapEvent: invalid wrap options: %v", err)
+		return nil, nil, fmt.Errorf("invalid wrap options: %w", err)
 	}
-	testEventWithEmptyPadding.Tags = append(testEventWithEmptyPadding.Tags, nostr.Tag{"padding", ""})
-	testJSONWithEmptyPadding, _ := json.Marshal(&testEventWithEmptyPadding)
-	tagBaseSize := len(testJSONWithEmptyPadding) - currentSize
-	logging.DebugMethod("client.wrapper", "padEventToExactSize", "Padding tag base size: %d bytes", tagBaseSize)
 
-	// Calculate total size including padding tag base
-	totalSize := currentSize + tagBaseSize
+	// Reject oversized events up front using the size model, before any payment, encryption or PoW.
+	// The budget leaves room for payment proofs and Cashu tokens, so an event that fits now still
+	// fits once paid
+	recipients := renterPath.Keys()
+	budget, powDifficulty := wrapProfile(originalEvent, renterPath, opts.Options)
+	if opts.Bucket > 0 {
+		forced := config.SizeLimits{StandardizedSize: opts.Bucket, MaxInnerEventSize: opts.Bucket - config.PaddingTagOverhead}
+		budget = newSizeBudget(len(renterPath), forced, opts.layerFormat()).withPayments(opts.paymentLayers(recipients))
+		powDifficulty = config.PoWDifficulty
+	}
+	if opts.PoWDifficulty > 0 {
+		powDifficulty = opts.PoWDifficulty
+	}
+	if err := budget.CheckEvent(originalEvent); err != nil {
+		return nil, nil, err
+	}
+
+	// Pay paid Renoters on the path and take Cashu tokens for those admitting them; each tag
+	// goes into the layer addressed to its Renoter
+	payments, err := collectPayments(ctx, recipients, opts.Options)
+	if err != nil {
+		logging.Error("client.wrapper.WrapEvent: %v", err)
+		return nil, nil, err
+	}
+	tokens, err := collectCashuTokens(ctx, recipients, opts.Options)
+	if err != nil {
+		logging.Error("client.wrapper.WrapEvent: %v", err)
+		return nil, nil, err
+	}
+
+	// The exit recognizes resent copies of the event by its idempotency key
+	idempotency := map[string]nostr.Tag{recipients[len(recipients)-1]: {config.IdempotencyTag, config.IdempotencyKey(originalEvent.ID)}}
 
-	// Check if base event is too large
-	if totalSize > targetSize {
-		logging.Error("client.wrapper.padEventToExactSize: event base size %d (with tag overhead %d) exceeds target size %d", currentSize, tagBaseSize, targetSize)
-		return nil, fmt.Errorf("event too large: base event size %d bytes exceeds maximum %d bytes", totalSize, targetSize)
+	// In the mixed lane every Renoter is asked to hold the event for a random delay
+	delays := make(map[string]nostr.Tag)
+	if opts.Lane == config.LaneMixed && opts.MixingDelay > 0 {
+		for _, recipient := range recipients {
+			delays[recipient] = nostr.Tag{config.DelayTag, config.FormatDelayHint(opts.MixingDelay)}
+		}
+	}
+
+	// Every Renoter is asked for a timing trailer, and the first container starts with decoys
+	reports := make(map[string]nostr.Tag)
+	var trailers nostr.Tags
+	if opts.ReportLatency {
+		for _, recipient := range recipients {
+			reports[recipient] = nostr.Tag{config.ReportTag, reportPubkey}
+		}
+		if trailers, err = trailer.Decoys(); err != nil {
+			logging.Error("client.wrapper.WrapEvent: failed to create trailer decoys: %v", err)
+			return nil, nil, err
+		}
 	}
 
-	// Calculate exact padding needed
-	paddingNeeded := targetSize - totalSize
+	// Every Renoter is asked for a hop acknowledgement
+	acks := make(map[string]nostr.Tag)
+	if opts.hopAcks {
+		for _, recipient := range recipients {
+			acks[recipient] = nostr.Tag{config.AckTag, strconv.Itoa(config.ProtocolVersion)}
+		}
+	}
 
-	// Generate padding string of exactly the needed length
-	paddingBytes := make([]byte, (paddingNeeded+1)/2) // Round up
-	if len(paddingBytes) > 0 {
-		if _, err := rand.Read(paddingBytes); err != nil {
-			return nil, fmt.Errorf("failed to generate random padding: %w", err)
+	// Every Renoter is asked for an error notice should it reject the event
+	replies := make(map[string]nostr.Tag)
+	if opts.replyTo != "" {
+		for _, recipient := range recipients {
+			replies[recipient] = nostr.Tag{config.ReplyTag, opts.replyTo}
 		}
 	}
-	paddingString := hex.EncodeToString(paddingBytes)
 
-	// Truncate to exact length needed
-	if len(paddingString) > paddingNeeded {
-		paddingString = paddingString[:paddingNeeded]
+	// Build the nested 29000 layers, starting from the original event
+	// Stamps would age while mixed-lane layers are held, so those are always mined
+	stamps := opts.stamps
+	if opts.Lane == config.LaneMixed {
+		stamps = nil
+	}
+	currentEvent, keys, err := wrapLayers(ctx, originalEvent, renterPath, powDifficulty, opts.Miner, sealedLayerTags(payments, tokens, idempotency, delays, reports, acks, replies), opts.CompactLayers, stamps, opts.TimestampFuzz)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	// Add padding tag
-	paddedEvent.Tags = append(paddedEvent.Tags, nostr.Tag{"padding", paddingString})
+	// After creating all 29000 layers, check the outermost 29000 against the same budget, which
+	// only fails for a custom Payer or CashuWallet handing out tags beyond the reserved room. We
+	// pad it to exactly the smallest bucket it fits in and wrap it in a 29001 container; every
+	// Renoter keeps that bucket for the containers it forwards.
+	outermost29000JSON, err := marshalEventPooled(currentEvent)
+	if err != nil {
+		logging.Error("client.wrapper.WrapEvent: failed to serialize outermost 29000 event for size check: %v", err)
+		return nil, nil, fmt.Errorf("failed to serialize outermost 29000 event: %w", err)
+	}
+	outermost29000Size := len(*outermost29000JSON)
+	releaseJSONBuffer(outermost29000JSON)
+	bucket, err := budget.bucket(outermost29000Size)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Get first Renoter's pubkey for addressing the 29001 container
+	firstRenoterPubkey := renterPath[0].Key()
 
-	// Verify final size
-	finalJSON, _ := json.Marshal(&paddedEvent)
-	if len(finalJSON) != targetSize {
-		logging.Error("client.wrapper.padEventToExactSize: padded event size %d does not match target %d", len(finalJSON), targetSize)
-		return nil, fmt.Errorf("padded event size %d does not match target %d", len(finalJSON), targetSize)
+	standardizedEvent, err := buildStandardizedContainer(ctx, currentEvent, firstRenoterPubkey, bucket, opts.ContainerPoWDifficulty, opts.Miner, trailers, opts.TimestampFuzz)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	logging.DebugMethod("client.wrapper", "padEventToExactSize", "Added padding: %d bytes needed, event size: %d -> target: %d", paddingNeeded, currentSize, targetSize)
+	logging.Info("client.wrapper.WrapEvent: Successfully wrapped event through %d Renoter layers, created 29001 container, ID: %s", len(renterPath), standardizedEvent.ID)
+	return standardizedEvent, keys, nil
+}
 
-	return &paddedEvent, nil
+// fuzzedNow returns the current time backdated by a random amount of whole seconds up to fuzz.
+func fuzzedNow(fuzz time.Duration) nostr.Timestamp {
+	if fuzz <= 0 {
+		return nostr.Now()
+	}
+	return nostr.Now() - nostr.Timestamp(rand.Int63n(int64(fuzz/time.Second)+1))
 }
 
-// WrapEvent creates nested wrapper events for the given Renoter path.
-// Events are wrapped in reverse order (last Renoter first, first Renoter last).
-// Each wrapper event encrypts the inner event for the next Renoter in the path.
-func WrapEvent(ctx context.Context, originalEvent *nostr.Event, renterPath [][]byte) (*nostr.Event, error) {
-	logging.DebugMethod("client.wrapper", "WrapEvent", "Starting event wrapping, path length: %d, original event ID: %s, kind: %d", len(renterPath), originalEvent.ID, originalEvent.Kind)
+// jsonBufferPool recycles serialization buffers between layers and wraps; every layer of a
+// wrap serializes an event of up to the standardized size only to encrypt it immediately.
+var jsonBufferPool = sync.Pool{
+	New: func() any {
+		buf := make([]byte, 0, config.StandardizedSize)
+		return &buf
+	},
+}
 
-	if len(renterPath) == 0 {
-		logging.Error("client.wrapper.WrapEvent: renoter path cannot be empty")
-		return nil, fmt.Errorf("renoter path cannot be empty")
+// marshalEventPooled serializes an event with padding.Marshal into a buffer taken from jsonBufferPool.
+// The caller must hand the buffer back with releaseJSONBuffer once done with it.
+func marshalEventPooled(event *nostr.Event) (*[]byte, error) {
+	buf := jsonBufferPool.Get().(*[]byte)
+	out, err := padding.MarshalTo(*buf, event)
+	if err != nil {
+		releaseJSONBuffer(buf)
+		return nil, err
+	}
+	*buf = out
+	return buf, nil
+}
+
+// marshalLayerPlaintext serializes the event a layer holds, compact or as JSON. Like
+// marshalEventPooled, the buffer must be handed back with releaseJSONBuffer.
+func marshalLayerPlaintext(event *nostr.Event, compactLayers bool) (*[]byte, error) {
+	if !compactLayers {
+		return marshalEventPooled(event)
+	}
+	encoded, err := compact.Encode(event)
+	if err != nil {
+		return nil, err
+	}
+	return &encoded, nil
+}
+
+// releaseJSONBuffer returns a buffer obtained from marshalEventPooled to the pool.
+func releaseJSONBuffer(buf *[]byte) {
+	*buf = (*buf)[:0]
+	jsonBufferPool.Put(buf)
+}
+
+// layerKeys holds the ephemeral key material used to build one wrapper layer.
+type layerKeys struct {
+	sk              string
+	pubkey          string
+	conversationKey [32]byte
+	// Nonce tag of a stamp mined ahead for the layer, and the date it was mined for (nil = mine
+	// the layer when wrapping)
+	nonce     nostr.Tag
+	createdAt nostr.Timestamp
+}
+
+// newLayerKeys generates an ephemeral key and its NIP-44 conversation key with recipient.
+func newLayerKeys(recipient string) (layerKeys, error) {
+	sk := nostr.GeneratePrivateKey()
+	pubkey, err := nostr.GetPublicKey(sk)
+	if err != nil {
+		return layerKeys{}, fmt.Errorf("failed to get public key: %w", err)
+	}
+	conversationKey, err := nip44.GenerateConversationKey(recipient, sk)
+	if err != nil {
+		return layerKeys{}, fmt.Errorf("failed to generate conversation key: %w", err)
+	}
+	return layerKeys{sk: sk, pubkey: pubkey, conversationKey: conversationKey}, nil
+}
+
+// deriveLayerKeys generates an ephemeral key and NIP-44 conversation key for every recipient,
+// taking those of a pre-mined stamp from stamps where there is one (nil = none).
+// Only encryption depends on the previous layer, so the EC work for all layers runs concurrently.
+func deriveLayerKeys(recipients []string, stamps *stampPool) ([]layerKeys, error) {
+	keys := make([]layerKeys, len(recipients))
+	errs := make([]error, len(recipients))
+
+	var wg sync.WaitGroup
+	for i, recipient := range recipients {
+		if stamped, ok := stamps.take(recipient); ok {
+			keys[i] = stamped
+			continue
+		}
+		wg.Add(1)
+		go func(i int, recipient string) {
+			defer wg.Done()
+			generated, err := newLayerKeys(recipient)
+			if err != nil {
+				errs[i] = fmt.Errorf("renoter %d: %w", i, err)
+				return
+			}
+			keys[i] = generated
+		}(i, recipient)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return keys, nil
+}
+
+// CheckEventSize reports whether an original event fits through a path of pathLength hops under
+// the given limits, using the size model so no encryption or PoW is needed. Oversized events get a
+// *config.Rejection with config.RejectSizeExceeded.
+func CheckEventSize(originalEvent *nostr.Event, pathLength int, limits config.SizeLimits) error {
+	return NewSizeBudget(pathLength, limits).CheckEvent(originalEvent)
+}
+
+// sealedLayerTags groups per-Renoter tags (payments, Cashu tokens, the idempotency key, mixing delays, report keys, ack requests) by the layer they go into.
+func sealedLayerTags(sets ...map[string]nostr.Tag) map[string]nostr.Tags {
+	layers := make(map[string]nostr.Tags)
+	for _, set := range sets {
+		for pubkey, tag := range set {
+			layers[pubkey] = append(layers[pubkey], tag)
+		}
+	}
+	return layers
+}
+
+// wrapLayers builds the nested 29000 wrapper events for the path and returns the outermost one,
+// with the keys of the layers in path order.
+// Each layer is mined to powDifficulty with miner; a difficulty of 0 skips mining entirely.
+// sealed holds, by Renoter pubkey, the tags whose values are sealed to that Renoter in its layer
+// (payment proofs, Cashu tokens, the idempotency key, mixing delays; nil if none). Layers carrying a Cashu token are not mined.
+// With compactLayers every layer holds the event inside it in the compact encoding instead of JSON.
+// Layers taking a stamp from stamps (nil = none) carry it instead of being mined; the others are
+// backdated by up to fuzz.
+func wrapLayers(ctx context.Context, originalEvent *nostr.Event, renterPath Path, powDifficulty int, miner PoWMiner, sealed map[string]nostr.Tags, compactLayers bool, stamps *stampPool, fuzz time.Duration) (*nostr.Event, []layerKeys, error) {
+	recipients := renterPath.Keys()
+
+	// Generate ephemeral keys and conversation keys for all layers up front
+	logging.DebugMethod("client.wrapper", "WrapEvent", "Deriving keys for %d layers", len(recipients))
+	keys, err := deriveLayerKeys(recipients, stamps)
+	if err != nil {
+		logging.Error("client.wrapper.WrapEvent: failed to derive layer keys: %v", err)
+		return nil, nil, err
 	}
 
-	// Start with the original event
 	// Note: We don't pad the original event because it's already signed,
 	// and padding would invalidate the signature. We only pad wrapper events
 	// (which we create and sign ourselves after padding).
@@ -105,47 +359,27 @@ func WrapEvent(ctx context.Context, originalEvent *nostr.Event, renterPath [][]b
 
 	// Wrap in reverse order (last Renoter first)
 	for i := len(renterPath) - 1; i >= 0; i-- {
-		renoterPubkeyBytes := renterPath[i]
-		renoterPubkey := hex.EncodeToString(renoterPubkeyBytes)
+		renoterPubkey := recipients[i]
+		layer := keys[i]
 
 		logging.DebugMethod("client.wrapper", "WrapEvent", "Wrapping layer %d/%d for Renoter pubkey: %s (first 16 chars: %s)", len(renterPath)-i, len(renterPath), renoterPubkey, renoterPubkey[:16])
 
 		// Serialize inner event for encryption
 		logging.DebugMethod("client.wrapper", "WrapEvent", "Serializing inner event to JSON (layer %d)", i)
-		eventJSON, err := json.Marshal(currentEvent)
+		eventJSON, err := marshalLayerPlaintext(currentEvent, compactLayers)
 		if err != nil {
 			logging.Error("client.wrapper.WrapEvent: failed to serialize event at layer %d: %v", i, err)
-			return nil, fmt.Errorf("failed to serialize event: %w", err)
+			return nil, nil, fmt.Errorf("failed to serialize event: %w", err)
 		}
-		logging.DebugMethod("client.wrapper", "WrapEvent", "Serialized event JSON length: %d bytes (layer %d)", len(eventJSON), i)
-
-		// Generate random key for this wrapper event
-		logging.DebugMethod("client.wrapper", "WrapEvent", "Generating random key for wrapper (layer %d)", i)
-		sk := nostr.GeneratePrivateKey()
-
-		// Get public key from private key
-		pubkey, err := nostr.GetPublicKey(sk)
-		if err != nil {
-			logging.Error("client.wrapper.WrapEvent: failed to get public key at layer %d: %v", i, err)
-			return nil, fmt.Errorf("failed to get public key: %w", err)
-		}
-		logging.DebugMethod("client.wrapper", "WrapEvent", "Generated wrapper key pubkey: %s (first 16 chars, layer %d)", pubkey[:16], i)
-
-		// Generate conversation key using Renoter's public key and our random private key
-		logging.DebugMethod("client.wrapper", "WrapEvent", "Generating conversation key (layer %d)", i)
-		conversationKey, err := nip44.GenerateConversationKey(renoterPubkey, sk)
-		if err != nil {
-			logging.Error("client.wrapper.WrapEvent: failed to generate conversation key for renoter %d: %v", i, err)
-			return nil, fmt.Errorf("failed to generate conversation key for renoter %d: %w", i, err)
-		}
-		logging.DebugMethod("client.wrapper", "WrapEvent", "Generated conversation key (layer %d)", i)
+		logging.DebugMethod("client.wrapper", "WrapEvent", "Serialized event JSON length: %d bytes (layer %d)", len(*eventJSON), i)
 
 		// Encrypt for this Renoter using NIP-44
 		logging.DebugMethod("client.wrapper", "WrapEvent", "Encrypting with NIP-44 (layer %d)", i)
-		ciphertext, err := nip44.Encrypt(string(eventJSON), conversationKey)
+		ciphertext, err := nip44.Encrypt(string(*eventJSON), layer.conversationKey)
+		releaseJSONBuffer(eventJSON)
 		if err != nil {
 			logging.Error("client.wrapper.WrapEvent: failed to encrypt for renoter %d: %v", i, err)
-			return nil, fmt.Errorf("failed to encrypt for renoter %d: %w", i, err)
+			return nil, nil, fmt.Errorf("failed to encrypt for renoter %d: %w", i, err)
 		}
 		logging.DebugMethod("client.wrapper", "WrapEvent", "Encrypted ciphertext length: %d bytes (layer %d)", len(ciphertext), i)
 
@@ -153,43 +387,50 @@ func WrapEvent(ctx context.Context, originalEvent *nostr.Event, renterPath [][]b
 		wrapperEvent := &nostr.Event{
 			Kind:      config.WrapperEventKind,
 			Content:   ciphertext,
-			CreatedAt: nostr.Now(),
-			PubKey:    pubkey,
+			CreatedAt: fuzzedNow(fuzz),
+			PubKey:    layer.pubkey,
 			Tags: nostr.Tags{
 				// Add "p" tag with destination Renoter's pubkey for routing
 				{"p", renoterPubkey},
 			},
 		}
 
+		// Seal payment proofs, Cashu tokens, the idempotency key and delays to this Renoter; the previous hop sees this layer's tags
+		for _, tag := range sealed[renoterPubkey] {
+			value, err := sealtag.Seal(tag[1:], layer.conversationKey)
+			if err != nil {
+				logging.Error("client.wrapper.WrapEvent: failed to seal %s tag for renoter %d: %v", tag[0], i, err)
+				return nil, nil, err
+			}
+			wrapperEvent.Tags = append(wrapperEvent.Tags, nostr.Tag{tag[0], value})
+		}
+
 		logging.DebugMethod("client.wrapper", "WrapEvent", "Created wrapper event structure (layer %d)", i)
 
 		// Mine proof-of-work for 29000 wrapper events before signing
-		// This adds spam protection by requiring computational work
-		logging.DebugMethod("client.wrapper", "WrapEvent", "Mining PoW for 29000 wrapper event (difficulty %d, layer %d)", config.PoWDifficulty, i)
-		nonceTag, err := nip13.DoWork(ctx, *wrapperEvent, config.PoWDifficulty)
-		if err != nil {
-			logging.Error("client.wrapper.WrapEvent: failed to mine PoW for wrapper event at layer %d: %v", i, err)
-			return nil, fmt.Errorf("failed to mine PoW for wrapper event: %w", err)
-		}
-		// Add the nonce tag returned by DoWork
-		if wrapperEvent.Tags == nil {
-			wrapperEvent.Tags = nostr.Tags{}
-		}
-		wrapperEvent.Tags = append(wrapperEvent.Tags, nonceTag)
-		logging.DebugMethod("client.wrapper", "WrapEvent", "Mined PoW for wrapper event (layer %d)", i)
-
-		// Compute ID and sign the wrapper event (ID computation includes nonce tags)
-		wrapperEvent.ID = wrapperEvent.GetID()
-		if !wrapperEvent.CheckID() {
-			logging.Error("client.wrapper.WrapEvent: wrapper event ID %s failed CheckID validation (layer %d)", wrapperEvent.ID, i)
-			return nil, fmt.Errorf("invalid wrapper event ID at layer %d", i)
+		// This adds spam protection by requiring computational work; a Cashu token replaces it
+		if layer.nonce != nil {
+			// The stamp was mined on this layer's skeleton, so the layer keeps its date
+			wrapperEvent.CreatedAt = layer.createdAt
+			wrapperEvent.Tags = append(wrapperEvent.Tags, layer.nonce)
+			logging.DebugMethod("client.wrapper", "WrapEvent", "Using a pre-mined stamp (layer %d)", i)
+		} else if powDifficulty > 0 && sealed[renoterPubkey].Find(cashuTag) == nil {
+			logging.DebugMethod("client.wrapper", "WrapEvent", "Mining PoW for 29000 wrapper event (difficulty %d, layer %d)", powDifficulty, i)
+			nonceTag, err := miner.Mine(ctx, *wrapperEvent, powDifficulty)
+			if err != nil {
+				logging.Error("client.wrapper.WrapEvent: failed to mine PoW for wrapper event at layer %d: %v", i, err)
+				return nil, nil, fmt.Errorf("failed to mine PoW for wrapper event: %w", err)
+			}
+			// Add the nonce tag returned by the miner
+			wrapperEvent.Tags = append(wrapperEvent.Tags, nonceTag)
+			logging.DebugMethod("client.wrapper", "WrapEvent", "Mined PoW for wrapper event (layer %d)", i)
 		}
 
-		// Sign the wrapper event
-		err = wrapperEvent.Sign(sk)
+		// Sign the wrapper event; Sign computes the ID (including nonce tags) from the same serialization
+		err = wrapperEvent.Sign(layer.sk)
 		if err != nil {
 			logging.Error("client.wrapper.WrapEvent: failed to sign wrapper event at layer %d: %v", i, err)
-			return nil, fmt.Errorf("failed to sign wrapper event: %w", err)
+			return nil, nil, fmt.Errorf("failed to sign wrapper event: %w", err)
 		}
 		logging.DebugMethod("client.wrapper", "WrapEvent", "Signed wrapper event, ID: %s (layer %d)", wrapperEvent.ID, i)
 
@@ -198,56 +439,47 @@ func WrapEvent(ctx context.Context, originalEvent *nostr.Event, renterPath [][]b
 		logging.DebugMethod("client.wrapper", "WrapEvent", "Completed wrapping layer %d, proceeding to next layer", i)
 	}
 
-	// After creating all 29000 layers, check if the outermost 29000 exceeds 8KB
-	// before padding. We pad it to exactly 8KB and wrap it in a 29001 container.
-	outermost29000JSON, err := json.Marshal(currentEvent)
-	if err != nil {
-		logging.Error("client.wrapper.WrapEvent: failed to serialize outermost 29000 event for size check: %v", err)
-		return nil, fmt.Errorf("failed to serialize outermost 29000 event: %w", err)
-	}
-	outermost29000Size := len(outermost29000JSON)
-
-	if outermost29000Size > config.StandardizedSize {
-		logging.Error("client.wrapper.WrapEvent: outermost 29000 event size %d bytes exceeds maximum %d bytes", outermost29000Size, config.StandardizedSize)
-		return nil, fmt.Errorf("event too large: outermost 29000 event size %d bytes exceeds maximum %d bytes", outermost29000Size, config.StandardizedSize)
-	}
+	return currentEvent, keys, nil
+}
 
-	logging.DebugMethod("client.wrapper", "WrapEvent", "Padding outermost 29000 event to %d bytes (current size: %d)", config.StandardizedSize, outermost29000Size)
-	padded29000, err := padEventToExactSize(currentEvent, config.StandardizedSize)
+// buildStandardizedContainer pads the outermost 29000 event to exactly standardizedSize,
+// encrypts it for the first Renoter, and wraps it in a signed 29001 container.
+// If powDifficulty is positive the container itself is mined with miner, for relays that require PoW.
+// trailers are appended to the container's tags. The container is backdated by up to fuzz.
+func buildStandardizedContainer(ctx context.Context, outermost29000 *nostr.Event, firstRenoterPubkey string, standardizedSize int, powDifficulty int, miner PoWMiner, trailers nostr.Tags, fuzz time.Duration) (*nostr.Event, error) {
+	logging.DebugMethod("client.wrapper", "buildStandardizedContainer", "Padding outermost 29000 event to %d bytes", standardizedSize)
+	padded29000, err := padding.PadEventToExactSize(outermost29000, standardizedSize)
 	if err != nil {
-		logging.Error("client.wrapper.WrapEvent: failed to pad outermost 29000 event: %v", err)
+		logging.Error("client.wrapper.buildStandardizedContainer: failed to pad outermost 29000 event: %v", err)
 		return nil, fmt.Errorf("failed to pad outermost 29000 event: %w", err)
 	}
 
-	// Get first Renoter's pubkey for addressing the 29001 container
-	firstRenoterPubkeyBytes := renterPath[0]
-	firstRenoterPubkey := hex.EncodeToString(firstRenoterPubkeyBytes)
-
-	// Serialize the padded 29000 for encryption
-	padded29000JSON, err := json.Marshal(padded29000)
-	if err != nil {
-		logging.Error("client.wrapper.WrapEvent: failed to serialize padded 29000 event: %v", err)
-		return nil, fmt.Errorf("failed to serialize padded 29000 event: %w", err)
-	}
-
 	// Generate random key for the 29001 container
 	sk29001 := nostr.GeneratePrivateKey()
 	pubkey29001, err := nostr.GetPublicKey(sk29001)
 	if err != nil {
-		logging.Error("client.wrapper.WrapEvent: failed to get public key for 29001: %v", err)
+		logging.Error("client.wrapper.buildStandardizedContainer: failed to get public key for 29001: %v", err)
 		return nil, fmt.Errorf("failed to get public key: %w", err)
 	}
 
 	// Encrypt the padded 29000 for the first Renoter
 	conversationKey29001, err := nip44.GenerateConversationKey(firstRenoterPubkey, sk29001)
 	if err != nil {
-		logging.Error("client.wrapper.WrapEvent: failed to generate conversation key for 29001: %v", err)
+		logging.Error("client.wrapper.buildStandardizedContainer: failed to generate conversation key for 29001: %v", err)
 		return nil, fmt.Errorf("failed to generate conversation key: %w", err)
 	}
 
-	ciphertext29001, err := nip44.Encrypt(string(padded29000JSON), conversationKey29001)
+	// Serialize the padded 29000 for encryption
+	padded29000JSON, err := marshalEventPooled(padded29000)
+	if err != nil {
+		logging.Error("client.wrapper.buildStandardizedContainer: failed to serialize padded 29000 event: %v", err)
+		return nil, fmt.Errorf("failed to serialize padded 29000 event: %w", err)
+	}
+
+	ciphertext29001, err := nip44.Encrypt(string(*padded29000JSON), conversationKey29001)
+	releaseJSONBuffer(padded29000JSON)
 	if err != nil {
-		logging.Error("client.wrapper.WrapEvent: failed to encrypt for 29001: %v", err)
+		logging.Error("client.wrapper.buildStandardizedContainer: failed to encrypt for 29001: %v", err)
 		return nil, fmt.Errorf("failed to encrypt for 29001: %w", err)
 	}
 
@@ -255,27 +487,31 @@ func WrapEvent(ctx context.Context, originalEvent *nostr.Event, renterPath [][]b
 	standardizedEvent := &nostr.Event{
 		Kind:      config.StandardizedWrapperKind,
 		Content:   ciphertext29001,
-		CreatedAt: nostr.Now(),
+		CreatedAt: fuzzedNow(fuzz),
 		PubKey:    pubkey29001,
-		Tags: nostr.Tags{
+		Tags: append(nostr.Tags{
 			// Add "p" tag with first Renoter's pubkey for routing
 			{"p", firstRenoterPubkey},
-		},
+		}, trailers...),
 	}
 
-	// Compute ID and sign the 29001 event
-	standardizedEvent.ID = standardizedEvent.GetID()
-	if !standardizedEvent.CheckID() {
-		logging.Error("client.wrapper.WrapEvent: 29001 event ID %s failed CheckID validation", standardizedEvent.ID)
-		return nil, fmt.Errorf("invalid 29001 event ID")
+	// Optionally mine the container for server relays that require PoW on incoming events
+	if powDifficulty > 0 {
+		logging.DebugMethod("client.wrapper", "buildStandardizedContainer", "Mining PoW for 29001 container (difficulty %d)", powDifficulty)
+		nonceTag, err := miner.Mine(ctx, *standardizedEvent, powDifficulty)
+		if err != nil {
+			logging.Error("client.wrapper.buildStandardizedContainer: failed to mine PoW for 29001 event: %v", err)
+			return nil, fmt.Errorf("failed to mine PoW for 29001 event: %w", err)
+		}
+		standardizedEvent.Tags = append(standardizedEvent.Tags, nonceTag)
 	}
 
+	// Sign the 29001 event; Sign computes the ID from the same serialization
 	err = standardizedEvent.Sign(sk29001)
 	if err != nil {
-		logging.Error("client.wrapper.WrapEvent: failed to sign 29001 event: %v", err)
+		logging.Error("client.wrapper.buildStandardizedContainer: failed to sign 29001 event: %v", err)
 		return nil, fmt.Errorf("failed to sign 29001 event: %w", err)
 	}
 
-	logging.Info("client.wrapper.WrapEvent: Successfully wrapped event through %d Renoter layers, created 29001 container, ID: %s", len(renterPath), standardizedEvent.ID)
 	return standardizedEvent, nil
 }
@@ -4,11 +4,16 @@ import (
 	"context"
 	"encoding/hex"
 	"encoding/json"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/girino/renoter/internal/config"
+	"github.com/girino/renoter/internal/padding"
 	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip13"
 	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/nbd-wtf/go-nostr/nip44"
 )
 
 func TestWrapEvent(t *testing.T) {
@@ -39,14 +44,14 @@ func TestWrapEvent(t *testing.T) {
 	tests := []struct {
 		name       string
 		event      *nostr.Event
-		path       [][]byte
+		path       Path
 		wantErr    bool
 		wantLayers int
 	}{
 		{
 			name:       "wrap with single Renoter",
 			event:      testEvent,
-			path:       [][]byte{path[0]},
+			path:       Path{path[0]},
 			wantErr:    false,
 			wantLayers: 1,
 		},
@@ -60,14 +65,14 @@ func TestWrapEvent(t *testing.T) {
 		{
 			name:    "empty path",
 			event:   testEvent,
-			path:    [][]byte{},
+			path:    Path{},
 			wantErr: true,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			wrapped, err := WrapEvent(context.Background(), tt.event, tt.path)
+			wrapped, err := WrapEvent(context.Background(), tt.event, tt.path, DefaultWrapOptions())
 			if (err != nil) != tt.wantErr {
 				t.Errorf("WrapEvent() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -122,7 +127,7 @@ func TestWrapEvent_ReverseOrder(t *testing.T) {
 
 	path, _ := ValidatePath([]string{npub1, npub2, npub3})
 
-	wrapped, err := WrapEvent(context.Background(), testEvent, path)
+	wrapped, err := WrapEvent(context.Background(), testEvent, path, DefaultWrapOptions())
 	if err != nil {
 		t.Fatalf("WrapEvent() error = %v", err)
 	}
@@ -133,115 +138,322 @@ func TestWrapEvent_ReverseOrder(t *testing.T) {
 		t.Error("Wrapper event should have 'p' tag")
 	}
 
-	firstPubkey := hex.EncodeToString(path[0])
+	firstPubkey := path[0].Key()
 	if wrapped.Tags[0][1] != firstPubkey {
 		t.Errorf("Wrapper 'p' tag should contain first Renoter's pubkey")
 	}
 }
 
-func TestPadEventToExactSize(t *testing.T) {
+func TestWrapEvent_LargeEvent(t *testing.T) {
+	// Test wrapping an event that will produce a large 29000 wrapper
+	// We'll create an event that when wrapped will be close to the size limit
+	sk1 := nostr.GeneratePrivateKey()
+	pk1, _ := nostr.GetPublicKey(sk1)
+	npub1, _ := nip19.EncodePublicKey(pk1)
+
+	path, _ := ValidatePath([]string{npub1})
+
+	// Create event with content that will be close to size limit when wrapped
+	largeContent := make([]byte, 30*1024) // 30KB
+	for i := range largeContent {
+		largeContent[i] = 'A'
+	}
+
 	event := &nostr.Event{
 		Kind:      1,
-		Content:   "Small content",
+		Content:   string(largeContent),
 		CreatedAt: nostr.Now(),
 		PubKey:    nostr.GeneratePrivateKey(),
 	}
 	event.Sign(event.PubKey)
 
-	targetSize := 1000 // 1KB target
-
-	// Test successful padding
-	padded, err := padEventToExactSize(event, targetSize)
+	// This should succeed or fail depending on the exact size
+	_, err := WrapEvent(context.Background(), event, path, DefaultWrapOptions())
+	// We just verify it doesn't panic - the actual result depends on PoW mining
 	if err != nil {
-		t.Fatalf("padEventToExactSize() error = %v", err)
+		// Verify error mentions size
+		errMsg := err.Error()
+		if !(containsString(errMsg, "too large") || containsString(errMsg, "exceeds")) {
+			t.Logf("WrapEvent with large event: %v (expected if exceeds size limit)", err)
+		}
 	}
+}
 
-	// Verify size
-	jsonBytes, _ := json.Marshal(padded)
-	if len(jsonBytes) != targetSize {
-		t.Errorf("Padded event size = %d, want %d", len(jsonBytes), targetSize)
+func containsString(s, substr string) bool {
+	for i := 0; i <= len(s)-len(substr); i++ {
+		if s[i:i+len(substr)] == substr {
+			return true
+		}
 	}
+	return false
+}
 
-	// Verify padding tag exists
-	foundPadding := false
-	for _, tag := range padded.Tags {
-		if len(tag) >= 1 && tag[0] == "padding" {
-			foundPadding = true
-			break
-		}
+func TestBuildStandardizedContainer_Golden(t *testing.T) {
+	// The server's re-wrap test checks the same vectors, so client and server
+	// containers can never drift apart in size.
+	vectors, err := padding.LoadGoldenVectors("../../internal/padding/testdata/golden.json")
+	if err != nil {
+		t.Fatalf("LoadGoldenVectors() error = %v", err)
 	}
-	if !foundPadding {
-		t.Error("Padded event should have padding tag")
+	if vectors.Container.StandardizedSize != config.StandardizedSize {
+		t.Fatalf("golden standardized size = %d, config.StandardizedSize = %d", vectors.Container.StandardizedSize, config.StandardizedSize)
+	}
+
+	renoterSk := nostr.GeneratePrivateKey()
+	renoterPk, _ := nostr.GetPublicKey(renoterSk)
+
+	for _, v := range vectors.Padding {
+		t.Run(v.Name, func(t *testing.T) {
+			inner, err := v.Event()
+			if err != nil {
+				t.Fatalf("Event() error = %v", err)
+			}
+
+			container, err := buildStandardizedContainer(context.Background(), inner, renoterPk, vectors.Container.StandardizedSize, 0, nil, nil, 0)
+			if err != nil {
+				t.Fatalf("buildStandardizedContainer() error = %v", err)
+			}
+
+			if len(container.Content) != vectors.Container.ContentLen {
+				t.Errorf("29001 content length = %d, want %d", len(container.Content), vectors.Container.ContentLen)
+			}
+			containerJSON, _ := json.Marshal(container)
+			if len(containerJSON) != vectors.Container.JSONSize {
+				t.Errorf("29001 JSON size = %d, want %d", len(containerJSON), vectors.Container.JSONSize)
+			}
+		})
 	}
 }
 
-func TestPadEventToExactSize_TooLarge(t *testing.T) {
-	// Create a large event that exceeds target even with padding tag overhead
-	largeContent := make([]byte, 5000)
-	for i := range largeContent {
-		largeContent[i] = 'A'
+func TestBuildStandardizedContainer_ContainerPoW(t *testing.T) {
+	renoterPk, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	inner := &nostr.Event{Kind: config.WrapperEventKind, Content: "inner", CreatedAt: nostr.Now(), Tags: nostr.Tags{{"p", renoterPk}}}
+	inner.Sign(nostr.GeneratePrivateKey())
+
+	container, err := buildStandardizedContainer(context.Background(), inner, renoterPk, config.StandardizedSize, 8, CPUMiner{}, nil, 0)
+	if err != nil {
+		t.Fatalf("buildStandardizedContainer() error = %v", err)
+	}
+	if got := nip13.Difficulty(container.ID); got < 8 {
+		t.Errorf("container PoW difficulty = %d, want at least 8", got)
+	}
+	if committed := nip13.CommittedDifficulty(container); committed != 8 {
+		t.Errorf("container committed difficulty = %d, want 8", committed)
 	}
+	if container.Tags[0][0] != "p" || container.Tags[0][1] != renoterPk {
+		t.Errorf("container must stay addressed to the first Renoter, tags = %v", container.Tags)
+	}
+	if valid, err := container.CheckSignature(); err != nil || !valid {
+		t.Errorf("container signature invalid: %v", err)
+	}
+}
+
+func TestWrapEvent_Limits(t *testing.T) {
+	sk1 := nostr.GeneratePrivateKey()
+	pk1, _ := nostr.GetPublicKey(sk1)
+	npub1, _ := nip19.EncodePublicKey(pk1)
+	path, _ := ValidatePath([]string{npub1})
 
 	event := &nostr.Event{
 		Kind:      1,
-		Content:   string(largeContent),
+		Content:   "Test event content",
 		CreatedAt: nostr.Now(),
 		PubKey:    nostr.GeneratePrivateKey(),
 	}
 	event.Sign(event.PubKey)
 
-	// Try to pad to a size smaller than the event
-	targetSize := 100
-	_, err := padEventToExactSize(event, targetSize)
+	tests := []struct {
+		name    string
+		limits  config.SizeLimits
+		wantErr string
+	}{
+		{
+			name:   "small standardized size",
+			limits: config.SizeLimits{StandardizedSize: 4096, MaxInnerEventSize: 4096 - config.PaddingTagOverhead},
+		},
+		{
+			name:    "inner limit below event size",
+			limits:  config.SizeLimits{StandardizedSize: 4096, MaxInnerEventSize: 200},
+			wantErr: "too large",
+		},
+		{
+			name:    "invalid limits",
+			limits:  config.SizeLimits{StandardizedSize: 4096, MaxInnerEventSize: 4096},
+			wantErr: "invalid size limits",
+		},
+	}
 
-	if err == nil {
-		t.Error("padEventToExactSize() should error when event is too large")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DefaultWrapOptions()
+			opts.Limits = tt.limits
+			wrapped, err := WrapEvent(context.Background(), event, path, opts)
+			if tt.wantErr != "" {
+				if err == nil || !containsString(err.Error(), tt.wantErr) {
+					t.Fatalf("WrapEvent() error = %v, want error containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("WrapEvent() error = %v", err)
+			}
+			if wrapped.Kind != config.StandardizedWrapperKind {
+				t.Errorf("Wrapped event kind = %d, want %d", wrapped.Kind, config.StandardizedWrapperKind)
+			}
+		})
+	}
+}
+
+func TestWrapEvent_WrapOptions(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	pk, _ := nostr.GetPublicKey(sk)
+	npub, _ := nip19.EncodePublicKey(pk)
+	path, _ := ValidatePath([]string{npub})
+
+	event := &nostr.Event{Kind: 1, Content: "small", CreatedAt: nostr.Now(), Tags: nostr.Tags{}}
+	event.Sign(nostr.GeneratePrivateKey())
+
+	opts := DefaultWrapOptions()
+	opts.Limits.Buckets = []int{4096}
+	opts.Bucket = config.StandardizedSize
+	opts.PoWDifficulty = 4
+	opts.TimestampFuzz = MaxTimestampFuzz
+	before := nostr.Now()
+	container, err := WrapEvent(context.Background(), event, path, opts)
+	if err != nil {
+		t.Fatalf("WrapEvent() error = %v", err)
 	}
-	if err != nil && !contains(err.Error(), "too large") {
-		t.Errorf("Error message should mention 'too large', got: %v", err)
+	after := nostr.Now()
+
+	// The event fits the 4096 bucket, but the forced bucket wins
+	if bucket := containerBucket(container, opts.Limits); bucket != config.StandardizedSize {
+		t.Errorf("container bucket = %d, want the forced %d", bucket, config.StandardizedSize)
+	}
+
+	conversationKey, _ := nip44.GenerateConversationKey(container.PubKey, sk)
+	plaintext, err := nip44.Decrypt(container.Content, conversationKey)
+	if err != nil {
+		t.Fatalf("Decrypt() error = %v", err)
+	}
+	var layer nostr.Event
+	if err := json.Unmarshal([]byte(plaintext), &layer); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	if nonce := layer.Tags.Find("nonce"); len(nonce) < 3 || nonce[2] != "4" || nip13.Difficulty(layer.ID) < 4 {
+		t.Errorf("layer nonce tag = %v, want PoW committed to difficulty 4", nonce)
+	}
+
+	earliest := before - nostr.Timestamp(MaxTimestampFuzz/time.Second)
+	for _, e := range []*nostr.Event{container, &layer} {
+		if e.CreatedAt < earliest || e.CreatedAt > after {
+			t.Errorf("kind %d created_at = %d, want within [%d, %d]", e.Kind, e.CreatedAt, earliest, after)
+		}
 	}
 }
 
-func TestWrapEvent_LargeEvent(t *testing.T) {
-	// Test wrapping an event that will produce a large 29000 wrapper
-	// We'll create an event that when wrapped will be close to the size limit
-	sk1 := nostr.GeneratePrivateKey()
-	pk1, _ := nostr.GetPublicKey(sk1)
-	npub1, _ := nip19.EncodePublicKey(pk1)
+func TestWrapOptions_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(*WrapOptions)
+		wantErr string
+	}{
+		{name: "defaults", modify: func(*WrapOptions) {}},
+		{name: "standardized bucket", modify: func(o *WrapOptions) { o.Bucket = config.StandardizedSize }},
+		{name: "negative PoW", modify: func(o *WrapOptions) { o.PoWDifficulty = -1 }, wantErr: "PoW difficulty"},
+		{name: "unknown bucket", modify: func(o *WrapOptions) { o.Bucket = 4096 }, wantErr: "size bucket"},
+		{name: "fuzz too large", modify: func(o *WrapOptions) { o.TimestampFuzz = MaxTimestampFuzz + time.Second }, wantErr: "timestamp fuzz"},
+	}
 
-	path, _ := ValidatePath([]string{npub1})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DefaultWrapOptions()
+			tt.modify(&opts)
+			err := opts.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate() error = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
 
-	// Create event with content that will be close to size limit when wrapped
-	largeContent := make([]byte, 30*1024) // 30KB
-	for i := range largeContent {
-		largeContent[i] = 'A'
+func TestMarshalEventPooled(t *testing.T) {
+	first := &nostr.Event{Kind: 1, Content: "first <event> & more", CreatedAt: nostr.Now(), Tags: nostr.Tags{}}
+	first.Sign(nostr.GeneratePrivateKey())
+	second := &nostr.Event{Kind: 1, Content: "second", CreatedAt: nostr.Now(), Tags: nostr.Tags{{"t", "x"}}}
+	second.Sign(nostr.GeneratePrivateKey())
+
+	for _, event := range []*nostr.Event{first, second, first} {
+		want, _ := event.MarshalJSON()
+		buf, err := marshalEventPooled(event)
+		if err != nil {
+			t.Fatalf("marshalEventPooled() error = %v", err)
+		}
+		if string(*buf) != string(want) {
+			t.Errorf("marshalEventPooled() = %s, want %s", *buf, want)
+		}
+		releaseJSONBuffer(buf)
 	}
+}
 
-	event := &nostr.Event{
-		Kind:      1,
-		Content:   string(largeContent),
-		CreatedAt: nostr.Now(),
-		PubKey:    nostr.GeneratePrivateKey(),
+func TestDeriveLayerKeys(t *testing.T) {
+	renoterSks := []string{nostr.GeneratePrivateKey(), nostr.GeneratePrivateKey(), nostr.GeneratePrivateKey()}
+	recipients := make([]string, len(renoterSks))
+	for i, sk := range renoterSks {
+		recipients[i], _ = nostr.GetPublicKey(sk)
 	}
-	event.Sign(event.PubKey)
 
-	// This should succeed or fail depending on the exact size
-	_, err := WrapEvent(context.Background(), event, path)
-	// We just verify it doesn't panic - the actual result depends on PoW mining
+	keys, err := deriveLayerKeys(recipients, nil)
 	if err != nil {
-		// Verify error mentions size
-		errMsg := err.Error()
-		if !(containsString(errMsg, "too large") || containsString(errMsg, "exceeds")) {
-			t.Logf("WrapEvent with large event: %v (expected if exceeds size limit)", err)
+		t.Fatalf("deriveLayerKeys() error = %v", err)
+	}
+	for i, layer := range keys {
+		// The Renoter must derive the same conversation key from the layer's public key
+		renoterKey, err := nip44.GenerateConversationKey(layer.pubkey, renoterSks[i])
+		if err != nil {
+			t.Fatalf("GenerateConversationKey() error = %v", err)
+		}
+		if renoterKey != layer.conversationKey {
+			t.Errorf("layer %d conversation key does not match the Renoter's", i)
 		}
 	}
+
+	if _, err := deriveLayerKeys([]string{"not-a-pubkey"}, nil); err == nil {
+		t.Error("deriveLayerKeys() should fail for an invalid recipient")
+	}
 }
 
-func containsString(s, substr string) bool {
-	for i := 0; i <= len(s)-len(substr); i++ {
-		if s[i:i+len(substr)] == substr {
-			return true
+// BenchmarkWrapEvent_ThreeHops measures the full wrapping hot path (layers and standardized
+// container) for a 3-hop path, excluding PoW mining.
+func BenchmarkWrapEvent_ThreeHops(b *testing.B) {
+	path := make(Path, 3)
+	for i := range path {
+		pk, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+		path[i].PubKey, _ = hex.DecodeString(pk)
+	}
+
+	event := &nostr.Event{
+		Kind:      1,
+		Content:   strings.Repeat("x", 2048),
+		CreatedAt: nostr.Now(),
+		Tags:      nostr.Tags{{"t", "renoter"}},
+	}
+	event.Sign(nostr.GeneratePrivateKey())
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		outermost, _, err := wrapLayers(context.Background(), event, path, 0, nil, nil, false, nil, 0)
+		if err != nil {
+			b.Fatalf("wrapLayers() error = %v", err)
+		}
+		if _, err := buildStandardizedContainer(context.Background(), outermost, path[0].Key(), config.StandardizedSize, 0, nil, nil, 0); err != nil {
+			b.Fatalf("buildStandardizedContainer() error = %v", err)
 		}
 	}
-	return false
 }
@@ -0,0 +1,198 @@
+// Package mobile lets Android and iOS Nostr apps send events through Renoters without running
+// the client's local relay. Its API only uses types gomobile can bind, with JSON for everything
+// else:
+//
+//	gomobile bind -target=android -o renoter.aar ./pkg/mobile
+//	gomobile bind -target=ios -o Renoter.xcframework ./pkg/mobile
+//
+// Apps sign their events as usual and hand them to WrapAndSend, which wraps, mines and publishes
+// them with the client library and returns the outcome.
+package mobile
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/girino/renoter/internal/config"
+	"github.com/girino/renoter/pkg/client"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// defaultTimeout is how long WrapAndSend waits for an event when the config sets no timeout.
+const defaultTimeout = 2 * time.Minute
+
+// closeTimeout is how long a replaced or closed dispatcher may finish the events in flight.
+const closeTimeout = 5 * time.Second
+
+// sendConfig is the JSON configuration of WrapAndSend.
+type sendConfig struct {
+	// Renoters to route through: npubs, nprofiles or hex pubkeys
+	Renoters []string `json:"renoters"`
+	// Relays the 29001 containers are published to
+	ServerRelays []string `json:"server_relays"`
+	// Renoters per path (0 = all of them)
+	Hops int `json:"hops,omitempty"`
+	// Delivery lane, config.LaneFast or config.LaneMixed ("" = fast)
+	Lane string `json:"lane,omitempty"`
+	// Check the Renoters' service descriptors before using them
+	CheckDescriptors bool `json:"check_descriptors,omitempty"`
+	// Send small events in the lite profile (needs CheckDescriptors)
+	Lite bool `json:"lite,omitempty"`
+	// Encode the event in every layer compactly (needs CheckDescriptors)
+	CompactLayers bool `json:"compact_layers,omitempty"`
+	// Seconds to wait for an event to be published (0 = 2 minutes)
+	TimeoutSeconds int `json:"timeout_seconds,omitempty"`
+}
+
+// options returns the client options of the config.
+func (c sendConfig) options() (client.Options, error) {
+	if len(c.ServerRelays) == 0 {
+		return client.Options{}, fmt.Errorf("at least one server relay is required")
+	}
+	if c.TimeoutSeconds < 0 {
+		return client.Options{}, fmt.Errorf("timeout must not be negative, got %d seconds", c.TimeoutSeconds)
+	}
+	opts := client.DefaultOptions()
+	// One event at a time: phones have few cores and little battery to spare
+	opts.MiningWorkers = 1
+	opts.PathPolicy.Hops = c.Hops
+	if c.Lane != "" {
+		opts.Lane = c.Lane
+	}
+	opts.CheckDescriptors = c.CheckDescriptors
+	opts.LiteProfile = c.Lite
+	opts.CompactLayers = c.CompactLayers
+	opts.ServerPool = serverPool
+	if err := opts.Validate(); err != nil {
+		return client.Options{}, err
+	}
+	return opts, nil
+}
+
+// timeout returns how long to wait for an event.
+func (c sendConfig) timeout() time.Duration {
+	if c.TimeoutSeconds == 0 {
+		return defaultTimeout
+	}
+	return time.Duration(c.TimeoutSeconds) * time.Second
+}
+
+// serverPool replaces the server relay connections when set, in tests.
+var serverPool client.Pool
+
+// session is the dispatcher kept between calls, so events sent with the same config reuse its
+// relay connections and descriptor checks.
+var session struct {
+	sync.Mutex
+	// Config the dispatcher was started with, as JSON
+	config     string
+	dispatcher *client.Dispatcher
+	cancel     context.CancelFunc
+}
+
+// WrapAndSend wraps the signed event in eventJSON for the Renoters in configJSON and publishes
+// it to the server relays, waiting until it has been published or has failed. configJSON is an
+// object with these fields:
+//
+//	renoters           npubs, nprofiles or hex pubkeys of the Renoters to route through
+//	server_relays      relays the containers are published to
+//	hops               Renoters per path (0 = all of them)
+//	lane               "fast" (default) or "mixed"
+//	check_descriptors  check the Renoters' service descriptors before using them
+//	lite               send small events in the lite profile (needs check_descriptors)
+//	compact_layers     encode the event compactly in every layer (needs check_descriptors)
+//	timeout_seconds    how long to wait for the event (0 = 2 minutes)
+//
+// The result is a client.PublishResult as JSON: the wrapped container's ID and the answer of
+// every server relay, or the reason the event was not published in its "error" field, in the
+// config.Rejection format. An error is only returned for malformed arguments or a config the
+// client cannot start with. The client started for a config is kept for the calls that follow
+// with the same config, until Close.
+func WrapAndSend(eventJSON, configJSON string) (string, error) {
+	var event nostr.Event
+	if err := json.Unmarshal([]byte(eventJSON), &event); err != nil {
+		return "", fmt.Errorf("%s: malformed event: %w", config.PrefixInvalid, err)
+	}
+	var cfg sendConfig
+	if err := json.Unmarshal([]byte(configJSON), &cfg); err != nil {
+		logging.Error("mobile.mobile.WrapAndSend: malformed config: %v", err)
+		return "", fmt.Errorf("malformed config: %w", err)
+	}
+	dispatcher, err := dispatcherFor(cfg)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.timeout())
+	defer cancel()
+	entry, err := dispatcher.Publish(ctx, &event)
+	if err != nil {
+		logging.Warn("mobile.mobile.WrapAndSend: event %s was not published: %v", event.ID, err)
+	}
+	result, err := json.Marshal(client.NewPublishResult(event.ID, entry, err))
+	if err != nil {
+		return "", fmt.Errorf("failed to encode result: %w", err)
+	}
+	return string(result), nil
+}
+
+// Close stops the client kept by WrapAndSend, giving the events in flight a few seconds to
+// finish. Apps call it when they go to the background for long; the next WrapAndSend starts a
+// new one.
+func Close() {
+	session.Lock()
+	defer session.Unlock()
+	closeSessionLocked()
+}
+
+// dispatcherFor returns the session's dispatcher if it was started with cfg, or else replaces it
+// with one started with cfg.
+func dispatcherFor(cfg sendConfig) (*client.Dispatcher, error) {
+	key, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode config: %w", err)
+	}
+	session.Lock()
+	defer session.Unlock()
+	if session.dispatcher != nil && session.config == string(key) {
+		return session.dispatcher, nil
+	}
+	closeSessionLocked()
+
+	path, err := client.ValidatePath(cfg.Renoters)
+	if err != nil {
+		return nil, fmt.Errorf("invalid renoters: %w", err)
+	}
+	opts, err := cfg.options()
+	if err != nil {
+		logging.Error("mobile.mobile.WrapAndSend: invalid config: %v", err)
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	dispatcher, err := client.StartDispatcher(ctx, path, cfg.ServerRelays, opts)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	session.config, session.dispatcher, session.cancel = string(key), dispatcher, cancel
+	logging.Info("mobile.mobile.WrapAndSend: Started client with %d Renoters and %d server relays", len(path), len(cfg.ServerRelays))
+	return dispatcher, nil
+}
+
+// closeSessionLocked stops the session's dispatcher, if any.
+func closeSessionLocked() {
+	if session.dispatcher == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+	defer cancel()
+	if err := session.dispatcher.Close(ctx); err != nil {
+		logging.Warn("mobile.mobile.Close: %v", err)
+	}
+	session.cancel()
+	session.config, session.dispatcher, session.cancel = "", nil, nil
+}
@@ -0,0 +1,150 @@
+package mobile
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/girino/renoter/internal/config"
+	"github.com/girino/renoter/pkg/client"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+// fakePool accepts every published event without any relay and finds nothing.
+type fakePool struct {
+	published chan nostr.Event
+}
+
+func (p *fakePool) SubscribeMany(ctx context.Context, urls []string, filter nostr.Filter, opts ...nostr.SubscriptionOption) chan nostr.RelayEvent {
+	return p.FetchMany(ctx, urls, filter, opts...)
+}
+
+func (p *fakePool) FetchMany(ctx context.Context, urls []string, filter nostr.Filter, opts ...nostr.SubscriptionOption) chan nostr.RelayEvent {
+	ch := make(chan nostr.RelayEvent)
+	close(ch)
+	return ch
+}
+
+func (p *fakePool) PublishMany(ctx context.Context, urls []string, evt nostr.Event) chan nostr.PublishResult {
+	p.published <- evt
+	ch := make(chan nostr.PublishResult, len(urls))
+	for _, url := range urls {
+		ch <- nostr.PublishResult{RelayURL: url}
+	}
+	close(ch)
+	return ch
+}
+
+func testConfig(t *testing.T, fields string) string {
+	t.Helper()
+	pk, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	npub, err := nip19.EncodePublicKey(pk)
+	if err != nil {
+		t.Fatalf("EncodePublicKey() error = %v", err)
+	}
+	return `{"renoters":["` + npub + `"],"server_relays":["wss://unreachable.invalid"]` + fields + `}`
+}
+
+func testEvent(t *testing.T) string {
+	t.Helper()
+	event := nostr.Event{Kind: 1, Content: "sent from a phone", CreatedAt: nostr.Now(), Tags: nostr.Tags{}}
+	event.Sign(nostr.GeneratePrivateKey())
+	return event.String()
+}
+
+func TestWrapAndSend(t *testing.T) {
+	pool := &fakePool{published: make(chan nostr.Event, 1)}
+	serverPool = pool
+	defer func() { serverPool = nil }()
+	defer Close()
+
+	eventJSON := testEvent(t)
+	resultJSON, err := WrapAndSend(eventJSON, testConfig(t, ""))
+	if err != nil {
+		t.Fatalf("WrapAndSend() error = %v", err)
+	}
+	var result client.PublishResult
+	if err := json.Unmarshal([]byte(resultJSON), &result); err != nil {
+		t.Fatalf("result %q is not a PublishResult: %v", resultJSON, err)
+	}
+	published := <-pool.published
+	if result.Error != "" || result.Accepted != 1 || result.WrappedID != published.ID {
+		t.Errorf("result = %+v, want the container %s accepted by the relay", result, published.ID)
+	}
+	if published.Kind != config.StandardizedWrapperKind {
+		t.Errorf("published kind %d, want the 29001 container", published.Kind)
+	}
+}
+
+func TestWrapAndSend_RejectedEvent(t *testing.T) {
+	serverPool = &fakePool{published: make(chan nostr.Event, 1)}
+	defer func() { serverPool = nil }()
+	defer Close()
+
+	// A tampered event is refused before anything is mined
+	var event nostr.Event
+	json.Unmarshal([]byte(testEvent(t)), &event)
+	event.Content = "tampered"
+	resultJSON, err := WrapAndSend(event.String(), testConfig(t, ""))
+	if err != nil {
+		t.Fatalf("WrapAndSend() error = %v, want the rejection in the result", err)
+	}
+	var result client.PublishResult
+	json.Unmarshal([]byte(resultJSON), &result)
+	if !strings.HasPrefix(result.Error, config.PrefixInvalid) {
+		t.Errorf("result error = %q, want an %s rejection", result.Error, config.PrefixInvalid)
+	}
+}
+
+func TestWrapAndSend_InvalidArguments(t *testing.T) {
+	serverPool = &fakePool{published: make(chan nostr.Event, 1)}
+	defer func() { serverPool = nil }()
+	defer Close()
+
+	tests := []struct {
+		name   string
+		event  string
+		config string
+	}{
+		{"malformed event", "{", testConfig(t, "")},
+		{"malformed config", testEvent(t), "{"},
+		{"no renoters", testEvent(t), `{"server_relays":["wss://relay.example.com"]}`},
+		{"no server relays", testEvent(t), `{"renoters":["` + strings.Repeat("ab", 32) + `"]}`},
+		{"unknown lane", testEvent(t), testConfig(t, `,"lane":"slow"`)},
+		{"negative timeout", testEvent(t), testConfig(t, `,"timeout_seconds":-1`)},
+		{"lite without descriptors", testEvent(t), testConfig(t, `,"lite":true`)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := WrapAndSend(tt.event, tt.config); err == nil {
+				t.Error("WrapAndSend() succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestDispatcherFor_ReusesSession(t *testing.T) {
+	serverPool = &fakePool{published: make(chan nostr.Event, 1)}
+	defer func() { serverPool = nil }()
+	defer Close()
+
+	var cfg, other sendConfig
+	json.Unmarshal([]byte(testConfig(t, "")), &cfg)
+	json.Unmarshal([]byte(testConfig(t, "")), &other)
+	first, err := dispatcherFor(cfg)
+	if err != nil {
+		t.Fatalf("dispatcherFor() error = %v", err)
+	}
+	if again, _ := dispatcherFor(cfg); again != first {
+		t.Error("dispatcherFor() started a new dispatcher for the same config")
+	}
+	if replaced, _ := dispatcherFor(other); replaced == first {
+		t.Error("dispatcherFor() kept the dispatcher of another config")
+	}
+	Close()
+	if session.dispatcher != nil {
+		t.Error("Close() kept the dispatcher")
+	}
+}
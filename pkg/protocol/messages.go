@@ -0,0 +1,33 @@
+package protocol
+
+// Outcomes a hop acknowledgement reports.
+const (
+	// The next layer was re-wrapped and published for the next Renoter
+	AckForwarded = "forwarded"
+	// The final event was published
+	AckPublished = "published"
+	// The layer was rejected; the sender may resend the event, e.g. over another path
+	AckRejected = "rejected"
+)
+
+// HopAck is the plaintext of a HopAckKind event.
+type HopAck struct {
+	// AckForwarded, AckPublished or AckRejected
+	Outcome string `json:"outcome"`
+	// Why the layer was rejected, a category revealing nothing about it such as the reason of a
+	// rejection, "quota" or "error" (empty otherwise)
+	Reason string `json:"reason,omitempty"`
+}
+
+// ErrorNotice is the plaintext of an ErrorNoticeKind event: which Renoter rejected the event and
+// why. A sender receiving one knows the event was refused, not lost by a relay.
+type ErrorNotice struct {
+	// Hex pubkey of the Renoter
+	Renoter string `json:"renoter"`
+	// Pipeline stage that rejected the layer, e.g. "admission" or "policy" (empty if it was
+	// rejected when forwarded after a mixing delay)
+	Stage string `json:"stage,omitempty"`
+	// Why, a category revealing nothing about the event such as the reason of a rejection,
+	// "quota", "malformed" or "error"
+	Reason string `json:"reason"`
+}
@@ -0,0 +1,80 @@
+// Package protocol holds the wire-level constants of the Renoter protocol (event kinds, tag
+// names, standardized sizes, PoW difficulties and the protocol versions that introduced them)
+// and small helpers validating events against them, for implementations outside this module.
+//
+// Everything here is part of the protocol: a value only changes together with Version, and the
+// constants introduced by a version say so. Settings of this implementation that other
+// implementations need not share stay in its own packages.
+package protocol
+
+// Version is the version of the wrapping protocol, announced in service descriptors and
+// capability responses. Every version still reads the layers of the versions before it.
+const Version = 4
+
+// MinVersion is the oldest protocol version this implementation can route through.
+const MinVersion = 1
+
+// CompactLayersVersion is the first protocol version whose Renoters read layers holding a
+// compact (binary) event instead of JSON.
+const CompactLayersVersion = 2
+
+// StampedPoWVersion is the first protocol version whose Renoters admit layers carrying a stamp:
+// PoW mined ahead of time on the layer's skeleton instead of its ID.
+const StampedPoWVersion = 3
+
+// HopAcksVersion is the first protocol version whose Renoters accept layers carrying an AckTag.
+const HopAcksVersion = 4
+
+// ErrorNoticesVersion is the first protocol version whose Renoters accept layers carrying a
+// ReplyTag.
+const ErrorNoticesVersion = 4
+
+// WrapperEventKind is the ephemeral event kind used for inner wrapper events (routing layer).
+// Ephemeral events (20000-29999) are non-persistent and won't be stored by relays.
+const WrapperEventKind = 29000
+
+// StandardizedWrapperKind is the ephemeral event kind used for outer standardized size containers.
+// These events are always padded to one of the agreed sizes to hide message size metadata.
+const StandardizedWrapperKind = 29001
+
+// TrailerEventKind is the ephemeral event kind the exit Renoter publishes the timing trailers of
+// an event in, signed by a throwaway key and p-tagged with the report pubkey.
+const TrailerEventKind = 29002
+
+// HandshakeResponseKind is the ephemeral event kind a Renoter answers a capability probe with,
+// signed by a throwaway key and p-tagged with the probe layer's pubkey. Its content is the
+// Renoter's service descriptor tags as JSON, encrypted with the layer's NIP-44 conversation key.
+const HandshakeResponseKind = 29003
+
+// HopAckKind is the ephemeral event kind a Renoter acknowledges a layer with once it forwarded it,
+// published the final event inside it or rejected it. It is signed by a throwaway key and p-tagged
+// with the layer's pubkey, an ephemeral key of the sender; its content is a HopAck as JSON,
+// encrypted with the layer's NIP-44 conversation key, so only the sender can read it.
+const HopAckKind = 29004
+
+// ErrorNoticeKind is the ephemeral event kind a Renoter reports a rejected layer in, signed by a
+// throwaway key and p-tagged with the reply pubkey. Its content is an ErrorNotice as JSON,
+// encrypted to the reply pubkey with the throwaway key, so only the sender can read it.
+const ErrorNoticeKind = 29005
+
+// ServiceDescriptorKind is the parameterized replaceable event kind a Renoter publishes to
+// announce what it accepts (kinds, standardized size, PoW, fee policy and contact).
+const ServiceDescriptorKind = 30290
+
+// HeartbeatKind is the replaceable event kind a Renoter publishes its liveness heartbeats as,
+// much smaller and more frequent than its service descriptor. Peers may republish them as-is.
+const HeartbeatKind = 10290
+
+// PoWDifficulty is the proof-of-work difficulty for 29000 wrapper events (number of leading zero bits required).
+// Default is 16, which requires ~65536 attempts on average. This can be adjusted to balance spam prevention vs CPU cost.
+const PoWDifficulty = 16
+
+// LitePoWDifficulty is the PoW difficulty 29000 wrappers of the lite profile are mined to.
+const LitePoWDifficulty = 12
+
+// IsWrapperKind reports whether kind is one of the wrapper kinds Renoters route: a 29000 layer
+// or a 29001 container. Everything else is a final event, or a reply such as a hop
+// acknowledgement, and is never unwrapped.
+func IsWrapperKind(kind int) bool {
+	return kind == WrapperEventKind || kind == StandardizedWrapperKind
+}
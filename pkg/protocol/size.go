@@ -0,0 +1,259 @@
+package protocol
+
+import (
+	"encoding/base64"
+	"fmt"
+	"math"
+	"math/bits"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/girino/renoter/internal/compact"
+	"github.com/girino/renoter/internal/padding"
+	"github.com/girino/renoter/internal/sealtag"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// StandardizedSize is the target size for standardized wrapper events (32KB).
+const StandardizedSize = 32 * 1024 // 32768 bytes
+
+// LiteSize is the standardized size of the lite profile, for events small enough to spare
+// metered connections the full StandardizedSize.
+const LiteSize = 4 * 1024
+
+// PaddingTagOverhead is the worst-case number of bytes an empty ["padding",""] tag adds to
+// a serialized event that already has tags (the tag itself plus a separating comma).
+const PaddingTagOverhead = 15
+
+// MaxStandardizedSize is the largest standardized size NIP-44 can encrypt in a single payload.
+const MaxStandardizedSize = 65535
+
+// SizeLimits ties the outer standardized container size to the largest inner event accepted.
+//
+// The outermost 29000 is serialized, checked against MaxInnerEventSize, then padded to exactly
+// StandardizedSize before being encrypted into the 29001 container. Padding itself costs
+// PaddingTagOverhead bytes, so the relation MaxInnerEventSize + PaddingTagOverhead <= StandardizedSize
+// must always hold. Client and servers on the same path must agree on StandardizedSize.
+//
+// Buckets adds smaller sizes a padded 29000 may have instead. The client pads the outermost 29000
+// to the smallest bucket it fits in, and every Renoter re-wraps the next layer to the bucket of the
+// container it received, so a message keeps its size along the whole path. Client and servers on
+// the same path must agree on the buckets too.
+type SizeLimits struct {
+	// Exact serialized size of every padded 29000 (the 29001 plaintext), and the largest bucket
+	StandardizedSize int
+	// Largest serialized outermost 29000 accepted before padding
+	MaxInnerEventSize int
+	// Smaller sizes a padded 29000 may have instead of StandardizedSize (empty = one size only)
+	Buckets []int
+}
+
+// DefaultSizeLimits returns the protocol default limits: a 32KB standardized size with the
+// largest inner event that still fits once the padding tag is added.
+func DefaultSizeLimits() SizeLimits {
+	return SizeLimits{
+		StandardizedSize:  StandardizedSize,
+		MaxInnerEventSize: StandardizedSize - PaddingTagOverhead,
+	}
+}
+
+// LiteSizeLimits returns the limits of the lite profile: containers padded to LiteSize, with the
+// largest inner event that still fits once the padding tag is added.
+func LiteSizeLimits() SizeLimits {
+	return SizeLimits{
+		StandardizedSize:  LiteSize,
+		MaxInnerEventSize: LiteSize - PaddingTagOverhead,
+	}
+}
+
+// Validate checks that the limits are usable and consistent with each other.
+func (l SizeLimits) Validate() error {
+	if l.StandardizedSize <= PaddingTagOverhead {
+		return fmt.Errorf("standardized size %d must be larger than the padding tag overhead (%d bytes)", l.StandardizedSize, PaddingTagOverhead)
+	}
+	if l.StandardizedSize > MaxStandardizedSize {
+		return fmt.Errorf("standardized size %d exceeds the NIP-44 maximum of %d bytes", l.StandardizedSize, MaxStandardizedSize)
+	}
+	if l.MaxInnerEventSize <= 0 {
+		return fmt.Errorf("max inner event size must be positive, got %d", l.MaxInnerEventSize)
+	}
+	if l.MaxInnerEventSize+PaddingTagOverhead > l.StandardizedSize {
+		return fmt.Errorf("max inner event size %d plus padding tag overhead (%d bytes) exceeds standardized size %d", l.MaxInnerEventSize, PaddingTagOverhead, l.StandardizedSize)
+	}
+	for _, bucket := range l.Buckets {
+		if bucket <= PaddingTagOverhead || bucket >= l.StandardizedSize {
+			return fmt.Errorf("size bucket %d must be larger than the padding tag overhead (%d bytes) and smaller than the standardized size %d", bucket, PaddingTagOverhead, l.StandardizedSize)
+		}
+	}
+	return nil
+}
+
+// Sizes returns every size a padded 29000 may have in ascending order, StandardizedSize last.
+func (l SizeLimits) Sizes() []int {
+	sizes := append(slices.Clone(l.Buckets), l.StandardizedSize)
+	slices.Sort(sizes)
+	return slices.Compact(sizes)
+}
+
+// Bucket returns the smallest size a 29000 of size bytes can be padded to, or 0 if it is
+// larger than StandardizedSize.
+func (l SizeLimits) Bucket(size int) int {
+	for _, bucket := range l.Sizes() {
+		if size <= bucket {
+			return bucket
+		}
+	}
+	return 0
+}
+
+// nip44FramingSize is what NIP-44 v2 adds around a padded plaintext: version byte, 32-byte
+// nonce, 2-byte length prefix and 32-byte MAC.
+const nip44FramingSize = 1 + 32 + 2 + 32
+
+// NIP44PayloadSize returns the exact length of the raw NIP-44 v2 payload of a plaintext of n
+// bytes, before base64: framing and padded plaintext. Compact layers hold payloads raw.
+func NIP44PayloadSize(n int) int {
+	padded := 32
+	if n > 32 {
+		nextPower := 1 << bits.Len(uint(n-1))
+		chunk := max(32, nextPower/8)
+		padded = chunk * ((n-1)/chunk + 1)
+	}
+	return nip44FramingSize + padded
+}
+
+// NIP44CiphertextSize returns the exact length of the base64 NIP-44 v2 payload of a plaintext of
+// n bytes: version byte, nonce, length prefix, padded plaintext and MAC.
+func NIP44CiphertextSize(n int) int {
+	return base64.StdEncoding.EncodedLen(NIP44PayloadSize(n))
+}
+
+// ContainerContentSize returns the exact length of the content of a 29001 container holding a
+// padded 29000 of bucket bytes: the base64 NIP-44 v2 payload of that many bytes.
+func ContainerContentSize(bucket int) int {
+	return NIP44CiphertextSize(bucket)
+}
+
+// TagOverhead is the size an optional tag adds to a 29000 layer, in either encoding of the layer.
+type TagOverhead struct {
+	// Bytes added to the JSON of the layer, separating comma included
+	JSON int
+	// Bytes added to the compact encoding of the layer
+	Compact int
+}
+
+// SealedTagOverhead returns the overhead of a tag named name whose values are sealed to the
+// layer's Renoter, for values no longer than the given ones.
+func SealedTagOverhead(name string, values ...string) TagOverhead {
+	template := wrapperTemplate()
+	tag, _ := padding.TagSize(&template, nostr.Tag{name, ""})
+	plaintext, _ := sealtag.Plaintext(values)
+	sealed := NIP44CiphertextSize(len(plaintext))
+	return TagOverhead{
+		JSON: tag + sealed,
+		// Value count, then the name and the sealed value with their length prefixes
+		Compact: 1 + compact.LengthPrefix(len(name)) + len(name) + compact.LengthPrefix(sealed) + sealed,
+	}
+}
+
+// IdempotencyOverhead is the overhead of the sealed IdempotencyTag of the innermost 29000.
+var IdempotencyOverhead = SealedTagOverhead(IdempotencyTag, strings.Repeat("0", 64))
+
+// LayerFormat is what the size of the layers of a wrapped event depends on besides the event.
+// The zero value is the plainest format: JSON layers without optional tags.
+type LayerFormat struct {
+	// Layers hold compact events instead of JSON; the outermost 29000 is JSON either way
+	Compact bool
+	// Overheads of the optional tags every layer carries, such as a sealed DelayTag
+	Tags []TagOverhead
+	// Overheads of the tags only some layers carry, such as a payment proof for a paid Renoter,
+	// by layer from the innermost
+	LayerTags [][]TagOverhead
+}
+
+// wrapperOverhead and compactWrapperOverhead are the sizes of a 29000 with empty content and the
+// longest possible fields, as JSON and compact, so that they plus the content bound any real one.
+var wrapperOverhead, compactWrapperOverhead = func() (int, int) {
+	template := wrapperTemplate()
+	encoded, _ := padding.Marshal(&template)
+	compacted, _ := compact.Encode(&template)
+	return len(encoded), len(compacted)
+}()
+
+// wrapperTemplate is a 29000 with empty content and the longest possible fields: a routing tag,
+// and a nonce tag with the longest nonce (DoWork counts nonces as a uint64, so MaxUint64 in
+// decimal).
+func wrapperTemplate() nostr.Event {
+	return nostr.Event{
+		ID:        strings.Repeat("0", 64),
+		PubKey:    strings.Repeat("0", 64),
+		CreatedAt: nostr.Timestamp(9999999999),
+		Kind:      WrapperEventKind,
+		Tags: nostr.Tags{
+			{RoutingTag, strings.Repeat("0", 64)},
+			{"nonce", strconv.FormatUint(math.MaxUint64, 10), strconv.Itoa(PoWDifficulty)},
+		},
+		Sig: strings.Repeat("0", 128),
+	}
+}
+
+// WrappedSize returns an upper bound on the serialized size of the outermost 29000 of an original
+// event of originalSize bytes wrapped for pathLength Renoters in layers of format. With compact
+// layers originalSize is the compact size of the event, and every layer but the outermost is
+// measured compact, as that is how the layer around it holds it. Base64 ciphertext never needs
+// JSON escaping, so the only slack is the PoW nonce length.
+func WrappedSize(originalSize, pathLength int, format LayerFormat) int {
+	size := originalSize
+	for i := 0; i < pathLength; i++ {
+		tags := format.Tags
+		if i == 0 {
+			tags = append([]TagOverhead{IdempotencyOverhead}, tags...)
+		}
+		if i < len(format.LayerTags) {
+			tags = slices.Concat(tags, format.LayerTags[i])
+		}
+
+		if format.Compact && i < pathLength-1 {
+			payload := NIP44PayloadSize(size)
+			size = compactWrapperOverhead - compact.LengthPrefix(0) + compact.LengthPrefix(payload) + payload
+			for _, tag := range tags {
+				size += tag.Compact
+			}
+			continue
+		}
+		size = wrapperOverhead + NIP44CiphertextSize(size)
+		for _, tag := range tags {
+			size += tag.JSON
+		}
+	}
+	return size
+}
+
+// MaxPayload returns the largest original event, in serialized bytes, whose outermost 29000 is
+// guaranteed to fit limits.MaxInnerEventSize once wrapped for pathLength Renoters in layers of
+// format, or 0 if none does.
+func MaxPayload(pathLength int, limits SizeLimits, format LayerFormat) int {
+	// WrappedSize is non-decreasing in the original size, so search for the last size that fits
+	low, high := 0, limits.MaxInnerEventSize
+	for low < high {
+		mid := (low + high + 1) / 2
+		if WrappedSize(mid, pathLength, format) <= limits.MaxInnerEventSize {
+			low = mid
+		} else {
+			high = mid - 1
+		}
+	}
+	return low
+}
+
+// MaxInnerPayload returns the largest original event, in bytes of JSON, guaranteed to fit
+// through a path of pathLength Renoters with the DefaultSizeLimits, or 0 if none does. It assumes
+// the plainest layers: JSON events, no mixing delays and no optional tags such as AckTag.
+// Optional tags only make layers larger; MaxPayload accounts for them.
+func MaxInnerPayload(pathLength int) int {
+	if pathLength < 1 {
+		return 0
+	}
+	return MaxPayload(pathLength, DefaultSizeLimits(), LayerFormat{})
+}
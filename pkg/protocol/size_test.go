@@ -0,0 +1,123 @@
+package protocol
+
+import (
+	"encoding/base64"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip44"
+)
+
+func TestSizeLimits_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		limits  SizeLimits
+		wantErr bool
+	}{
+		{"defaults", DefaultSizeLimits(), false},
+		{"small bucket", SizeLimits{StandardizedSize: 4096, MaxInnerEventSize: 4096 - PaddingTagOverhead}, false},
+		{"no headroom for padding tag", SizeLimits{StandardizedSize: StandardizedSize, MaxInnerEventSize: StandardizedSize}, true},
+		{"inner larger than outer", SizeLimits{StandardizedSize: 4096, MaxInnerEventSize: 8192}, true},
+		{"zero inner", SizeLimits{StandardizedSize: 4096, MaxInnerEventSize: 0}, true},
+		{"too small outer", SizeLimits{StandardizedSize: PaddingTagOverhead, MaxInnerEventSize: 1}, true},
+		{"beyond NIP-44", SizeLimits{StandardizedSize: MaxStandardizedSize + 1, MaxInnerEventSize: 1024}, true},
+		{"buckets", SizeLimits{StandardizedSize: 8192, MaxInnerEventSize: 1024, Buckets: []int{2048, 4096}}, false},
+		{"bucket above standardized size", SizeLimits{StandardizedSize: 4096, MaxInnerEventSize: 1024, Buckets: []int{8192}}, true},
+		{"bucket below padding tag", SizeLimits{StandardizedSize: 4096, MaxInnerEventSize: 1024, Buckets: []int{PaddingTagOverhead}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.limits.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSizeLimits_Bucket(t *testing.T) {
+	limits := SizeLimits{StandardizedSize: 8192, MaxInnerEventSize: 8192 - PaddingTagOverhead, Buckets: []int{4096, 1024, 4096}}
+	if got := limits.Sizes(); !slices.Equal(got, []int{1024, 4096, 8192}) {
+		t.Errorf("Sizes() = %v, want [1024 4096 8192]", got)
+	}
+	for size, want := range map[int]int{1: 1024, 1024: 1024, 1025: 4096, 8192: 8192, 8193: 0} {
+		if got := limits.Bucket(size); got != want {
+			t.Errorf("Bucket(%d) = %d, want %d", size, got, want)
+		}
+	}
+
+}
+
+func TestNIP44PayloadSize(t *testing.T) {
+	pubkey, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	key, err := nip44.GenerateConversationKey(pubkey, nostr.GeneratePrivateKey())
+	if err != nil {
+		t.Fatalf("GenerateConversationKey() error = %v", err)
+	}
+	for _, n := range []int{1, 31, 32, 33, 64, 65, 255, 256, 257, 1000, 4096, 4097, 32768, 65535} {
+		ciphertext, err := nip44.Encrypt(strings.Repeat("x", n), key)
+		if err != nil {
+			t.Fatalf("Encrypt(%d bytes) error = %v", n, err)
+		}
+		if got := NIP44CiphertextSize(n); got != len(ciphertext) {
+			t.Errorf("NIP44CiphertextSize(%d) = %d, want %d", n, got, len(ciphertext))
+		}
+		payload, _ := base64.StdEncoding.DecodeString(ciphertext)
+		if got := NIP44PayloadSize(n); got != len(payload) {
+			t.Errorf("NIP44PayloadSize(%d) = %d, want %d", n, got, len(payload))
+		}
+	}
+}
+
+func TestContainerContentSize(t *testing.T) {
+	pubkey, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	key, err := nip44.GenerateConversationKey(pubkey, nostr.GeneratePrivateKey())
+	if err != nil {
+		t.Fatalf("GenerateConversationKey() error = %v", err)
+	}
+	for _, bucket := range []int{1, 32, 33, 256, 257, 1000, 4096, 4097, StandardizedSize, MaxStandardizedSize} {
+		ciphertext, err := nip44.Encrypt(strings.Repeat("x", bucket), key)
+		if err != nil {
+			t.Fatalf("Encrypt() error = %v", err)
+		}
+		if got := ContainerContentSize(bucket); got != len(ciphertext) {
+			t.Errorf("ContainerContentSize(%d) = %d, want %d", bucket, got, len(ciphertext))
+		}
+	}
+}
+
+func TestMaxInnerPayload(t *testing.T) {
+	previous := DefaultSizeLimits().MaxInnerEventSize
+	for hops := 1; hops <= 5; hops++ {
+		size := MaxInnerPayload(hops)
+		if size <= 0 || size >= previous {
+			t.Errorf("MaxInnerPayload(%d) = %d, want a positive size below %d", hops, size, previous)
+		}
+		if WrappedSize(size, hops, LayerFormat{}) > DefaultSizeLimits().MaxInnerEventSize || WrappedSize(size+1, hops, LayerFormat{}) <= DefaultSizeLimits().MaxInnerEventSize {
+			t.Errorf("MaxInnerPayload(%d) = %d is not the largest size that fits", hops, size)
+		}
+		previous = size
+	}
+	if got := MaxInnerPayload(0); got != 0 {
+		t.Errorf("MaxInnerPayload(0) = %d, want 0", got)
+	}
+	if got := MaxInnerPayload(200); got != 0 {
+		t.Errorf("MaxInnerPayload(200) = %d, want 0", got)
+	}
+}
+
+func TestWrappedSize_LayerTags(t *testing.T) {
+	tag := SealedTagOverhead("payment", strings.Repeat("0", 200), strings.Repeat("0", 64))
+	plain := WrappedSize(1000, 3, LayerFormat{})
+	everyLayer := WrappedSize(1000, 3, LayerFormat{Tags: []TagOverhead{tag}})
+	// Only the middle layer carries the tag
+	oneLayer := WrappedSize(1000, 3, LayerFormat{LayerTags: [][]TagOverhead{nil, {tag}}})
+	if oneLayer <= plain || oneLayer >= everyLayer {
+		t.Errorf("WrappedSize() with the tag in one layer = %d, want between %d and %d", oneLayer, plain, everyLayer)
+	}
+	if got := WrappedSize(1000, 3, LayerFormat{LayerTags: [][]TagOverhead{{tag}, {tag}, {tag}}}); got != everyLayer {
+		t.Errorf("WrappedSize() with the tag in each layer = %d, want %d as with Tags", got, everyLayer)
+	}
+}
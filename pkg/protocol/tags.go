@@ -0,0 +1,112 @@
+package protocol
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// ServiceDescriptorTag is the "d" tag identifying a Renoter's service descriptor.
+const ServiceDescriptorTag = "renoter"
+
+// RoutingTag is the tag naming the Renoter a 29001 container or 29000 layer is addressed to:
+// ["p", "<pubkey>"]. A wrapper carries exactly one, so every implementation routes it to the same
+// Renoter; wrappers with none, several (even repeating the same pubkey) or a malformed one are
+// rejected. Final events are not wrappers and may tag any number of pubkeys.
+const RoutingTag = "p"
+
+// RoutingPubkey returns the pubkey a wrapper is addressed to, or an error if it does not carry
+// exactly one well-formed routing tag.
+func RoutingPubkey(wrapper *nostr.Event) (string, error) {
+	pubkey := ""
+	count := 0
+	for _, tag := range wrapper.Tags {
+		if len(tag) == 0 || tag[0] != RoutingTag {
+			continue
+		}
+		count++
+		if len(tag) < 2 || !nostr.IsValidPublicKey(tag[1]) {
+			return "", fmt.Errorf("malformed %q tag", RoutingTag)
+		}
+		pubkey = tag[1]
+	}
+	if count != 1 {
+		return "", fmt.Errorf("%d %q tags, want exactly one", count, RoutingTag)
+	}
+	return pubkey, nil
+}
+
+// HandshakeTag marks a 29000 layer as a capability probe instead of traffic, sealed to the Renoter
+// it is addressed to: ["handshake", sealed(["<client protocol version>"])]. The probe travels in
+// an ordinary standardized 29001 container, so relays cannot tell it from traffic.
+const HandshakeTag = "handshake"
+
+// ReportTag is the tag of a 29000 layer asking the Renoter it is addressed to for a timing
+// trailer, sealed to that Renoter like payment proofs: ["report", sealed(["<report pubkey>"])].
+// The report pubkey is a throwaway key of the sender, fresh for every event.
+const ReportTag = "report"
+
+// TrailerTag is the tag of a 29001 container carrying one timing trailer, encrypted to the report
+// pubkey with a throwaway key: ["trailer", "<throwaway pubkey>", "<NIP-44 ciphertext>"].
+const TrailerTag = "trailer"
+
+// TrailerSlots is the number of trailer tags on every container of an event that asked for timing
+// trailers. The sender fills them with decoys and every Renoter drops the oldest and appends its
+// own, so the count does not reveal a hop's position; only the last TrailerSlots hops are reported.
+const TrailerSlots = 6
+
+// AckTag is the tag of a 29000 layer asking the Renoter it is addressed to for a hop
+// acknowledgement, sealed to that Renoter like payment proofs: ["ack", sealed(["<protocol version>"])].
+// Since HopAcksVersion.
+const AckTag = "ack"
+
+// ReplyTag is the tag of a 29000 layer asking the Renoter it is addressed to for an error notice
+// if it rejects the layer, sealed to that Renoter like payment proofs:
+// ["reply", sealed(["<reply pubkey>"])]. The reply pubkey is a throwaway key of the sender, fresh
+// for every event and the same in all its layers. Since ErrorNoticesVersion.
+const ReplyTag = "reply"
+
+// IdempotencyTag is the tag of the innermost 29000 (the one addressed to the exit Renoter) carrying
+// the idempotency key of the original event, sealed to the exit like payment proofs:
+// ["idempotency", sealed(["<key>"])]. Every resend of an event carries the same key, so the exit
+// drops copies it has already published without decrypting them.
+const IdempotencyTag = "idempotency"
+
+// IdempotencyKey derives the idempotency key of an original event from its ID. It is hashed under
+// a domain prefix so the key differs from the event ID the relays see.
+func IdempotencyKey(eventID string) string {
+	sum := sha256.Sum256([]byte("renoter-idempotency:" + eventID))
+	return hex.EncodeToString(sum[:])
+}
+
+// DelayTag is the tag of a 29000 layer asking the Renoter it is addressed to to hold the layer
+// before forwarding it, sealed to that Renoter like payment proofs: ["delay", sealed(["<seconds>"])].
+// The value is the mean delay requested; the Renoter draws the actual delay at random and keeps it
+// within the bounds its operator allows. Layers without the tag are forwarded immediately.
+const DelayTag = "delay"
+
+// MaxDelayHint bounds the delay a DelayTag may request, so the sealed value has a fixed size.
+const MaxDelayHint = 24 * time.Hour
+
+// FormatDelayHint formats a requested mean delay as the value of a DelayTag, in whole seconds
+// (at least 1).
+func FormatDelayHint(delay time.Duration) string {
+	seconds := int64(delay / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return strconv.FormatInt(seconds, 10)
+}
+
+// ParseDelayHint parses the value of a DelayTag.
+func ParseDelayHint(value string) (time.Duration, error) {
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || seconds < 0 || time.Duration(seconds)*time.Second > MaxDelayHint {
+		return 0, fmt.Errorf("invalid delay hint %q", value)
+	}
+	return time.Duration(seconds) * time.Second, nil
+}
@@ -0,0 +1,57 @@
+package protocol
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	id := strings.Repeat("ab", 32)
+	key := IdempotencyKey(id)
+	if len(key) != 64 || key == id {
+		t.Errorf("IdempotencyKey() = %q, want a 64 character hex key distinct from the ID", key)
+	}
+	if IdempotencyKey(id) != key || IdempotencyKey(strings.Repeat("cd", 32)) == key {
+		t.Error("IdempotencyKey() should be deterministic and differ between events")
+	}
+}
+
+func TestDelayHint(t *testing.T) {
+	if got := FormatDelayHint(1500 * time.Millisecond); got != "1" {
+		t.Errorf("FormatDelayHint(1.5s) = %q, want whole seconds", got)
+	}
+	if got := FormatDelayHint(0); got != "1" {
+		t.Errorf("FormatDelayHint(0) = %q, want at least 1", got)
+	}
+	if delay, err := ParseDelayHint("30"); err != nil || delay != 30*time.Second {
+		t.Errorf("ParseDelayHint(30) = %v, %v", delay, err)
+	}
+	for _, value := range []string{"", "-1", "1.5", "86401"} {
+		if _, err := ParseDelayHint(value); err == nil {
+			t.Errorf("ParseDelayHint(%q) should fail", value)
+		}
+	}
+}
+
+func TestRoutingPubkey(t *testing.T) {
+	a := strings.Repeat("a", 64)
+	b := strings.Repeat("b", 64)
+	got, err := RoutingPubkey(&nostr.Event{Tags: nostr.Tags{{"nonce", "1", "16"}, {"p", a}}})
+	if err != nil || got != a {
+		t.Errorf("RoutingPubkey() = %q, %v, want %q", got, err, a)
+	}
+	for _, tags := range []nostr.Tags{
+		{},
+		{{"p", a}, {"p", a}},
+		{{"p", a}, {"p", b}},
+		{{"p"}},
+		{{"p", "not a pubkey"}},
+	} {
+		if got, err := RoutingPubkey(&nostr.Event{Tags: tags}); err == nil {
+			t.Errorf("RoutingPubkey(%v) = %q, want an error", tags, got)
+		}
+	}
+}
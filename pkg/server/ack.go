@@ -0,0 +1,85 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/girino/renoter/internal/config"
+	"github.com/girino/renoter/internal/sealtag"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip44"
+)
+
+// SetHopAcks sets whether this Renoter acknowledges layers asking for a hop acknowledgement (the
+// default). Senders use them to tell which hop lost an event and resend it.
+func (r *Renoter) SetHopAcks(enabled bool) {
+	r.acksOff = !enabled
+}
+
+// wantsAck reports whether the layer in msg asks for a hop acknowledgement this Renoter sends.
+func (r *Renoter) wantsAck(msg *Message) bool {
+	if r.acksOff || msg.Layer == nil || msg.LayerKey == [32]byte{} {
+		return false
+	}
+	tag := msg.Layer.Tags.Find(config.AckTag)
+	if tag == nil {
+		return false
+	}
+	if _, err := sealtag.Open(tag[1], msg.LayerKey); err != nil {
+		logging.Warn("server.ack.wantsAck: ignoring unreadable ack tag on 29000 %s: %v", msg.Layer.ID, err)
+		return false
+	}
+	return true
+}
+
+// acknowledge publishes the hop acknowledgement of the layer in msg, which ended with result, if
+// the layer asks for one. Held layers are acknowledged once forwarded, and dropped ones never,
+// so a sender cannot tell a drop from loss. Failures are only logged.
+func (r *Renoter) acknowledge(ctx context.Context, msg *Message, result Outcome) {
+	var ack config.HopAck
+	switch result.Kind {
+	case OutcomeForwarded:
+		ack.Outcome = config.AckForwarded
+	case OutcomePublishedFinal:
+		ack.Outcome = config.AckPublished
+	case OutcomeRejected:
+		ack.Outcome, ack.Reason = config.AckRejected, result.Reason
+	default:
+		return
+	}
+	if !r.wantsAck(msg) {
+		return
+	}
+	plaintext, err := json.Marshal(ack)
+	if err != nil {
+		logging.Warn("server.ack.acknowledge: failed to serialize acknowledgement of 29000 %s: %v", msg.Layer.ID, err)
+		return
+	}
+	content, err := nip44.Encrypt(string(plaintext), msg.LayerKey)
+	if err != nil {
+		logging.Warn("server.ack.acknowledge: failed to encrypt acknowledgement of 29000 %s: %v", msg.Layer.ID, err)
+		return
+	}
+	event := nostr.Event{
+		Kind:      config.HopAckKind,
+		CreatedAt: nostr.Now(),
+		Tags:      nostr.Tags{{"p", msg.Layer.PubKey}},
+		Content:   content,
+	}
+	if err := event.Sign(nostr.GeneratePrivateKey()); err != nil {
+		logging.Warn("server.ack.acknowledge: failed to sign acknowledgement: %v", err)
+		return
+	}
+	successCount := 0
+	for result := range r.forwarder.PublishMany(ctx, r.relayURLs, event) {
+		if result.Error == nil {
+			successCount++
+		}
+	}
+	if successCount == 0 {
+		logging.Warn("server.ack.acknowledge: no relay accepted the acknowledgement of 29000 %s", msg.Layer.ID)
+		return
+	}
+	logging.DebugMethod("server.ack", "acknowledge", "Acknowledged 29000 %s as %s with %s", msg.Layer.ID, ack.Outcome, event.ID)
+}
@@ -0,0 +1,74 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/girino/renoter/internal/config"
+	"github.com/girino/renoter/internal/sealtag"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip44"
+)
+
+func TestRenoter_Acknowledge(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pool := &fakePool{published: make(chan nostr.Event, 10)}
+	renoter, err := NewRenoterWithPool(ctx, nostr.GeneratePrivateKey(), []string{"wss://relay.example.com"}, pool)
+	if err != nil {
+		t.Fatalf("NewRenoterWithPool() error = %v", err)
+	}
+
+	// A container whose layer asks for an acknowledgement, and the key to read it
+	send := func() (string, [32]byte) {
+		final := &nostr.Event{Kind: 1, Content: "hello", CreatedAt: nostr.Now(), Tags: nostr.Tags{}}
+		final.Sign(nostr.GeneratePrivateKey())
+		finalJSON, _ := json.Marshal(final)
+		content, sk, pk, _ := encryptFor(t, string(finalJSON), renoter.PublicKey)
+		layerKey, _ := nip44.GenerateConversationKey(renoter.PublicKey, sk)
+		sealed, err := sealtag.Seal([]string{"4"}, layerKey)
+		if err != nil {
+			t.Fatalf("Seal() error = %v", err)
+		}
+		layer := &nostr.Event{Kind: config.WrapperEventKind, Content: content, CreatedAt: nostr.Now(), Tags: nostr.Tags{{"p", renoter.PublicKey}, {config.AckTag, sealed}}}
+		layer.Sign(sk)
+		renoter.Handle(ctx, sealContainer(t, renoter.PublicKey, layer, nostr.Now(), nostr.Tags{{"p", renoter.PublicKey}}))
+		return pk, layerKey
+	}
+	// The acknowledgement published for the layer with pubkey pk, or nil
+	ackOf := func(pk string, layerKey [32]byte) *config.HopAck {
+		for len(pool.published) > 0 {
+			event := <-pool.published
+			if event.Kind != config.HopAckKind || event.Tags.Find("p")[1] != pk {
+				continue
+			}
+			plaintext, err := nip44.Decrypt(event.Content, layerKey)
+			if err != nil {
+				t.Fatalf("failed to decrypt acknowledgement: %v", err)
+			}
+			var ack config.HopAck
+			if err := json.Unmarshal([]byte(plaintext), &ack); err != nil {
+				t.Fatalf("invalid acknowledgement: %v", err)
+			}
+			return &ack
+		}
+		return nil
+	}
+
+	renoter.powDifficulty = 0
+	if ack := ackOf(send()); ack == nil || ack.Outcome != config.AckPublished {
+		t.Errorf("acknowledgement of a published event = %+v", ack)
+	}
+	// Layers failing admission are acknowledged as rejected, with the reason
+	renoter.powDifficulty = 30
+	if ack := ackOf(send()); ack == nil || ack.Outcome != config.AckRejected || ack.Reason == "" {
+		t.Errorf("acknowledgement of an unmined layer = %+v", ack)
+	}
+	renoter.powDifficulty = 0
+	renoter.SetHopAcks(false)
+	if ack := ackOf(send()); ack != nil {
+		t.Errorf("acknowledgement with hop acks off = %+v", ack)
+	}
+}
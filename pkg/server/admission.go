@@ -0,0 +1,306 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/girino/renoter/internal/cashu"
+	"github.com/girino/renoter/internal/config"
+	"github.com/girino/renoter/internal/descriptor"
+	"github.com/girino/renoter/internal/sealtag"
+	"github.com/girino/renoter/internal/stamp"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// CashuTag is the 29000 tag carrying a Cashu token for the Renoter the layer is addressed to:
+// ["cashu", sealed(["<cashuA token>"])], sealed like PaymentTag so no other hop can spend it.
+const CashuTag = "cashu"
+
+// cashuRedeemTimeout bounds the mint requests made to redeem a single token.
+const cashuRedeemTimeout = 15 * time.Second
+
+// Admission is an anti-spam strategy deciding whether a 29000 layer addressed to this Renoter
+// may be forwarded. A Renoter admits a layer if any of its strategies does.
+type Admission interface {
+	// Name identifies the strategy in the service descriptor (descriptor.AdmissionPoW, ...)
+	Name() string
+	// Admit returns nil if wrapper may be forwarded. conversationKey is the layer's NIP-44
+	// conversation key, for reading sealed tags.
+	Admit(ctx context.Context, wrapper *nostr.Event, conversationKey [32]byte) error
+}
+
+// DeferredAdmission is an Admission taking something of value from the sender, such as a Cashu
+// token. Admit only checks the layer, and Redeem collects the value in StageRedeem, once every
+// other stage has passed the layer, so a layer rejected on the way costs its sender nothing.
+type DeferredAdmission interface {
+	Admission
+	// Redeem collects the value carried by wrapper, which Admit admitted
+	Redeem(ctx context.Context, wrapper *nostr.Event, conversationKey [32]byte) error
+}
+
+// PoWAdmission admits layers mined to at least Difficulty (NIP-13). It is the default strategy.
+type PoWAdmission struct {
+	Difficulty int
+}
+
+// Name implements Admission.
+func (a *PoWAdmission) Name() string {
+	return descriptor.AdmissionPoW
+}
+
+// Admit implements Admission. The committed difficulty only counts if the ID meets it, or the
+// skeleton ID for a stamped layer.
+func (a *PoWAdmission) Admit(ctx context.Context, wrapper *nostr.Event, conversationKey [32]byte) error {
+	if committed := stamp.CommittedDifficulty(wrapper); committed < a.Difficulty {
+		return fmt.Errorf("committed difficulty %d is less than required %d", committed, a.Difficulty)
+	}
+	return nil
+}
+
+// CashuPolicy configures Cashu admission: each layer must carry a token worth at least Amount
+// sats from one of Mints. Redeemed tokens are appended to WalletFile.
+type CashuPolicy struct {
+	// Token value in sats required per layer
+	Amount uint64
+	// Mint URLs whose tokens are accepted
+	Mints []string
+	// File the redeemed tokens are appended to, one cashuA token per line
+	WalletFile string
+}
+
+// Validate checks that the policy is usable.
+func (p CashuPolicy) Validate() error {
+	if p.Amount == 0 {
+		return fmt.Errorf("token amount must be positive")
+	}
+	if len(p.Mints) == 0 {
+		return fmt.Errorf("at least one mint is required")
+	}
+	for _, mint := range p.Mints {
+		if !strings.HasPrefix(mint, "https://") && !strings.HasPrefix(mint, "http://") {
+			return fmt.Errorf("invalid mint URL %q", mint)
+		}
+	}
+	if p.WalletFile == "" {
+		return fmt.Errorf("a wallet file is required to keep redeemed tokens")
+	}
+	return nil
+}
+
+// CashuRedeemer swaps a token for fresh proofs, so its sender can no longer spend it.
+type CashuRedeemer interface {
+	Redeem(ctx context.Context, token *cashu.Token) (*cashu.Token, error)
+}
+
+// MintRedeemer redeems tokens with a swap at the mint that issued them (NUT-03).
+type MintRedeemer struct {
+	Client *http.Client
+}
+
+// Redeem implements CashuRedeemer.
+func (m *MintRedeemer) Redeem(ctx context.Context, token *cashu.Token) (*cashu.Token, error) {
+	return cashu.Redeem(ctx, m.Client, token)
+}
+
+// CashuAdmission admits layers carrying a Cashu token of the configured value. It is a
+// DeferredAdmission: the token is redeemed just before the layer is forwarded, and the mint
+// refuses spent proofs, so tokens cannot be reused.
+type CashuAdmission struct {
+	policy   CashuPolicy
+	redeemer CashuRedeemer
+
+	// Serializes appends to the wallet file
+	mu sync.Mutex
+}
+
+// NewCashuAdmission creates a Cashu admission strategy. A nil redeemer redeems at the mints.
+func NewCashuAdmission(policy CashuPolicy, redeemer CashuRedeemer) (*CashuAdmission, error) {
+	for i, mint := range policy.Mints {
+		policy.Mints[i] = strings.TrimRight(strings.TrimSpace(mint), "/")
+	}
+	if err := policy.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid cashu policy: %w", err)
+	}
+	if redeemer == nil {
+		redeemer = &MintRedeemer{Client: &http.Client{}}
+	}
+	return &CashuAdmission{policy: policy, redeemer: redeemer}, nil
+}
+
+// Name implements Admission.
+func (a *CashuAdmission) Name() string {
+	return descriptor.AdmissionCashu
+}
+
+// Admit implements Admission. It checks the token without redeeming it.
+func (a *CashuAdmission) Admit(ctx context.Context, wrapper *nostr.Event, conversationKey [32]byte) error {
+	_, _, err := a.token(wrapper, conversationKey)
+	return err
+}
+
+// Redeem implements DeferredAdmission: it swaps the token at its mint and keeps the fresh one.
+func (a *CashuAdmission) Redeem(ctx context.Context, wrapper *nostr.Event, conversationKey [32]byte) error {
+	token, amount, err := a.token(wrapper, conversationKey)
+	if err != nil {
+		return err
+	}
+
+	redeemCtx, cancel := context.WithTimeout(ctx, cashuRedeemTimeout)
+	defer cancel()
+	redeemed, err := a.redeemer.Redeem(redeemCtx, token)
+	if err != nil {
+		logging.Warn("server.admission.Redeem: cashu token in 29000 %s rejected: %v", wrapper.ID, err)
+		return fmt.Errorf("token redemption failed: %w", err)
+	}
+
+	// The sender's token is spent either way, so a storage failure does not reject the layer
+	if err := a.store(redeemed); err != nil {
+		logging.Error("server.admission.Redeem: failed to store redeemed token from 29000 %s: %v", wrapper.ID, err)
+	}
+	logging.DebugMethod("server.admission", "Redeem", "Redeemed %d sat cashu token from 29000 %s", amount, wrapper.ID)
+	return nil
+}
+
+// token opens the token sealed in wrapper and checks it against the policy, returning it with its
+// value in sats.
+func (a *CashuAdmission) token(wrapper *nostr.Event, conversationKey [32]byte) (*cashu.Token, uint64, error) {
+	tag := wrapper.Tags.Find(CashuTag)
+	if tag == nil {
+		return nil, 0, fmt.Errorf("no cashu token")
+	}
+	values, err := sealtag.Open(tag[1], conversationKey)
+	if err != nil || len(values) < 1 {
+		return nil, 0, fmt.Errorf("invalid cashu tag")
+	}
+	token, err := cashu.Decode(values[0])
+	if err != nil {
+		return nil, 0, err
+	}
+	if token.Unit != "" && token.Unit != cashu.UnitSat {
+		return nil, 0, fmt.Errorf("token unit %q is not %s", token.Unit, cashu.UnitSat)
+	}
+	mint, err := token.Mint()
+	if err != nil {
+		return nil, 0, err
+	}
+	if !slices.Contains(a.policy.Mints, strings.TrimRight(mint, "/")) {
+		return nil, 0, fmt.Errorf("mint %s is not accepted", mint)
+	}
+	amount, err := token.Amount()
+	if err != nil {
+		return nil, 0, err
+	}
+	if amount < a.policy.Amount {
+		return nil, 0, fmt.Errorf("token is worth %d sats, %d required", amount, a.policy.Amount)
+	}
+	return token, amount, nil
+}
+
+// store appends a redeemed token to the wallet file.
+func (a *CashuAdmission) store(token *cashu.Token) error {
+	encoded, err := token.Encode()
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	file, err := os.OpenFile(a.policy.WalletFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteString(encoded + "\n"); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// SetAdmissions replaces the strategies 29000 layers are admitted with; any one of them suffices.
+// Strategies are tried in order, so cheap checks such as PoW should come first.
+func (r *Renoter) SetAdmissions(admissions ...Admission) error {
+	if len(admissions) == 0 {
+		logging.Error("server.admission.SetAdmissions: no admission strategy given")
+		return fmt.Errorf("at least one admission strategy is required")
+	}
+	names := make([]string, 0, len(admissions))
+	for _, admission := range admissions {
+		if slices.Contains(names, admission.Name()) {
+			return fmt.Errorf("admission strategy %q given twice", admission.Name())
+		}
+		names = append(names, admission.Name())
+	}
+	r.admissions = admissions
+	logging.Info("server.admission.SetAdmissions: Admitting 29000 layers with %s", strings.Join(names, " or "))
+	return nil
+}
+
+// admissionStrategies returns the configured strategies, defaulting to PoW at powDifficulty.
+func (r *Renoter) admissionStrategies() []Admission {
+	if len(r.admissions) == 0 {
+		return []Admission{&PoWAdmission{Difficulty: r.powDifficulty}}
+	}
+	return r.admissions
+}
+
+// admitLayer checks a 29000 layer addressed to this Renoter against its admission strategies,
+// returning the one that admitted it. Layers of lite containers need no more PoW than
+// config.LitePoWDifficulty.
+func (r *Renoter) admitLayer(ctx context.Context, wrapper *nostr.Event, conversationKey [32]byte, lite bool) (Admission, error) {
+	var reasons []string
+	for _, admission := range r.admissionStrategies() {
+		if pow, ok := admission.(*PoWAdmission); ok && lite && pow.Difficulty > config.LitePoWDifficulty {
+			admission = &PoWAdmission{Difficulty: config.LitePoWDifficulty}
+		}
+		err := admission.Admit(ctx, wrapper, conversationKey)
+		if _, ok := admission.(*PoWAdmission); ok && err == nil && stamp.Stamped(wrapper) {
+			err = r.spendStamp(wrapper, time.Now())
+		}
+		if err == nil {
+			logging.DebugMethod("server.admission", "admitLayer", "29000 %s admitted by %s", wrapper.ID, admission.Name())
+			if _, ok := admission.(*PoWAdmission); ok {
+				r.powBits.record(wrapper)
+			}
+			return admission, nil
+		}
+		reasons = append(reasons, fmt.Sprintf("%s: %v", admission.Name(), err))
+	}
+	logging.Error("server.admission.admitLayer: 29000 %s not admitted: %s", wrapper.ID, strings.Join(reasons, "; "))
+	return nil, fmt.Errorf("29000 event not admitted: %s", strings.Join(reasons, "; "))
+}
+
+// redeemLayer is StageRedeem: it collects the value carried by a layer admitted by a
+// DeferredAdmission, now that no other stage rejected it.
+func (r *Renoter) redeemLayer(ctx context.Context, msg *Message) error {
+	deferred, ok := msg.Admission.(DeferredAdmission)
+	if !ok {
+		return nil
+	}
+	return deferred.Redeem(ctx, msg.Layer, msg.LayerKey)
+}
+
+const (
+	// stampClockSkew is how far in the future a stamped layer may be dated
+	stampClockSkew = 5 * time.Minute
+	// stampCacheSize bounds the spent stamps remembered; each cost a mined nonce
+	stampCacheSize = 50000
+)
+
+// spendStamp accepts the stamp of a layer once, while it is fresh: a stamp is not bound to the
+// content of its layer, so only spending it keeps it from admitting other layers.
+func (r *Renoter) spendStamp(wrapper *nostr.Event, now time.Time) error {
+	created := wrapper.CreatedAt.Time()
+	if created.Before(now.Add(-stamp.MaxAge)) || created.After(now.Add(stampClockSkew)) {
+		return fmt.Errorf("stamp of %s is not within %v of now", created.Format(time.RFC3339), stamp.MaxAge)
+	}
+	if r.stamps.CheckAndMark(stamp.ID(wrapper), now) {
+		return fmt.Errorf("stamp already spent")
+	}
+	return nil
+}
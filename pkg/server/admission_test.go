@@ -0,0 +1,219 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/girino/renoter/internal/cashu"
+	"github.com/girino/renoter/internal/descriptor"
+	"github.com/girino/renoter/internal/sealtag"
+	"github.com/girino/renoter/internal/stamp"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip13"
+)
+
+// fakeRedeemer redeems every token once, like a mint refusing spent proofs.
+type fakeRedeemer struct {
+	spent map[string]bool
+}
+
+func (f *fakeRedeemer) Redeem(ctx context.Context, token *cashu.Token) (*cashu.Token, error) {
+	secret := token.Proofs()[0].Secret
+	if f.spent[secret] {
+		return nil, fmt.Errorf("token already spent")
+	}
+	f.spent[secret] = true
+	mint, _ := token.Mint()
+	amount, _ := token.Amount()
+	return &cashu.Token{Token: []cashu.TokenEntry{{Mint: mint, Proofs: []cashu.Proof{{Amount: amount, ID: "00", Secret: "redeemed-" + secret, C: "02"}}}}, Unit: cashu.UnitSat}, nil
+}
+
+// newCashuWrapper returns a 29000 carrying a token of amount from mint sealed to testLayerKey.
+func newCashuWrapper(t *testing.T, mint string, amount uint64, secret string) *nostr.Event {
+	t.Helper()
+	token := &cashu.Token{Token: []cashu.TokenEntry{{Mint: mint, Proofs: []cashu.Proof{{Amount: amount, ID: "00", Secret: secret, C: "02"}}}}, Unit: cashu.UnitSat}
+	encoded, err := token.Encode()
+	if err != nil {
+		t.Fatalf("Encode() error = %v", err)
+	}
+	sealed, err := sealtag.Seal([]string{encoded}, testLayerKey)
+	if err != nil {
+		t.Fatalf("Seal() error = %v", err)
+	}
+	return &nostr.Event{Kind: 29000, CreatedAt: nostr.Now(), Tags: nostr.Tags{{"p", strings.Repeat("a", 64)}, {CashuTag, sealed}}}
+}
+
+func newTestCashuAdmission(t *testing.T) (*CashuAdmission, string) {
+	t.Helper()
+	walletFile := filepath.Join(t.TempDir(), "tokens.txt")
+	admission, err := NewCashuAdmission(CashuPolicy{Amount: 4, Mints: []string{"https://mint.example.com/"}, WalletFile: walletFile}, &fakeRedeemer{spent: make(map[string]bool)})
+	if err != nil {
+		t.Fatalf("NewCashuAdmission() error = %v", err)
+	}
+	return admission, walletFile
+}
+
+func TestCashuPolicy_Validate(t *testing.T) {
+	valid := CashuPolicy{Amount: 1, Mints: []string{"https://mint.example.com"}, WalletFile: "tokens.txt"}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+	for name, policy := range map[string]CashuPolicy{
+		"no amount": {Mints: []string{"https://mint.example.com"}, WalletFile: "tokens.txt"},
+		"no mints":  {Amount: 1, WalletFile: "tokens.txt"},
+		"bad mint":  {Amount: 1, Mints: []string{"mint.example.com"}, WalletFile: "tokens.txt"},
+		"no wallet": {Amount: 1, Mints: []string{"https://mint.example.com"}},
+	} {
+		if err := policy.Validate(); err == nil {
+			t.Errorf("Validate(%s) should fail", name)
+		}
+	}
+}
+
+func TestCashuAdmission_Admit(t *testing.T) {
+	ctx := context.Background()
+	admission, walletFile := newTestCashuAdmission(t)
+
+	// Admit only checks the token: it is spent by Redeem
+	if err := admission.Admit(ctx, newCashuWrapper(t, "https://mint.example.com", 4, "s1"), testLayerKey); err != nil {
+		t.Fatalf("Admit() error = %v", err)
+	}
+	if data, _ := os.ReadFile(walletFile); len(data) != 0 {
+		t.Error("Admit() redeemed the token")
+	}
+	if err := admission.Redeem(ctx, newCashuWrapper(t, "https://mint.example.com", 4, "s1"), testLayerKey); err != nil {
+		t.Fatalf("Redeem() error = %v", err)
+	}
+	if err := admission.Redeem(ctx, newCashuWrapper(t, "https://mint.example.com", 4, "s1"), testLayerKey); err == nil {
+		t.Error("Redeem() should reject a spent token")
+	}
+
+	rejects := map[string]*nostr.Event{
+		"no token":     newPaidWrapper(t),
+		"too small":    newCashuWrapper(t, "https://mint.example.com", 2, "s2"),
+		"unknown mint": newCashuWrapper(t, "https://evil.example.com", 4, "s3"),
+		"wrong key":    newCashuWrapper(t, "https://mint.example.com", 4, "s4"),
+		"unsealed":     {Kind: 29000, Tags: nostr.Tags{{CashuTag, "cashuAeyJ0b2tlbiI6W119"}}},
+	}
+	for name, wrapper := range rejects {
+		key := testLayerKey
+		if name == "wrong key" {
+			key = [32]byte{9}
+		}
+		if err := admission.Admit(ctx, wrapper, key); err == nil {
+			t.Errorf("Admit(%s) should fail", name)
+		}
+	}
+
+	// Only the redeemed token is kept
+	data, err := os.ReadFile(walletFile)
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("wallet file has %d tokens, want 1", len(lines))
+	}
+	if token, err := cashu.Decode(lines[0]); err != nil || token.Proofs()[0].Secret != "redeemed-s1" {
+		t.Errorf("wallet token = %+v, %v, want the redeemed token", token, err)
+	}
+}
+
+func TestRenoter_AdmitLayer(t *testing.T) {
+	ctx := context.Background()
+	renoter := newOfflineRenoter(t)
+	renoter.powDifficulty = 8
+
+	// PoW is the default: an unmined layer is rejected
+	if _, err := renoter.admitLayer(ctx, newCashuWrapper(t, "https://mint.example.com", 4, "s1"), testLayerKey, false); err == nil || !strings.Contains(err.Error(), "committed difficulty") {
+		t.Errorf("admitLayer() without PoW error = %v, want a difficulty error", err)
+	}
+
+	if err := renoter.SetAdmissions(); err == nil {
+		t.Error("SetAdmissions() should require a strategy")
+	}
+	if err := renoter.SetAdmissions(&PoWAdmission{Difficulty: 8}, &PoWAdmission{Difficulty: 4}); err == nil {
+		t.Error("SetAdmissions() should reject duplicate strategies")
+	}
+
+	cashuAdmission, walletFile := newTestCashuAdmission(t)
+	if err := renoter.SetAdmissions(&PoWAdmission{Difficulty: 8}, cashuAdmission); err != nil {
+		t.Fatalf("SetAdmissions() error = %v", err)
+	}
+	// The token is only redeemed by StageRedeem, so a layer rejected before costs nothing
+	msg := &Message{Layer: newCashuWrapper(t, "https://mint.example.com", 4, "s1"), LayerKey: testLayerKey}
+	admission, err := renoter.admitLayer(ctx, msg.Layer, msg.LayerKey, false)
+	if err != nil || admission != cashuAdmission {
+		t.Fatalf("admitLayer() with a token = %v, %v, want the cashu strategy", admission, err)
+	}
+	if data, _ := os.ReadFile(walletFile); len(data) != 0 {
+		t.Error("admitLayer() redeemed the token")
+	}
+	msg.Admission = admission
+	if err := renoter.redeemLayer(ctx, msg); err != nil {
+		t.Errorf("redeemLayer() error = %v", err)
+	}
+	if data, _ := os.ReadFile(walletFile); len(data) == 0 {
+		t.Error("redeemLayer() did not redeem the token")
+	}
+	if err := renoter.redeemLayer(ctx, msg); err == nil {
+		t.Error("redeemLayer() of a spent token should fail")
+	}
+	if err := renoter.redeemLayer(ctx, &Message{Admission: &PoWAdmission{}}); err != nil {
+		t.Errorf("redeemLayer() of a PoW layer error = %v", err)
+	}
+
+	_, err = renoter.admitLayer(ctx, newCashuWrapper(t, "https://mint.example.com", 2, "s2"), testLayerKey, false)
+	if err == nil || !strings.Contains(err.Error(), "pow:") || !strings.Contains(err.Error(), "cashu:") {
+		t.Errorf("admitLayer() with a small token error = %v, want reasons from both strategies", err)
+	}
+
+	d := renoter.Descriptor("")
+	if len(d.Admission) != 2 || d.Admission[1] != descriptor.AdmissionCashu || d.PoWDifficulty != 8 || d.CashuAmount != 4 || d.CashuMints[0] != "https://mint.example.com" {
+		t.Errorf("Descriptor() = %+v, want both admission strategies", d)
+	}
+}
+
+// newStampedWrapper returns a 29000 dated createdAt carrying a stamp of difficulty.
+func newStampedWrapper(t *testing.T, createdAt nostr.Timestamp, difficulty int) *nostr.Event {
+	t.Helper()
+	pubkey, recipient := strings.Repeat("b", 64), strings.Repeat("a", 64)
+	nonce, err := nip13.DoWork(context.Background(), stamp.Skeleton(pubkey, createdAt, recipient), difficulty)
+	if err != nil {
+		t.Fatalf("DoWork() error = %v", err)
+	}
+	layer := &nostr.Event{Kind: 29000, PubKey: pubkey, CreatedAt: createdAt, Tags: nostr.Tags{{"p", recipient}, stamp.Tag(nonce)}, Content: "ciphertext"}
+	layer.ID = layer.GetID()
+	return layer
+}
+
+func TestRenoter_AdmitLayer_Stamp(t *testing.T) {
+	ctx := context.Background()
+	renoter := newOfflineRenoter(t)
+	renoter.powDifficulty = 4
+
+	layer := newStampedWrapper(t, nostr.Now(), 4)
+	if _, err := renoter.admitLayer(ctx, layer, testLayerKey, false); err != nil {
+		t.Fatalf("admitLayer() with a stamp error = %v", err)
+	}
+	// A stamp is not bound to the content, so it admits a single layer
+	reused := *layer
+	reused.Content = "other ciphertext"
+	reused.ID = reused.GetID()
+	if _, err := renoter.admitLayer(ctx, &reused, testLayerKey, false); err == nil || !strings.Contains(err.Error(), "stamp already spent") {
+		t.Errorf("admitLayer() with a spent stamp error = %v", err)
+	}
+
+	stale := newStampedWrapper(t, nostr.Timestamp(time.Now().Add(-stamp.MaxAge-time.Minute).Unix()), 4)
+	if _, err := renoter.admitLayer(ctx, stale, testLayerKey, false); err == nil {
+		t.Error("admitLayer() accepted a stale stamp")
+	}
+	if stats := renoter.PoWStats(); stats.Admitted != 1 {
+		t.Errorf("PoWStats().Admitted = %d, want the stamped layer", stats.Admitted)
+	}
+}
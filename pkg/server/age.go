@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// replayWindow is how long processed container IDs are remembered by default. Containers must be
+// too old to pass StageAge by the time they are forgotten, or they could be replayed.
+const replayWindow = 2 * time.Hour
+
+// AgePolicy bounds the created_at of incoming 29001 containers and of the 29000 layers inside
+// them. Both are checked, so an old layer cannot be brought back in a freshly dated container.
+type AgePolicy struct {
+	// Oldest a container or layer may be when received
+	MaxAge time.Duration
+	// How far in the future a created_at may be, to allow for clocks running ahead
+	MaxFuture time.Duration
+}
+
+// DefaultAgePolicy returns the policy of a new Renoter.
+func DefaultAgePolicy() AgePolicy {
+	return AgePolicy{MaxAge: time.Hour, MaxFuture: stampClockSkew}
+}
+
+// Validate checks that the policy is usable.
+func (p AgePolicy) Validate() error {
+	if p.MaxAge <= 0 {
+		return fmt.Errorf("max age must be positive, got %v", p.MaxAge)
+	}
+	if p.MaxFuture < 0 {
+		return fmt.Errorf("max future must not be negative, got %v", p.MaxFuture)
+	}
+	return nil
+}
+
+// SetAgePolicy sets how old, and how far in the future, containers and layers may be. Together
+// they may not exceed the TTL of the replay cache (see SetReplayCachePolicy).
+func (r *Renoter) SetAgePolicy(policy AgePolicy) error {
+	err := policy.Validate()
+	if err == nil {
+		err = checkReplayWindow(policy, r.ReplayCachePolicy().TTL)
+	}
+	if err != nil {
+		logging.Error("server.age.SetAgePolicy: invalid policy: %v", err)
+		return fmt.Errorf("invalid age policy: %w", err)
+	}
+	r.age = policy
+	logging.Info("server.age.SetAgePolicy: Accepting containers and layers created up to %v ago and %v ahead", policy.MaxAge, policy.MaxFuture)
+	return nil
+}
+
+// agePolicy returns the policy ages are checked with, the default if none was set.
+func (r *Renoter) agePolicy() AgePolicy {
+	if r.age.MaxAge == 0 {
+		return DefaultAgePolicy()
+	}
+	return r.age
+}
+
+// checkCreatedAt rejects an event created before the policy's max age or after its max future,
+// relative to receivedAt. what names the event in errors.
+func (r *Renoter) checkCreatedAt(what string, event *nostr.Event, receivedAt time.Time) error {
+	policy := r.agePolicy()
+	eventTime := time.Unix(int64(event.CreatedAt), 0)
+	if eventTime.Before(receivedAt.Add(-policy.MaxAge)) {
+		logging.Warn("server.age.checkCreatedAt: %s %s is too old (created at %v, more than %v ago)", what, event.ID, eventTime, policy.MaxAge)
+		return fmt.Errorf("%s %s is too old (created more than %v ago)", what, event.ID, policy.MaxAge)
+	}
+	if eventTime.After(receivedAt.Add(policy.MaxFuture)) {
+		logging.Warn("server.age.checkCreatedAt: %s %s is dated in the future (created at %v, more than %v ahead)", what, event.ID, eventTime, policy.MaxFuture)
+		return fmt.Errorf("%s %s is dated in the future (more than %v ahead)", what, event.ID, policy.MaxFuture)
+	}
+	return nil
+}
+
+// checkAge is StageAge: it rejects containers created too long ago or too far in the future.
+func (r *Renoter) checkAge(ctx context.Context, msg *Message) error {
+	return r.checkCreatedAt("event", msg.Container, msg.ReceivedAt)
+}
+
+// checkLayerAge is StageLayerAge: it applies the same bounds to the 29000 layer, which the
+// container's own date says nothing about.
+func (r *Renoter) checkLayerAge(ctx context.Context, msg *Message) error {
+	return r.checkCreatedAt("inner 29000", msg.Layer, msg.ReceivedAt)
+}
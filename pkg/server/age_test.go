@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/girino/renoter/internal/config"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestAgePolicy_Validate(t *testing.T) {
+	if err := DefaultAgePolicy().Validate(); err != nil {
+		t.Errorf("DefaultAgePolicy().Validate() error = %v", err)
+	}
+	for _, policy := range []AgePolicy{
+		{MaxAge: 0},
+		{MaxAge: time.Hour, MaxFuture: -time.Second},
+	} {
+		if err := policy.Validate(); err == nil {
+			t.Errorf("Validate(%+v) should fail", policy)
+		}
+	}
+
+	// The replay cache must remember containers for as long as they are accepted
+	renoter := newOfflineRenoter(t)
+	if err := renoter.SetAgePolicy(AgePolicy{MaxAge: replayWindow, MaxFuture: time.Minute}); err == nil {
+		t.Error("SetAgePolicy() beyond the replay cache TTL should fail")
+	}
+}
+
+// nestedContainer wraps a 29000 layer created layerAge ago in a 29001 container created
+// containerAge ago, both addressed to recipient. Negative ages are in the future.
+func nestedContainer(t *testing.T, recipient string, containerAge, layerAge time.Duration) *nostr.Event {
+	t.Helper()
+	now := time.Now()
+	ciphertext, sk, _, _ := encryptFor(t, "not an event", recipient)
+	layer := nostr.Event{
+		Kind:      config.WrapperEventKind,
+		Content:   ciphertext,
+		CreatedAt: nostr.Timestamp(now.Add(-layerAge).Unix()),
+		Tags:      nostr.Tags{{"p", recipient}},
+	}
+	layer.Sign(sk)
+	return sealContainer(t, recipient, &layer, nostr.Timestamp(now.Add(-containerAge).Unix()), nostr.Tags{{"p", recipient}})
+}
+
+func TestRenoter_Handle_MixedAgeNesting(t *testing.T) {
+	tests := []struct {
+		name                   string
+		containerAge, layerAge time.Duration
+		// Stage expected to reject the container, empty if both ages are accepted
+		stage string
+	}{
+		{"both fresh", 0, 0, ""},
+		{"within skew and age", -2 * time.Minute, 50 * time.Minute, ""},
+		{"old container", 2 * time.Hour, 0, StageAge},
+		{"future container", -time.Hour, 0, StageAge},
+		{"old layer in fresh container", 0, 2 * time.Hour, StageLayerAge},
+		{"old layer in future container", -time.Hour, 2 * time.Hour, StageAge},
+		{"future layer in fresh container", 0, -time.Hour, StageLayerAge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			renoter := newOfflineRenoter(t)
+			if err := renoter.SetTraceBuffer(1); err != nil {
+				t.Fatalf("SetTraceBuffer() error = %v", err)
+			}
+			container := nestedContainer(t, renoter.PublicKey, tt.containerAge, tt.layerAge)
+
+			// The layer holds no event, so every container fails at the latest when it is decrypted
+			if err := renoter.Handle(context.Background(), container); err == nil {
+				t.Fatal("Handle() accepted an undecryptable layer")
+			}
+			trace := renoter.Traces()[0]
+			if tt.stage != "" && trace.StoppedAt != tt.stage {
+				t.Errorf("stopped at %q, want %q", trace.StoppedAt, tt.stage)
+			}
+			if tt.stage == "" && (trace.StoppedAt == StageAge || trace.StoppedAt == StageLayerAge) {
+				t.Errorf("stopped at %q, want the ages accepted", trace.StoppedAt)
+			}
+		})
+	}
+}
+
+func TestRenoter_SetAgePolicy(t *testing.T) {
+	renoter := newOfflineRenoter(t)
+	if err := renoter.SetAgePolicy(AgePolicy{MaxAge: 10 * time.Minute}); err != nil {
+		t.Fatalf("SetAgePolicy() error = %v", err)
+	}
+	msg := &Message{ReceivedAt: time.Now()}
+	msg.Container = nestedContainer(t, renoter.PublicKey, 0, 0)
+	if err := renoter.checkAge(context.Background(), msg); err != nil {
+		t.Errorf("checkAge() error = %v for a fresh container", err)
+	}
+	msg.Layer = &nostr.Event{CreatedAt: nostr.Timestamp(msg.ReceivedAt.Add(-20 * time.Minute).Unix())}
+	if err := renoter.checkLayerAge(context.Background(), msg); err == nil {
+		t.Error("checkLayerAge() accepted a layer older than the max age")
+	}
+	msg.Layer.CreatedAt = nostr.Timestamp(msg.ReceivedAt.Add(time.Minute).Unix())
+	if err := renoter.checkLayerAge(context.Background(), msg); err == nil {
+		t.Error("checkLayerAge() accepted a future layer without clock skew")
+	}
+
+	if err := renoter.SetAgePolicy(AgePolicy{MaxAge: 3 * time.Hour}); err == nil {
+		t.Error("SetAgePolicy() accepted a max age beyond the replay window")
+	}
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// NIP-32 labels an exit publishes about the final events it relayed, see SetAttribution.
+const (
+	labelKind = 1985
+	// AttributionNamespace is the label namespace ("L" tag) of attribution labels
+	AttributionNamespace = "app.renoter"
+	// AttributionLabel is the label ("l" tag) of attribution labels
+	AttributionLabel = "relayed"
+)
+
+// SetAttribution makes the exit publish a NIP-32 label, signed with the Renoter's key, for every
+// final event it publishes, stating that the event was relayed by this Renoter. Final events are
+// signed by their authors, so the exit can neither add nor strip tags on them; a separate label
+// is the only way to attribute them. It is off by default: the label tells anyone that the event
+// came through a Renoter, and through which exit. The setting is announced in the descriptor.
+func (r *Renoter) SetAttribution(enabled bool) {
+	r.attribution = enabled
+	if enabled {
+		logging.Info("server.attribution.SetAttribution: Labelling published final events as relayed by this Renoter")
+	}
+}
+
+// attributionLabel returns the unsigned label attributing event to this Renoter.
+func attributionLabel(event *nostr.Event) nostr.Event {
+	return nostr.Event{
+		Kind:      labelKind,
+		CreatedAt: nostr.Now(),
+		Tags: nostr.Tags{
+			{"L", AttributionNamespace},
+			{"l", AttributionLabel, AttributionNamespace},
+			{"e", event.ID},
+			{"k", strconv.Itoa(event.Kind)},
+		},
+	}
+}
+
+// attribute publishes the attribution label of a published final event, if enabled, to the
+// relays the event went to. Ephemeral events are not stored, so they get no label. Failures
+// are only logged: the event is out already.
+func (r *Renoter) attribute(ctx context.Context, event *nostr.Event) {
+	if !r.attribution || nostr.IsEphemeralKind(event.Kind) {
+		return
+	}
+	label := attributionLabel(event)
+	if err := r.identity.SignEvent(ctx, &label); err != nil {
+		logging.Error("server.attribution.attribute: failed to sign label: %v", err)
+		return
+	}
+	successCount := 0
+	relayURLs := r.finalRelayURLs()
+	for result := range r.forwarder.PublishMany(ctx, relayURLs, label) {
+		if result.Error != nil {
+			logging.Warn("server.attribution.attribute: failed to publish label of %s to %s: %v", event.ID, result.RelayURL, result.Error)
+			continue
+		}
+		successCount++
+	}
+	logging.DebugMethod("server.attribution", "attribute", "Published label %s of final event %s to %d/%d relays", label.ID, event.ID, successCount, len(relayURLs))
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestPublishFinal_Attribution(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pool := &fakePool{published: make(chan nostr.Event, 10)}
+	renoter, err := NewRenoterWithPool(ctx, nostr.GeneratePrivateKey(), []string{"wss://relay.example.com"}, pool)
+	if err != nil {
+		t.Fatalf("NewRenoterWithPool() error = %v", err)
+	}
+	publish := func(kind int) *nostr.Event {
+		event := &nostr.Event{Kind: kind, Content: "note", CreatedAt: nostr.Now(), Tags: nostr.Tags{}}
+		event.Sign(nostr.GeneratePrivateKey())
+		if err := renoter.publishFinal(ctx, event); err != nil {
+			t.Fatalf("publishFinal() error = %v", err)
+		}
+		return event
+	}
+
+	// Off by default: only the event itself is published
+	publish(1)
+	<-pool.published
+	if len(pool.published) != 0 || renoter.Descriptor("").Attribution {
+		t.Fatal("final events should not be labelled by default")
+	}
+
+	renoter.SetAttribution(true)
+	if !renoter.Descriptor("").Attribution {
+		t.Error("Descriptor() should announce attribution")
+	}
+	event := publish(1)
+	<-pool.published
+	if len(pool.published) != 1 {
+		t.Fatalf("published %d events after the final event, want its label", len(pool.published))
+	}
+	label := <-pool.published
+	if label.Kind != labelKind || label.PubKey != renoter.PublicKey || label.Tags.FindWithValue("e", event.ID) == nil ||
+		label.Tags.FindWithValue("L", AttributionNamespace) == nil || label.Tags.FindWithValue("l", AttributionLabel) == nil {
+		t.Errorf("label = %+v", label)
+	}
+	if valid, err := label.CheckSignature(); err != nil || !valid {
+		t.Errorf("label signature invalid: %v", err)
+	}
+
+	// Ephemeral events are not stored, so they are not labelled
+	publish(20001)
+	<-pool.published
+	if len(pool.published) != 0 {
+		t.Error("ephemeral events should not be labelled")
+	}
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/girino/renoter/internal/padding"
+	"github.com/girino/renoter/internal/storage"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// AuditEntry records one final event published as exit, without its content or ID.
+type AuditEntry struct {
+	// When the event was published
+	PublishedAt time.Time `json:"published_at"`
+	// Kind of the event
+	Kind int `json:"kind"`
+	// Serialized size of the event in bytes
+	Size int `json:"size"`
+	// Hash of the event ID (see AuditHash)
+	EventHash string `json:"event_hash"`
+	// Relays that accepted the event
+	Relays int `json:"relays"`
+}
+
+// AuditHash returns the hex SHA-256 of an event ID. The audit log keeps only this, so it
+// answers whether a given event was published without listing the events themselves.
+func AuditHash(eventID string) string {
+	raw, err := hex.DecodeString(eventID)
+	if err != nil {
+		raw = []byte(eventID)
+	}
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// AuditStorage returns the storage options the audit log is kept with: its time field and table.
+func AuditStorage(opts storage.Options) storage.Options {
+	opts.TimeField, opts.Table = "published_at", "audit"
+	return opts
+}
+
+// SetAuditLog records every final event this Renoter publishes as exit in a local JSONL file:
+// its kind, size, the hash of its ID and when it was published, never its content or author.
+// The file is rotated to path.1, path.2, ... when it would grow beyond maxBytes (0 = never),
+// keeping keep rotated files. An empty path disables the audit log.
+func (r *Renoter) SetAuditLog(path string, maxBytes int64, keep int) error {
+	if path == "" {
+		r.SetAuditStorage(nil)
+		return nil
+	}
+	store, err := storage.OpenFile(path, AuditStorage(storage.Options{MaxBytes: maxBytes, Keep: keep}))
+	if err != nil {
+		logging.Error("server.audit.SetAuditLog: %v", err)
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	r.SetAuditStorage(store)
+	logging.Info("server.audit.SetAuditLog: Recording published final events in %s (rotating at %d bytes, keeping %d files)", path, maxBytes, keep)
+	return nil
+}
+
+// SetAuditStorage records the audit log in store, opened with the options of AuditStorage
+// (nil disables it).
+func (r *Renoter) SetAuditStorage(store storage.Storage) {
+	r.audit = store
+}
+
+// CloseAuditLog closes the audit log, if any.
+func (r *Renoter) CloseAuditLog() error {
+	if r.audit == nil {
+		return nil
+	}
+	return r.audit.Close()
+}
+
+// recordAudit appends a published final event to the audit log, if any. Failures are only
+// logged: the event is out already.
+func (r *Renoter) recordAudit(event *nostr.Event, relays int) {
+	if r.audit == nil {
+		return
+	}
+	serialized, _ := padding.Marshal(event)
+	entry := AuditEntry{
+		PublishedAt: time.Now().UTC(),
+		Kind:        event.Kind,
+		Size:        len(serialized),
+		EventHash:   AuditHash(event.ID),
+		Relays:      relays,
+	}
+	if err := r.audit.Append(entry.PublishedAt, entry); err != nil {
+		logging.Error("server.audit.recordAudit: %v", err)
+	}
+}
+
+// LookupAudit returns the audit entries of eventID in the audit log at path and its keep rotated
+// files, oldest first. path may also be a storage spec such as "sqlite:audit.db".
+func LookupAudit(path, eventID string, keep int) ([]AuditEntry, error) {
+	hash := AuditHash(eventID)
+	var entries []AuditEntry
+	err := storage.Scan(path, AuditStorage(storage.Options{Keep: keep}), time.Time{}, time.Time{}, func(line []byte) {
+		var entry AuditEntry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			logging.Warn("server.audit.LookupAudit: skipping malformed entry in %s: %v", path, err)
+			return
+		}
+		if entry.EventHash == hash {
+			entries = append(entries, entry)
+		}
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	return entries, nil
+}
@@ -0,0 +1,55 @@
+package server
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestPublishFinal_RecordsAudit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pool := &fakePool{published: make(chan nostr.Event, 10)}
+	renoter, err := NewRenoterWithPool(ctx, nostr.GeneratePrivateKey(), []string{"wss://relay.example.com"}, pool)
+	if err != nil {
+		t.Fatalf("NewRenoterWithPool() error = %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	if err := renoter.SetAuditLog(path, 0, 0); err != nil {
+		t.Fatalf("SetAuditLog() error = %v", err)
+	}
+
+	event := &nostr.Event{Kind: 1, Content: "secret note", CreatedAt: nostr.Now(), Tags: nostr.Tags{}}
+	event.Sign(nostr.GeneratePrivateKey())
+	if err := renoter.publishFinal(ctx, event); err != nil {
+		t.Fatalf("publishFinal() error = %v", err)
+	}
+	renoter.CloseAuditLog()
+
+	// The log holds neither the content, the author nor the ID itself
+	data, _ := os.ReadFile(path)
+	for _, secret := range []string{event.Content, event.PubKey, event.ID} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("audit log contains %q: %s", secret, data)
+		}
+	}
+
+	entries, err := LookupAudit(path, event.ID, 0)
+	if err != nil {
+		t.Fatalf("LookupAudit() error = %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("LookupAudit() = %d entries, want 1", len(entries))
+	}
+	if entry := entries[0]; entry.Kind != 1 || entry.Size == 0 || entry.Relays != 1 || entry.PublishedAt.IsZero() {
+		t.Errorf("audit entry = %+v", entry)
+	}
+	if entries, _ := LookupAudit(path, strings.Repeat("0", 64), 0); len(entries) != 0 {
+		t.Errorf("LookupAudit() of an unknown event = %+v, want none", entries)
+	}
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"hash/maphash"
+	"sync/atomic"
+)
+
+// Bloom filter parameters: 10 bits per entry and 7 hash functions give about 1% false positives
+// at full capacity.
+const (
+	bloomBitsPerEntry = 10
+	bloomHashes       = 7
+)
+
+// bloomFilter is a Bloom filter over IDs that can be queried without locks while IDs are added.
+// It never forgets an ID, so it is rotated (rebuilt from the live IDs) once it has taken in
+// its capacity.
+type bloomFilter struct {
+	seed  maphash.Seed
+	words []atomic.Uint64
+	// Number of IDs added, and the number after which the filter should be rotated
+	added    atomic.Int64
+	capacity int64
+}
+
+// newBloomFilter creates an empty filter sized for capacity IDs.
+func newBloomFilter(capacity int) *bloomFilter {
+	capacity = max(capacity, 1)
+	bits := capacity * bloomBitsPerEntry
+	return &bloomFilter{
+		seed:     maphash.MakeSeed(),
+		words:    make([]atomic.Uint64, (bits+63)/64),
+		capacity: int64(capacity),
+	}
+}
+
+// positions calls fn with each bit position of id (double hashing of a 64-bit hash).
+func (f *bloomFilter) positions(id string, fn func(word int, mask uint64) bool) bool {
+	h := maphash.String(f.seed, id)
+	h1, h2 := uint32(h), uint32(h>>32)|1
+	bits := uint32(len(f.words) * 64)
+	for i := uint32(0); i < bloomHashes; i++ {
+		bit := (h1 + i*h2) % bits
+		if !fn(int(bit/64), 1<<(bit%64)) {
+			return false
+		}
+	}
+	return true
+}
+
+// add records id.
+func (f *bloomFilter) add(id string) {
+	f.positions(id, func(word int, mask uint64) bool {
+		f.words[word].Or(mask)
+		return true
+	})
+	f.added.Add(1)
+}
+
+// mayContain reports whether id may have been added. False means it certainly was not.
+func (f *bloomFilter) mayContain(id string) bool {
+	return f.positions(id, func(word int, mask uint64) bool {
+		return f.words[word].Load()&mask != 0
+	})
+}
+
+// full reports whether the filter has taken in its capacity and should be rotated.
+func (f *bloomFilter) full() bool {
+	return f.added.Load() >= f.capacity
+}
@@ -0,0 +1,62 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestBloomFilter_NoFalseNegatives(t *testing.T) {
+	filter := newBloomFilter(1000)
+	for i := 0; i < 1000; i++ {
+		filter.add(fmt.Sprintf("added-%d", i))
+	}
+	if !filter.full() {
+		t.Error("full() should be true after adding the capacity")
+	}
+
+	falsePositives := 0
+	for i := 0; i < 1000; i++ {
+		if !filter.mayContain(fmt.Sprintf("added-%d", i)) {
+			t.Fatalf("mayContain() = false for an added ID")
+		}
+		if filter.mayContain(fmt.Sprintf("other-%d", i)) {
+			falsePositives++
+		}
+	}
+	// About 1% expected at capacity; allow generous slack
+	if falsePositives > 50 {
+		t.Errorf("%d/1000 false positives, want about 1%%", falsePositives)
+	}
+}
+
+func TestEventCache_FilterRotationKeepsLiveEntries(t *testing.T) {
+	cache := NewEventCache(10, time.Hour)
+	now := time.Now()
+
+	// Enough inserts to rotate the filter several times while pruning keeps the cache small
+	for i := 0; i < 100; i++ {
+		if cache.CheckAndMark(fmt.Sprintf("event-%d", i), now) {
+			t.Fatalf("CheckAndMark() = true for new event %d", i)
+		}
+	}
+	if cache.Reserve("reserved", now) {
+		t.Fatal("Reserve() = true for a new event")
+	}
+	for i := 0; i < 30; i++ {
+		cache.CheckAndMark(fmt.Sprintf("more-%d", i), now)
+	}
+
+	var cached []string
+	for eventID := range cache.eventStore {
+		cached = append(cached, eventID)
+	}
+	for _, eventID := range cached {
+		if !cache.CheckAndMark(eventID, now) {
+			t.Errorf("CheckAndMark() = false for cached event %s after rotation", eventID)
+		}
+	}
+	if !cache.Reserve("reserved", now) {
+		t.Error("Reserve() = false for a reservation made before rotation")
+	}
+}
@@ -1,135 +1,453 @@
 package server
 
 import (
-	"sort"
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/girino/nostr-lib/logging"
 )
 
 // EventCache maintains a bounded in-memory cache of event IDs for replay attack protection.
-// The cache is limited to a maximum size and automatically prunes entries older than the cutoff duration.
+// The cache is limited to a maximum size, and entries older than the cutoff duration are ignored
+// by lookups and removed by Cleanup, which a Renoter runs in the background. A Bloom filter in
+// front of the exact maps answers the common "never seen" lookup without taking the lock; its
+// false positives fall back to the maps under the read lock. Marking takes the write lock only
+// for the insert, and for pruning the oldest quarter when the cache is full.
 type EventCache struct {
 	// Map event ID to when it was first seen
 	eventStore map[string]time.Time
-	// Track insertion order for pruning
-	eventKeys []string
+	// The IDs of eventStore by when they were seen, for expiring and pruning
+	wheel *timeWheel
 	// Mutex for thread-safe access
 	mu sync.RWMutex
 	// Maximum cache size
 	maxSize int
+	// Fraction of maxSize pruned, oldest first, when the cache is full
+	pruneFraction float64
 	// Maximum age for cached entries (older entries are removed)
 	cutoffDuration time.Duration
+	// IDs reserved by Reserve and not yet confirmed or released, with when they were reserved
+	provisional map[string]time.Time
+	// Filter holding every ID in eventStore and provisional (and some removed since), replaced
+	// by a fresh one built from the live IDs once full
+	filter atomic.Pointer[bloomFilter]
+
+	// Optional file every marked ID is appended to, so the cache survives restarts (see OpenEventCache)
+	path    string
+	file    *os.File
+	appends int
 }
 
 // NewEventCache creates a new EventCache with the specified maximum size and cutoff duration.
-// Entries older than cutoffDuration will be automatically removed during cleanup.
+// Entries older than cutoffDuration are no longer reported as seen, and are removed by Cleanup.
 func NewEventCache(maxSize int, cutoffDuration time.Duration) *EventCache {
-	return &EventCache{
+	c := &EventCache{
 		eventStore:     make(map[string]time.Time),
-		eventKeys:      make([]string, 0, maxSize+100), // Pre-allocate slightly more to reduce reallocations
+		wheel:          newTimeWheel(cutoffDuration),
 		maxSize:        maxSize,
+		pruneFraction:  defaultPruneFraction,
 		cutoffDuration: cutoffDuration,
+		provisional:    make(map[string]time.Time),
 	}
+	c.filter.Store(newBloomFilter(2 * maxSize))
+	return c
 }
 
+// defaultPruneFraction is the fraction of a full cache pruned to make room, see SetLimits.
+const defaultPruneFraction = 0.25
+
+// cacheCleanupInterval is how often runCacheCleanup removes expired entries. Lookups ignore them
+// already, so this only bounds how long they take up memory.
+const cacheCleanupInterval = time.Minute
+
+// provisionalTimeout is how long a reservation holds an ID if it is never confirmed or released
+// (e.g. processing got stuck), after which the ID may be processed again.
+const provisionalTimeout = 5 * time.Minute
+
 // CheckAndMark checks if an event ID has been seen before and marks it as seen.
 // Returns true if the event was already seen (replay attack), false otherwise.
 // The event is marked as seen with the current timestamp.
 func (c *EventCache) CheckAndMark(eventID string, now time.Time) bool {
+	if seen, _ := c.seenFast(eventID, now); seen {
+		logging.Warn("server.cache.CheckAndMark: Replay attack detected, event %s already processed", eventID)
+		return true
+	}
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	// Check if event already exists
+	if c.seenLocked(eventID, now) {
+		logging.Warn("server.cache.CheckAndMark: Replay attack detected, event %s already processed", eventID)
+		return true
+	}
+
 	// Prune cache if it's at or exceeds max size (remove 25% for performance)
-	// Do this first since it's less resource intensive
 	// We prune at maxSize (not just above) to ensure there's room for the new event we'll add
-	if len(c.eventKeys) >= c.maxSize {
-		logging.DebugMethod("server.cache", "CheckAndMark", "Cache at max size (%d), pruning...", len(c.eventKeys))
+	if len(c.eventStore) >= c.maxSize {
+		logging.DebugMethod("server.cache", "CheckAndMark", "Cache at max size (%d), pruning...", len(c.eventStore))
 		c.pruneLocked()
 	}
+	c.insertLocked(eventID, now)
+	return false
+}
 
-	// Clean up old events (binary search, more resource intensive)
-	logging.DebugMethod("server.cache", "CheckAndMark", "Cleaning up old events (cache size: %d)...", len(c.eventKeys))
-	c.cleanupOldEventsLocked(now)
-	logging.DebugMethod("server.cache", "CheckAndMark", "Cleanup complete (cache size: %d)", len(c.eventKeys))
+// seenLocked reports whether an ID is marked and within the cutoff. An expired mark still in the
+// maps is overwritten when the ID is marked again. Must be called with mu locked.
+func (c *EventCache) seenLocked(eventID string, now time.Time) bool {
+	seenAt, exists := c.eventStore[eventID]
+	return exists && now.Sub(seenAt) <= c.cutoffDuration
+}
 
-	// Check if event already exists
-	if _, exists := c.eventStore[eventID]; exists {
-		logging.Warn("server.cache: Replay attack detected, event %s already processed", eventID)
+// seenFast reports whether an ID is certainly marked, or else reserved, checking the filter
+// without locking and confirming its hits under the read lock only. False for both means the
+// caller must still check under the write lock before marking the ID.
+func (c *EventCache) seenFast(eventID string, now time.Time) (seen, reserved bool) {
+	if !c.filter.Load().mayContain(eventID) {
+		return false, false
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if seenAt, exists := c.eventStore[eventID]; exists && now.Sub(seenAt) <= c.cutoffDuration {
+		return true, false
+	}
+	reservedAt, exists := c.provisional[eventID]
+	return false, exists && now.Sub(reservedAt) < provisionalTimeout
+}
+
+// Reserve provisionally marks an event ID as seen while it is being processed. Returns true if
+// the ID was already seen or is reserved by another caller, false otherwise; callers report it.
+// The reservation must be settled with Confirm once processing succeeds, or with Release if it
+// fails, so a copy delivered again after a failure is not taken for a replay.
+func (c *EventCache) Reserve(eventID string, now time.Time) bool {
+	seen, reserved := c.seenFast(eventID, now)
+	if seen {
+		logging.Warn("server.cache.Reserve: Replay attack detected, event %s already processed", eventID)
+		return true
+	}
+	if reserved {
+		logging.DebugMethod("server.cache", "Reserve", "Event %s already being processed", eventID)
 		return true
 	}
 
-	// Mark event as seen
-	c.eventStore[eventID] = now
-	c.eventKeys = append(c.eventKeys, eventID)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.seenLocked(eventID, now) {
+		logging.Warn("server.cache.Reserve: Replay attack detected, event %s already processed", eventID)
+		return true
+	}
+	if reservedAt, exists := c.provisional[eventID]; exists && now.Sub(reservedAt) < provisionalTimeout {
+		logging.DebugMethod("server.cache", "Reserve", "Event %s already being processed", eventID)
+		return true
+	}
 
+	// Stale reservations are swept by Cleanup, and here only when there are many; they expire by
+	// themselves anyway
+	if len(c.provisional) >= c.maxSize {
+		c.sweepProvisionalLocked(now)
+	}
+	c.provisional[eventID] = now
+	c.rememberLocked(eventID)
 	return false
 }
 
-// cleanupOldEvents removes events older than the cutoff duration from the cache.
-// Since events are added sequentially and ordered by timestamp, we use binary search
-// to find the cutoff point efficiently.
+// Confirm turns the reservation of an event ID into a permanent mark. It does nothing if the ID
+// is not reserved.
+func (c *EventCache) Confirm(eventID string, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, exists := c.provisional[eventID]; !exists {
+		return
+	}
+	delete(c.provisional, eventID)
+	c.markLocked(eventID, now)
+}
+
+// Release drops the reservation of an event ID, so it may be processed again.
+func (c *EventCache) Release(eventID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.provisional, eventID)
+}
+
+// SetCutoff changes how long marked IDs are kept. Entries older than the new cutoff are removed
+// at the next cleanup.
+func (c *EventCache) SetCutoff(cutoffDuration time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cutoffDuration = cutoffDuration
+
+	// Rebuild the wheel with slots sized for the new cutoff
+	wheel := newTimeWheel(cutoffDuration)
+	c.wheel.each(func(eventID string, index int64) {
+		if seenAt, exists := c.eventStore[eventID]; exists && c.wheel.slotOf(seenAt) == index {
+			wheel.add(eventID, seenAt)
+		}
+	})
+	c.wheel = wheel
+}
+
+// SetLimits changes the maximum size of the cache and the fraction of it pruned, oldest first,
+// when it is full. A cache above the new size is pruned right away.
+func (c *EventCache) SetLimits(maxSize int, pruneFraction float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxSize, c.pruneFraction = maxSize, pruneFraction
+	for len(c.eventStore) > c.maxSize {
+		c.pruneLocked()
+	}
+	c.rotateFilterLocked()
+}
+
+// OpenEventCache creates an EventCache backed by file: the entries in it that are still within
+// cutoffDuration are loaded, and every ID marked afterwards is appended to it. The file is
+// compacted when opened and whenever it has grown to twice maxSize lines.
+func OpenEventCache(file string, maxSize int, cutoffDuration time.Duration) (*EventCache, error) {
+	c := NewEventCache(maxSize, cutoffDuration)
+	c.path = file
+
+	data, err := os.ReadFile(file)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read cache file: %w", err)
+	}
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for scanner.Scan() {
+		// Each line is "<id> <unix seconds>", oldest first
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		seconds, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		if _, exists := c.eventStore[fields[0]]; !exists {
+			c.addLocked(fields[0], time.Unix(seconds, 0))
+		}
+	}
+	c.cleanupOldEventsLocked(time.Now())
+	for len(c.eventStore) > c.maxSize {
+		c.pruneLocked()
+	}
+	c.rotateFilterLocked()
+
+	if err := c.compactLocked(); err != nil {
+		return nil, err
+	}
+	logging.Info("server.cache.OpenEventCache: Loaded %d entries from %s", len(c.eventStore), file)
+	return c, nil
+}
+
+// Contains reports whether an ID is in the cache, without marking it.
+func (c *EventCache) Contains(eventID string) bool {
+	if !c.filter.Load().mayContain(eventID) {
+		return false
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	seenAt, exists := c.eventStore[eventID]
+	return exists && time.Since(seenAt) <= c.cutoffDuration
+}
+
+// Mark records an ID as seen, for callers that check with Contains and mark only once the
+// event has been handled.
+func (c *EventCache) Mark(eventID string, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.markLocked(eventID, now)
+}
+
+// markLocked records an ID as seen, pruning the cache first if needed.
 // Must be called with mu locked.
-func (c *EventCache) cleanupOldEventsLocked(now time.Time) {
-	if len(c.eventKeys) == 0 {
-		logging.DebugMethod("server.cache", "cleanupOldEventsLocked", "Cache is empty, skipping cleanup")
+func (c *EventCache) markLocked(eventID string, now time.Time) {
+	if c.seenLocked(eventID, now) {
 		return
 	}
+	if len(c.eventStore) >= c.maxSize {
+		c.pruneLocked()
+	}
+	c.insertLocked(eventID, now)
+}
 
-	initialSize := len(c.eventKeys)
-	cutoffTime := now.Add(-c.cutoffDuration)
-	logging.DebugMethod("server.cache", "cleanupOldEventsLocked", "Starting cleanup: cache size=%d, cutoff duration=%v, cutoff time=%v", initialSize, c.cutoffDuration, cutoffTime)
+// Cleanup removes the entries older than the cutoff duration and the reservations never settled,
+// keeping that work off the CheckAndMark and Reserve hot path. See runCacheCleanup.
+func (c *EventCache) Cleanup(now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cleanupOldEventsLocked(now)
+	c.sweepProvisionalLocked(now)
+}
+
+// sweepProvisionalLocked drops the reservations older than provisionalTimeout.
+// Must be called with mu locked.
+func (c *EventCache) sweepProvisionalLocked(now time.Time) {
+	for id, reservedAt := range c.provisional {
+		if now.Sub(reservedAt) >= provisionalTimeout {
+			delete(c.provisional, id)
+		}
+	}
+}
+
+// Close closes the backing file of a cache opened with OpenEventCache.
+func (c *EventCache) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.file == nil {
+		return nil
+	}
+	err := c.file.Close()
+	c.file = nil
+	return err
+}
 
-	// Binary search to find the first index where timestamp is >= cutoffTime
-	// (i.e., first event newer than cutoffDuration ago)
-	// We want to keep all events from this index onwards
-	firstNewIndex := sort.Search(len(c.eventKeys), func(i int) bool {
-		eventID := c.eventKeys[i]
-		if seenTime, exists := c.eventStore[eventID]; exists {
-			return seenTime.After(cutoffTime) || seenTime.Equal(cutoffTime)
+// insertLocked adds an ID and appends it to the backing file, if any.
+// Must be called with mu locked.
+func (c *EventCache) insertLocked(eventID string, now time.Time) {
+	c.addLocked(eventID, now)
+	c.rememberLocked(eventID)
+	if c.file == nil {
+		return
+	}
+	if c.appends >= 2*c.maxSize {
+		if err := c.compactLocked(); err != nil {
+			logging.Warn("server.cache.insertLocked: %v", err)
 		}
-		// If event doesn't exist in map, treat it as old to remove
+		return
+	}
+	if _, err := fmt.Fprintf(c.file, "%s %d\n", eventID, now.Unix()); err != nil {
+		logging.Warn("server.cache.insertLocked: failed to persist %s: %v", eventID, err)
+	}
+	c.appends++
+}
+
+// addLocked records an ID seen at t in the maps and the wheel. Must be called with mu locked.
+func (c *EventCache) addLocked(eventID string, t time.Time) {
+	c.eventStore[eventID] = t
+	c.wheel.add(eventID, t)
+}
+
+// dropLocked removes an ID from the wheel bucket of slot index from the maps, unless it has been
+// marked again since and so lives in a later bucket. It reports whether it was removed.
+// Must be called with mu locked.
+func (c *EventCache) dropLocked(eventID string, index int64) bool {
+	seenAt, exists := c.eventStore[eventID]
+	if !exists || c.wheel.slotOf(seenAt) != index {
 		return false
-	})
+	}
+	delete(c.eventStore, eventID)
+	return true
+}
+
+// rememberLocked adds an ID to the filter, rotating it first if it is full.
+// Must be called with mu locked.
+func (c *EventCache) rememberLocked(eventID string) {
+	if c.filter.Load().full() {
+		c.rotateFilterLocked()
+	}
+	c.filter.Load().add(eventID)
+}
 
-	// Remove all events before firstNewIndex (older than cutoff duration)
-	if firstNewIndex > 0 {
-		removedCount := firstNewIndex
-		logging.DebugMethod("server.cache", "cleanupOldEventsLocked", "Binary search found cutoff at index %d (will remove %d old entries)", firstNewIndex, removedCount)
-		for i := 0; i < firstNewIndex; i++ {
-			delete(c.eventStore, c.eventKeys[i])
+// rotateFilterLocked replaces the filter with a fresh one holding only the live IDs, shedding
+// the IDs removed since the last rotation. Must be called with mu locked.
+func (c *EventCache) rotateFilterLocked() {
+	live := len(c.eventStore) + len(c.provisional)
+	filter := newBloomFilter(max(2*c.maxSize, 2*live))
+	for eventID := range c.eventStore {
+		filter.add(eventID)
+	}
+	for eventID := range c.provisional {
+		filter.add(eventID)
+	}
+	c.filter.Store(filter)
+	logging.DebugMethod("server.cache", "rotateFilterLocked", "Rotated Bloom filter with %d live entries", live)
+}
+
+// compactLocked rewrites the backing file with the current entries and reopens it for appending.
+// Must be called with mu locked.
+func (c *EventCache) compactLocked() error {
+	var b strings.Builder
+	c.wheel.each(func(eventID string, index int64) {
+		if seenAt, exists := c.eventStore[eventID]; exists && c.wheel.slotOf(seenAt) == index {
+			fmt.Fprintf(&b, "%s %d\n", eventID, seenAt.Unix())
 		}
-		// Keep only events from firstNewIndex onwards
-		c.eventKeys = c.eventKeys[firstNewIndex:]
-		logging.DebugMethod("server.cache", "cleanupOldEventsLocked", "Cleanup complete: removed %d events older than cutoff duration, cache size %d -> %d", removedCount, initialSize, len(c.eventKeys))
-	} else {
-		logging.DebugMethod("server.cache", "cleanupOldEventsLocked", "No old events to remove (all events are within cutoff duration)")
+	})
+	tmp := c.path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(b.String()), 0o600); err != nil {
+		return fmt.Errorf("failed to write cache file: %w", err)
+	}
+	if err := os.Rename(tmp, c.path); err != nil {
+		return fmt.Errorf("failed to replace cache file: %w", err)
 	}
+	if c.file != nil {
+		c.file.Close()
+	}
+	file, err := os.OpenFile(c.path, os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		c.file = nil
+		return fmt.Errorf("failed to open cache file: %w", err)
+	}
+	c.file, c.appends = file, 0
+	return nil
+}
+
+// cleanupOldEventsLocked removes events older than the cutoff duration from the cache: the wheel
+// buckets of the slots that ended before the cutoff. Events in the slot of the cutoff itself are
+// kept until the next cleanup; lookups ignore them already. Must be called with mu locked.
+func (c *EventCache) cleanupOldEventsLocked(now time.Time) {
+	initialSize := len(c.eventStore)
+	c.wheel.expire(now.Add(-c.cutoffDuration), c.dropLocked)
+	logging.DebugMethod("server.cache", "cleanupOldEventsLocked", "Cleanup complete: removed %d events older than %v, cache size %d -> %d", initialSize-len(c.eventStore), c.cutoffDuration, initialSize, len(c.eventStore))
 }
 
-// pruneLocked removes 25% of the oldest entries when cache exceeds max size.
+// pruneLocked removes the pruneFraction of oldest entries when cache exceeds max size.
 // Must be called with mu locked.
 func (c *EventCache) pruneLocked() {
-	initialSize := len(c.eventKeys)
-	// Remove 25% of oldest entries
-	removeCount := c.maxSize / 4 // 25% of max cache size
+	initialSize := len(c.eventStore)
+	removeCount := int(float64(c.maxSize) * c.pruneFraction)
 	if removeCount == 0 {
 		removeCount = 1 // Ensure at least one is removed
 	}
-	logging.DebugMethod("server.cache", "pruneLocked", "Starting prune: cache size=%d, maxSize=%d, will remove %d entries (25%%)", initialSize, c.maxSize, removeCount)
-	for i := 0; i < removeCount; i++ {
-		oldestID := c.eventKeys[i]
-		delete(c.eventStore, oldestID)
-	}
-	// Keep only the recent entries
-	c.eventKeys = c.eventKeys[removeCount:]
-	logging.DebugMethod("server.cache", "pruneLocked", "Prune complete: removed %d oldest entries, cache size %d -> %d", removeCount, initialSize, len(c.eventKeys))
+	c.wheel.shift(removeCount, c.dropLocked)
+	logging.DebugMethod("server.cache", "pruneLocked", "Prune complete: removed %d oldest entries, cache size %d -> %d", initialSize-len(c.eventStore), initialSize, len(c.eventStore))
 }
 
 // Size returns the current number of entries in the cache.
 func (c *EventCache) Size() int {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
-	return len(c.eventKeys)
+	return len(c.eventStore)
+}
+
+// runCacheCleanup cleans up the replay, delivery and stamp caches every cacheCleanupInterval until
+// ctx is done, unless it is running already.
+func (r *Renoter) runCacheCleanup(ctx context.Context) {
+	if !r.cleaning.CompareAndSwap(false, true) {
+		return
+	}
+	r.life.run(ctx, func(ctx context.Context) {
+		defer r.cleaning.Store(false)
+		ticker := time.NewTicker(cacheCleanupInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				for _, cache := range []*EventCache{r.eventCache, r.deliveries, r.stamps} {
+					cache.Cleanup(now)
+				}
+				logging.DebugMethod("server.cache", "runCacheCleanup", "Cleaned up caches (replay cache size: %d)", r.eventCache.Size())
+			}
+		}
+	})
 }
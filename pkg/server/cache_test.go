@@ -2,6 +2,10 @@ package server
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -63,6 +67,48 @@ func TestEventCache_CheckAndMark(t *testing.T) {
 	}
 }
 
+func TestEventCache_ReserveConfirmRelease(t *testing.T) {
+	cache := NewEventCache(100, 1*time.Hour)
+	now := time.Now()
+
+	if cache.Reserve("event1", now) {
+		t.Fatal("Reserve() should return false for new event")
+	}
+	if !cache.Reserve("event1", now) {
+		t.Error("Reserve() should return true while the event is reserved")
+	}
+
+	// A released reservation may be taken again
+	cache.Release("event1")
+	if cache.Reserve("event1", now) {
+		t.Error("Reserve() should return false after Release()")
+	}
+
+	// A confirmed reservation is a permanent mark
+	cache.Confirm("event1", now)
+	if !cache.Contains("event1") || cache.Size() != 1 {
+		t.Errorf("Confirm() should mark the event, Contains() = %v, Size() = %d", cache.Contains("event1"), cache.Size())
+	}
+	cache.Release("event1")
+	if !cache.Reserve("event1", now) {
+		t.Error("Reserve() should return true for a confirmed event")
+	}
+
+	// Confirming an ID that was never reserved does nothing
+	cache.Confirm("event2", now)
+	if cache.Contains("event2") {
+		t.Error("Confirm() should ignore IDs that are not reserved")
+	}
+
+	// A reservation never settled expires
+	if cache.Reserve("event3", now) {
+		t.Fatal("Reserve() should return false for new event")
+	}
+	if cache.Reserve("event3", now.Add(provisionalTimeout)) {
+		t.Error("Reserve() should return false once the reservation expired")
+	}
+}
+
 func TestEventCache_CleanupOldEvents(t *testing.T) {
 	cache := NewEventCache(100, 1*time.Hour)
 	now := time.Now()
@@ -72,13 +118,11 @@ func TestEventCache_CleanupOldEvents(t *testing.T) {
 	cache.CheckAndMark("old1", oldTime)
 	cache.CheckAndMark("old2", oldTime)
 
-	// When we add new1, cleanup runs and should remove old1 and old2 (they're > 1 hour old)
-	// But cleanup runs BEFORE checking/marking, so old events are still there when we check
-	// The cleanup happens during CheckAndMark, so old events are removed
+	// Cleanup runs in the background, so old1 and old2 are still in the cache when new1 is added
 	cache.CheckAndMark("new1", now)
 
-	// Verify old events were cleaned up - they should NOT be detected as replays
-	// because they were removed during cleanup
+	// Verify old events are expired - they should NOT be detected as replays
+	// even before a cleanup removed them
 	if cache.CheckAndMark("old1", now) {
 		t.Error("Old event 'old1' should have been cleaned up")
 	}
@@ -92,6 +136,27 @@ func TestEventCache_CleanupOldEvents(t *testing.T) {
 	}
 }
 
+func TestEventCache_Cleanup(t *testing.T) {
+	cache := NewEventCache(100, time.Hour)
+	now := time.Now()
+
+	cache.CheckAndMark("old1", now.Add(-2*time.Hour))
+	cache.CheckAndMark("old2", now.Add(-2*time.Hour))
+	cache.CheckAndMark("new1", now)
+	cache.Reserve("stuck", now.Add(-provisionalTimeout))
+	if cache.Size() != 3 {
+		t.Fatalf("Cache size should be 3 before Cleanup(), got %d", cache.Size())
+	}
+
+	cache.Cleanup(now)
+	if cache.Size() != 1 || !cache.Contains("new1") {
+		t.Errorf("Cleanup() should keep only new1, size = %d", cache.Size())
+	}
+	if cache.Reserve("stuck", now) {
+		t.Error("Cleanup() should drop reservations never settled")
+	}
+}
+
 func TestEventCache_Pruning(t *testing.T) {
 	cache := NewEventCache(10, 2*time.Hour) // Small cache for testing
 	now := time.Now()
@@ -122,6 +187,25 @@ func TestEventCache_Pruning(t *testing.T) {
 	// exposing internal state, so we just verify the size is correct
 }
 
+func TestEventCache_SetLimits(t *testing.T) {
+	cache := NewEventCache(10, 2*time.Hour)
+	now := time.Now()
+	cache.SetLimits(10, 0.5)
+	for i := 0; i < 11; i++ {
+		cache.CheckAndMark(fmt.Sprintf("event%d", i), now)
+	}
+	// Half of the cache was pruned to make room for the eleventh event
+	if size := cache.Size(); size != 10-5+1 {
+		t.Errorf("Cache should be pruned to 6, got %d", size)
+	}
+
+	// Shrinking prunes right away
+	cache.SetLimits(4, 0.25)
+	if size := cache.Size(); size > 4 || !cache.Contains("event10") {
+		t.Errorf("Cache should keep at most 4 of the newest events, got %d", size)
+	}
+}
+
 func TestEventCache_Size(t *testing.T) {
 	cache := NewEventCache(100, 1*time.Hour)
 	now := time.Now()
@@ -138,3 +222,57 @@ func TestEventCache_Size(t *testing.T) {
 		t.Errorf("Cache size should be 5, got %d", cache.Size())
 	}
 }
+
+func TestOpenEventCache_Persists(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "cache.txt")
+	now := time.Now()
+
+	cache, err := OpenEventCache(file, 100, time.Hour)
+	if err != nil {
+		t.Fatalf("OpenEventCache() error = %v", err)
+	}
+	cache.Mark("old", now.Add(-2*time.Hour))
+	cache.Mark("recent", now)
+	if cache.CheckAndMark("checked", now) {
+		t.Error("CheckAndMark() reported a new ID as seen")
+	}
+	cache.Close()
+
+	reopened, err := OpenEventCache(file, 100, time.Hour)
+	if err != nil {
+		t.Fatalf("OpenEventCache() reopen error = %v", err)
+	}
+	defer reopened.Close()
+	if !reopened.Contains("recent") || !reopened.Contains("checked") {
+		t.Error("reopened cache lost marked IDs")
+	}
+	if reopened.Contains("old") || reopened.Size() != 2 {
+		t.Errorf("reopened cache kept expired entries (size %d)", reopened.Size())
+	}
+
+	// Compaction on open dropped the expired line from the file
+	data, _ := os.ReadFile(file)
+	if lines := strings.Count(string(data), "\n"); lines != 2 {
+		t.Errorf("cache file has %d lines after compaction, want 2", lines)
+	}
+}
+
+// BenchmarkEventCache_CheckAndMarkParallel measures the replay check of the handler hot path
+// under concurrency: mostly new IDs, with every fourth one a replay of an ID just marked.
+func BenchmarkEventCache_CheckAndMarkParallel(b *testing.B) {
+	cache := NewEventCache(5000, replayWindow)
+	var next atomic.Int64
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		last := ""
+		for i := 0; pb.Next(); i++ {
+			id := last
+			if i%4 != 3 || id == "" {
+				id = fmt.Sprintf("%064x", next.Add(1))
+			}
+			cache.CheckAndMark(id, time.Now())
+			last = id
+		}
+	})
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"sync"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// FinalHook inspects a final event the exit is about to publish, e.g. to apply a custom content
+// policy or mirror it to local storage. It gets a copy of the event, since the author's signature
+// forbids changing it, and may return annotation tags for it (nil for none). An error refuses
+// the event: it is not published and the container is rejected, or dropped silently if the error
+// wraps ErrDrop (see Drop).
+type FinalHook func(ctx context.Context, event *nostr.Event) (annotations nostr.Tags, err error)
+
+// DefaultAnnotationTags are the tags final hooks may annotate events with unless
+// SetAnnotationTags says otherwise: NIP-32 label namespaces and labels.
+var DefaultAnnotationTags = []string{"L", "l"}
+
+// finalHooks are the final hooks of a Renoter and the annotation tags they may return.
+type finalHooks struct {
+	mu    sync.RWMutex
+	hooks []FinalHook
+	// Names of the annotation tags published (nil = DefaultAnnotationTags)
+	allowed map[string]bool
+}
+
+// AddFinalHook adds a hook run on every final event before it is published, after the hooks
+// added before it. Hooks run once every pipeline stage has passed the container (including
+// StagePolicy, the role policy and the mixing delay) and the event is known not to have been
+// published yet, and before publication, the audit log, attribution and delivery to mentioned
+// pubkeys. The first hook refusing the event stops it. There are no hooks by default.
+func (r *Renoter) AddFinalHook(hook FinalHook) {
+	if hook == nil {
+		return
+	}
+	r.finalHooks.mu.Lock()
+	defer r.finalHooks.mu.Unlock()
+	r.finalHooks.hooks = append(r.finalHooks.hooks, hook)
+}
+
+// SetAnnotationTags sets the names of the annotation tags final hooks may return (none if called
+// without names). Others are left out with a warning. The annotations of an event are published
+// after it as one NIP-32 label signed with the Renoter's key, which, like attribution, tells
+// anyone which exit relayed the event.
+func (r *Renoter) SetAnnotationTags(names ...string) {
+	allowed := make(map[string]bool, len(names))
+	for _, name := range names {
+		allowed[name] = true
+	}
+	r.finalHooks.mu.Lock()
+	defer r.finalHooks.mu.Unlock()
+	r.finalHooks.allowed = allowed
+}
+
+// runFinalHooks runs the final hooks on event and returns the allowed annotation tags they
+// returned. A hook that panics refuses the event.
+func (r *Renoter) runFinalHooks(ctx context.Context, event *nostr.Event) (nostr.Tags, error) {
+	r.finalHooks.mu.RLock()
+	hooks := r.finalHooks.hooks
+	allowed := r.finalHooks.allowed
+	r.finalHooks.mu.RUnlock()
+	if allowed == nil {
+		allowed = make(map[string]bool, len(DefaultAnnotationTags))
+		for _, name := range DefaultAnnotationTags {
+			allowed[name] = true
+		}
+	}
+
+	var annotations nostr.Tags
+	for i, hook := range hooks {
+		tags, err := runFinalHook(ctx, hook, *event)
+		if err != nil {
+			if errors.Is(err, ErrDrop) {
+				logging.DebugMethod("server.finalhook", "runFinalHooks", "Final hook %d dropped event %s: %v", i+1, event.ID, err)
+				return nil, err
+			}
+			logging.Warn("server.finalhook.runFinalHooks: final hook %d refused event %s: %v", i+1, event.ID, err)
+			return nil, fmt.Errorf("final hook refused the event: %w", err)
+		}
+		for _, tag := range tags {
+			if len(tag) < 2 || !allowed[tag[0]] {
+				logging.Warn("server.finalhook.runFinalHooks: leaving out annotation %v of event %s, not an allowed annotation tag", tag, event.ID)
+				continue
+			}
+			annotations = append(annotations, tag)
+		}
+	}
+	return annotations, nil
+}
+
+// runFinalHook runs one hook on a copy of event, turning a panic into an error.
+func runFinalHook(ctx context.Context, hook FinalHook, event nostr.Event) (tags nostr.Tags, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("final hook panicked: %v", p)
+		}
+	}()
+	copied := make(nostr.Tags, len(event.Tags))
+	for i, tag := range event.Tags {
+		copied[i] = slices.Clone(tag)
+	}
+	event.Tags = copied
+	return hook(ctx, &event)
+}
+
+// annotate publishes the annotations of a published final event as a NIP-32 label to the relays
+// the event went to. Ephemeral events are not stored, so they get no label. Failures are only
+// logged: the event is out already.
+func (r *Renoter) annotate(ctx context.Context, event *nostr.Event, annotations nostr.Tags) {
+	if len(annotations) == 0 || nostr.IsEphemeralKind(event.Kind) {
+		return
+	}
+	label := nostr.Event{
+		Kind:      labelKind,
+		CreatedAt: nostr.Now(),
+		Tags:      append(nostr.Tags{{"e", event.ID}, {"k", strconv.Itoa(event.Kind)}}, annotations...),
+	}
+	if err := r.identity.SignEvent(ctx, &label); err != nil {
+		logging.Error("server.finalhook.annotate: failed to sign label: %v", err)
+		return
+	}
+	successCount := 0
+	relayURLs := r.finalRelayURLs()
+	for result := range r.forwarder.PublishMany(ctx, relayURLs, label) {
+		if result.Error != nil {
+			logging.Warn("server.finalhook.annotate: failed to publish annotations of %s to %s: %v", event.ID, result.RelayURL, result.Error)
+			continue
+		}
+		successCount++
+	}
+	logging.DebugMethod("server.finalhook", "annotate", "Published annotations %s of final event %s to %d/%d relays", label.ID, event.ID, successCount, len(relayURLs))
+}
@@ -0,0 +1,90 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestPublishFinal_FinalHooks(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pool := &fakePool{published: make(chan nostr.Event, 10)}
+	renoter, err := NewRenoterWithPool(ctx, nostr.GeneratePrivateKey(), []string{"wss://relay.example.com"}, pool)
+	if err != nil {
+		t.Fatalf("NewRenoterWithPool() error = %v", err)
+	}
+	newEvent := func(content string) *nostr.Event {
+		event := &nostr.Event{Kind: 1, Content: content, CreatedAt: nostr.Now(), Tags: nostr.Tags{{"t", "renoter"}}}
+		event.Sign(nostr.GeneratePrivateKey())
+		return event
+	}
+
+	var mirrored []string
+	allowSpam := false
+	renoter.AddFinalHook(func(ctx context.Context, event *nostr.Event) (nostr.Tags, error) {
+		mirrored = append(mirrored, event.ID)
+		// Changes to the copy never reach the published event
+		event.Tags[0][1] = "changed"
+		return nil, nil
+	})
+	renoter.AddFinalHook(func(ctx context.Context, event *nostr.Event) (nostr.Tags, error) {
+		switch event.Content {
+		case "spam":
+			if !allowSpam {
+				return nil, errors.New("looks like spam")
+			}
+		case "quiet":
+			return nil, Drop("filtered")
+		case "crash":
+			panic("hook bug")
+		}
+		return nostr.Tags{{"L", "app.example"}, {"l", "checked", "app.example"}, {"p", "not-allowed"}}, nil
+	})
+
+	event := newEvent("hello")
+	if err := renoter.publishFinal(ctx, event); err != nil {
+		t.Fatalf("publishFinal() error = %v", err)
+	}
+	published := <-pool.published
+	if published.ID != event.ID || published.Tags[0][1] != "renoter" {
+		t.Errorf("published %+v, want the event unchanged", published)
+	}
+	label := <-pool.published
+	if label.Kind != labelKind || label.PubKey != renoter.PublicKey || label.Tags.FindWithValue("e", event.ID) == nil ||
+		label.Tags.FindWithValue("l", "checked") == nil || label.Tags.Find("p") != nil {
+		t.Errorf("label = %+v, want the allowed annotations of the event", label)
+	}
+	if len(mirrored) != 1 || mirrored[0] != event.ID {
+		t.Errorf("mirrored = %v, want the event", mirrored)
+	}
+
+	for _, content := range []string{"spam", "crash"} {
+		err := renoter.publishFinal(ctx, newEvent(content))
+		if err == nil || errors.Is(err, ErrDrop) {
+			t.Errorf("publishFinal(%q) error = %v, want a refusal", content, err)
+		}
+	}
+	if err := renoter.publishFinal(ctx, newEvent("quiet")); !errors.Is(err, ErrDrop) {
+		t.Errorf("publishFinal() error = %v, want the hook's drop", err)
+	}
+	if len(pool.published) != 0 {
+		t.Errorf("published %d refused events", len(pool.published))
+	}
+
+	// A refused event is not marked as published, so it can pass once the hook allows it
+	renoter.SetAnnotationTags()
+	refused := newEvent("spam")
+	renoter.publishFinal(ctx, refused)
+	allowSpam = true
+	if err := renoter.publishFinal(ctx, refused); err != nil {
+		t.Fatalf("publishFinal() of a refused event error = %v", err)
+	}
+	<-pool.published
+	if len(pool.published) != 0 {
+		t.Error("annotations should not be published once no tag is allowed")
+	}
+}
@@ -0,0 +1,149 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/girino/renoter/internal/config"
+	"github.com/girino/renoter/internal/descriptor"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// GossipPolicy configures liveness gossip: this Renoter publishes a small signed heartbeat at
+// Interval, and republishes the heartbeats of its peers to its own relays as-is. A heartbeat only
+// speaks for the Renoter that signed it, so peers spread availability without vouching for
+// anyone, and clients find fresh heartbeats on their relays instead of probing every Renoter.
+type GossipPolicy struct {
+	// Interval this Renoter publishes its heartbeat at
+	Interval time.Duration
+	// Hex pubkeys of the Renoters whose heartbeats are republished (empty = publish our own only)
+	Peers []string
+	// Peer heartbeats older than this are not republished (0 = three intervals)
+	MaxAge time.Duration
+}
+
+// Validate checks that the policy is usable.
+func (p GossipPolicy) Validate() error {
+	if p.Interval < time.Second {
+		return fmt.Errorf("heartbeat interval must be at least a second, got %v", p.Interval)
+	}
+	if p.MaxAge < 0 {
+		return fmt.Errorf("maximum heartbeat age must not be negative, got %v", p.MaxAge)
+	}
+	for _, peer := range p.Peers {
+		if !nostr.IsValidPublicKey(peer) {
+			return fmt.Errorf("invalid peer pubkey %q", peer)
+		}
+	}
+	return nil
+}
+
+// gossip tracks the peer heartbeats already republished.
+type gossip struct {
+	policy GossipPolicy
+
+	mu sync.Mutex
+	// created_at of the newest heartbeat republished per peer
+	relayed map[string]nostr.Timestamp
+}
+
+// StartGossip publishes this Renoter's heartbeat now and then every policy.Interval until ctx is
+// done, and republishes the heartbeats of policy.Peers seen on its relays. Only the first
+// heartbeat's error is returned; gossip continues either way.
+func (r *Renoter) StartGossip(ctx context.Context, policy GossipPolicy) error {
+	if err := policy.Validate(); err != nil {
+		logging.Error("server.gossip.StartGossip: invalid gossip policy: %v", err)
+		return fmt.Errorf("invalid gossip policy: %w", err)
+	}
+	if policy.MaxAge == 0 {
+		policy.MaxAge = 3 * policy.Interval
+	}
+	r.gossip = &gossip{policy: policy, relayed: make(map[string]nostr.Timestamp)}
+	logging.Info("server.gossip.StartGossip: Publishing heartbeats every %v, relaying those of %d peers", policy.Interval, len(policy.Peers))
+
+	err := r.publishHeartbeat(ctx, descriptor.StatusOnline)
+	r.life.run(ctx, func(ctx context.Context) {
+		ticker := time.NewTicker(policy.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := r.publishHeartbeat(ctx, descriptor.StatusOnline); err != nil {
+					logging.Warn("server.gossip.StartGossip: failed to publish heartbeat: %v", err)
+				}
+			}
+		}
+	})
+
+	if len(policy.Peers) > 0 {
+		filter := nostr.Filter{Kinds: []int{config.HeartbeatKind}, Authors: policy.Peers}
+		r.life.run(ctx, func(ctx context.Context) {
+			for relayEvent := range r.pool.SubscribeMany(ctx, r.relayURLs, filter) {
+				r.relayHeartbeat(ctx, relayEvent.Event, time.Now())
+			}
+		})
+	}
+	return err
+}
+
+// publishHeartbeat signs a heartbeat with status and publishes it to all of this Renoter's relays.
+func (r *Renoter) publishHeartbeat(ctx context.Context, status string) error {
+	event := descriptor.Heartbeat{Status: status, Interval: r.gossip.policy.Interval}.Event()
+	if err := r.identity.SignEvent(ctx, &event); err != nil {
+		logging.Error("server.gossip.publishHeartbeat: failed to sign heartbeat: %v", err)
+		return fmt.Errorf("failed to sign heartbeat: %w", err)
+	}
+	if r.publishToRelays(ctx, event) == 0 {
+		return fmt.Errorf("no relay accepted the heartbeat")
+	}
+	logging.DebugMethod("server.gossip", "publishHeartbeat", "Published %s heartbeat %s", status, event.ID)
+	return nil
+}
+
+// relayHeartbeat republishes a peer's heartbeat received at now, unless it is invalid, stale, or
+// not newer than the last one republished for that peer. It reports whether it was republished.
+func (r *Renoter) relayHeartbeat(ctx context.Context, event *nostr.Event, now time.Time) bool {
+	g := r.gossip
+	if !slices.Contains(g.policy.Peers, event.PubKey) {
+		return false
+	}
+	beat, err := descriptor.ParseHeartbeat(event)
+	if err != nil {
+		logging.Warn("server.gossip.relayHeartbeat: ignoring invalid heartbeat %s: %v", event.ID, err)
+		return false
+	}
+	if now.Sub(beat.CreatedAt) > g.policy.MaxAge {
+		return false
+	}
+
+	g.mu.Lock()
+	if event.CreatedAt <= g.relayed[event.PubKey] {
+		g.mu.Unlock()
+		return false
+	}
+	g.relayed[event.PubKey] = event.CreatedAt
+	g.mu.Unlock()
+
+	accepted := r.publishToRelays(ctx, *event)
+	logging.DebugMethod("server.gossip", "relayHeartbeat", "Republished %s heartbeat of %s to %d/%d relays", beat.Status, event.PubKey[:16], accepted, len(r.relayURLs))
+	return true
+}
+
+// publishToRelays publishes event to all of this Renoter's relays and returns how many accepted it.
+func (r *Renoter) publishToRelays(ctx context.Context, event nostr.Event) int {
+	accepted := 0
+	for result := range r.forwarder.PublishMany(ctx, r.relayURLs, event) {
+		if result.Error != nil {
+			logging.DebugMethod("server.gossip", "publishToRelays", "Relay %s refused %s: %v", result.RelayURL, event.ID, result.Error)
+			continue
+		}
+		accepted++
+	}
+	return accepted
+}
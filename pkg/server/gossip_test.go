@@ -0,0 +1,114 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/girino/renoter/internal/descriptor"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestGossipPolicy_Validate(t *testing.T) {
+	pk, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	tests := []struct {
+		name    string
+		policy  GossipPolicy
+		wantErr bool
+	}{
+		{"own heartbeat only", GossipPolicy{Interval: time.Minute}, false},
+		{"peers", GossipPolicy{Interval: time.Minute, Peers: []string{pk}, MaxAge: time.Hour}, false},
+		{"sub-second interval", GossipPolicy{Interval: time.Millisecond}, true},
+		{"negative max age", GossipPolicy{Interval: time.Minute, MaxAge: -time.Second}, true},
+		{"npub peer", GossipPolicy{Interval: time.Minute, Peers: []string{"npub1xyz"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.policy.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestStartGossip(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	peerSK := nostr.GeneratePrivateKey()
+	peer, _ := nostr.GetPublicKey(peerSK)
+	pool := &fakePool{events: make(chan nostr.RelayEvent, 1), published: make(chan nostr.Event, 4)}
+	renoter, err := NewRenoterWithPool(ctx, nostr.GeneratePrivateKey(), []string{"wss://relay.example.com"}, pool)
+	if err != nil {
+		t.Fatalf("NewRenoterWithPool() error = %v", err)
+	}
+	if err := renoter.StartGossip(ctx, GossipPolicy{Interval: time.Hour, Peers: []string{peer}}); err != nil {
+		t.Fatalf("StartGossip() error = %v", err)
+	}
+
+	own := <-pool.published
+	beat, err := descriptor.ParseHeartbeat(&own)
+	if err != nil || beat.PubKey != renoter.PublicKey || beat.Status != descriptor.StatusOnline || beat.Interval != time.Hour {
+		t.Fatalf("published heartbeat = %+v, %v", beat, err)
+	}
+
+	// A peer's heartbeat seen on our relays is republished unchanged
+	peerBeat := descriptor.Heartbeat{Status: descriptor.StatusOnline, Interval: time.Minute}.Event()
+	peerBeat.Sign(peerSK)
+	pool.events <- nostr.RelayEvent{Event: &peerBeat}
+	select {
+	case got := <-pool.published:
+		if got.ID != peerBeat.ID || got.Sig != peerBeat.Sig {
+			t.Errorf("republished %s, want the peer's heartbeat %s", got.ID, peerBeat.ID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("peer heartbeat was not republished")
+	}
+}
+
+func TestRelayHeartbeat(t *testing.T) {
+	ctx := context.Background()
+	peerSK, otherSK := nostr.GeneratePrivateKey(), nostr.GeneratePrivateKey()
+	peer, _ := nostr.GetPublicKey(peerSK)
+	pool := &fakePool{published: make(chan nostr.Event, 8)}
+	renoter, err := NewRenoterWithPool(ctx, nostr.GeneratePrivateKey(), []string{"wss://relay.example.com"}, pool)
+	if err != nil {
+		t.Fatalf("NewRenoterWithPool() error = %v", err)
+	}
+	renoter.gossip = &gossip{policy: GossipPolicy{Interval: time.Minute, Peers: []string{peer}, MaxAge: 3 * time.Minute}, relayed: make(map[string]nostr.Timestamp)}
+
+	now := time.Now()
+	beat := func(sk string, age time.Duration) *nostr.Event {
+		event := descriptor.Heartbeat{Status: descriptor.StatusOnline, Interval: time.Minute}.Event()
+		event.CreatedAt = nostr.Timestamp(now.Add(-age).Unix())
+		event.Sign(sk)
+		return &event
+	}
+	fresh := beat(peerSK, time.Minute)
+	tampered := beat(peerSK, 0)
+	tampered.Tags[0][1] = descriptor.StatusOffline
+
+	tests := []struct {
+		name  string
+		event *nostr.Event
+		want  bool
+	}{
+		{"fresh peer heartbeat", fresh, true},
+		{"same heartbeat again", fresh, false},
+		{"older heartbeat", beat(peerSK, 2*time.Minute), false},
+		{"stale heartbeat", beat(peerSK, time.Hour), false},
+		{"not a peer", beat(otherSK, 0), false},
+		{"altered heartbeat", tampered, false},
+		{"newer heartbeat", beat(peerSK, 0), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := renoter.relayHeartbeat(ctx, tt.event, now); got != tt.want {
+				t.Errorf("relayHeartbeat() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	if len(pool.published) != 2 {
+		t.Errorf("published %d heartbeats, want 2", len(pool.published))
+	}
+}
@@ -2,362 +2,382 @@ package server
 
 import (
 	"context"
-	"crypto/rand"
-	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/girino/nostr-lib/logging"
+	"github.com/girino/renoter/internal/compact"
 	"github.com/girino/renoter/internal/config"
+	"github.com/girino/renoter/internal/padding"
+	"github.com/girino/renoter/internal/schema"
 	"github.com/nbd-wtf/go-nostr"
 	"github.com/nbd-wtf/go-nostr/nip13"
 	"github.com/nbd-wtf/go-nostr/nip44"
 )
 
-// padEventToExactSize is a helper function to pad events to exact size (same as client version).
-func padEventToExactSize(event *nostr.Event, targetSize int) (*nostr.Event, error) {
-	// Create a copy to avoid modifying the original
-	paddedEvent := *event
-	if paddedEvent.Tags == nil {
-		paddedEvent.Tags = nostr.Tags{}
-	}
-
-	// Serialize event to get current size (without padding)
-	eventJSON, err := json.Marshal(&paddedEvent)
-	if err != nil {
-		return nil, fmt.Errorf("failed to serialize event for padding: %w", err)
-	}
-	currentSize := len(eventJSON)
+// HandleEvent handles a standardized wrapper event (29001) already accepted by ProcessEvent by
+// running it through the pipeline stages from StageRecipient on: checking it is addressed to us,
+// decrypting it, processing the inner 29000 event, and either re-wrapping or publishing the
+// final event. Dropped containers (e.g. addressed to another Renoter) are not an error; see
+// HandleEventOutcome for how the container ended.
+func (r *Renoter) HandleEvent(ctx context.Context, event *nostr.Event) error {
+	return r.HandleEventOutcome(ctx, event).Err
+}
 
-	// Calculate padding tag base size: ["padding",""]
-	testEventWithEmptyPadding := *event
-	if testEventWithEmptyPadding.Tags == nil {
-		testEventWithEmptyPadding.Tags = nostr.Tags{}
+// unwrapEvent decrypts a 29001 container and the 29000 inside it, returning the inner
+// event with padding removed and its ID and signature verified. It runs the pipeline stages
+// from StageRecipient up to StageForward.
+// Returns nil, nil if the container or the inner 29000 is addressed to another Renoter.
+func (r *Renoter) unwrapEvent(ctx context.Context, event *nostr.Event) (*nostr.Event, error) {
+	msg := &Message{Container: event, ReceivedAt: time.Now()}
+	if err := r.pipeline.run(ctx, msg, StageRecipient, StageForward); err != nil {
+		if errors.Is(err, ErrDrop) {
+			return nil, nil
+		}
+		return nil, err
 	}
-	testEventWithEmptyPadding.Tags = append(testEventWithEmptyPadding.Tags, nostr.Tag{"padding", ""})
-	testJSONWithEmptyPadding, _ := json.Marshal(&testEventWithEmptyPadding)
-	tagBaseSize := len(testJSONWithEmptyPadding) - currentSize
-	logging.DebugMethod("server.handler", "padEventToExactSize", "Padding tag base size: %d bytes", tagBaseSize)
-
-	// Calculate total size including padding tag base
-	totalSize := currentSize + tagBaseSize
+	return msg.Inner, nil
+}
 
-	// Check if base event is too large
-	if totalSize > targetSize {
-		logging.Error("server.handler.padEventToExactSize: event base size %d (with tag overhead %d) exceeds target size %d", currentSize, tagBaseSize, targetSize)
-		return nil, fmt.Errorf("event base size %d exceeds target size %d", totalSize, targetSize)
+// checkRecipient is StageRecipient: it drops containers whose outer "p" tag is not our pubkey,
+// republishing them first if the misaddressed policy says so. The subscription filter already
+// asks relays for our "p" tag unless republishing, but a relay may ignore it, and checking the
+// tag is far cheaper than a NIP-44 decryption bound to fail.
+func (r *Renoter) checkRecipient(ctx context.Context, msg *Message) error {
+	if _, err := config.RoutingPubkey(msg.Container); err != nil {
+		return r.malformedWrapper("29001", msg.Container, err)
 	}
-
-	// Calculate exact padding needed
-	paddingNeeded := targetSize - totalSize
-
-	// Generate padding string of exactly the needed length
-	paddingBytes := make([]byte, (paddingNeeded+1)/2) // Round up
-	if len(paddingBytes) > 0 {
-		if _, err := rand.Read(paddingBytes); err != nil {
-			return nil, fmt.Errorf("failed to generate random padding: %w", err)
+	if r.addressedToUs(msg.Container) {
+		if err := schema.CheckContainer(msg.Container); err != nil {
+			return r.malformedWrapper("29001", msg.Container, err)
 		}
+		return nil
 	}
-	paddingString := hex.EncodeToString(paddingBytes)
-
-	// Truncate to exact length needed
-	if len(paddingString) > paddingNeeded {
-		paddingString = paddingString[:paddingNeeded]
-	}
-
-	// Add padding tag
-	paddedEvent.Tags = append(paddedEvent.Tags, nostr.Tag{"padding", paddingString})
-
-	// Verify final size
-	finalJSON, _ := json.Marshal(&paddedEvent)
-	if len(finalJSON) != targetSize {
-		logging.Error("server.handler.padEventToExactSize: padded event size %d does not match target %d", len(finalJSON), targetSize)
-		return nil, fmt.Errorf("padded event size %d does not match target %d", len(finalJSON), targetSize)
+	if r.republishesMisaddressed() {
+		// Republishing a container carrying extra fields would help whoever added them
+		if err := schema.CheckContainer(msg.Container); err != nil {
+			return r.malformedWrapper("29001", msg.Container, err)
+		}
+		r.republishMisaddressed(ctx, msg.Container)
+		return Drop(DropRepublished)
 	}
-
-	logging.DebugMethod("server.handler", "padEventToExactSize", "Added padding: %d bytes needed, event size: %d -> target: %d", paddingNeeded, currentSize, targetSize)
-
-	return &paddedEvent, nil
+	logging.DebugMethod("server.handler", "checkRecipient", "29001 event %s not addressed to us, dropping it before decryption", msg.Container.ID)
+	return Drop(DropMisaddressed)
 }
 
-// HandleEvent handles a standardized wrapper event (29001) by decrypting it,
-// processing the inner 29000 event, and either re-wrapping or publishing the final event.
-func (r *Renoter) HandleEvent(ctx context.Context, event *nostr.Event) error {
-	// Verify signature (already done in ProcessEvent, but double-check)
-	valid, err := event.CheckSignature()
-	if err != nil {
-		logging.Error("server.handler.HandleEvent: signature check failed for event %s: %v", event.ID, err)
-		return fmt.Errorf("signature check failed: %w", err)
-	}
-	if !valid {
-		logging.Error("server.handler.HandleEvent: invalid signature for event %s", event.ID)
-		return fmt.Errorf("invalid signature for event %s", event.ID)
-	}
-
-	// Decrypt the 29001 content using this Renoter's private key
+// openContainer is StageOpen: it decrypts the 29001 content using this Renoter's identity
+// and parses the 29000 layer inside it.
+func (r *Renoter) openContainer(ctx context.Context, msg *Message) error {
+	event := msg.Container
 	senderPubkey := event.PubKey
-	logging.DebugMethod("server.handler", "HandleEvent", "Decrypting 29001 event, sender pubkey: %s (first 16 chars)", senderPubkey[:16])
+	logging.DebugMethod("server.handler", "openContainer", "Decrypting 29001 event, sender pubkey: %s", senderPubkey)
 
-	conversationKey, err := nip44.GenerateConversationKey(senderPubkey, r.PrivateKey)
+	conversationKey, err := r.identity.ConversationKey(ctx, senderPubkey)
 	if err != nil {
-		logging.Error("server.handler.HandleEvent: failed to generate conversation key for 29001 %s: %v", event.ID, err)
+		logging.Error("server.handler.openContainer: failed to generate conversation key for 29001 %s: %v", event.ID, err)
 		return fmt.Errorf("failed to generate conversation key: %w", err)
 	}
 
 	plaintext29001, err := nip44.Decrypt(event.Content, conversationKey)
 	if err != nil {
-		logging.Error("server.handler.HandleEvent: failed to decrypt 29001 content for event %s: %v", event.ID, err)
+		logging.Error("server.handler.openContainer: failed to decrypt 29001 content for event %s: %v", event.ID, err)
 		return fmt.Errorf("failed to decrypt 29001 content: %w", err)
 	}
 
+	// A padded 29000 is exactly the size of one of the buckets, the standardized size at most, so
+	// anything larger is malformed. Reject it before parsing to bound the work an attacker can force on us.
+	if len(plaintext29001) > r.standardizedSize {
+		logging.Error("server.handler.openContainer: decrypted 29001 payload for event %s is %d bytes, exceeds %d", event.ID, len(plaintext29001), r.standardizedSize)
+		return fmt.Errorf("decrypted 29001 payload size %d exceeds maximum %d bytes", len(plaintext29001), r.standardizedSize)
+	}
+	msg.Bucket = r.containerBucket(len(plaintext29001))
+
 	// Deserialize the inner 29000 event
 	var inner29000 nostr.Event
 	err = json.Unmarshal([]byte(plaintext29001), &inner29000)
 	if err != nil {
-		logging.Error("server.handler.HandleEvent: failed to deserialize inner 29000 event for event %s: %v", event.ID, err)
+		logging.Error("server.handler.openContainer: failed to deserialize inner 29000 event for event %s: %v", event.ID, err)
 		return fmt.Errorf("failed to deserialize inner 29000 event: %w", err)
 	}
+	msg.Layer = &inner29000
 
-	// Verify the inner 29000 is addressed to us
-	// Check "p" tag contains our pubkey
-	isAddressedToUs := false
-	for _, tag := range inner29000.Tags {
-		if len(tag) >= 2 && tag[0] == "p" && tag[1] == r.PublicKey {
-			isAddressedToUs = true
-			break
-		}
-	}
-
-	if !isAddressedToUs {
-		logging.DebugMethod("server.handler", "HandleEvent", "Inner 29000 event not addressed to us, silently dropping")
-		return nil // Silently drop
+	conversationKey29000, err := r.identity.ConversationKey(ctx, inner29000.PubKey)
+	if err != nil {
+		logging.Error("server.handler.openContainer: failed to generate conversation key for inner 29000: %v", err)
+		return fmt.Errorf("failed to generate conversation key for 29000: %w", err)
 	}
+	msg.LayerKey = conversationKey29000
+	return nil
+}
 
-	logging.DebugMethod("server.handler", "HandleEvent", "Inner 29000 event is addressed to us, decrypting")
-
-	// Validate proof-of-work for 29000 event (checks both committed difficulty and actual difficulty)
-	committedDiff := nip13.CommittedDifficulty(&inner29000)
-	if committedDiff < config.PoWDifficulty {
-		logging.Error("server.handler.HandleEvent: 29000 event committed difficulty %d is less than required %d", committedDiff, config.PoWDifficulty)
-		return fmt.Errorf("29000 event committed difficulty %d is less than required %d", committedDiff, config.PoWDifficulty)
+// checkAddressing is StageAddressing: it rejects layers that do not follow the wrapper schema,
+// exactly one routing tag included, and drops those whose "p" tag is not our pubkey.
+func (r *Renoter) checkAddressing(ctx context.Context, msg *Message) error {
+	if err := schema.CheckLayer(msg.Layer); err != nil {
+		return r.malformedWrapper("inner 29000", msg.Layer, err)
 	}
-	logging.DebugMethod("server.handler", "HandleEvent", "29000 event PoW validated successfully (difficulty: %d)", config.PoWDifficulty)
-
-	// Decrypt the 29000 event
-	sender29000Pubkey := inner29000.PubKey
-	conversationKey29000, err := nip44.GenerateConversationKey(sender29000Pubkey, r.PrivateKey)
-	if err != nil {
-		logging.Error("server.handler.HandleEvent: failed to generate conversation key for inner 29000: %v", err)
-		return fmt.Errorf("failed to generate conversation key for 29000: %w", err)
+	recipient, _ := config.RoutingPubkey(msg.Layer)
+	if recipient == r.PublicKey {
+		logging.DebugMethod("server.handler", "checkAddressing", "Inner 29000 event is addressed to us, decrypting")
+		return nil
 	}
+	logging.DebugMethod("server.handler", "checkAddressing", "Inner 29000 event not addressed to us, silently dropping")
+	return Drop(DropMisaddressed)
+}
 
-	plaintext29000, err := nip44.Decrypt(inner29000.Content, conversationKey29000)
+// decryptLayer is StageDecrypt: it decrypts the 29000 layer and verifies the event inside it
+// once its padding is removed.
+func (r *Renoter) decryptLayer(ctx context.Context, msg *Message) error {
+	plaintext29000, err := nip44.Decrypt(msg.Layer.Content, msg.LayerKey)
 	if err != nil {
-		logging.Error("server.handler.HandleEvent: failed to decrypt inner 29000 content: %v", err)
+		logging.Error("server.handler.decryptLayer: failed to decrypt inner 29000 content: %v", err)
 		return fmt.Errorf("failed to decrypt inner 29000 content: %w", err)
 	}
 
-	// Deserialize the content inside 29000
-	var innerEvent nostr.Event
-	err = json.Unmarshal([]byte(plaintext29000), &innerEvent)
+	// Deserialize the content inside 29000, a compact event or JSON
+	innerEvent, err := decodeInner([]byte(plaintext29000))
 	if err != nil {
-		logging.Error("server.handler.HandleEvent: failed to deserialize inner event: %v", err)
+		logging.Error("server.handler.decryptLayer: failed to deserialize inner event: %v", err)
 		return fmt.Errorf("failed to deserialize inner event: %w", err)
 	}
 
 	// Remove padding from inner event
-	originalTags := innerEvent.Tags
-	innerEvent.Tags = nostr.Tags{}
-	for _, tag := range originalTags {
-		if len(tag) > 0 && tag[0] != "padding" {
-			innerEvent.Tags = append(innerEvent.Tags, tag)
-		}
-	}
+	innerEvent.Tags = padding.StripPadding(innerEvent.Tags)
 
 	// Verify ID and signature after removing padding
 	originalID := innerEvent.ID
 	calculatedID := innerEvent.GetID()
 	if originalID != calculatedID {
-		logging.Error("server.handler.HandleEvent: inner event ID mismatch after removing padding: original=%s, calculated=%s", originalID, calculatedID)
+		logging.Error("server.handler.decryptLayer: inner event ID mismatch after removing padding: original=%s, calculated=%s", originalID, calculatedID)
 		return fmt.Errorf("inner event ID mismatch after removing padding")
 	}
 
 	if innerEvent.Sig != "" {
 		valid, err := innerEvent.CheckSignature()
 		if err != nil {
-			logging.Error("server.handler.HandleEvent: failed to check inner event signature: %v", err)
+			logging.Error("server.handler.decryptLayer: failed to check inner event signature: %v", err)
 			return fmt.Errorf("failed to check inner event signature: %w", err)
 		}
 		if !valid {
-			logging.Error("server.handler.HandleEvent: invalid signature for inner event %s", innerEvent.ID)
+			logging.Error("server.handler.decryptLayer: invalid signature for inner event %s", innerEvent.ID)
 			return fmt.Errorf("invalid signature for inner event")
 		}
 	}
 
-	// Check if inner event is another 29000 (next in path) or final event
+	// The next layer is checked now rather than after a mixing delay
 	if innerEvent.Kind == config.WrapperEventKind {
-		logging.DebugMethod("server.handler", "HandleEvent", "Inner event is another 29000, re-wrapping for next Renoter")
-
-		// Validate proof-of-work for inner 29000 event (checks both committed difficulty and actual difficulty)
-		committedDiff := nip13.CommittedDifficulty(&innerEvent)
-		if committedDiff < config.PoWDifficulty {
-			logging.Error("server.handler.HandleEvent: inner 29000 event committed difficulty %d is less than required %d", committedDiff, config.PoWDifficulty)
-			return fmt.Errorf("inner 29000 event committed difficulty %d is less than required %d", committedDiff, config.PoWDifficulty)
-		}
-		logging.DebugMethod("server.handler", "HandleEvent", "Inner 29000 event PoW validated successfully (difficulty: %d)", config.PoWDifficulty)
-
-		// Get next Renoter from "p" tag of inner 29000
-		nextRenoterPubkey := ""
-		for _, tag := range innerEvent.Tags {
-			if len(tag) >= 2 && tag[0] == "p" {
-				nextRenoterPubkey = tag[1]
-				break
-			}
+		if err := schema.CheckLayer(innerEvent); err != nil {
+			return r.malformedWrapper("next 29000", innerEvent, err)
 		}
+	}
 
-		if nextRenoterPubkey == "" {
-			logging.Error("server.handler.HandleEvent: inner 29000 has no 'p' tag for next Renoter")
-			return fmt.Errorf("inner 29000 has no 'p' tag for next Renoter")
-		}
+	msg.Inner = innerEvent
+	return nil
+}
 
-		// Pad inner 29000 to exactly 8KB
-		padded29000, err := padEventToExactSize(&innerEvent, config.StandardizedSize)
-		if err != nil {
-			logging.Error("server.handler.HandleEvent: failed to pad inner 29000 to %d bytes: %v", config.StandardizedSize, err)
-			return fmt.Errorf("failed to pad inner 29000: %w", err)
-		}
+// MalformedWrappers returns how many containers and layers were rejected since the Renoter was
+// created for not following the wrapper schema (see internal/schema), such as carrying several
+// routing tags or unexpected tags.
+func (r *Renoter) MalformedWrappers() int64 {
+	return r.malformedWrappers.Load()
+}
 
-		// Serialize padded 29000
-		padded29000JSON, err := json.Marshal(padded29000)
-		if err != nil {
-			logging.Error("server.handler.HandleEvent: failed to serialize padded 29000: %v", err)
-			return fmt.Errorf("failed to serialize padded 29000: %w", err)
-		}
+// malformedWrapper counts and rejects a wrapper that failed the schema or routing tag checks.
+// what names the wrapper in logs.
+func (r *Renoter) malformedWrapper(what string, wrapper *nostr.Event, err error) error {
+	count := r.malformedWrappers.Add(1)
+	logging.Warn("server.handler.malformedWrapper: rejecting %s %s: %v (%d malformed wrappers)", what, wrapper.ID, err, count)
+	return fmt.Errorf("%w: %s %s: %w", ErrMalformedWrapper, what, wrapper.ID, err)
+}
 
-		// Generate key for new 29001
-		sk29001 := nostr.GeneratePrivateKey()
-		pubkey29001, err := nostr.GetPublicKey(sk29001)
-		if err != nil {
-			logging.Error("server.handler.HandleEvent: failed to get public key for 29001: %v", err)
-			return fmt.Errorf("failed to get public key: %w", err)
-		}
+// decodeInner parses the plaintext of a 29000 layer. Clients speaking config.CompactLayersVersion
+// may send compact events, older ones always send JSON.
+func decodeInner(plaintext []byte) (*nostr.Event, error) {
+	if compact.Is(plaintext) {
+		return compact.Decode(plaintext)
+	}
+	var event nostr.Event
+	if err := json.Unmarshal(plaintext, &event); err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
 
-		// Encrypt for next Renoter
-		conversationKey29001, err := nip44.GenerateConversationKey(nextRenoterPubkey, sk29001)
-		if err != nil {
-			logging.Error("server.handler.HandleEvent: failed to generate conversation key for next Renoter: %v", err)
-			return fmt.Errorf("failed to generate conversation key: %w", err)
-		}
+// forward is StageForward: it re-wraps an inner 29000 for the next Renoter, or publishes the
+// final event. With a mixing delay the event is held in the background and the container counts
+// as handled; failures after the delay are only logged.
+func (r *Renoter) forward(ctx context.Context, msg *Message) error {
+	if msg.Delay > 0 {
+		r.hold(ctx, msg)
+		return nil
+	}
+	return r.forwardInner(ctx, msg)
+}
 
-		ciphertext29001, err := nip44.Encrypt(string(padded29000JSON), conversationKey29001)
+// forwardInner forwards the event inside a layer right away, adding a timing trailer if the
+// layer asks for one.
+func (r *Renoter) forwardInner(ctx context.Context, msg *Message) error {
+	report := r.reportKey(msg)
+	if msg.Inner.Kind == config.WrapperEventKind {
+		trailers, err := r.nextTrailers(msg, report)
 		if err != nil {
-			logging.Error("server.handler.HandleEvent: failed to encrypt for 29001: %v", err)
-			return fmt.Errorf("failed to encrypt for 29001: %w", err)
+			logging.Warn("server.handler.forwardInner: forwarding 29000 %s without trailers: %v", msg.Inner.ID, err)
+			trailers = nil
 		}
+		return r.forwardLayer(ctx, msg.Inner, msg.Bucket, trailers)
+	}
+	// A duplicate final event is dropped, but the sender still gets its trailer
+	err := r.publishFinal(ctx, msg.Inner)
+	if err != nil && !errors.Is(err, ErrDrop) {
+		return err
+	}
+	if report != "" {
+		r.publishTrailers(ctx, msg, report)
+	}
+	return err
+}
 
-		// Create new 29001 container
-		new29001 := &nostr.Event{
-			Kind:      config.StandardizedWrapperKind,
-			Content:   ciphertext29001,
-			CreatedAt: nostr.Now(),
-			PubKey:    pubkey29001,
-			Tags: nostr.Tags{
-				{"p", nextRenoterPubkey},
-			},
-		}
+// forwardLayer wraps an inner 29000 in a new 29001 container for the next Renoter and publishes
+// it, with the given trailer tags (nil for none). The inner 29000 is padded to bucket, the size of
+// the container it arrived in, so observers cannot see the message shrink from hop to hop.
+func (r *Renoter) forwardLayer(ctx context.Context, innerEvent *nostr.Event, bucket int, trailers nostr.Tags) error {
+	logging.DebugMethod("server.handler", "forwardLayer", "Inner event is another 29000, re-wrapping for next Renoter")
 
-		new29001.ID = new29001.GetID()
-		if !new29001.CheckID() {
-			logging.Error("server.handler.HandleEvent: new 29001 ID validation failed")
-			return fmt.Errorf("invalid new 29001 event ID")
-		}
+	// The inner 29000 is not admission-checked here: the next Renoter admits it with its own
+	// strategies, which may rely on tags sealed to it (e.g. a Cashu token instead of PoW)
 
-		err = new29001.Sign(sk29001)
-		if err != nil {
-			logging.Error("server.handler.HandleEvent: failed to sign new 29001: %v", err)
-			return fmt.Errorf("failed to sign new 29001: %w", err)
-		}
+	// Get next Renoter from the routing tag of inner 29000
+	nextRenoterPubkey, err := config.RoutingPubkey(innerEvent)
+	if err != nil {
+		logging.Error("server.handler.forwardLayer: inner 29000 has no usable 'p' tag for next Renoter: %v", err)
+		return fmt.Errorf("inner 29000 has no usable 'p' tag for next Renoter: %w", err)
+	}
 
-		// Publish new 29001
-		relayURLs := r.GetRelayURLs()
-		publishResults := r.GetPool().PublishMany(ctx, relayURLs, *new29001)
-		successCount := 0
-		failedRelays := []string{}
-		for result := range publishResults {
-			if result.Error != nil {
-				failedRelays = append(failedRelays, result.RelayURL)
-				logging.Error("server.handler.HandleEvent: failed to publish new 29001 %s to relay %s: %v", new29001.ID, result.RelayURL, result.Error)
-			} else {
-				successCount++
-				logging.DebugMethod("server.handler", "HandleEvent", "Successfully published new 29001 %s to relay %s", new29001.ID, result.RelayURL)
-			}
-		}
+	if bucket == 0 {
+		bucket = r.standardizedSize
+	}
+	new29001, err := buildNextHopContainer(ctx, innerEvent, nextRenoterPubkey, bucket, r.containerPoWDifficulty, trailers)
+	if err != nil {
+		return err
+	}
 
-		if successCount == 0 {
-			logging.Error("server.handler.HandleEvent: Failed to publish new 29001 %s to any of %d relays. Failed relays: %v", new29001.ID, len(relayURLs), failedRelays)
-			return fmt.Errorf("failed to publish new 29001 to any relay")
+	// Publish new 29001
+	relayURLs := r.forwardRelayURLs()
+	publishResults := r.forwarder.PublishMany(ctx, relayURLs, *new29001)
+	successCount := 0
+	failedRelays := []string{}
+	for result := range publishResults {
+		if result.Error != nil {
+			failedRelays = append(failedRelays, result.RelayURL)
+			logging.Error("server.handler.forwardLayer: failed to publish new 29001 %s to relay %s: %v", new29001.ID, result.RelayURL, result.Error)
+		} else {
+			successCount++
+			logging.DebugMethod("server.handler", "forwardLayer", "Successfully published new 29001 %s to relay %s", new29001.ID, result.RelayURL)
 		}
+	}
 
-		logging.Info("server.handler.HandleEvent: Successfully re-wrapped and published 29001 %s to %d/%d relays", new29001.ID, successCount, len(relayURLs))
-		if len(failedRelays) > 0 {
-			logging.Warn("server.handler.HandleEvent: Failed to publish 29001 %s to %d relay(s): %v", new29001.ID, len(failedRelays), failedRelays)
-		}
-		return nil
-	} else {
-		// Final event - publish as-is
-		logging.DebugMethod("server.handler", "HandleEvent", "Inner event is final event (kind %d), publishing", innerEvent.Kind)
-		relayURLs := r.GetRelayURLs()
-		publishResults := r.GetPool().PublishMany(ctx, relayURLs, innerEvent)
-		successCount := 0
-		failedRelays := []string{}
-		for result := range publishResults {
-			if result.Error != nil {
-				failedRelays = append(failedRelays, result.RelayURL)
-				logging.Error("server.handler.HandleEvent: failed to publish final event %s to relay %s: %v", innerEvent.ID, result.RelayURL, result.Error)
-			} else {
-				successCount++
-				logging.DebugMethod("server.handler", "HandleEvent", "Successfully published final event %s to relay %s", innerEvent.ID, result.RelayURL)
-			}
-		}
+	if successCount == 0 {
+		logging.Error("server.handler.forwardLayer: Failed to publish new 29001 %s to any of %d relays. Failed relays: %v", new29001.ID, len(relayURLs), failedRelays)
+		return fmt.Errorf("failed to publish new 29001 to any relay")
+	}
 
-		if successCount == 0 {
-			logging.Error("server.handler.HandleEvent: Failed to publish final event %s to any of %d relays. Failed relays: %v", innerEvent.ID, len(relayURLs), failedRelays)
-			return fmt.Errorf("failed to publish final event to any relay")
-		}
+	logging.Info("server.handler.forwardLayer: Successfully re-wrapped and published 29001 %s to %d/%d relays", new29001.ID, successCount, len(relayURLs))
+	if len(failedRelays) > 0 {
+		logging.Warn("server.handler.forwardLayer: Failed to publish 29001 %s to %d relay(s): %v", new29001.ID, len(failedRelays), failedRelays)
+	}
+	return nil
+}
 
-		logging.Info("server.handler.HandleEvent: Successfully published final event %s to %d/%d relays", innerEvent.ID, successCount, len(relayURLs))
-		if len(failedRelays) > 0 {
-			logging.Warn("server.handler.HandleEvent: Failed to publish final event %s to %d relay(s): %v", innerEvent.ID, len(failedRelays), failedRelays)
+// publishFinal publishes the final event as-is, unless an identical copy (sent by anyone, with or
+// without an idempotency tag) was published within the duplicate TTL or is being published, in
+// which case it returns Drop(DropDuplicate).
+func (r *Renoter) publishFinal(ctx context.Context, innerEvent *nostr.Event) error {
+	if !r.claimPublish(innerEvent) {
+		return Drop(DropDuplicate)
+	}
+	annotations, err := r.runFinalHooks(ctx, innerEvent)
+	if err != nil {
+		r.settlePublish(innerEvent, false)
+		return err
+	}
+	logging.DebugMethod("server.handler", "publishFinal", "Inner event is final event (kind %d), publishing", innerEvent.Kind)
+	relayURLs := r.finalRelayURLs()
+	publishResults := r.forwarder.PublishMany(ctx, relayURLs, *innerEvent)
+	successCount := 0
+	failedRelays := []string{}
+	for result := range publishResults {
+		if result.Error != nil {
+			failedRelays = append(failedRelays, result.RelayURL)
+			logging.Error("server.handler.publishFinal: failed to publish final event %s to relay %s: %v", innerEvent.ID, result.RelayURL, result.Error)
+		} else {
+			successCount++
+			logging.DebugMethod("server.handler", "publishFinal", "Successfully published final event %s to relay %s", innerEvent.ID, result.RelayURL)
 		}
-		return nil
 	}
+
+	r.settlePublish(innerEvent, successCount > 0)
+	if successCount == 0 {
+		logging.Error("server.handler.publishFinal: Failed to publish final event %s to any of %d relays. Failed relays: %v", innerEvent.ID, len(relayURLs), failedRelays)
+		return fmt.Errorf("failed to publish final event to any relay")
+	}
+
+	logging.Info("server.handler.publishFinal: Successfully published final event %s to %d/%d relays", innerEvent.ID, successCount, len(relayURLs))
+	r.recordAudit(innerEvent, successCount)
+	r.mirrorEvent(ctx, innerEvent)
+	r.recordKind(innerEvent.Kind)
+	r.attribute(ctx, innerEvent)
+	r.annotate(ctx, innerEvent, annotations)
+	if len(failedRelays) > 0 {
+		logging.Warn("server.handler.publishFinal: Failed to publish final event %s to %d relay(s): %v", innerEvent.ID, len(failedRelays), failedRelays)
+	}
+	r.deliverToMentions(ctx, innerEvent)
+	return nil
 }
 
 // SubscribeToWrappedEvents subscribes to standardized wrapper events (kind 29001) on multiple relays.
 func (r *Renoter) SubscribeToWrappedEvents(ctx context.Context) error {
-	relayURLs := r.GetRelayURLs()
+	relayURLs := r.listenRelayURLs()
 
 	// Create filter for standardized wrapper events addressed to this Renoter
-	// Filter by events with kind 29001 that have our pubkey in a "p" tag
-	filter := nostr.Filter{
-		Kinds: []int{config.StandardizedWrapperKind}, // Standardized wrapper event kind (29001)
-		Tags: nostr.TagMap{
-			"p": []string{r.PublicKey}, // Only events with our pubkey in "p" tag
-		},
-	}
+	// Filter by events with kind 29001 that have our pubkey in a "p" tag, narrowed by the subscription options
+	filter := r.subscriptionFilter()
 
-	logging.DebugMethod("server.handler", "SubscribeToWrappedEvents", "Creating subscription filter: kind=29001, p tag=%s (first 16 chars)", r.PublicKey[:16])
+	logging.DebugMethod("server.handler", "SubscribeToWrappedEvents", "Creating subscription filter: kind=29001, p tag=%s (first 16 chars), since startup=%v, limit=%d", r.PublicKey[:16], r.subscription.SinceStartup, filter.Limit)
 
-	// Subscribe to all relays using SimplePool
+	// Subscribe to the listen relays using SimplePool; Close cancels the subscription
+	ctx, done, err := r.life.start(ctx)
+	if err != nil {
+		logging.Error("server.handler.SubscribeToWrappedEvents: %v", err)
+		return err
+	}
 	events := r.GetPool().SubscribeMany(ctx, relayURLs, filter)
 	logging.Info("server.handler.SubscribeToWrappedEvents: Successfully subscribed to standardized wrapper events (kind 29001) with our pubkey in 'p' tag on %d relays", len(relayURLs))
 
-	// Handle incoming events from all relays
+	// Handle incoming events from all relays on the workers; reading pauses while they are all
+	// busy and the queue is full, so a stalled publish slows the subscription down
 	// Track processed events to avoid processing the same event multiple times from different relays
 	// Also track events currently being processed to prevent concurrent processing
+	var mu sync.Mutex
 	processedEvents := make(map[string]bool)
 	processingEvents := make(map[string]bool)
+	queue := r.startQueue(ctx, "SubscribeToWrappedEvents", func(ctx context.Context, ev *nostr.Event) Outcome {
+		// Run the event through the pipeline (verify, decrypt and forward)
+		outcome := r.HandleOutcome(ctx, ev)
+
+		// Rejected events are not marked as processed, so a copy delivered again is retried
+		mu.Lock()
+		delete(processingEvents, ev.ID)
+		if outcome.Kind != OutcomeRejected {
+			processedEvents[ev.ID] = true
+		}
+		mu.Unlock()
+		return outcome
+	})
 	go func() {
+		defer done()
 		for {
 			select {
 			case <-ctx.Done():
@@ -367,36 +387,28 @@ func (r *Renoter) SubscribeToWrappedEvents(ctx context.Context) error {
 					return
 				}
 
-				ev := relayEvent.Event
-
-				// Deduplicate: skip if we already processed this event
-				if processedEvents[ev.ID] {
+				// Relays may ignore parts of the filter; drop anything that does not match it
+				if !r.acceptsRelayEvent(relayEvent, filter) {
+					logging.DebugMethod("server.handler", "SubscribeToWrappedEvents", "Dropping event outside the subscription filter from %s", relayEventURL(relayEvent))
 					continue
 				}
-				// Check if currently being processed (defense against race conditions)
-				if processingEvents[ev.ID] {
-					continue
-				}
-				// Mark as being processed immediately
-				processingEvents[ev.ID] = true
 
-				// Process the event (verify signature)
-				err := r.ProcessEvent(ctx, ev)
-				if err != nil {
-					logging.Warn("server.handler.SubscribeToWrappedEvents: Error processing event %s: %v", ev.ID, err)
+				ev := relayEvent.Event
+
+				// Deduplicate: skip if we already processed this event or it is being processed
+				mu.Lock()
+				skip := processedEvents[ev.ID] || processingEvents[ev.ID]
+				if !skip {
+					// Mark as being processed immediately
+					processingEvents[ev.ID] = true
+				}
+				mu.Unlock()
+				if skip {
 					continue
 				}
 
-				// Handle (decrypt and forward)
-				err = r.HandleEvent(ctx, ev)
-
-				// Mark as processed (regardless of success/failure)
-				processedEvents[ev.ID] = true
-				delete(processingEvents, ev.ID)
-
-				if err != nil {
-					logging.Warn("server.handler.SubscribeToWrappedEvents: Error handling event %s: %v", ev.ID, err)
-					continue
+				if !r.enqueue(ctx, queue, ev) {
+					return
 				}
 			}
 		}
@@ -404,3 +416,89 @@ func (r *Renoter) SubscribeToWrappedEvents(ctx context.Context) error {
 
 	return nil
 }
+
+// relayEventURL returns the URL of the relay that delivered relayEvent, for logging.
+func relayEventURL(relayEvent nostr.RelayEvent) string {
+	if relayEvent.Relay == nil {
+		return "unknown relay"
+	}
+	return relayEvent.Relay.URL
+}
+
+// buildNextHopContainer pads an inner 29000 event to exactly standardizedSize,
+// encrypts it for the next Renoter, and wraps it in a signed 29001 container.
+// If powDifficulty is positive the container is mined for relays that require PoW. trailers are
+// appended to the container's tags.
+func buildNextHopContainer(ctx context.Context, inner29000 *nostr.Event, nextRenoterPubkey string, standardizedSize int, powDifficulty int, trailers nostr.Tags) (*nostr.Event, error) {
+	// Pad inner 29000 to exactly the standardized size or bucket, with fresh padding replacing
+	// any it came with
+	padded29000, err := padding.PadEventToExactSize(inner29000, standardizedSize)
+	if err != nil {
+		logging.Error("server.handler.buildNextHopContainer: failed to pad inner 29000 to %d bytes: %v", standardizedSize, err)
+		return nil, fmt.Errorf("failed to pad inner 29000: %w", err)
+	}
+
+	// Serialize padded 29000
+	padded29000JSON, err := padding.Marshal(padded29000)
+	if err != nil {
+		logging.Error("server.handler.buildNextHopContainer: failed to serialize padded 29000: %v", err)
+		return nil, fmt.Errorf("failed to serialize padded 29000: %w", err)
+	}
+
+	// Generate key for new 29001
+	sk29001 := nostr.GeneratePrivateKey()
+	pubkey29001, err := nostr.GetPublicKey(sk29001)
+	if err != nil {
+		logging.Error("server.handler.buildNextHopContainer: failed to get public key for 29001: %v", err)
+		return nil, fmt.Errorf("failed to get public key: %w", err)
+	}
+
+	// Encrypt for next Renoter
+	conversationKey29001, err := nip44.GenerateConversationKey(nextRenoterPubkey, sk29001)
+	if err != nil {
+		logging.Error("server.handler.buildNextHopContainer: failed to generate conversation key for next Renoter: %v", err)
+		return nil, fmt.Errorf("failed to generate conversation key: %w", err)
+	}
+
+	ciphertext29001, err := nip44.Encrypt(string(padded29000JSON), conversationKey29001)
+	if err != nil {
+		logging.Error("server.handler.buildNextHopContainer: failed to encrypt for 29001: %v", err)
+		return nil, fmt.Errorf("failed to encrypt for 29001: %w", err)
+	}
+
+	// Create new 29001 container
+	new29001 := &nostr.Event{
+		Kind:      config.StandardizedWrapperKind,
+		Content:   ciphertext29001,
+		CreatedAt: nostr.Now(),
+		PubKey:    pubkey29001,
+		Tags: append(nostr.Tags{
+			{"p", nextRenoterPubkey},
+		}, trailers...),
+	}
+
+	// Optionally mine the container for relays that require PoW on incoming events
+	if powDifficulty > 0 {
+		logging.DebugMethod("server.handler", "buildNextHopContainer", "Mining PoW for new 29001 (difficulty %d)", powDifficulty)
+		nonceTag, err := nip13.DoWork(ctx, *new29001, powDifficulty)
+		if err != nil {
+			logging.Error("server.handler.buildNextHopContainer: failed to mine PoW for new 29001: %v", err)
+			return nil, fmt.Errorf("failed to mine PoW for new 29001: %w", err)
+		}
+		new29001.Tags = append(new29001.Tags, nonceTag)
+	}
+
+	new29001.ID = new29001.GetID()
+	if !new29001.CheckID() {
+		logging.Error("server.handler.buildNextHopContainer: new 29001 ID validation failed")
+		return nil, fmt.Errorf("invalid new 29001 event ID")
+	}
+
+	err = new29001.Sign(sk29001)
+	if err != nil {
+		logging.Error("server.handler.buildNextHopContainer: failed to sign new 29001: %v", err)
+		return nil, fmt.Errorf("failed to sign new 29001: %w", err)
+	}
+
+	return new29001, nil
+}
@@ -2,110 +2,21 @@ package server
 
 import (
 	"context"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/girino/renoter/internal/config"
+	"github.com/girino/renoter/internal/padding"
+	"github.com/girino/renoter/pkg/client"
 	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip13"
+	"github.com/nbd-wtf/go-nostr/nip44"
 )
 
-func TestPadEventToExactSize(t *testing.T) {
-	// Create a small test event
-	event := &nostr.Event{
-		Kind:      1,
-		Content:   "Small content",
-		CreatedAt: nostr.Now(),
-		PubKey:    nostr.GeneratePrivateKey(),
-	}
-	event.Sign(event.PubKey)
-
-	targetSize := 1000 // 1KB target
-
-	// Test successful padding
-	padded, err := padEventToExactSize(event, targetSize)
-	if err != nil {
-		t.Fatalf("padEventToExactSize() error = %v", err)
-	}
-
-	// Verify size
-	jsonBytes, _ := json.Marshal(padded)
-	if len(jsonBytes) != targetSize {
-		t.Errorf("Padded event size = %d, want %d", len(jsonBytes), targetSize)
-	}
-
-	// Verify padding tag exists
-	foundPadding := false
-	for _, tag := range padded.Tags {
-		if len(tag) >= 1 && tag[0] == "padding" {
-			foundPadding = true
-			break
-		}
-	}
-	if !foundPadding {
-		t.Error("Padded event should have padding tag")
-	}
-}
-
-func TestPadEventToExactSize_TooLarge(t *testing.T) {
-	// Create a large event
-	largeContent := make([]byte, 5000)
-	for i := range largeContent {
-		largeContent[i] = 'A'
-	}
-
-	event := &nostr.Event{
-		Kind:      1,
-		Content:   string(largeContent),
-		CreatedAt: nostr.Now(),
-		PubKey:    nostr.GeneratePrivateKey(),
-	}
-	event.Sign(event.PubKey)
-
-	// Try to pad to a size smaller than the event
-	targetSize := 100
-	_, err := padEventToExactSize(event, targetSize)
-
-	if err == nil {
-		t.Error("padEventToExactSize() should error when event is too large")
-	}
-}
-
-func TestPadEventToExactSize_ExactSize(t *testing.T) {
-	// Create a small event
-	event := &nostr.Event{
-		Kind:      1,
-		Content:   "Test",
-		CreatedAt: nostr.Now(),
-		PubKey:    nostr.GeneratePrivateKey(),
-	}
-	event.Sign(event.PubKey)
-
-	// Calculate current size
-	jsonBytes, _ := json.Marshal(event)
-	currentSize := len(jsonBytes)
-
-	// Calculate size with empty padding tag to get tag overhead
-	testEvent := *event
-	testEvent.Tags = nostr.Tags{{"padding", ""}}
-	testJSON, _ := json.Marshal(&testEvent)
-	tagBaseSize := len(testJSON) - currentSize
-
-	// Target size should be at least current size + tag overhead
-	targetSize := currentSize + tagBaseSize + 100 // Add some padding room
-
-	// Pad to target size
-	padded, err := padEventToExactSize(event, targetSize)
-	if err != nil {
-		t.Fatalf("padEventToExactSize() error = %v", err)
-	}
-
-	// Size should match
-	finalJSON, _ := json.Marshal(padded)
-	if len(finalJSON) != targetSize {
-		t.Errorf("Padded event size = %d, want %d", len(finalJSON), targetSize)
-	}
-}
-
 func TestRenoter_GetPool(t *testing.T) {
 	ctx := context.Background()
 
@@ -291,3 +202,408 @@ func TestRenoter_ProcessEvent_OldEvent(t *testing.T) {
 // Note: HandleEvent and SubscribeToWrappedEvents require actual relay connections
 // or complex mocking. These would be better suited for integration tests.
 // The above tests cover the testable parts of the handler functions.
+
+func TestBuildNextHopContainer_Golden(t *testing.T) {
+	// The client's container test checks the same vectors, so client and server
+	// containers can never drift apart in size.
+	vectors, err := padding.LoadGoldenVectors("../../internal/padding/testdata/golden.json")
+	if err != nil {
+		t.Fatalf("LoadGoldenVectors() error = %v", err)
+	}
+	if vectors.Container.StandardizedSize != config.StandardizedSize {
+		t.Fatalf("golden standardized size = %d, config.StandardizedSize = %d", vectors.Container.StandardizedSize, config.StandardizedSize)
+	}
+
+	nextSk := nostr.GeneratePrivateKey()
+	nextPk, _ := nostr.GetPublicKey(nextSk)
+
+	for _, v := range vectors.Padding {
+		t.Run(v.Name, func(t *testing.T) {
+			inner, err := v.Event()
+			if err != nil {
+				t.Fatalf("Event() error = %v", err)
+			}
+
+			container, err := buildNextHopContainer(context.Background(), inner, nextPk, vectors.Container.StandardizedSize, 0, nil)
+			if err != nil {
+				t.Fatalf("buildNextHopContainer() error = %v", err)
+			}
+
+			if len(container.Content) != vectors.Container.ContentLen {
+				t.Errorf("29001 content length = %d, want %d", len(container.Content), vectors.Container.ContentLen)
+			}
+			containerJSON, _ := json.Marshal(container)
+			if len(containerJSON) != vectors.Container.JSONSize {
+				t.Errorf("29001 JSON size = %d, want %d", len(containerJSON), vectors.Container.JSONSize)
+			}
+		})
+	}
+}
+
+func TestBuildNextHopContainer_ContainerPoW(t *testing.T) {
+	nextPk, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	inner := &nostr.Event{Kind: config.WrapperEventKind, Content: "inner", CreatedAt: nostr.Now(), Tags: nostr.Tags{{"p", nextPk}}}
+	inner.Sign(nostr.GeneratePrivateKey())
+
+	container, err := buildNextHopContainer(context.Background(), inner, nextPk, config.StandardizedSize, 8, nil)
+	if err != nil {
+		t.Fatalf("buildNextHopContainer() error = %v", err)
+	}
+	if got := nip13.Difficulty(container.ID); got < 8 {
+		t.Errorf("container PoW difficulty = %d, want at least 8", got)
+	}
+	if valid, err := container.CheckSignature(); err != nil || !valid {
+		t.Errorf("container signature invalid: %v", err)
+	}
+}
+
+func TestForwardLayer_KeepsInboundBucket(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	limits := config.DefaultSizeLimits()
+	limits.Buckets = []int{4096, 16384}
+	pool := &fakePool{events: make(chan nostr.RelayEvent), published: make(chan nostr.Event, 1)}
+	entry, err := NewRenoterWithPool(ctx, nostr.GeneratePrivateKey(), []string{"wss://relay.example.com"}, pool)
+	if err != nil {
+		t.Fatalf("NewRenoterWithPool() error = %v", err)
+	}
+	if err := entry.SetSizeLimits(limits); err != nil {
+		t.Fatalf("SetSizeLimits() error = %v", err)
+	}
+	exit := newOfflineRenoter(t)
+
+	path := make(client.Path, 2)
+	path[0].PubKey, _ = hex.DecodeString(entry.PublicKey)
+	path[1].PubKey, _ = hex.DecodeString(exit.PublicKey)
+	event := &nostr.Event{Kind: 1, Content: strings.Repeat("a", 2000), CreatedAt: nostr.Now(), Tags: nostr.Tags{}}
+	event.Sign(nostr.GeneratePrivateKey())
+	opts := client.DefaultWrapOptions()
+	opts.Limits = limits
+	container, err := client.WrapEvent(ctx, event, path, opts)
+	if err != nil {
+		t.Fatalf("WrapEvent() error = %v", err)
+	}
+
+	if err := entry.Handle(ctx, container); err != nil {
+		t.Fatalf("Handle() error = %v", err)
+	}
+	forwarded := <-pool.published
+	conversationKey, _ := nip44.GenerateConversationKey(forwarded.PubKey, exit.PrivateKey)
+	plaintext, err := nip44.Decrypt(forwarded.Content, conversationKey)
+	if err != nil {
+		t.Fatalf("failed to decrypt the forwarded container: %v", err)
+	}
+	// The event needs the 16384 bucket at the entry and fits in 4096 at the exit, but keeps its size
+	if len(plaintext) != 16384 {
+		t.Errorf("forwarded 29000 is %d bytes, want the inbound bucket of 16384", len(plaintext))
+	}
+}
+
+func TestForwardLayer_RegeneratesPadding(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pool := &fakePool{events: make(chan nostr.RelayEvent), published: make(chan nostr.Event, 1)}
+	entry, err := NewRenoterWithPool(ctx, nostr.GeneratePrivateKey(), []string{"wss://relay.example.com"}, pool)
+	if err != nil {
+		t.Fatalf("NewRenoterWithPool() error = %v", err)
+	}
+	entry.powDifficulty = 0
+	exitSk := nostr.GeneratePrivateKey()
+	exit, _ := nostr.GetPublicKey(exitSk)
+
+	// A previous hop colluding with the exit watermarks the padding it can set: that of the layer
+	// it hands to the entry, and that of the next layer, which its signature does not cover
+	watermark := strings.Repeat("deadbeef", 64)
+	nextContent, nextSk, _, _ := encryptFor(t, "final event", exit)
+	next := &nostr.Event{Kind: config.WrapperEventKind, Content: nextContent, CreatedAt: nostr.Now(), Tags: nostr.Tags{{"p", exit}}}
+	next.Sign(nextSk)
+	next.Tags = append(next.Tags, nostr.Tag{padding.TagName, watermark})
+	nextJSON, _ := json.Marshal(next)
+	layerContent, layerSk, _, _ := encryptFor(t, string(nextJSON), entry.PublicKey)
+	layer := &nostr.Event{Kind: config.WrapperEventKind, Content: layerContent, CreatedAt: nostr.Now(), Tags: nostr.Tags{{"p", entry.PublicKey}}}
+	layer.Sign(layerSk)
+	layer.Tags = append(layer.Tags, nostr.Tag{padding.TagName, watermark})
+	container := sealContainer(t, entry.PublicKey, layer, nostr.Now(), nostr.Tags{{"p", entry.PublicKey}})
+
+	if err := entry.Handle(ctx, container); err != nil {
+		t.Fatalf("Handle() error = %v", err)
+	}
+	forwarded := <-pool.published
+	conversationKey, _ := nip44.GenerateConversationKey(forwarded.PubKey, exitSk)
+	plaintext, err := nip44.Decrypt(forwarded.Content, conversationKey)
+	if err != nil {
+		t.Fatalf("failed to decrypt the forwarded container: %v", err)
+	}
+	if strings.Contains(plaintext, watermark[:16]) {
+		t.Error("inbound padding bytes reached the next hop")
+	}
+	var received nostr.Event
+	if err := json.Unmarshal([]byte(plaintext), &received); err != nil {
+		t.Fatalf("forwarded 29000 is not JSON: %v", err)
+	}
+	if got := len(received.Tags) - len(padding.StripPadding(received.Tags)); got != 1 {
+		t.Errorf("forwarded 29000 has %d padding tags, want 1", got)
+	}
+	received.Tags = padding.StripPadding(received.Tags)
+	if received.ID != next.ID || !received.CheckID() {
+		t.Error("the forwarded 29000 is not the next layer once its padding is removed")
+	}
+}
+
+// newOfflineRenoter creates a Renoter without a relay pool, for tests that only
+// exercise decryption and parsing. PoW is disabled so fuzz inputs can reach inner layers.
+func newOfflineRenoter(t testing.TB) *Renoter {
+	t.Helper()
+	sk := nostr.GeneratePrivateKey()
+	pk, err := nostr.GetPublicKey(sk)
+	if err != nil {
+		t.Fatalf("GetPublicKey() error = %v", err)
+	}
+	r := &Renoter{
+		PrivateKey:       sk,
+		PublicKey:        pk,
+		identity:         &KeyIdentity{privateKey: sk, publicKey: pk},
+		eventCache:       NewEventCache(100, time.Hour),
+		deliveries:       NewEventCache(100, time.Hour),
+		stamps:           NewEventCache(100, time.Hour),
+		powDifficulty:    0,
+		standardizedSize: config.StandardizedSize,
+	}
+	r.pipeline = r.defaultPipeline()
+	return r
+}
+
+// encryptFor encrypts plaintext for the given recipient with a fresh ephemeral key
+// and returns the ciphertext together with the ephemeral keypair.
+func encryptFor(t testing.TB, plaintext string, recipientPubkey string) (ciphertext, sk, pk string, ok bool) {
+	t.Helper()
+	sk = nostr.GeneratePrivateKey()
+	pk, _ = nostr.GetPublicKey(sk)
+	conversationKey, err := nip44.GenerateConversationKey(recipientPubkey, sk)
+	if err != nil {
+		t.Fatalf("GenerateConversationKey() error = %v", err)
+	}
+	ciphertext, err = nip44.Encrypt(plaintext, conversationKey)
+	if err != nil {
+		// NIP-44 refuses empty and >64KB plaintexts, nothing to fuzz there
+		return "", "", "", false
+	}
+	return ciphertext, sk, pk, true
+}
+
+// sealContainer encrypts layer for recipient into a 29001 container with the given date and tags.
+func sealContainer(t *testing.T, recipient string, layer *nostr.Event, createdAt nostr.Timestamp, tags nostr.Tags) *nostr.Event {
+	t.Helper()
+	layerJSON, err := json.Marshal(layer)
+	if err != nil {
+		t.Fatalf("json.Marshal() error = %v", err)
+	}
+	ciphertext, sk, pk, ok := encryptFor(t, string(layerJSON), recipient)
+	if !ok {
+		t.Fatal("encryptFor() failed")
+	}
+	container := &nostr.Event{
+		Kind:      config.StandardizedWrapperKind,
+		Content:   ciphertext,
+		CreatedAt: createdAt,
+		PubKey:    pk,
+		Tags:      tags,
+	}
+	container.Sign(sk)
+	return container
+}
+
+func TestRenoter_Handle_RejectsMalformedRouting(t *testing.T) {
+	renoter := newOfflineRenoter(t)
+	us := renoter.PublicKey
+	other, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+
+	// A layer for us whose content is the next layer, tagged with nextTags
+	layerFor := func(layerTags, nextTags nostr.Tags) *nostr.Event {
+		next := &nostr.Event{Kind: config.WrapperEventKind, Content: "next", CreatedAt: nostr.Now(), Tags: nextTags}
+		next.Sign(nostr.GeneratePrivateKey())
+		nextJSON, _ := json.Marshal(next)
+		ciphertext, sk, pk, ok := encryptFor(t, string(nextJSON), us)
+		if !ok {
+			t.Fatal("encryptFor() failed")
+		}
+		layer := &nostr.Event{Kind: config.WrapperEventKind, Content: ciphertext, CreatedAt: nostr.Now(), PubKey: pk, Tags: layerTags}
+		layer.Sign(sk)
+		return layer
+	}
+	valid := nostr.Tags{{"p", us}}
+	tests := []struct {
+		name                               string
+		containerTags, layerTags, nextTags nostr.Tags
+	}{
+		{"container without p tag", nostr.Tags{}, valid, nostr.Tags{{"p", other}}},
+		{"container tagged twice with us", nostr.Tags{{"p", us}, {"p", us}}, valid, nostr.Tags{{"p", other}}},
+		{"container tagged with us and another Renoter", nostr.Tags{{"p", other}, {"p", us}}, valid, nostr.Tags{{"p", other}}},
+		{"container with invalid pubkey", nostr.Tags{{"p", us}, {"p", "not a pubkey"}}, valid, nostr.Tags{{"p", other}}},
+		{"layer tagged with us and another Renoter", valid, nostr.Tags{{"p", us}, {"p", other}}, nostr.Tags{{"p", other}}},
+		{"layer without p tag", valid, nostr.Tags{}, nostr.Tags{{"p", other}}},
+		{"next layer tagged with two Renoters", valid, valid, nostr.Tags{{"p", other}, {"p", us}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			container := sealContainer(t, us, layerFor(tt.layerTags, tt.nextTags), nostr.Now(), tt.containerTags)
+			before := renoter.MalformedWrappers()
+			err := renoter.Handle(context.Background(), container)
+			if !errors.Is(err, ErrMalformedWrapper) {
+				t.Errorf("Handle() error = %v, want ErrMalformedWrapper", err)
+			}
+			if got := renoter.MalformedWrappers(); got != before+1 {
+				t.Errorf("MalformedWrappers() = %d, want %d", got, before+1)
+			}
+		})
+	}
+}
+
+func TestUnwrapEvent_RejectsOversizedPayload(t *testing.T) {
+	renoter := newOfflineRenoter(t)
+
+	ciphertext, sk, pk, ok := encryptFor(t, strings.Repeat("A", config.StandardizedSize+1), renoter.PublicKey)
+	if !ok {
+		t.Fatal("encryptFor() failed for oversized payload")
+	}
+	event := &nostr.Event{
+		Kind:      config.StandardizedWrapperKind,
+		Content:   ciphertext,
+		CreatedAt: nostr.Now(),
+		PubKey:    pk,
+		Tags:      nostr.Tags{{"p", renoter.PublicKey}},
+	}
+	event.Sign(sk)
+
+	_, err := renoter.unwrapEvent(context.Background(), event)
+	if err == nil || !contains(err.Error(), "exceeds") {
+		t.Errorf("unwrapEvent() error = %v, want size error", err)
+	}
+}
+
+func TestUnwrapEvent_DropsContainerForAnotherRenoter(t *testing.T) {
+	renoter := newOfflineRenoter(t)
+
+	// Garbage content: a container addressed elsewhere must be dropped before decryption fails
+	sk := nostr.GeneratePrivateKey()
+	other, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	event := &nostr.Event{
+		Kind:      config.StandardizedWrapperKind,
+		Content:   "not a NIP-44 payload",
+		CreatedAt: nostr.Now(),
+		Tags:      nostr.Tags{{"p", other}},
+	}
+	event.Sign(sk)
+
+	if inner, err := renoter.unwrapEvent(context.Background(), event); err != nil || inner != nil {
+		t.Errorf("unwrapEvent() = %v, %v, want the container dropped", inner, err)
+	}
+	if err := renoter.HandleEvent(context.Background(), event); err != nil {
+		t.Errorf("HandleEvent() error = %v, want the container dropped", err)
+	}
+}
+
+func FuzzUnwrapEvent_Ciphertext(f *testing.F) {
+	renoter := newOfflineRenoter(f)
+	validCiphertext, _, validPubkey, _ := encryptFor(f, `{"kind":29000}`, renoter.PublicKey)
+
+	f.Add("", "")
+	f.Add("not base64 at all", renoter.PublicKey)
+	f.Add(validCiphertext, validPubkey)
+	f.Add(validCiphertext, renoter.PublicKey)
+	f.Add("#"+validCiphertext[1:], validPubkey)
+	f.Add(strings.Repeat("A", 132), "zz")
+	f.Add(strings.Repeat("/", 87472), validPubkey)
+
+	f.Fuzz(func(t *testing.T, content string, senderPubkey string) {
+		event := &nostr.Event{
+			Kind:      config.StandardizedWrapperKind,
+			Content:   content,
+			CreatedAt: nostr.Now(),
+			PubKey:    senderPubkey,
+			Tags:      nostr.Tags{{"p", renoter.PublicKey}},
+		}
+		// Must never panic; random ciphertexts are expected to fail
+		inner, err := renoter.unwrapEvent(context.Background(), event)
+		if err == nil && inner != nil && inner.GetID() != inner.ID {
+			t.Errorf("unwrapEvent() returned event with invalid ID")
+		}
+	})
+}
+
+func FuzzUnwrapEvent_OuterPlaintext(f *testing.F) {
+	renoter := newOfflineRenoter(f)
+
+	f.Add([]byte(`not json`))
+	f.Add([]byte(`{}`))
+	f.Add([]byte(`{"kind":29000,"tags":[["p"]]}`))
+	f.Add([]byte(`{"kind":29000,"pubkey":"` + renoter.PublicKey + `","tags":[["p","` + renoter.PublicKey + `"]],"content":"AAAA"}`))
+	f.Add([]byte(`{"kind":29000,"tags":[[["p"]]],"content":{}}`))
+	f.Add([]byte(`{"tags":` + strings.Repeat("[", 10000) + strings.Repeat("]", 10000) + `}`))
+	f.Add([]byte(`{"tags":[` + strings.Repeat(`["p","x"],`, 2000) + `[]]}`))
+	f.Add([]byte(`{"content":"` + strings.Repeat(`\u0000`, 1000) + `"}`))
+
+	f.Fuzz(func(t *testing.T, plaintext []byte) {
+		ciphertext, sk, pk, ok := encryptFor(t, string(plaintext), renoter.PublicKey)
+		if !ok {
+			return
+		}
+		event := &nostr.Event{
+			Kind:      config.StandardizedWrapperKind,
+			Content:   ciphertext,
+			CreatedAt: nostr.Now(),
+			PubKey:    pk,
+			Tags:      nostr.Tags{{"p", renoter.PublicKey}},
+		}
+		event.Sign(sk)
+
+		// Must never panic
+		inner, err := renoter.unwrapEvent(context.Background(), event)
+		if err == nil && inner != nil && inner.GetID() != inner.ID {
+			t.Errorf("unwrapEvent() returned event with invalid ID")
+		}
+	})
+}
+
+func FuzzUnwrapEvent_InnerPlaintext(f *testing.F) {
+	renoter := newOfflineRenoter(f)
+
+	final := &nostr.Event{Kind: 1, Content: "hello", CreatedAt: nostr.Now(), Tags: nostr.Tags{}}
+	final.Sign(nostr.GeneratePrivateKey())
+	finalJSON, _ := json.Marshal(final)
+
+	f.Add(finalJSON)
+	f.Add([]byte(`garbage`))
+	f.Add([]byte(`{"id":"` + final.ID + `","tags":[["padding"],[],[""]]}`))
+	f.Add([]byte(`{"kind":29000,"tags":[["p",""],["nonce","1","9999999999"]]}`))
+	f.Add([]byte(`{"tags":[` + strings.Repeat(`["padding","`+strings.Repeat("f", 64)+`"],`, 200) + `[]]}`))
+	f.Add([]byte(`{"tags":` + strings.Repeat("[", 5000) + `}`))
+
+	f.Fuzz(func(t *testing.T, plaintext []byte) {
+		ciphertext, sk, pk, ok := encryptFor(t, string(plaintext), renoter.PublicKey)
+		if !ok {
+			return
+		}
+		inner29000 := &nostr.Event{
+			Kind:      config.WrapperEventKind,
+			Content:   ciphertext,
+			CreatedAt: nostr.Now(),
+			PubKey:    pk,
+			Tags:      nostr.Tags{{"p", renoter.PublicKey}},
+		}
+		inner29000.Sign(sk)
+
+		container, err := buildNextHopContainer(context.Background(), inner29000, renoter.PublicKey, config.StandardizedSize, 0, nil)
+		if err != nil {
+			return // Too large to pad, nothing to unwrap
+		}
+
+		// Must never panic, and anything returned must carry a valid ID
+		inner, err := renoter.unwrapEvent(context.Background(), container)
+		if err == nil && inner != nil && inner.GetID() != inner.ID {
+			t.Errorf("unwrapEvent() returned event with invalid ID")
+		}
+	})
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/girino/renoter/internal/config"
+	"github.com/girino/renoter/internal/sealtag"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip44"
+)
+
+// answerHandshake is StageHandshake: a layer carrying a handshake tag is a capability probe, which
+// is answered with this Renoter's descriptor tags encrypted to the probe and then dropped. Probes
+// skip admission, so they cost the client no PoW; the quotas still apply.
+func (r *Renoter) answerHandshake(ctx context.Context, msg *Message) error {
+	tag := msg.Layer.Tags.Find(config.HandshakeTag)
+	if tag == nil {
+		return nil
+	}
+	values, err := sealtag.Open(tag[1], msg.LayerKey)
+	if err != nil || len(values) == 0 {
+		logging.Warn("server.handshake.answerHandshake: ignoring unreadable capability probe %s: %v", msg.Layer.ID, err)
+		return Drop(DropHandshake)
+	}
+	logging.DebugMethod("server.handshake", "answerHandshake", "Answering capability probe %s (client protocol version %s)", msg.Layer.ID, values[0])
+
+	response, err := r.handshakeResponse(msg)
+	if err != nil {
+		logging.Error("server.handshake.answerHandshake: failed to build response to probe %s: %v", msg.Layer.ID, err)
+		return err
+	}
+	successCount := 0
+	for result := range r.forwarder.PublishMany(ctx, r.relayURLs, *response) {
+		if result.Error == nil {
+			successCount++
+		}
+	}
+	if successCount == 0 {
+		logging.Error("server.handshake.answerHandshake: no relay accepted the response to probe %s", msg.Layer.ID)
+		return fmt.Errorf("failed to publish capability response to any relay")
+	}
+	logging.Info("server.handshake.answerHandshake: Answered capability probe with %s on %d/%d relays", response.ID, successCount, len(r.relayURLs))
+	return Drop(DropHandshake)
+}
+
+// handshakeResponse builds the HandshakeResponseKind event answering the probe in msg.
+func (r *Renoter) handshakeResponse(msg *Message) (*nostr.Event, error) {
+	capabilities := r.Descriptor("").Event().Tags
+	plaintext, err := json.Marshal(capabilities)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize capabilities: %w", err)
+	}
+	content, err := nip44.Encrypt(string(plaintext), msg.LayerKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt capabilities: %w", err)
+	}
+	response := &nostr.Event{
+		Kind:      config.HandshakeResponseKind,
+		CreatedAt: nostr.Now(),
+		Tags:      nostr.Tags{{"p", msg.Layer.PubKey}},
+		Content:   content,
+	}
+	if err := response.Sign(nostr.GeneratePrivateKey()); err != nil {
+		return nil, fmt.Errorf("failed to sign capability response: %w", err)
+	}
+	return response, nil
+}
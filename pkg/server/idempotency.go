@@ -0,0 +1,122 @@
+package server
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/girino/renoter/internal/config"
+	"github.com/girino/renoter/internal/sealtag"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Bounds of the cache of idempotency keys of the final events this Renoter published as exit.
+// Clients resend within minutes, so a day covers every resend with a wide margin. The cutoff is
+// the default duplicate TTL (see SetDuplicateTTL).
+const (
+	deliveryCacheSize   = 50000
+	deliveryCacheCutoff = 24 * time.Hour
+)
+
+// SetDeliveryCache keeps the idempotency keys of published final events in file, so resent copies
+// are still recognized after a restart. Without it the keys are kept in memory only.
+func (r *Renoter) SetDeliveryCache(file string) error {
+	cache, err := OpenEventCache(file, deliveryCacheSize, r.duplicateTTL())
+	if err != nil {
+		logging.Error("server.idempotency.SetDeliveryCache: %v", err)
+		return fmt.Errorf("failed to open delivery cache: %w", err)
+	}
+	if r.deliveries != nil {
+		r.deliveries.Close()
+	}
+	r.deliveries = cache
+	return nil
+}
+
+// SetDuplicateTTL sets how long a published final event is remembered, so identical copies routed
+// by other senders (e.g. the same popular repost) or resent by the client are published only once
+// in that time. Copies arriving later are published again, which relays ignore as duplicates.
+func (r *Renoter) SetDuplicateTTL(ttl time.Duration) error {
+	if ttl <= 0 {
+		return fmt.Errorf("duplicate TTL must be positive, got %v", ttl)
+	}
+	r.deliveryTTL = ttl
+	r.deliveries.SetCutoff(ttl)
+	logging.Info("server.idempotency.SetDuplicateTTL: Publishing each final event at most once per %v", ttl)
+	return nil
+}
+
+// duplicateTTL returns how long published final events are remembered.
+func (r *Renoter) duplicateTTL() time.Duration {
+	if r.deliveryTTL == 0 {
+		return deliveryCacheCutoff
+	}
+	return r.deliveryTTL
+}
+
+// DuplicateStats counts the copies of final events this Renoter did not publish again as exit.
+type DuplicateStats struct {
+	// 29000 layers dropped before decryption because their idempotency key was already published
+	ResentLayers int64
+	// Final events decrypted but not published because an identical one was, within the TTL
+	DuplicateFinals int64
+}
+
+// DuplicateStats returns the copies suppressed since the Renoter was created.
+func (r *Renoter) DuplicateStats() DuplicateStats {
+	return DuplicateStats{ResentLayers: r.resentLayers.Load(), DuplicateFinals: r.duplicateFinals.Load()}
+}
+
+// resentLayer reports whether a 29000 addressed to us carries the idempotency key of an event we
+// already published, which lets the exit drop a resent copy before decrypting its content.
+func (r *Renoter) resentLayer(layer *nostr.Event, conversationKey [32]byte) bool {
+	tag := layer.Tags.Find(config.IdempotencyTag)
+	if tag == nil {
+		return false
+	}
+	values, err := sealtag.Open(tag[1], conversationKey)
+	if err != nil || len(values) == 0 {
+		logging.Warn("server.idempotency.resentLayer: ignoring unreadable idempotency tag on 29000 %s: %v", layer.ID, err)
+		return false
+	}
+	if !r.deliveries.Contains(values[0]) {
+		return false
+	}
+	dropped := r.resentLayers.Add(1)
+	logging.Info("server.idempotency.resentLayer: Dropping 29000 %s, its event was already published (%d resent layers dropped)", layer.ID, dropped)
+	return true
+}
+
+// published reports whether the final event was already published by this Renoter. Keys are
+// always derived from the event ID, never taken from the tag, so a sender cannot mark other events.
+func (r *Renoter) published(event *nostr.Event) bool {
+	return r.deliveries.Contains(config.IdempotencyKey(event.ID))
+}
+
+// markPublished records that a final event was published.
+func (r *Renoter) markPublished(event *nostr.Event) {
+	r.deliveries.Mark(config.IdempotencyKey(event.ID), time.Now())
+}
+
+// claimPublish reserves the publication of a final event, reporting false if it was published
+// within the TTL or another copy is being published right now. The claim must be settled with
+// settlePublish.
+func (r *Renoter) claimPublish(event *nostr.Event) bool {
+	if !r.deliveries.Reserve(config.IdempotencyKey(event.ID), time.Now()) {
+		return true
+	}
+	dropped := r.duplicateFinals.Add(1)
+	logging.Info("server.idempotency.claimPublish: Final event %s already published, dropping the copy (%d duplicates suppressed)", event.ID, dropped)
+	return false
+}
+
+// settlePublish records the outcome of a claimed publication; a failed one may be retried by
+// the next copy.
+func (r *Renoter) settlePublish(event *nostr.Event, ok bool) {
+	key := config.IdempotencyKey(event.ID)
+	if ok {
+		r.deliveries.Confirm(key, time.Now())
+	} else {
+		r.deliveries.Release(key)
+	}
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestUnwrapEvent_DropsResentCopies(t *testing.T) {
+	renoter := newOfflineRenoter(t)
+	event := &nostr.Event{Kind: 1, Content: "sent twice", CreatedAt: nostr.Now(), Tags: nostr.Tags{}}
+	event.Sign(nostr.GeneratePrivateKey())
+
+	// Two independent wraps of the same event, as the client's resend produces
+	first := wrapForRenoters(t, event, []*Renoter{renoter})
+	resend := wrapForRenoters(t, event, []*Renoter{renoter})
+
+	final, err := renoter.unwrapEvent(context.Background(), first)
+	if err != nil || final == nil || final.ID != event.ID {
+		t.Fatalf("unwrapEvent() = %v, %v, want the original event", final, err)
+	}
+	if renoter.published(final) {
+		t.Fatal("published() before the event was marked")
+	}
+	renoter.markPublished(final)
+
+	if inner, err := renoter.unwrapEvent(context.Background(), resend); err != nil || inner != nil {
+		t.Errorf("unwrapEvent() of a resent copy = %v, %v, want it dropped", inner, err)
+	}
+}
+
+func TestSetDeliveryCache_SurvivesRestart(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "deliveries.txt")
+	event := &nostr.Event{Kind: 1, Content: "published once", CreatedAt: nostr.Now(), Tags: nostr.Tags{}}
+	event.Sign(nostr.GeneratePrivateKey())
+
+	before := newOfflineRenoter(t)
+	if err := before.SetDeliveryCache(file); err != nil {
+		t.Fatalf("SetDeliveryCache() error = %v", err)
+	}
+	before.markPublished(event)
+	before.deliveries.Close()
+
+	after := newOfflineRenoter(t)
+	if err := after.SetDeliveryCache(file); err != nil {
+		t.Fatalf("SetDeliveryCache() error = %v", err)
+	}
+	defer after.deliveries.Close()
+	if !after.published(event) {
+		t.Error("restarted Renoter forgot a published event")
+	}
+}
+
+func TestPublishFinal_SuppressesDuplicates(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pool := &fakePool{published: make(chan nostr.Event, 10)}
+	renoter, err := NewRenoterWithPool(ctx, nostr.GeneratePrivateKey(), []string{"wss://relay.example.com"}, pool)
+	if err != nil {
+		t.Fatalf("NewRenoterWithPool() error = %v", err)
+	}
+	event := &nostr.Event{Kind: 6, Content: "popular repost", CreatedAt: nostr.Now(), Tags: nostr.Tags{}}
+	event.Sign(nostr.GeneratePrivateKey())
+
+	// Copies routed by several senders at once are published once
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := renoter.publishFinal(ctx, event); err != nil && !errors.Is(err, ErrDrop) {
+				t.Errorf("publishFinal() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if len(pool.published) != 1 {
+		t.Errorf("published %d copies, want 1", len(pool.published))
+	}
+	if stats := renoter.DuplicateStats(); stats.DuplicateFinals != 4 || stats.ResentLayers != 0 {
+		t.Errorf("DuplicateStats() = %+v, want 4 duplicate finals", stats)
+	}
+
+	// Once the TTL has passed the event is published again
+	if err := renoter.SetDuplicateTTL(0); err == nil {
+		t.Error("SetDuplicateTTL(0) should fail")
+	}
+	if err := renoter.SetDuplicateTTL(10 * time.Millisecond); err != nil {
+		t.Fatalf("SetDuplicateTTL() error = %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	renoter.publishFinal(ctx, event)
+	if len(pool.published) != 2 {
+		t.Errorf("published %d copies after the TTL, want 2", len(pool.published))
+	}
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip44"
+)
+
+// Signer signs events with a Renoter's identity key: its service descriptor, heartbeats and
+// labels.
+type Signer interface {
+	// SignEvent sets the pubkey, ID and signature of event
+	SignEvent(ctx context.Context, event *nostr.Event) error
+}
+
+// Decrypter derives the NIP-44 conversation keys a Renoter opens containers and layers with.
+type Decrypter interface {
+	// ConversationKey returns the NIP-44 conversation key between the identity key and pubkey
+	ConversationKey(ctx context.Context, pubkey string) ([32]byte, error)
+}
+
+// Identity is the long-lived key of a Renoter. KeyIdentity holds it in process memory;
+// RemoteIdentity delegates every operation to a signer service, e.g. in front of an HSM, so the
+// key never enters the Renoter process.
+type Identity interface {
+	Signer
+	Decrypter
+	// PublicKey returns the hex pubkey of the identity
+	PublicKey() string
+}
+
+// KeyIdentity is an Identity holding the private key in process memory.
+type KeyIdentity struct {
+	privateKey string
+	publicKey  string
+}
+
+// NewKeyIdentity creates a KeyIdentity for a hex private key.
+func NewKeyIdentity(privateKey string) (*KeyIdentity, error) {
+	if privateKey == "" {
+		return nil, fmt.Errorf("private key cannot be empty")
+	}
+	pubkey, err := nostr.GetPublicKey(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get public key: %w", err)
+	}
+	return &KeyIdentity{privateKey: privateKey, publicKey: pubkey}, nil
+}
+
+// PublicKey implements Identity.
+func (k *KeyIdentity) PublicKey() string {
+	return k.publicKey
+}
+
+// SignEvent implements Signer.
+func (k *KeyIdentity) SignEvent(ctx context.Context, event *nostr.Event) error {
+	return event.Sign(k.privateKey)
+}
+
+// ConversationKey implements Decrypter.
+func (k *KeyIdentity) ConversationKey(ctx context.Context, pubkey string) ([32]byte, error) {
+	return nip44.GenerateConversationKey(pubkey, k.privateKey)
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip44"
+)
+
+func TestKeyIdentity(t *testing.T) {
+	if _, err := NewKeyIdentity(""); err == nil {
+		t.Error("NewKeyIdentity() with an empty key should fail")
+	}
+
+	sk := nostr.GeneratePrivateKey()
+	identity, err := NewKeyIdentity(sk)
+	if err != nil {
+		t.Fatalf("NewKeyIdentity() error = %v", err)
+	}
+	if pk, _ := nostr.GetPublicKey(sk); identity.PublicKey() != pk {
+		t.Errorf("PublicKey() = %s, want %s", identity.PublicKey(), pk)
+	}
+
+	other := nostr.GeneratePrivateKey()
+	otherPk, _ := nostr.GetPublicKey(other)
+	key, err := identity.ConversationKey(context.Background(), otherPk)
+	if err != nil {
+		t.Fatalf("ConversationKey() error = %v", err)
+	}
+	if want, _ := nip44.GenerateConversationKey(identity.PublicKey(), other); key != want {
+		t.Error("ConversationKey() differs from the key the other side derives")
+	}
+
+	event := nostr.Event{Kind: 1, CreatedAt: nostr.Now(), Tags: nostr.Tags{}}
+	if err := identity.SignEvent(context.Background(), &event); err != nil {
+		t.Fatalf("SignEvent() error = %v", err)
+	}
+	if ok, _ := event.CheckSignature(); !ok || event.PubKey != identity.PublicKey() {
+		t.Error("SignEvent() did not sign the event with the identity key")
+	}
+}
@@ -0,0 +1,160 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/girino/renoter/internal/config"
+	"github.com/girino/renoter/internal/schema"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip13"
+	"github.com/nbd-wtf/go-nostr/nip19"
+)
+
+// IngressPolicy is a khatru RejectEvent policy for relays hosting an entry point: it accepts
+// only well-formed 29001 containers of a valid size and PoW addressed to one of the registered
+// Renoters. It holds no Renoter key, so the layers inside are left to the Renoters. Install it
+// with
+//
+//	relay.RejectEvent = append(relay.RejectEvent, policy.RejectEvent)
+type IngressPolicy struct {
+	mu       sync.RWMutex
+	renoters map[string]bool
+	// Content lengths of containers holding a 29000 of one of the bucket sizes
+	contentSizes []int
+	largest      int
+	minPoW       int
+	otherKinds   bool
+}
+
+// NewIngressPolicy creates an ingress policy for containers padded to limits, whose IDs have at
+// least minPoW leading zero bits (0 = none). The limits must match those of the registered
+// Renoters. No Renoter is registered yet, so every container is rejected until Register is called.
+func NewIngressPolicy(limits config.SizeLimits, minPoW int) (*IngressPolicy, error) {
+	if err := limits.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid size limits: %w", err)
+	}
+	if minPoW < 0 || minPoW > 256 {
+		return nil, fmt.Errorf("PoW difficulty must be between 0 and 256, got %d", minPoW)
+	}
+	p := &IngressPolicy{renoters: make(map[string]bool), largest: limits.StandardizedSize, minPoW: minPoW}
+	for _, size := range limits.Sizes() {
+		p.contentSizes = append(p.contentSizes, config.ContainerContentSize(size))
+	}
+	return p, nil
+}
+
+// Register adds Renoters, as hex pubkeys or npubs, that containers may be addressed to. Nothing
+// is registered if any of them is invalid.
+func (p *IngressPolicy) Register(renoters ...string) error {
+	pubkeys := make([]string, len(renoters))
+	for i, renoter := range renoters {
+		pubkey, err := parseRenoterKey(renoter)
+		if err != nil {
+			return err
+		}
+		pubkeys[i] = pubkey
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, pubkey := range pubkeys {
+		p.renoters[pubkey] = true
+	}
+	logging.Info("server.ingress.Register: Accepting containers for %d Renoters", len(p.renoters))
+	return nil
+}
+
+// Unregister removes Renoters, as hex pubkeys or npubs. Unknown or invalid keys are ignored.
+func (p *IngressPolicy) Unregister(renoters ...string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, renoter := range renoters {
+		if pubkey, err := parseRenoterKey(renoter); err == nil {
+			delete(p.renoters, pubkey)
+		}
+	}
+}
+
+// Registered returns the hex pubkeys of the registered Renoters, sorted.
+func (p *IngressPolicy) Registered() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	pubkeys := make([]string, 0, len(p.renoters))
+	for pubkey := range p.renoters {
+		pubkeys = append(pubkeys, pubkey)
+	}
+	slices.Sort(pubkeys)
+	return pubkeys
+}
+
+// SetAllowOtherKinds sets whether events other than 29001 containers pass the policy untouched,
+// for relays that also serve other traffic. They are rejected by default.
+func (p *IngressPolicy) SetAllowOtherKinds(allow bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.otherKinds = allow
+}
+
+// RejectEvent is the khatru RejectEvent hook. The message is a config.Rejection.
+func (p *IngressPolicy) RejectEvent(ctx context.Context, event *nostr.Event) (bool, string) {
+	if err := p.Check(event); err != nil {
+		logging.DebugMethod("server.ingress", "RejectEvent", "Rejecting event %s: %v", event.ID, err)
+		return true, err.Error()
+	}
+	return false, ""
+}
+
+// Check returns the rejection of event, or nil if the policy accepts it.
+func (p *IngressPolicy) Check(event *nostr.Event) *config.Rejection {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if event.Kind != config.StandardizedWrapperKind {
+		if p.otherKinds {
+			return nil
+		}
+		return config.NewRejection(config.RejectKindNotAllowed, strconv.Itoa(event.Kind), "this relay only accepts Renoter containers")
+	}
+	if event.GetID() != event.ID {
+		return config.NewRejection(config.RejectBadID, "", "event ID does not match its contents")
+	}
+	if ok, _ := event.CheckSignature(); !ok {
+		return config.NewRejection(config.RejectBadSignature, "", "event signature is invalid")
+	}
+	if err := schema.CheckContainer(event); err != nil {
+		return config.NewRejection(config.RejectMalformedWrapper, "", err.Error())
+	}
+	recipient, _ := config.RoutingPubkey(event)
+	if !p.renoters[recipient] {
+		return config.NewRejection(config.RejectUnknownRenoter, "", "container is not addressed to a Renoter served by this relay")
+	}
+	if !slices.Contains(p.contentSizes, len(event.Content)) {
+		return config.NewRejection(config.RejectBadSize, strconv.Itoa(p.largest), fmt.Sprintf("container content of %d bytes does not hold a padded 29000", len(event.Content)))
+	}
+	if p.minPoW > 0 {
+		if bits := nip13.Difficulty(event.ID); bits < p.minPoW {
+			return config.NewRejection(config.RejectInsufficientPoW, strconv.Itoa(p.minPoW), fmt.Sprintf("difficulty %d is less than %d", bits, p.minPoW))
+		}
+	}
+	return nil
+}
+
+// parseRenoterKey returns the hex pubkey of a Renoter given as hex or npub.
+func parseRenoterKey(renoter string) (string, error) {
+	renoter = strings.TrimSpace(renoter)
+	if strings.HasPrefix(renoter, "npub1") {
+		prefix, value, err := nip19.Decode(renoter)
+		if err != nil || prefix != "npub" {
+			return "", fmt.Errorf("invalid npub %q: %v", renoter, err)
+		}
+		renoter = value.(string)
+	}
+	if !nostr.IsValidPublicKey(renoter) {
+		return "", fmt.Errorf("invalid Renoter pubkey %q", renoter)
+	}
+	return renoter, nil
+}
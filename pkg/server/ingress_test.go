@@ -0,0 +1,89 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/girino/renoter/internal/config"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip19"
+	"github.com/nbd-wtf/go-nostr/nip44"
+)
+
+func TestIngressPolicy(t *testing.T) {
+	limits := config.SizeLimits{StandardizedSize: 4096, MaxInnerEventSize: 4096 - config.PaddingTagOverhead, Buckets: []int{1024}}
+	policy, err := NewIngressPolicy(limits, 0)
+	if err != nil {
+		t.Fatalf("NewIngressPolicy() error = %v", err)
+	}
+	renoter, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	npub, _ := nip19.EncodePublicKey(renoter)
+
+	// A container holding a padded 29000 of size bytes for recipient
+	container := func(recipient string, size int) *nostr.Event {
+		sk := nostr.GeneratePrivateKey()
+		key, _ := nip44.GenerateConversationKey(recipient, sk)
+		content, err := nip44.Encrypt(strings.Repeat("x", size), key)
+		if err != nil {
+			t.Fatalf("Encrypt() error = %v", err)
+		}
+		event := &nostr.Event{Kind: config.StandardizedWrapperKind, Content: content, CreatedAt: nostr.Now(), Tags: nostr.Tags{{"p", recipient}}}
+		event.Sign(sk)
+		return event
+	}
+	reason := func(event *nostr.Event) string {
+		if rejection := policy.Check(event); rejection != nil {
+			return rejection.Reason
+		}
+		return ""
+	}
+
+	if got := reason(container(renoter, 1024)); got != config.RejectUnknownRenoter {
+		t.Errorf("container for an unregistered Renoter: reason %q, want %q", got, config.RejectUnknownRenoter)
+	}
+	if err := policy.Register(npub); err != nil {
+		t.Fatalf("Register() error = %v", err)
+	}
+	if err := policy.Register("not-a-key"); err == nil {
+		t.Error("Register() accepted an invalid key")
+	}
+	if got := policy.Registered(); len(got) != 1 || got[0] != renoter {
+		t.Errorf("Registered() = %v, want [%s]", got, renoter)
+	}
+	for _, size := range []int{1024, 4096} {
+		if got := reason(container(renoter, size)); got != "" {
+			t.Errorf("%d byte container rejected: %q", size, got)
+		}
+	}
+	if got := reason(container(renoter, 2000)); got != config.RejectBadSize {
+		t.Errorf("container between buckets: reason %q, want %q", got, config.RejectBadSize)
+	}
+	tampered := container(renoter, 1024)
+	tampered.Tags = append(tampered.Tags, nostr.Tag{"t", "renoter"})
+	tampered.Sign(nostr.GeneratePrivateKey())
+	if got := reason(tampered); got != config.RejectMalformedWrapper {
+		t.Errorf("container with an extra tag: reason %q, want %q", got, config.RejectMalformedWrapper)
+	}
+	note := &nostr.Event{Kind: 1, Content: "hello", CreatedAt: nostr.Now()}
+	note.Sign(nostr.GeneratePrivateKey())
+	if got := reason(note); got != config.RejectKindNotAllowed {
+		t.Errorf("kind 1: reason %q, want %q", got, config.RejectKindNotAllowed)
+	}
+	policy.SetAllowOtherKinds(true)
+	if reject, msg := policy.RejectEvent(context.Background(), note); reject {
+		t.Errorf("kind 1 rejected with other kinds allowed: %s", msg)
+	}
+
+	policy.Unregister(renoter)
+	if reject, msg := policy.RejectEvent(context.Background(), container(renoter, 1024)); !reject || !strings.HasPrefix(msg, "blocked: unknown-renoter") {
+		t.Errorf("RejectEvent() after Unregister = %v, %q", reject, msg)
+	}
+
+	// Containers mined below the difficulty are refused with a NIP-01 pow message
+	policy, _ = NewIngressPolicy(limits, 30)
+	policy.Register(renoter)
+	if reject, msg := policy.RejectEvent(context.Background(), container(renoter, 1024)); !reject || !strings.HasPrefix(msg, "pow: insufficient-pow:30") {
+		t.Errorf("RejectEvent() of an unmined container = %v, %q", reject, msg)
+	}
+}
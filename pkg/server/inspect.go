@@ -0,0 +1,113 @@
+package server
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/girino/renoter/internal/config"
+	"github.com/girino/renoter/internal/padding"
+	"github.com/girino/renoter/internal/stamp"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip13"
+)
+
+// Inspection is a redacted summary of one 29001 container, for debugging stuck events. It holds
+// sizes, PoW and addressing only, never the content of the event inside.
+type Inspection struct {
+	// ID of the container
+	ContainerID string
+	// When the container was created
+	CreatedAt nostr.Timestamp
+	// Serialized size of the container in bytes
+	ContainerSize int
+	// Leading zero bits of the container ID
+	ContainerPoW int
+	// Whether the container signature is valid
+	ValidSignature bool
+	// Hex pubkey the container is addressed to, and whether that is this Renoter
+	AddressedTo string
+	ForUs       bool
+
+	// Whether the 29000 layer inside could be decrypted and parsed; the fields below are only set
+	// if it was
+	Opened bool
+	// Size bucket of the padded layer
+	Bucket int
+	// Hex pubkey the layer is addressed to
+	LayerAddressedTo string
+	// Leading zero bits of the layer ID, or of its stamp ID if it carries a stamp
+	LayerPoW int
+	Stamped  bool
+	// Names of the tags of the layer, sealed values left out
+	LayerTags []string
+
+	// Whether the event inside the layer could be decrypted and verified; the fields below are
+	// only set if it was
+	Decrypted bool
+	// Kind of the event inside the layer
+	InnerKind int
+	// Whether the event inside is another 29000 to forward, rather than the final event
+	NextHop bool
+}
+
+// Inspect decrypts the layer of a 29001 container addressed to the Renoter with privateKey,
+// offline, and summarizes it. limits are the Renoter's size limits. The summary is filled in as
+// far as decoding got, and the error tells why it stopped. Nothing is published and no cache,
+// quota or admission check is involved.
+func Inspect(privateKey string, limits config.SizeLimits, container *nostr.Event) (*Inspection, error) {
+	identity, err := NewKeyIdentity(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	return InspectWithIdentity(identity, limits, container)
+}
+
+// InspectWithIdentity is like Inspect for the Renoter with identity, e.g. a RemoteIdentity.
+func InspectWithIdentity(identity Identity, limits config.SizeLimits, container *nostr.Event) (*Inspection, error) {
+	if err := limits.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid size limits: %w", err)
+	}
+	r := &Renoter{PublicKey: identity.PublicKey(), identity: identity, standardizedSize: limits.StandardizedSize, buckets: limits.Buckets}
+
+	inspection := &Inspection{ContainerID: container.ID, CreatedAt: container.CreatedAt, ContainerPoW: nip13.Difficulty(container.ID)}
+	if containerJSON, err := padding.Marshal(container); err == nil {
+		inspection.ContainerSize = len(containerJSON)
+	}
+	inspection.ValidSignature, _ = container.CheckSignature()
+	if container.Kind != config.StandardizedWrapperKind {
+		return inspection, fmt.Errorf("event is kind %d, not a %d container", container.Kind, config.StandardizedWrapperKind)
+	}
+	var err error
+	inspection.AddressedTo, err = config.RoutingPubkey(container)
+	if err != nil {
+		return inspection, err
+	}
+	inspection.ForUs = inspection.AddressedTo == r.PublicKey
+	if !inspection.ForUs {
+		return inspection, fmt.Errorf("container is addressed to %s, not this Renoter", inspection.AddressedTo)
+	}
+
+	ctx := context.Background()
+	msg := &Message{Container: container}
+	if err := r.openContainer(ctx, msg); err != nil {
+		return inspection, err
+	}
+	inspection.Opened = true
+	inspection.Bucket = msg.Bucket
+	inspection.LayerAddressedTo, _ = config.RoutingPubkey(msg.Layer)
+	inspection.LayerPoW = nip13.Difficulty(stamp.Work(msg.Layer))
+	inspection.Stamped = stamp.Stamped(msg.Layer)
+	for _, tag := range msg.Layer.Tags {
+		if len(tag) > 0 {
+			inspection.LayerTags = append(inspection.LayerTags, tag[0])
+		}
+	}
+
+	if err := r.decryptLayer(ctx, msg); err != nil {
+		return inspection, err
+	}
+	inspection.Decrypted = true
+	inspection.InnerKind = msg.Inner.Kind
+	inspection.NextHop = msg.Inner.Kind == config.WrapperEventKind
+	return inspection, nil
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/girino/renoter/internal/config"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestInspect(t *testing.T) {
+	sk := nostr.GeneratePrivateKey()
+	us, _ := nostr.GetPublicKey(sk)
+	limits := config.DefaultSizeLimits()
+
+	final := &nostr.Event{Kind: 1, Content: "secret", CreatedAt: nostr.Now(), Tags: nostr.Tags{}}
+	final.Sign(nostr.GeneratePrivateKey())
+	finalJSON, _ := json.Marshal(final)
+	content, layerSk, _, _ := encryptFor(t, string(finalJSON), us)
+	layer := &nostr.Event{Kind: config.WrapperEventKind, Content: content, CreatedAt: nostr.Now(), Tags: nostr.Tags{{"p", us}}}
+	layer.Sign(layerSk)
+	container := sealContainer(t, us, layer, nostr.Now(), nostr.Tags{{"p", us}})
+
+	inspection, err := Inspect(sk, limits, container)
+	if err != nil {
+		t.Fatalf("Inspect() error = %v", err)
+	}
+	if !inspection.ValidSignature || !inspection.ForUs || !inspection.Opened || !inspection.Decrypted {
+		t.Errorf("Inspect() = %+v, want a valid container for us, opened and decrypted", inspection)
+	}
+	if inspection.LayerAddressedTo != us || inspection.InnerKind != 1 || inspection.NextHop {
+		t.Errorf("Inspect() = %+v, want a layer for us holding a final kind 1", inspection)
+	}
+	if summary, _ := json.Marshal(inspection); strings.Contains(string(summary), "secret") {
+		t.Errorf("Inspect() leaked the final event: %s", summary)
+	}
+
+	// Containers for other Renoters are not opened
+	other, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	container = sealContainer(t, other, layer, nostr.Now(), nostr.Tags{{"p", other}})
+	if inspection, err := Inspect(sk, limits, container); err == nil || inspection.ForUs || inspection.Opened {
+		t.Errorf("Inspect() of a container for another Renoter = %+v, %v", inspection, err)
+	}
+}
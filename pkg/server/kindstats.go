@@ -0,0 +1,145 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/girino/nostr-lib/logging"
+)
+
+// Shape of the final kind stats: counts of complete hours only, each rounded to the nearest
+// KindStatsRounding, for the last KindStatsHours hours.
+const (
+	KindStatsHours    = 24
+	KindStatsRounding = 10
+)
+
+// kindCounter counts the final events published per kind and hour. It never sees anything but
+// the kind and the hour.
+type kindCounter struct {
+	mu    sync.Mutex
+	hours map[time.Time]map[int]int64
+}
+
+// record counts one final event of kind published at now, forgetting hours too old to report.
+func (c *kindCounter) record(kind int, now time.Time) {
+	hour := now.UTC().Truncate(time.Hour)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.hours == nil {
+		c.hours = make(map[time.Time]map[int]int64)
+	}
+	if c.hours[hour] == nil {
+		c.hours[hour] = make(map[int]int64)
+		for old := range c.hours {
+			if hour.Sub(old) > KindStatsHours*time.Hour {
+				delete(c.hours, old)
+			}
+		}
+	}
+	c.hours[hour][kind]++
+}
+
+// snapshot returns the rounded counts of the complete hours before now.
+func (c *kindCounter) snapshot(now time.Time) KindStats {
+	current := now.UTC().Truncate(time.Hour)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var stats KindStats
+	for hour, kinds := range c.hours {
+		if !hour.Before(current) || current.Sub(hour) > KindStatsHours*time.Hour {
+			continue
+		}
+		counts := KindCounts{Hour: hour, Kinds: make(map[int]int64)}
+		for kind, count := range kinds {
+			if rounded := roundCount(count); rounded > 0 {
+				counts.Kinds[kind] = rounded
+			}
+		}
+		stats.Hours = append(stats.Hours, counts)
+		if hour.Equal(current.Add(-time.Hour)) {
+			stats.LastHour = counts.Kinds
+		}
+	}
+	slices.SortFunc(stats.Hours, func(a, b KindCounts) int { return a.Hour.Compare(b.Hour) })
+	return stats
+}
+
+// roundCount rounds count to the nearest multiple of KindStatsRounding, halves up.
+func roundCount(count int64) int64 {
+	return (count + KindStatsRounding/2) / KindStatsRounding * KindStatsRounding
+}
+
+// KindCounts are the final events published in one hour, by kind, rounded. Kinds whose count
+// rounds to 0 are left out.
+type KindCounts struct {
+	// Start of the hour, in UTC
+	Hour  time.Time     `json:"hour"`
+	Kinds map[int]int64 `json:"kinds"`
+}
+
+// KindStats are the kinds of the final events an exit published, without their content, so
+// operators can tell what their node is used for. Only complete hours are reported, and counts
+// are rounded, so the stats cannot be matched with single events seen on the relays.
+type KindStats struct {
+	// The complete hours of the last KindStatsHours with published final events, oldest first
+	Hours []KindCounts `json:"hours"`
+	// The counts of the last complete hour (nil if nothing was published in it)
+	LastHour map[int]int64 `json:"last_hour"`
+}
+
+// KindStats returns the kinds of the final events published in the last KindStatsHours
+// complete hours. It is empty if SetKindStats turned them off.
+func (r *Renoter) KindStats() KindStats {
+	if r.kindStatsOff {
+		return KindStats{}
+	}
+	return r.kindCounts.snapshot(time.Now())
+}
+
+// SetKindStats sets whether the kinds of published final events are counted for KindStats. On
+// by default.
+func (r *Renoter) SetKindStats(enabled bool) {
+	r.kindStatsOff = !enabled
+	if !enabled {
+		logging.Info("server.kindstats.SetKindStats: Not counting the kinds of published final events")
+	}
+}
+
+// recordKind counts a published final event of kind, if enabled.
+func (r *Renoter) recordKind(kind int) {
+	if !r.kindStatsOff {
+		r.kindCounts.record(kind, time.Now())
+	}
+}
+
+// Totals returns the counts of every kind summed over the hours.
+func (s KindStats) Totals() map[int]int64 {
+	totals := make(map[int]int64)
+	for _, hour := range s.Hours {
+		for kind, count := range hour.Kinds {
+			totals[kind] += count
+		}
+	}
+	return totals
+}
+
+// WriteMetrics writes the stats in the Prometheus text format, as gauges labelled by kind: the
+// last complete hour, and the sum of the reported hours.
+func (s KindStats) WriteMetrics(w io.Writer) {
+	fmt.Fprintf(w, "# HELP renoter_final_events_last_hour Final events published in the last complete hour, by kind, rounded to %d.\n", KindStatsRounding)
+	fmt.Fprintf(w, "# TYPE renoter_final_events_last_hour gauge\n")
+	for _, kind := range slices.Sorted(maps.Keys(s.LastHour)) {
+		fmt.Fprintf(w, "renoter_final_events_last_hour{kind=\"%d\"} %d\n", kind, s.LastHour[kind])
+	}
+	totals := s.Totals()
+	fmt.Fprintf(w, "# HELP renoter_final_events_last_day Final events published in the last %d complete hours, by kind, rounded to %d per hour.\n", KindStatsHours, KindStatsRounding)
+	fmt.Fprintf(w, "# TYPE renoter_final_events_last_day gauge\n")
+	for _, kind := range slices.Sorted(maps.Keys(totals)) {
+		fmt.Fprintf(w, "renoter_final_events_last_day{kind=\"%d\"} %d\n", kind, totals[kind])
+	}
+}
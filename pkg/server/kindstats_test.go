@@ -0,0 +1,74 @@
+package server
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKindCounter_Snapshot(t *testing.T) {
+	now := time.Date(2026, 5, 1, 12, 30, 0, 0, time.UTC)
+	var c kindCounter
+	record := func(kind int, count int, at time.Time) {
+		for i := 0; i < count; i++ {
+			c.record(kind, at)
+		}
+	}
+	record(1, 14, now.Add(-25*time.Hour))
+	record(1, 16, now.Add(-2*time.Hour))
+	record(7, 4, now.Add(-2*time.Hour))
+	record(1, 25, now.Add(-time.Hour))
+	record(30023, 12, now.Add(-time.Hour))
+	// The current hour is not complete yet
+	record(1, 50, now)
+
+	stats := c.snapshot(now)
+	if len(stats.Hours) != 2 {
+		t.Fatalf("snapshot() = %+v, want the 2 complete hours of the last day", stats)
+	}
+	if stats.Hours[0].Hour != time.Date(2026, 5, 1, 10, 0, 0, 0, time.UTC) || stats.Hours[0].Kinds[1] != 20 {
+		t.Errorf("first hour = %+v, want 20 kind 1 events at 10:00", stats.Hours[0])
+	}
+	if _, ok := stats.Hours[0].Kinds[7]; ok {
+		t.Error("kinds rounding to 0 should be left out")
+	}
+	if stats.LastHour[1] != 30 || stats.LastHour[30023] != 10 {
+		t.Errorf("LastHour = %v, want 30 kind 1 and 10 kind 30023 events", stats.LastHour)
+	}
+	if totals := stats.Totals(); totals[1] != 50 || totals[30023] != 10 || len(totals) != 2 {
+		t.Errorf("Totals() = %v", totals)
+	}
+
+	var out strings.Builder
+	stats.WriteMetrics(&out)
+	for _, line := range []string{
+		"# TYPE renoter_final_events_last_hour gauge",
+		`renoter_final_events_last_hour{kind="1"} 30`,
+		`renoter_final_events_last_hour{kind="30023"} 10`,
+		`renoter_final_events_last_day{kind="1"} 50`,
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Errorf("metrics missing %q:\n%s", line, out.String())
+		}
+	}
+
+	// Hours older than the reported window are forgotten as new ones start
+	record(1, 1, now.Add(time.Hour))
+	if len(c.hours) != 4 {
+		t.Errorf("kept %d hours, want the 25 hour old one forgotten", len(c.hours))
+	}
+}
+
+func TestRenoter_SetKindStats(t *testing.T) {
+	renoter := newOfflineRenoter(t)
+	renoter.SetKindStats(false)
+	renoter.recordKind(1)
+	if len(renoter.kindCounts.hours) != 0 {
+		t.Error("kinds should not be counted when off")
+	}
+	renoter.SetKindStats(true)
+	renoter.recordKind(1)
+	if len(renoter.kindCounts.hours) != 1 {
+		t.Error("kinds should be counted by default")
+	}
+}
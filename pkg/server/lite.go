@@ -0,0 +1,40 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/girino/renoter/internal/config"
+)
+
+// SetLiteProfile sets whether this Renoter supports the lite profile, announced in its
+// descriptor: it also forwards containers padded to config.LiteSize, re-wrapping the next layer
+// to that size, and admits the 29000 layers inside them with PoW of config.LitePoWDifficulty when
+// it requires more. Clients on metered connections use it for events small enough, on paths
+// where every Renoter supports it. Off by default; the standardized size must be larger than
+// config.LiteSize.
+func (r *Renoter) SetLiteProfile(enabled bool) error {
+	if enabled && r.standardizedSize <= config.LiteSize {
+		logging.Error("server.lite.SetLiteProfile: standardized size %d leaves no room for the lite profile", r.standardizedSize)
+		return fmt.Errorf("the lite profile needs a standardized size larger than %d bytes, got %d", config.LiteSize, r.standardizedSize)
+	}
+	r.lite = enabled
+	if enabled {
+		logging.Info("server.lite.SetLiteProfile: Supporting the lite profile (%d byte containers, PoW %d)", config.LiteSize, config.LitePoWDifficulty)
+	}
+	return nil
+}
+
+// containerBucket returns the size to re-wrap the next layer of a container to, from the size of
+// its plaintext: config.LiteSize for lite containers, the bucket it fits in otherwise.
+func (r *Renoter) containerBucket(size int) int {
+	if r.lite && size == config.LiteSize {
+		return config.LiteSize
+	}
+	return r.sizeLimits().Bucket(size)
+}
+
+// liteContainer reports whether a container re-wrapped to bucket is in the lite profile.
+func (r *Renoter) liteContainer(bucket int) bool {
+	return r.lite && bucket == config.LiteSize
+}
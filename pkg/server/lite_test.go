@@ -0,0 +1,98 @@
+package server
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/girino/renoter/internal/config"
+	"github.com/girino/renoter/internal/descriptor"
+	"github.com/girino/renoter/pkg/client"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestSetLiteProfile(t *testing.T) {
+	renoter := newOfflineRenoter(t)
+	if err := renoter.SetLiteProfile(true); err != nil {
+		t.Fatalf("SetLiteProfile() error = %v", err)
+	}
+	if d := renoter.Descriptor(""); !slices.Equal(d.Profiles, []string{descriptor.ProfileLite}) || len(d.Buckets) != 0 {
+		t.Errorf("Descriptor() profiles = %v, buckets = %v; want lite and no extra bucket", d.Profiles, d.Buckets)
+	}
+
+	small := newOfflineRenoter(t)
+	if err := small.SetSizeLimits(config.LiteSizeLimits()); err != nil {
+		t.Fatalf("SetSizeLimits() error = %v", err)
+	}
+	if err := small.SetLiteProfile(true); err == nil {
+		t.Error("SetLiteProfile() should refuse a standardized size with no room for lite containers")
+	}
+}
+
+func TestRoundTrip_LiteProfile(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping round-trip test in short mode (mines PoW)")
+	}
+
+	event := &nostr.Event{Kind: 1, Content: "sent over a metered connection", CreatedAt: nostr.Now(), Tags: nostr.Tags{}}
+	event.Sign(nostr.GeneratePrivateKey())
+
+	renoters := newPathRenoters(t, 2)
+	path := make(client.Path, len(renoters))
+	for i, renoter := range renoters {
+		if err := renoter.SetLiteProfile(true); err != nil {
+			t.Fatalf("SetLiteProfile() error = %v", err)
+		}
+		d := renoter.Descriptor("")
+		path[i].PubKey, _ = hex.DecodeString(renoter.PublicKey)
+		path[i].Descriptor = &d
+	}
+	opts := client.DefaultOptions()
+	opts.LiteProfile = true
+	container, err := client.WrapEvent(context.Background(), event, path, client.WrapOptions{Options: opts})
+	if err != nil {
+		t.Fatalf("WrapEvent() error = %v", err)
+	}
+	if len(container.Content) != config.ContainerContentSize(config.LiteSize) {
+		t.Fatalf("container content is %d bytes, want a lite container", len(container.Content))
+	}
+
+	// Every hop keeps the lite size and admits the lighter PoW, though it requires more by default
+	for i, renoter := range renoters {
+		msg := &Message{Container: container, ReceivedAt: time.Now()}
+		if err := renoter.pipeline.run(context.Background(), msg, StageRecipient, StageForward); err != nil {
+			t.Fatalf("hop %d: error = %v", i, err)
+		}
+		if msg.Bucket != config.LiteSize {
+			t.Errorf("hop %d: bucket = %d, want %d", i, msg.Bucket, config.LiteSize)
+		}
+		if i == len(renoters)-1 {
+			originalJSON, _ := json.Marshal(event)
+			finalJSON, _ := json.Marshal(msg.Inner)
+			if string(finalJSON) != string(originalJSON) {
+				t.Errorf("recovered event differs from original\n got: %s\nwant: %s", finalJSON, originalJSON)
+			}
+			break
+		}
+		container, err = buildNextHopContainer(context.Background(), msg.Inner, renoters[i+1].PublicKey, msg.Bucket, 0, nil)
+		if err != nil {
+			t.Fatalf("hop %d: buildNextHopContainer() error = %v", i, err)
+		}
+	}
+
+	// A Renoter without the lite profile holds the layer to its full PoW
+	strict := newPathRenoters(t, 1)[0]
+	path = client.Path{{Descriptor: path[0].Descriptor}}
+	path[0].PubKey, _ = hex.DecodeString(strict.PublicKey)
+	container, err = client.WrapEvent(context.Background(), event, path, client.WrapOptions{Options: opts})
+	if err != nil {
+		t.Fatalf("WrapEvent() error = %v", err)
+	}
+	if _, err := strict.unwrapEvent(context.Background(), container); err == nil || !strings.Contains(err.Error(), "committed difficulty") {
+		t.Errorf("unwrapEvent() without the lite profile error = %v, want a difficulty error", err)
+	}
+}
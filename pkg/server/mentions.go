@@ -0,0 +1,199 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Relay list kinds looked up for mentioned pubkeys: NIP-65 read relays for public events,
+// NIP-17 DM relays for gift wraps.
+const (
+	relayListKind   = 10002
+	dmRelayListKind = 10050
+	giftWrapKind    = 1059
+)
+
+// mentionLookupTimeout bounds the relay list lookup made for a single final event.
+const mentionLookupTimeout = 5 * time.Second
+
+// MentionPolicy configures the optional delivery of final events to the inbox relays of the
+// pubkeys they mention ("p" tags), so anonymous replies reach the people replied to.
+type MentionPolicy struct {
+	// Mentioned pubkeys whose relay lists are looked up per event
+	MaxMentions int
+	// Hard cap on the inbox relays an event is published to, on top of the Renoter's own relays
+	MaxRelays int
+	// Relays the relay lists are fetched from (empty = the Renoter's relays)
+	LookupRelays []string
+}
+
+// Validate checks that the policy is usable.
+func (p MentionPolicy) Validate() error {
+	if p.MaxMentions <= 0 {
+		return fmt.Errorf("mentions looked up must be positive, got %d", p.MaxMentions)
+	}
+	if p.MaxRelays <= 0 {
+		return fmt.Errorf("inbox relays per event must be positive, got %d", p.MaxRelays)
+	}
+	return nil
+}
+
+// SetMentionPolicy enables publishing final events to the inbox relays of mentioned pubkeys.
+func (r *Renoter) SetMentionPolicy(policy MentionPolicy) error {
+	if err := policy.Validate(); err != nil {
+		logging.Error("server.mentions.SetMentionPolicy: invalid policy: %v", err)
+		return fmt.Errorf("invalid mention policy: %w", err)
+	}
+	r.mentions = &policy
+	logging.Info("server.mentions.SetMentionPolicy: Delivering final events to the inbox relays of up to %d mentions (at most %d relays)", policy.MaxMentions, policy.MaxRelays)
+	return nil
+}
+
+// mentionedPubkeys returns the distinct valid pubkeys in the "p" tags of event, at most max.
+func mentionedPubkeys(event *nostr.Event, max int) []string {
+	var pubkeys []string
+	seen := make(map[string]bool)
+	for _, tag := range event.Tags {
+		if len(pubkeys) == max {
+			break
+		}
+		if len(tag) < 2 || tag[0] != "p" || !nostr.IsValidPublicKey(tag[1]) || seen[tag[1]] {
+			continue
+		}
+		seen[tag[1]] = true
+		pubkeys = append(pubkeys, tag[1])
+	}
+	return pubkeys
+}
+
+// inboxRelays extracts the relays a relay list event asks to be written to: NIP-65 "r" tags
+// marked read or unmarked, or NIP-17 "relay" tags.
+func inboxRelays(list *nostr.Event) []string {
+	var relays []string
+	for _, tag := range list.Tags {
+		switch {
+		case list.Kind == relayListKind && len(tag) >= 2 && tag[0] == "r" && (len(tag) == 2 || tag[2] == "read"):
+			relays = append(relays, tag[1])
+		case list.Kind == dmRelayListKind && len(tag) >= 2 && tag[0] == "relay":
+			relays = append(relays, tag[1])
+		}
+	}
+	return relays
+}
+
+// usableRelayURL reports whether an inbox relay may be published to. Relay lists are written by
+// anyone, so only secure websocket URLs on public hosts are followed; this keeps senders from
+// aiming the exit at its own network.
+func usableRelayURL(relayURL string) bool {
+	u, err := url.Parse(relayURL)
+	if err != nil || u.Scheme != "wss" || u.Hostname() == "" {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") || strings.HasSuffix(host, ".local") {
+		return false
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.IsGlobalUnicast() && !ip.IsPrivate()
+	}
+	return true
+}
+
+// selectInboxRelays picks up to max usable relays from the lists of each mentioned pubkey, taking
+// one relay per pubkey in turn so every mention is reached before any gets a second relay.
+// Relays in exclude (the Renoter's own) are skipped.
+func selectInboxRelays(pubkeys []string, lists map[string][]string, exclude []string, max int) []string {
+	taken := make(map[string]bool)
+	for _, relayURL := range exclude {
+		taken[nostr.NormalizeURL(relayURL)] = true
+	}
+	var selected []string
+	for len(selected) < max {
+		progressed := false
+		for _, pubkey := range pubkeys {
+			relays := lists[pubkey]
+			// Skip relays already taken or unusable to find this pubkey's next candidate
+			for len(relays) > 0 {
+				candidate := nostr.NormalizeURL(relays[0])
+				relays = relays[1:]
+				if taken[candidate] || !usableRelayURL(candidate) {
+					continue
+				}
+				taken[candidate] = true
+				selected = append(selected, candidate)
+				progressed = true
+				break
+			}
+			lists[pubkey] = relays
+			if len(selected) == max {
+				break
+			}
+		}
+		if !progressed {
+			break
+		}
+	}
+	return selected
+}
+
+// mentionRelays looks up the inbox relays of the pubkeys a final event mentions, or returns nil
+// if mention delivery is off.
+func (r *Renoter) mentionRelays(ctx context.Context, event *nostr.Event) []string {
+	if r.mentions == nil {
+		return nil
+	}
+	pubkeys := mentionedPubkeys(event, r.mentions.MaxMentions)
+	if len(pubkeys) == 0 {
+		return nil
+	}
+	kind := relayListKind
+	if event.Kind == giftWrapKind {
+		kind = dmRelayListKind
+	}
+	lookupRelays := r.mentions.LookupRelays
+	if len(lookupRelays) == 0 {
+		lookupRelays = r.relayURLs
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, mentionLookupTimeout)
+	defer cancel()
+	newest := make(map[string]nostr.Timestamp)
+	lists := make(map[string][]string)
+	for relayEvent := range r.pool.FetchMany(ctx, lookupRelays, nostr.Filter{Kinds: []int{kind}, Authors: pubkeys}) {
+		list := relayEvent.Event
+		if list.CreatedAt <= newest[list.PubKey] {
+			continue
+		}
+		newest[list.PubKey] = list.CreatedAt
+		lists[list.PubKey] = inboxRelays(list)
+	}
+
+	relays := selectInboxRelays(pubkeys, lists, r.relayURLs, r.mentions.MaxRelays)
+	logging.DebugMethod("server.mentions", "mentionRelays", "Found %d inbox relays for %d mentions of event %s", len(relays), len(pubkeys), event.ID)
+	return relays
+}
+
+// deliverToMentions publishes a final event to the inbox relays of the pubkeys it mentions.
+// Delivery there is best effort: the event is already on the Renoter's own relays.
+func (r *Renoter) deliverToMentions(ctx context.Context, event *nostr.Event) {
+	relays := r.mentionRelays(ctx, event)
+	if len(relays) == 0 {
+		return
+	}
+	successCount := 0
+	for result := range r.forwarder.PublishMany(ctx, relays, *event) {
+		if result.Error != nil {
+			logging.Warn("server.mentions.deliverToMentions: failed to publish final event %s to inbox relay %s: %v", event.ID, result.RelayURL, result.Error)
+		} else {
+			successCount++
+		}
+	}
+	logging.Info("server.mentions.deliverToMentions: Published final event %s to %d/%d inbox relays of mentioned pubkeys", event.ID, successCount, len(relays))
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestMentionedPubkeys(t *testing.T) {
+	alice, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	bob, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	carol, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	event := &nostr.Event{Tags: nostr.Tags{{"e", alice}, {"p", alice}, {"p", "not-a-key"}, {"p", alice}, {"p", bob}, {"p", carol}}}
+
+	if got := mentionedPubkeys(event, 2); !reflect.DeepEqual(got, []string{alice, bob}) {
+		t.Errorf("mentionedPubkeys() = %v, want alice and bob", got)
+	}
+}
+
+func TestInboxRelays(t *testing.T) {
+	nip65 := &nostr.Event{Kind: relayListKind, Tags: nostr.Tags{
+		{"r", "wss://both.example.com"},
+		{"r", "wss://read.example.com", "read"},
+		{"r", "wss://write.example.com", "write"},
+	}}
+	if got := inboxRelays(nip65); !reflect.DeepEqual(got, []string{"wss://both.example.com", "wss://read.example.com"}) {
+		t.Errorf("inboxRelays(10002) = %v", got)
+	}
+	dm := &nostr.Event{Kind: dmRelayListKind, Tags: nostr.Tags{{"relay", "wss://dm.example.com"}, {"r", "wss://ignored.example.com"}}}
+	if got := inboxRelays(dm); !reflect.DeepEqual(got, []string{"wss://dm.example.com"}) {
+		t.Errorf("inboxRelays(10050) = %v", got)
+	}
+}
+
+func TestUsableRelayURL(t *testing.T) {
+	for relayURL, want := range map[string]bool{
+		"wss://relay.example.com":   true,
+		"wss://203.0.113.9:7777":    true,
+		"ws://relay.example.com":    false,
+		"https://relay.example.com": false,
+		"wss://localhost:7777":      false,
+		"wss://127.0.0.1":           false,
+		"wss://10.0.0.5":            false,
+		"wss://[::1]":               false,
+		"wss://printer.local":       false,
+	} {
+		if got := usableRelayURL(relayURL); got != want {
+			t.Errorf("usableRelayURL(%q) = %v, want %v", relayURL, got, want)
+		}
+	}
+}
+
+func TestSelectInboxRelays(t *testing.T) {
+	alice, bob := strings.Repeat("a", 64), strings.Repeat("b", 64)
+	lists := map[string][]string{
+		alice: {"wss://own.example.com", "wss://a1.example.com", "wss://a2.example.com", "wss://a3.example.com"},
+		bob:   {"wss://a1.example.com", "ws://insecure.example.com", "wss://b1.example.com"},
+	}
+
+	// Every mention gets a relay before any gets a second one; own, shared and unusable relays are skipped
+	got := selectInboxRelays([]string{alice, bob}, lists, []string{"wss://own.example.com"}, 3)
+	want := []string{"wss://a1.example.com", "wss://b1.example.com", "wss://a2.example.com"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("selectInboxRelays() = %v, want %v", got, want)
+	}
+}
+
+func TestSetMentionPolicy_Validates(t *testing.T) {
+	renoter := newOfflineRenoter(t)
+	if err := renoter.SetMentionPolicy(MentionPolicy{MaxMentions: 0, MaxRelays: 5}); err == nil {
+		t.Error("SetMentionPolicy() should reject a zero mention limit")
+	}
+	if err := renoter.SetMentionPolicy(MentionPolicy{MaxMentions: 3, MaxRelays: 5}); err != nil || renoter.mentions == nil {
+		t.Errorf("SetMentionPolicy() error = %v", err)
+	}
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/fiatjaf/eventstore"
+	"github.com/girino/nostr-lib/logging"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// DefaultMirrorPruneInterval is how often the mirror is pruned when MirrorPolicy.PruneInterval is 0.
+const DefaultMirrorPruneInterval = 10 * time.Minute
+
+// mirrorPruneBatch is how many events each query of a prune pass reads.
+const mirrorPruneBatch = 500
+
+// MirrorPolicy limits what the local mirror of published final events keeps. At least one of
+// Retention and MaxEvents must be set: the mirror never grows without bound.
+type MirrorPolicy struct {
+	// Events created longer ago than this are deleted (0 = no age limit)
+	Retention time.Duration
+	// Only the newest this many events are kept (0 = no count limit)
+	MaxEvents int
+	// How often the limits are applied (0 = DefaultMirrorPruneInterval)
+	PruneInterval time.Duration
+}
+
+// Validate checks that the policy is usable.
+func (p MirrorPolicy) Validate() error {
+	if p.Retention < 0 {
+		return fmt.Errorf("mirror retention must not be negative, got %v", p.Retention)
+	}
+	if p.MaxEvents < 0 {
+		return fmt.Errorf("maximum mirrored events must not be negative, got %d", p.MaxEvents)
+	}
+	if p.Retention == 0 && p.MaxEvents == 0 {
+		return fmt.Errorf("a mirror needs a retention or a maximum number of events")
+	}
+	if p.PruneInterval < 0 {
+		return fmt.Errorf("mirror prune interval must not be negative, got %v", p.PruneInterval)
+	}
+	return nil
+}
+
+// mirror is the local copy of the final events a Renoter published.
+type mirror struct {
+	store  eventstore.Store
+	policy MirrorPolicy
+}
+
+// StartMirror keeps a copy of every final event this Renoter publishes as exit in store, e.g.
+// for abuse response, and prunes it to policy now and then every policy.PruneInterval until ctx
+// is done. It initializes store; CloseMirror closes it. Unlike the audit log, the mirror holds
+// whole events, content and author included, so it is off unless started. Age is measured by the
+// events' created_at.
+func (r *Renoter) StartMirror(ctx context.Context, store eventstore.Store, policy MirrorPolicy) error {
+	if err := policy.Validate(); err != nil {
+		logging.Error("server.mirror.StartMirror: invalid mirror policy: %v", err)
+		return fmt.Errorf("invalid mirror policy: %w", err)
+	}
+	if policy.PruneInterval == 0 {
+		policy.PruneInterval = DefaultMirrorPruneInterval
+	}
+	if err := store.Init(); err != nil {
+		logging.Error("server.mirror.StartMirror: failed to initialize store: %v", err)
+		return fmt.Errorf("failed to initialize mirror store: %w", err)
+	}
+	m := &mirror{store: store, policy: policy}
+	if _, err := m.prune(ctx, time.Now()); err != nil {
+		logging.Warn("server.mirror.StartMirror: failed to prune mirror: %v", err)
+	}
+	r.mirror = m
+	logging.Info("server.mirror.StartMirror: Mirroring published final events (retention %v, at most %d events)", policy.Retention, policy.MaxEvents)
+
+	r.life.run(ctx, func(ctx context.Context) {
+		ticker := time.NewTicker(policy.PruneInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := m.prune(ctx, time.Now()); err != nil {
+					logging.Warn("server.mirror.StartMirror: failed to prune mirror: %v", err)
+				}
+			}
+		}
+	})
+	return nil
+}
+
+// CloseMirror closes the mirror store, if any. Call it once the Renoter is closed.
+func (r *Renoter) CloseMirror() {
+	if r.mirror != nil {
+		r.mirror.store.Close()
+	}
+}
+
+// mirrorEvent saves a published final event to the mirror, if any. Failures are only logged:
+// the event is out already.
+func (r *Renoter) mirrorEvent(ctx context.Context, event *nostr.Event) {
+	if r.mirror == nil {
+		return
+	}
+	err := r.mirror.store.SaveEvent(context.WithoutCancel(ctx), event)
+	if err != nil && !errors.Is(err, eventstore.ErrDupEvent) {
+		logging.Error("server.mirror.mirrorEvent: failed to mirror event %s: %v", event.ID, err)
+		return
+	}
+	logging.DebugMethod("server.mirror", "mirrorEvent", "Mirrored final event %s", event.ID)
+}
+
+// prune deletes the events beyond the policy's limits, reading the store newest first, and
+// returns how many it deleted.
+func (m *mirror) prune(ctx context.Context, now time.Time) (int, error) {
+	var cutoff nostr.Timestamp
+	if m.policy.Retention > 0 {
+		cutoff = nostr.Timestamp(now.Add(-m.policy.Retention).Unix())
+	}
+	seen := make(map[string]bool)
+	kept, deleted := 0, 0
+	var until *nostr.Timestamp
+	for {
+		ch, err := m.store.QueryEvents(ctx, nostr.Filter{Until: until, Limit: mirrorPruneBatch})
+		if err != nil {
+			return deleted, fmt.Errorf("failed to query mirror: %w", err)
+		}
+		var batch []*nostr.Event
+		for event := range ch {
+			batch = append(batch, event)
+		}
+		fresh := 0
+		for _, event := range batch {
+			if seen[event.ID] {
+				continue
+			}
+			seen[event.ID] = true
+			fresh++
+			if event.CreatedAt >= cutoff && (m.policy.MaxEvents == 0 || kept < m.policy.MaxEvents) {
+				kept++
+				continue
+			}
+			if err := m.store.DeleteEvent(ctx, event); err != nil {
+				return deleted, fmt.Errorf("failed to delete %s from mirror: %w", event.ID, err)
+			}
+			deleted++
+		}
+		if len(batch) == 0 {
+			break
+		}
+		// Read on from the oldest timestamp of the batch, which may hold more events, or past it
+		// when it holds more than a batch
+		next := batch[len(batch)-1].CreatedAt
+		if fresh == 0 {
+			if len(batch) < mirrorPruneBatch || next == 0 {
+				break
+			}
+			next--
+		}
+		until = &next
+	}
+	if deleted > 0 {
+		logging.DebugMethod("server.mirror", "prune", "Pruned %d events from the mirror, keeping %d", deleted, kept)
+	}
+	return deleted, nil
+}
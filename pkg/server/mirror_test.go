@@ -0,0 +1,117 @@
+package server
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/girino/renoter/internal/storage"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestMirrorPolicy_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		policy  MirrorPolicy
+		wantErr bool
+	}{
+		{"retention", MirrorPolicy{Retention: time.Hour}, false},
+		{"max events", MirrorPolicy{MaxEvents: 10}, false},
+		{"unbounded", MirrorPolicy{}, true},
+		{"negative retention", MirrorPolicy{Retention: -time.Hour}, true},
+		{"negative max events", MirrorPolicy{Retention: time.Hour, MaxEvents: -1}, true},
+		{"negative interval", MirrorPolicy{MaxEvents: 10, PruneInterval: -time.Second}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.policy.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPublishFinal_Mirrors(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pool := &fakePool{published: make(chan nostr.Event, 10)}
+	renoter, err := NewRenoterWithPool(ctx, nostr.GeneratePrivateKey(), []string{"wss://relay.example.com"}, pool)
+	if err != nil {
+		t.Fatalf("NewRenoterWithPool() error = %v", err)
+	}
+
+	// Off by default
+	event := &nostr.Event{Kind: 1, Content: "first", CreatedAt: nostr.Now(), Tags: nostr.Tags{}}
+	event.Sign(nostr.GeneratePrivateKey())
+	if err := renoter.publishFinal(ctx, event); err != nil {
+		t.Fatalf("publishFinal() error = %v", err)
+	}
+
+	store := &storage.EventFile{Path: filepath.Join(t.TempDir(), "mirror.jsonl")}
+	if err := renoter.StartMirror(ctx, store, MirrorPolicy{Retention: time.Hour}); err != nil {
+		t.Fatalf("StartMirror() error = %v", err)
+	}
+	defer renoter.CloseMirror()
+	mirrored := &nostr.Event{Kind: 1, Content: "second", CreatedAt: nostr.Now(), Tags: nostr.Tags{}}
+	mirrored.Sign(nostr.GeneratePrivateKey())
+	if err := renoter.publishFinal(ctx, mirrored); err != nil {
+		t.Fatalf("publishFinal() error = %v", err)
+	}
+
+	ch, _ := store.QueryEvents(ctx, nostr.Filter{})
+	var got []*nostr.Event
+	for e := range ch {
+		got = append(got, e)
+	}
+	if len(got) != 1 || got[0].ID != mirrored.ID || got[0].Content != mirrored.Content || got[0].Sig != mirrored.Sig {
+		t.Errorf("mirror holds %v, want only the event published after StartMirror", got)
+	}
+}
+
+func TestMirror_Prune(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(100000, 0)
+	store := &storage.EventFile{Path: filepath.Join(t.TempDir(), "mirror.jsonl")}
+	if err := store.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	defer store.Close()
+
+	// Many events sharing timestamps, so pruning reads on through batches and ties
+	sk := nostr.GeneratePrivateKey()
+	for i := 0; i < 3*mirrorPruneBatch; i++ {
+		event := &nostr.Event{Kind: 1, Content: "note", CreatedAt: nostr.Timestamp(now.Unix()) - nostr.Timestamp(i/7), Tags: nostr.Tags{{"i", time.Duration(i).String()}}}
+		event.Sign(sk)
+		if err := store.SaveEvent(ctx, event); err != nil {
+			t.Fatalf("SaveEvent() error = %v", err)
+		}
+	}
+	count := func() int {
+		ch, _ := store.QueryEvents(ctx, nostr.Filter{})
+		n := 0
+		for range ch {
+			n++
+		}
+		return n
+	}
+
+	// Events created more than 100 seconds ago: 7 per second for seconds 101 to 214
+	m := &mirror{store: store, policy: MirrorPolicy{Retention: 100 * time.Second}}
+	deleted, err := m.prune(ctx, now)
+	if err != nil {
+		t.Fatalf("prune() error = %v", err)
+	}
+	if want := 3*mirrorPruneBatch - 101*7; deleted != want || count() != 101*7 {
+		t.Errorf("prune(retention) deleted %d, left %d; want %d deleted, %d left", deleted, count(), want, 101*7)
+	}
+
+	m.policy = MirrorPolicy{MaxEvents: 600}
+	if deleted, err := m.prune(ctx, now); err != nil || deleted != 101*7-600 || count() != 600 {
+		t.Errorf("prune(max events) = %d, %v, left %d; want %d deleted, 600 left", deleted, err, count(), 101*7-600)
+	}
+	if deleted, _ := m.prune(ctx, now); deleted != 0 {
+		t.Errorf("prune() again deleted %d, want 0", deleted)
+	}
+}
@@ -0,0 +1,106 @@
+package server
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/girino/renoter/internal/config"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// What a Renoter does with 29001 containers addressed to another Renoter, see
+// SetMisaddressedPolicy.
+const (
+	// Drop them; only containers addressed to this Renoter are asked for
+	MisaddressedDrop = "drop"
+	// Republish them unchanged to the listen relays
+	MisaddressedListen = "listen"
+	// Republish them unchanged to the relays of the policy
+	MisaddressedRelays = "relays"
+)
+
+// MisaddressedPolicy lets a Renoter carry the containers of its peers between relays that do not
+// propagate events to each other, so the Renoter a container is addressed to sees it on the
+// relays it listens on. To see such containers at all, the subscription then asks for every 29001
+// container instead of only those addressed to this Renoter. Containers are republished once:
+// copies coming back are caught as replays.
+type MisaddressedPolicy struct {
+	// MisaddressedDrop, MisaddressedListen or MisaddressedRelays
+	Action string
+	// Relays containers are republished to with MisaddressedRelays; any relays, not only the
+	// Renoter's own
+	Relays []string
+}
+
+// Validate checks that the policy is usable.
+func (p MisaddressedPolicy) Validate() error {
+	switch p.Action {
+	case MisaddressedDrop, MisaddressedListen:
+		if len(p.Relays) > 0 {
+			return fmt.Errorf("relays are only used with %q", MisaddressedRelays)
+		}
+	case MisaddressedRelays:
+		if len(p.Relays) == 0 {
+			return fmt.Errorf("%q needs at least one relay", MisaddressedRelays)
+		}
+		for _, url := range p.Relays {
+			if !nostr.IsValidRelayURL(url) {
+				return fmt.Errorf("invalid relay URL %q", url)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown action %q (use %s, %s or %s)", p.Action, MisaddressedDrop, MisaddressedListen, MisaddressedRelays)
+	}
+	return nil
+}
+
+// SetMisaddressedPolicy sets what is done with containers addressed to another Renoter. It must
+// be called before subscribing.
+func (r *Renoter) SetMisaddressedPolicy(policy MisaddressedPolicy) error {
+	if err := policy.Validate(); err != nil {
+		logging.Error("server.misaddressed.SetMisaddressedPolicy: invalid policy: %v", err)
+		return fmt.Errorf("invalid misaddressed policy: %w", err)
+	}
+	relays := make([]string, len(policy.Relays))
+	for i, url := range policy.Relays {
+		relays[i] = nostr.NormalizeURL(url)
+	}
+	policy.Relays = relays
+	r.misaddressed = policy
+	logging.Info("server.misaddressed.SetMisaddressedPolicy: Containers addressed to other Renoters: %s %v", policy.Action, policy.Relays)
+	return nil
+}
+
+// republishesMisaddressed reports whether containers addressed to other Renoters are republished.
+func (r *Renoter) republishesMisaddressed() bool {
+	return r.misaddressed.Action != "" && r.misaddressed.Action != MisaddressedDrop
+}
+
+// addressedToUs reports whether the routing tag of a container is our pubkey.
+func (r *Renoter) addressedToUs(container *nostr.Event) bool {
+	recipient, err := config.RoutingPubkey(container)
+	return err == nil && recipient == r.PublicKey
+}
+
+// republishMisaddressed publishes a container addressed to another Renoter, unchanged, to the
+// relays of the policy.
+func (r *Renoter) republishMisaddressed(ctx context.Context, container *nostr.Event) {
+	relayURLs := r.misaddressed.Relays
+	if r.misaddressed.Action == MisaddressedListen {
+		relayURLs = r.listenRelayURLs()
+	}
+	accepted := 0
+	for result := range r.forwarder.PublishMany(ctx, relayURLs, *container) {
+		if result.Error != nil {
+			logging.DebugMethod("server.misaddressed", "republishMisaddressed", "Relay %s refused 29001 %s: %v", result.RelayURL, container.ID, result.Error)
+			continue
+		}
+		accepted++
+	}
+	if accepted == 0 {
+		logging.Warn("server.misaddressed.republishMisaddressed: no relay accepted 29001 %s addressed to another Renoter", container.ID)
+		return
+	}
+	logging.DebugMethod("server.misaddressed", "republishMisaddressed", "Republished 29001 %s addressed to another Renoter to %d/%d relays", container.ID, accepted, len(relayURLs))
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/girino/renoter/internal/config"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestMisaddressedPolicy_Validate(t *testing.T) {
+	for _, policy := range []MisaddressedPolicy{
+		{Action: MisaddressedDrop},
+		{Action: MisaddressedListen},
+		{Action: MisaddressedRelays, Relays: []string{"wss://other.example.com"}},
+	} {
+		if err := policy.Validate(); err != nil {
+			t.Errorf("Validate(%+v) error = %v", policy, err)
+		}
+	}
+	for _, policy := range []MisaddressedPolicy{
+		{Action: "forward"},
+		{Action: MisaddressedRelays},
+		{Action: MisaddressedRelays, Relays: []string{"https://other.example.com"}},
+		{Action: MisaddressedListen, Relays: []string{"wss://other.example.com"}},
+	} {
+		if err := policy.Validate(); err == nil {
+			t.Errorf("Validate(%+v) should fail", policy)
+		}
+	}
+}
+
+func TestRenoter_RepublishesMisaddressed(t *testing.T) {
+	ctx := context.Background()
+	pool := &fakePool{published: make(chan nostr.Event, 2)}
+	renoter, err := NewRenoterWithPool(ctx, nostr.GeneratePrivateKey(), []string{"wss://relay.example.com"}, pool)
+	if err != nil {
+		t.Fatalf("NewRenoterWithPool() error = %v", err)
+	}
+	if _, ok := renoter.subscriptionFilter().Tags["p"]; !ok {
+		t.Error("subscriptionFilter() should only ask for our containers by default")
+	}
+	if err := renoter.SetMisaddressedPolicy(MisaddressedPolicy{Action: MisaddressedRelays, Relays: []string{"wss://other.example.com"}}); err != nil {
+		t.Fatalf("SetMisaddressedPolicy() error = %v", err)
+	}
+	if _, ok := renoter.subscriptionFilter().Tags["p"]; ok {
+		t.Error("subscriptionFilter() should ask for every container when republishing")
+	}
+
+	peer, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	ciphertext, sk, _, _ := encryptFor(t, "for a peer", peer)
+	container := nostr.Event{Kind: config.StandardizedWrapperKind, CreatedAt: nostr.Now(), Tags: nostr.Tags{{"p", peer}}, Content: ciphertext}
+	container.Sign(sk)
+	if err := renoter.Handle(ctx, &container); err != nil {
+		t.Fatalf("Handle() error = %v", err)
+	}
+	select {
+	case published := <-pool.published:
+		if published.ID != container.ID || published.Sig != container.Sig {
+			t.Errorf("republished %+v, want the container unchanged", published)
+		}
+	default:
+		t.Fatal("the container addressed to a peer was not republished")
+	}
+
+	// A copy coming back is not republished again
+	renoter.Handle(ctx, &container)
+	select {
+	case <-pool.published:
+		t.Error("a replayed container was republished")
+	default:
+	}
+}
@@ -0,0 +1,121 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/girino/renoter/internal/config"
+	"github.com/girino/renoter/internal/sealtag"
+)
+
+// MixingPolicy bounds the delays layers asking for one (the mixed lane) are held for. Layers
+// without a delay tag are always forwarded immediately.
+type MixingPolicy struct {
+	// Shortest delay a layer asking for one is held for
+	Min time.Duration
+	// Longest delay a layer is held for; 0 forwards every layer immediately
+	Max time.Duration
+	// Distribution delays are drawn from around the requested mean (config.MixingExponential
+	// or config.MixingUniform)
+	Distribution string
+}
+
+// DefaultMixingPolicy returns the policy of a new Renoter: exponential delays of at most
+// config.DefaultMaxMixingDelay.
+func DefaultMixingPolicy() MixingPolicy {
+	return MixingPolicy{Max: config.DefaultMaxMixingDelay, Distribution: config.MixingExponential}
+}
+
+// Validate checks that the policy is usable.
+func (p MixingPolicy) Validate() error {
+	if p.Min < 0 || p.Max < 0 {
+		return fmt.Errorf("mixing delays must not be negative")
+	}
+	if p.Min > p.Max {
+		return fmt.Errorf("minimum mixing delay %v exceeds the maximum %v", p.Min, p.Max)
+	}
+	if p.Max > config.MaxDelayHint {
+		return fmt.Errorf("maximum mixing delay %v exceeds %v", p.Max, config.MaxDelayHint)
+	}
+	if p.Distribution != config.MixingExponential && p.Distribution != config.MixingUniform {
+		return fmt.Errorf("unknown mixing distribution %q (use %s or %s)", p.Distribution, config.MixingExponential, config.MixingUniform)
+	}
+	return nil
+}
+
+// draw returns a delay with the given mean from the policy's distribution, within its bounds.
+func (p MixingPolicy) draw(mean time.Duration) time.Duration {
+	var delay time.Duration
+	switch p.Distribution {
+	case config.MixingUniform:
+		delay = time.Duration(rand.Int63n(int64(2*mean) + 1))
+	default:
+		delay = time.Duration(rand.ExpFloat64() * float64(mean))
+	}
+	return min(max(delay, p.Min), p.Max)
+}
+
+// SetMixingPolicy sets the bounds and distribution of the delays layers asking for one are held
+// for; they are announced in the service descriptor.
+func (r *Renoter) SetMixingPolicy(policy MixingPolicy) error {
+	if err := policy.Validate(); err != nil {
+		logging.Error("server.mixing.SetMixingPolicy: invalid policy: %v", err)
+		return fmt.Errorf("invalid mixing policy: %w", err)
+	}
+	r.mixing = policy
+	logging.Info("server.mixing.SetMixingPolicy: Holding mixed-lane layers for %v to %v (%s)", policy.Min, policy.Max, policy.Distribution)
+	return nil
+}
+
+// drawDelay is StageDelay: if the layer carries a delay tag, it draws a delay around the
+// requested mean from the mixing policy. An unreadable tag is ignored and the layer forwarded
+// immediately.
+func (r *Renoter) drawDelay(ctx context.Context, msg *Message) error {
+	tag := msg.Layer.Tags.Find(config.DelayTag)
+	if tag == nil || r.mixing.Max == 0 {
+		return nil
+	}
+	values, err := sealtag.Open(tag[1], msg.LayerKey)
+	if err != nil || len(values) == 0 {
+		logging.Warn("server.mixing.drawDelay: ignoring unreadable delay tag on 29000 %s: %v", msg.Layer.ID, err)
+		return nil
+	}
+	mean, err := config.ParseDelayHint(values[0])
+	if err != nil {
+		logging.Warn("server.mixing.drawDelay: ignoring delay tag on 29000 %s: %v", msg.Layer.ID, err)
+		return nil
+	}
+	msg.Delay = r.mixing.draw(mean)
+	logging.DebugMethod("server.mixing", "drawDelay", "Holding 29000 %s for %v (mean %v requested)", msg.Layer.ID, msg.Delay, mean)
+	return nil
+}
+
+// hold forwards the event inside a layer once its delay has passed, unless ctx is done first.
+// With MaxHeld layers already held, it waits for one of them to be forwarded.
+func (r *Renoter) hold(ctx context.Context, msg *Message) {
+	release, ok := r.reserveHold(ctx, msg)
+	if !ok {
+		logging.Warn("server.mixing.hold: shutting down, dropping event %s waiting to be held", msg.Inner.ID)
+		return
+	}
+	r.life.run(ctx, func(ctx context.Context) {
+		defer release()
+		timer := time.NewTimer(msg.Delay)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			logging.Warn("server.mixing.hold: shutting down, dropping event %s held for mixing", msg.Inner.ID)
+			return
+		}
+		err := r.forwardInner(ctx, msg)
+		if err != nil && !errors.Is(err, ErrDrop) {
+			logging.Error("server.mixing.hold: failed to forward event %s after a %v delay: %v", msg.Inner.ID, msg.Delay, err)
+		}
+		r.notifySender(ctx, msg, forwardOutcome(msg, err))
+	})
+}
@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"encoding/hex"
+	"testing"
+	"time"
+
+	"github.com/girino/renoter/internal/config"
+	"github.com/girino/renoter/pkg/client"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestHandle_HoldsMixedLaneLayers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pool := &fakePool{published: make(chan nostr.Event, 2)}
+	renoter, err := NewRenoterWithPool(ctx, nostr.GeneratePrivateKey(), []string{"wss://relay.example.com"}, pool)
+	if err != nil {
+		t.Fatalf("NewRenoterWithPool() error = %v", err)
+	}
+	renoter.SetMixingPolicy(MixingPolicy{Max: 100 * time.Millisecond, Distribution: config.MixingExponential})
+	var delays []time.Duration
+	renoter.Pipeline().InsertBefore(StageForward, Stage{Name: "observe", Run: func(ctx context.Context, msg *Message) error {
+		delays = append(delays, msg.Delay)
+		return nil
+	}})
+
+	wrap := func(lane string) *nostr.Event {
+		event := &nostr.Event{Kind: 1, Content: "lane " + lane, CreatedAt: nostr.Now(), Tags: nostr.Tags{}}
+		event.Sign(nostr.GeneratePrivateKey())
+		path := make(client.Path, 1)
+		path[0].PubKey, _ = hex.DecodeString(renoter.PublicKey)
+		opts := client.DefaultOptions()
+		opts.Lane, opts.MixingDelay = lane, time.Hour
+		wrapped, err := client.WrapEvent(ctx, event, path, client.WrapOptions{Options: opts})
+		if err != nil {
+			t.Fatalf("WrapEvent() error = %v", err)
+		}
+		return wrapped
+	}
+
+	// The fast lane is forwarded before Handle returns
+	if err := renoter.Handle(ctx, wrap(config.LaneFast)); err != nil {
+		t.Fatalf("Handle() error = %v", err)
+	}
+	if len(pool.published) != 1 || delays[0] != 0 {
+		t.Fatalf("fast lane: %d published, delay %v; want 1 published at once", len(pool.published), delays[0])
+	}
+	<-pool.published
+
+	// The mixed lane asks for an hour, which the operator caps to 100ms
+	if err := renoter.Handle(ctx, wrap(config.LaneMixed)); err != nil {
+		t.Fatalf("Handle() error = %v", err)
+	}
+	if delays[1] <= 0 || delays[1] > 100*time.Millisecond {
+		t.Errorf("mixed lane delay = %v, want within (0, 100ms]", delays[1])
+	}
+	select {
+	case <-pool.published:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the held event was never published")
+	}
+}
+
+func TestMixingPolicy(t *testing.T) {
+	for _, policy := range []MixingPolicy{
+		{Min: -time.Second, Max: time.Minute, Distribution: config.MixingUniform},
+		{Min: time.Minute, Max: time.Second, Distribution: config.MixingUniform},
+		{Max: 48 * time.Hour, Distribution: config.MixingUniform},
+		{Max: time.Minute, Distribution: "normal"},
+	} {
+		if err := policy.Validate(); err == nil {
+			t.Errorf("Validate(%+v) should fail", policy)
+		}
+	}
+
+	for _, distribution := range []string{config.MixingExponential, config.MixingUniform} {
+		policy := MixingPolicy{Min: 2 * time.Second, Max: 20 * time.Second, Distribution: distribution}
+		if err := policy.Validate(); err != nil {
+			t.Fatalf("Validate(%+v) error = %v", policy, err)
+		}
+		var total time.Duration
+		for i := 0; i < 2000; i++ {
+			delay := policy.draw(8 * time.Second)
+			if delay < policy.Min || delay > policy.Max {
+				t.Fatalf("%s draw = %v, outside %v..%v", distribution, delay, policy.Min, policy.Max)
+			}
+			total += delay
+		}
+		// Clamping shifts the mean a little; it stays near the requested 8s
+		if mean := total / 2000; mean < 6*time.Second || mean > 10*time.Second {
+			t.Errorf("%s mean delay = %v, want about 8s", distribution, mean)
+		}
+	}
+}
+
+func TestDescriptor_AnnouncesMixing(t *testing.T) {
+	renoter := newOfflineRenoter(t)
+	policy := MixingPolicy{Min: time.Second, Max: time.Minute, Distribution: config.MixingUniform}
+	if err := renoter.SetMixingPolicy(policy); err != nil {
+		t.Fatalf("SetMixingPolicy() error = %v", err)
+	}
+	if d := renoter.Descriptor(""); d.MixingMin != time.Second || d.MixingMax != time.Minute || d.MixingDistribution != config.MixingUniform {
+		t.Errorf("Descriptor() mixing = %v..%v %q, want the policy", d.MixingMin, d.MixingMax, d.MixingDistribution)
+	}
+	renoter.SetMixingPolicy(MixingPolicy{Distribution: config.MixingExponential})
+	if d := renoter.Descriptor(""); d.MixingMax != 0 {
+		t.Errorf("Descriptor() announced mixing %v for a Renoter that never holds layers", d.MixingMax)
+	}
+}
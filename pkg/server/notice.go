@@ -0,0 +1,99 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/girino/renoter/internal/config"
+	"github.com/girino/renoter/internal/sealtag"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip44"
+)
+
+// SetErrorNotices sets whether this Renoter sends an error notice to senders asking for one when
+// it rejects their layer (the default).
+func (r *Renoter) SetErrorNotices(enabled bool) {
+	r.noticesOff = !enabled
+}
+
+// replyKey returns the reply pubkey sealed in the layer's reply tag, or "" if the layer does not
+// ask for error notices, the tag is unreadable or notices are disabled.
+func (r *Renoter) replyKey(msg *Message) string {
+	if r.noticesOff || msg.Layer == nil || msg.LayerKey == [32]byte{} {
+		return ""
+	}
+	tag := msg.Layer.Tags.Find(config.ReplyTag)
+	if tag == nil {
+		return ""
+	}
+	values, err := sealtag.Open(tag[1], msg.LayerKey)
+	if err != nil || len(values) == 0 || !nostr.IsValid32ByteHex(values[0]) {
+		logging.Warn("server.notice.replyKey: ignoring unreadable reply tag on 29000 %s: %v", msg.Layer.ID, err)
+		return ""
+	}
+	return values[0]
+}
+
+// notifySender tells the sender of the layer in msg how it ended with result, if the layer asks:
+// a hop acknowledgement, and an error notice if it was rejected.
+func (r *Renoter) notifySender(ctx context.Context, msg *Message, result Outcome) {
+	r.acknowledge(ctx, msg, result)
+	if result.Kind == OutcomeRejected {
+		r.sendErrorNotice(ctx, msg, result)
+	}
+}
+
+// sendErrorNotice publishes an ErrorNoticeKind event telling the sender of the layer in msg that
+// this Renoter rejected it, if the layer carries a reply key. Failures are only logged.
+func (r *Renoter) sendErrorNotice(ctx context.Context, msg *Message, result Outcome) {
+	reply := r.replyKey(msg)
+	if reply == "" {
+		return
+	}
+	event, err := r.errorNotice(reply, config.ErrorNotice{Renoter: r.PublicKey, Stage: result.Stage, Reason: result.Reason})
+	if err != nil {
+		logging.Warn("server.notice.sendErrorNotice: failed to build error notice for 29000 %s: %v", msg.Layer.ID, err)
+		return
+	}
+	successCount := 0
+	for result := range r.forwarder.PublishMany(ctx, r.relayURLs, *event) {
+		if result.Error == nil {
+			successCount++
+		}
+	}
+	if successCount == 0 {
+		logging.Warn("server.notice.sendErrorNotice: no relay accepted the error notice for 29000 %s", msg.Layer.ID)
+		return
+	}
+	logging.DebugMethod("server.notice", "sendErrorNotice", "Sent error notice %s for rejected 29000 %s (%s)", event.ID, msg.Layer.ID, result.Reason)
+}
+
+// errorNotice builds the ErrorNoticeKind event carrying notice, encrypted to reply with a
+// throwaway key that also signs it.
+func (r *Renoter) errorNotice(reply string, notice config.ErrorNotice) (*nostr.Event, error) {
+	plaintext, err := json.Marshal(notice)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize error notice: %w", err)
+	}
+	sk := nostr.GeneratePrivateKey()
+	conversationKey, err := nip44.GenerateConversationKey(reply, sk)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate conversation key: %w", err)
+	}
+	content, err := nip44.Encrypt(string(plaintext), conversationKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt error notice: %w", err)
+	}
+	event := &nostr.Event{
+		Kind:      config.ErrorNoticeKind,
+		CreatedAt: nostr.Now(),
+		Tags:      nostr.Tags{{"p", reply}},
+		Content:   content,
+	}
+	if err := event.Sign(sk); err != nil {
+		return nil, fmt.Errorf("failed to sign error notice: %w", err)
+	}
+	return event, nil
+}
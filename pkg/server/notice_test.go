@@ -0,0 +1,78 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/girino/renoter/internal/config"
+	"github.com/girino/renoter/internal/sealtag"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip44"
+)
+
+func TestRenoter_SendErrorNotice(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pool := &fakePool{published: make(chan nostr.Event, 10)}
+	renoter, err := NewRenoterWithPool(ctx, nostr.GeneratePrivateKey(), []string{"wss://relay.example.com"}, pool)
+	if err != nil {
+		t.Fatalf("NewRenoterWithPool() error = %v", err)
+	}
+
+	replySk := nostr.GeneratePrivateKey()
+	replyPubkey, _ := nostr.GetPublicKey(replySk)
+	// A container whose layer asks for error notices to replyPubkey
+	send := func() {
+		final := &nostr.Event{Kind: 1, Content: "hello", CreatedAt: nostr.Now(), Tags: nostr.Tags{}}
+		final.Sign(nostr.GeneratePrivateKey())
+		finalJSON, _ := json.Marshal(final)
+		content, sk, _, _ := encryptFor(t, string(finalJSON), renoter.PublicKey)
+		layerKey, _ := nip44.GenerateConversationKey(renoter.PublicKey, sk)
+		sealed, err := sealtag.Seal([]string{replyPubkey}, layerKey)
+		if err != nil {
+			t.Fatalf("Seal() error = %v", err)
+		}
+		layer := &nostr.Event{Kind: config.WrapperEventKind, Content: content, CreatedAt: nostr.Now(), Tags: nostr.Tags{{"p", renoter.PublicKey}, {config.ReplyTag, sealed}}}
+		layer.Sign(sk)
+		renoter.Handle(ctx, sealContainer(t, renoter.PublicKey, layer, nostr.Now(), nostr.Tags{{"p", renoter.PublicKey}}))
+	}
+	// The error notice published to replyPubkey, or nil
+	notice := func() *config.ErrorNotice {
+		for len(pool.published) > 0 {
+			event := <-pool.published
+			if event.Kind != config.ErrorNoticeKind || event.Tags.Find("p")[1] != replyPubkey {
+				continue
+			}
+			conversationKey, _ := nip44.GenerateConversationKey(event.PubKey, replySk)
+			plaintext, err := nip44.Decrypt(event.Content, conversationKey)
+			if err != nil {
+				t.Fatalf("failed to decrypt error notice: %v", err)
+			}
+			var notice config.ErrorNotice
+			if err := json.Unmarshal([]byte(plaintext), &notice); err != nil {
+				t.Fatalf("invalid error notice: %v", err)
+			}
+			return &notice
+		}
+		return nil
+	}
+
+	renoter.powDifficulty = 0
+	send()
+	if n := notice(); n != nil {
+		t.Errorf("error notice for a published event = %+v", n)
+	}
+	// Layers failing admission get a notice naming the Renoter, stage and reason
+	renoter.powDifficulty = 30
+	send()
+	if n := notice(); n == nil || n.Renoter != renoter.PublicKey || n.Stage != StageAdmission || n.Reason == "" {
+		t.Errorf("error notice for an unmined layer = %+v", n)
+	}
+	renoter.SetErrorNotices(false)
+	send()
+	if n := notice(); n != nil {
+		t.Errorf("error notice with error notices off = %+v", n)
+	}
+}
@@ -0,0 +1,210 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/girino/renoter/internal/config"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// OutcomeKind is how processing of a container ended, see Outcome.
+type OutcomeKind string
+
+// Outcomes of a container.
+const (
+	// The layer inside was re-wrapped and published for the next Renoter
+	OutcomeForwarded OutcomeKind = "forwarded"
+	// The final event inside was published as exit
+	OutcomePublishedFinal OutcomeKind = "published"
+	// The event inside is held for a mixing delay; failures after the delay are only logged
+	OutcomeHeld OutcomeKind = "held"
+	// Stopped silently by a stage returning ErrDrop or Drop
+	OutcomeDropped OutcomeKind = "dropped"
+	// Stopped by a stage returning an error
+	OutcomeRejected OutcomeKind = "rejected"
+)
+
+// Reasons the built-in stages drop containers for, see Drop.
+const (
+	// The container or the layer inside is addressed to another Renoter
+	DropMisaddressed = "misaddressed"
+	// The container is addressed to another Renoter and was republished for it
+	DropRepublished = "republished"
+	// The layer is a capability probe, answered or unreadable
+	DropHandshake = "handshake"
+	// The layer carries the idempotency key of an event already published
+	DropResent = "resent"
+	// The final event was already published
+	DropDuplicate = "duplicate"
+)
+
+// dropError is ErrDrop with a reason.
+type dropError struct {
+	reason string
+}
+
+func (e *dropError) Error() string {
+	return ErrDrop.Error() + ": " + e.reason
+}
+
+func (e *dropError) Is(target error) bool {
+	return target == ErrDrop
+}
+
+// Drop returns an error that stops processing a container silently like ErrDrop, with the
+// reason reported in its Outcome.
+func Drop(reason string) error {
+	return &dropError{reason: reason}
+}
+
+// Outcome is how processing of one container ended, for callers counting or acknowledging
+// containers without parsing errors.
+type Outcome struct {
+	Kind OutcomeKind
+	// Stage that dropped or rejected the container
+	Stage string
+	// Why it was dropped (the reason given to Drop, empty for ErrDrop) or rejected: the reason
+	// of a config.Rejection, "quota", "malformed", "timeout", "canceled" or "error"
+	Reason string
+	// Error that rejected the container, nil for every other outcome
+	Err error
+}
+
+// outcome classifies how processing of msg ended with err.
+func outcome(msg *Message, err error) Outcome {
+	if err == nil && msg.Delay > 0 {
+		return Outcome{Kind: OutcomeHeld}
+	}
+	return forwardOutcome(msg, err)
+}
+
+// forwardOutcome classifies how processing of msg ended with err once its event was forwarded,
+// right away or after a mixing delay.
+func forwardOutcome(msg *Message, err error) Outcome {
+	var drop *dropError
+	switch {
+	case errors.As(err, &drop):
+		return Outcome{Kind: OutcomeDropped, Stage: msg.stoppedAt, Reason: drop.reason}
+	case errors.Is(err, ErrDrop):
+		return Outcome{Kind: OutcomeDropped, Stage: msg.stoppedAt}
+	case err != nil:
+		return Outcome{Kind: OutcomeRejected, Stage: msg.stoppedAt, Reason: errorCategory(err), Err: err}
+	case msg.Inner != nil && msg.Inner.Kind == config.WrapperEventKind:
+		return Outcome{Kind: OutcomeForwarded}
+	case msg.Inner != nil:
+		return Outcome{Kind: OutcomePublishedFinal}
+	}
+	// A pipeline without StageForward, e.g. rearranged by an embedder
+	return Outcome{Kind: OutcomeDropped, Stage: msg.stoppedAt}
+}
+
+// errorCategory returns a category of err that reveals nothing about the container.
+func errorCategory(err error) string {
+	var rejection *config.Rejection
+	var quota *QuotaError
+	switch {
+	case errors.As(err, &rejection):
+		return rejection.Reason
+	case errors.As(err, &quota):
+		return "quota"
+	case errors.Is(err, ErrMalformedWrapper):
+		return "malformed"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	}
+	return "error"
+}
+
+// HandleOutcome runs an incoming 29001 container through the whole pipeline, like Handle, and
+// returns how it ended.
+func (r *Renoter) HandleOutcome(ctx context.Context, event *nostr.Event) Outcome {
+	msg := &Message{Container: event, ReceivedAt: time.Now()}
+	r.startTrace(msg)
+	return r.finish(ctx, msg, r.pipeline.Run(ctx, msg))
+}
+
+// HandleEventOutcome runs a container already accepted by ProcessEvent through the pipeline
+// stages from StageRecipient on, like HandleEvent, and returns how it ended.
+func (r *Renoter) HandleEventOutcome(ctx context.Context, event *nostr.Event) Outcome {
+	// The container was reserved in the replay cache by ProcessEvent
+	msg := &Message{Container: event, ReceivedAt: time.Now(), reserved: true}
+	r.startTrace(msg)
+	return r.finish(ctx, msg, r.pipeline.run(ctx, msg, StageRecipient, ""))
+}
+
+// finish ends processing of msg with err: it settles the replay mark, counts and traces the
+// outcome, tells the sender if asked, and returns it.
+func (r *Renoter) finish(ctx context.Context, msg *Message, err error) Outcome {
+	r.settleReplay(msg, err)
+	result := outcome(msg, err)
+	r.outcomes.add(result.Kind)
+	r.finishTrace(msg, result)
+	r.notifySender(ctx, msg, result)
+	return result
+}
+
+// outcomeCounters counts the outcomes of the containers a Renoter processed.
+type outcomeCounters struct {
+	forwarded, published, held, dropped, rejected atomic.Int64
+}
+
+// add counts one outcome.
+func (c *outcomeCounters) add(kind OutcomeKind) {
+	switch kind {
+	case OutcomeForwarded:
+		c.forwarded.Add(1)
+	case OutcomePublishedFinal:
+		c.published.Add(1)
+	case OutcomeHeld:
+		c.held.Add(1)
+	case OutcomeDropped:
+		c.dropped.Add(1)
+	case OutcomeRejected:
+		c.rejected.Add(1)
+	}
+}
+
+// OutcomeStats counts the containers a Renoter processed, by outcome.
+type OutcomeStats struct {
+	Forwarded      int64
+	PublishedFinal int64
+	Held           int64
+	Dropped        int64
+	Rejected       int64
+}
+
+// OutcomeStats returns the outcomes of the containers processed since the Renoter was created.
+func (r *Renoter) OutcomeStats() OutcomeStats {
+	return OutcomeStats{
+		Forwarded:      r.outcomes.forwarded.Load(),
+		PublishedFinal: r.outcomes.published.Load(),
+		Held:           r.outcomes.held.Load(),
+		Dropped:        r.outcomes.dropped.Load(),
+		Rejected:       r.outcomes.rejected.Load(),
+	}
+}
+
+// WriteMetrics writes the stats in the Prometheus text format, as a counter labelled by outcome.
+func (s OutcomeStats) WriteMetrics(w io.Writer) {
+	fmt.Fprintf(w, "# HELP renoter_containers_total 29001 containers processed, by outcome.\n")
+	fmt.Fprintf(w, "# TYPE renoter_containers_total counter\n")
+	for _, count := range []struct {
+		kind  OutcomeKind
+		value int64
+	}{
+		{OutcomeForwarded, s.Forwarded},
+		{OutcomePublishedFinal, s.PublishedFinal},
+		{OutcomeHeld, s.Held},
+		{OutcomeDropped, s.Dropped},
+		{OutcomeRejected, s.Rejected},
+	} {
+		fmt.Fprintf(w, "renoter_containers_total{outcome=%q} %d\n", count.kind, count.value)
+	}
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/girino/renoter/internal/config"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestRenoter_HandleOutcome(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pool := &fakePool{published: make(chan nostr.Event, 10)}
+	renoter, err := NewRenoterWithPool(ctx, nostr.GeneratePrivateKey(), []string{"wss://relay.example.com"}, pool)
+	if err != nil {
+		t.Fatalf("NewRenoterWithPool() error = %v", err)
+	}
+	renoter.powDifficulty = 0
+
+	// Two containers carrying the same final event, as a sender retrying would send
+	final := &nostr.Event{Kind: 1, Content: "hello", CreatedAt: nostr.Now(), Tags: nostr.Tags{}}
+	final.Sign(nostr.GeneratePrivateKey())
+	finalJSON, _ := json.Marshal(final)
+	containerFor := func() *nostr.Event {
+		content, sk, _, _ := encryptFor(t, string(finalJSON), renoter.PublicKey)
+		layer := &nostr.Event{Kind: config.WrapperEventKind, Content: content, CreatedAt: nostr.Now(), Tags: nostr.Tags{{"p", renoter.PublicKey}}}
+		layer.Sign(sk)
+		return sealContainer(t, renoter.PublicKey, layer, nostr.Now(), nostr.Tags{{"p", renoter.PublicKey}})
+	}
+	other, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	misaddressed := containerFor()
+	misaddressed.Tags = nostr.Tags{{"p", other}}
+	misaddressed.Sign(nostr.GeneratePrivateKey())
+	forged := *containerFor()
+	forged.Content = "forged"
+
+	tests := []struct {
+		name      string
+		container *nostr.Event
+		want      Outcome
+	}{
+		{"final event", containerFor(), Outcome{Kind: OutcomePublishedFinal}},
+		{"same final event again", containerFor(), Outcome{Kind: OutcomeDropped, Stage: StageForward, Reason: DropDuplicate}},
+		{"container for another Renoter", misaddressed, Outcome{Kind: OutcomeDropped, Stage: StageRecipient, Reason: DropMisaddressed}},
+		{"forged container", &forged, Outcome{Kind: OutcomeRejected, Stage: StageSignature, Reason: "error"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := renoter.HandleOutcome(ctx, tt.container)
+			if got.Kind != tt.want.Kind || got.Stage != tt.want.Stage || got.Reason != tt.want.Reason {
+				t.Errorf("HandleOutcome() = %+v, want %+v", got, tt.want)
+			}
+			if (got.Err != nil) != (tt.want.Kind == OutcomeRejected) {
+				t.Errorf("HandleOutcome() error = %v for outcome %s", got.Err, got.Kind)
+			}
+		})
+	}
+	if len(pool.published) != 1 {
+		t.Errorf("published %d events, want the final event once", len(pool.published))
+	}
+
+	stats := renoter.OutcomeStats()
+	if stats != (OutcomeStats{PublishedFinal: 1, Dropped: 2, Rejected: 1}) {
+		t.Errorf("OutcomeStats() = %+v", stats)
+	}
+	var metrics strings.Builder
+	stats.WriteMetrics(&metrics)
+	if !strings.Contains(metrics.String(), `renoter_containers_total{outcome="dropped"} 2`) {
+		t.Errorf("WriteMetrics() = %s", metrics.String())
+	}
+}
+
+func TestOutcome_Classifies(t *testing.T) {
+	forward := &nostr.Event{Kind: config.WrapperEventKind}
+	final := &nostr.Event{Kind: 1}
+	tests := []struct {
+		name string
+		msg  *Message
+		err  error
+		want OutcomeKind
+	}{
+		{"forwarded", &Message{Inner: forward}, nil, OutcomeForwarded},
+		{"published", &Message{Inner: final}, nil, OutcomePublishedFinal},
+		{"held", &Message{Inner: final, Delay: time.Second}, nil, OutcomeHeld},
+		{"dropped with a reason", &Message{}, fmt.Errorf("stage: %w", Drop(DropResent)), OutcomeDropped},
+		{"dropped", &Message{}, ErrDrop, OutcomeDropped},
+		{"rejected", &Message{Inner: final}, errors.New("failed"), OutcomeRejected},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := outcome(tt.msg, tt.err); got.Kind != tt.want {
+				t.Errorf("outcome() = %+v, want %s", got, tt.want)
+			}
+		})
+	}
+	if !errors.Is(Drop(DropHandshake), ErrDrop) {
+		t.Error("Drop() does not match ErrDrop")
+	}
+}
+
+func TestErrorCategory(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want string
+	}{
+		{config.NewRejection(config.RejectSizeExceeded, "100", "too big"), config.RejectSizeExceeded},
+		{fmt.Errorf("wrapped: %w", &QuotaError{Scope: "key", Resource: "events", RetryAfter: time.Minute}), "quota"},
+		{fmt.Errorf("publish: %w", context.DeadlineExceeded), "timeout"},
+		{context.Canceled, "canceled"},
+		{fmt.Errorf("invalid signature for event %s", strings.Repeat("f", 64)), "error"},
+	} {
+		if got := errorCategory(tc.err); got != tc.want {
+			t.Errorf("errorCategory(%v) = %q, want %q", tc.err, got, tc.want)
+		}
+	}
+}
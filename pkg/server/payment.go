@@ -0,0 +1,188 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/girino/renoter/internal/lightning"
+	"github.com/girino/renoter/internal/sealtag"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// PaymentTag is the 29000 tag carrying a payment proof for the Renoter the layer is addressed to:
+// ["payment", sealed(["<LUD-21 verify URL>", "<preimage hex>"])], sealed with the layer's
+// conversation key so the previous hop, which sees the layer's tags, cannot read it.
+const PaymentTag = "payment"
+
+// paymentVerifyTimeout bounds the verification request made for a single event.
+const paymentVerifyTimeout = 10 * time.Second
+
+// PaymentPolicy configures the optional paid mode: every forwarded event costs FeeMsats, paid to
+// LightningAddress, except for the first FreeQuota unpaid events of each hour.
+type PaymentPolicy struct {
+	// Fee per forwarded event in millisatoshis
+	FeeMsats int64
+	// Lightning address (LUD-16) the fee is paid to; its provider must support LUD-21 verify
+	LightningAddress string
+	// Unpaid events forwarded per hour before payment is required (shared by all senders)
+	FreeQuota int
+}
+
+// Validate checks that the policy is usable.
+func (p PaymentPolicy) Validate() error {
+	if p.FeeMsats <= 0 {
+		return fmt.Errorf("fee must be positive, got %d msats", p.FeeMsats)
+	}
+	if _, err := lightning.AddressURL(p.LightningAddress); err != nil {
+		return err
+	}
+	if p.FreeQuota < 0 {
+		return fmt.Errorf("free quota must not be negative, got %d", p.FreeQuota)
+	}
+	return nil
+}
+
+// PaymentVerifier confirms that the invoice behind verifyURL was settled with preimage for at least minMsats.
+type PaymentVerifier interface {
+	VerifyPayment(ctx context.Context, verifyURL, preimage string, minMsats int64) error
+}
+
+// LUD21Verifier verifies payments with the LUD-21 verify endpoint of the Renoter's own lightning
+// address provider. Verify URLs on any other host are refused, so senders cannot point the
+// Renoter at a server that vouches for unpaid invoices.
+type LUD21Verifier struct {
+	// Host of the lightning address provider (the part after "@")
+	Domain string
+	// HTTP client used for verification requests
+	Client *http.Client
+}
+
+// VerifyPayment implements PaymentVerifier.
+func (v *LUD21Verifier) VerifyPayment(ctx context.Context, verifyURL, preimage string, minMsats int64) error {
+	parsed, err := url.Parse(verifyURL)
+	if err != nil || parsed.Host != v.Domain {
+		return fmt.Errorf("verify URL %q is not on the Renoter's lightning provider %s", verifyURL, v.Domain)
+	}
+
+	result, err := lightning.Verify(ctx, v.Client, verifyURL)
+	if err != nil {
+		return err
+	}
+	if !result.Settled {
+		return fmt.Errorf("invoice is not settled")
+	}
+	if !strings.EqualFold(result.Preimage, preimage) {
+		return fmt.Errorf("preimage does not match the settled invoice")
+	}
+	amount, err := lightning.InvoiceAmountMsats(result.PR)
+	if err != nil {
+		return fmt.Errorf("invalid settled invoice: %w", err)
+	}
+	if amount < minMsats {
+		return fmt.Errorf("paid %d msats, fee is %d msats", amount, minMsats)
+	}
+	return nil
+}
+
+// paymentGate enforces a PaymentPolicy on incoming 29000 wrappers.
+type paymentGate struct {
+	policy   PaymentPolicy
+	verifier PaymentVerifier
+	// Proofs already used, keyed by preimage hash, so one payment forwards one event
+	spent *EventCache
+
+	mu          sync.Mutex
+	windowStart time.Time
+	freeUsed    int
+}
+
+// SetPaymentPolicy enables paid mode. A nil verifier verifies with the LUD-21 endpoint of the
+// policy's lightning address provider.
+func (r *Renoter) SetPaymentPolicy(policy PaymentPolicy, verifier PaymentVerifier) error {
+	if err := policy.Validate(); err != nil {
+		logging.Error("server.payment.SetPaymentPolicy: invalid payment policy: %v", err)
+		return fmt.Errorf("invalid payment policy: %w", err)
+	}
+	if verifier == nil {
+		verifier = &LUD21Verifier{Domain: lightning.AddressDomain(policy.LightningAddress), Client: &http.Client{}}
+	}
+
+	r.payment = &paymentGate{
+		policy:   policy,
+		verifier: verifier,
+		// Events older than an hour are rejected anyway, so a day of spent proofs is plenty
+		spent: NewEventCache(100000, 24*time.Hour),
+	}
+	logging.Info("server.payment.SetPaymentPolicy: Paid mode enabled: %d msats per event to %s, %d free events per hour", policy.FeeMsats, policy.LightningAddress, policy.FreeQuota)
+	return nil
+}
+
+// admitPayment checks the payment proof of a 29000 wrapper addressed to this Renoter, falling back
+// to the hourly free quota when there is none. It is a no-op when paid mode is disabled.
+func (r *Renoter) admitPayment(ctx context.Context, wrapper *nostr.Event, conversationKey [32]byte) error {
+	gate := r.payment
+	if gate == nil {
+		return nil
+	}
+
+	tag := wrapper.Tags.Find(PaymentTag)
+	if tag == nil {
+		if gate.takeFreeQuota(time.Now()) {
+			logging.DebugMethod("server.payment", "admitPayment", "Forwarding unpaid 29000 %s within the free quota", wrapper.ID)
+			return nil
+		}
+		return fmt.Errorf("payment-required: %d msats to %s per event", gate.policy.FeeMsats, gate.policy.LightningAddress)
+	}
+
+	proof, err := sealtag.Open(tag[1], conversationKey)
+	if err != nil || len(proof) < 2 {
+		return fmt.Errorf("invalid payment proof")
+	}
+	verifyURL, preimage := proof[0], proof[1]
+	preimageBytes, err := hex.DecodeString(preimage)
+	if err != nil || len(preimageBytes) != 32 {
+		return fmt.Errorf("invalid payment preimage")
+	}
+	paymentHash := sha256.Sum256(preimageBytes)
+	// Reserve the proof while it is verified, so a failed verification does not spend it
+	spentKey := hex.EncodeToString(paymentHash[:])
+	if gate.spent.Reserve(spentKey, time.Now()) {
+		logging.Warn("server.payment.admitPayment: payment proof in 29000 %s was already used", wrapper.ID)
+		return fmt.Errorf("payment already used")
+	}
+
+	verifyCtx, cancel := context.WithTimeout(ctx, paymentVerifyTimeout)
+	defer cancel()
+	if err := gate.verifier.VerifyPayment(verifyCtx, verifyURL, preimage, gate.policy.FeeMsats); err != nil {
+		gate.spent.Release(spentKey)
+		logging.Warn("server.payment.admitPayment: payment for 29000 %s rejected: %v", wrapper.ID, err)
+		return fmt.Errorf("payment rejected: %w", err)
+	}
+	gate.spent.Confirm(spentKey, time.Now())
+
+	logging.DebugMethod("server.payment", "admitPayment", "Payment for 29000 %s verified", wrapper.ID)
+	return nil
+}
+
+// takeFreeQuota consumes one unpaid event from the current hour's quota, if any is left.
+func (g *paymentGate) takeFreeQuota(now time.Time) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if now.Sub(g.windowStart) >= time.Hour {
+		g.windowStart = now
+		g.freeUsed = 0
+	}
+	if g.freeUsed >= g.policy.FreeQuota {
+		return false
+	}
+	g.freeUsed++
+	return true
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/girino/renoter/internal/lightning"
+	"github.com/girino/renoter/internal/sealtag"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// fakeVerifier accepts every proof whose verify URL it was told about.
+type fakeVerifier struct {
+	settled map[string]bool
+}
+
+func (v *fakeVerifier) VerifyPayment(ctx context.Context, verifyURL, preimage string, minMsats int64) error {
+	if !v.settled[verifyURL] {
+		return fmt.Errorf("not settled")
+	}
+	return nil
+}
+
+func newPreimage(t *testing.T) string {
+	t.Helper()
+	preimage := make([]byte, 32)
+	rand.Read(preimage)
+	return hex.EncodeToString(preimage)
+}
+
+// testLayerKey stands in for the conversation key of a 29000 layer.
+var testLayerKey = [32]byte{1, 2, 3}
+
+// newPaidWrapper returns a 29000 carrying proof (verify URL and preimage) sealed to testLayerKey.
+func newPaidWrapper(t *testing.T, proof ...string) *nostr.Event {
+	t.Helper()
+	wrapper := &nostr.Event{Kind: 29000, CreatedAt: nostr.Now(), Tags: nostr.Tags{{"p", strings.Repeat("a", 64)}}}
+	if len(proof) > 0 {
+		sealed, err := sealtag.Seal(proof, testLayerKey)
+		if err != nil {
+			t.Fatalf("Seal() error = %v", err)
+		}
+		wrapper.Tags = append(wrapper.Tags, nostr.Tag{PaymentTag, sealed})
+	}
+	return wrapper
+}
+
+func TestPaymentPolicy_Validate(t *testing.T) {
+	valid := PaymentPolicy{FeeMsats: 1000, LightningAddress: "renoter@example.com", FreeQuota: 5}
+	if err := valid.Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+	for name, policy := range map[string]PaymentPolicy{
+		"no fee":         {LightningAddress: "renoter@example.com"},
+		"no address":     {FeeMsats: 1000},
+		"negative quota": {FeeMsats: 1000, LightningAddress: "renoter@example.com", FreeQuota: -1},
+	} {
+		if err := policy.Validate(); err == nil {
+			t.Errorf("Validate(%s) should fail", name)
+		}
+	}
+}
+
+func TestRenoter_AdmitPayment(t *testing.T) {
+	ctx := context.Background()
+	renoter := newOfflineRenoter(t)
+
+	// Free mode admits everything
+	if err := renoter.admitPayment(ctx, newPaidWrapper(t), testLayerKey); err != nil {
+		t.Fatalf("admitPayment() in free mode error = %v", err)
+	}
+
+	verifier := &fakeVerifier{settled: map[string]bool{"https://example.com/verify/paid": true}}
+	policy := PaymentPolicy{FeeMsats: 1000, LightningAddress: "renoter@example.com", FreeQuota: 1}
+	if err := renoter.SetPaymentPolicy(policy, verifier); err != nil {
+		t.Fatalf("SetPaymentPolicy() error = %v", err)
+	}
+	if d := renoter.Descriptor(""); d.FeePolicy != "lightning" || d.FeeMsats != 1000 || d.FreeQuota != 1 {
+		t.Errorf("Descriptor() = %+v, want lightning fee policy", d)
+	}
+
+	// The free quota covers exactly one unpaid event
+	if err := renoter.admitPayment(ctx, newPaidWrapper(t), testLayerKey); err != nil {
+		t.Errorf("first unpaid event should use the free quota: %v", err)
+	}
+	if err := renoter.admitPayment(ctx, newPaidWrapper(t), testLayerKey); err == nil || !strings.Contains(err.Error(), "payment-required") {
+		t.Errorf("admitPayment() error = %v, want payment-required once the quota is used", err)
+	}
+
+	// A settled payment is admitted once
+	preimage := newPreimage(t)
+	if err := renoter.admitPayment(ctx, newPaidWrapper(t, "https://example.com/verify/paid", preimage), testLayerKey); err != nil {
+		t.Errorf("admitPayment() with a settled payment error = %v", err)
+	}
+	if err := renoter.admitPayment(ctx, newPaidWrapper(t, "https://example.com/verify/paid", preimage), testLayerKey); err == nil {
+		t.Error("admitPayment() should reject a reused payment")
+	}
+
+	// A proof failing verification is not spent: it is admitted once the payment settles
+	preimage = newPreimage(t)
+	if err := renoter.admitPayment(ctx, newPaidWrapper(t, "https://example.com/verify/unpaid", preimage), testLayerKey); err == nil {
+		t.Error("admitPayment() should reject an unsettled payment")
+	}
+	verifier.settled["https://example.com/verify/unpaid"] = true
+	if err := renoter.admitPayment(ctx, newPaidWrapper(t, "https://example.com/verify/unpaid", preimage), testLayerKey); err != nil {
+		t.Errorf("admitPayment() once the payment settled error = %v", err)
+	}
+	if err := renoter.admitPayment(ctx, newPaidWrapper(t, "https://example.com/verify/paid", "zz"), testLayerKey); err == nil {
+		t.Error("admitPayment() should reject a malformed preimage")
+	}
+	if err := renoter.admitPayment(ctx, newPaidWrapper(t, "https://example.com/verify/paid", newPreimage(t)), [32]byte{9}); err == nil {
+		t.Error("admitPayment() should reject a proof sealed to another key")
+	}
+}
+
+func TestPaymentGate_FreeQuotaResetsHourly(t *testing.T) {
+	gate := &paymentGate{policy: PaymentPolicy{FreeQuota: 1}}
+	now := time.Now()
+	if !gate.takeFreeQuota(now) || gate.takeFreeQuota(now.Add(time.Minute)) {
+		t.Fatal("quota of 1 should admit exactly one event per hour")
+	}
+	if !gate.takeFreeQuota(now.Add(61 * time.Minute)) {
+		t.Error("quota should reset after an hour")
+	}
+}
+
+func TestLUD21Verifier(t *testing.T) {
+	preimage := newPreimage(t)
+	results := map[string]lightning.VerifyResult{
+		"/verify/ok":       {Status: "OK", Settled: true, Preimage: preimage, PR: "lnbc10n1test"},
+		"/verify/pending":  {Status: "OK", Settled: false, PR: "lnbc10n1test"},
+		"/verify/cheap":    {Status: "OK", Settled: true, Preimage: preimage, PR: "lnbc5n1test"},
+		"/verify/mismatch": {Status: "OK", Settled: true, Preimage: newPreimage(t), PR: "lnbc10n1test"},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(results[r.URL.Path])
+	}))
+	defer server.Close()
+
+	verifier := &LUD21Verifier{Domain: strings.TrimPrefix(server.URL, "http://"), Client: server.Client()}
+	ctx := context.Background()
+
+	if err := verifier.VerifyPayment(ctx, server.URL+"/verify/ok", preimage, 1000); err != nil {
+		t.Errorf("VerifyPayment() error = %v", err)
+	}
+	for path, want := range map[string]string{
+		"/verify/pending":  "not settled",
+		"/verify/cheap":    "paid 500 msats",
+		"/verify/mismatch": "preimage",
+	} {
+		if err := verifier.VerifyPayment(ctx, server.URL+path, preimage, 1000); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("VerifyPayment(%s) error = %v, want %q", path, err, want)
+		}
+	}
+	if err := verifier.VerifyPayment(ctx, "https://attacker.example/verify/ok", preimage, 1000); err == nil {
+		t.Error("VerifyPayment() should refuse verify URLs on other hosts")
+	}
+}
@@ -0,0 +1,273 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Names of the built-in pipeline stages, in the order every incoming 29001 passes through them.
+const (
+	// Verify the container's signature
+	StageSignature = "signature"
+	// Reject containers created more than an hour ago or dated in the future
+	StageAge = "age"
+	// Reject containers already processed, provisionally marking this one as seen
+	StageReplay = "replay"
+	// Charge the container against the submitting pubkey's quotas
+	StageQuota = "quota"
+	// Reject containers not following the wrapper schema, drop (or republish) those whose "p"
+	// tag is not our pubkey, before any decryption
+	StageRecipient = "recipient"
+	// Decrypt the container and parse the 29000 layer inside it
+	StageOpen = "open"
+	// Reject layers not following the wrapper schema, drop those addressed to another Renoter
+	StageAddressing = "addressing"
+	// Apply the age bounds to the layer as well
+	StageLayerAge = "layer-age"
+	// Answer capability probes instead of forwarding them
+	StageHandshake = "handshake"
+	// Admit the layer through an admission strategy (PoW by default)
+	StageAdmission = "admission"
+	// Drop resent copies of events already published as exit
+	StageIdempotency = "idempotency"
+	// Decrypt the layer and verify the event inside it
+	StageDecrypt = "decrypt"
+	// Infer whether we are entry or exit and apply the role's policy
+	StageRole = "role"
+	// Enforce the paid mode
+	StagePolicy = "policy"
+	// Draw the mixing delay the layer asks for, within the operator's bounds
+	StageDelay = "delay"
+	// Collect the value of a layer admitted by a DeferredAdmission, such as a Cashu token
+	StageRedeem = "redeem"
+	// Forward the next layer, or publish the final event
+	StageForward = "forward"
+)
+
+// ErrDrop is returned by a stage to stop processing a container silently, as opposed to
+// rejecting it with an error. Stages give the reason with Drop.
+var ErrDrop = errors.New("container dropped")
+
+// ErrMalformedWrapper is wrapped by the errors rejecting a container or layer that does not
+// follow the wrapper schema (see internal/schema), such as carrying several routing tags.
+var ErrMalformedWrapper = errors.New("malformed wrapper")
+
+// Message is one incoming 29001 container on its way through the pipeline. Stages fill in the
+// fields below Container as they unwrap it.
+type Message struct {
+	// The incoming 29001 container
+	Container *nostr.Event
+	// When processing of the container started
+	ReceivedAt time.Time
+	// The 29000 layer inside the container, set by StageOpen
+	Layer *nostr.Event
+	// NIP-44 conversation key of Layer, set by StageOpen
+	LayerKey [32]byte
+	// The strategy that admitted Layer, set by StageAdmission
+	Admission Admission
+	// Size of the padded Layer, the bucket the next container is padded to, set by StageOpen
+	Bucket int
+	// The event inside Layer (the next 29000 or the final event), set by StageDecrypt
+	Inner *nostr.Event
+	// Whether Inner is forwarded as entry or published as exit, set by StageRole
+	Role HopRole
+	// How long to hold Inner before forwarding it, set by StageDelay
+	Delay time.Duration
+
+	// Whether the container is provisionally marked as seen and must be settled when done
+	reserved bool
+	// Stage that dropped or rejected the container
+	stoppedAt string
+	// Timings of the stages run, when tracing is on (nil = off), see SetTraceBuffer
+	trace *Trace
+}
+
+// Stage is one named step of the pipeline. Returning an error stops processing of the container;
+// ErrDrop (or Drop) stops it without one.
+type Stage struct {
+	Name string
+	Run  func(ctx context.Context, msg *Message) error
+}
+
+// Pipeline is the ordered list of stages every incoming container passes through. Embedders
+// can add their own stages (e.g. a content policy before StageForward) or replace built-in ones.
+type Pipeline struct {
+	mu sync.RWMutex
+	// Stages in order. Never modified in place: changes store a new slice, so containers already
+	// running keep the stages they started with
+	stages []Stage
+}
+
+// NewPipeline creates a pipeline running stages in order.
+func NewPipeline(stages ...Stage) *Pipeline {
+	return &Pipeline{stages: slices.Clone(stages)}
+}
+
+// Stages returns the names of the stages in order.
+func (p *Pipeline) Stages() []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	names := make([]string, len(p.stages))
+	for i, stage := range p.stages {
+		names[i] = stage.Name
+	}
+	return names
+}
+
+// indexLocked returns the position of the named stage, or -1. Must be called with mu held.
+func (p *Pipeline) indexLocked(name string) int {
+	return slices.IndexFunc(p.stages, func(stage Stage) bool { return stage.Name == name })
+}
+
+// insert adds stage at the position of the named stage plus offset.
+func (p *Pipeline) insert(name string, offset int, stage Stage) error {
+	if stage.Name == "" || stage.Run == nil {
+		return fmt.Errorf("a stage needs a name and a function")
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.indexLocked(stage.Name) >= 0 {
+		return fmt.Errorf("stage %q already exists", stage.Name)
+	}
+	i := p.indexLocked(name)
+	if i < 0 {
+		return fmt.Errorf("unknown stage %q", name)
+	}
+	p.stages = slices.Insert(slices.Clone(p.stages), i+offset, stage)
+	return nil
+}
+
+// InsertBefore adds stage right before the named stage.
+func (p *Pipeline) InsertBefore(name string, stage Stage) error {
+	return p.insert(name, 0, stage)
+}
+
+// InsertAfter adds stage right after the named stage.
+func (p *Pipeline) InsertAfter(name string, stage Stage) error {
+	return p.insert(name, 1, stage)
+}
+
+// Replace swaps the function of the named stage for run.
+func (p *Pipeline) Replace(name string, run func(ctx context.Context, msg *Message) error) error {
+	if run == nil {
+		return fmt.Errorf("a stage needs a function")
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	i := p.indexLocked(name)
+	if i < 0 {
+		return fmt.Errorf("unknown stage %q", name)
+	}
+	stages := slices.Clone(p.stages)
+	stages[i].Run = run
+	p.stages = stages
+	return nil
+}
+
+// Run passes msg through every stage. It returns the error of the stage that stopped msg, which
+// for a dropped container wraps ErrDrop.
+func (p *Pipeline) Run(ctx context.Context, msg *Message) error {
+	return p.run(ctx, msg, "", "")
+}
+
+// run passes msg through the stages from the named one up to, but excluding, the stage named
+// until (empty = from the first, to the end).
+func (p *Pipeline) run(ctx context.Context, msg *Message, from, until string) error {
+	p.mu.RLock()
+	stages := p.stages
+	start, end := 0, len(stages)
+	if from != "" {
+		start = max(p.indexLocked(from), 0)
+	}
+	if until != "" {
+		if i := p.indexLocked(until); i >= 0 {
+			end = i
+		}
+	}
+	p.mu.RUnlock()
+
+	for _, stage := range stages[start:end] {
+		started := time.Now()
+		err := stage.Run(ctx, msg)
+		if msg.trace != nil {
+			msg.trace.Stages = append(msg.trace.Stages, StageTiming{Stage: stage.Name, Duration: time.Since(started)})
+		}
+		if err != nil {
+			msg.stoppedAt = stage.Name
+			if errors.Is(err, ErrDrop) {
+				logging.DebugMethod("server.pipeline", "run", "Container %s dropped at stage %s: %v", msg.Container.ID, stage.Name, err)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// defaultPipeline returns the built-in stages of r.
+func (r *Renoter) defaultPipeline() *Pipeline {
+	return NewPipeline(
+		Stage{StageSignature, r.checkSignature},
+		Stage{StageAge, r.checkAge},
+		Stage{StageReplay, r.checkReplay},
+		Stage{StageQuota, r.checkQuota},
+		Stage{StageRecipient, r.checkRecipient},
+		Stage{StageOpen, r.openContainer},
+		Stage{StageAddressing, r.checkAddressing},
+		Stage{StageLayerAge, r.checkLayerAge},
+		Stage{StageHandshake, r.answerHandshake},
+		Stage{StageAdmission, func(ctx context.Context, msg *Message) (err error) {
+			msg.Admission, err = r.admitLayer(ctx, msg.Layer, msg.LayerKey, r.liteContainer(msg.Bucket))
+			return err
+		}},
+		Stage{StageIdempotency, func(ctx context.Context, msg *Message) error {
+			if r.resentLayer(msg.Layer, msg.LayerKey) {
+				return Drop(DropResent)
+			}
+			return nil
+		}},
+		Stage{StageDecrypt, r.decryptLayer},
+		Stage{StageRole, r.checkRole},
+		Stage{StagePolicy, func(ctx context.Context, msg *Message) error {
+			return r.admitPayment(ctx, msg.Layer, msg.LayerKey)
+		}},
+		Stage{StageDelay, r.drawDelay},
+		Stage{StageRedeem, r.redeemLayer},
+		Stage{StageForward, r.forward},
+	)
+}
+
+// Pipeline returns the stages incoming containers pass through, for embedders to extend.
+// Changes apply to containers received afterwards.
+func (r *Renoter) Pipeline() *Pipeline {
+	return r.pipeline
+}
+
+// Handle runs an incoming 29001 container through the whole pipeline. Dropped containers
+// (e.g. addressed to another Renoter) are not an error; see HandleOutcome for how the container
+// ended.
+func (r *Renoter) Handle(ctx context.Context, event *nostr.Event) error {
+	return r.HandleOutcome(ctx, event).Err
+}
+
+// settleReplay settles the provisional replay mark of a container once processing ends: it is
+// confirmed if the container was handled or deliberately dropped, and released if processing
+// failed, so a copy delivered again can still get through.
+func (r *Renoter) settleReplay(msg *Message, err error) {
+	if !msg.reserved {
+		return
+	}
+	if err == nil || errors.Is(err, ErrDrop) {
+		r.eventCache.Confirm(msg.Container.ID, time.Now())
+	} else {
+		logging.DebugMethod("server.pipeline", "settleReplay", "Releasing event %s from the replay cache after a failure", msg.Container.ID)
+		r.eventCache.Release(msg.Container.ID)
+	}
+	msg.reserved = false
+}
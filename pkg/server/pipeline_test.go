@@ -0,0 +1,132 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestDefaultPipeline_Stages(t *testing.T) {
+	renoter := newOfflineRenoter(t)
+	want := []string{
+		StageSignature, StageAge, StageReplay, StageQuota, StageRecipient, StageOpen,
+		StageAddressing, StageLayerAge, StageHandshake, StageAdmission, StageIdempotency, StageDecrypt, StageRole, StagePolicy, StageDelay, StageRedeem, StageForward,
+	}
+	if got := renoter.Pipeline().Stages(); !slices.Equal(got, want) {
+		t.Errorf("Stages() = %v, want %v", got, want)
+	}
+}
+
+func TestPipeline_InsertAndReplace(t *testing.T) {
+	noop := func(ctx context.Context, msg *Message) error { return nil }
+	p := NewPipeline(Stage{"a", noop}, Stage{"c", noop})
+
+	if err := p.InsertBefore("c", Stage{"b", noop}); err != nil {
+		t.Fatalf("InsertBefore() error = %v", err)
+	}
+	if err := p.InsertAfter("c", Stage{"d", noop}); err != nil {
+		t.Fatalf("InsertAfter() error = %v", err)
+	}
+	if got := p.Stages(); !slices.Equal(got, []string{"a", "b", "c", "d"}) {
+		t.Errorf("Stages() = %v, want [a b c d]", got)
+	}
+
+	if err := p.InsertBefore("missing", Stage{"e", noop}); err == nil {
+		t.Error("InsertBefore() an unknown stage should error")
+	}
+	if err := p.InsertAfter("a", Stage{"b", noop}); err == nil {
+		t.Error("InsertAfter() a duplicate name should error")
+	}
+	if err := p.Replace("missing", noop); err == nil {
+		t.Error("Replace() an unknown stage should error")
+	}
+
+	rejected := errors.New("rejected by c")
+	if err := p.Replace("c", func(ctx context.Context, msg *Message) error { return rejected }); err != nil {
+		t.Fatalf("Replace() error = %v", err)
+	}
+	if err := p.Run(context.Background(), &Message{Container: &nostr.Event{}}); !errors.Is(err, rejected) {
+		t.Errorf("Run() error = %v, want the replaced stage's error", err)
+	}
+}
+
+func TestPipeline_ChangesWhileRunning(t *testing.T) {
+	entered, resume := make(chan struct{}), make(chan struct{})
+	var ran []string
+	stage := func(name string) func(ctx context.Context, msg *Message) error {
+		return func(ctx context.Context, msg *Message) error {
+			ran = append(ran, name)
+			return nil
+		}
+	}
+	blocking := func(ctx context.Context, msg *Message) error {
+		entered <- struct{}{}
+		<-resume
+		return nil
+	}
+	// Spare capacity lets an in-place insert shift the stages of a running container
+	p := &Pipeline{stages: append(make([]Stage, 0, 8), Stage{"block", blocking}, Stage{"b", stage("old b")})}
+
+	done := make(chan error)
+	go func() { done <- p.Run(context.Background(), &Message{Container: &nostr.Event{}}) }()
+	<-entered
+
+	// Run with -race: changes must not touch the stages of the running container
+	if err := p.Replace("b", stage("new b")); err != nil {
+		t.Fatalf("Replace() error = %v", err)
+	}
+	if err := p.InsertAfter("block", Stage{"inserted", stage("inserted")}); err != nil {
+		t.Fatalf("InsertAfter() error = %v", err)
+	}
+	close(resume)
+	if err := <-done; err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if !slices.Equal(ran, []string{"old b"}) {
+		t.Errorf("running container ran %v, want the stages it started with: [old b]", ran)
+	}
+
+	// Containers received afterwards run the changed stages
+	ran = nil
+	go func() { done <- p.Run(context.Background(), &Message{Container: &nostr.Event{}}) }()
+	<-entered
+	if err := <-done; err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if !slices.Equal(ran, []string{"inserted", "new b"}) {
+		t.Errorf("later container ran %v, want [inserted new b]", ran)
+	}
+}
+
+func TestRenoter_CustomStageDropsEvent(t *testing.T) {
+	renoter := newOfflineRenoter(t)
+	var inspected []string
+	err := renoter.Pipeline().InsertBefore(StageForward, Stage{
+		Name: "content-policy",
+		Run: func(ctx context.Context, msg *Message) error {
+			inspected = append(inspected, msg.Inner.Content)
+			return ErrDrop
+		},
+	})
+	if err != nil {
+		t.Fatalf("InsertBefore() error = %v", err)
+	}
+
+	event := &nostr.Event{Kind: 1, Content: "filtered", CreatedAt: nostr.Now(), Tags: nostr.Tags{}}
+	event.Sign(nostr.GeneratePrivateKey())
+	container := wrapForRenoters(t, event, []*Renoter{renoter})
+
+	// The forward stage would publish to relays; the custom stage stops the event first
+	if err := renoter.Handle(context.Background(), container); err != nil {
+		t.Fatalf("Handle() error = %v, want the dropped event to be no error", err)
+	}
+	if !slices.Equal(inspected, []string{"filtered"}) {
+		t.Errorf("custom stage saw %v, want the decrypted final event", inspected)
+	}
+	if renoter.published(event) {
+		t.Error("dropped event was marked published")
+	}
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/girino/renoter/internal/config"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// pluginMaxLine bounds a single input line of the plugin protocol.
+const pluginMaxLine = 1 << 20
+
+// Actions a write policy plugin answers with.
+const (
+	pluginAccept = "accept"
+	pluginReject = "reject"
+)
+
+// pluginRequest is one line strfry writes to a write policy plugin's stdin.
+type pluginRequest struct {
+	Type       string       `json:"type"`
+	Event      *nostr.Event `json:"event"`
+	ReceivedAt int64        `json:"receivedAt"`
+	SourceType string       `json:"sourceType"`
+	SourceInfo string       `json:"sourceInfo"`
+}
+
+// pluginResponse is the decision written back to stdout for every request.
+type pluginResponse struct {
+	ID     string `json:"id"`
+	Action string `json:"action"`
+	Msg    string `json:"msg"`
+}
+
+// ServePlugin runs r as a strfry write policy plugin: it reads events as newline-delimited JSON
+// from in and writes one accept or reject decision per event to out, so a relay operator can
+// colocate a Renoter with their relay without a websocket subscription looping back to it.
+// 29001 containers addressed to r are checked like ProcessEvent does, rejected if that fails,
+// and handled by background workers otherwise; every other event is accepted untouched.
+// Returns nil when in is closed.
+func (r *Renoter) ServePlugin(ctx context.Context, in io.Reader, out io.Writer) error {
+	filter := r.subscriptionFilter()
+	queue := r.startQueue(ctx, "ServePlugin", r.HandleEventOutcome)
+	encoder := json.NewEncoder(out)
+
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 0, 64*1024), pluginMaxLine)
+	logging.Info("server.plugin.ServePlugin: Reading events from the relay as a write policy plugin")
+	for scanner.Scan() {
+		var request pluginRequest
+		if err := json.Unmarshal(scanner.Bytes(), &request); err != nil || request.Event == nil {
+			// Without an event ID there is nothing to answer
+			logging.Warn("server.plugin.ServePlugin: skipping malformed input line: %v", err)
+			continue
+		}
+
+		response := pluginResponse{ID: request.Event.ID, Action: pluginAccept}
+		if request.Event.Kind == config.StandardizedWrapperKind && filter.Matches(request.Event) {
+			if err := r.admitPluginEvent(ctx, request.Event, queue); err != nil {
+				response.Action, response.Msg = pluginReject, err.Error()
+			}
+		}
+		if err := encoder.Encode(response); err != nil {
+			logging.Error("server.plugin.ServePlugin: failed to write decision for event %s: %v", request.Event.ID, err)
+			return fmt.Errorf("failed to write plugin output: %w", err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		logging.Error("server.plugin.ServePlugin: failed to read plugin input: %v", err)
+		return fmt.Errorf("failed to read plugin input: %w", err)
+	}
+	logging.Info("server.plugin.ServePlugin: Input closed, stopping")
+	return nil
+}
+
+// admitPluginEvent runs the acceptance checks on a container addressed to us and queues it for
+// handling, returning the rejection to report to the relay otherwise.
+func (r *Renoter) admitPluginEvent(ctx context.Context, event *nostr.Event, queue chan *nostr.Event) error {
+	if err := r.ProcessEvent(ctx, event); err != nil {
+		logging.Warn("server.plugin.admitPluginEvent: rejecting event %s: %v", event.ID, err)
+		var rejection *config.Rejection
+		if errors.As(err, &rejection) {
+			return rejection
+		}
+		return fmt.Errorf("%s: %w", config.PrefixInvalid, err)
+	}
+	select {
+	case queue <- event:
+		return nil
+	default:
+		// ProcessEvent reserved the container; let a later copy through
+		r.eventCache.Release(event.ID)
+		logging.Warn("server.plugin.admitPluginEvent: processing queue full (%d), rejecting event %s", cap(queue), event.ID)
+		return config.NewRejection(config.RejectQueueFull, strconv.Itoa(cap(queue)), "renoter processing queue is full")
+	}
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestServePlugin_Decisions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	renoter := newOfflineRenoter(t)
+	forwarded := make(chan *nostr.Event, 1)
+	if err := renoter.Pipeline().Replace(StageForward, func(ctx context.Context, msg *Message) error {
+		forwarded <- msg.Inner
+		return nil
+	}); err != nil {
+		t.Fatalf("Replace() error = %v", err)
+	}
+
+	note := &nostr.Event{Kind: 1, Content: "relayed as usual", CreatedAt: nostr.Now(), Tags: nostr.Tags{}}
+	note.Sign(nostr.GeneratePrivateKey())
+	final := &nostr.Event{Kind: 1, Content: "through the plugin", CreatedAt: nostr.Now(), Tags: nostr.Tags{}}
+	final.Sign(nostr.GeneratePrivateKey())
+	container := wrapForRenoters(t, final, []*Renoter{renoter})
+	forged := *wrapForRenoters(t, final, []*Renoter{renoter})
+	forged.Sig = strings.Repeat("0", 128)
+
+	var in bytes.Buffer
+	for _, event := range []*nostr.Event{note, container, container, &forged} {
+		line, _ := json.Marshal(pluginRequest{Type: "new", Event: event, SourceType: "IP4", SourceInfo: "127.0.0.1"})
+		in.Write(append(line, '\n'))
+	}
+	in.WriteString("not json\n")
+
+	var out bytes.Buffer
+	if err := renoter.ServePlugin(ctx, &in, &out); err != nil {
+		t.Fatalf("ServePlugin() error = %v", err)
+	}
+
+	var responses []pluginResponse
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var response pluginResponse
+		if err := json.Unmarshal([]byte(line), &response); err != nil {
+			t.Fatalf("output line %q is not JSON: %v", line, err)
+		}
+		responses = append(responses, response)
+	}
+	want := []struct{ id, action, msgPrefix string }{
+		{note.ID, pluginAccept, ""},
+		{container.ID, pluginAccept, ""},
+		{container.ID, pluginReject, "invalid: "},
+		{forged.ID, pluginReject, "invalid: "},
+	}
+	if len(responses) != len(want) {
+		t.Fatalf("got %d decisions, want %d: %v", len(responses), len(want), responses)
+	}
+	for i, w := range want {
+		got := responses[i]
+		if got.ID != w.id || got.Action != w.action || !strings.HasPrefix(got.Msg, w.msgPrefix) {
+			t.Errorf("decision %d = %+v, want id %s action %s msg prefix %q", i, got, w.id, w.action, w.msgPrefix)
+		}
+	}
+
+	select {
+	case inner := <-forwarded:
+		if inner.ID != final.ID {
+			t.Errorf("forwarded event %s, want %s", inner.ID, final.ID)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("accepted container was not handled")
+	}
+}
@@ -0,0 +1,153 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/girino/renoter/internal/stamp"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip13"
+)
+
+// metricsMaxBits is the largest finite bucket of the PoW histogram metric; layers with more
+// leading zero bits only count towards +Inf.
+const metricsMaxBits = 40
+
+// powHistogram counts the leading zero bits of the IDs of admitted 29000 layers (of their
+// skeletons for stamped layers).
+type powHistogram struct {
+	mu sync.Mutex
+	// counts[i] is the number of layers whose ID has exactly i leading zero bits
+	counts [257]int64
+}
+
+// record counts the ID of an admitted layer. Malformed IDs, which only get through when no PoW
+// is required, are ignored.
+func (h *powHistogram) record(layer *nostr.Event) {
+	work := stamp.Work(layer)
+	if !nostr.IsValid32ByteHex(work) {
+		return
+	}
+	bits := nip13.Difficulty(work)
+	h.mu.Lock()
+	h.counts[bits]++
+	h.mu.Unlock()
+}
+
+// PoWStats is the distribution of the PoW actually done on the 29000 layers admitted by PoW,
+// against the difficulty required, for tuning the difficulty on real traffic.
+type PoWStats struct {
+	// Difficulty required by PoW admission (0 = layers are not admitted by PoW)
+	Required int `json:"required"`
+	// Layers admitted by PoW
+	Admitted int64 `json:"admitted"`
+	// Bits[i] is the number of admitted layers whose ID has exactly i leading zero bits, up to
+	// the largest seen
+	Bits []int64 `json:"bits"`
+}
+
+// PoWStats returns the leading zero bits of the layers admitted by PoW since the Renoter was
+// created.
+func (r *Renoter) PoWStats() PoWStats {
+	stats := PoWStats{}
+	for _, admission := range r.admissionStrategies() {
+		if pow, ok := admission.(*PoWAdmission); ok {
+			stats.Required = pow.Difficulty
+		}
+	}
+	r.powBits.mu.Lock()
+	defer r.powBits.mu.Unlock()
+	last := -1
+	for bits, count := range r.powBits.counts {
+		stats.Admitted += count
+		if count > 0 {
+			last = bits
+		}
+	}
+	stats.Bits = append([]int64{}, r.powBits.counts[:last+1]...)
+	return stats
+}
+
+// Mean returns the average leading zero bits of the admitted layers.
+func (s PoWStats) Mean() float64 {
+	if s.Admitted == 0 {
+		return 0
+	}
+	var sum int64
+	for bits, count := range s.Bits {
+		sum += int64(bits) * count
+	}
+	return float64(sum) / float64(s.Admitted)
+}
+
+// Quantile returns the leading zero bits reached by at least the fraction q of the admitted
+// layers' IDs, counting from the least, e.g. Quantile(0.5) is the median.
+func (s PoWStats) Quantile(q float64) int {
+	if s.Admitted == 0 {
+		return 0
+	}
+	var seen int64
+	for bits, count := range s.Bits {
+		seen += count
+		if count > 0 && float64(seen) >= q*float64(s.Admitted) {
+			return bits
+		}
+	}
+	return len(s.Bits) - 1
+}
+
+// Above returns the layers whose ID has more leading zero bits than required.
+func (s PoWStats) Above() int64 {
+	var above int64
+	for bits := s.Required + 1; bits < len(s.Bits); bits++ {
+		above += s.Bits[bits]
+	}
+	return above
+}
+
+// WriteMetrics writes the stats in the Prometheus text format: a histogram of the leading zero
+// bits of admitted layers and a gauge with the required difficulty.
+func (s PoWStats) WriteMetrics(w io.Writer) {
+	fmt.Fprintf(w, "# HELP renoter_layer_pow_bits Leading zero bits of the IDs of 29000 layers admitted by PoW.\n")
+	fmt.Fprintf(w, "# TYPE renoter_layer_pow_bits histogram\n")
+	var cumulative, sum int64
+	for bits := 0; bits <= metricsMaxBits; bits++ {
+		if bits < len(s.Bits) {
+			cumulative += s.Bits[bits]
+		}
+		fmt.Fprintf(w, "renoter_layer_pow_bits_bucket{le=\"%d\"} %d\n", bits, cumulative)
+	}
+	for bits, count := range s.Bits {
+		sum += int64(bits) * count
+	}
+	fmt.Fprintf(w, "renoter_layer_pow_bits_bucket{le=\"+Inf\"} %d\n", s.Admitted)
+	fmt.Fprintf(w, "renoter_layer_pow_bits_sum %d\n", sum)
+	fmt.Fprintf(w, "renoter_layer_pow_bits_count %d\n", s.Admitted)
+	fmt.Fprintf(w, "# HELP renoter_layer_pow_required_bits PoW difficulty required to admit a 29000 layer.\n")
+	fmt.Fprintf(w, "# TYPE renoter_layer_pow_required_bits gauge\n")
+	fmt.Fprintf(w, "renoter_layer_pow_required_bits %d\n", s.Required)
+}
+
+// PoWMetrics serves the PoW stats, container outcomes, final kinds and replay cache of a Renoter
+// in the Prometheus text format, or the PoW stats alone as JSON when asked for application/json.
+type PoWMetrics struct {
+	Renoter *Renoter
+}
+
+// ServeHTTP serves the current stats.
+func (m PoWMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	stats := m.Renoter.PoWStats()
+	if r.Header.Get("Accept") == "application/json" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(stats)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	stats.WriteMetrics(w)
+	m.Renoter.OutcomeStats().WriteMetrics(w)
+	m.Renoter.KindStats().WriteMetrics(w)
+	m.Renoter.ReplayCacheStats().WriteMetrics(w)
+}
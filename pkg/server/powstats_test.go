@@ -0,0 +1,95 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip13"
+)
+
+// newMinedWrapper returns a 29000 whose ID has at least difficulty leading zero bits.
+func newMinedWrapper(t *testing.T, difficulty int) *nostr.Event {
+	t.Helper()
+	event := nostr.Event{Kind: 29000, PubKey: strings.Repeat("b", 64), CreatedAt: nostr.Now(), Tags: nostr.Tags{{"p", strings.Repeat("a", 64)}}}
+	tag, err := nip13.DoWork(context.Background(), event, difficulty)
+	if err != nil {
+		t.Fatalf("DoWork() error = %v", err)
+	}
+	event.Tags = append(event.Tags, tag)
+	event.ID = event.GetID()
+	return &event
+}
+
+func TestRenoter_PoWStats(t *testing.T) {
+	ctx := context.Background()
+	renoter := newOfflineRenoter(t)
+	renoter.powDifficulty = 4
+
+	var want []int
+	for _, difficulty := range []int{4, 4, 6} {
+		layer := newMinedWrapper(t, difficulty)
+		if _, err := renoter.admitLayer(ctx, layer, testLayerKey, false); err != nil {
+			t.Fatalf("admitLayer() error = %v", err)
+		}
+		want = append(want, nip13.Difficulty(layer.ID))
+	}
+	// Rejected layers are not counted
+	renoter.admitLayer(ctx, newCashuWrapper(t, "https://mint.example.com", 4, "s1"), testLayerKey, false)
+
+	stats := renoter.PoWStats()
+	if stats.Required != 4 || stats.Admitted != 3 {
+		t.Fatalf("PoWStats() = %+v, want 3 layers admitted at difficulty 4", stats)
+	}
+	var mean float64
+	for _, bits := range want {
+		if stats.Bits[bits] == 0 {
+			t.Errorf("PoWStats().Bits = %v, missing a layer with %d bits", stats.Bits, bits)
+		}
+		mean += float64(bits) / 3
+	}
+	if got := stats.Mean(); got < mean-0.001 || got > mean+0.001 {
+		t.Errorf("Mean() = %f, want %f", got, mean)
+	}
+	if got := stats.Quantile(0); got < 4 {
+		t.Errorf("Quantile(0) = %d, want at least the required 4 bits", got)
+	}
+	if got := stats.Quantile(1); got != len(stats.Bits)-1 || got < 6 {
+		t.Errorf("Quantile(1) = %d, want the most bits seen", got)
+	}
+	if stats.Above() == 0 {
+		t.Error("Above() = 0, want the layer mined to 6 bits")
+	}
+}
+
+func TestPoWStats_WriteMetrics(t *testing.T) {
+	stats := PoWStats{Required: 2, Admitted: 3, Bits: []int64{0, 0, 2, 1}}
+	var out strings.Builder
+	stats.WriteMetrics(&out)
+	for _, line := range []string{
+		"# TYPE renoter_layer_pow_bits histogram",
+		`renoter_layer_pow_bits_bucket{le="1"} 0`,
+		`renoter_layer_pow_bits_bucket{le="2"} 2`,
+		`renoter_layer_pow_bits_bucket{le="40"} 3`,
+		`renoter_layer_pow_bits_bucket{le="+Inf"} 3`,
+		"renoter_layer_pow_bits_sum 7",
+		"renoter_layer_pow_bits_count 3",
+		"renoter_layer_pow_required_bits 2",
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Errorf("WriteMetrics() lacks %q:\n%s", line, out.String())
+		}
+	}
+
+	renoter := newOfflineRenoter(t)
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	request.Header.Set("Accept", "application/json")
+	PoWMetrics{Renoter: renoter}.ServeHTTP(recorder, request)
+	if body := recorder.Body.String(); !strings.Contains(body, `"admitted":0`) {
+		t.Errorf("ServeHTTP() as JSON = %s", body)
+	}
+}
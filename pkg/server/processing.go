@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// ProcessingPolicy bounds the containers a Renoter works on at once, so a burst that outpaces
+// publishing slows down reading from the relays instead of piling up containers that time out.
+type ProcessingPolicy struct {
+	// Containers handled at once
+	Workers int
+	// Containers waiting for a worker. The subscription stops reading from the relays while the
+	// queue is full; an attached relay or plugin drops or rejects new containers instead
+	QueueSize int
+	// Layers held for a mixing delay at once; handling waits for one to be forwarded before
+	// holding another (0 = unlimited)
+	MaxHeld int
+}
+
+// DefaultProcessingPolicy returns the policy of a new Renoter.
+func DefaultProcessingPolicy() ProcessingPolicy {
+	return ProcessingPolicy{Workers: 4, QueueSize: 1000, MaxHeld: 1000}
+}
+
+// Validate checks that the policy is usable.
+func (p ProcessingPolicy) Validate() error {
+	if p.Workers < 1 {
+		return fmt.Errorf("at least one worker is needed, got %d", p.Workers)
+	}
+	if p.QueueSize < 1 {
+		return fmt.Errorf("queue size must be positive, got %d", p.QueueSize)
+	}
+	if p.MaxHeld < 0 {
+		return fmt.Errorf("max held layers must not be negative, got %d", p.MaxHeld)
+	}
+	return nil
+}
+
+// SetProcessingPolicy sets how many containers are handled, queued and held at once. It must be
+// called before subscribing or attaching to a relay.
+func (r *Renoter) SetProcessingPolicy(policy ProcessingPolicy) error {
+	if err := policy.Validate(); err != nil {
+		logging.Error("server.processing.SetProcessingPolicy: invalid policy: %v", err)
+		return fmt.Errorf("invalid processing policy: %w", err)
+	}
+	r.processing = policy
+	r.held = nil
+	if policy.MaxHeld > 0 {
+		r.held = make(chan struct{}, policy.MaxHeld)
+	}
+	logging.Info("server.processing.SetProcessingPolicy: Handling %d containers at once, queueing %d and holding %d", policy.Workers, policy.QueueSize, policy.MaxHeld)
+	return nil
+}
+
+// processingPolicy returns the policy containers are handled with, the default if none was set.
+func (r *Renoter) processingPolicy() ProcessingPolicy {
+	if r.processing.Workers == 0 {
+		return DefaultProcessingPolicy()
+	}
+	return r.processing
+}
+
+// startQueue starts the workers passing queued containers to handle until ctx is done, and
+// returns the queue. caller names the entry point in logs.
+func (r *Renoter) startQueue(ctx context.Context, caller string, handle func(context.Context, *nostr.Event) Outcome) chan *nostr.Event {
+	r.runCacheCleanup(ctx)
+	policy := r.processingPolicy()
+	queue := make(chan *nostr.Event, policy.QueueSize)
+	for range policy.Workers {
+		r.life.run(ctx, func(ctx context.Context) {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case event := <-queue:
+					if outcome := handle(ctx, event); outcome.Kind == OutcomeRejected {
+						logging.Warn("server.processing.%s: Error handling event %s: %v", caller, event.ID, outcome.Err)
+					}
+				}
+			}
+		})
+	}
+	return queue
+}
+
+// enqueue waits for room in queue for event, which pauses reading from the relays while every
+// worker is busy. It returns false if ctx is done first.
+func (r *Renoter) enqueue(ctx context.Context, queue chan *nostr.Event, event *nostr.Event) bool {
+	select {
+	case queue <- event:
+		return true
+	default:
+	}
+	logging.Warn("server.processing.enqueue: processing queue full (%d), pausing reading until a container is handled", cap(queue))
+	select {
+	case queue <- event:
+		logging.DebugMethod("server.processing", "enqueue", "Processing queue has room again, resuming reading")
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// reserveHold waits for room to hold another layer for a mixing delay and returns the function
+// releasing it, or false if ctx is done first.
+func (r *Renoter) reserveHold(ctx context.Context, msg *Message) (func(), bool) {
+	held := r.held
+	if held == nil {
+		return func() {}, true
+	}
+	select {
+	case held <- struct{}{}:
+		return func() { <-held }, true
+	default:
+	}
+	logging.Warn("server.processing.reserveHold: %d layers held for mixing, waiting to hold 29000 %s", cap(held), msg.Layer.ID)
+	select {
+	case held <- struct{}{}:
+		return func() { <-held }, true
+	case <-ctx.Done():
+		return nil, false
+	}
+}
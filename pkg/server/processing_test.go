@@ -0,0 +1,96 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestProcessingPolicy_Validate(t *testing.T) {
+	if err := DefaultProcessingPolicy().Validate(); err != nil {
+		t.Errorf("DefaultProcessingPolicy().Validate() error = %v", err)
+	}
+	for _, policy := range []ProcessingPolicy{
+		{Workers: 0, QueueSize: 1},
+		{Workers: 1, QueueSize: 0},
+		{Workers: 1, QueueSize: 1, MaxHeld: -1},
+	} {
+		if err := policy.Validate(); err == nil {
+			t.Errorf("Validate(%+v) should fail", policy)
+		}
+	}
+}
+
+func TestRenoter_Enqueue_PausesWhenSaturated(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	renoter := newOfflineRenoter(t)
+	if err := renoter.SetProcessingPolicy(ProcessingPolicy{Workers: 1, QueueSize: 1}); err != nil {
+		t.Fatalf("SetProcessingPolicy() error = %v", err)
+	}
+
+	// A stalled publish keeps the only worker busy
+	stalled := make(chan struct{})
+	handled := make(chan string, 3)
+	queue := renoter.startQueue(ctx, "test", func(ctx context.Context, event *nostr.Event) Outcome {
+		<-stalled
+		handled <- event.ID
+		return Outcome{Kind: OutcomeForwarded}
+	})
+	renoter.enqueue(ctx, queue, &nostr.Event{ID: "1"})
+	deadline := time.Now().Add(5 * time.Second)
+	for len(queue) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	renoter.enqueue(ctx, queue, &nostr.Event{ID: "2"})
+
+	// With the queue full, the next container is not read until the worker catches up
+	enqueued := make(chan bool)
+	go func() { enqueued <- renoter.enqueue(ctx, queue, &nostr.Event{ID: "3"}) }()
+	select {
+	case <-enqueued:
+		t.Fatal("enqueue() did not wait for room in a full queue")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(stalled)
+	if ok := <-enqueued; !ok {
+		t.Error("enqueue() = false once the worker caught up")
+	}
+	for _, want := range []string{"1", "2", "3"} {
+		if got := <-handled; got != want {
+			t.Errorf("handled %s, want %s", got, want)
+		}
+	}
+
+	// Shutting down stops waiting
+	full := make(chan *nostr.Event, 1)
+	full <- &nostr.Event{ID: "4"}
+	cancel()
+	if renoter.enqueue(ctx, full, &nostr.Event{ID: "5"}) {
+		t.Error("enqueue() into a full queue after ctx is done = true")
+	}
+}
+
+func TestRenoter_ReserveHold(t *testing.T) {
+	renoter := newOfflineRenoter(t)
+	if err := renoter.SetProcessingPolicy(ProcessingPolicy{Workers: 1, QueueSize: 1, MaxHeld: 1}); err != nil {
+		t.Fatalf("SetProcessingPolicy() error = %v", err)
+	}
+	msg := &Message{Layer: &nostr.Event{ID: "layer"}}
+
+	release, ok := renoter.reserveHold(context.Background(), msg)
+	if !ok {
+		t.Fatal("reserveHold() with room = false")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, ok := renoter.reserveHold(ctx, msg); ok {
+		t.Error("reserveHold() beyond MaxHeld = true")
+	}
+	release()
+	if _, ok := renoter.reserveHold(context.Background(), msg); !ok {
+		t.Error("reserveHold() after a release = false")
+	}
+}
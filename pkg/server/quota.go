@@ -0,0 +1,171 @@
+package server
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/girino/nostr-lib/logging"
+)
+
+// QuotaRejectionPrefix starts every quota rejection, following NIP-01's machine-readable prefixes,
+// so senders can tell a rate limit from a malformed event and back off.
+const QuotaRejectionPrefix = "rate-limited:"
+
+// quotaWindow is the accounting period of every quota.
+const quotaWindow = time.Hour
+
+// QuotaPolicy limits the 29001 containers a Renoter accepts per hour, per outer (submitting)
+// pubkey and in total. Zero disables a limit.
+type QuotaPolicy struct {
+	// Containers accepted per pubkey per hour
+	EventsPerKey int
+	// Container bytes accepted per pubkey per hour
+	BytesPerKey int64
+	// Containers accepted from all pubkeys per hour
+	TotalEvents int
+	// Container bytes accepted from all pubkeys per hour
+	TotalBytes int64
+	// Pubkeys tracked at once; the least recently seen is forgotten to make room
+	TrackedKeys int
+}
+
+// Validate checks that the policy is usable.
+func (p QuotaPolicy) Validate() error {
+	if p.EventsPerKey < 0 || p.BytesPerKey < 0 || p.TotalEvents < 0 || p.TotalBytes < 0 {
+		return fmt.Errorf("quotas must not be negative")
+	}
+	if (p.EventsPerKey > 0 || p.BytesPerKey > 0) && p.TrackedKeys <= 0 {
+		return fmt.Errorf("per-key quotas need a positive number of tracked keys")
+	}
+	return nil
+}
+
+// QuotaError is the standardized rejection for a container over quota. Its message starts with
+// QuotaRejectionPrefix.
+type QuotaError struct {
+	// "key" for a per-pubkey quota, "total" for the Renoter-wide one, or the HopRole of a
+	// role quota (see RolePolicy)
+	Scope string
+	// "events" or "bytes"
+	Resource string
+	// When the exhausted window ends
+	RetryAfter time.Duration
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("%s %s %s quota exceeded, retry in %s", QuotaRejectionPrefix, e.Scope, e.Resource, e.RetryAfter.Round(time.Second))
+}
+
+// quotaUsage is the consumption of one pubkey (or the total) in the current window.
+type quotaUsage struct {
+	pubkey      string
+	windowStart time.Time
+	events      int
+	bytes       int64
+}
+
+// charge adds one container of size bytes if it fits within maxEvents and maxBytes (0 = unlimited).
+func (u *quotaUsage) charge(scope string, size int64, maxEvents int, maxBytes int64, now time.Time) error {
+	if now.Sub(u.windowStart) >= quotaWindow {
+		u.windowStart, u.events, u.bytes = now, 0, 0
+	}
+	retryAfter := u.windowStart.Add(quotaWindow).Sub(now)
+	if maxEvents > 0 && u.events+1 > maxEvents {
+		return &QuotaError{Scope: scope, Resource: "events", RetryAfter: retryAfter}
+	}
+	if maxBytes > 0 && u.bytes+size > maxBytes {
+		return &QuotaError{Scope: scope, Resource: "bytes", RetryAfter: retryAfter}
+	}
+	u.events++
+	u.bytes += size
+	return nil
+}
+
+// quotaTracker accounts container usage per pubkey in an LRU of bounded size.
+type quotaTracker struct {
+	policy QuotaPolicy
+
+	mu    sync.Mutex
+	total quotaUsage
+	// Most recently seen pubkeys at the front
+	order *list.List
+	keys  map[string]*list.Element
+}
+
+func newQuotaTracker(policy QuotaPolicy) *quotaTracker {
+	return &quotaTracker{policy: policy, order: list.New(), keys: make(map[string]*list.Element)}
+}
+
+// charge accounts a container of size bytes from pubkey, or returns a *QuotaError.
+// Rejected containers are not counted.
+func (q *quotaTracker) charge(pubkey string, size int64, now time.Time) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	// Check the per-key quota first so a single noisy key cannot consume the total
+	var usage *quotaUsage
+	if q.policy.EventsPerKey > 0 || q.policy.BytesPerKey > 0 {
+		usage = q.usageLocked(pubkey, now)
+		probe := *usage
+		if err := probe.charge("key", size, q.policy.EventsPerKey, q.policy.BytesPerKey, now); err != nil {
+			return err
+		}
+	}
+	if err := q.total.charge("total", size, q.policy.TotalEvents, q.policy.TotalBytes, now); err != nil {
+		return err
+	}
+	if usage != nil {
+		usage.charge("key", size, q.policy.EventsPerKey, q.policy.BytesPerKey, now)
+	}
+	return nil
+}
+
+// usageLocked returns the usage of pubkey, tracking it (and forgetting the least recently seen
+// pubkey if the tracker is full) when it is new.
+func (q *quotaTracker) usageLocked(pubkey string, now time.Time) *quotaUsage {
+	if element, ok := q.keys[pubkey]; ok {
+		q.order.MoveToFront(element)
+		return element.Value.(*quotaUsage)
+	}
+	if q.order.Len() >= q.policy.TrackedKeys {
+		oldest := q.order.Back()
+		q.order.Remove(oldest)
+		delete(q.keys, oldest.Value.(*quotaUsage).pubkey)
+	}
+	usage := &quotaUsage{pubkey: pubkey, windowStart: now}
+	q.keys[pubkey] = q.order.PushFront(usage)
+	return usage
+}
+
+// trackedKeys returns the number of pubkeys currently accounted.
+func (q *quotaTracker) trackedKeys() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.order.Len()
+}
+
+// SetQuotaPolicy enables per-hour event and byte quotas on incoming 29001 containers.
+func (r *Renoter) SetQuotaPolicy(policy QuotaPolicy) error {
+	if err := policy.Validate(); err != nil {
+		logging.Error("server.quota.SetQuotaPolicy: invalid quota policy: %v", err)
+		return fmt.Errorf("invalid quota policy: %w", err)
+	}
+	r.quota = newQuotaTracker(policy)
+	logging.Info("server.quota.SetQuotaPolicy: Quotas per hour: %d events/%d bytes per key (%d keys tracked), %d events/%d bytes in total",
+		policy.EventsPerKey, policy.BytesPerKey, policy.TrackedKeys, policy.TotalEvents, policy.TotalBytes)
+	return nil
+}
+
+// chargeQuota accounts an incoming container against the quotas. It is a no-op without a quota policy.
+func (r *Renoter) chargeQuota(pubkey string, size int64, now time.Time) error {
+	if r.quota == nil {
+		return nil
+	}
+	if err := r.quota.charge(pubkey, size, now); err != nil {
+		logging.Warn("server.quota.chargeQuota: rejecting %d byte container from %s: %v", size, pubkey[:16], err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestQuotaPolicy_Validate(t *testing.T) {
+	if err := (QuotaPolicy{EventsPerKey: 10, TrackedKeys: 100}).Validate(); err != nil {
+		t.Errorf("Validate() error = %v", err)
+	}
+	if err := (QuotaPolicy{TotalBytes: 1 << 20}).Validate(); err != nil {
+		t.Errorf("Validate() of a total-only policy error = %v", err)
+	}
+	for name, policy := range map[string]QuotaPolicy{
+		"negative":        {EventsPerKey: -1, TrackedKeys: 10},
+		"no tracked keys": {BytesPerKey: 1000},
+	} {
+		if err := policy.Validate(); err == nil {
+			t.Errorf("Validate(%s) should fail", name)
+		}
+	}
+}
+
+func TestQuotaTracker_PerKey(t *testing.T) {
+	q := newQuotaTracker(QuotaPolicy{EventsPerKey: 2, BytesPerKey: 250, TrackedKeys: 10})
+	now := time.Now()
+
+	if err := q.charge("alice", 100, now); err != nil {
+		t.Fatalf("charge() error = %v", err)
+	}
+	if err := q.charge("alice", 100, now); err != nil {
+		t.Fatalf("charge() error = %v", err)
+	}
+	err := q.charge("alice", 10, now.Add(10*time.Minute))
+	var quotaErr *QuotaError
+	if !errors.As(err, &quotaErr) || quotaErr.Scope != "key" || quotaErr.Resource != "events" || quotaErr.RetryAfter != 50*time.Minute {
+		t.Fatalf("charge() over the event quota error = %v, want a key events QuotaError", err)
+	}
+	if !strings.HasPrefix(err.Error(), QuotaRejectionPrefix) {
+		t.Errorf("QuotaError = %q, want prefix %q", err, QuotaRejectionPrefix)
+	}
+
+	// Other keys have their own quota, which bytes can also exhaust
+	if err := q.charge("bob", 300, now); !errors.As(err, &quotaErr) || quotaErr.Resource != "bytes" {
+		t.Errorf("charge() over the byte quota error = %v, want a bytes QuotaError", err)
+	}
+	if err := q.charge("bob", 250, now); err != nil {
+		t.Errorf("charge() after a rejected container error = %v, rejections should not count", err)
+	}
+
+	// A new window starts an hour after the first container
+	if err := q.charge("alice", 100, now.Add(time.Hour)); err != nil {
+		t.Errorf("charge() in the next window error = %v", err)
+	}
+}
+
+func TestQuotaTracker_Total(t *testing.T) {
+	q := newQuotaTracker(QuotaPolicy{EventsPerKey: 5, TotalEvents: 3, TrackedKeys: 10})
+	now := time.Now()
+	for _, key := range []string{"a", "b", "c"} {
+		if err := q.charge(key, 1, now); err != nil {
+			t.Fatalf("charge(%s) error = %v", key, err)
+		}
+	}
+	var quotaErr *QuotaError
+	if err := q.charge("d", 1, now); !errors.As(err, &quotaErr) || quotaErr.Scope != "total" {
+		t.Errorf("charge() over the total quota error = %v, want a total QuotaError", err)
+	}
+}
+
+func TestQuotaTracker_LRU(t *testing.T) {
+	q := newQuotaTracker(QuotaPolicy{EventsPerKey: 1, TrackedKeys: 2})
+	now := time.Now()
+	q.charge("a", 1, now)
+	q.charge("b", 1, now)
+	// Seeing a again makes b the least recently seen key
+	if err := q.charge("a", 1, now); err == nil {
+		t.Fatal("charge() should reject a's second container")
+	}
+	q.charge("c", 1, now)
+
+	if got := q.trackedKeys(); got != 2 {
+		t.Errorf("trackedKeys() = %d, want 2", got)
+	}
+	if err := q.charge("a", 1, now); err == nil {
+		t.Error("a should still be tracked and over quota")
+	}
+	if err := q.charge("b", 1, now); err != nil {
+		t.Errorf("b should have been forgotten, charge() error = %v", err)
+	}
+}
+
+func TestRenoter_ProcessEvent_Quota(t *testing.T) {
+	ctx := context.Background()
+	renoter := newOfflineRenoter(t)
+	if err := renoter.SetQuotaPolicy(QuotaPolicy{EventsPerKey: 1, TrackedKeys: 10}); err != nil {
+		t.Fatalf("SetQuotaPolicy() error = %v", err)
+	}
+
+	sk := nostr.GeneratePrivateKey()
+	for i, content := range []string{"first", "second"} {
+		event := nostr.Event{Kind: 29001, CreatedAt: nostr.Now(), Content: content, Tags: nostr.Tags{}}
+		event.Sign(sk)
+		err := renoter.ProcessEvent(ctx, &event)
+		if i == 0 && err != nil {
+			t.Fatalf("ProcessEvent() error = %v", err)
+		}
+		if i == 1 && (err == nil || !strings.HasPrefix(err.Error(), QuotaRejectionPrefix)) {
+			t.Errorf("ProcessEvent() over quota error = %v, want a rate-limited rejection", err)
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package server
+
+import (
+	"context"
+
+	"github.com/fiatjaf/khatru"
+	"github.com/girino/nostr-lib/logging"
+	"github.com/girino/renoter/internal/config"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// AttachToRelay makes r process the 29001 containers addressed to it that are published to an
+// existing khatru relay, so an operator can run a Renoter inside their own relay instead of
+// subscribing to it from a separate process. Containers are handed to background workers, so
+// publishing clients never wait for decryption or forwarding; the workers stop with ctx.
+// The relay still broadcasts the containers to its other listeners as usual.
+func AttachToRelay(ctx context.Context, relay *khatru.Relay, r *Renoter) {
+	filter := r.subscriptionFilter()
+	queue := r.startQueue(ctx, "AttachToRelay", r.HandleOutcome)
+
+	relay.OnEphemeralEvent = append(relay.OnEphemeralEvent, func(_ context.Context, event *nostr.Event) {
+		if event.Kind != config.StandardizedWrapperKind || !filter.Matches(event) {
+			return
+		}
+		// khatru owns the event it hands out; queue a copy
+		copied := *event
+		select {
+		case queue <- &copied:
+		default:
+			logging.Warn("server.relay.AttachToRelay: processing queue full (%d), dropping event %s", cap(queue), event.ID)
+		}
+	})
+
+	logging.Info("server.relay.AttachToRelay: Processing standardized wrapper events (kind 29001) with our pubkey in 'p' tag published to the relay")
+}
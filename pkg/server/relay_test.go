@@ -0,0 +1,51 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestAttachToRelay_ProcessesPublishedContainers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	testRelay, err := StartTestRelay(ctx)
+	if err != nil {
+		t.Fatalf("Failed to start test relay: %v", err)
+	}
+	defer testRelay.Stop(ctx)
+
+	renoter, err := NewRenoter(ctx, nostr.GeneratePrivateKey(), []string{testRelay.URL()})
+	if err != nil {
+		t.Fatalf("NewRenoter() error = %v", err)
+	}
+	AttachToRelay(ctx, testRelay.Relay(), renoter)
+
+	// The exit publishes the final (ephemeral) event back to the relay; listen for it first
+	final := &nostr.Event{Kind: 20001, Content: "through an attached renoter", CreatedAt: nostr.Now(), Tags: nostr.Tags{}}
+	final.Sign(nostr.GeneratePrivateKey())
+	delivered := nostr.NewSimplePool(ctx).SubscribeMany(ctx, []string{testRelay.URL()}, nostr.Filter{IDs: []string{final.ID}})
+	time.Sleep(200 * time.Millisecond)
+
+	container := wrapForRenoters(t, final, []*Renoter{renoter})
+	conn, err := nostr.RelayConnect(ctx, testRelay.URL())
+	if err != nil {
+		t.Fatalf("RelayConnect() error = %v", err)
+	}
+	defer conn.Close()
+	if err := conn.Publish(ctx, *container); err != nil {
+		t.Fatalf("Publish() of the container error = %v", err)
+	}
+
+	select {
+	case relayEvent := <-delivered:
+		if relayEvent.Event.Content != final.Content {
+			t.Errorf("delivered content = %q, want %q", relayEvent.Event.Content, final.Content)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("final event was not published by the attached Renoter")
+	}
+}
@@ -0,0 +1,239 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/girino/renoter/internal/config"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// SignedKinds are the only kinds NewIdentityHandler signs: what a Renoter signs with its identity
+// key. A compromised Renoter process can then not make the signer sign arbitrary notes.
+var SignedKinds = []int{config.ServiceDescriptorKind, config.HeartbeatKind, labelKind}
+
+// identityRequest is the body RemoteIdentity posts to a signer service.
+type identityRequest struct {
+	// Event to sign (/sign)
+	Event *nostr.Event `json:"event,omitempty"`
+	// Hex pubkey to derive the conversation key with (/conversation-key)
+	PubKey string `json:"pubkey,omitempty"`
+}
+
+// identityResponse is the body a signer service answers with.
+type identityResponse struct {
+	// Hex pubkey of the identity (/pubkey)
+	PubKey string `json:"pubkey,omitempty"`
+	// The signed event (/sign)
+	Event *nostr.Event `json:"event,omitempty"`
+	// Hex conversation key (/conversation-key)
+	Key   string `json:"key,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// maxIdentityRequestSize bounds request and response bodies: descriptors, heartbeats and labels
+// are small events.
+const maxIdentityRequestSize = 64 * 1024
+
+// RemoteIdentity is an Identity delegating signing and conversation-key derivation to a signer
+// service (see NewIdentityHandler), so the identity key stays in another process or an HSM. The
+// service is reached over HTTP, or over a unix socket for a signer on the same host. Only
+// conversation keys, which open the events of one sender, ever reach the Renoter process.
+// Signatures are verified locally.
+type RemoteIdentity struct {
+	// Base URL of the signer service
+	URL string
+	// HTTP client used for requests
+	Client *http.Client
+	// Bearer token sent with every request, empty for none
+	Token string
+
+	publicKey string
+}
+
+// NewRemoteIdentity connects to the signer service at url and fetches its pubkey. url is an
+// http(s) base URL, or "unix:<path>" for a service listening on a unix socket. token is the
+// bearer token the service requires, if any.
+func NewRemoteIdentity(ctx context.Context, url, token string) (*RemoteIdentity, error) {
+	r := &RemoteIdentity{URL: strings.TrimSuffix(url, "/"), Client: &http.Client{}, Token: token}
+	if path, ok := strings.CutPrefix(url, "unix:"); ok {
+		var dialer net.Dialer
+		r.URL = "http://signer"
+		r.Client.Transport = &http.Transport{DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", path)
+		}}
+	}
+
+	var resp identityResponse
+	if err := r.call(ctx, http.MethodGet, "/pubkey", nil, &resp); err != nil {
+		return nil, err
+	}
+	if !nostr.IsValidPublicKey(resp.PubKey) {
+		return nil, fmt.Errorf("signer service returned an invalid pubkey %q", resp.PubKey)
+	}
+	r.publicKey = resp.PubKey
+	logging.Info("server.remoteidentity.NewRemoteIdentity: Using signer service %s, pubkey: %s (first 16 chars)", url, resp.PubKey[:16])
+	return r, nil
+}
+
+// PublicKey implements Identity.
+func (r *RemoteIdentity) PublicKey() string {
+	return r.publicKey
+}
+
+// SignEvent implements Signer by asking the signer service for a signature.
+func (r *RemoteIdentity) SignEvent(ctx context.Context, event *nostr.Event) error {
+	var resp identityResponse
+	if err := r.call(ctx, http.MethodPost, "/sign", &identityRequest{Event: event}, &resp); err != nil {
+		return err
+	}
+	// Never trust the remote: the signature must be over this event by this identity
+	signed := resp.Event
+	if signed == nil || signed.PubKey != r.publicKey || signed.Kind != event.Kind || signed.CreatedAt != event.CreatedAt ||
+		signed.Content != event.Content || !slices.EqualFunc(signed.Tags, event.Tags, slices.Equal) {
+		return fmt.Errorf("signer service returned a different event")
+	}
+	if ok, err := signed.CheckSignature(); !ok {
+		logging.Error("server.remoteidentity.SignEvent: signer service %s returned an invalid signature: %v", r.URL, err)
+		return fmt.Errorf("signer service returned an invalid signature")
+	}
+	*event = *signed
+	return nil
+}
+
+// ConversationKey implements Decrypter by asking the signer service for the key.
+func (r *RemoteIdentity) ConversationKey(ctx context.Context, pubkey string) ([32]byte, error) {
+	var key [32]byte
+	var resp identityResponse
+	if err := r.call(ctx, http.MethodPost, "/conversation-key", &identityRequest{PubKey: pubkey}, &resp); err != nil {
+		return key, err
+	}
+	decoded, err := hex.DecodeString(resp.Key)
+	if err != nil || len(decoded) != len(key) {
+		return key, fmt.Errorf("signer service returned an invalid conversation key")
+	}
+	copy(key[:], decoded)
+	return key, nil
+}
+
+// call sends req to the signer service endpoint path and decodes its answer into resp.
+func (r *RemoteIdentity) call(ctx context.Context, method, path string, req *identityRequest, resp *identityResponse) error {
+	var body io.Reader
+	if req != nil {
+		data, err := json.Marshal(req)
+		if err != nil {
+			return fmt.Errorf("failed to serialize signer request: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, method, r.URL+path, body)
+	if err != nil {
+		return fmt.Errorf("failed to create signer request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if r.Token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+r.Token)
+	}
+
+	logging.DebugMethod("server.remoteidentity", "call", "%s %s", method, path)
+	httpResp, err := r.Client.Do(httpReq)
+	if err != nil {
+		logging.Error("server.remoteidentity.call: request to signer service %s failed: %v", r.URL, err)
+		return fmt.Errorf("signer request failed: %w", err)
+	}
+	defer httpResp.Body.Close()
+
+	if err := json.NewDecoder(io.LimitReader(httpResp.Body, maxIdentityRequestSize)).Decode(resp); err != nil {
+		logging.Error("server.remoteidentity.call: invalid response from %s (status %d): %v", r.URL, httpResp.StatusCode, err)
+		return fmt.Errorf("invalid signer response (status %d): %w", httpResp.StatusCode, err)
+	}
+	if httpResp.StatusCode != http.StatusOK || resp.Error != "" {
+		logging.Error("server.remoteidentity.call: signer service %s returned status %d: %s", r.URL, httpResp.StatusCode, resp.Error)
+		return fmt.Errorf("signer service error (status %d): %s", httpResp.StatusCode, resp.Error)
+	}
+	return nil
+}
+
+// NewIdentityHandler returns an http.Handler serving RemoteIdentity clients with identity: GET
+// /pubkey, POST /sign and POST /conversation-key. Only events of SignedKinds are signed. With a
+// non-empty token, requests without it as their bearer token are refused: anyone reaching the
+// handler can open containers addressed to the identity.
+func NewIdentityHandler(identity Identity, token string) http.Handler {
+	reply := func(w http.ResponseWriter, status int, resp identityResponse) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(resp)
+	}
+	decode := func(w http.ResponseWriter, r *http.Request) (*identityRequest, bool) {
+		if r.Method != http.MethodPost {
+			reply(w, http.StatusMethodNotAllowed, identityResponse{Error: "only POST is supported"})
+			return nil, false
+		}
+		var req identityRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxIdentityRequestSize)).Decode(&req); err != nil {
+			reply(w, http.StatusBadRequest, identityResponse{Error: "invalid request: " + err.Error()})
+			return nil, false
+		}
+		return &req, true
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pubkey", func(w http.ResponseWriter, r *http.Request) {
+		reply(w, http.StatusOK, identityResponse{PubKey: identity.PublicKey()})
+	})
+	mux.HandleFunc("/sign", func(w http.ResponseWriter, r *http.Request) {
+		req, ok := decode(w, r)
+		if !ok {
+			return
+		}
+		if req.Event == nil || !slices.Contains(SignedKinds, req.Event.Kind) {
+			reply(w, http.StatusForbidden, identityResponse{Error: "only descriptors, heartbeats and labels are signed"})
+			return
+		}
+		logging.DebugMethod("server.remoteidentity", "IdentityHandler", "Signing kind %d for %s", req.Event.Kind, r.RemoteAddr)
+		if err := identity.SignEvent(r.Context(), req.Event); err != nil {
+			logging.Warn("server.remoteidentity.IdentityHandler: signing for %s failed: %v", r.RemoteAddr, err)
+			reply(w, http.StatusServiceUnavailable, identityResponse{Error: err.Error()})
+			return
+		}
+		reply(w, http.StatusOK, identityResponse{Event: req.Event})
+	})
+	mux.HandleFunc("/conversation-key", func(w http.ResponseWriter, r *http.Request) {
+		req, ok := decode(w, r)
+		if !ok {
+			return
+		}
+		if !nostr.IsValidPublicKey(req.PubKey) {
+			reply(w, http.StatusBadRequest, identityResponse{Error: "invalid pubkey"})
+			return
+		}
+		key, err := identity.ConversationKey(r.Context(), req.PubKey)
+		if err != nil {
+			reply(w, http.StatusBadRequest, identityResponse{Error: err.Error()})
+			return
+		}
+		reply(w, http.StatusOK, identityResponse{Key: hex.EncodeToString(key[:])})
+	})
+	if token == "" {
+		return mux
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			logging.Warn("server.remoteidentity.IdentityHandler: refused %s %s from %s without a valid token", r.Method, r.URL.Path, r.RemoteAddr)
+			reply(w, http.StatusUnauthorized, identityResponse{Error: "missing or invalid token"})
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
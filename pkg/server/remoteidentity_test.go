@@ -0,0 +1,173 @@
+package server
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/girino/renoter/internal/config"
+	"github.com/girino/renoter/internal/descriptor"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// testSignerToken is the bearer token newSignerService requires.
+const testSignerToken = "test-token"
+
+// newSignerService serves a fresh KeyIdentity with NewIdentityHandler, requiring testSignerToken.
+func newSignerService(t *testing.T) (*KeyIdentity, string) {
+	t.Helper()
+	identity, err := NewKeyIdentity(nostr.GeneratePrivateKey())
+	if err != nil {
+		t.Fatalf("NewKeyIdentity() error = %v", err)
+	}
+	srv := httptest.NewServer(NewIdentityHandler(identity, testSignerToken))
+	t.Cleanup(srv.Close)
+	return identity, srv.URL
+}
+
+func TestRemoteIdentity(t *testing.T) {
+	ctx := context.Background()
+	local, url := newSignerService(t)
+	remote, err := NewRemoteIdentity(ctx, url, testSignerToken)
+	if err != nil {
+		t.Fatalf("NewRemoteIdentity() error = %v", err)
+	}
+	if remote.PublicKey() != local.PublicKey() {
+		t.Errorf("PublicKey() = %s, want %s", remote.PublicKey(), local.PublicKey())
+	}
+
+	sender, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	key, err := remote.ConversationKey(ctx, sender)
+	if err != nil {
+		t.Fatalf("ConversationKey() error = %v", err)
+	}
+	if want, _ := local.ConversationKey(ctx, sender); key != want {
+		t.Error("ConversationKey() differs from the service's key")
+	}
+	if _, err := remote.ConversationKey(ctx, "not a pubkey"); err == nil {
+		t.Error("ConversationKey() of an invalid pubkey should fail")
+	}
+
+	heartbeat := descriptor.Heartbeat{Status: descriptor.StatusOnline, Interval: time.Minute}.Event()
+	if err := remote.SignEvent(ctx, &heartbeat); err != nil {
+		t.Fatalf("SignEvent() error = %v", err)
+	}
+	if ok, _ := heartbeat.CheckSignature(); !ok || heartbeat.PubKey != local.PublicKey() {
+		t.Error("SignEvent() did not sign the heartbeat with the service's key")
+	}
+
+	// The service only signs what a Renoter signs
+	note := nostr.Event{Kind: 1, CreatedAt: nostr.Now(), Tags: nostr.Tags{}, Content: "not a Renoter event"}
+	if err := remote.SignEvent(ctx, &note); err == nil || note.Sig != "" {
+		t.Error("SignEvent() of a kind 1 note should be refused")
+	}
+}
+
+func TestRemoteIdentity_Token(t *testing.T) {
+	ctx := context.Background()
+	_, url := newSignerService(t)
+	for _, token := range []string{"", "wrong-token"} {
+		if _, err := NewRemoteIdentity(ctx, url, token); err == nil {
+			t.Errorf("NewRemoteIdentity() with token %q should be refused", token)
+		}
+	}
+	remote, err := NewRemoteIdentity(ctx, url, testSignerToken)
+	if err != nil {
+		t.Fatalf("NewRemoteIdentity() error = %v", err)
+	}
+	remote.Token = "wrong-token"
+	sender, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+	if _, err := remote.ConversationKey(ctx, sender); err == nil {
+		t.Error("ConversationKey() with a wrong token should be refused")
+	}
+}
+
+func TestRemoteIdentity_RejectsForeignSignature(t *testing.T) {
+	// A service signing with another key than the one it announced
+	announced, _ := NewKeyIdentity(nostr.GeneratePrivateKey())
+	signing, _ := NewKeyIdentity(nostr.GeneratePrivateKey())
+	mux := http.NewServeMux()
+	mux.Handle("/pubkey", NewIdentityHandler(announced, ""))
+	mux.Handle("/", NewIdentityHandler(signing, ""))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	remote, err := NewRemoteIdentity(context.Background(), srv.URL, "")
+	if err != nil {
+		t.Fatalf("NewRemoteIdentity() error = %v", err)
+	}
+	event := nostr.Event{Kind: config.HeartbeatKind, CreatedAt: nostr.Now(), Tags: nostr.Tags{}}
+	if err := remote.SignEvent(context.Background(), &event); err == nil {
+		t.Error("SignEvent() accepted a signature by another key")
+	}
+}
+
+func TestRemoteIdentity_UnixSocket(t *testing.T) {
+	local, _ := NewKeyIdentity(nostr.GeneratePrivateKey())
+	path := filepath.Join(t.TempDir(), "signer.sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Skipf("unix sockets unavailable: %v", err)
+	}
+	srv := &http.Server{Handler: NewIdentityHandler(local, "")}
+	go srv.Serve(listener)
+	defer srv.Close()
+
+	remote, err := NewRemoteIdentity(context.Background(), "unix:"+path, "")
+	if err != nil {
+		t.Fatalf("NewRemoteIdentity() error = %v", err)
+	}
+	if remote.PublicKey() != local.PublicKey() {
+		t.Errorf("PublicKey() = %s, want %s", remote.PublicKey(), local.PublicKey())
+	}
+}
+
+func TestNewRenoterWithIdentity_Remote(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	local, url := newSignerService(t)
+	remote, err := NewRemoteIdentity(ctx, url, testSignerToken)
+	if err != nil {
+		t.Fatalf("NewRemoteIdentity() error = %v", err)
+	}
+	if _, err := NewRenoterWithIdentity(ctx, nil, []string{"wss://relay.example.com"}, nil); err == nil {
+		t.Error("NewRenoterWithIdentity() with a nil identity should fail")
+	}
+	pool := &fakePool{events: make(chan nostr.RelayEvent, 1), published: make(chan nostr.Event, 2)}
+	renoter, err := NewRenoterWithIdentity(ctx, remote, []string{"wss://relay.example.com"}, pool)
+	if err != nil {
+		t.Fatalf("NewRenoterWithIdentity() error = %v", err)
+	}
+	if renoter.PublicKey != local.PublicKey() || renoter.PrivateKey != "" {
+		t.Errorf("Renoter keys = %q/%q, want the service's pubkey and no private key", renoter.PublicKey, renoter.PrivateKey)
+	}
+
+	// Descriptors are signed by the service
+	if err := renoter.AnnounceOffline(ctx, ""); err != nil {
+		t.Fatalf("AnnounceOffline() error = %v", err)
+	}
+	if d := <-pool.published; d.PubKey != local.PublicKey() || d.Kind != config.ServiceDescriptorKind {
+		t.Errorf("published %d by %s, want a descriptor by the service's key", d.Kind, d.PubKey)
+	}
+
+	// Containers are opened with conversation keys from the service
+	if err := renoter.SubscribeToWrappedEvents(ctx); err != nil {
+		t.Fatalf("SubscribeToWrappedEvents() error = %v", err)
+	}
+	event := &nostr.Event{Kind: 1, CreatedAt: nostr.Now(), Tags: nostr.Tags{}, Content: "through a remote identity"}
+	event.Sign(nostr.GeneratePrivateKey())
+	pool.events <- nostr.RelayEvent{Event: wrapForRenoters(t, event, []*Renoter{renoter})}
+	select {
+	case published := <-pool.published:
+		if published.ID != event.ID {
+			t.Errorf("published event %s, want the final event %s", published.ID, event.ID)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the final event was not published")
+	}
+}
@@ -3,119 +3,649 @@ package server
 import (
 	"context"
 	"fmt"
+	"slices"
+	"sync/atomic"
 	"time"
 
 	"github.com/girino/nostr-lib/logging"
+	"github.com/girino/renoter/internal/config"
+	"github.com/girino/renoter/internal/descriptor"
+	"github.com/girino/renoter/internal/relayinfo"
+	"github.com/girino/renoter/internal/relaypool"
+	"github.com/girino/renoter/internal/stamp"
+	"github.com/girino/renoter/internal/storage"
 	"github.com/nbd-wtf/go-nostr"
 )
 
 // Renoter represents a Renoter server that decrypts wrapper events
 // and forwards them to the next Renoter or final destination.
 type Renoter struct {
-	// Private key for this Renoter (used for decryption)
+	// Private key for this Renoter (empty if identity holds it elsewhere, see NewRenoterWithIdentity)
 	PrivateKey string
 
-	// Public key derived from private key
+	// Public key of identity
 	PublicKey string
 
+	// Signs and derives conversation keys with this Renoter's identity key
+	identity Identity
+
 	// Event cache for replay attack protection
 	eventCache *EventCache
+	// Limits of eventCache (zero = DefaultReplayCachePolicy), see SetReplayCachePolicy
+	replayCache ReplayCachePolicy
+	// Whether runCacheCleanup is running
+	cleaning atomic.Bool
+
+	// Idempotency keys of the final events published as exit, to drop resent and duplicate copies
+	deliveries *EventCache
+	// How long published final events are remembered (0 = deliveryCacheCutoff)
+	deliveryTTL time.Duration
+	// Copies suppressed by resentLayer and publishFinal, see DuplicateStats
+	resentLayers    atomic.Int64
+	duplicateFinals atomic.Int64
+	// Containers and layers rejected for their routing tags, see MalformedWrappers
+	malformedWrappers atomic.Int64
+	// Containers processed, by outcome, see OutcomeStats
+	outcomes outcomeCounters
+	// Final events published, by kind and hour, see KindStats
+	kindCounts kindCounter
+	// Don't count kindCounts, see SetKindStats
+	kindStatsOff bool
+
+	// Required proof-of-work difficulty for 29000 wrapper events under the default PoW admission
+	powDifficulty int
+
+	// Strategies 29000 layers are admitted with (empty = PoW at powDifficulty)
+	admissions []Admission
+	// Stamps spent by admitted layers, so each admits only one, see spendStamp
+	stamps *EventCache
+	// Leading zero bits of the layers admitted by PoW, see PoWStats
+	powBits powHistogram
+	// Processing traces of recent containers (nil = off), see SetTraceBuffer
+	traces *traceBuffer
+
+	// Size every 29000 is padded to before being wrapped in a 29001 container
+	standardizedSize int
+	// Smaller sizes 29000s arriving padded to them are re-wrapped to instead
+	buckets []int
+
+	// PoW difficulty mined on forwarded 29001 containers for relays that require it (0 = none)
+	containerPoWDifficulty int
+
+	// Optional paid mode (nil = free)
+	payment *paymentGate
+
+	// Optional per-hour quotas on incoming containers (nil = unlimited)
+	quota *quotaTracker
+	// Optional rules for the containers handled as entry and as exit (nil = none)
+	roles *roleGate
+
+	// Bounds of the delays mixed-lane layers are held for
+	mixing MixingPolicy
+	// Bounds of the created_at of containers and layers, see SetAgePolicy (zero = default)
+	age AgePolicy
+	// Containers handled, queued and held at once, see SetProcessingPolicy (zero = default)
+	processing ProcessingPolicy
+	// Slots of the layers held for mixing (nil = unlimited)
+	held chan struct{}
+	// Ignore report tags instead of adding timing trailers, see SetTimingTrailers
+	trailersOff bool
+	// Ignore ack tags instead of acknowledging layers, see SetHopAcks
+	acksOff bool
+	// Ignore reply tags instead of sending error notices, see SetErrorNotices
+	noticesOff bool
+	// Publish a label attributing each final event to this Renoter, see SetAttribution
+	attribution bool
+	// Support the lite profile, see SetLiteProfile
+	lite bool
+	// Hooks run on final events before they are published, see AddFinalHook
+	finalHooks finalHooks
+	// Local record of published final events (nil = none), see SetAuditLog
+	audit storage.Storage
+	// Local copy of published final events (nil = none), see StartMirror
+	mirror *mirror
+
+	// Optional delivery of final events to the inbox relays of mentioned pubkeys (nil = off)
+	mentions *MentionPolicy
 
-	// SimplePool for managing multiple relay connections (used for both listening and forwarding)
-	pool      *nostr.SimplePool
+	// Self-declared location announced in the service descriptor (empty/0 = undeclared)
+	region string
+	asn    uint32
+
+	// Interval the service descriptor is republished at, see AnnouncePeriodically (0 = once)
+	heartbeat time.Duration
+	// Liveness heartbeats and peer gossip (nil = off), see StartGossip
+	gossip *gossip
+
+	// Refinements applied to the subscription for incoming 29001 containers
+	subscription SubscriptionOptions
+	// Relays each kind of output is published to, see SetPublishPolicy
+	publishPolicy PublishPolicy
+	// What is done with containers addressed to other Renoters, see SetMisaddressedPolicy
+	misaddressed MisaddressedPolicy
+
+	// Time this Renoter was created, used as the subscription's since when SinceStartup is set
+	startedAt time.Time
+
+	// Stages every incoming 29001 container passes through
+	pipeline *Pipeline
+
+	// Pool holding the subscription connections; publishing uses a separate pool so
+	// forwarding bursts never compete with the subscription for a socket
+	pool      Pool
+	publisher *relaypool.Publisher
+	// Whether pool was created by NewRenoter, and so is closed by Close
+	ownPool bool
+	// Pool every event is published through: publisher, or the pool given to NewRenoterWithPool,
+	// in which case publisher is nil
+	forwarder Pool
 	relayURLs []string
+
+	// Goroutines started by this Renoter, stopped by Close
+	life lifecycle
+}
+
+// Pool is the relay operations a Renoter uses: subscribing to containers, fetching relay lists
+// and publishing. *nostr.SimplePool implements it; pass another implementation to
+// NewRenoterWithPool for fakes in tests or custom dialers, metrics or circuit breakers.
+type Pool = relaypool.Pool
+
+// SubscriptionOptions narrows the subscription for incoming 29001 containers beyond kind and
+// "p" tag, reducing the junk (stale or replayed events) that misbehaving relays deliver.
+// Relays may ignore filter fields, so since and the relay allowlist are also enforced locally.
+type SubscriptionOptions struct {
+	// Only request containers created at or after the Renoter's startup time
+	SinceStartup bool
+	// Maximum number of stored containers requested from each relay (0 = relay default)
+	Limit int
+	// Client-facing relays to listen on, a subset of the Renoter's relays (empty = all).
+	// Forwarding always uses all relays.
+	ListenRelays []string
+}
+
+// PublishPolicy routes the Renoter's outputs to subsets of its relays, e.g. final events to
+// public relays and re-wrapped 29001 containers only to relays friendly to Renoter traffic.
+// The next Renoter must listen on at least one of the forward relays, and clients checking
+// delivery look final events up on their server relays.
+type PublishPolicy struct {
+	// Relays re-wrapped 29001 containers are published to, a subset of the Renoter's relays (empty = all)
+	ForwardRelays []string
+	// Relays final events are published to, a subset of the Renoter's relays (empty = all).
+	// Inbox deliveries to mentioned pubkeys still skip all of the Renoter's relays.
+	FinalRelays []string
 }
 
 // NewRenoter creates a new Renoter instance with a SimplePool for multiple relay connections.
 func NewRenoter(ctx context.Context, privateKey string, relayURLs []string) (*Renoter, error) {
-	logging.DebugMethod("server.renoter", "NewRenoter", "Creating new Renoter instance with %d relays", len(relayURLs))
+	identity, err := keyIdentity(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	return NewRenoterWithIdentity(ctx, identity, relayURLs, nil)
+}
 
-	if privateKey == "" {
-		logging.Error("server.renoter.NewRenoter: private key cannot be empty")
-		return nil, fmt.Errorf("private key cannot be empty")
+// NewRenoterWithPool is like NewRenoter but subscribes, looks up and publishes through pool
+// instead of its own connections. Connection caps are then up to the pool, and
+// SetPublishPoolOptions has no effect.
+func NewRenoterWithPool(ctx context.Context, privateKey string, relayURLs []string, pool Pool) (*Renoter, error) {
+	if pool == nil {
+		logging.Error("server.renoter.NewRenoterWithPool: pool cannot be nil")
+		return nil, fmt.Errorf("pool cannot be nil")
 	}
+	identity, err := keyIdentity(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	return NewRenoterWithIdentity(ctx, identity, relayURLs, pool)
+}
 
-	if len(relayURLs) == 0 {
-		logging.Error("server.renoter.NewRenoter: relay URLs cannot be empty")
-		return nil, fmt.Errorf("relay URLs cannot be empty")
+// NewRenoterWithIdentity is like NewRenoterWithPool but signs and opens containers with identity,
+// e.g. a RemoteIdentity keeping the key out of this process. A nil pool gets its own connections
+// as with NewRenoter.
+func NewRenoterWithIdentity(ctx context.Context, identity Identity, relayURLs []string, pool Pool) (*Renoter, error) {
+	if identity == nil {
+		logging.Error("server.renoter.NewRenoterWithIdentity: identity cannot be nil")
+		return nil, fmt.Errorf("identity cannot be nil")
+	}
+	if pool != nil {
+		return newRenoter(ctx, identity, relayURLs, pool)
 	}
 
-	logging.DebugMethod("server.renoter", "NewRenoter", "Deriving public key from private key")
-	pubkey, err := nostr.GetPublicKey(privateKey)
+	// Create SimplePool for the subscription connections
+	simplePool := nostr.NewSimplePool(ctx)
+	r, err := newRenoter(ctx, identity, relayURLs, simplePool)
 	if err != nil {
-		logging.Error("server.renoter.NewRenoter: failed to get public key: %v", err)
-		return nil, fmt.Errorf("failed to get public key: %w", err)
+		return nil, err
 	}
-
-	// Create SimplePool for managing relay connections
-	pool := nostr.NewSimplePool(ctx)
+	r.ownPool = true
 	logging.DebugMethod("server.renoter", "NewRenoter", "Created SimplePool for %d relays", len(relayURLs))
 
+	// Forwarding publishes through its own capped pool, connecting on demand
+	r.publisher, err = relaypool.NewPublisher(ctx, relaypool.DefaultOptions())
+	if err != nil {
+		logging.Error("server.renoter.NewRenoter: failed to create publisher: %v", err)
+		return nil, fmt.Errorf("failed to create publisher: %w", err)
+	}
+	r.forwarder = r.publisher
+
 	// Ensure all relays are available in the pool (they'll be connected on-demand)
 	for _, url := range relayURLs {
-		_, err := pool.EnsureRelay(url)
+		_, err := simplePool.EnsureRelay(url)
 		if err != nil {
 			logging.Error("server.renoter.NewRenoter: failed to ensure relay %s in pool: %v", url, err)
 			return nil, fmt.Errorf("failed to ensure relay %s: %w", url, err)
 		}
 	}
+	return r, nil
+}
+
+// keyIdentity validates privateKey and creates a KeyIdentity for it.
+func keyIdentity(privateKey string) (*KeyIdentity, error) {
+	identity, err := NewKeyIdentity(privateKey)
+	if err != nil {
+		logging.Error("server.renoter.NewRenoter: %v", err)
+		return nil, err
+	}
+	return identity, nil
+}
+
+// newRenoter validates the relays and creates a Renoter with identity subscribing through pool.
+func newRenoter(ctx context.Context, identity Identity, relayURLs []string, pool Pool) (*Renoter, error) {
+	logging.DebugMethod("server.renoter", "NewRenoter", "Creating new Renoter instance with %d relays", len(relayURLs))
+
+	if len(relayURLs) == 0 {
+		logging.Error("server.renoter.NewRenoter: relay URLs cannot be empty")
+		return nil, fmt.Errorf("relay URLs cannot be empty")
+	}
+	pubkey := identity.PublicKey()
 
 	logging.Info("server.renoter.NewRenoter: Created Renoter instance, pubkey: %s (first 16 chars), %d relays", pubkey[:16], len(relayURLs))
 
-	return &Renoter{
-		PrivateKey: privateKey,
-		PublicKey:  pubkey,
-		eventCache: NewEventCache(5000, 2*time.Hour), // Max 5K entries, 2 hour cutoff
-		pool:       pool,
-		relayURLs:  relayURLs,
-	}, nil
+	r := &Renoter{
+		PublicKey:        pubkey,
+		identity:         identity,
+		eventCache:       NewEventCache(DefaultReplayCachePolicy().MaxSize, DefaultReplayCachePolicy().TTL),
+		deliveries:       NewEventCache(deliveryCacheSize, deliveryCacheCutoff),
+		stamps:           NewEventCache(stampCacheSize, stamp.MaxAge+stampClockSkew),
+		powDifficulty:    config.PoWDifficulty,
+		mixing:           DefaultMixingPolicy(),
+		processing:       DefaultProcessingPolicy(),
+		held:             make(chan struct{}, DefaultProcessingPolicy().MaxHeld),
+		standardizedSize: config.StandardizedSize,
+		startedAt:        time.Now(),
+		pool:             pool,
+		forwarder:        pool,
+		relayURLs:        relayURLs,
+	}
+	if key, ok := identity.(*KeyIdentity); ok {
+		r.PrivateKey = key.privateKey
+	}
+	r.pipeline = r.defaultPipeline()
+	return r, nil
 }
 
-// GetPool returns the SimplePool used by this Renoter.
-func (r *Renoter) GetPool() *nostr.SimplePool {
+// GetPool returns the pool used by this Renoter's subscription.
+func (r *Renoter) GetPool() Pool {
 	return r.pool
 }
 
-// GetRelayURLs returns the list of relay URLs used by this Renoter.
-func (r *Renoter) GetRelayURLs() []string {
+// GetPublisher returns the capped publisher this Renoter forwards events through, or nil if it
+// was created with a pool of its own (NewRenoterWithPool or NewRenoterWithIdentity), which it then
+// forwards through instead.
+func (r *Renoter) GetPublisher() *relaypool.Publisher {
+	return r.publisher
+}
+
+// SetPublishPoolOptions sets the connection cap and idle timeout used when forwarding. It does
+// nothing for a Renoter forwarding through a pool it was given, whose caps are up to that pool.
+func (r *Renoter) SetPublishPoolOptions(opts relaypool.Options) error {
+	if r.publisher == nil {
+		if err := opts.Validate(); err != nil {
+			logging.Error("server.renoter.SetPublishPoolOptions: invalid options: %v", err)
+			return fmt.Errorf("invalid pool options: %w", err)
+		}
+		logging.DebugMethod("server.renoter", "SetPublishPoolOptions", "Forwarding through the given pool, ignoring publisher options")
+		return nil
+	}
+	return r.publisher.SetOptions(opts)
+}
+
+// SetSizeLimits validates and applies the size limits used when unwrapping and re-wrapping events.
+// All Renoters and clients on a path must use the same standardized size.
+func (r *Renoter) SetSizeLimits(limits config.SizeLimits) error {
+	if err := limits.Validate(); err != nil {
+		logging.Error("server.renoter.SetSizeLimits: invalid size limits: %v", err)
+		return fmt.Errorf("invalid size limits: %w", err)
+	}
+	r.standardizedSize = limits.StandardizedSize
+	r.buckets = slices.Clone(limits.Buckets)
+	logging.DebugMethod("server.renoter", "SetSizeLimits", "Standardized size set to %d bytes, buckets %v", r.standardizedSize, r.buckets)
+	return nil
+}
+
+// sizeLimits returns the sizes padded 29000s may have.
+func (r *Renoter) sizeLimits() config.SizeLimits {
+	return config.SizeLimits{
+		StandardizedSize:  r.standardizedSize,
+		MaxInnerEventSize: r.standardizedSize - config.PaddingTagOverhead,
+		Buckets:           r.buckets,
+	}
+}
+
+// SetContainerPoWDifficulty sets the PoW difficulty mined on forwarded 29001 containers.
+// This is independent of the 29000 PoW required from clients; 0 disables container mining.
+func (r *Renoter) SetContainerPoWDifficulty(difficulty int) error {
+	if difficulty < 0 || difficulty > 256 {
+		logging.Error("server.renoter.SetContainerPoWDifficulty: invalid difficulty %d", difficulty)
+		return fmt.Errorf("container PoW difficulty must be between 0 and 256, got %d", difficulty)
+	}
+	r.containerPoWDifficulty = difficulty
+	logging.DebugMethod("server.renoter", "SetContainerPoWDifficulty", "Container PoW difficulty set to %d", difficulty)
+	return nil
+}
+
+// DetectContainerPoW raises the container PoW difficulty to the highest min_pow_difficulty
+// advertised in the NIP-11 documents of the relays containers are forwarded to, and returns the
+// resulting difficulty. Call it after SetPublishPolicy.
+func (r *Renoter) DetectContainerPoW(ctx context.Context) int {
+	if detected := relayinfo.MinPoWDifficulty(ctx, r.forwardRelayURLs()); detected > r.containerPoWDifficulty {
+		logging.Info("server.renoter.DetectContainerPoW: Relays require PoW difficulty %d, mining forwarded 29001 containers accordingly", detected)
+		r.containerPoWDifficulty = detected
+	}
+	return r.containerPoWDifficulty
+}
+
+// SetSubscriptionOptions validates and applies the refinements used by SubscribeToWrappedEvents.
+// It must be called before subscribing.
+func (r *Renoter) SetSubscriptionOptions(opts SubscriptionOptions) error {
+	if opts.Limit < 0 {
+		logging.Error("server.renoter.SetSubscriptionOptions: invalid limit %d", opts.Limit)
+		return fmt.Errorf("subscription limit must not be negative, got %d", opts.Limit)
+	}
+
+	listenRelays, err := r.relaySubset("listen", opts.ListenRelays)
+	if err != nil {
+		logging.Error("server.renoter.SetSubscriptionOptions: %v", err)
+		return err
+	}
+	opts.ListenRelays = listenRelays
+
+	r.subscription = opts
+	logging.DebugMethod("server.renoter", "SetSubscriptionOptions", "Subscription options: since startup=%v, limit=%d, listen relays=%v", opts.SinceStartup, opts.Limit, opts.ListenRelays)
+	return nil
+}
+
+// SetPublishPolicy validates and applies the relays each kind of output is published to.
+func (r *Renoter) SetPublishPolicy(policy PublishPolicy) error {
+	forwardRelays, err := r.relaySubset("forward", policy.ForwardRelays)
+	if err != nil {
+		logging.Error("server.renoter.SetPublishPolicy: %v", err)
+		return err
+	}
+	finalRelays, err := r.relaySubset("final", policy.FinalRelays)
+	if err != nil {
+		logging.Error("server.renoter.SetPublishPolicy: %v", err)
+		return err
+	}
+	r.publishPolicy = PublishPolicy{ForwardRelays: forwardRelays, FinalRelays: finalRelays}
+	logging.DebugMethod("server.renoter", "SetPublishPolicy", "Publish policy: forward relays=%v, final relays=%v", forwardRelays, finalRelays)
+	return nil
+}
+
+// relaySubset normalizes urls and checks that each is one of the Renoter's relays.
+func (r *Renoter) relaySubset(role string, urls []string) ([]string, error) {
+	known := make(map[string]bool, len(r.relayURLs))
+	for _, url := range r.relayURLs {
+		known[nostr.NormalizeURL(url)] = true
+	}
+	subset := make([]string, 0, len(urls))
+	for _, url := range urls {
+		normalized := nostr.NormalizeURL(url)
+		if !known[normalized] {
+			return nil, fmt.Errorf("%s relay %s is not one of this Renoter's relays", role, url)
+		}
+		subset = append(subset, normalized)
+	}
+	return subset, nil
+}
+
+// forwardRelayURLs returns the relays re-wrapped containers are published to.
+func (r *Renoter) forwardRelayURLs() []string {
+	if len(r.publishPolicy.ForwardRelays) > 0 {
+		return r.publishPolicy.ForwardRelays
+	}
 	return r.relayURLs
 }
 
-// ProcessEvent processes a wrapped event by verifying signature,
-// decrypting one layer, and forwarding the inner event.
-func (r *Renoter) ProcessEvent(ctx context.Context, event *nostr.Event) error {
-	// Reject events with timestamps more than 1 hour in the past
-	eventTime := time.Unix(int64(event.CreatedAt), 0)
-	now := time.Now()
-	if eventTime.Before(now.Add(-1 * time.Hour)) {
-		logging.Warn("server.renoter.ProcessEvent: Event %s is too old (created at %v, more than 1 hour ago)", event.ID, eventTime)
-		return fmt.Errorf("event %s is too old (created more than 1 hour ago)", event.ID)
+// finalRelayURLs returns the relays final events are published to.
+func (r *Renoter) finalRelayURLs() []string {
+	if len(r.publishPolicy.FinalRelays) > 0 {
+		return r.publishPolicy.FinalRelays
 	}
+	return r.relayURLs
+}
 
-	// Check for replay attacks using the event cache
-	if r.eventCache.CheckAndMark(event.ID, now) {
-		return fmt.Errorf("event %s already processed (replay attack)", event.ID)
+// subscriptionFilter returns the filter for 29001 containers addressed to this Renoter, or for
+// every 29001 container when those addressed to other Renoters are republished.
+func (r *Renoter) subscriptionFilter() nostr.Filter {
+	filter := nostr.Filter{
+		Kinds: []int{config.StandardizedWrapperKind},
+		Tags: nostr.TagMap{
+			"p": []string{r.PublicKey},
+		},
+		Limit: r.subscription.Limit,
 	}
+	if r.republishesMisaddressed() {
+		filter.Tags = nil
+	}
+	if r.subscription.SinceStartup {
+		since := nostr.Timestamp(r.startedAt.Unix())
+		filter.Since = &since
+	}
+	return filter
+}
+
+// listenRelayURLs returns the relays to subscribe on: the allowlist if set, otherwise all relays.
+func (r *Renoter) listenRelayURLs() []string {
+	if len(r.subscription.ListenRelays) > 0 {
+		return r.subscription.ListenRelays
+	}
+	return r.relayURLs
+}
 
-	logging.DebugMethod("server.renoter", "ProcessEvent", "Atomically checked and marked event %s as seen in event cache (cache size: %d)", event.ID, r.eventCache.Size())
+// acceptsRelayEvent reports whether an event delivered by a relay matches the subscription,
+// catching relays that ignore since or deliver events on relays outside the allowlist.
+func (r *Renoter) acceptsRelayEvent(relayEvent nostr.RelayEvent, filter nostr.Filter) bool {
+	if relayEvent.Event == nil || !filter.Matches(relayEvent.Event) {
+		return false
+	}
+	if len(r.subscription.ListenRelays) > 0 && relayEvent.Relay != nil && !slices.Contains(r.subscription.ListenRelays, nostr.NormalizeURL(relayEvent.Relay.URL)) {
+		return false
+	}
+	return true
+}
+
+// Descriptor returns the service descriptor for this Renoter's current configuration.
+func (r *Renoter) Descriptor(contact string) descriptor.Descriptor {
+	d := descriptor.Descriptor{
+		PubKey:                 r.PublicKey,
+		Kinds:                  []int{config.WrapperEventKind, config.StandardizedWrapperKind},
+		StandardizedSize:       r.standardizedSize,
+		ContainerPoWDifficulty: r.containerPoWDifficulty,
+		FeePolicy:              descriptor.FeePolicyFree,
+		Contact:                contact,
+		Region:                 r.region,
+		ASN:                    r.asn,
+		Status:                 descriptor.StatusOnline,
+		Version:                config.ProtocolVersion,
+		Heartbeat:              r.heartbeat,
+		Attribution:            r.attribution,
+	}
+	if sizes := r.sizeLimits().Sizes(); len(sizes) > 1 {
+		d.Buckets = sizes[:len(sizes)-1]
+	}
+	if r.lite {
+		d.Profiles = []string{descriptor.ProfileLite}
+	}
+	if r.mixing.Max > 0 {
+		d.MixingMin, d.MixingMax, d.MixingDistribution = r.mixing.Min, r.mixing.Max, r.mixing.Distribution
+	}
+	for _, admission := range r.admissionStrategies() {
+		d.Admission = append(d.Admission, admission.Name())
+		switch a := admission.(type) {
+		case *PoWAdmission:
+			d.PoWDifficulty = a.Difficulty
+		case *CashuAdmission:
+			d.CashuMints = a.policy.Mints
+			d.CashuAmount = a.policy.Amount
+		}
+	}
+	if r.payment != nil {
+		d.FeePolicy = descriptor.FeePolicyLightning
+		d.FeeMsats = r.payment.policy.FeeMsats
+		d.LightningAddress = r.payment.policy.LightningAddress
+		d.FreeQuota = r.payment.policy.FreeQuota
+	}
+	return d
+}
 
-	// Verify signature
-	logging.DebugMethod("server.renoter", "ProcessEvent", "Verifying signature for event %s", event.ID)
+// SetLocation sets the region and network provider (ASN) announced in the service descriptor,
+// so clients can avoid putting two hops in the same place. Either may be left empty or 0.
+func (r *Renoter) SetLocation(region string, asn uint32) {
+	r.region = region
+	r.asn = asn
+}
+
+// PublishDescriptor signs and publishes this Renoter's service descriptor to all of its relays,
+// so clients can check compatibility before including it in a path.
+func (r *Renoter) PublishDescriptor(ctx context.Context, contact string) error {
+	return r.publishDescriptor(ctx, r.Descriptor(contact))
+}
+
+// AnnouncePeriodically publishes the service descriptor now and then every interval until ctx is
+// done, announcing the interval as a heartbeat: clients treat a Renoter that misses several
+// heartbeats as offline and route around it. Only the first publication's error is returned;
+// republishing continues either way.
+func (r *Renoter) AnnouncePeriodically(ctx context.Context, contact string, interval time.Duration) error {
+	if interval < time.Second {
+		return fmt.Errorf("announce interval must be at least a second, got %v", interval)
+	}
+	r.heartbeat = interval
+	err := r.PublishDescriptor(ctx, contact)
+	r.life.run(ctx, func(ctx context.Context) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := r.PublishDescriptor(ctx, contact); err != nil {
+					logging.Warn("server.renoter.AnnouncePeriodically: failed to republish descriptor: %v", err)
+				}
+			}
+		}
+	})
+	return err
+}
+
+// AnnounceOffline replaces the service descriptor (and the heartbeat, with gossip on) with one
+// marked offline, so clients stop drawing this Renoter into paths until it announces itself
+// again. Call it when shutting down.
+func (r *Renoter) AnnounceOffline(ctx context.Context, contact string) error {
+	d := r.Descriptor(contact)
+	d.Status = descriptor.StatusOffline
+	if r.gossip != nil {
+		if err := r.publishHeartbeat(ctx, descriptor.StatusOffline); err != nil {
+			logging.Warn("server.renoter.AnnounceOffline: failed to publish offline heartbeat: %v", err)
+		}
+	}
+	return r.publishDescriptor(ctx, d)
+}
+
+// publishDescriptor signs d and publishes it to all of this Renoter's relays.
+func (r *Renoter) publishDescriptor(ctx context.Context, d descriptor.Descriptor) error {
+	event := d.Event()
+	if err := r.identity.SignEvent(ctx, &event); err != nil {
+		logging.Error("server.renoter.PublishDescriptor: failed to sign descriptor: %v", err)
+		return fmt.Errorf("failed to sign descriptor: %w", err)
+	}
+
+	successCount := 0
+	for result := range r.forwarder.PublishMany(ctx, r.relayURLs, event) {
+		if result.Error != nil {
+			logging.Warn("server.renoter.PublishDescriptor: failed to publish descriptor to %s: %v", result.RelayURL, result.Error)
+			continue
+		}
+		successCount++
+	}
+	if successCount == 0 {
+		logging.Error("server.renoter.PublishDescriptor: no relay accepted the service descriptor")
+		return fmt.Errorf("no relay accepted the service descriptor")
+	}
+
+	logging.Info("server.renoter.PublishDescriptor: Published %s service descriptor %s to %d/%d relays", d.Status, event.ID, successCount, len(r.relayURLs))
+	return nil
+}
+
+// GetRelayURLs returns the list of relay URLs used by this Renoter.
+func (r *Renoter) GetRelayURLs() []string {
+	return r.relayURLs
+}
+
+// ProcessEvent runs the checks every incoming 29001 container is accepted with: the pipeline
+// stages before StageRecipient (signature, age, replay and quota by default).
+// An accepted container stays provisionally marked as seen until HandleEvent, which runs the
+// remaining stages, settles the mark.
+func (r *Renoter) ProcessEvent(ctx context.Context, event *nostr.Event) error {
+	msg := &Message{Container: event, ReceivedAt: time.Now()}
+	r.startTrace(msg)
+	err := r.pipeline.run(ctx, msg, "", StageRecipient)
+	if err != nil {
+		// Accepted containers are counted and traced by HandleEvent
+		r.finish(ctx, msg, err)
+	}
+	return err
+}
+
+// checkSignature is StageSignature: it verifies the container's signature.
+func (r *Renoter) checkSignature(ctx context.Context, msg *Message) error {
+	event := msg.Container
+	logging.DebugMethod("server.renoter", "checkSignature", "Verifying signature for event %s", event.ID)
 	valid, err := event.CheckSignature()
 	if err != nil {
-		logging.Error("server.renoter.ProcessEvent: signature check failed for event %s: %v", event.ID, err)
+		logging.Error("server.renoter.checkSignature: signature check failed for event %s: %v", event.ID, err)
 		return fmt.Errorf("signature check failed: %w", err)
 	}
 	if !valid {
-		logging.Error("server.renoter.ProcessEvent: invalid signature for event %s", event.ID)
+		logging.Error("server.renoter.checkSignature: invalid signature for event %s", event.ID)
 		return fmt.Errorf("invalid signature for event %s", event.ID)
 	}
-	logging.DebugMethod("server.renoter", "ProcessEvent", "Signature verified successfully for event %s", event.ID)
+	return nil
+}
 
-	// Process will be handled by handler.go
+// checkReplay is StageReplay: it rejects containers already seen, using the event cache. The
+// container is only provisionally marked as seen until processing ends (see settleReplay).
+func (r *Renoter) checkReplay(ctx context.Context, msg *Message) error {
+	event := msg.Container
+	if r.eventCache.Reserve(event.ID, msg.ReceivedAt) {
+		return fmt.Errorf("event %s already processed (replay attack)", event.ID)
+	}
+	msg.reserved = true
+	logging.DebugMethod("server.renoter", "checkReplay", "Atomically checked and reserved event %s in event cache (cache size: %d)", event.ID, r.eventCache.Size())
 	return nil
 }
 
+// checkQuota is StageQuota: it accounts the container against the quotas of its (outer)
+// submitting pubkey. Containers addressed to other Renoters, only received when they are
+// republished, are not charged.
+func (r *Renoter) checkQuota(ctx context.Context, msg *Message) error {
+	if r.republishesMisaddressed() && !r.addressedToUs(msg.Container) {
+		return nil
+	}
+	return r.chargeQuota(msg.Container.PubKey, int64(len(msg.Container.String())), msg.ReceivedAt)
+}
+
 // GetPublicKey returns this Renoter's public key.
 func (r *Renoter) GetPublicKey() string {
 	return r.PublicKey
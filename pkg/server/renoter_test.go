@@ -2,10 +2,19 @@ package server
 
 import (
 	"context"
+	"errors"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/fiatjaf/khatru"
+	"github.com/girino/renoter/internal/config"
+	"github.com/girino/renoter/internal/descriptor"
+	"github.com/girino/renoter/internal/relaypool"
 	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip11"
 )
 
 func TestNewRenoter(t *testing.T) {
@@ -76,6 +85,9 @@ func TestNewRenoter(t *testing.T) {
 				if pool == nil {
 					t.Error("GetPool() should return non-nil pool")
 				}
+				if renoter.GetPublisher() == nil {
+					t.Error("GetPublisher() should return non-nil publisher")
+				}
 				// Test GetPublicKey
 				pubkey := renoter.GetPublicKey()
 				if pubkey != renoter.PublicKey {
@@ -164,6 +176,142 @@ func TestRenoter_GetPublicKey(t *testing.T) {
 	}
 }
 
+func TestRenoter_SetSizeLimits(t *testing.T) {
+	renoter := &Renoter{standardizedSize: config.StandardizedSize}
+
+	if err := renoter.SetSizeLimits(config.SizeLimits{StandardizedSize: 8192, MaxInnerEventSize: 8192}); err == nil {
+		t.Error("SetSizeLimits() should reject limits without room for the padding tag")
+	}
+	if renoter.standardizedSize != config.StandardizedSize {
+		t.Errorf("standardizedSize = %d after rejected limits, want unchanged %d", renoter.standardizedSize, config.StandardizedSize)
+	}
+
+	if err := renoter.SetSizeLimits(config.SizeLimits{StandardizedSize: 8192, MaxInnerEventSize: 8000}); err != nil {
+		t.Fatalf("SetSizeLimits() error = %v", err)
+	}
+	if renoter.standardizedSize != 8192 {
+		t.Errorf("standardizedSize = %d, want 8192", renoter.standardizedSize)
+	}
+}
+
+func TestRenoter_ContainerPoW(t *testing.T) {
+	// A relay that demands PoW on every incoming event
+	relay := khatru.NewRelay()
+	relay.Info.Limitation = &nip11.RelayLimitationDocument{MinPowDifficulty: 12}
+	httpServer := httptest.NewServer(relay)
+	defer httpServer.Close()
+
+	renoter := &Renoter{relayURLs: []string{"ws" + strings.TrimPrefix(httpServer.URL, "http")}}
+
+	if err := renoter.SetContainerPoWDifficulty(-1); err == nil {
+		t.Error("SetContainerPoWDifficulty() should reject negative difficulty")
+	}
+	if err := renoter.SetContainerPoWDifficulty(4); err != nil {
+		t.Fatalf("SetContainerPoWDifficulty() error = %v", err)
+	}
+	if got := renoter.DetectContainerPoW(context.Background()); got != 12 {
+		t.Errorf("DetectContainerPoW() = %d, want 12", got)
+	}
+
+	// A configured difficulty above what the relays ask for is kept
+	if err := renoter.SetContainerPoWDifficulty(16); err != nil {
+		t.Fatalf("SetContainerPoWDifficulty() error = %v", err)
+	}
+	if got := renoter.DetectContainerPoW(context.Background()); got != 16 {
+		t.Errorf("DetectContainerPoW() = %d, want 16", got)
+	}
+}
+
+func TestRenoter_SubscriptionOptions(t *testing.T) {
+	startedAt := time.Now().Add(-time.Minute)
+	renoter := &Renoter{
+		PublicKey: strings.Repeat("a", 64),
+		startedAt: startedAt,
+		relayURLs: []string{"wss://relay1.example.com", "wss://relay2.example.com"},
+	}
+
+	// Without options the filter is kind+p on all relays
+	filter := renoter.subscriptionFilter()
+	if filter.Since != nil || filter.Limit != 0 {
+		t.Errorf("default filter should not set since or limit, got since=%v limit=%d", filter.Since, filter.Limit)
+	}
+	if len(renoter.listenRelayURLs()) != 2 {
+		t.Errorf("listenRelayURLs() = %v, want all relays", renoter.listenRelayURLs())
+	}
+
+	if err := renoter.SetSubscriptionOptions(SubscriptionOptions{Limit: -1}); err == nil {
+		t.Error("SetSubscriptionOptions() should reject a negative limit")
+	}
+	if err := renoter.SetSubscriptionOptions(SubscriptionOptions{ListenRelays: []string{"wss://other.example.com"}}); err == nil {
+		t.Error("SetSubscriptionOptions() should reject listen relays outside the Renoter's relays")
+	}
+
+	err := renoter.SetSubscriptionOptions(SubscriptionOptions{
+		SinceStartup: true,
+		Limit:        50,
+		ListenRelays: []string{"relay2.example.com"},
+	})
+	if err != nil {
+		t.Fatalf("SetSubscriptionOptions() error = %v", err)
+	}
+	filter = renoter.subscriptionFilter()
+	if filter.Since == nil || *filter.Since != nostr.Timestamp(startedAt.Unix()) {
+		t.Errorf("filter.Since = %v, want startup time %d", filter.Since, startedAt.Unix())
+	}
+	if filter.Limit != 50 {
+		t.Errorf("filter.Limit = %d, want 50", filter.Limit)
+	}
+	if got := renoter.listenRelayURLs(); len(got) != 1 || got[0] != "wss://relay2.example.com" {
+		t.Errorf("listenRelayURLs() = %v, want [wss://relay2.example.com]", got)
+	}
+
+	// Events that slip past a relay ignoring the filter are dropped locally
+	container := &nostr.Event{
+		Kind:      config.StandardizedWrapperKind,
+		CreatedAt: nostr.Now(),
+		Tags:      nostr.Tags{{"p", renoter.PublicKey}},
+	}
+	allowed := &nostr.Relay{URL: "wss://relay2.example.com"}
+	if !renoter.acceptsRelayEvent(nostr.RelayEvent{Event: container, Relay: allowed}, filter) {
+		t.Error("acceptsRelayEvent() should accept a fresh container from an allowed relay")
+	}
+	if renoter.acceptsRelayEvent(nostr.RelayEvent{Event: container, Relay: &nostr.Relay{URL: "wss://relay1.example.com"}}, filter) {
+		t.Error("acceptsRelayEvent() should drop containers from relays outside the allowlist")
+	}
+	stale := *container
+	stale.CreatedAt = nostr.Timestamp(startedAt.Add(-time.Minute).Unix())
+	if renoter.acceptsRelayEvent(nostr.RelayEvent{Event: &stale, Relay: allowed}, filter) {
+		t.Error("acceptsRelayEvent() should drop containers created before startup")
+	}
+	wrongKind := *container
+	wrongKind.Kind = 1
+	if renoter.acceptsRelayEvent(nostr.RelayEvent{Event: &wrongKind, Relay: allowed}, filter) {
+		t.Error("acceptsRelayEvent() should drop events of other kinds")
+	}
+}
+
+func TestRenoter_Descriptor(t *testing.T) {
+	renoter := newOfflineRenoter(t)
+	renoter.powDifficulty = config.PoWDifficulty
+	renoter.containerPoWDifficulty = 12
+	renoter.SetLocation("NL", 60781)
+
+	event := renoter.Descriptor("ops@example.com").Event()
+	if err := event.Sign(renoter.PrivateKey); err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+	d, err := descriptor.Parse(&event)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if d.PubKey != renoter.PublicKey || d.ContainerPoWDifficulty != 12 || d.Contact != "ops@example.com" || d.Region != "NL" || d.ASN != 60781 {
+		t.Errorf("descriptor = %+v", d)
+	}
+	if err := d.CheckCompatible(config.DefaultSizeLimits(), config.PoWDifficulty, false, false); err != nil {
+		t.Errorf("a default Renoter should be compatible with a default client: %v", err)
+	}
+}
+
 func TestRenoter_ProcessEvent_ReplayDetection(t *testing.T) {
 	ctx := context.Background()
 
@@ -205,3 +353,207 @@ func TestRenoter_ProcessEvent_ReplayDetection(t *testing.T) {
 		t.Log("Replay detection working correctly")
 	}
 }
+
+func TestRenoter_Handle_RetriesAfterFailure(t *testing.T) {
+	ctx := context.Background()
+	renoter := newOfflineRenoter(t)
+
+	// A stage standing in for a transient failure (e.g. no relay reachable) on the first attempt
+	attempts := 0
+	err := renoter.Pipeline().InsertBefore(StageForward, Stage{
+		Name: "flaky",
+		Run: func(ctx context.Context, msg *Message) error {
+			attempts++
+			if attempts == 1 {
+				return errors.New("transient failure")
+			}
+			return ErrDrop
+		},
+	})
+	if err != nil {
+		t.Fatalf("InsertBefore() error = %v", err)
+	}
+
+	event := &nostr.Event{Kind: 1, Content: "retried", CreatedAt: nostr.Now(), Tags: nostr.Tags{}}
+	event.Sign(nostr.GeneratePrivateKey())
+	container := wrapForRenoters(t, event, []*Renoter{renoter})
+
+	if err := renoter.Handle(ctx, container); err == nil {
+		t.Fatal("Handle() should report the transient failure")
+	}
+	if err := renoter.Handle(ctx, container); err != nil {
+		t.Fatalf("Handle() of a copy delivered again error = %v, want it retried", err)
+	}
+	if err := renoter.Handle(ctx, container); err == nil || !contains(err.Error(), "replay") {
+		t.Errorf("Handle() after success error = %v, want a replay error", err)
+	}
+
+	// Garbage rejected before the replay stage never reaches the cache
+	garbage := *container
+	garbage.Content += "x"
+	garbage.ID = garbage.GetID()
+	if err := renoter.Handle(ctx, &garbage); err == nil {
+		t.Fatal("Handle() should reject a container with an invalid signature")
+	}
+	if renoter.eventCache.Reserve(garbage.ID, time.Now()) {
+		t.Error("container with an invalid signature was marked as seen")
+	}
+}
+
+func TestNewRenoterWithPool_NoPublisher(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pool := &fakePool{published: make(chan nostr.Event, 1)}
+	renoter, err := NewRenoterWithPool(ctx, nostr.GeneratePrivateKey(), []string{"wss://relay.example.com"}, pool)
+	if err != nil {
+		t.Fatalf("NewRenoterWithPool() error = %v", err)
+	}
+	defer renoter.Close(ctx)
+	if renoter.GetPublisher() != nil {
+		t.Error("GetPublisher() should be nil when forwarding through the given pool")
+	}
+	if renoter.forwarder != pool {
+		t.Error("forwarding should go through the given pool")
+	}
+	if err := renoter.SetPublishPoolOptions(relaypool.Options{MaxConnections: 4}); err != nil {
+		t.Errorf("SetPublishPoolOptions() error = %v", err)
+	}
+	if err := renoter.SetPublishPoolOptions(relaypool.Options{MaxConnections: -1}); err == nil {
+		t.Error("SetPublishPoolOptions() should reject invalid options")
+	}
+}
+
+func TestSetPublishPolicy(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pool := &fakePool{published: make(chan nostr.Event, 10)}
+	renoter, err := NewRenoterWithPool(ctx, nostr.GeneratePrivateKey(), []string{"wss://private.example.com", "wss://public.example.com"}, pool)
+	if err != nil {
+		t.Fatalf("NewRenoterWithPool() error = %v", err)
+	}
+	if len(renoter.forwardRelayURLs()) != 2 || len(renoter.finalRelayURLs()) != 2 {
+		t.Errorf("without a policy every output goes to all relays, got %v and %v", renoter.forwardRelayURLs(), renoter.finalRelayURLs())
+	}
+	if err := renoter.SetPublishPolicy(PublishPolicy{FinalRelays: []string{"wss://other.example.com"}}); err == nil {
+		t.Error("SetPublishPolicy() should reject relays outside the Renoter's relays")
+	}
+
+	err = renoter.SetPublishPolicy(PublishPolicy{ForwardRelays: []string{"private.example.com"}, FinalRelays: []string{"wss://public.example.com"}})
+	if err != nil {
+		t.Fatalf("SetPublishPolicy() error = %v", err)
+	}
+	if got := renoter.forwardRelayURLs(); len(got) != 1 || got[0] != "wss://private.example.com" {
+		t.Errorf("forwardRelayURLs() = %v, want [wss://private.example.com]", got)
+	}
+	if got := renoter.finalRelayURLs(); len(got) != 1 || got[0] != "wss://public.example.com" {
+		t.Errorf("finalRelayURLs() = %v, want [wss://public.example.com]", got)
+	}
+
+	// Final events are published to the final relays only
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	renoter.SetAuditLog(path, 0, 0)
+	event := &nostr.Event{Kind: 1, Content: "public note", CreatedAt: nostr.Now(), Tags: nostr.Tags{}}
+	event.Sign(nostr.GeneratePrivateKey())
+	if err := renoter.publishFinal(ctx, event); err != nil {
+		t.Fatalf("publishFinal() error = %v", err)
+	}
+	if entries, _ := LookupAudit(path, event.ID, 0); len(entries) != 1 || entries[0].Relays != 1 {
+		t.Errorf("final event published to %+v, want 1 relay", entries)
+	}
+}
+
+// fakePool feeds subscriptions from a channel and records published events.
+type fakePool struct {
+	events    chan nostr.RelayEvent
+	published chan nostr.Event
+}
+
+func (p *fakePool) SubscribeMany(ctx context.Context, urls []string, filter nostr.Filter, opts ...nostr.SubscriptionOption) chan nostr.RelayEvent {
+	return p.events
+}
+
+func (p *fakePool) FetchMany(ctx context.Context, urls []string, filter nostr.Filter, opts ...nostr.SubscriptionOption) chan nostr.RelayEvent {
+	ch := make(chan nostr.RelayEvent)
+	close(ch)
+	return ch
+}
+
+func (p *fakePool) PublishMany(ctx context.Context, urls []string, evt nostr.Event) chan nostr.PublishResult {
+	p.published <- evt
+	ch := make(chan nostr.PublishResult, len(urls))
+	for _, url := range urls {
+		ch <- nostr.PublishResult{RelayURL: url}
+	}
+	close(ch)
+	return ch
+}
+
+func TestNewRenoterWithPool(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if _, err := NewRenoterWithPool(ctx, nostr.GeneratePrivateKey(), []string{"wss://relay.example.com"}, nil); err == nil {
+		t.Error("NewRenoterWithPool() with a nil pool should fail")
+	}
+
+	pool := &fakePool{events: make(chan nostr.RelayEvent, 1), published: make(chan nostr.Event, 1)}
+	renoter, err := NewRenoterWithPool(ctx, nostr.GeneratePrivateKey(), []string{"wss://relay.example.com"}, pool)
+	if err != nil {
+		t.Fatalf("NewRenoterWithPool() error = %v", err)
+	}
+	if renoter.GetPool() != Pool(pool) {
+		t.Error("GetPool() should return the injected pool")
+	}
+	if err := renoter.SubscribeToWrappedEvents(ctx); err != nil {
+		t.Fatalf("SubscribeToWrappedEvents() error = %v", err)
+	}
+
+	event := &nostr.Event{Kind: 1, CreatedAt: nostr.Now(), Tags: nostr.Tags{}, Content: "through a fake pool"}
+	event.Sign(nostr.GeneratePrivateKey())
+	pool.events <- nostr.RelayEvent{Event: wrapForRenoters(t, event, []*Renoter{renoter})}
+
+	select {
+	case published := <-pool.published:
+		if published.ID != event.ID {
+			t.Errorf("published event %s, want the final event %s", published.ID, event.ID)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the final event was not published through the injected pool")
+	}
+}
+
+func TestAnnounce_HeartbeatAndOffline(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	pool := &fakePool{events: make(chan nostr.RelayEvent), published: make(chan nostr.Event, 4)}
+	renoter, err := NewRenoterWithPool(ctx, nostr.GeneratePrivateKey(), []string{"wss://relay.example.com"}, pool)
+	if err != nil {
+		t.Fatalf("NewRenoterWithPool() error = %v", err)
+	}
+	if err := renoter.AnnouncePeriodically(ctx, "", time.Millisecond); err == nil {
+		t.Error("AnnouncePeriodically() should refuse sub-second intervals")
+	}
+
+	if err := renoter.AnnouncePeriodically(ctx, "", time.Hour); err != nil {
+		t.Fatalf("AnnouncePeriodically() error = %v", err)
+	}
+	event := <-pool.published
+	d, err := descriptor.Parse(&event)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if d.Status != descriptor.StatusOnline || d.Heartbeat != time.Hour {
+		t.Errorf("announced status %q, heartbeat %v, want online every hour", d.Status, d.Heartbeat)
+	}
+
+	if err := renoter.AnnounceOffline(ctx, ""); err != nil {
+		t.Fatalf("AnnounceOffline() error = %v", err)
+	}
+	event = <-pool.published
+	if d, _ := descriptor.Parse(&event); d == nil || d.Status != descriptor.StatusOffline {
+		t.Errorf("AnnounceOffline() published %v, want an offline descriptor", event.Tags)
+	}
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/girino/nostr-lib/logging"
+)
+
+// ReplayCachePolicy sizes the cache of processed container IDs replays are detected with.
+type ReplayCachePolicy struct {
+	// Most container IDs remembered; the oldest are pruned beyond that
+	MaxSize int
+	// Fraction of MaxSize pruned, oldest first, when the cache is full (0 < PruneFraction <= 1)
+	PruneFraction float64
+	// How long container IDs are remembered; must cover the age policy's MaxAge plus MaxFuture
+	TTL time.Duration
+}
+
+// DefaultReplayCachePolicy returns the policy of a new Renoter.
+func DefaultReplayCachePolicy() ReplayCachePolicy {
+	return ReplayCachePolicy{MaxSize: 5000, PruneFraction: defaultPruneFraction, TTL: replayWindow}
+}
+
+// Validate checks that the policy is usable.
+func (p ReplayCachePolicy) Validate() error {
+	if p.MaxSize < 1 {
+		return fmt.Errorf("max size must be at least 1, got %d", p.MaxSize)
+	}
+	if p.PruneFraction <= 0 || p.PruneFraction > 1 {
+		return fmt.Errorf("prune fraction must be above 0 and at most 1, got %v", p.PruneFraction)
+	}
+	if p.TTL <= 0 {
+		return fmt.Errorf("TTL must be positive, got %v", p.TTL)
+	}
+	return nil
+}
+
+// checkReplayWindow rejects an age policy accepting containers for longer than a replay cache
+// with ttl remembers them, as a forgotten container could then be replayed.
+func checkReplayWindow(age AgePolicy, ttl time.Duration) error {
+	if age.MaxAge+age.MaxFuture > ttl {
+		return fmt.Errorf("max age plus max future (%v) exceeds the %v containers are remembered for replays", age.MaxAge+age.MaxFuture, ttl)
+	}
+	return nil
+}
+
+// SetReplayCachePolicy resizes the replay cache in place, keeping the IDs it remembers. Its TTL
+// must cover the age policy, so shrink the age policy first when shrinking both.
+func (r *Renoter) SetReplayCachePolicy(policy ReplayCachePolicy) error {
+	err := policy.Validate()
+	if err == nil {
+		err = checkReplayWindow(r.agePolicy(), policy.TTL)
+	}
+	if err != nil {
+		logging.Error("server.replaycache.SetReplayCachePolicy: invalid policy: %v", err)
+		return fmt.Errorf("invalid replay cache policy: %w", err)
+	}
+	r.eventCache.SetLimits(policy.MaxSize, policy.PruneFraction)
+	r.eventCache.SetCutoff(policy.TTL)
+	r.replayCache = policy
+	logging.Info("server.replaycache.SetReplayCachePolicy: Remembering up to %d container IDs for %v, pruning %.0f%% when full", policy.MaxSize, policy.TTL, 100*policy.PruneFraction)
+	return nil
+}
+
+// ReplayCachePolicy returns the policy the replay cache is sized with.
+func (r *Renoter) ReplayCachePolicy() ReplayCachePolicy {
+	if r.replayCache.MaxSize == 0 {
+		return DefaultReplayCachePolicy()
+	}
+	return r.replayCache
+}
+
+// ReplayCacheStats is the current state of the replay cache.
+type ReplayCacheStats struct {
+	ReplayCachePolicy
+	// Container IDs remembered, expired ones not yet cleaned up included
+	Size int
+}
+
+// ReplayCacheStats returns the replay cache's policy and current size.
+func (r *Renoter) ReplayCacheStats() ReplayCacheStats {
+	return ReplayCacheStats{ReplayCachePolicy: r.ReplayCachePolicy(), Size: r.eventCache.Size()}
+}
+
+// WriteMetrics writes the stats in the Prometheus text format, as gauges.
+func (s ReplayCacheStats) WriteMetrics(w io.Writer) {
+	fmt.Fprintf(w, "# HELP renoter_replay_cache_entries Container IDs in the replay cache.\n")
+	fmt.Fprintf(w, "# TYPE renoter_replay_cache_entries gauge\n")
+	fmt.Fprintf(w, "renoter_replay_cache_entries %d\n", s.Size)
+	fmt.Fprintf(w, "# HELP renoter_replay_cache_max_entries Most container IDs the replay cache holds.\n")
+	fmt.Fprintf(w, "# TYPE renoter_replay_cache_max_entries gauge\n")
+	fmt.Fprintf(w, "renoter_replay_cache_max_entries %d\n", s.MaxSize)
+	fmt.Fprintf(w, "# HELP renoter_replay_cache_ttl_seconds How long the replay cache remembers container IDs.\n")
+	fmt.Fprintf(w, "# TYPE renoter_replay_cache_ttl_seconds gauge\n")
+	fmt.Fprintf(w, "renoter_replay_cache_ttl_seconds %g\n", s.TTL.Seconds())
+}
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReplayCachePolicy_Validate(t *testing.T) {
+	if err := DefaultReplayCachePolicy().Validate(); err != nil {
+		t.Errorf("DefaultReplayCachePolicy().Validate() error = %v", err)
+	}
+	for _, policy := range []ReplayCachePolicy{
+		{MaxSize: 0, PruneFraction: 0.25, TTL: time.Hour},
+		{MaxSize: 100, PruneFraction: 0, TTL: time.Hour},
+		{MaxSize: 100, PruneFraction: 1.5, TTL: time.Hour},
+		{MaxSize: 100, PruneFraction: 0.25, TTL: 0},
+	} {
+		if err := policy.Validate(); err == nil {
+			t.Errorf("Validate(%+v) should fail", policy)
+		}
+	}
+}
+
+func TestRenoter_SetReplayCachePolicy(t *testing.T) {
+	renoter := newOfflineRenoter(t)
+	now := time.Now()
+	for i := 0; i < 100; i++ {
+		renoter.eventCache.CheckAndMark(fmt.Sprintf("event-%d", i), now)
+	}
+
+	// Shrinking the cache prunes it right away, keeping the newest IDs
+	policy := ReplayCachePolicy{MaxSize: 20, PruneFraction: 0.5, TTL: 3 * time.Hour}
+	if err := renoter.SetReplayCachePolicy(policy); err != nil {
+		t.Fatalf("SetReplayCachePolicy() error = %v", err)
+	}
+	stats := renoter.ReplayCacheStats()
+	if stats.ReplayCachePolicy != policy || stats.Size > 20 || !renoter.eventCache.Contains("event-99") {
+		t.Errorf("ReplayCacheStats() = %+v, want the new policy and at most 20 entries", stats)
+	}
+
+	// A longer TTL allows older containers, a shorter one must still cover them
+	if err := renoter.SetAgePolicy(AgePolicy{MaxAge: 2 * time.Hour, MaxFuture: time.Minute}); err != nil {
+		t.Errorf("SetAgePolicy() within a 3h TTL error = %v", err)
+	}
+	policy.TTL = time.Hour
+	if err := renoter.SetReplayCachePolicy(policy); err == nil {
+		t.Error("SetReplayCachePolicy() with a TTL shorter than the age policy should fail")
+	}
+
+	var b strings.Builder
+	renoter.ReplayCacheStats().WriteMetrics(&b)
+	for _, want := range []string{"renoter_replay_cache_entries ", "renoter_replay_cache_max_entries 20", "renoter_replay_cache_ttl_seconds 10800"} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("WriteMetrics() output lacks %q:\n%s", want, b.String())
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/girino/renoter/internal/config"
+	"github.com/girino/renoter/internal/stamp"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// RoleRejectionPrefix starts the rejections of role policies other than their hourly quota,
+// following NIP-01's machine-readable prefixes.
+const RoleRejectionPrefix = config.PrefixBlocked + ":"
+
+// HopRole is the part a Renoter plays for one container, inferred from the event inside its layer.
+type HopRole string
+
+const (
+	// The layer holds another 29000 to forward. Middle hops look exactly like the first hop by
+	// design, so every forwarding hop is treated as the entry.
+	RoleEntry HopRole = "entry"
+	// The layer holds the final event to publish
+	RoleExit HopRole = "exit"
+)
+
+// RoleOf returns the role a Renoter plays for a layer holding inner.
+func RoleOf(inner *nostr.Event) HopRole {
+	if inner.Kind == config.WrapperEventKind {
+		return RoleEntry
+	}
+	return RoleExit
+}
+
+// RolePolicy is the set of rules applied to the containers a Renoter handles in one role, on top
+// of those applied to every container. Zero disables a rule.
+type RolePolicy struct {
+	// Minimum committed PoW difficulty of the layer, whatever admission strategy admitted it
+	MinPoW int
+	// Containers handled in this role per hour
+	EventsPerHour int
+
+	// The rules below look at the final event, so they only apply to the exit
+
+	// Kinds of final events published (nil = all)
+	AllowedKinds map[int]bool
+	// Largest final event content in bytes
+	MaxContentSize int
+	// Most pubkeys a final event may mention ("p" tags), capping who it is delivered to
+	MaxMentions int
+}
+
+// Validate checks that the policy is usable in role.
+func (p RolePolicy) Validate(role HopRole) error {
+	if p.MinPoW < 0 || p.EventsPerHour < 0 || p.MaxContentSize < 0 || p.MaxMentions < 0 {
+		return fmt.Errorf("%s limits must not be negative", role)
+	}
+	if role != RoleExit && (p.AllowedKinds != nil || p.MaxContentSize > 0 || p.MaxMentions > 0) {
+		return fmt.Errorf("kind, content and mention limits only apply to the exit")
+	}
+	return nil
+}
+
+// RolePolicies holds the policy of each role.
+type RolePolicies struct {
+	Entry RolePolicy
+	Exit  RolePolicy
+}
+
+// roleGate enforces the role policies, counting containers per role.
+type roleGate struct {
+	policies RolePolicies
+
+	mu    sync.Mutex
+	usage map[HopRole]*quotaUsage
+}
+
+// SetRolePolicies applies different rules to the containers this Renoter forwards as entry and
+// those whose final event it publishes as exit. The role is known once the layer is decrypted.
+func (r *Renoter) SetRolePolicies(policies RolePolicies) error {
+	for _, set := range []struct {
+		role   HopRole
+		policy RolePolicy
+	}{{RoleEntry, policies.Entry}, {RoleExit, policies.Exit}} {
+		if err := set.policy.Validate(set.role); err != nil {
+			logging.Error("server.roles.SetRolePolicies: invalid %s policy: %v", set.role, err)
+			return fmt.Errorf("invalid %s policy: %w", set.role, err)
+		}
+	}
+	r.roles = &roleGate{policies: policies, usage: make(map[HopRole]*quotaUsage)}
+	logging.Info("server.roles.SetRolePolicies: Entry: PoW %d, %d events/hour; exit: PoW %d, %d events/hour, %d kinds allowed (0 = all), content up to %d bytes, %d mentions",
+		policies.Entry.MinPoW, policies.Entry.EventsPerHour, policies.Exit.MinPoW, policies.Exit.EventsPerHour, len(policies.Exit.AllowedKinds), policies.Exit.MaxContentSize, policies.Exit.MaxMentions)
+	return nil
+}
+
+// checkRole is StageRole: it infers the role from the decrypted layer and applies its policy.
+// Over-quota containers get a *QuotaError scoped to the role.
+func (r *Renoter) checkRole(ctx context.Context, msg *Message) error {
+	msg.Role = RoleOf(msg.Inner)
+	if r.roles == nil {
+		return nil
+	}
+	if err := r.roles.admit(msg.Role, msg.Layer, msg.Inner, time.Now()); err != nil {
+		logging.Warn("server.roles.checkRole: rejecting container %s as %s: %v", msg.Container.ID, msg.Role, err)
+		return err
+	}
+	return nil
+}
+
+// admit applies the policy of role to a layer and the event inside it, counting it if it passes.
+func (g *roleGate) admit(role HopRole, layer, inner *nostr.Event, now time.Time) error {
+	policy := g.policies.Entry
+	if role == RoleExit {
+		policy = g.policies.Exit
+	}
+
+	if committed := stamp.CommittedDifficulty(layer); policy.MinPoW > 0 && committed < policy.MinPoW {
+		return fmt.Errorf("%s committed difficulty %d is less than the %d required at the %s", RoleRejectionPrefix, committed, policy.MinPoW, role)
+	}
+	if role == RoleExit {
+		if policy.AllowedKinds != nil && !policy.AllowedKinds[inner.Kind] {
+			return fmt.Errorf("%s kind %d is not published by this exit", RoleRejectionPrefix, inner.Kind)
+		}
+		if policy.MaxContentSize > 0 && len(inner.Content) > policy.MaxContentSize {
+			return fmt.Errorf("%s content of %d bytes exceeds the %d allowed by this exit", RoleRejectionPrefix, len(inner.Content), policy.MaxContentSize)
+		}
+		if policy.MaxMentions > 0 {
+			if mentions := len(mentionedPubkeys(inner, policy.MaxMentions+1)); mentions > policy.MaxMentions {
+				return fmt.Errorf("%s more than %d mentioned pubkeys", RoleRejectionPrefix, policy.MaxMentions)
+			}
+		}
+	}
+
+	if policy.EventsPerHour == 0 {
+		return nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	usage, ok := g.usage[role]
+	if !ok {
+		usage = &quotaUsage{windowStart: now}
+		g.usage[role] = usage
+	}
+	return usage.charge(string(role), 0, policy.EventsPerHour, 0, now)
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/girino/renoter/internal/config"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestRolePolicy_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		role    HopRole
+		policy  RolePolicy
+		wantErr bool
+	}{
+		{"empty", RoleEntry, RolePolicy{}, false},
+		{"entry limits", RoleEntry, RolePolicy{MinPoW: 20, EventsPerHour: 100}, false},
+		{"exit content policy", RoleExit, RolePolicy{AllowedKinds: map[int]bool{1: true}, MaxContentSize: 1000, MaxMentions: 3}, false},
+		{"negative", RoleExit, RolePolicy{EventsPerHour: -1}, true},
+		{"content policy at entry", RoleEntry, RolePolicy{MaxContentSize: 1000}, true},
+		{"kinds at entry", RoleEntry, RolePolicy{AllowedKinds: map[int]bool{}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.policy.Validate(tt.role); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRoleGate_Admit(t *testing.T) {
+	mention := func(n int) nostr.Tags {
+		var tags nostr.Tags
+		for i := 0; i < n; i++ {
+			pk, _ := nostr.GetPublicKey(nostr.GeneratePrivateKey())
+			tags = append(tags, nostr.Tag{"p", pk})
+		}
+		return tags
+	}
+	layer := &nostr.Event{Kind: config.WrapperEventKind, ID: "0000" + strings.Repeat("f", 60), Tags: nostr.Tags{{"nonce", "1", "16"}}}
+	gate := &roleGate{policies: RolePolicies{
+		Entry: RolePolicy{MinPoW: 20},
+		Exit:  RolePolicy{AllowedKinds: map[int]bool{1: true}, MaxContentSize: 10, MaxMentions: 2},
+	}, usage: make(map[HopRole]*quotaUsage)}
+
+	tests := []struct {
+		name    string
+		inner   *nostr.Event
+		wantErr bool
+	}{
+		{"entry below PoW", &nostr.Event{Kind: config.WrapperEventKind}, true},
+		{"exit", &nostr.Event{Kind: 1, Content: "hello", Tags: mention(2)}, false},
+		{"exit kind not allowed", &nostr.Event{Kind: 7, Content: "+"}, true},
+		{"exit content too large", &nostr.Event{Kind: 1, Content: "hello world"}, true},
+		{"exit too many mentions", &nostr.Event{Kind: 1, Content: "hi", Tags: mention(3)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := gate.admit(RoleOf(tt.inner), layer, tt.inner, time.Now())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("admit() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !strings.HasPrefix(err.Error(), RoleRejectionPrefix) {
+				t.Errorf("admit() error = %v, want prefix %q", err, RoleRejectionPrefix)
+			}
+		})
+	}
+}
+
+func TestRoleGate_EventsPerHour(t *testing.T) {
+	gate := &roleGate{policies: RolePolicies{Entry: RolePolicy{EventsPerHour: 1}, Exit: RolePolicy{EventsPerHour: 2}}, usage: make(map[HopRole]*quotaUsage)}
+	layer := &nostr.Event{Kind: config.WrapperEventKind}
+	forward := &nostr.Event{Kind: config.WrapperEventKind}
+	final := &nostr.Event{Kind: 1}
+	now := time.Now()
+
+	if err := gate.admit(RoleEntry, layer, forward, now); err != nil {
+		t.Fatalf("admit() entry error = %v", err)
+	}
+	var quotaErr *QuotaError
+	if err := gate.admit(RoleEntry, layer, forward, now); !errors.As(err, &quotaErr) || quotaErr.Scope != string(RoleEntry) {
+		t.Errorf("admit() second entry error = %v, want an entry quota error", err)
+	}
+	// The exit quota is counted separately
+	for i := 0; i < 2; i++ {
+		if err := gate.admit(RoleExit, layer, final, now); err != nil {
+			t.Fatalf("admit() exit %d error = %v", i, err)
+		}
+	}
+	if err := gate.admit(RoleExit, layer, final, now.Add(time.Hour)); err != nil {
+		t.Errorf("admit() in the next window error = %v", err)
+	}
+}
+
+func TestUnwrapEvent_RolePolicies(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping role policy test in short mode (mines PoW for every layer)")
+	}
+	event := &nostr.Event{Kind: 7, Content: "+", CreatedAt: nostr.Now(), Tags: nostr.Tags{}}
+	event.Sign(nostr.GeneratePrivateKey())
+
+	renoters := newPathRenoters(t, 2)
+	wrapped := wrapForRenoters(t, event, renoters)
+
+	// The first hop only forwards, so the exit's content policy does not apply to it
+	if err := renoters[0].SetRolePolicies(RolePolicies{Exit: RolePolicy{AllowedKinds: map[int]bool{1: true}}}); err != nil {
+		t.Fatalf("SetRolePolicies() error = %v", err)
+	}
+	if inner, err := renoters[0].unwrapEvent(context.Background(), wrapped); err != nil || inner == nil {
+		t.Fatalf("unwrapEvent() at the entry = %v, %v", inner, err)
+	}
+
+	renoters[0].SetRolePolicies(RolePolicies{Entry: RolePolicy{MinPoW: config.PoWDifficulty + 8}})
+	if _, err := renoters[0].unwrapEvent(context.Background(), wrapped); err == nil || !strings.HasPrefix(err.Error(), RoleRejectionPrefix) {
+		t.Errorf("unwrapEvent() with a stricter entry PoW error = %v, want a rejection", err)
+	}
+
+	// A single-hop path makes the Renoter the exit
+	exit := newPathRenoters(t, 1)
+	exit[0].SetRolePolicies(RolePolicies{Exit: RolePolicy{AllowedKinds: map[int]bool{1: true}}})
+	if _, err := exit[0].unwrapEvent(context.Background(), wrapForRenoters(t, event, exit)); err == nil || !strings.Contains(err.Error(), "kind 7") {
+		t.Errorf("unwrapEvent() of a refused kind at the exit error = %v, want a rejection", err)
+	}
+}
@@ -0,0 +1,205 @@
+package server
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/quick"
+	"time"
+
+	"github.com/girino/renoter/internal/config"
+	"github.com/girino/renoter/pkg/client"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// roundTripCase is a randomly generated signed event and Renoter path length.
+type roundTripCase struct {
+	Event   *nostr.Event
+	PathLen int
+}
+
+// Generate implements quick.Generator so testing/quick can produce random cases.
+func (roundTripCase) Generate(rng *rand.Rand, size int) reflect.Value {
+	kinds := []int{0, 1, 3, 6, 7, 1111, 10002, 30023}
+	runes := []rune("abcXYZ019 \n\t\"\\<>&éß漢字🙂\u2028")
+
+	content := make([]rune, rng.Intn(size*20+1))
+	for i := range content {
+		content[i] = runes[rng.Intn(len(runes))]
+	}
+
+	tags := nostr.Tags{}
+	for i := rng.Intn(5); i > 0; i-- {
+		value := make([]byte, 32)
+		rng.Read(value)
+		tagNames := []string{"e", "p", "t", "client", "subject"}
+		tags = append(tags, nostr.Tag{tagNames[rng.Intn(len(tagNames))], hex.EncodeToString(value)})
+	}
+
+	sk := nostr.GeneratePrivateKey()
+	event := &nostr.Event{
+		Kind:      kinds[rng.Intn(len(kinds))],
+		Content:   string(content),
+		CreatedAt: nostr.Timestamp(time.Now().Unix() - rng.Int63n(3600)),
+		Tags:      tags,
+	}
+	event.Sign(sk)
+
+	return reflect.ValueOf(roundTripCase{Event: event, PathLen: 1 + rng.Intn(4)})
+}
+
+// newPathRenoters creates n offline Renoters that enforce the production PoW difficulty.
+func newPathRenoters(t testing.TB, n int) []*Renoter {
+	t.Helper()
+	renoters := make([]*Renoter, n)
+	for i := range renoters {
+		renoters[i] = newOfflineRenoter(t)
+		renoters[i].powDifficulty = config.PoWDifficulty
+	}
+	return renoters
+}
+
+// unwrapPath simulates every hop of the path in-process: each Renoter unwraps its layer
+// and, except for the last one, re-wraps the inner 29000 for the next Renoter exactly as
+// HandleEvent would before publishing. Returns the final event delivered by the exit.
+func unwrapPath(t testing.TB, renoters []*Renoter, container *nostr.Event) *nostr.Event {
+	t.Helper()
+	current := container
+	for i, renoter := range renoters {
+		if valid, err := current.CheckSignature(); err != nil || !valid {
+			t.Fatalf("hop %d: container signature invalid: %v", i, err)
+		}
+		if len(current.Tags) == 0 || current.Tags[0][1] != renoter.PublicKey {
+			t.Fatalf("hop %d: container is not addressed to this Renoter", i)
+		}
+
+		inner, err := renoter.unwrapEvent(context.Background(), current)
+		if err != nil {
+			t.Fatalf("hop %d: unwrapEvent() error = %v", i, err)
+		}
+		if inner == nil {
+			t.Fatalf("hop %d: unwrapEvent() dropped event addressed to us", i)
+		}
+
+		if i == len(renoters)-1 {
+			return inner
+		}
+
+		if inner.Kind != config.WrapperEventKind {
+			t.Fatalf("hop %d: inner kind = %d, want %d", i, inner.Kind, config.WrapperEventKind)
+		}
+		next := renoters[i+1].PublicKey
+		if inner.Tags[0][0] != "p" || inner.Tags[0][1] != next {
+			t.Fatalf("hop %d: inner 29000 not routed to next Renoter", i)
+		}
+
+		current, err = buildNextHopContainer(context.Background(), inner, next, renoter.standardizedSize, 0, nil)
+		if err != nil {
+			t.Fatalf("hop %d: buildNextHopContainer() error = %v", i, err)
+		}
+	}
+	return nil
+}
+
+// wrapForRenoters wraps an event with the real client for the given Renoters.
+func wrapForRenoters(t testing.TB, event *nostr.Event, renoters []*Renoter) *nostr.Event {
+	t.Helper()
+	path := make(client.Path, len(renoters))
+	for i, renoter := range renoters {
+		path[i].PubKey, _ = hex.DecodeString(renoter.PublicKey)
+	}
+	wrapped, err := client.WrapEvent(context.Background(), event, path, client.DefaultWrapOptions())
+	if err != nil {
+		t.Fatalf("WrapEvent() error = %v", err)
+	}
+	return wrapped
+}
+
+func TestRoundTrip_WrapThenUnwrapEveryHop(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping round-trip property test in short mode (mines PoW for every layer)")
+	}
+
+	seed := time.Now().UnixNano()
+	t.Logf("round-trip seed: %d", seed)
+
+	property := func(c roundTripCase) bool {
+		originalJSON, _ := json.Marshal(c.Event)
+
+		renoters := newPathRenoters(t, c.PathLen)
+		wrapped := wrapForRenoters(t, c.Event, renoters)
+		final := unwrapPath(t, renoters, wrapped)
+
+		if valid, err := final.CheckSignature(); err != nil || !valid {
+			t.Errorf("final event signature invalid: %v", err)
+			return false
+		}
+		finalJSON, _ := json.Marshal(final)
+		if string(finalJSON) != string(originalJSON) {
+			t.Errorf("path length %d: recovered event differs from original\n got: %s\nwant: %s", c.PathLen, finalJSON, originalJSON)
+			return false
+		}
+		return true
+	}
+
+	cfg := &quick.Config{MaxCount: 4, Rand: rand.New(rand.NewSource(seed))}
+	if err := quick.Check(property, cfg); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRoundTrip_CompactLayers(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping round-trip test in short mode (mines PoW)")
+	}
+
+	event := &nostr.Event{Kind: 1, Content: "compact <layers> & unicode éè", CreatedAt: nostr.Now(), Tags: nostr.Tags{{"t", "renoter"}}}
+	event.Sign(nostr.GeneratePrivateKey())
+
+	renoters := newPathRenoters(t, 3)
+	path := make(client.Path, len(renoters))
+	for i, renoter := range renoters {
+		path[i].PubKey, _ = hex.DecodeString(renoter.PublicKey)
+	}
+	opts := client.DefaultOptions()
+	opts.CompactLayers = true
+	wrapped, err := client.WrapEvent(context.Background(), event, path, client.WrapOptions{Options: opts})
+	if err != nil {
+		t.Fatalf("WrapEvent() error = %v", err)
+	}
+
+	final := unwrapPath(t, renoters, wrapped)
+	originalJSON, _ := json.Marshal(event)
+	finalJSON, _ := json.Marshal(final)
+	if string(finalJSON) != string(originalJSON) {
+		t.Errorf("recovered event differs from original\n got: %s\nwant: %s", finalJSON, originalJSON)
+	}
+}
+
+func TestRoundTrip_TamperedLayerIsRejected(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping round-trip test in short mode (mines PoW)")
+	}
+
+	event := &nostr.Event{Kind: 1, Content: "tamper me", CreatedAt: nostr.Now(), Tags: nostr.Tags{}}
+	event.Sign(nostr.GeneratePrivateKey())
+
+	renoters := newPathRenoters(t, 1)
+	wrapped := wrapForRenoters(t, event, renoters)
+
+	// Flip one ciphertext character; the NIP-44 MAC must catch it
+	tampered := *wrapped
+	replacement := "A"
+	if strings.HasPrefix(tampered.Content[40:], "A") {
+		replacement = "B"
+	}
+	tampered.Content = tampered.Content[:40] + replacement + tampered.Content[41:]
+
+	if _, err := renoters[0].unwrapEvent(context.Background(), &tampered); err == nil {
+		t.Error("unwrapEvent() should reject a tampered container")
+	}
+}
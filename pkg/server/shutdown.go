@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/girino/renoter/internal/relaypool"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// lifecycle tracks the goroutines a Renoter starts, so Close can cancel their contexts and wait
+// for them. The zero value is ready to use.
+type lifecycle struct {
+	mu      sync.Mutex
+	closed  bool
+	next    int
+	cancels map[int]context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// start returns a context derived from ctx that is also cancelled by close, and a function to
+// call when the goroutine using it returns. It fails once close was called.
+func (l *lifecycle) start(ctx context.Context) (context.Context, func(), error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil, nil, fmt.Errorf("renoter is closed")
+	}
+	if l.cancels == nil {
+		l.cancels = make(map[int]context.CancelFunc)
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	id := l.next
+	l.next++
+	l.cancels[id] = cancel
+	l.wg.Add(1)
+
+	done := func() {
+		cancel()
+		l.mu.Lock()
+		delete(l.cancels, id)
+		l.mu.Unlock()
+		l.wg.Done()
+	}
+	return ctx, done, nil
+}
+
+// run runs f in a goroutine with a context from start. Once closed, f is not run.
+func (l *lifecycle) run(ctx context.Context, f func(ctx context.Context)) {
+	ctx, done, err := l.start(ctx)
+	if err != nil {
+		return
+	}
+	go func() {
+		defer done()
+		f(ctx)
+	}()
+}
+
+// close cancels the context of every running goroutine and waits for them to return until ctx
+// is done. It reports how many were still running then.
+func (l *lifecycle) close(ctx context.Context) error {
+	l.mu.Lock()
+	l.closed = true
+	for _, cancel := range l.cancels {
+		cancel()
+	}
+	l.mu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		l.wg.Wait()
+		close(finished)
+	}()
+	select {
+	case <-finished:
+		return nil
+	case <-ctx.Done():
+		l.mu.Lock()
+		running := len(l.cancels)
+		l.mu.Unlock()
+		return fmt.Errorf("%d goroutines still running: %w", running, ctx.Err())
+	}
+}
+
+// Close stops the Renoter: it cancels its subscriptions, held layers, announcements and gossip,
+// waits for them to finish until ctx is done, and then closes the relay connections it opened,
+// so relays see the subscriptions closed rather than dropped. A pool given to
+// NewRenoterWithPool is left to its owner. The Renoter cannot be used afterwards.
+func (r *Renoter) Close(ctx context.Context) error {
+	logging.Info("server.shutdown.Close: Closing Renoter")
+	err := r.life.close(ctx)
+	if err != nil {
+		logging.Warn("server.shutdown.Close: not every goroutine stopped: %v", err)
+	}
+
+	if r.ownPool {
+		if pool, ok := r.pool.(*nostr.SimplePool); ok {
+			if closeErr := relaypool.CloseRelays(ctx, pool); closeErr != nil && err == nil {
+				err = closeErr
+			}
+		}
+	}
+	if r.publisher != nil {
+		if closeErr := r.publisher.Close(ctx); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
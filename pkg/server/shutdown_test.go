@@ -0,0 +1,63 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestRenoter_Close(t *testing.T) {
+	ctx := context.Background()
+	testRelay, err := StartTestRelay(ctx)
+	if err != nil {
+		t.Fatalf("Failed to start test relay: %v", err)
+	}
+	defer testRelay.Stop(ctx)
+
+	renoter, err := NewRenoter(ctx, nostr.GeneratePrivateKey(), []string{testRelay.URL()})
+	if err != nil {
+		t.Fatalf("NewRenoter() error = %v", err)
+	}
+	if err := renoter.SubscribeToWrappedEvents(ctx); err != nil {
+		t.Fatalf("SubscribeToWrappedEvents() error = %v", err)
+	}
+	stopped := make(chan struct{})
+	renoter.life.run(ctx, func(ctx context.Context) {
+		<-ctx.Done()
+		close(stopped)
+	})
+
+	closeCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := renoter.Close(closeCtx); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	select {
+	case <-stopped:
+	default:
+		t.Error("Close() returned before the background goroutine stopped")
+	}
+	if n := renoter.pool.(*nostr.SimplePool).Relays.Size(); n != 0 {
+		t.Errorf("pool holds %d relays after Close(), want 0", n)
+	}
+	if err := renoter.SubscribeToWrappedEvents(ctx); err == nil {
+		t.Error("SubscribeToWrappedEvents() after Close() should fail")
+	}
+}
+
+func TestLifecycle_CloseTimeout(t *testing.T) {
+	var life lifecycle
+	release := make(chan struct{})
+	defer close(release)
+	// A goroutine ignoring its context keeps close waiting until the deadline
+	life.run(context.Background(), func(context.Context) { <-release })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := life.close(ctx); err == nil || !strings.Contains(err.Error(), "1 goroutines still running") {
+		t.Errorf("close() error = %v, want the goroutine reported as still running", err)
+	}
+}
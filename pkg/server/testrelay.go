@@ -17,10 +17,14 @@ type TestRelay struct {
 	url    string
 }
 
-// StartTestRelay starts a local khatru relay on a random available port
-func StartTestRelay(ctx context.Context) (*TestRelay, error) {
+// StartTestRelay starts a local khatru relay on a random available port. The setup functions
+// run before it serves, so they can set its hooks without racing its connections.
+func StartTestRelay(ctx context.Context, setup ...func(*khatru.Relay)) (*TestRelay, error) {
 	// Create a new khatru relay
 	relay := khatru.NewRelay()
+	for _, configure := range setup {
+		configure(relay)
+	}
 
 	// Find an available port
 	listener, err := net.Listen("tcp", ":0")
@@ -60,7 +64,8 @@ func (tr *TestRelay) URL() string {
 	return tr.url
 }
 
-// Relay returns the underlying khatru relay instance
+// Relay returns the underlying khatru relay instance. Its hooks must not be changed once it
+// serves; set them through StartTestRelay instead.
 func (tr *TestRelay) Relay() *khatru.Relay {
 	return tr.relay
 }
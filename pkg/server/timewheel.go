@@ -0,0 +1,100 @@
+package server
+
+import (
+	"sort"
+	"time"
+)
+
+// wheelSlots is how many slots of time a timeWheel splits the cutoff duration of its cache into.
+const wheelSlots = 120
+
+// timeWheel orders the IDs of an EventCache by when they were marked, in one bucket per slot of
+// time, oldest first. Expiring IDs then drops whole buckets, a step per bucket however large the
+// cache is, instead of searching every ID. An ID marked again after it expired may still sit in
+// its old bucket; callers tell the live copy by the slot of the time they hold for it.
+type timeWheel struct {
+	// Length of a slot
+	slot time.Duration
+	// Buckets of the slots holding IDs, oldest first
+	buckets []*wheelBucket
+}
+
+// wheelBucket holds the IDs marked within one slot of time, in insertion order.
+type wheelBucket struct {
+	index int64
+	ids   []string
+}
+
+// newTimeWheel creates a timeWheel for a cache keeping IDs for cutoff: wheelSlots slots, of a
+// second at least.
+func newTimeWheel(cutoff time.Duration) *timeWheel {
+	return &timeWheel{slot: max(time.Second, cutoff/wheelSlots)}
+}
+
+// slotOf returns the index of the slot t falls in.
+func (w *timeWheel) slotOf(t time.Time) int64 {
+	return t.UnixNano() / int64(w.slot)
+}
+
+// add appends id to the bucket of t. IDs usually arrive in time order, so this is an append to
+// the newest bucket; older times search the buckets.
+func (w *timeWheel) add(id string, t time.Time) {
+	index := w.slotOf(t)
+	n := len(w.buckets)
+	if n > 0 && w.buckets[n-1].index == index {
+		w.buckets[n-1].ids = append(w.buckets[n-1].ids, id)
+		return
+	}
+	if n == 0 || w.buckets[n-1].index < index {
+		w.buckets = append(w.buckets, &wheelBucket{index: index, ids: []string{id}})
+		return
+	}
+	i := sort.Search(n, func(i int) bool { return w.buckets[i].index >= index })
+	if w.buckets[i].index != index {
+		w.buckets = append(w.buckets, nil)
+		copy(w.buckets[i+1:], w.buckets[i:])
+		w.buckets[i] = &wheelBucket{index: index}
+	}
+	w.buckets[i].ids = append(w.buckets[i].ids, id)
+}
+
+// expire removes the buckets of the slots that ended by cutoff, calling drop for every ID in
+// them with the index of its slot.
+func (w *timeWheel) expire(cutoff time.Time, drop func(id string, index int64) bool) {
+	last := w.slotOf(cutoff)
+	expired := 0
+	for expired < len(w.buckets) && w.buckets[expired].index < last {
+		for _, id := range w.buckets[expired].ids {
+			drop(id, w.buckets[expired].index)
+		}
+		expired++
+	}
+	w.buckets = w.buckets[expired:]
+}
+
+// shift removes the oldest IDs until drop reported n of them as removed, or the wheel is empty.
+func (w *timeWheel) shift(n int, drop func(id string, index int64) bool) {
+	for n > 0 && len(w.buckets) > 0 {
+		bucket := w.buckets[0]
+		taken := 0
+		for taken < len(bucket.ids) && n > 0 {
+			if drop(bucket.ids[taken], bucket.index) {
+				n--
+			}
+			taken++
+		}
+		bucket.ids = bucket.ids[taken:]
+		if len(bucket.ids) == 0 {
+			w.buckets = w.buckets[1:]
+		}
+	}
+}
+
+// each calls fn for every ID, oldest first, with the index of its slot.
+func (w *timeWheel) each(fn func(id string, index int64)) {
+	for _, bucket := range w.buckets {
+		for _, id := range bucket.ids {
+			fn(id, bucket.index)
+		}
+	}
+}
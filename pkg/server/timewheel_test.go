@@ -0,0 +1,78 @@
+package server
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestTimeWheel(t *testing.T) {
+	wheel := newTimeWheel(2 * time.Minute) // one-second slots
+	start := time.Unix(1700000000, 0)
+
+	wheel.add("b", start.Add(2*time.Second))
+	wheel.add("c", start.Add(2*time.Second))
+	wheel.add("d", start.Add(5*time.Second))
+	// Older times land in the bucket of their slot
+	wheel.add("a", start)
+
+	var order []string
+	wheel.each(func(id string, index int64) { order = append(order, id) })
+	if fmt.Sprint(order) != "[a b c d]" {
+		t.Errorf("each() order = %v, want [a b c d]", order)
+	}
+
+	// Buckets of slots that ended by the cutoff are dropped whole
+	var expired []string
+	wheel.expire(start.Add(3*time.Second), func(id string, index int64) bool {
+		expired = append(expired, id)
+		return true
+	})
+	if fmt.Sprint(expired) != "[a b c]" || len(wheel.buckets) != 1 {
+		t.Errorf("expire() dropped %v leaving %d buckets, want [a b c] leaving 1", expired, len(wheel.buckets))
+	}
+
+	// shift counts only the IDs drop reports as removed
+	wheel.add("e", start.Add(6*time.Second))
+	wheel.add("f", start.Add(6*time.Second))
+	var shifted []string
+	wheel.shift(2, func(id string, index int64) bool {
+		shifted = append(shifted, id)
+		return id != "d"
+	})
+	if fmt.Sprint(shifted) != "[d e f]" || len(wheel.buckets) != 0 {
+		t.Errorf("shift() visited %v leaving %d buckets, want [d e f] leaving none", shifted, len(wheel.buckets))
+	}
+}
+
+func TestEventCache_LargeWheel(t *testing.T) {
+	const size = 300000
+	cache := NewEventCache(size, time.Hour)
+	start := time.Now().Add(-2 * time.Hour)
+
+	// Spread the IDs over two hours, so the first half is expired
+	step := 2 * time.Hour / size
+	for i := 0; i < size; i++ {
+		cache.CheckAndMark(fmt.Sprintf("event-%d", i), start.Add(time.Duration(i)*step))
+	}
+	now := start.Add(2 * time.Hour)
+	cache.Cleanup(now)
+
+	// Cleanup drops whole slots, so at most one slot of expired IDs is left
+	slot := time.Hour / wheelSlots
+	if got, most := cache.Size(), size/2+int(slot/step)+1; got < size/2 || got > most {
+		t.Errorf("Size() after Cleanup() = %d, want between %d and %d", got, size/2, most)
+	}
+	if cache.Contains("event-0") || !cache.Contains(fmt.Sprintf("event-%d", size-1)) {
+		t.Error("Cleanup() should drop the oldest IDs and keep the newest")
+	}
+
+	// A re-marked expired ID lives in its new slot and survives the cleanup of its old one
+	if cache.CheckAndMark("event-1", now) {
+		t.Error("CheckAndMark() reported an expired ID as seen")
+	}
+	cache.Cleanup(now.Add(time.Minute))
+	if !cache.CheckAndMark("event-1", now) {
+		t.Error("a re-marked ID was dropped with its old slot")
+	}
+}
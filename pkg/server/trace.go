@@ -0,0 +1,162 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/girino/nostr-lib/logging"
+)
+
+// StageTiming is how long one pipeline stage took on a container.
+type StageTiming struct {
+	Stage    string        `json:"stage"`
+	Duration time.Duration `json:"duration_ns"`
+}
+
+// Trace is how one container went through the pipeline. It carries no content, keys or error
+// messages, and its identifiers are hashed with a salt drawn at startup, so traces from the same
+// run can be matched with each other but not with the events seen on relays.
+type Trace struct {
+	// Hashed ID of the 29001 container
+	Container string `json:"container"`
+	// Hashed ID of the 29000 layer inside it, once opened
+	Layer string `json:"layer,omitempty"`
+	// When processing started
+	ReceivedAt time.Time `json:"received_at"`
+	// Stages run, in order
+	Stages []StageTiming `json:"stages"`
+	// How processing ended
+	Outcome OutcomeKind `json:"outcome"`
+	// Stage that dropped or rejected the container
+	StoppedAt string `json:"stopped_at,omitempty"`
+	// Why it was dropped or rejected, see Outcome.Reason
+	Category string `json:"category,omitempty"`
+	// Mixing delay the forwarded event was held for
+	Held time.Duration `json:"held_ns,omitempty"`
+}
+
+// traceBuffer keeps the traces of the most recent containers.
+type traceBuffer struct {
+	salt [16]byte
+
+	mu     sync.Mutex
+	traces []Trace
+	// Position the next trace is written to once the buffer is full
+	next int
+}
+
+// newTraceBuffer creates a buffer keeping the last size traces.
+func newTraceBuffer(size int) (*traceBuffer, error) {
+	b := &traceBuffer{traces: make([]Trace, 0, size)}
+	if _, err := rand.Read(b.salt[:]); err != nil {
+		return nil, fmt.Errorf("failed to draw trace salt: %w", err)
+	}
+	return b, nil
+}
+
+// hash redacts an event ID.
+func (b *traceBuffer) hash(id string) string {
+	sum := sha256.Sum256(append(b.salt[:], id...))
+	return hex.EncodeToString(sum[:8])
+}
+
+// add records a trace, replacing the oldest once the buffer is full.
+func (b *traceBuffer) add(trace Trace) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.traces) < cap(b.traces) {
+		b.traces = append(b.traces, trace)
+		return
+	}
+	b.traces[b.next] = trace
+	b.next = (b.next + 1) % len(b.traces)
+}
+
+// recent returns the traces, newest first.
+func (b *traceBuffer) recent() []Trace {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	recent := make([]Trace, 0, len(b.traces))
+	for i := range b.traces {
+		recent = append(recent, b.traces[(b.next+len(b.traces)-1-i)%len(b.traces)])
+	}
+	return recent
+}
+
+// SetTraceBuffer keeps the processing traces of the last size containers, see Traces (0 = off).
+// Tracing costs a few allocations per container, so it is meant for debugging.
+func (r *Renoter) SetTraceBuffer(size int) error {
+	if size < 0 {
+		logging.Error("server.trace.SetTraceBuffer: invalid size %d", size)
+		return fmt.Errorf("trace buffer size must not be negative, got %d", size)
+	}
+	if size == 0 {
+		r.traces = nil
+		return nil
+	}
+	traces, err := newTraceBuffer(size)
+	if err != nil {
+		logging.Error("server.trace.SetTraceBuffer: %v", err)
+		return err
+	}
+	r.traces = traces
+	logging.Info("server.trace.SetTraceBuffer: Keeping processing traces of the last %d containers", size)
+	return nil
+}
+
+// Traces returns the processing traces of the most recent containers, newest first, or nil if
+// tracing is off.
+func (r *Renoter) Traces() []Trace {
+	if r.traces == nil {
+		return nil
+	}
+	return r.traces.recent()
+}
+
+// startTrace starts tracing msg if tracing is on.
+func (r *Renoter) startTrace(msg *Message) {
+	if r.traces != nil {
+		msg.trace = &Trace{ReceivedAt: msg.ReceivedAt}
+	}
+}
+
+// finishTrace records the trace of msg, which ended with outcome.
+func (r *Renoter) finishTrace(msg *Message, outcome Outcome) {
+	trace := msg.trace
+	if trace == nil || r.traces == nil {
+		return
+	}
+	trace.Container = r.traces.hash(msg.Container.ID)
+	if msg.Layer != nil {
+		trace.Layer = r.traces.hash(msg.Layer.ID)
+	}
+	trace.Outcome = outcome.Kind
+	trace.StoppedAt = outcome.Stage
+	trace.Category = outcome.Reason
+	if outcome.Kind == OutcomeHeld {
+		trace.Held = msg.Delay
+	}
+	r.traces.add(*trace)
+}
+
+// TraceHandler serves the processing traces of a Renoter as JSON, newest first.
+type TraceHandler struct {
+	Renoter *Renoter
+}
+
+// ServeHTTP serves the current traces.
+func (h TraceHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	traces := h.Renoter.Traces()
+	if traces == nil {
+		http.Error(w, "tracing is off", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(traces)
+}
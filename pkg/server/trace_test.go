@@ -0,0 +1,76 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/girino/renoter/internal/config"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+func TestTraceBuffer_KeepsMostRecent(t *testing.T) {
+	buffer, err := newTraceBuffer(2)
+	if err != nil {
+		t.Fatalf("newTraceBuffer() error = %v", err)
+	}
+	for _, outcome := range []OutcomeKind{"1", "2", "3"} {
+		buffer.add(Trace{Outcome: outcome})
+	}
+	recent := buffer.recent()
+	if len(recent) != 2 || recent[0].Outcome != "3" || recent[1].Outcome != "2" {
+		t.Errorf("recent() = %+v, want traces 3 and 2", recent)
+	}
+}
+
+func TestRenoter_Traces(t *testing.T) {
+	ctx := context.Background()
+	renoter := newOfflineRenoter(t)
+	if got := renoter.Traces(); got != nil {
+		t.Errorf("Traces() with tracing off = %v, want nil", got)
+	}
+	if err := renoter.SetTraceBuffer(-1); err == nil {
+		t.Error("SetTraceBuffer(-1) should fail")
+	}
+	if err := renoter.SetTraceBuffer(10); err != nil {
+		t.Fatalf("SetTraceBuffer() error = %v", err)
+	}
+
+	// A container for another Renoter is dropped before decryption
+	other := nostr.Event{Kind: config.StandardizedWrapperKind, CreatedAt: nostr.Now(), Tags: nostr.Tags{{"p", strings.Repeat("a", 64)}}}
+	other.Sign(nostr.GeneratePrivateKey())
+	renoter.Handle(ctx, &other)
+	// A container with a broken signature fails the first stage
+	forged := other
+	forged.Content = "forged"
+	renoter.Handle(ctx, &forged)
+
+	traces := renoter.Traces()
+	if len(traces) != 2 {
+		t.Fatalf("Traces() = %d traces, want 2", len(traces))
+	}
+	failed, dropped := traces[0], traces[1]
+	if failed.Outcome != OutcomeRejected || failed.StoppedAt != StageSignature || failed.Category != "error" || len(failed.Stages) != 1 {
+		t.Errorf("forged container trace = %+v, want a rejection at %s", failed, StageSignature)
+	}
+	if dropped.Outcome != OutcomeDropped || dropped.StoppedAt != StageRecipient || dropped.Category != DropMisaddressed || len(dropped.Stages) != 5 {
+		t.Errorf("misaddressed container trace = %+v, want a drop at %s", dropped, StageRecipient)
+	}
+	if dropped.Container == "" || strings.Contains(other.ID, dropped.Container) {
+		t.Errorf("trace container = %q, want the ID hashed", dropped.Container)
+	}
+
+	recorder := httptest.NewRecorder()
+	TraceHandler{Renoter: renoter}.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/traces", nil))
+	if body := recorder.Body.String(); !strings.Contains(body, `"outcome":"dropped"`) || strings.Contains(body, other.ID) {
+		t.Errorf("ServeHTTP() = %s", body)
+	}
+	renoter.SetTraceBuffer(0)
+	recorder = httptest.NewRecorder()
+	TraceHandler{Renoter: renoter}.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/traces", nil))
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("ServeHTTP() with tracing off = %d, want 404", recorder.Code)
+	}
+}
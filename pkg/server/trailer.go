@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/girino/renoter/internal/config"
+	"github.com/girino/renoter/internal/sealtag"
+	"github.com/girino/renoter/internal/trailer"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// SetTimingTrailers sets whether this Renoter adds a timing trailer for layers asking for one
+// (the default). When disabled, trailers from earlier hops are still carried to the next
+// container, but the report is lost if this Renoter is the exit.
+func (r *Renoter) SetTimingTrailers(enabled bool) {
+	r.trailersOff = !enabled
+}
+
+// reportKey returns the report pubkey sealed in the layer's report tag, or "" if the layer does
+// not ask for a trailer, the tag is unreadable or trailers are disabled.
+func (r *Renoter) reportKey(msg *Message) string {
+	tag := msg.Layer.Tags.Find(config.ReportTag)
+	if tag == nil || r.trailersOff {
+		return ""
+	}
+	values, err := sealtag.Open(tag[1], msg.LayerKey)
+	if err != nil || len(values) == 0 || !nostr.IsValid32ByteHex(values[0]) {
+		logging.Warn("server.trailer.reportKey: ignoring unreadable report tag on 29000 %s: %v", msg.Layer.ID, err)
+		return ""
+	}
+	return values[0]
+}
+
+// ownTrailer seals this Renoter's timing of msg, forwarded now, to report.
+func (r *Renoter) ownTrailer(msg *Message, report string) (nostr.Tag, error) {
+	return trailer.Seal(trailer.Timing{
+		Renoter:     r.PublicKey,
+		ReceivedAt:  msg.ReceivedAt,
+		ForwardedAt: time.Now(),
+		Held:        msg.Delay,
+	}, report)
+}
+
+// nextTrailers returns the trailer tags for the container forwarding msg: the incoming container's
+// trailers with this Renoter's own added if report is set. Containers without trailers and
+// layers not asking for one get none.
+func (r *Renoter) nextTrailers(msg *Message, report string) (nostr.Tags, error) {
+	if report == "" {
+		if msg.Container.Tags.Find(config.TrailerTag) == nil {
+			return nil, nil
+		}
+		return trailer.Carry(msg.Container.Tags, nil)
+	}
+	own, err := r.ownTrailer(msg, report)
+	if err != nil {
+		return nil, err
+	}
+	return trailer.Carry(msg.Container.Tags, own)
+}
+
+// publishTrailers publishes the trailers of the event msg delivered, with this Renoter's own, to
+// the sender as a TrailerEventKind event signed by a throwaway key. Failures are only logged: the
+// event itself was delivered.
+func (r *Renoter) publishTrailers(ctx context.Context, msg *Message, report string) {
+	trailers, err := r.nextTrailers(msg, report)
+	if err != nil {
+		logging.Warn("server.trailer.publishTrailers: failed to seal trailer for event %s: %v", msg.Inner.ID, err)
+		return
+	}
+	event := nostr.Event{
+		Kind:      config.TrailerEventKind,
+		CreatedAt: nostr.Now(),
+		Tags:      append(nostr.Tags{{"p", report}}, trailers...),
+	}
+	if err := event.Sign(nostr.GeneratePrivateKey()); err != nil {
+		logging.Warn("server.trailer.publishTrailers: failed to sign trailer event: %v", err)
+		return
+	}
+	successCount := 0
+	for result := range r.forwarder.PublishMany(ctx, r.relayURLs, event) {
+		if result.Error == nil {
+			successCount++
+		}
+	}
+	if successCount == 0 {
+		logging.Warn("server.trailer.publishTrailers: no relay accepted the trailers of event %s", msg.Inner.ID)
+		return
+	}
+	logging.DebugMethod("server.trailer", "publishTrailers", "Published trailers of event %s as %s", msg.Inner.ID, event.ID)
+}
@@ -0,0 +1,261 @@
+package simulator
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fasthttp/websocket"
+	"github.com/fiatjaf/khatru"
+	"github.com/girino/nostr-lib/logging"
+	"github.com/girino/renoter/internal/config"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// observerParam marks the connections of the observer counting final events in their URL query,
+// so faults never hit them and losses are the network's alone.
+const observerParam = "observer"
+
+// Faults makes the simulated relays misbehave like real ones. They hit the 29001 containers a
+// relay delivers to each subscribing Renoter, independently per relay and subscriber, so the
+// mixing, replay protection and deduplication of the Renoters can be checked against them.
+type Faults struct {
+	// Fraction of deliveries silently dropped, as by a relay that accepted the container
+	DropRate float64
+	// Fraction of deliveries made twice
+	DuplicateRate float64
+	// Largest random delay added to a delivery, reordering containers (0 = deliver at once)
+	Jitter time.Duration
+	// Mean time between forced disconnects of one random Renoter or client connection to a
+	// relay (0 = never)
+	DisconnectInterval time.Duration
+}
+
+// Validate checks that the faults are usable.
+func (f Faults) Validate() error {
+	if f.DropRate < 0 || f.DropRate > 1 {
+		return fmt.Errorf("drop rate must be between 0 and 1, got %v", f.DropRate)
+	}
+	if f.DuplicateRate < 0 || f.DuplicateRate > 1 {
+		return fmt.Errorf("duplicate rate must be between 0 and 1, got %v", f.DuplicateRate)
+	}
+	if f.Jitter < 0 {
+		return fmt.Errorf("jitter must not be negative, got %v", f.Jitter)
+	}
+	if f.DisconnectInterval < 0 {
+		return fmt.Errorf("disconnect interval must not be negative, got %v", f.DisconnectInterval)
+	}
+	return nil
+}
+
+// active reports whether any fault is injected.
+func (f Faults) active() bool {
+	return f.DropRate > 0 || f.DuplicateRate > 0 || f.Jitter > 0 || f.DisconnectInterval > 0
+}
+
+// FaultStats counts the faults injected during a run.
+type FaultStats struct {
+	Dropped     int64
+	Duplicated  int64
+	Delayed     int64
+	Disconnects int64
+}
+
+// faultCounters is the concurrently updated form of FaultStats.
+type faultCounters struct {
+	dropped, duplicated, delayed, disconnects atomic.Int64
+}
+
+// snapshot returns the counts so far.
+func (c *faultCounters) snapshot() FaultStats {
+	return FaultStats{
+		Dropped:     c.dropped.Load(),
+		Duplicated:  c.duplicated.Load(),
+		Delayed:     c.delayed.Load(),
+		Disconnects: c.disconnects.Load(),
+	}
+}
+
+// faultyRelay injects faults into the deliveries of one relay.
+type faultyRelay struct {
+	relay    *khatru.Relay
+	faults   Faults
+	counters *faultCounters
+
+	mu  sync.Mutex
+	rng *rand.Rand
+	// Open connections other than the observer's, with their subscriptions, which late
+	// deliveries are written to
+	connections map[*khatru.WebSocket][]subscription
+	// Whether each container was withheld from a connection, so it is faulted once per
+	// connection however many of its subscriptions match
+	withheld map[delivery]bool
+}
+
+// subscription is one filter of a REQ.
+type subscription struct {
+	id     string
+	filter nostr.Filter
+}
+
+// delivery is one event delivered to one connection.
+type delivery struct {
+	ws *khatru.WebSocket
+	id string
+}
+
+// injectFaults makes relay misbehave as faults describes until ctx is done.
+func injectFaults(ctx context.Context, relay *khatru.Relay, faults Faults, counters *faultCounters, seed int64) {
+	f := &faultyRelay{
+		relay:       relay,
+		faults:      faults,
+		counters:    counters,
+		rng:         rand.New(rand.NewSource(seed)),
+		connections: make(map[*khatru.WebSocket][]subscription),
+		withheld:    make(map[delivery]bool),
+	}
+	relay.OnConnect = append(relay.OnConnect, f.connect)
+	relay.OnDisconnect = append(relay.OnDisconnect, f.forget)
+	relay.RejectFilter = append(relay.RejectFilter, f.subscribe)
+	relay.PreventBroadcast = append(relay.PreventBroadcast, f.intercept)
+	// Publishers get an OK even when every delivery is withheld, as from relays that don't
+	// report their listeners
+	relay.OnEphemeralEvent = append(relay.OnEphemeralEvent, func(context.Context, *nostr.Event) {})
+	if faults.DisconnectInterval > 0 {
+		go f.disconnect(ctx)
+	}
+}
+
+// connect is the OnConnect hook: it tracks every connection but the observer's.
+func (f *faultyRelay) connect(ctx context.Context) {
+	ws := khatru.GetConnection(ctx)
+	if ws == nil || ws.Request.URL.Query().Has(observerParam) {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.connections[ws] = nil
+}
+
+// forget is the OnDisconnect hook.
+func (f *faultyRelay) forget(ctx context.Context) {
+	ws := khatru.GetConnection(ctx)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.connections, ws)
+	for key := range f.withheld {
+		if key.ws == ws {
+			delete(f.withheld, key)
+		}
+	}
+}
+
+// subscribe is a RejectFilter hook that never rejects: it records the subscriptions of tracked
+// connections.
+func (f *faultyRelay) subscribe(ctx context.Context, filter nostr.Filter) (bool, string) {
+	ws := khatru.GetConnection(ctx)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if subs, tracked := f.connections[ws]; tracked {
+		f.connections[ws] = append(subs, subscription{id: khatru.GetSubscriptionID(ctx), filter: filter})
+	}
+	return false, ""
+}
+
+// intercept is the PreventBroadcast hook: it drops, delays or duplicates the delivery of a
+// container to ws, reporting whether khatru must skip its own delivery.
+func (f *faultyRelay) intercept(ws *khatru.WebSocket, event *nostr.Event) bool {
+	if event.Kind != config.StandardizedWrapperKind {
+		return false
+	}
+	key := delivery{ws: ws, id: event.ID}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if withheld, decided := f.withheld[key]; decided {
+		return withheld
+	}
+	if _, tracked := f.connections[ws]; !tracked {
+		f.withheld[key] = false
+		return false
+	}
+
+	if f.rng.Float64() < f.faults.DropRate {
+		f.withheld[key] = true
+		f.counters.dropped.Add(1)
+		logging.DebugMethod("simulator.faults", "intercept", "Dropping container %s", event.ID)
+		return true
+	}
+	copies := 1
+	if f.rng.Float64() < f.faults.DuplicateRate {
+		copies = 2
+		f.counters.duplicated.Add(1)
+	}
+	if copies == 1 && f.faults.Jitter == 0 {
+		f.withheld[key] = false
+		return false
+	}
+	if f.faults.Jitter > 0 {
+		f.counters.delayed.Add(1)
+	}
+	f.withheld[key] = true
+	late := *event
+	for i := 0; i < copies; i++ {
+		var delay time.Duration
+		if f.faults.Jitter > 0 {
+			delay = time.Duration(f.rng.Int63n(int64(f.faults.Jitter)))
+		}
+		time.AfterFunc(delay, func() { f.release(ws, &late) })
+	}
+	return true
+}
+
+// release makes a withheld delivery to every subscription of ws the event matches.
+func (f *faultyRelay) release(ws *khatru.WebSocket, event *nostr.Event) {
+	f.mu.Lock()
+	subs := slices.Clone(f.connections[ws])
+	f.mu.Unlock()
+	for _, sub := range subs {
+		if sub.filter.Matches(event) {
+			ws.WriteJSON(nostr.EventEnvelope{SubscriptionID: &sub.id, Event: *event})
+		}
+	}
+}
+
+// disconnect closes one random connection at exponentially distributed intervals until ctx is
+// done. Clients and Renoters reconnect on their own.
+func (f *faultyRelay) disconnect(ctx context.Context) {
+	for {
+		f.mu.Lock()
+		wait := time.Duration(f.rng.ExpFloat64() * float64(f.faults.DisconnectInterval))
+		f.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		f.mu.Lock()
+		var victim *khatru.WebSocket
+		if n := len(f.connections); n > 0 {
+			pick := f.rng.Intn(n)
+			for ws := range f.connections {
+				if pick == 0 {
+					victim = ws
+					break
+				}
+				pick--
+			}
+		}
+		f.mu.Unlock()
+		if victim == nil {
+			continue
+		}
+		f.counters.disconnects.Add(1)
+		logging.DebugMethod("simulator.faults", "disconnect", "Disconnecting %s", victim.Request.RemoteAddr)
+		victim.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "simulated disconnect"))
+	}
+}
@@ -0,0 +1,101 @@
+package simulator
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/girino/renoter/pkg/server"
+)
+
+// Report summarizes the outcome of a simulation run.
+type Report struct {
+	// Number of original events submitted by all clients
+	Sent int
+	// Number of original events observed on the relays after traversing the path
+	Delivered int
+	// Number of submitted events that never arrived before the delivery timeout
+	Lost int
+	// Number of events the clients failed to wrap or publish, not counted as lost
+	SubmitErrors int
+
+	// End-to-end latencies (submission to first observation) of delivered events, sorted ascending
+	Latencies []time.Duration
+
+	// Wall-clock duration of the whole run
+	Duration time.Duration
+
+	// Faults injected by the relays
+	Faults FaultStats
+	// Outcomes of the containers processed by all Renoters, which should not grow with
+	// duplicated deliveries
+	Outcomes server.OutcomeStats
+}
+
+// LossRate returns the fraction of submitted events that were not delivered.
+func (r *Report) LossRate() float64 {
+	submitted := r.Sent - r.SubmitErrors
+	if submitted <= 0 {
+		return 0
+	}
+	return float64(r.Lost) / float64(submitted)
+}
+
+// addOutcomes adds the outcomes of one Renoter to the report.
+func (r *Report) addOutcomes(stats server.OutcomeStats) {
+	r.Outcomes.Forwarded += stats.Forwarded
+	r.Outcomes.PublishedFinal += stats.PublishedFinal
+	r.Outcomes.Held += stats.Held
+	r.Outcomes.Dropped += stats.Dropped
+	r.Outcomes.Rejected += stats.Rejected
+}
+
+// Percentile returns the p-th percentile (0-100) of delivered event latencies
+// using the nearest-rank method. Returns 0 if nothing was delivered.
+func (r *Report) Percentile(p float64) time.Duration {
+	return percentile(r.Latencies, p)
+}
+
+// String renders a human-readable summary of the report.
+func (r *Report) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "sent=%d delivered=%d lost=%d (%.1f%%) submit_errors=%d duration=%v\n",
+		r.Sent, r.Delivered, r.Lost, r.LossRate()*100, r.SubmitErrors, r.Duration.Round(time.Millisecond))
+	fmt.Fprintf(&b, "latency p50=%v p90=%v p99=%v max=%v",
+		r.Percentile(50).Round(time.Millisecond),
+		r.Percentile(90).Round(time.Millisecond),
+		r.Percentile(99).Round(time.Millisecond),
+		r.Percentile(100).Round(time.Millisecond))
+	if r.Faults != (FaultStats{}) {
+		fmt.Fprintf(&b, "\nfaults dropped=%d duplicated=%d delayed=%d disconnects=%d",
+			r.Faults.Dropped, r.Faults.Duplicated, r.Faults.Delayed, r.Faults.Disconnects)
+	}
+	fmt.Fprintf(&b, "\nrenoters forwarded=%d published=%d held=%d dropped=%d rejected=%d",
+		r.Outcomes.Forwarded, r.Outcomes.PublishedFinal, r.Outcomes.Held, r.Outcomes.Dropped, r.Outcomes.Rejected)
+	return b.String()
+}
+
+// percentile computes the nearest-rank percentile of an ascending slice of durations.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	if p <= 0 {
+		return sorted[0]
+	}
+	if p >= 100 {
+		return sorted[len(sorted)-1]
+	}
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// sortDurations sorts latencies in place in ascending order.
+func sortDurations(latencies []time.Duration) {
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+}
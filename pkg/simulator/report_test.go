@@ -0,0 +1,83 @@
+package simulator
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPercentile(t *testing.T) {
+	latencies := []time.Duration{}
+	for i := 1; i <= 100; i++ {
+		latencies = append(latencies, time.Duration(i)*time.Millisecond)
+	}
+
+	tests := []struct {
+		p    float64
+		want time.Duration
+	}{
+		{0, 1 * time.Millisecond},
+		{50, 50 * time.Millisecond},
+		{90, 90 * time.Millisecond},
+		{99, 99 * time.Millisecond},
+		{100, 100 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		if got := percentile(latencies, tt.p); got != tt.want {
+			t.Errorf("percentile(%v) = %v, want %v", tt.p, got, tt.want)
+		}
+	}
+
+	if got := percentile(nil, 50); got != 0 {
+		t.Errorf("percentile(nil) = %v, want 0", got)
+	}
+	if got := percentile([]time.Duration{7 * time.Millisecond}, 1); got != 7*time.Millisecond {
+		t.Errorf("percentile(single) = %v, want 7ms", got)
+	}
+}
+
+func TestReport_LossRate(t *testing.T) {
+	report := &Report{Sent: 10, Delivered: 7, Lost: 3}
+	if got := report.LossRate(); got != 0.3 {
+		t.Errorf("LossRate() = %v, want 0.3", got)
+	}
+	// Events that failed to submit are not lost
+	report = &Report{Sent: 12, Delivered: 7, Lost: 3, SubmitErrors: 2}
+	if got := report.LossRate(); got != 0.3 {
+		t.Errorf("LossRate() with submit errors = %v, want 0.3", got)
+	}
+	if got := (&Report{}).LossRate(); got != 0 {
+		t.Errorf("LossRate() with nothing sent = %v, want 0", got)
+	}
+}
+
+func TestTracker_Report(t *testing.T) {
+	tr := newTracker()
+	start := time.Now()
+	tr.sent("a", start)
+	tr.sent("b", start)
+	tr.sent("c", start)
+	tr.delivered("a", start.Add(30*time.Millisecond))
+	tr.delivered("a", start.Add(90*time.Millisecond)) // duplicate from another relay
+	tr.delivered("b", start.Add(10*time.Millisecond))
+	tr.delivered("unknown", start)
+
+	if tr.complete() {
+		t.Error("complete() should be false while an event is outstanding")
+	}
+
+	report := tr.report()
+	if report.Sent != 3 || report.Delivered != 2 || report.Lost != 1 {
+		t.Errorf("report = sent %d delivered %d lost %d, want 3/2/1", report.Sent, report.Delivered, report.Lost)
+	}
+	if report.Latencies[0] != 10*time.Millisecond || report.Latencies[1] != 30*time.Millisecond {
+		t.Errorf("latencies = %v, want [10ms 30ms]", report.Latencies)
+	}
+
+	tr.submitFailed("c")
+	if !tr.complete() {
+		t.Error("complete() should be true once the last event failed to submit")
+	}
+	if report := tr.report(); report.Lost != 0 || report.SubmitErrors != 1 {
+		t.Errorf("report = lost %d submit errors %d, want 0/1", report.Lost, report.SubmitErrors)
+	}
+}
@@ -0,0 +1,389 @@
+package simulator
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/girino/nostr-lib/logging"
+	"github.com/girino/renoter/pkg/client"
+	"github.com/girino/renoter/pkg/server"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+// Pattern selects how each simulated client spaces out its submissions.
+type Pattern string
+
+const (
+	// PatternConstant submits one event every Interval.
+	PatternConstant Pattern = "constant"
+	// PatternBurst submits all events at once.
+	PatternBurst Pattern = "burst"
+	// PatternPoisson submits events with exponentially distributed gaps averaging Interval.
+	PatternPoisson Pattern = "poisson"
+)
+
+// simulationTag marks final events produced by a run so the observer only counts its own traffic.
+const simulationTag = "renoter-sim"
+
+// Config describes the simulated network and the traffic to push through it.
+type Config struct {
+	// Number of independent clients submitting events
+	Clients int
+	// Number of Renoter servers in the network
+	Renoters int
+	// Number of in-process relays shared by clients and Renoters
+	Relays int
+	// Number of Renoters in each event's path (picked at random per event)
+	PathLength int
+	// Number of events each client submits
+	EventsPerClient int
+	// Traffic pattern and mean gap between a client's submissions
+	Pattern  Pattern
+	Interval time.Duration
+	// Time given to subscriptions to settle before traffic starts
+	WarmUp time.Duration
+	// How long to wait for stragglers after the last submission
+	DeliveryTimeout time.Duration
+	// Misbehavior of the relays (none by default)
+	Faults Faults
+}
+
+// DefaultConfig returns a small network suitable for quick local runs.
+func DefaultConfig() Config {
+	return Config{
+		Clients:         2,
+		Renoters:        3,
+		Relays:          2,
+		PathLength:      2,
+		EventsPerClient: 5,
+		Pattern:         PatternConstant,
+		Interval:        200 * time.Millisecond,
+		WarmUp:          500 * time.Millisecond,
+		DeliveryTimeout: 10 * time.Second,
+	}
+}
+
+// Validate checks that the configuration describes a runnable network.
+func (c Config) Validate() error {
+	if c.Clients < 1 {
+		return fmt.Errorf("clients must be at least 1, got %d", c.Clients)
+	}
+	if c.Renoters < 1 {
+		return fmt.Errorf("renoters must be at least 1, got %d", c.Renoters)
+	}
+	if c.Relays < 1 {
+		return fmt.Errorf("relays must be at least 1, got %d", c.Relays)
+	}
+	if c.PathLength < 1 || c.PathLength > c.Renoters {
+		return fmt.Errorf("path length must be between 1 and the number of renoters (%d), got %d", c.Renoters, c.PathLength)
+	}
+	if c.EventsPerClient < 1 {
+		return fmt.Errorf("events per client must be at least 1, got %d", c.EventsPerClient)
+	}
+	switch c.Pattern {
+	case PatternConstant, PatternBurst, PatternPoisson:
+	default:
+		return fmt.Errorf("unknown traffic pattern %q", c.Pattern)
+	}
+	if c.Pattern != PatternBurst && c.Interval <= 0 {
+		return fmt.Errorf("interval must be positive for pattern %q", c.Pattern)
+	}
+	if c.DeliveryTimeout <= 0 {
+		return fmt.Errorf("delivery timeout must be positive")
+	}
+	if err := c.Faults.Validate(); err != nil {
+		return fmt.Errorf("invalid faults: %w", err)
+	}
+	return nil
+}
+
+// network holds the in-process relays and Renoters of one run.
+type network struct {
+	relays    []*server.TestRelay
+	relayURLs []string
+	renoters  []*server.Renoter
+	nodes     client.Path
+	faults    faultCounters
+}
+
+// Run builds an in-process network, pushes the configured traffic through it
+// and reports end-to-end latency and loss.
+func Run(ctx context.Context, cfg Config) (*Report, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid simulation config: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	logging.Info("simulator.simulator.Run: Starting simulation: %d clients, %d renoters, %d relays, path length %d, %d events per client (%s)",
+		cfg.Clients, cfg.Renoters, cfg.Relays, cfg.PathLength, cfg.EventsPerClient, cfg.Pattern)
+
+	net, err := startNetwork(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	defer net.stop()
+
+	runID := nostr.GeneratePrivateKey()[:16]
+	tracker := newTracker()
+
+	// Observe final events on every relay, through connections spared by the faults
+	observerURLs := make([]string, len(net.relayURLs))
+	for i, url := range net.relayURLs {
+		observerURLs[i] = url + "?" + observerParam
+	}
+	observerPool := nostr.NewSimplePool(ctx)
+	defer observerPool.Close("simulation done")
+	finals := observerPool.SubscribeMany(ctx, observerURLs, nostr.Filter{
+		Tags: nostr.TagMap{"t": []string{simulationTag + "-" + runID}},
+	})
+	go func() {
+		for relayEvent := range finals {
+			tracker.delivered(relayEvent.Event.ID, time.Now())
+		}
+	}()
+
+	// Let the Renoter and observer subscriptions reach the relays
+	select {
+	case <-time.After(cfg.WarmUp):
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.Clients; i++ {
+		wg.Add(1)
+		go func(clientIndex int) {
+			defer wg.Done()
+			runClient(ctx, cfg, net, clientIndex, runID, tracker)
+		}(i)
+	}
+	wg.Wait()
+
+	// Wait for all events or the delivery timeout, whichever comes first
+	deadline := time.NewTimer(cfg.DeliveryTimeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+waitLoop:
+	for !tracker.complete() {
+		select {
+		case <-deadline.C:
+			break waitLoop
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	report := tracker.report()
+	report.Duration = time.Since(start)
+	report.Faults = net.faults.snapshot()
+	for _, renoter := range net.renoters {
+		report.addOutcomes(renoter.OutcomeStats())
+	}
+	logging.Info("simulator.simulator.Run: Simulation finished: %s", report.String())
+	return report, nil
+}
+
+// startNetwork launches the relays and Renoters and subscribes every Renoter.
+func startNetwork(ctx context.Context, cfg Config) (*network, error) {
+	net := &network{}
+	for i := 0; i < cfg.Relays; i++ {
+		relay, err := server.StartTestRelay(ctx)
+		if err != nil {
+			net.stop()
+			return nil, fmt.Errorf("failed to start relay %d: %w", i, err)
+		}
+		if cfg.Faults.active() {
+			injectFaults(ctx, relay.Relay(), cfg.Faults, &net.faults, time.Now().UnixNano()+int64(i))
+		}
+		net.relays = append(net.relays, relay)
+		net.relayURLs = append(net.relayURLs, relay.URL())
+	}
+
+	for i := 0; i < cfg.Renoters; i++ {
+		renoter, err := server.NewRenoter(ctx, nostr.GeneratePrivateKey(), net.relayURLs)
+		if err != nil {
+			net.stop()
+			return nil, fmt.Errorf("failed to create renoter %d: %w", i, err)
+		}
+		if err := renoter.SubscribeToWrappedEvents(ctx); err != nil {
+			net.stop()
+			return nil, fmt.Errorf("failed to subscribe renoter %d: %w", i, err)
+		}
+		pubkey, _ := hex.DecodeString(renoter.GetPublicKey())
+		net.renoters = append(net.renoters, renoter)
+		net.nodes = append(net.nodes, client.PathNode{PubKey: pubkey})
+	}
+
+	logging.DebugMethod("simulator.simulator", "startNetwork", "Started %d relays and %d renoters", len(net.relays), len(net.renoters))
+	return net, nil
+}
+
+// stop closes every Renoter of the network, then shuts down its relays.
+func (n *network) stop() {
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for i, renoter := range n.renoters {
+		if err := renoter.Close(shutdownCtx); err != nil {
+			logging.Warn("simulator.simulator.stop: failed to close renoter %d: %v", i, err)
+		}
+	}
+	for _, relay := range n.relays {
+		relay.Stop(shutdownCtx)
+	}
+}
+
+// randomPath picks length distinct Renoters in random order.
+func (n *network) randomPath(rng *rand.Rand, length int) client.Path {
+	order := rng.Perm(len(n.nodes))[:length]
+	path := make(client.Path, length)
+	for i, idx := range order {
+		path[i] = n.nodes[idx]
+	}
+	return path
+}
+
+// runClient submits one client's events according to the configured pattern.
+func runClient(ctx context.Context, cfg Config, net *network, clientIndex int, runID string, tracker *tracker) {
+	rng := rand.New(rand.NewSource(time.Now().UnixNano() + int64(clientIndex)))
+	sk := nostr.GeneratePrivateKey()
+	pool := nostr.NewSimplePool(ctx)
+	defer pool.Close("client done")
+
+	// Submissions still running when the client stops use the pool until they return
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	for i := 0; i < cfg.EventsPerClient; i++ {
+		if i > 0 {
+			if gap := nextGap(rng, cfg); gap > 0 {
+				select {
+				case <-time.After(gap):
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+
+		event := &nostr.Event{
+			Kind:      1,
+			Content:   fmt.Sprintf("simulated note %d from client %d", i, clientIndex),
+			CreatedAt: nostr.Now(),
+			Tags:      nostr.Tags{{"t", simulationTag + "-" + runID}},
+		}
+		event.Sign(sk)
+		path := net.randomPath(rng, cfg.PathLength)
+
+		// Wrapping mines PoW, so submissions run concurrently like real clients would
+		wg.Add(1)
+		go func(event *nostr.Event, path client.Path) {
+			defer wg.Done()
+			tracker.sent(event.ID, time.Now())
+
+			wrapped, err := client.WrapEvent(ctx, event, path, client.DefaultWrapOptions())
+			if err != nil {
+				logging.Warn("simulator.simulator.runClient: client %d failed to wrap event %s: %v", clientIndex, event.ID, err)
+				tracker.submitFailed(event.ID)
+				return
+			}
+
+			published := 0
+			for result := range pool.PublishMany(ctx, net.relayURLs, *wrapped) {
+				if result.Error == nil {
+					published++
+				}
+			}
+			if published == 0 {
+				logging.Warn("simulator.simulator.runClient: client %d failed to publish wrapped event %s", clientIndex, wrapped.ID)
+				tracker.submitFailed(event.ID)
+			}
+		}(event, path)
+	}
+}
+
+// nextGap returns the delay before a client's next submission.
+func nextGap(rng *rand.Rand, cfg Config) time.Duration {
+	switch cfg.Pattern {
+	case PatternBurst:
+		return 0
+	case PatternPoisson:
+		return time.Duration(rng.ExpFloat64() * float64(cfg.Interval))
+	default:
+		return cfg.Interval
+	}
+}
+
+// tracker records submission and delivery times of simulated events.
+type tracker struct {
+	mu          sync.Mutex
+	sentAt      map[string]time.Time
+	deliveredAt map[string]time.Time
+	failed      map[string]bool
+}
+
+func newTracker() *tracker {
+	return &tracker{
+		sentAt:      make(map[string]time.Time),
+		deliveredAt: make(map[string]time.Time),
+		failed:      make(map[string]bool),
+	}
+}
+
+func (t *tracker) sent(id string, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.sentAt[id] = at
+}
+
+func (t *tracker) submitFailed(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.failed[id] = true
+}
+
+// delivered records the first observation of an event; duplicates from other relays are ignored.
+func (t *tracker) delivered(id string, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, known := t.sentAt[id]; !known {
+		return
+	}
+	if _, seen := t.deliveredAt[id]; !seen {
+		t.deliveredAt[id] = at
+	}
+}
+
+// complete reports whether every successfully submitted event has been observed.
+func (t *tracker) complete() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return len(t.deliveredAt)+len(t.failed) >= len(t.sentAt)
+}
+
+func (t *tracker) report() *Report {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	report := &Report{
+		Sent:         len(t.sentAt),
+		Delivered:    len(t.deliveredAt),
+		SubmitErrors: len(t.failed),
+	}
+	for id := range t.sentAt {
+		if _, delivered := t.deliveredAt[id]; !delivered && !t.failed[id] {
+			report.Lost++
+		}
+	}
+	for id, deliveredAt := range t.deliveredAt {
+		report.Latencies = append(report.Latencies, deliveredAt.Sub(t.sentAt[id]))
+	}
+	sortDurations(report.Latencies)
+	return report
+}
@@ -0,0 +1,126 @@
+package simulator
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestConfig_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		mutate  func(*Config)
+		wantErr bool
+	}{
+		{"default", func(c *Config) {}, false},
+		{"no clients", func(c *Config) { c.Clients = 0 }, true},
+		{"no relays", func(c *Config) { c.Relays = 0 }, true},
+		{"path longer than network", func(c *Config) { c.PathLength = c.Renoters + 1 }, true},
+		{"unknown pattern", func(c *Config) { c.Pattern = "random" }, true},
+		{"burst without interval", func(c *Config) { c.Pattern = PatternBurst; c.Interval = 0 }, false},
+		{"poisson without interval", func(c *Config) { c.Pattern = PatternPoisson; c.Interval = 0 }, true},
+		{"faults", func(c *Config) { c.Faults = Faults{DropRate: 0.1, DuplicateRate: 1, Jitter: time.Second} }, false},
+		{"drop rate above 1", func(c *Config) { c.Faults.DropRate = 1.5 }, true},
+		{"negative duplicate rate", func(c *Config) { c.Faults.DuplicateRate = -0.1 }, true},
+		{"negative jitter", func(c *Config) { c.Faults.Jitter = -time.Second }, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := DefaultConfig()
+			tt.mutate(&cfg)
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRun_DeliversAllEvents(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping simulation in short mode (mines PoW)")
+	}
+
+	cfg := DefaultConfig()
+	cfg.Clients = 2
+	cfg.Renoters = 3
+	cfg.Relays = 2
+	cfg.PathLength = 2
+	cfg.EventsPerClient = 2
+	cfg.Pattern = PatternBurst
+	cfg.DeliveryTimeout = 20 * time.Second
+
+	report, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	t.Logf("simulation report:\n%s", report)
+
+	if report.Sent != 4 {
+		t.Errorf("Sent = %d, want 4", report.Sent)
+	}
+	if report.Lost != 0 {
+		t.Errorf("Lost = %d, want 0 on a fault-free network", report.Lost)
+	}
+	if len(report.Latencies) != report.Delivered {
+		t.Errorf("latencies = %d, want one per delivered event (%d)", len(report.Latencies), report.Delivered)
+	}
+}
+
+func TestRun_SurvivesDuplicatesAndReordering(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping simulation in short mode (mines PoW)")
+	}
+
+	cfg := DefaultConfig()
+	cfg.Clients = 2
+	cfg.EventsPerClient = 2
+	cfg.Pattern = PatternBurst
+	cfg.DeliveryTimeout = 20 * time.Second
+	cfg.Faults = Faults{DuplicateRate: 1, Jitter: 300 * time.Millisecond}
+
+	report, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	t.Logf("simulation report:\n%s", report)
+
+	if report.Lost != 0 {
+		t.Errorf("Lost = %d, want 0 when relays only duplicate and reorder", report.Lost)
+	}
+	if report.Faults.Duplicated == 0 || report.Faults.Delayed == 0 {
+		t.Errorf("Faults = %+v, want duplicated and delayed deliveries", report.Faults)
+	}
+	// Every hop receives each container at least twice, and handles it at most once (the last
+	// outcomes may be counted after the report)
+	if handled := report.Outcomes.Forwarded + report.Outcomes.PublishedFinal; handled > int64(report.Sent*cfg.PathLength) {
+		t.Errorf("Outcomes = %+v, want each of the %d containers handled at most once", report.Outcomes, report.Sent*cfg.PathLength)
+	}
+}
+
+func TestRun_CountsDrops(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping simulation in short mode (mines PoW)")
+	}
+
+	cfg := DefaultConfig()
+	cfg.Clients = 1
+	cfg.EventsPerClient = 2
+	cfg.Pattern = PatternBurst
+	cfg.DeliveryTimeout = 3 * time.Second
+	cfg.Faults = Faults{DropRate: 1}
+
+	report, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if report.SubmitErrors != 0 {
+		t.Errorf("SubmitErrors = %d, want 0: relays dropping deliveries still accept the containers", report.SubmitErrors)
+	}
+	if report.Delivered != 0 || report.Lost != report.Sent {
+		t.Errorf("Delivered = %d, Lost = %d, want every event lost", report.Delivered, report.Lost)
+	}
+	if report.Faults.Dropped == 0 {
+		t.Errorf("Faults.Dropped = 0, want drops counted")
+	}
+}
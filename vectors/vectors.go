@@ -0,0 +1,314 @@
+// Package vectors defines the Renoter interop test vectors and checks them.
+//
+// A vector file is plain JSON so implementations in other languages can both run the canonical
+// vectors in testdata/ and hand theirs to this package. It has three sections:
+//
+//   - sizes: NIP-44 ciphertext lengths for the plaintext sizes the protocol produces
+//   - padding: events and the exact padding tag needed to reach a target size
+//   - wraps: an original event, the secret keys of every Renoter on its path, and a 29001
+//     container some implementation built for it
+//
+// Sizes and padding are deterministic and checked against this implementation. A wrap is checked
+// by opening its container hop by hop the way every Renoter does, with nothing but NIP-44 and
+// the keys in the vector, so containers built by any implementation can be validated.
+package vectors
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/bits"
+	"os"
+
+	"github.com/girino/renoter/internal/compact"
+	"github.com/girino/renoter/internal/config"
+	"github.com/girino/renoter/internal/padding"
+	"github.com/girino/renoter/internal/schema"
+	"github.com/nbd-wtf/go-nostr"
+	"github.com/nbd-wtf/go-nostr/nip13"
+	"github.com/nbd-wtf/go-nostr/nip44"
+)
+
+// File is a set of test vectors.
+type File struct {
+	// Which implementation produced the wraps, for humans reading the file
+	Producer string `json:"producer,omitempty"`
+	// Protocol version the wraps were built for (see config.ProtocolVersion)
+	ProtocolVersion int                     `json:"protocol_version"`
+	Sizes           []SizeVector            `json:"sizes"`
+	Padding         []padding.PaddingVector `json:"padding"`
+	Wraps           []WrapVector            `json:"wraps"`
+}
+
+// SizeVector pins the length of a NIP-44 payload for a plaintext length.
+type SizeVector struct {
+	Name          string `json:"name"`
+	PlaintextLen  int    `json:"plaintext_len"`
+	CiphertextLen int    `json:"ciphertext_len"`
+}
+
+// Hop is a Renoter on the path of a wrap vector. Its secret key is published so the container
+// can be opened by anyone running the vector.
+type Hop struct {
+	SecretKey string `json:"secret_key"`
+	PubKey    string `json:"pubkey"`
+}
+
+// WrapVector is an original event wrapped for a path of Renoters.
+type WrapVector struct {
+	Name string `json:"name"`
+	// The signed event the exit publishes
+	Event *nostr.Event `json:"event"`
+	// The Renoters in the order the container reaches them; the last is the exit
+	Path []Hop `json:"path"`
+	// Size in bytes of the padded 29000 inside the container, one of the size buckets
+	Bucket int `json:"bucket"`
+	// Whether every 29000 holds the event inside it in the compact encoding instead of JSON
+	Compact bool `json:"compact"`
+	// Minimum PoW difficulty of every 29000 layer (0 = not checked)
+	LayerPoW int `json:"layer_pow"`
+	// The 29001 container addressed to the first hop
+	Container *nostr.Event `json:"container"`
+}
+
+// Load reads a vector file.
+func Load(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read vectors: %w", err)
+	}
+	var file File
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse vectors: %w", err)
+	}
+	return &file, nil
+}
+
+// Save writes the vectors to path as indented JSON.
+func (f *File) Save(path string) error {
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize vectors: %w", err)
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write vectors: %w", err)
+	}
+	return nil
+}
+
+// Verify checks every vector in the file, returning all failures joined.
+func (f *File) Verify() error {
+	var errs []error
+	for _, v := range f.Sizes {
+		if err := v.Verify(); err != nil {
+			errs = append(errs, fmt.Errorf("size %q: %w", v.Name, err))
+		}
+	}
+	for _, v := range f.Padding {
+		if err := VerifyPadding(v); err != nil {
+			errs = append(errs, fmt.Errorf("padding %q: %w", v.Name, err))
+		}
+	}
+	for _, v := range f.Wraps {
+		if err := v.Verify(); err != nil {
+			errs = append(errs, fmt.Errorf("wrap %q: %w", v.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// CiphertextSize returns the length of the base64 NIP-44 v2 payload of a plaintextLen-byte
+// plaintext: version byte, nonce, length prefix, padded plaintext and MAC.
+func CiphertextSize(plaintextLen int) int {
+	return base64.StdEncoding.EncodedLen(1 + 32 + 2 + paddedLen(plaintextLen) + 32)
+}
+
+// paddedLen is the NIP-44 v2 padding of a plaintext length.
+func paddedLen(n int) int {
+	if n <= 32 {
+		return 32
+	}
+	nextPower := 1 << bits.Len(uint(n-1))
+	chunk := 32
+	if nextPower > 256 {
+		chunk = nextPower / 8
+	}
+	return chunk * ((n-1)/chunk + 1)
+}
+
+// Verify checks the ciphertext length against CiphertextSize and an actual encryption.
+func (v SizeVector) Verify() error {
+	if got := CiphertextSize(v.PlaintextLen); got != v.CiphertextLen {
+		return fmt.Errorf("ciphertext size = %d, want %d", got, v.CiphertextLen)
+	}
+	var key [32]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		return fmt.Errorf("failed to generate key: %w", err)
+	}
+	ciphertext, err := nip44.Encrypt(string(make([]byte, v.PlaintextLen)), key)
+	if err != nil {
+		return fmt.Errorf("failed to encrypt: %w", err)
+	}
+	if len(ciphertext) != v.CiphertextLen {
+		return fmt.Errorf("encrypted length = %d, want %d", len(ciphertext), v.CiphertextLen)
+	}
+	return nil
+}
+
+// VerifyPadding checks the padding this implementation adds to the vector's event.
+func VerifyPadding(v padding.PaddingVector) error {
+	event, err := v.Event()
+	if err != nil {
+		return err
+	}
+	tagBaseSize, err := padding.TagBaseSize(event)
+	if err != nil {
+		return fmt.Errorf("failed to measure tags: %w", err)
+	}
+	if tagBaseSize != v.TagBaseSize {
+		return fmt.Errorf("tag base size = %d, want %d", tagBaseSize, v.TagBaseSize)
+	}
+	padded, err := padding.PadEventToExactSize(event, v.Target)
+	if (err != nil) != v.WantErr {
+		return fmt.Errorf("padding error = %v, want error %v", err, v.WantErr)
+	}
+	if v.WantErr {
+		return nil
+	}
+	tag := padded.Tags[len(padded.Tags)-1]
+	if tag[0] != padding.TagName || len(tag[1]) != v.PaddingLen {
+		return fmt.Errorf("padding length = %d, want %d", len(tag[1]), v.PaddingLen)
+	}
+	return nil
+}
+
+// Verify opens the container the way each Renoter on the path would and checks that the exit
+// ends up with the original event:
+//
+//  1. The container is a signed 29001 tagged "p" with the first hop only, whose content decrypts
+//     with that hop's key to exactly Bucket bytes of JSON: the padded 29000 for the first hop.
+//  2. Every 29000, once its padding tags are removed, is signed, tagged "p" with its hop only and
+//     carries LayerPoW bits of work. Its content decrypts with the hop's key to the event
+//     for the next hop, compact when Compact is set and JSON otherwise.
+//  3. The event inside the exit's layer is the original event, signature included.
+//
+// Every NIP-44 payload must have the length CiphertextSize gives for its plaintext. Wrappers must
+// follow the schema of internal/schema, which Renoters enforce: only the tags of the protocol,
+// with sealed values for anything a hop reads.
+func (v WrapVector) Verify() error {
+	if len(v.Path) == 0 {
+		return fmt.Errorf("path is empty")
+	}
+	if v.Event == nil || v.Container == nil {
+		return fmt.Errorf("event and container are required")
+	}
+	for i, hop := range v.Path {
+		pubkey, err := nostr.GetPublicKey(hop.SecretKey)
+		if err != nil || pubkey != hop.PubKey {
+			return fmt.Errorf("hop %d: secret key does not match pubkey %s", i, hop.PubKey)
+		}
+	}
+
+	container := v.Container
+	if err := checkEvent(container, config.StandardizedWrapperKind, v.Path[0].PubKey); err != nil {
+		return fmt.Errorf("container: %w", err)
+	}
+	plaintext, err := open(container, v.Path[0])
+	if err != nil {
+		return fmt.Errorf("container: %w", err)
+	}
+	if len(plaintext) != v.Bucket {
+		return fmt.Errorf("container holds %d bytes, want bucket %d", len(plaintext), v.Bucket)
+	}
+	var layer nostr.Event
+	if err := json.Unmarshal(plaintext, &layer); err != nil {
+		return fmt.Errorf("container: padded 29000 is not JSON: %w", err)
+	}
+
+	current := &layer
+	for i, hop := range v.Path {
+		current.Tags = padding.StripPadding(current.Tags)
+		if err := checkEvent(current, config.WrapperEventKind, hop.PubKey); err != nil {
+			return fmt.Errorf("layer %d: %w", i, err)
+		}
+		if v.LayerPoW > 0 {
+			if err := nip13.Check(current.ID, v.LayerPoW); err != nil {
+				return fmt.Errorf("layer %d: %w", i, err)
+			}
+		}
+		plaintext, err := open(current, hop)
+		if err != nil {
+			return fmt.Errorf("layer %d: %w", i, err)
+		}
+		if compact.Is(plaintext) != v.Compact {
+			return fmt.Errorf("layer %d: compact encoding = %v, want %v", i, compact.Is(plaintext), v.Compact)
+		}
+		inner, err := decode(plaintext)
+		if err != nil {
+			return fmt.Errorf("layer %d: failed to decode inner event: %w", i, err)
+		}
+		current = inner
+	}
+
+	if current.ID != v.Event.ID || current.Sig != v.Event.Sig {
+		return fmt.Errorf("exit got event %s, want %s", current.ID, v.Event.ID)
+	}
+	if ok, err := current.CheckSignature(); err != nil || !ok {
+		return fmt.Errorf("exit got an event with an invalid signature")
+	}
+	return nil
+}
+
+// checkEvent checks the kind, ID, signature, schema and "p" tag of a wrapper.
+func checkEvent(event *nostr.Event, kind int, recipient string) error {
+	if event.Kind != kind {
+		return fmt.Errorf("kind = %d, want %d", event.Kind, kind)
+	}
+	if !event.CheckID() {
+		return fmt.Errorf("ID does not match the event")
+	}
+	if ok, err := event.CheckSignature(); err != nil || !ok {
+		return fmt.Errorf("invalid signature")
+	}
+	checkSchema := schema.CheckLayer
+	if kind == config.StandardizedWrapperKind {
+		checkSchema = schema.CheckContainer
+	}
+	if err := checkSchema(event); err != nil {
+		return err
+	}
+	if pubkey, _ := config.RoutingPubkey(event); pubkey != recipient {
+		return fmt.Errorf("not tagged \"p\" with %s", recipient)
+	}
+	return nil
+}
+
+// open decrypts a wrapper with the hop's key, checking the payload length.
+func open(event *nostr.Event, hop Hop) ([]byte, error) {
+	conversationKey, err := nip44.GenerateConversationKey(event.PubKey, hop.SecretKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate conversation key: %w", err)
+	}
+	plaintext, err := nip44.Decrypt(event.Content, conversationKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt: %w", err)
+	}
+	if want := CiphertextSize(len(plaintext)); len(event.Content) != want {
+		return nil, fmt.Errorf("ciphertext is %d bytes, want %d", len(event.Content), want)
+	}
+	return []byte(plaintext), nil
+}
+
+// decode parses the plaintext of a 29000 layer, compact or JSON.
+func decode(plaintext []byte) (*nostr.Event, error) {
+	if compact.Is(plaintext) {
+		return compact.Decode(plaintext)
+	}
+	var event nostr.Event
+	if err := json.Unmarshal(plaintext, &event); err != nil {
+		return nil, err
+	}
+	return &event, nil
+}
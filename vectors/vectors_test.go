@@ -0,0 +1,257 @@
+package vectors
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/girino/renoter/internal/config"
+	"github.com/girino/renoter/internal/padding"
+	"github.com/girino/renoter/pkg/client"
+	"github.com/nbd-wtf/go-nostr"
+)
+
+const canonicalFile = "testdata/vectors.json"
+
+// canonicalWrap describes a wrap vector of the canonical file, built by TestWrap_GoImplementation.
+type canonicalWrap struct {
+	name    string
+	author  string
+	content string
+	tags    nostr.Tags
+	hops    []string
+	buckets []int
+	compact bool
+}
+
+var canonicalWraps = []canonicalWrap{
+	{
+		name:    "single hop",
+		author:  "0000000000000000000000000000000000000000000000000000000000000001",
+		content: "Hello from the vectors",
+		hops:    []string{"0000000000000000000000000000000000000000000000000000000000000011"},
+	},
+	{
+		name:    "three hops",
+		author:  "fcd2a8dcfc0b2ba0a8c35b1e25ed4c4d5e1bc6d8a9f1d08f0a5a28c7a1c7d3b2",
+		content: "Reply with \"quotes\" and unicode éè and <html>",
+		tags:    nostr.Tags{{"p", "79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"}, {"t", "renoter"}},
+		hops: []string{
+			"0000000000000000000000000000000000000000000000000000000000000011",
+			"0000000000000000000000000000000000000000000000000000000000000012",
+			"0000000000000000000000000000000000000000000000000000000000000013",
+		},
+	},
+	{
+		name:    "three hops, compact layers",
+		author:  "fcd2a8dcfc0b2ba0a8c35b1e25ed4c4d5e1bc6d8a9f1d08f0a5a28c7a1c7d3b2",
+		content: "Compact layers",
+		hops: []string{
+			"0000000000000000000000000000000000000000000000000000000000000011",
+			"0000000000000000000000000000000000000000000000000000000000000012",
+			"0000000000000000000000000000000000000000000000000000000000000013",
+		},
+		compact: true,
+	},
+	{
+		name:    "two hops, size buckets",
+		author:  "0000000000000000000000000000000000000000000000000000000000000001",
+		content: "Small enough for the smallest bucket",
+		hops: []string{
+			"0000000000000000000000000000000000000000000000000000000000000012",
+			"0000000000000000000000000000000000000000000000000000000000000011",
+		},
+		buckets: []int{4096, 8192},
+	},
+}
+
+// canonicalSizes are the plaintext lengths of the sizes section: NIP-44 padding boundaries and
+// the size buckets.
+var canonicalSizes = []int{1, 32, 33, 256, 257, 1024, 4096, 8192, 16384, 32768, config.MaxStandardizedSize}
+
+func TestVectors(t *testing.T) {
+	runFile(t, canonicalFile)
+}
+
+// TestThirdPartyVectors runs the vector files listed in RENOTER_VECTORS, separated like PATH,
+// such as the output of another implementation.
+func TestThirdPartyVectors(t *testing.T) {
+	paths := os.Getenv("RENOTER_VECTORS")
+	if paths == "" {
+		t.Skip("RENOTER_VECTORS not set")
+	}
+	for _, path := range filepath.SplitList(paths) {
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			runFile(t, path)
+		})
+	}
+}
+
+func runFile(t *testing.T, path string) {
+	t.Helper()
+	file, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if len(file.Sizes)+len(file.Padding)+len(file.Wraps) == 0 {
+		t.Fatalf("%s holds no vectors", path)
+	}
+	for _, v := range file.Sizes {
+		t.Run("size/"+v.Name, func(t *testing.T) {
+			if err := v.Verify(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+	for _, v := range file.Padding {
+		t.Run("padding/"+v.Name, func(t *testing.T) {
+			if err := VerifyPadding(v); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+	for _, v := range file.Wraps {
+		t.Run("wrap/"+v.Name, func(t *testing.T) {
+			if err := v.Verify(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+// TestWrap_GoImplementation wraps the canonical events with this implementation and checks the
+// containers against the vectors. With RENOTER_WRITE_VECTORS set it writes the canonical file
+// with the new containers there, to regenerate testdata or to hand to other implementations.
+func TestWrap_GoImplementation(t *testing.T) {
+	golden, err := padding.LoadGoldenVectors("../internal/padding/testdata/golden.json")
+	if err != nil {
+		t.Fatalf("LoadGoldenVectors() error = %v", err)
+	}
+	file := &File{
+		Producer:        "renoter (Go)",
+		ProtocolVersion: config.ProtocolVersion,
+		Padding:         golden.Padding,
+	}
+	for _, n := range canonicalSizes {
+		file.Sizes = append(file.Sizes, SizeVector{Name: sizeName(n), PlaintextLen: n, CiphertextLen: CiphertextSize(n)})
+	}
+
+	for _, c := range canonicalWraps {
+		t.Run(c.name, func(t *testing.T) {
+			v := wrapCanonical(t, c)
+			if err := v.Verify(); err != nil {
+				t.Fatalf("Verify() error = %v", err)
+			}
+			file.Wraps = append(file.Wraps, v)
+		})
+	}
+
+	if err := file.Verify(); err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if path := os.Getenv("RENOTER_WRITE_VECTORS"); path != "" {
+		if err := file.Save(path); err != nil {
+			t.Fatalf("Save() error = %v", err)
+		}
+	}
+}
+
+func wrapCanonical(t *testing.T, c canonicalWrap) WrapVector {
+	t.Helper()
+	event := &nostr.Event{Kind: 1, Content: c.content, CreatedAt: 1700000000, Tags: c.tags}
+	if event.Tags == nil {
+		event.Tags = nostr.Tags{}
+	}
+	if err := event.Sign(c.author); err != nil {
+		t.Fatalf("Sign() error = %v", err)
+	}
+
+	v := WrapVector{Name: c.name, Event: event, Compact: c.compact, LayerPoW: config.PoWDifficulty}
+	var pubkeys [][]byte
+	for _, sk := range c.hops {
+		pubkey, err := nostr.GetPublicKey(sk)
+		if err != nil {
+			t.Fatalf("GetPublicKey() error = %v", err)
+		}
+		raw, _ := hex.DecodeString(pubkey)
+		pubkeys = append(pubkeys, raw)
+		v.Path = append(v.Path, Hop{SecretKey: sk, PubKey: pubkey})
+	}
+
+	opts := client.DefaultOptions()
+	opts.Limits.Buckets = c.buckets
+	opts.CompactLayers = c.compact
+	container, err := client.WrapEvent(context.Background(), event, client.NewPath(pubkeys...), client.WrapOptions{Options: opts})
+	if err != nil {
+		t.Fatalf("WrapEvent() error = %v", err)
+	}
+	v.Container = container
+
+	plaintext, err := open(container, v.Path[0])
+	if err != nil {
+		t.Fatalf("open() error = %v", err)
+	}
+	v.Bucket = len(plaintext)
+	if !slices.Contains(opts.Limits.Sizes(), v.Bucket) {
+		t.Fatalf("container holds %d bytes, not one of the sizes %v", v.Bucket, opts.Limits.Sizes())
+	}
+	return v
+}
+
+func sizeName(n int) string {
+	if n%1024 == 0 {
+		return fmt.Sprintf("%dKB plaintext", n/1024)
+	}
+	return fmt.Sprintf("%d byte plaintext", n)
+}
+
+func TestWrapVector_Rejects(t *testing.T) {
+	file, err := Load(canonicalFile)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	base := file.Wraps[1]
+	other, _ := nostr.GetPublicKey("0000000000000000000000000000000000000000000000000000000000000021")
+
+	tests := []struct {
+		name   string
+		modify func(v *WrapVector)
+	}{
+		{"wrong bucket", func(v *WrapVector) { v.Bucket = 4096 }},
+		{"wrong exit event", func(v *WrapVector) { v.Event = file.Wraps[0].Event }},
+		{"hops out of order", func(v *WrapVector) { v.Path[1], v.Path[2] = v.Path[2], v.Path[1] }},
+		{"wrong first hop", func(v *WrapVector) {
+			v.Path[0] = Hop{SecretKey: "0000000000000000000000000000000000000000000000000000000000000021", PubKey: other}
+		}},
+		{"compact expected", func(v *WrapVector) { v.Compact = true }},
+		{"tampered container", func(v *WrapVector) {
+			v.Container.Content = v.Container.Content[:100] + "AAAA" + v.Container.Content[104:]
+		}},
+		{"unsigned container", func(v *WrapVector) { v.Container.Sig = "" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := base
+			v.Path = slices.Clone(base.Path)
+			container := *base.Container
+			v.Container = &container
+			tt.modify(&v)
+			if err := v.Verify(); err == nil {
+				t.Error("Verify() succeeded, want an error")
+			}
+		})
+	}
+}
+
+func TestCiphertextSize(t *testing.T) {
+	for n := 1; n <= config.MaxStandardizedSize; n += 97 {
+		v := SizeVector{PlaintextLen: n, CiphertextLen: CiphertextSize(n)}
+		if err := v.Verify(); err != nil {
+			t.Fatalf("%d bytes: %v", n, err)
+		}
+	}
+}